@@ -0,0 +1,168 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// writeBatchSize is the number of indexes `AddFiles` builds at once and uploads
+// in a single round trip to the search server.
+const writeBatchSize = 64
+
+// AddFiles indexes the files in `directory` at `pathnames`, as `AddFile` does
+// each, and writes their indexes to the server in batches of `writeBatchSize`,
+// one round trip each, so that indexing many small files does not pay for a
+// round trip per file.  The indexes of a batch are built concurrently, on as
+// many workers as there are CPUs, within the memory budget set by
+// `SetMemoryBudget`.  Returns the errors of the files that could not be added,
+// keyed by pathname, along with an error if `directory` is not a directory of
+// the client or its number of documents could not be padded.  The files not
+// started yet once `ctx` is done are reported with the error of `ctx`.
+func (c *Client) AddFiles(ctx context.Context, directory string, pathnames []string) (map[string]error, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	written := false
+	for start := 0; start < len(pathnames); start += writeBatchSize {
+		end := start + writeBatchSize
+		if end > len(pathnames) {
+			end = len(pathnames)
+		}
+		if err := ctx.Err(); err != nil {
+			for _, pathname := range pathnames[start:] {
+				failed[pathname] = err
+			}
+			break
+		}
+
+		var batch []pendingWrite
+		var batchPaths []string
+		writes, errs := c.buildWrites(ctx, dirInfo, pathnames[start:end])
+		for i, pathname := range pathnames[start:end] {
+			if errs[i] != nil {
+				failed[pathname] = errs[i]
+			} else if dirInfo.padding.isBatched() {
+				dirInfo.padding.addWrite(writes[i])
+			} else {
+				batch = append(batch, writes[i])
+				batchPaths = append(batchPaths, pathname)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		for i, err := range c.writeIndexes(ctx, dirInfo, batch) {
+			if err != nil {
+				failed[batchPaths[i]] = err
+			} else {
+				written = true
+			}
+		}
+	}
+	if !written {
+		return failed, nil
+	}
+	return failed, c.padDocumentCount(ctx, dirInfo)
+}
+
+// buildWrites builds the uploads of the indexes of the files at `pathnames` in
+// the directory of `dirInfo` concurrently, on as many workers as there are
+// CPUs, and returns them along with the error of each file, in the order of
+// `pathnames`.
+func (c *Client) buildWrites(ctx context.Context, dirInfo *DirectoryInfo, pathnames []string) ([]pendingWrite, []error) {
+	writes := make([]pendingWrite, len(pathnames))
+	errs := make([]error, len(pathnames))
+	workers := runtime.NumCPU()
+	if workers > len(pathnames) {
+		workers = len(pathnames)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				writes[i], errs[i] = c.buildWrite(ctx, dirInfo, pathnames[i])
+			}
+		}()
+	}
+	for i := range pathnames {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return writes, errs
+}
+
+// writeIndexes uploads the indexes of `writes` in a single round trip, as
+// `writeIndex` does each, and returns the error of each of them.  The round trip
+// is retried as set by `SetUploadRetries`.  While operations are queued in the
+// offline queue, or once the search server turns out to be unreachable, the
+// writes go through the offline queue one by one instead, so that the
+// operations reach the server in order.
+func (c *Client) writeIndexes(ctx context.Context, dirInfo *DirectoryInfo, writes []pendingWrite) []error {
+	errs := make([]error, len(writes))
+	writeEach := func() []error {
+		for i, write := range writes {
+			errs[i] = c.writeIndex(ctx, dirInfo, write)
+		}
+		return errs
+	}
+	if queued, _ := c.GetOfflineQueueLength(dirInfo.absDir); queued > 0 || c.isOffline() {
+		return writeEach()
+	}
+
+	arg := sserver1.WriteIndexesArg{TlfID: dirInfo.tlfID, Writes: make([]sserver1.IndexWrite, len(writes))}
+	size := 0
+	for i, write := range writes {
+		arg.Writes[i] = sserver1.IndexWrite{SecureIndex: write.arg.SecureIndex, DocID: write.arg.DocID, BaseRevision: dirInfo.revisions.base(write.arg.DocID)}
+		size += len(write.arg.SecureIndex)
+	}
+	var results []sserver1.WriteResult
+	err := c.withRetries(ctx, func() (err error) {
+		c.waitUpload(size)
+		for range writes {
+			c.waitBackfill(ctx, dirInfo)
+		}
+		results, err = c.searchCli.WriteIndexes(ctx, arg)
+		return err
+	})
+	if err != nil && isConnectionError(err) && c.getOfflineQueue(dirInfo.absDir) != nil {
+		return writeEach()
+	} else if err == nil && len(results) != len(writes) {
+		err = errors.New("unexpected number of write results")
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	defer c.invalidateResults(dirInfo)
+	for i, write := range writes {
+		docID := write.arg.DocID
+		dirInfo.revisions.written(docID, results[i])
+		c.recordUpload(dirInfo, len(write.arg.SecureIndex))
+		c.metrics.fileIndexed(dirInfo.absDir)
+		if len(write.summary) > 0 {
+			if errs[i] = c.mergeSummary(ctx, dirInfo, docID, write.summary); errs[i] != nil {
+				continue
+			}
+		}
+		errs[i] = c.writeWordSetDigest(dirInfo.absDir, docID, write.digest, write.key)
+	}
+	return errs
+}
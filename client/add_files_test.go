@@ -0,0 +1,76 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// batchCountingServerClient counts the single and batched index writes sent to
+// an in-memory server.
+type batchCountingServerClient struct {
+	*memoryServerClient
+	writes  int // The number of `WriteIndex` calls.
+	batches int // The number of `WriteIndexes` calls.
+}
+
+func (c *batchCountingServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	c.writes++
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+func (c *batchCountingServerClient) WriteIndexes(ctx context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteResult, error) {
+	c.batches++
+	return c.memoryServerClient.WriteIndexes(ctx, arg)
+}
+
+// TestAddFiles tests the `AddFiles` function.  Checks that the indexes are
+// uploaded in batches of `writeBatchSize` without any single write, that all
+// the files are then found, and that the files that cannot be indexed are
+// reported without failing the others.
+func TestAddFiles(t *testing.T) {
+	server := &batchCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	var pathnames []string
+	for i := 0; i < writeBatchSize+10; i++ {
+		pathname := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(pathname, []byte("batched word"+strconv.Itoa(i)), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		pathnames = append(pathnames, pathname)
+	}
+	missing := filepath.Join(dir, "missing")
+	failed, err := cli.AddFiles(context.Background(), dir, append(pathnames, missing))
+	if err != nil {
+		t.Fatalf("error when adding the files: %s", err)
+	}
+	if len(failed) != 1 || failed[missing] == nil {
+		t.Fatalf("incorrect failed files: %v", failed)
+	}
+	if server.writes != 0 || server.batches != 2 {
+		t.Fatalf("incorrect writes: %d single, %d batches", server.writes, server.batches)
+	}
+
+	results, err := cli.SearchWord(context.Background(), dir, "batched")
+	if err != nil {
+		t.Fatalf("error when searching: %s", err)
+	}
+	if len(results) != len(pathnames) {
+		t.Fatalf("incorrect number of results: %d", len(results))
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "word7"); err != nil || !reflect.DeepEqual(results, []string{pathnames[7]}) {
+		t.Fatalf("incorrect results: %v, %v", results, err)
+	}
+}
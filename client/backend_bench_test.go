@@ -0,0 +1,144 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+var benchNumIndexes = flag.Int("bench_indexes", 1000, "the number of indexes the index stores are populated with in the benchmarks")
+
+// benchBackends are the index stores of the test server compared by the
+// benchmarks.  They are not the storage of the search server, which is not part
+// of this tree; the one of the prototype server is benchmarked along with it.
+var benchBackends = []struct {
+	name     string
+	newStore func(b *testing.B) (indexStore, func())
+}{
+	{"memory", func(b *testing.B) (indexStore, func()) {
+		return make(memIndexStore), func() {}
+	}},
+	{"filesystem", func(b *testing.B) (indexStore, func()) {
+		dir, err := ioutil.TempDir("", "BenchmarkFsStore")
+		if err != nil {
+			b.Fatalf("error when creating the store directory: %s", err)
+		}
+		return fsIndexStore(dir), func() { os.RemoveAll(dir) }
+	}},
+}
+
+// benchTlfID is the TLF the benchmarks store their indexes in.
+const benchTlfID = sserver1.FolderID("benchTLFID")
+
+// benchSetup registers a TLF on a server backed by `store`, and returns the
+// server, an indexer for the TLF and a few marshaled indexes to store.
+func benchSetup(b *testing.B, store indexStore) (*memoryServerClient, *libsearch.SecureIndexBuilder, [][]byte) {
+	server := newMemoryServerClientWithStore(store)
+	tlfInfo, err := server.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: benchTlfID, LenSalt: 32, FpRate: 0.000001, NumUniqWords: 1000})
+	if err != nil {
+		b.Fatalf("error when registering the TLF: %s", err)
+	}
+	masterSecret := make([]byte, 64)
+	if _, err := rand.Read(masterSecret); err != nil {
+		b.Fatalf("error when generating the master secret: %s", err)
+	}
+	indexer := libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
+
+	dir, err := ioutil.TempDir("", "BenchmarkBackends")
+	if err != nil {
+		b.Fatalf("error when creating the document directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	secIndexes := make([][]byte, 16)
+	for i := range secIndexes {
+		pathname := filepath.Join(dir, "doc"+strconv.Itoa(i))
+		content := "common benchmark document number" + strconv.Itoa(i)
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			b.Fatalf("error when writing the document: %s", err)
+		}
+		file, err := os.Open(pathname)
+		if err != nil {
+			b.Fatalf("error when opening the document: %s", err)
+		}
+		secIndex, err := indexer.BuildSecureIndex(file, int64(len(content)))
+		file.Close()
+		if err != nil {
+			b.Fatalf("error when building the index: %s", err)
+		}
+		if secIndexes[i], err = secIndex.MarshalBinary(); err != nil {
+			b.Fatalf("error when marshaling the index: %s", err)
+		}
+	}
+	return server, indexer, secIndexes
+}
+
+// benchDocID returns the document ID of the `i`-th index of the benchmarks.
+func benchDocID(b *testing.B, i int) sserver1.DocumentID {
+	docID, err := libsearch.PathnameToDocID(1, "file"+strconv.Itoa(i), libsearch.PathnameKeyType{})
+	if err != nil {
+		b.Fatalf("error when computing the document ID: %s", err)
+	}
+	return docID
+}
+
+// populate writes `numIndexes` indexes to `server`, cycling through
+// `secIndexes`.
+func populate(b *testing.B, server *memoryServerClient, secIndexes [][]byte, numIndexes int) {
+	for i := 0; i < numIndexes; i++ {
+		if _, err := server.WriteIndex(context.Background(), sserver1.WriteIndexArg{TlfID: benchTlfID, SecureIndex: secIndexes[i%len(secIndexes)], DocID: benchDocID(b, i)}); err != nil {
+			b.Fatalf("error when writing the index: %s", err)
+		}
+	}
+}
+
+// BenchmarkBackendWriteIndex measures the write throughput of each index
+// store.
+func BenchmarkBackendWriteIndex(b *testing.B) {
+	for _, backend := range benchBackends {
+		b.Run(backend.name, func(b *testing.B) {
+			store, cleanup := backend.newStore(b)
+			defer cleanup()
+			server, _, secIndexes := benchSetup(b, store)
+			b.SetBytes(int64(len(secIndexes[0])))
+			b.ResetTimer()
+			populate(b, server, secIndexes, b.N)
+		})
+	}
+}
+
+// BenchmarkBackendSearchScan measures the latency of a search scanning all the
+// indexes of a TLF, for each index store populated with `-bench_indexes`
+// indexes.
+func BenchmarkBackendSearchScan(b *testing.B) {
+	for _, backend := range benchBackends {
+		b.Run(backend.name, func(b *testing.B) {
+			store, cleanup := backend.newStore(b)
+			defer cleanup()
+			server, indexer, secIndexes := benchSetup(b, store)
+			populate(b, server, secIndexes, *benchNumIndexes)
+			trapdoors := map[string]sserver1.Trapdoor{"1": {Codeword: indexer.ComputeTrapdoors("common")}}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := server.SearchWord(context.Background(), sserver1.SearchWordArg{TlfID: benchTlfID, Trapdoors: trapdoors})
+				if err != nil {
+					b.Fatalf("error when searching: %s", err)
+				} else if len(result) != *benchNumIndexes {
+					b.Fatalf("incorrect number of results: expected %d actual %d", *benchNumIndexes, len(result))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// backfillSession is the backfill of a directory announced to the search
+// server, which grants it a budget of index writes per second.
+type backfillSession struct {
+	lock     sync.Mutex
+	active   bool            // Whether a backfill of the directory is in progress.
+	numFiles int             // The number of files announced.
+	throttle *uploadThrottle // The limit of the rate of the index writes, counted in writes.  No limit if nil.
+	renewAt  time.Time       // The time the grant is renewed at, halfway through its duration.
+}
+
+// SetBackfillThreshold sets the number of files from which a scan announces a
+// backfill to the search server before indexing them, e.g. on the first scan
+// of a directory.  The server grants a budget of index writes per second,
+// shared among the clients backfilling at the same time, and the index writes
+// of the directory are paced to it until the scan ends, so that many new
+// clients joining at once do not overwhelm a shared server.  The files are
+// indexed at the pace of the client alone if the server fails to grant a
+// budget.  A non-positive `numFiles` disables the backfills.  Should be called
+// before the scans are started.
+func (c *Client) SetBackfillThreshold(numFiles int) {
+	if numFiles < 0 {
+		numFiles = 0
+	}
+	c.backfillMin = numFiles
+}
+
+// applyGrant paces the index writes of `session` to `grant`.  Must be called
+// with the lock of `session` held.
+func (c *Client) applyGrant(session *backfillSession, grant sserver1.BackfillGrant) {
+	switch {
+	case grant.WritesPerSec <= 0:
+		session.throttle = nil
+	case session.throttle == nil || session.throttle.rate != int64(grant.WritesPerSec):
+		session.throttle = newUploadThrottle(c.clock, int64(grant.WritesPerSec))
+	}
+	session.renewAt = c.clock.Now().Add(time.Duration(grant.Ttl) * time.Millisecond / 2)
+}
+
+// beginBackfill announces the backfill of `numFiles` files of the directory of
+// `dirInfo` to the search server, and paces its index writes to the budget
+// granted.
+func (c *Client) beginBackfill(ctx context.Context, dirInfo *DirectoryInfo, numFiles int) error {
+	grant, err := c.searchCli.BeginBackfill(ctx, sserver1.BeginBackfillArg{TlfID: dirInfo.tlfID, Claimant: c.claimant, NumFiles: numFiles})
+	if err != nil {
+		return err
+	}
+	session := &dirInfo.backfill
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	session.active = true
+	session.numFiles = numFiles
+	c.applyGrant(session, grant)
+	return nil
+}
+
+// waitBackfill waits for the next index write of the directory of `dirInfo` to
+// fit in the budget of its backfill, if any, unless the client is shut down
+// meanwhile.  The grant is renewed once halfway through, keeping the current
+// one if the renewal fails.
+func (c *Client) waitBackfill(ctx context.Context, dirInfo *DirectoryInfo) {
+	session := &dirInfo.backfill
+	session.lock.Lock()
+	if !session.active {
+		session.lock.Unlock()
+		return
+	}
+	if !c.clock.Now().Before(session.renewAt) {
+		grant, err := c.searchCli.BeginBackfill(ctx, sserver1.BeginBackfillArg{TlfID: dirInfo.tlfID, Claimant: c.claimant, NumFiles: session.numFiles})
+		if err == nil {
+			c.applyGrant(session, grant)
+		}
+	}
+	throttle := session.throttle
+	session.lock.Unlock()
+	if throttle != nil {
+		throttle.wait(1, c.shutdownCh)
+	}
+}
+
+// endBackfill ends the backfill of the directory of `dirInfo`, releasing its
+// budget on the search server, which otherwise reclaims it once the grant
+// expires.
+func (c *Client) endBackfill(ctx context.Context, dirInfo *DirectoryInfo) error {
+	session := &dirInfo.backfill
+	session.lock.Lock()
+	session.active = false
+	session.throttle = nil
+	session.lock.Unlock()
+	return c.searchCli.EndBackfill(ctx, sserver1.EndBackfillArg{TlfID: dirInfo.tlfID, Claimant: c.claimant})
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// backfillRecordingServerClient records the backfills announced to an
+// in-memory server.
+type backfillRecordingServerClient struct {
+	*memoryServerClient
+	announced []int // The number of files of each backfill announced.
+}
+
+func (c *backfillRecordingServerClient) BeginBackfill(ctx context.Context, arg sserver1.BeginBackfillArg) (sserver1.BackfillGrant, error) {
+	c.announced = append(c.announced, arg.NumFiles)
+	return c.memoryServerClient.BeginBackfill(ctx, arg)
+}
+
+// TestBackfill tests the backfills announced by the scans.  Checks that the
+// budget of the server is shared among the clients backfilling, that a scan
+// with enough files announces a backfill and ends it, and that a smaller scan
+// does not.
+func TestBackfill(t *testing.T) {
+	server := &backfillRecordingServerClient{memoryServerClient: newMemoryServerClient()}
+	server.budget = 1000
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	cli.SetBackfillThreshold(3)
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+
+	if _, err := server.memoryServerClient.BeginBackfill(context.Background(), sserver1.BeginBackfillArg{TlfID: dirInfo.tlfID, Claimant: "other", NumFiles: 10}); err != nil {
+		t.Fatalf("error when announcing another backfill: %s", err)
+	}
+	if err := cli.beginBackfill(context.Background(), dirInfo, 5); err != nil {
+		t.Fatalf("error when announcing the backfill: %s", err)
+	}
+	if throttle := dirInfo.backfill.throttle; throttle == nil || throttle.rate != 500 {
+		t.Fatalf("budget not shared with the other backfill: %+v", throttle)
+	}
+	if err := cli.endBackfill(context.Background(), dirInfo); err != nil {
+		t.Fatalf("error when ending the backfill: %s", err)
+	}
+	server.announced = nil
+
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("backfilled"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	if len(server.announced) != 1 || server.announced[0] != 3 {
+		t.Fatalf("incorrect backfills announced: %v", server.announced)
+	}
+	if _, ok := server.backfills[backfill{dirInfo.tlfID, cli.claimant}]; ok || dirInfo.backfill.active {
+		t.Fatalf("backfill not ended by the scan")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "single"), []byte("not backfilled"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect second scan: %+v", report)
+	}
+	if len(server.announced) != 1 {
+		t.Fatalf("backfill announced for a single file: %v", server.announced)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// Change is a change of the index of a file made by any client of a directory,
+// as recorded in the journal of the search server.
+type Change struct {
+	Seq      int64               // The sequence number of the change.
+	Type     sserver1.ChangeType // Whether the index has been written, renamed or deleted.
+	Path     string              // The absolute path of the file.
+	OrigPath string              // The absolute path of the file before a rename.
+}
+
+// ChangeSet is a list of changes of the indexes of a directory.
+type ChangeSet struct {
+	Changes   []Change // The changes, oldest first.
+	LatestSeq int64    // The sequence number of the latest change, to pass to the next call.
+	Truncated bool     // Whether some of the changes requested are no longer in the journal.
+}
+
+// docIDToFilename decrypts `docID` into an absolute filename under the
+// directory of `dirInfo`.  Returns an empty filename for the dummy indexes.
+func (c *Client) docIDToFilename(dirInfo *DirectoryInfo, docID sserver1.DocumentID) (string, error) {
+	filenames, err := c.docIDsToFilenames(dirInfo, []sserver1.DocumentID{docID})
+	if err != nil || len(filenames) == 0 {
+		return "", err
+	}
+	return filenames[0], nil
+}
+
+// GetChanges returns the changes of the indexes of `directory` made by all the
+// clients after the change with the sequence number `sinceSeq`, so that
+// another device can catch up on the indexing without scanning the directory.
+// Pass 0 to get all the changes still in the journal, and the `LatestSeq` of
+// the result on the next call.  If the result is `Truncated`, the journal of
+// the search server no longer goes back to `sinceSeq`, and the directory
+// should be scanned instead.  The changes of the dummy indexes padding the
+// number of documents are left out.
+func (c *Client) GetChanges(ctx context.Context, directory string, sinceSeq int64) (ChangeSet, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+
+	serverChanges, err := c.searchCli.GetChanges(ctx, sserver1.GetChangesArg{TlfID: dirInfo.tlfID, SinceSeq: sinceSeq})
+	if err != nil {
+		return ChangeSet{}, err
+	}
+
+	changeSet := ChangeSet{LatestSeq: serverChanges.LatestSeq, Truncated: serverChanges.Truncated}
+	for _, serverChange := range serverChanges.Changes {
+		change := Change{Seq: serverChange.Seq, Type: serverChange.Type}
+		if change.Path, err = c.docIDToFilename(dirInfo, serverChange.DocID); err != nil {
+			return ChangeSet{}, err
+		} else if change.Path == "" {
+			continue
+		}
+		if serverChange.Type == sserver1.ChangeType_RENAME {
+			if change.OrigPath, err = c.docIDToFilename(dirInfo, serverChange.OrigDocID); err != nil {
+				return ChangeSet{}, err
+			}
+		}
+		changeSet.Changes = append(changeSet.Changes, change)
+	}
+	return changeSet, nil
+}
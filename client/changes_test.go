@@ -0,0 +1,78 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestGetChanges tests the `GetChanges` function.  Checks that the changes made
+// by one client are returned to another client of the same directory with the
+// decrypted paths, that only the changes after the sequence number passed are
+// returned, and that the changes dropped from the journal are reported.
+func TestGetChanges(t *testing.T) {
+	server := newMemoryServerClient()
+	client1, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client2, _ := startTestClientWithServer(t, dir, server)
+
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(dir, "file2")
+	if err := ioutil.WriteFile(file1, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client1.AddFile(context.Background(), dir, file1); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	if err := os.Rename(file1, file2); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	if err := client1.RenameFile(context.Background(), dir, file1, file2); err != nil {
+		t.Fatalf("error when renaming the file: %s", err)
+	}
+	if err := client1.DeleteFile(context.Background(), dir, file2); err != nil {
+		t.Fatalf("error when deleting the file: %s", err)
+	}
+
+	changeSet, err := client2.GetChanges(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatalf("error when getting the changes: %s", err)
+	}
+	expected := ChangeSet{
+		Changes: []Change{
+			{Seq: 1, Type: sserver1.ChangeType_WRITE, Path: file1},
+			{Seq: 2, Type: sserver1.ChangeType_RENAME, Path: file2, OrigPath: file1},
+			{Seq: 3, Type: sserver1.ChangeType_DELETE, Path: file2},
+		},
+		LatestSeq: 3,
+	}
+	if !reflect.DeepEqual(expected, changeSet) {
+		t.Fatalf("incorrect changes: expected %+v actual %+v", expected, changeSet)
+	}
+	if changeSet, err = client2.GetChanges(context.Background(), dir, 2); err != nil || !reflect.DeepEqual(expected.Changes[2:], changeSet.Changes) {
+		t.Fatalf("incorrect changes since 2: %+v, %v", changeSet, err)
+	}
+
+	server.journalLen = 2
+	if err := ioutil.WriteFile(file1, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client1.AddFile(context.Background(), dir, file1); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	if changeSet, err = client2.GetChanges(context.Background(), dir, 1); err != nil || !changeSet.Truncated || changeSet.LatestSeq != 4 {
+		t.Fatalf("dropped changes not reported: %+v, %v", changeSet, err)
+	}
+	if changeSet, err = client2.GetChanges(context.Background(), dir, 3); err != nil || changeSet.Truncated || len(changeSet.Changes) != 1 {
+		t.Fatalf("incorrect changes since 3: %+v, %v", changeSet, err)
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build chaos
+// +build chaos
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// errChaos is the error returned for the RPC failures injected by
+// `chaosServerClient`.
+var errChaos = errors.New("injected RPC failure")
+
+// chaosServerClient wraps a SearchServerInterface and injects failures, delays
+// and dropped connections into the RPCs, simulating an unreliable transport.
+type chaosServerClient struct {
+	inner    sserver1.SearchServerInterface // The wrapped server.
+	lock     sync.Mutex                     // Protects `rng`.
+	rng      *rand.Rand                     // The source of randomness of the injected failures.
+	failRate float64                        // The probability that a request is lost before reaching the server.
+	dropRate float64                        // The probability that a response is lost after the server has handled the request.
+	maxDelay time.Duration                  // The maximum delay added to each RPC.
+}
+
+// inject calls `call` with the failures and delays injected.
+func (c *chaosServerClient) inject(call func() error) error {
+	c.lock.Lock()
+	fail := c.rng.Float64() < c.failRate
+	drop := c.rng.Float64() < c.dropRate
+	delay := time.Duration(c.rng.Int63n(int64(c.maxDelay) + 1))
+	c.lock.Unlock()
+
+	time.Sleep(delay)
+	if fail {
+		return errChaos
+	}
+	if err := call(); err != nil {
+		return err
+	}
+	if drop {
+		return errChaos
+	}
+	return nil
+}
+
+func (c *chaosServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (res sserver1.WriteResult, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.WriteIndex(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) RenameIndex(ctx context.Context, arg sserver1.RenameIndexArg) error {
+	return c.inject(func() error { return c.inner.RenameIndex(ctx, arg) })
+}
+
+func (c *chaosServerClient) DeleteIndex(ctx context.Context, arg sserver1.DeleteIndexArg) error {
+	return c.inject(func() error { return c.inner.DeleteIndex(ctx, arg) })
+}
+
+func (c *chaosServerClient) GetKeyGens(ctx context.Context, tlfID sserver1.FolderID) (res []int, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.GetKeyGens(ctx, tlfID)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) SearchWord(ctx context.Context, arg sserver1.SearchWordArg) (res []sserver1.DocumentID, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.SearchWord(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) SearchWords(ctx context.Context, arg sserver1.SearchWordsArg) (res [][]sserver1.DocumentID, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.SearchWords(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) SearchConjunction(ctx context.Context, arg sserver1.SearchConjunctionArg) (res []sserver1.DocumentID, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.SearchConjunction(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) GetDocumentInfo(ctx context.Context, arg sserver1.GetDocumentInfoArg) (res []sserver1.DocumentInfo, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.GetDocumentInfo(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) GetChanges(ctx context.Context, arg sserver1.GetChangesArg) (res sserver1.ChangeSet, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.GetChanges(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) ClaimIndex(ctx context.Context, arg sserver1.ClaimIndexArg) (res bool, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.ClaimIndex(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) RegisterTlfIfNotExists(ctx context.Context, arg sserver1.RegisterTlfIfNotExistsArg) (res sserver1.TlfInfo, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.RegisterTlfIfNotExists(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) GetIndexStats(ctx context.Context, tlfID sserver1.FolderID) (res sserver1.TlfIndexStats, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.GetIndexStats(ctx, tlfID)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) SearchWordPage(ctx context.Context, arg sserver1.SearchWordPageArg) (res sserver1.SearchPage, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.SearchWordPage(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) MergeTlfSummary(ctx context.Context, arg sserver1.MergeTlfSummaryArg) error {
+	return c.inject(func() error {
+		return c.inner.MergeTlfSummary(ctx, arg)
+	})
+}
+
+func (c *chaosServerClient) GetTlfSummary(ctx context.Context, tlfID sserver1.FolderID) (res sserver1.TlfSummary, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.GetTlfSummary(ctx, tlfID)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) DropTlf(ctx context.Context, tlfID sserver1.FolderID) error {
+	return c.inject(func() error { return c.inner.DropTlf(ctx, tlfID) })
+}
+
+func (c *chaosServerClient) BeginBackfill(ctx context.Context, arg sserver1.BeginBackfillArg) (res sserver1.BackfillGrant, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.BeginBackfill(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+func (c *chaosServerClient) EndBackfill(ctx context.Context, arg sserver1.EndBackfillArg) error {
+	return c.inject(func() error { return c.inner.EndBackfill(ctx, arg) })
+}
+
+func (c *chaosServerClient) WriteIndexes(ctx context.Context, arg sserver1.WriteIndexesArg) (res []sserver1.WriteResult, err error) {
+	err = c.inject(func() (err error) {
+		res, err = c.inner.WriteIndexes(ctx, arg)
+		return err
+	})
+	return res, err
+}
+
+// retryOnChaos retries `op` until it succeeds, failing the test after too many
+// attempts.  Only the injected failures are retried.
+func retryOnChaos(t *testing.T, op func() error) {
+	for attempt := 0; attempt < 100; attempt++ {
+		err := op()
+		if err == nil {
+			return
+		} else if err != errChaos {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+	}
+	t.Errorf("operation still failing after 100 attempts")
+}
+
+// TestChaos indexes, renames, deletes and searches files concurrently through
+// a transport that randomly fails, delays and drops RPCs.  Checks that once all
+// the operations have eventually succeeded, the server holds exactly one index
+// per remaining file, none for the deleted or renamed-away files, and that the
+// searches return the expected files.
+func TestChaos(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("chaos seed: %d", seed)
+
+	server := newMemoryServerClient()
+	chaos := &chaosServerClient{
+		inner:    server,
+		rng:      rand.New(rand.NewSource(seed)),
+		failRate: 0.2,
+		dropRate: 0.2,
+		maxDelay: 2 * time.Millisecond,
+	}
+
+	dir, err := ioutil.TempDir("", "TestChaos")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+
+	var cli *Client
+	retryOnChaos(t, func() (err error) {
+		cli, err = createClientWithClient(context.Background(), chaos, []string{dir}, 64, 32, 0.0001, 1000, false, sserver1.IndexType_BLOOM)
+		return err
+	})
+	if cli == nil {
+		t.Fatalf("cannot create the client")
+	}
+
+	numFiles := 60
+	filenames := make([]string, numFiles)
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, "chaosFile"+strconv.Itoa(i))
+		content := "common unique" + strconv.Itoa(i)
+		if err := ioutil.WriteFile(filenames[i], []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < numFiles; i += 4 {
+				filename := filenames[i]
+				retryOnChaos(t, func() error { return cli.AddFile(context.Background(), dir, filename) })
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	// Renames the first 10 files and deletes the next 10.
+	expected := make(map[string]bool)
+	for i, filename := range filenames {
+		switch {
+		case i < 10:
+			renamed := filepath.Join(dir, "renamedChaosFile"+strconv.Itoa(i))
+			if err := os.Rename(filename, renamed); err != nil {
+				t.Fatalf("error when renaming test file: %s", err)
+			}
+			retryOnChaos(t, func() error { return cli.RenameFile(context.Background(), dir, filename, renamed) })
+			expected[renamed] = true
+		case i < 20:
+			if err := os.Remove(filename); err != nil {
+				t.Fatalf("error when removing test file: %s", err)
+			}
+			retryOnChaos(t, func() error { return cli.DeleteFile(context.Background(), dir, filename) })
+		default:
+			expected[filename] = true
+		}
+	}
+
+	// No index loss and no duplicates: exactly one index per remaining file.
+	dirInfo := cli.directoryInfos[dir]
+	var expectedDocIDs []sserver1.DocumentID
+	for filename := range expected {
+		relPath, err := relPathStrict(dir, filename)
+		if err != nil {
+			t.Fatalf("error when computing the relative path: %s", err)
+		}
+		docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, dirInfo.getPathnameKey(0))
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		expectedDocIDs = append(expectedDocIDs, docID)
+	}
+	sort.Slice(expectedDocIDs, func(i, j int) bool { return expectedDocIDs[i] < expectedDocIDs[j] })
+	if actual := server.docIDs(dirInfo.tlfID); !reflect.DeepEqual(expectedDocIDs, actual) {
+		t.Fatalf("incorrect indexes on the server: expected %d indexes actual %d", len(expectedDocIDs), len(actual))
+	}
+
+	// Eventual consistency: the searches return exactly the remaining files.
+	var results map[string][]string
+	retryOnChaos(t, func() (err error) {
+		results, err = cli.SearchWordsStrict(context.Background(), dir, []string{"common", "unique5", "unique15", "unique42"})
+		return err
+	})
+	var expectedFiles []string
+	for filename := range expected {
+		expectedFiles = append(expectedFiles, filename)
+	}
+	sort.Strings(expectedFiles)
+	if !reflect.DeepEqual(expectedFiles, results["common"]) {
+		t.Fatalf("incorrect search result: expected %d files actual %d", len(expectedFiles), len(results["common"]))
+	}
+	if !reflect.DeepEqual([]string{filepath.Join(dir, "renamedChaosFile5")}, results["unique5"]) {
+		t.Fatalf("incorrect search result for a renamed file: %s", results["unique5"])
+	}
+	if len(results["unique15"]) != 0 {
+		t.Fatalf("deleted file found: %s", results["unique15"])
+	}
+	if !reflect.DeepEqual([]string{filenames[42]}, results["unique42"]) {
+		t.Fatalf("incorrect search result: %s", results["unique42"])
+	}
+}
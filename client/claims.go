@@ -0,0 +1,72 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// claimantIDLen is the number of random bytes identifying a client when it
+// claims the uploads of indexes.
+const claimantIDLen = 16
+
+// newClaimantID generates the random ID a client claims the uploads of indexes
+// with.
+func newClaimantID() (string, error) {
+	var id [claimantIDLen]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// SetClaimTTL sets the duration of the claims the client takes on the search
+// server before uploading the index of a file found by a scan.  When several
+// members of a shared TLF run a client, only the client holding the claim on a
+// file uploads its index, and the others skip it until the claim expires.  A
+// non-positive `ttl` disables the claims.  Should be called before the scans
+// are started.
+func (c *Client) SetClaimTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	c.claimTTL = ttl
+}
+
+// claimFile claims the upload of the index of the file at `pathname` in
+// `directory` on the search server.  Returns false if another client holds an
+// unexpired claim on the file.  Always succeeds if the claims are disabled.
+func (c *Client) claimFile(ctx context.Context, directory, pathname string) (bool, error) {
+	if c.claimTTL == 0 {
+		return true, nil
+	}
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return false, err
+	}
+
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return false, err
+	}
+
+	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, dirInfo.getPathnameKey(dirInfo.getLatestKeyIndex()))
+	if err != nil {
+		return false, err
+	}
+
+	return c.searchCli.ClaimIndex(ctx, sserver1.ClaimIndexArg{
+		TlfID:    dirInfo.tlfID,
+		DocID:    docID,
+		Claimant: c.claimant,
+		Ttl:      int64(c.claimTTL / time.Millisecond),
+	})
+}
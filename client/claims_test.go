@@ -0,0 +1,60 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestSetClaimTTL tests the `SetClaimTTL` function.  Checks that a scan leaves
+// the files claimed by another client of the same directory to it, and indexes
+// them once the claim has expired.
+func TestSetClaimTTL(t *testing.T) {
+	server := newMemoryServerClient()
+	client1, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client2, _ := startTestClientWithServer(t, dir, server)
+	client1.SetClaimTTL(time.Hour)
+	client2.SetClaimTTL(time.Hour)
+
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if claimed, err := client1.claimFile(context.Background(), dir, pathname); err != nil || !claimed {
+		t.Fatalf("file not claimed: %t, %v", claimed, err)
+	}
+	if claimed, err := client1.claimFile(context.Background(), dir, pathname); err != nil || !claimed {
+		t.Fatalf("claim not renewed: %t, %v", claimed, err)
+	}
+	if report := client2.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 0 || report.Deferred != 1 {
+		t.Fatalf("incorrect scan of a claimed file: %+v", report)
+	}
+
+	server.lock.Lock()
+	for _, claims := range server.claims {
+		for docID, claim := range claims {
+			claim.expiry = time.Now().Add(-time.Second)
+			claims[docID] = claim
+		}
+	}
+	server.lock.Unlock()
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(pathname, future, future); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+	if report := client2.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 || report.Deferred != 0 {
+		t.Fatalf("incorrect scan after the claim expired: %+v", report)
+	}
+	if claimed, err := client1.claimFile(context.Background(), dir, pathname); err != nil || claimed {
+		t.Fatalf("file claimed by two clients: %t, %v", claimed, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// ContentStats are the statistics of a file about to be indexed, computed
+// locally before its index is built and encrypted.
+type ContentStats struct {
+	Directory string               // The client directory of the file.
+	Path      string               // The absolute path of the file, its real one if reached through a symlink.
+	Size      int64                // The size of the file in bytes.
+	Tokens    libsearch.TokenStats // The statistics of the words of the file.
+}
+
+// ContentClassifier classifies the files about to be indexed from their
+// statistics, e.g. to enforce a data-loss-prevention policy keeping the files
+// with credit card numbers out of the indexes of a shared team folder.
+type ContentClassifier interface {
+	// Classify returns an error describing the policy violated if the file
+	// of `stats` must not be indexed.
+	Classify(stats ContentStats) error
+}
+
+// ContentRefusedError is returned when a file is refused by the classifier set
+// by `SetContentClassifier`.
+type ContentRefusedError struct {
+	Path string // The path of the file refused.
+	Err  error  // The error returned by the classifier.
+}
+
+// Error implements the error interface.
+func (e ContentRefusedError) Error() string {
+	return fmt.Sprintf("file %s refused by the content classifier: %s", e.Path, e.Err)
+}
+
+// SetContentClassifier sets the classifier each file is submitted to before
+// being indexed, with the statistics of its words.  The files it refuses are
+// not indexed, and the index of their previous version, if any, is deleted by
+// the scans.  No file is refused if `classifier` is nil, the default.  Should
+// be called before the scans are started.
+func (c *Client) SetContentClassifier(classifier ContentClassifier) {
+	c.classifier = classifier
+}
+
+// classifyContent submits the statistics of the open `file` at `path` in the
+// directory of `dirInfo` to the classifier set by `SetContentClassifier`, if
+// any, and rewinds it.  Returns a `ContentRefusedError` if the file is
+// refused.
+func (c *Client) classifyContent(dirInfo *DirectoryInfo, path string, file *os.File, size int64) error {
+	if c.classifier == nil {
+		return nil
+	}
+	tokens, err := libsearch.ComputeTokenStats(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := c.classifier.Classify(ContentStats{Directory: dirInfo.absDir, Path: path, Size: size, Tokens: tokens}); err != nil {
+		return ContentRefusedError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// patternClassifier refuses the files with a word matching a pattern.
+type patternClassifier struct {
+	pattern *regexp.Regexp // The pattern of the words refused.
+}
+
+// NewPatternClassifier returns a classifier refusing the files with a word,
+// normalized as for the indexes, matching `pattern`, e.g.
+// `^[0-9]{13,19}$` for the credit card numbers.
+func NewPatternClassifier(pattern *regexp.Regexp) ContentClassifier {
+	return patternClassifier{pattern: pattern}
+}
+
+// Classify implements the ContentClassifier interface.
+func (p patternClassifier) Classify(stats ContentStats) error {
+	for word := range stats.Tokens.Counts {
+		if p.pattern.MatchString(word) {
+			return fmt.Errorf("word matching %s", p.pattern)
+		}
+	}
+	return nil
+}
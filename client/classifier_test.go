@@ -0,0 +1,75 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// recordingClassifier records the statistics of the files submitted to the
+// classifier it wraps.
+type recordingClassifier struct {
+	ContentClassifier
+	submitted []ContentStats
+}
+
+func (r *recordingClassifier) Classify(stats ContentStats) error {
+	r.submitted = append(r.submitted, stats)
+	return r.ContentClassifier.Classify(stats)
+}
+
+// TestContentClassifier tests the classifier set by `SetContentClassifier`.
+// Checks that the files are submitted with the statistics of their words, that
+// a refused file is skipped by the scans with its issue recorded, and that the
+// index of its previous version is deleted.
+func TestContentClassifier(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	classifier := &recordingClassifier{ContentClassifier: NewPatternClassifier(regexp.MustCompile(`^[0-9]{13,19}$`))}
+	cli.SetContentClassifier(classifier)
+
+	clean := filepath.Join(dir, "clean")
+	card := filepath.Join(dir, "card")
+	if err := ioutil.WriteFile(clean, []byte("quarterly report, quarterly numbers"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := ioutil.WriteFile(card, []byte("quarterly expenses"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 2 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	for _, stats := range classifier.submitted {
+		if stats.Path == clean && (stats.Tokens.NumTokens != 4 || stats.Tokens.Counts["quarterly"] != 2) {
+			t.Fatalf("incorrect statistics submitted: %+v", stats)
+		}
+	}
+
+	if err := ioutil.WriteFile(card, []byte("quarterly expenses on 4111-1111-1111-1111"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := cli.AddFile(context.Background(), dir, card); err == nil {
+		t.Fatalf("file with a credit card number not refused")
+	} else if _, ok := err.(ContentRefusedError); !ok {
+		t.Fatalf("unexpected error when adding the refused file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || report.Skipped != 1 || len(report.Added) != 0 {
+		t.Fatalf("refused file not skipped: %+v", report)
+	}
+	if issues, err := cli.GetFileIssues(dir); err != nil || len(issues) != 1 || issues[0].Path != card || issues[0].Reason != SkipRefused {
+		t.Fatalf("refused file not recorded: %+v, %v", issues, err)
+	}
+	results, err := cli.SearchWord(context.Background(), dir, "quarterly")
+	if err != nil || !reflect.DeepEqual(results, []string{clean}) {
+		t.Fatalf("index of the refused file not deleted: %s, %v", results, err)
+	}
+}
@@ -9,15 +9,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/keybase/client/go/libkb"
+	"github.com/jonboulle/clockwork"
 	rpc "github.com/keybase/go-framed-msgpack-rpc"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/search/libsearch"
@@ -35,18 +35,84 @@ type DirectoryInfo struct {
 	keyGen       libkbfs.KeyGen                  // The lastest key generation of this directory.
 	indexers     []*libsearch.SecureIndexBuilder // The indexers for the directory.
 	pathnameKeys []libsearch.PathnameKeyType     // The keys to encrypt and decrypt the pathname to/from document IDs.
+	padding      *tlfPadding                     // The padding state of the directory.  No padding if nil.
+	revisions    *indexRevisions                 // The revisions of the indexes last seen by the client.
+	removedCh    chan struct{}                   // Closed once the directory is removed from the client.
+	blinding     libsearch.BlindingPolicy        // The policy the indexes of the directory are blinded with.  The default one if nil.
+	nearWindow   int                             // The co-occurrence window of the indexes of the directory.  No co-occurrence keyword if 0.
+	summary      tlfSummaryCache                 // The summary of the TLF, if enabled by `SetTlfSummaries`.
+	results      *resultCache                    // The results of the recent searches, if enabled by `SetResultCache`.
+	backfill     backfillSession                 // The backfill of the directory announced to the search server, if any.
+	tagsLock     sync.Mutex                      // Serializes the updates of the tags of the files of the directory.
+	termStats    termStats                       // The numbers of results of the terms searched alone, planning the conjunctive queries.
+}
+
+// directoryParams are the parameters the TLFs of the directories of a client
+// are registered with, kept for the directories added with `AddDirectory`.
+type directoryParams struct {
+	lenMS        int                      // The length of the master secrets.
+	lenSalt      int                      // The length of the salts.
+	fpRate       float64                  // The desired false positive rate of the indexes.
+	numUniqWords uint64                   // The expected number of unique words in a TLF.
+	encryptSalts bool                     // Whether the salts are generated by the client and encrypted.
+	indexType    sserver1.IndexType       // The index type requested when registering a TLF.
+	blinding     libsearch.BlindingPolicy // The policy the indexes are blinded with.  The default one if nil.
+	nearWindow   int                      // The co-occurrence window of the indexes.  No co-occurrence keyword if 0.
 }
 
 // Client contains all the necessary information for a KBFS Search Client.
-// TODO: Add a lock to protect directoryInfos if adding directories during
-// execution is allowed.
+// The methods reaching the search server take a context, whose cancellation or
+// deadline aborts their RPCs, so that the callers can time out the slow
+// searches and abort the bulk indexing.
 type Client struct {
-	searchCli      sserver1.SearchServerInterface // The client that talks to the RPC Search Server.
-	directoryInfos map[string]*DirectoryInfo      // The map from the directories to the DirectoryInfo's.
+	searchCli      sserver1.SearchServerInterface  // The client that talks to the RPC Search Server.
+	conn           *rpc.Connection                 // The connection to the search server.  Nil if not owned by the client.
+	standby        *standbyServerClient            // The client mirroring the index writes to the standby search server, if set by `SetStandby`.
+	directoryInfos map[string]*DirectoryInfo       // The map from the directories to the DirectoryInfo's.
+	dirLock        sync.RWMutex                    // Protects `directoryInfos`, `removals` and `stateLocks`.
+	dirParams      directoryParams                 // The parameters of the directories added with `AddDirectory`.
+	removals       chan struct{}                   // Closed and replaced on every call to `RemoveDirectory`.
+	memBudget      *MemoryBudget                   // The memory budget shared by the concurrent index builds.  No limit if nil.
+	resultBucket   int                             // The bucket size the server pads the search results to.  No padding if 0.
+	sizeBuckets    bool                            // Whether the indexes are padded up to their size buckets.
+	tlfSummaries   bool                            // Whether the summaries of the TLFs are contributed to and relied on.
+	throttle       *queryThrottle                  // The throttle of the search queries.  No limit if nil.
+	scanInterval   time.Duration                   // The interval between two scans of `PeriodicAdd`.
+	historyDepth   int                             // The number of prior versions of each file kept searchable.  No history if 0.
+	cacheEntries   int                             // The number of recent search results cached per directory.  No cache if 0.
+	maxFileSize    int64                           // The size beyond which the scanned files are skipped.  No limit if 0.
+	skipBinary     bool                            // Whether the scanned files with binary content are skipped.
+	symlinks       SymlinkPolicy                   // How the scans handle the symlinks.  Skipped unless `SymlinkFollow`.
+	classifier     ContentClassifier               // The classifier the files are submitted to before being indexed, if any.
+	indexWorkers   int                             // The number of files the scans index concurrently.
+	decryptWorkers int                             // The number of workers decrypting the document IDs of large search results.
+	verifyWorkers  int                             // The number of files the strict searches scan concurrently.
+	searchPageSize int                             // The number of results fetched at once by the streamed searches.
+	uploadRetries  int                             // The number of times a failed upload is retried.
+	retryDelay     time.Duration                   // The delay before the first retry of a failed upload.
+	uploadThrottle *uploadThrottle                 // The limit of the rate of the uploads of indexes.  No limit if nil.
+	claimant       string                          // The random ID the client claims the uploads of indexes with.
+	claimTTL       time.Duration                   // The duration of the claims on the uploads of indexes.  No claims if 0.
+	backfillMin    int                             // The number of files from which a scan announces a backfill.  No backfill if 0.
+	stateLocks     map[string]*stateLock           // The locks on the local state of the directories, if taken, keyed by directory.
+	stateDir       string                          // The directory holding the local state, while the directories are locked.
+	fileIssues     map[string]map[string]FileIssue // The issues of the files, keyed by directory and path.
+	offlineQueues  map[string]*offlineQueue        // The queues of the operations pending while the server is unreachable, keyed by directory, while the directories are locked.
+	contentHashes  map[string]map[string]string    // The content hashes of the files as of their last index, keyed by directory and path.
+	issuesLock     sync.Mutex                      // Protects `stateDir`, `fileIssues`, `offlineQueues` and `contentHashes`.
+	progress       map[string]*ScanProgress        // The progress of the scans in progress, keyed by directory.
+	scanErrors     map[string]error                // The errors of the last scans that failed, keyed by directory.
+	scanDurations  map[string]time.Duration        // The durations of the last scans that succeeded, keyed by directory.
+	uploadedBytes  map[string]int64                // The number of bytes of indexes uploaded since the client started, keyed by directory.
+	progressLock   sync.Mutex                      // Protects `progress`, `scanErrors`, `scanDurations` and `uploadedBytes`.
+	metrics        *clientMetrics                  // The metrics exported by `WriteMetrics`.
+	clock          clockwork.Clock                 // The clock driving the background loops.
+	shutdownCh     chan struct{}                   // Closed to stop the background loops.
+	shutdownOnce   sync.Once                       // Makes sure `shutdownCh` is only closed once.
 }
 
 // HandlerName implements the ConnectionHandler interface.
-func (Client) HandlerName() string {
+func (*Client) HandlerName() string {
 	return "SearchClient"
 }
 
@@ -77,23 +143,17 @@ func (c *Client) ShouldRetryOnConnect(err error) bool {
 	return false
 }
 
-// logOutput is a simple log output that prints to the console.
+// logOutput is the log output of the RPCs, which writes to a `Logger`.  The
+// traces of the RPCs are only logged at the debug level.
 type logOutput struct {
-	verbose bool // Whether log outputs should be printed out
+	logger *Logger // The logger the entries are written to, or nil to discard them.
 }
 
-func (l logOutput) log(ch string, fmts string, args []interface{}) {
-	if !l.verbose {
-		return
-	}
-	fmts = fmt.Sprintf("[%s] %s", ch, fmts)
-	fmt.Println(fmts, args)
-}
-func (l logOutput) Info(fmt string, args ...interface{})    { l.log("I", fmt, args) }
-func (l logOutput) Error(fmt string, args ...interface{})   { l.log("E", fmt, args) }
-func (l logOutput) Debug(fmt string, args ...interface{})   { l.log("D", fmt, args) }
-func (l logOutput) Warning(fmt string, args ...interface{}) { l.log("W", fmt, args) }
-func (l logOutput) Profile(fmt string, args ...interface{}) { l.log("P", fmt, args) }
+func (l logOutput) Info(fmt string, args ...interface{})    { l.logger.Debugf(fmt, args...) }
+func (l logOutput) Error(fmt string, args ...interface{})   { l.logger.Errorf(fmt, args...) }
+func (l logOutput) Debug(fmt string, args ...interface{})   { l.logger.Debugf(fmt, args...) }
+func (l logOutput) Warning(fmt string, args ...interface{}) { l.logger.Warnf(fmt, args...) }
+func (l logOutput) Profile(fmt string, args ...interface{}) { l.logger.Debugf(fmt, args...) }
 
 func logTags(ctx context.Context) (map[interface{}]string, bool) {
 	return nil, false
@@ -132,87 +192,283 @@ func (d *DirectoryInfo) getPathnameKey(index int) libsearch.PathnameKeyType {
 }
 
 // CreateClient creates a new `Client` instance with the parameters and returns
-// a pointer the the instance.  Returns an error on any failure.
-func CreateClient(ctx context.Context, ipAddr string, port int, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64, verbose bool) (*Client, error) {
-	serverAddr := fmt.Sprintf("%s:%d", ipAddr, port)
-	conn := rpc.NewTLSConnection(serverAddr, libsearch.GetRootCerts(serverAddr), libkb.ErrorUnwrapper{}, &Client{}, true, rpc.NewSimpleLogFactory(logOutput{verbose: verbose}, nil), libkb.WrapError, logOutput{verbose: verbose}, logTags)
-
-	searchCli := sserver1.SearchServerClient{Cli: conn.GetClient()}
-
-	return createClientWithClient(ctx, searchCli, directories, lenMS, lenSalt, fpRate, numUniqWords)
+// a pointer the the instance, as `NewClient` does with the options setting
+// them.  Returns an error on any failure.
+//
+// Deprecated: use `NewClient`, which takes the parameters as options.
+func CreateClient(ctx context.Context, ipAddr string, port int, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64, encryptSalts bool, indexType sserver1.IndexType, logger *Logger) (*Client, error) {
+	return NewClient(ctx, ipAddr, port, directories, WithMasterSecretLength(lenMS), WithSaltLength(lenSalt), WithFPRate(fpRate), WithNumUniqWords(numUniqWords), WithEncryptedSalts(encryptSalts), WithIndexType(indexType), WithLogger(logger))
 }
 
 // createClient creates a new `Client` with a given SearchServerInterface.
 // Should only be used internally and for tests.
-func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServerInterface, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64) (*Client, error) {
-	directoryInfos := make(map[string]*DirectoryInfo)
+func createClientWithClient(ctx context.Context, searchCli sserver1.SearchServerInterface, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64, encryptSalts bool, indexType sserver1.IndexType) (*Client, error) {
+	return createClientWithClock(ctx, searchCli, clockwork.NewRealClock(), directories, lenMS, lenSalt, fpRate, numUniqWords, encryptSalts, indexType)
+}
 
-	// Initializes the info for each directory.
-	for _, directory := range directories {
+// createClientWithClock is similar to `createClientWithClient`, but the
+// background loops of the client are driven by `clock`.  Should only be used
+// internally and for tests.
+func createClientWithClock(ctx context.Context, searchCli sserver1.SearchServerInterface, clock clockwork.Clock, directories []string, lenMS, lenSalt int, fpRate float64, numUniqWords uint64, encryptSalts bool, indexType sserver1.IndexType) (*Client, error) {
+	if err := libsearch.ValidateLenSalt(lenSalt); err != nil {
+		return nil, err
+	}
 
-		absDir, err := filepath.Abs(directory)
+	params := directoryParams{lenMS: lenMS, lenSalt: lenSalt, fpRate: fpRate, numUniqWords: numUniqWords, encryptSalts: encryptSalts, indexType: indexType}
+	directoryInfos := make(map[string]*DirectoryInfo)
+	for _, directory := range directories {
+		dirInfo, err := newDirectoryInfo(ctx, searchCli, directory, params)
 		if err != nil {
 			return nil, err
 		}
+		directoryInfos[dirInfo.absDir] = dirInfo
+	}
+
+	claimant, err := newClaimantID()
+	if err != nil {
+		return nil, err
+	}
+
+	cli := &Client{
+		searchCli:      searchCli,
+		claimant:       claimant,
+		directoryInfos: directoryInfos,
+		dirParams:      params,
+		removals:       make(chan struct{}),
+		clock:          clock,
+		scanInterval:   defaultScanInterval,
+		indexWorkers:   1,
+		decryptWorkers: runtime.NumCPU(),
+		verifyWorkers:  runtime.NumCPU(),
+		searchPageSize: defaultSearchPageSize,
+		fileIssues:     make(map[string]map[string]FileIssue),
+		contentHashes:  make(map[string]map[string]string),
+		progress:       make(map[string]*ScanProgress),
+		scanErrors:     make(map[string]error),
+		scanDurations:  make(map[string]time.Duration),
+		uploadedBytes:  make(map[string]int64),
+		metrics:        newClientMetrics(),
+		shutdownCh:     make(chan struct{}),
+	}
 
-		tlfID, keyGen, err := getTlfIDAndKeyGen(absDir)
+	// TODO: pass the context along
+	go cli.periodicKeyGenCheck()
+	for _, dirInfo := range directoryInfos {
+		if dirInfo.padding.isBatched() {
+			go cli.periodicFlush(dirInfo)
+		}
+	}
+
+	return cli, nil
+}
+
+// newDirectoryInfo registers the TLF of `directory` on the search server of
+// `searchCli` with `params` if needed, and sets up the indexers and the
+// pathname keys of the directory.
+func newDirectoryInfo(ctx context.Context, searchCli sserver1.SearchServerInterface, directory string, params directoryParams) (*DirectoryInfo, error) {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkMasterSecretPolicy(absDir, params.lenMS); err != nil {
+		return nil, err
+	}
+
+	tlfID, keyGen, err := getTlfIDAndKeyGen(absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	registerArg := sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: params.lenSalt, FpRate: params.fpRate, NumUniqWords: int64(params.numUniqWords), IndexType: params.indexType, AnalysisFingerprint: libsearch.ComputeAnalysisFingerprint(), CodewordMapping: int(registeredMapping(params.indexType))}
+	if params.encryptSalts {
+		registerArg.EncryptedSalts, err = generateEncryptedSalts(directory, keyGen, params.lenMS, params.lenSalt, params.fpRate)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		tlfInfo, err := searchCli.RegisterTlfIfNotExists(ctx, sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: lenSalt, FpRate: fpRate, NumUniqWords: int64(numUniqWords)})
+	tlfInfo, err := searchCli.RegisterTlfIfNotExists(ctx, registerArg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The salts are encrypted if this or another client of the TLF has
+	// generated them, regardless of the option of this client.
+	if len(tlfInfo.EncryptedSalts) > 0 {
+		tlfInfo.Salts, err = openEncryptedSalts(directory, keyGen, params.lenMS, tlfInfo.EncryptedSalts)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	// The salts may come from a TLF registered before the minimum length
+	// was enforced, or from a server not enforcing it.
+	for _, salt := range tlfInfo.Salts {
+		if err := libsearch.ValidateLenSalt(len(salt)); err != nil {
+			return nil, err
+		}
+	}
 
-		var indexers []*libsearch.SecureIndexBuilder
-		var pathnameKeys []libsearch.PathnameKeyType
+	if err := verifyTlfFingerprint(tlfInfo); err != nil {
+		return nil, err
+	}
 
-		// Sets up the indexers and pathname keys
-		if keyGen == libkbfs.PublicKeyGen {
-			masterSecret, err := fetchMasterSecret(directory, keyGen, lenMS)
+	// The index type is the one of the client that has registered the TLF,
+	// regardless of the option of this client.
+	if _, ok := sserver1.IndexTypeRevMap[tlfInfo.IndexType]; !ok {
+		return nil, fmt.Errorf("unsupported index type %d", tlfInfo.IndexType)
+	}
+	if err := validateTlfMapping(tlfInfo); err != nil {
+		return nil, err
+	}
+
+	padding, err := readPaddingPolicy(absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexers []*libsearch.SecureIndexBuilder
+	var pathnameKeys []libsearch.PathnameKeyType
+
+	// Sets up the indexers and pathname keys
+	if keyGen == libkbfs.PublicKeyGen {
+		masterSecret, err := fetchMasterSecret(directory, keyGen, params.lenMS)
+		if err != nil {
+			return nil, err
+		}
+		indexers = make([]*libsearch.SecureIndexBuilder, 1)
+		pathnameKeys = make([]libsearch.PathnameKeyType, 1)
+		indexers[0] = newIndexer(masterSecret, tlfInfo, params.blinding, params.nearWindow)
+		copy(pathnameKeys[0][:], masterSecret[0:32])
+	} else if keyGen >= libkbfs.FirstValidKeyGen {
+		indexers = make([]*libsearch.SecureIndexBuilder, keyGen)
+		pathnameKeys = make([]libsearch.PathnameKeyType, keyGen)
+		for i := libkbfs.KeyGen(libkbfs.FirstValidKeyGen); i <= keyGen; i++ {
+			masterSecret, err := fetchMasterSecret(directory, i, params.lenMS)
 			if err != nil {
 				return nil, err
 			}
-			indexers = make([]*libsearch.SecureIndexBuilder, 1)
-			pathnameKeys = make([]libsearch.PathnameKeyType, 1)
-			indexers[0] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
-			copy(pathnameKeys[0][:], masterSecret[0:32])
-		} else if keyGen >= libkbfs.FirstValidKeyGen {
-			indexers = make([]*libsearch.SecureIndexBuilder, keyGen)
-			pathnameKeys = make([]libsearch.PathnameKeyType, keyGen)
-			for i := libkbfs.KeyGen(libkbfs.FirstValidKeyGen); i <= keyGen; i++ {
-				masterSecret, err := fetchMasterSecret(directory, i, lenMS)
-				if err != nil {
-					return nil, err
-				}
-				indexers[getNormalizedKeyIndex(i)] = libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
-				copy(pathnameKeys[getNormalizedKeyIndex(i)][:], masterSecret[0:32])
-			}
-		} else {
-			return nil, errors.New("invalid key generation")
+			indexers[getNormalizedKeyIndex(i)] = newIndexer(masterSecret, tlfInfo, params.blinding, params.nearWindow)
+			copy(pathnameKeys[getNormalizedKeyIndex(i)][:], masterSecret[0:32])
 		}
+	} else {
+		return nil, errors.New("invalid key generation")
+	}
 
-		directoryInfos[absDir] = &DirectoryInfo{
-			absDir:       absDir,
-			lenMS:        lenMS,
-			tlfID:        tlfID,
-			tlfInfo:      tlfInfo,
-			keyGen:       keyGen,
-			indexers:     indexers,
-			pathnameKeys: pathnameKeys,
-		}
+	return &DirectoryInfo{
+		absDir:       absDir,
+		lenMS:        params.lenMS,
+		tlfID:        tlfID,
+		tlfInfo:      tlfInfo,
+		keyGen:       keyGen,
+		indexers:     indexers,
+		pathnameKeys: pathnameKeys,
+		padding:      padding,
+		revisions:    newIndexRevisions(),
+		removedCh:    make(chan struct{}),
+		blinding:     params.blinding,
+		nearWindow:   params.nearWindow,
+		results:      &resultCache{},
+	}, nil
+}
+
+// registeredMapping returns the codeword mapping the new TLFs of `indexType`
+// are registered with.
+func registeredMapping(indexType sserver1.IndexType) libsearch.CodewordMapping {
+	if indexType == sserver1.IndexType_CUCKOO {
+		return libsearch.CodewordMappingCuckoo
 	}
+	return libsearch.RegisteredCodewordMapping
+}
 
-	cli := &Client{
-		searchCli:      searchCli,
-		directoryInfos: directoryInfos,
+// validateTlfMapping returns an error if the codeword mapping recorded in
+// `tlfInfo` is unknown, or does not go with the index type of the TLF.
+func validateTlfMapping(tlfInfo sserver1.TlfInfo) error {
+	mapping := libsearch.CodewordMapping(tlfInfo.CodewordMapping)
+	if tlfInfo.CodewordMapping < 0 || int(mapping) != tlfInfo.CodewordMapping {
+		return fmt.Errorf("unknown codeword mapping %d", tlfInfo.CodewordMapping)
+	}
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+	// The cuckoo TLFs registered before the mappings were recorded have the
+	// legacy mapping.
+	isCuckoo := mapping == libsearch.CodewordMappingCuckoo
+	if isCuckoo != (tlfInfo.IndexType == sserver1.IndexType_CUCKOO) && mapping != libsearch.DefaultCodewordMapping {
+		return fmt.Errorf("codeword mapping %d does not go with index type %d", mapping, tlfInfo.IndexType)
 	}
+	return nil
+}
 
-	// TODO: pass the context along
-	go cli.periodicKeyGenCheck()
+// indexMapping returns the codeword mapping the indexes of the TLF described
+// by `tlfInfo` are built with: the one recorded when the TLF was registered,
+// which is the legacy one for the TLFs that record none.  The mapping should
+// have been validated by `validateTlfMapping`.
+func indexMapping(tlfInfo sserver1.TlfInfo) libsearch.CodewordMapping {
+	if tlfInfo.IndexType == sserver1.IndexType_CUCKOO {
+		return libsearch.CodewordMappingCuckoo
+	}
+	return libsearch.CodewordMapping(tlfInfo.CodewordMapping)
+}
 
-	return cli, nil
+// newIndexer creates the index builder of a TLF described by `tlfInfo` for the
+// key generation with `masterSecret`, blinding the indexes with `blinding`
+// unless nil, and indexing the co-occurrences within `nearWindow` words.
+func newIndexer(masterSecret []byte, tlfInfo sserver1.TlfInfo, blinding libsearch.BlindingPolicy, nearWindow int) *libsearch.SecureIndexBuilder {
+	indexer := libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, tlfInfo.Salts, uint64(tlfInfo.Size))
+	// The mapping is known, so the error can be ignored.
+	_ = indexer.SetCodewordMapping(indexMapping(tlfInfo))
+	if blinding != nil {
+		// The policy has been validated by `SetBlindingPolicy`.
+		_ = indexer.SetBlindingPolicy(blinding)
+	}
+	indexer.SetNearWindow(nearWindow)
+	return indexer
+}
+
+// SetNearWindow sets the number of following words each word of the files of
+// all the directories is indexed as co-occurring with, so that the queries
+// such as "alpha NEAR beta" match the files where the two words are within
+// `window` words of each other.  The co-occurrences raise the false positive
+// rate of the indexes, and are only indexed with a positive `window`.  All the
+// clients of a TLF should use the same window, as the files indexed by the
+// others are otherwise missed or matched with their window.  Should be called
+// before any file is added.
+func (c *Client) SetNearWindow(window int) {
+	if window < 0 {
+		window = 0
+	}
+	c.dirParams.nearWindow = window
+	for _, dirInfo := range c.getDirectoryInfos() {
+		dirInfo.keyGenLock.Lock()
+		dirInfo.nearWindow = window
+		for _, indexer := range dirInfo.indexers {
+			indexer.SetNearWindow(window)
+		}
+		dirInfo.keyGenLock.Unlock()
+	}
+}
+
+// SetBlindingPolicy sets the policy deciding how many random entries blind the
+// indexes of the files of all the directories, `libsearch.LengthBlinding` by
+// default.  The policy is recorded in each index.  Returns an error if the
+// version of `policy` is unknown.  Should be called before any file is added.
+func (c *Client) SetBlindingPolicy(policy libsearch.BlindingPolicy) error {
+	// Checks the policy before applying it to any directory.
+	if err := libsearch.CreateSecureIndexBuilder(sha256.New, nil, nil, 1).SetBlindingPolicy(policy); err != nil {
+		return err
+	}
+	c.dirParams.blinding = policy
+	for _, dirInfo := range c.getDirectoryInfos() {
+		dirInfo.keyGenLock.Lock()
+		dirInfo.blinding = policy
+		for _, indexer := range dirInfo.indexers {
+			_ = indexer.SetBlindingPolicy(policy)
+		}
+		dirInfo.keyGenLock.Unlock()
+	}
+	return nil
 }
 
 // getDirectoryInfo is a helper function that gets the DirectoryInfo for
@@ -224,6 +480,8 @@ func (c *Client) getDirectoryInfo(directory string) (*DirectoryInfo, error) {
 		return nil, err
 	}
 
+	c.dirLock.RLock()
+	defer c.dirLock.RUnlock()
 	dirInfo, ok := c.directoryInfos[absDir]
 	if !ok {
 		return nil, errors.New("invalid directory name provided")
@@ -232,53 +490,221 @@ func (c *Client) getDirectoryInfo(directory string) (*DirectoryInfo, error) {
 	return dirInfo, nil
 }
 
+// getDirectoryInfos returns the DirectoryInfo's of all the directories of the
+// client.
+func (c *Client) getDirectoryInfos() []*DirectoryInfo {
+	c.dirLock.RLock()
+	defer c.dirLock.RUnlock()
+	dirInfos := make([]*DirectoryInfo, 0, len(c.directoryInfos))
+	for _, dirInfo := range c.directoryInfos {
+		dirInfos = append(dirInfos, dirInfo)
+	}
+	return dirInfos
+}
+
+// isRemoved returns whether the directory of `d` has been removed from its
+// client with `RemoveDirectory`.  A nil `d`, standing for an unknown
+// directory, is never removed.
+func (d *DirectoryInfo) isRemoved() bool {
+	if d == nil {
+		return false
+	}
+	select {
+	case <-d.removedCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetMemoryBudget limits the total estimated memory used by the index builds
+// running concurrently on this client to `budget` bytes.  Index builds that do
+// not fit in the budget wait for the others to finish.  A non-positive
+// `budget` removes the limit.  Should be called before any file is added.
+func (c *Client) SetMemoryBudget(budget int64) {
+	c.ShareMemoryBudget(NewMemoryBudget(budget))
+}
+
+// ShareMemoryBudget is similar to `SetMemoryBudget`, but limits the memory
+// used by the index builds of this client together with those of all the
+// other clients sharing `budget`.  A nil `budget` removes the limit.  Should
+// be called before any file is added.
+func (c *Client) ShareMemoryBudget(budget *MemoryBudget) {
+	c.memBudget = budget
+}
+
+// SetQueryLimit throttles the search queries to at most `limit` words every
+// `window`, as a safeguard against the exfiltration of data through the search
+// server by rogue local callers.  The queries beyond the limit are delayed, and
+// `onAnomaly` is called once per burst hitting the limit.  A non-positive
+// `limit` removes the limit.  Should be called before any search.
+func (c *Client) SetQueryLimit(limit int, window time.Duration, onAnomaly func(QueryAnomaly)) {
+	if limit <= 0 {
+		c.throttle = nil
+		return
+	}
+	c.throttle = newQueryThrottle(c.clock, limit, window, onAnomaly)
+}
+
+// SetScanInterval sets the interval between two scans of the directories by
+// `PeriodicAdd`.  Longer intervals spare the rescans of very large
+// directories, while shorter ones keep the indexes fresher.  A non-positive
+// `interval` restores the default of one minute.  Should be called before
+// `PeriodicAdd`.
+func (c *Client) SetScanInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultScanInterval
+	}
+	c.scanInterval = interval
+}
+
+// SetResultBucketSize asks the search server to pad each list of search
+// results with dummy document IDs to the next multiple of `bucketSize`, so
+// that the result sizes do not reveal the exact document frequencies of the
+// words.  The dummies are filtered out by the client.  Disables the padding if
+// `bucketSize` is not positive.
+func (c *Client) SetResultBucketSize(bucketSize int) {
+	if bucketSize < 0 {
+		bucketSize = 0
+	}
+	c.resultBucket = bucketSize
+}
+
+// SetIndexSizeBuckets sets whether each index uploaded is padded up to the next
+// standard size bucket, so that the search server cannot tell the documents
+// apart by the exact sizes of their indexes, at the cost of up to twice the
+// storage.  Should be called before any file is added.
+func (c *Client) SetIndexSizeBuckets(enabled bool) {
+	c.sizeBuckets = enabled
+}
+
 // AddFile indexes a file in `directory` with the given `pathname` and writes
-// the index to the server.
-func (c *Client) AddFile(directory, pathname string) error {
+// the index to the server.  A symlink is indexed under the real path of its
+// target if followed as set by `SetSymlinkPolicy`.  Returns a
+// `ContentRefusedError` if the file is refused by the classifier set by
+// `SetContentClassifier`.
+func (c *Client) AddFile(ctx context.Context, directory, pathname string) error {
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
 		return err
 	}
 
-	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	write, err := c.buildWrite(ctx, dirInfo, pathname)
 	if err != nil {
 		return err
 	}
+	if dirInfo.padding.isBatched() {
+		dirInfo.padding.addWrite(write)
+		return nil
+	}
+
+	if err := c.writeIndex(ctx, dirInfo, write); err != nil {
+		return err
+	}
+	return c.padDocumentCount(ctx, dirInfo)
+}
+
+// buildWrite indexes the file at `pathname` in the directory of `dirInfo`, as
+// `AddFile` does, and returns the upload of its index, ready to be sent.
+// Returns the error of `ctx` if it is done while waiting for the memory budget.
+func (c *Client) buildWrite(ctx context.Context, dirInfo *DirectoryInfo, pathname string) (pendingWrite, error) {
+	pathname, err := c.realPath(dirInfo.absDir, pathname)
+	if err != nil {
+		return pendingWrite{}, err
+	}
+
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return pendingWrite{}, err
+	}
 
 	keyIndex := dirInfo.getLatestKeyIndex()
 
 	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, dirInfo.getPathnameKey(keyIndex))
 	if err != nil {
-		return err
+		return pendingWrite{}, err
 	}
 
 	file, err := os.Open(pathname)
 	if err != nil {
-		return err
+		return pendingWrite{}, err
 	}
+	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return err
+		return pendingWrite{}, err
+	}
+
+	if err := c.classifyContent(dirInfo, pathname, file, fileInfo.Size()); err != nil {
+		return pendingWrite{}, err
+	}
+
+	if c.memBudget != nil {
+		acquired, err := c.memBudget.acquire(ctx, estimateIndexMemory(fileInfo.Size(), len(dirInfo.tlfInfo.Salts), uint64(dirInfo.tlfInfo.Size)))
+		if err != nil {
+			return pendingWrite{}, err
+		}
+		defer c.memBudget.release(acquired)
 	}
 
-	secIndex, err := dirInfo.getIndexer(keyIndex).BuildSecureIndex(file, fileInfo.Size())
+	metadata := libsearch.ComputeMetadataKeywords(fileInfo.Name(), fileInfo.Size(), fileInfo.ModTime())
+	secIndex, words, err := dirInfo.getIndexer(keyIndex).BuildSecureIndexWithKeywords(file, fileInfo.Size(), metadata)
 	if err != nil {
-		return err
+		return pendingWrite{}, err
 	}
+	secIndex.SizeBucket = c.sizeBuckets
 
 	secIndexBytes, err := secIndex.MarshalBinary()
 	if err != nil {
-		return err
+		return pendingWrite{}, err
+	}
+
+	summary, err := c.buildSummary(dirInfo, keyIndex, words)
+	if err != nil {
+		return pendingWrite{}, err
+	}
+
+	pathnameKey := dirInfo.getPathnameKey(keyIndex)
+	return pendingWrite{
+		arg:     sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndexBytes, DocID: docID},
+		digest:  libsearch.ComputeWordSetDigest(pathnameKey, words),
+		key:     pathnameKey,
+		summary: summary,
+	}, nil
+}
+
+// GetWordSetDigest returns the word set digest persisted when the file with
+// `pathname` in `directory` was last indexed.  The digest can be compared with
+// the words of the current version of the file to compute the added and
+// removed words without retokenizing the previous version.  The digests are
+// kept with the local state, so none is persisted unless the directories are
+// locked with `LockDirectories`.
+func (c *Client) GetWordSetDigest(directory, pathname string) (libsearch.WordSetDigest, libsearch.PathnameKeyType, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, libsearch.PathnameKeyType{}, err
+	}
+
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return nil, libsearch.PathnameKeyType{}, err
 	}
 
-	return c.searchCli.WriteIndex(context.TODO(), sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndexBytes, DocID: docID})
+	pathnameKey := dirInfo.getPathnameKey(dirInfo.getLatestKeyIndex())
+	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, relPath, pathnameKey)
+	if err != nil {
+		return nil, libsearch.PathnameKeyType{}, err
+	}
+
+	digest, err := c.readWordSetDigest(dirInfo.absDir, docID, pathnameKey)
+	return digest, pathnameKey, err
 }
 
 // RenameFile is called when a file in `directory` has been renamed from `orig`
 // to `curr`.  This will rename their corresponding indexes.  Returns an error
 // if the filenames are invalid.
-func (c *Client) RenameFile(directory string, orig, curr string) error {
+func (c *Client) RenameFile(ctx context.Context, directory string, orig, curr string) error {
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
 		return err
@@ -306,12 +732,24 @@ func (c *Client) RenameFile(directory string, orig, curr string) error {
 		return err
 	}
 
-	return c.searchCli.RenameIndex(context.TODO(), sserver1.RenameIndexArg{TlfID: dirInfo.tlfID, Orig: origDocID, Curr: currDocID})
+	if dirInfo.padding.isBatched() && dirInfo.padding.renamePending(origDocID, currDocID) {
+		return nil
+	}
+
+	err = c.sendOrQueue(ctx, dirInfo, queuedOp{Type: queuedRename, DocID: origDocID, CurrDocID: currDocID})
+	if err != nil {
+		return err
+	}
+
+	if err := c.renameWordSetDigest(dirInfo.absDir, origDocID, currDocID); err != nil {
+		return err
+	}
+	return c.renameTags(ctx, dirInfo, relOrig, relCurr)
 }
 
 // DeleteFile deletes the index on the server associated with `pathname` in
 // `directory`.
-func (c *Client) DeleteFile(directory string, pathname string) error {
+func (c *Client) DeleteFile(ctx context.Context, directory string, pathname string) error {
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
 		return err
@@ -327,79 +765,446 @@ func (c *Client) DeleteFile(directory string, pathname string) error {
 		return err
 	}
 
-	return c.searchCli.DeleteIndex(context.Background(), sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID})
+	if dirInfo.padding.isBatched() {
+		dirInfo.padding.addDelete(docID)
+		return nil
+	}
+
+	if err := c.deleteIndex(ctx, dirInfo, docID); err != nil {
+		return err
+	}
+	return c.padDocumentCount(ctx, dirInfo)
+}
+
+// computeTrapdoorMap computes the trapdoors of `word` for each of the key
+// generations in `keyGens`, keyed by the string form of the key generation as
+// expected by the search server.
+func computeTrapdoorMap(dirInfo *DirectoryInfo, keyGens []int, word string) map[string]sserver1.Trapdoor {
+	trapdoorMap := make(map[string]sserver1.Trapdoor)
+	for _, keyGen := range keyGens {
+		origKeyGen := keyGen
+		if keyGen == int(libkbfs.PublicKeyGen) {
+			keyGen = libkbfs.FirstValidKeyGen
+		}
+		if keyGen < 0 || getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)) > dirInfo.getLatestKeyIndex() {
+			continue
+		}
+		indexer := dirInfo.getIndexer(getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)))
+		trapdoorMap[strconv.Itoa(origKeyGen)] = sserver1.Trapdoor{Codeword: indexer.ComputeTrapdoors(word)}
+	}
+	return trapdoorMap
+}
+
+// docIDsToFilenames decrypts the `documents` returned by the search server into
+// absolute filenames under the directory of `dirInfo`, sorted in increasing
+// order.  The dummy indexes padding the number of documents are filtered out,
+// as well as the dummy document IDs padding the results if requested, and the
+// auxiliary indexes of the tags are mapped to the files they tag.  If a
+// document has been encrypted with a key generation unknown to the client,
+// refreshes the keys of the directory once and retries.
+func (c *Client) docIDsToFilenames(dirInfo *DirectoryInfo, documents []sserver1.DocumentID) ([]string, error) {
+	pathnames, err := c.decryptDocIDs(dirInfo, documents)
+	if _, ok := err.(libsearch.UnknownKeyGenError); ok {
+		c.refreshKeys(dirInfo)
+		pathnames, err = c.decryptDocIDs(dirInfo, documents)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0, len(pathnames))
+	for _, pathname := range pathnames {
+		if tagged, ok := parseTagsPathname(pathname); ok {
+			pathname = tagged
+		}
+		if pathname != "" && !isDummyPathname(pathname) {
+			filenames = append(filenames, filepath.Join(dirInfo.absDir, pathname))
+		}
+	}
+	sort.Strings(filenames)
+	return uniqueWords(filenames), nil
 }
 
 // SearchWord performs a search request on the search server and returns the
 // list of filenames in `directory` possibly containing the `word`.
 // NOTE: False positives are possible.
-func (c *Client) SearchWord(directory, word string) ([]string, error) {
+func (c *Client) SearchWord(ctx context.Context, directory, word string) ([]string, error) {
+	defer c.metrics.searched(time.Now())
 	dirInfo, err := c.getDirectoryInfo(directory)
 	if err != nil {
 		return nil, err
 	}
 
+	if c.absentFromSummary(ctx, dirInfo, word) {
+		return []string{}, nil
+	}
+	if filenames, ok := c.cachedResults(dirInfo, wordCacheKey(word)); ok {
+		return filenames, nil
+	}
+
+	if c.throttle != nil {
+		if err := c.throttle.wait(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+
 	// TODO: cache the key generations and update when the server notifies the
 	// client of new key generations
-	keyGens, err := c.searchCli.GetKeyGens(context.TODO(), dirInfo.tlfID)
+	keyGens, err := c.searchCli.GetKeyGens(ctx, dirInfo.tlfID)
 	if err != nil {
 		return nil, err
 	}
 
-	trapdoorMap := make(map[string]sserver1.Trapdoor)
-	for _, keyGen := range keyGens {
-		origKeyGen := keyGen
-		if keyGen == int(libkbfs.PublicKeyGen) {
-			keyGen = libkbfs.FirstValidKeyGen
+	trapdoorMap := computeTrapdoorMap(dirInfo, keyGens, word)
+
+	documents, err := c.searchCli.SearchWord(ctx, sserver1.SearchWordArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMap, ResultBucketSize: c.resultBucket})
+	if err != nil {
+		return nil, err
+	}
+
+	filenames, err := c.docIDsToFilenames(dirInfo, documents)
+	if err != nil {
+		return nil, err
+	}
+	c.cacheResults(dirInfo, wordCacheKey(word), filenames)
+	dirInfo.termStats.record(word, len(filenames))
+	return filenames, nil
+}
+
+// SearchWords is similar to `SearchWord`, but searches for multiple
+// independent `words` in a single round trip to the search server.  Returns a
+// map from each word to the list of filenames in `directory` possibly
+// containing that word.
+// NOTE: False positives are possible.
+func (c *Client) SearchWords(ctx context.Context, directory string, words []string) (map[string][]string, error) {
+	defer c.metrics.searched(time.Now())
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	filenamesMap := make(map[string][]string, len(words))
+	// Only the words possibly in some of the files, and whose results are
+	// not cached, are sent.
+	var present []string
+	for _, word := range words {
+		if c.absentFromSummary(ctx, dirInfo, word) {
+			filenamesMap[word] = []string{}
+		} else if filenames, ok := c.cachedResults(dirInfo, wordCacheKey(word)); ok {
+			filenamesMap[word] = filenames
+		} else {
+			present = append(present, word)
 		}
-		if keyGen < 0 || getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)) > dirInfo.getLatestKeyIndex() {
-			continue
+	}
+	if len(present) == 0 {
+		return filenamesMap, nil
+	}
+
+	if c.throttle != nil {
+		if err := c.throttle.wait(ctx, len(present)); err != nil {
+			return nil, err
 		}
-		indexer := dirInfo.getIndexer(getNormalizedKeyIndex(libkbfs.KeyGen(keyGen)))
-		trapdoorMap[strconv.Itoa(origKeyGen)] = sserver1.Trapdoor{Codeword: indexer.ComputeTrapdoors(word)}
 	}
 
-	documents, err := c.searchCli.SearchWord(context.TODO(), sserver1.SearchWordArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMap})
+	keyGens, err := c.searchCli.GetKeyGens(ctx, dirInfo.tlfID)
 	if err != nil {
 		return nil, err
 	}
 
-	filenames := make([]string, len(documents))
-	for i, docID := range documents {
-		dirInfo.keyGenLock.RLock()
-		pathname, err := libsearch.DocIDToPathname(docID, dirInfo.pathnameKeys)
-		dirInfo.keyGenLock.RUnlock()
+	trapdoorMaps := make([]map[string]sserver1.Trapdoor, len(present))
+	for i, word := range present {
+		trapdoorMaps[i] = computeTrapdoorMap(dirInfo, keyGens, word)
+	}
+
+	results, err := c.searchCli.SearchWords(ctx, sserver1.SearchWordsArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMaps, ResultBucketSize: c.resultBucket})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(present) {
+		return nil, errors.New("mismatched number of results returned by the server")
+	}
+
+	for i, word := range present {
+		filenames, err := c.docIDsToFilenames(dirInfo, results[i])
 		if err != nil {
 			return nil, err
 		}
-		filenames[i] = filepath.Join(dirInfo.absDir, pathname)
+		filenamesMap[word] = filenames
+		c.cacheResults(dirInfo, wordCacheKey(word), filenames)
+		dirInfo.termStats.record(word, len(filenames))
 	}
 
-	sort.Strings(filenames)
+	return filenamesMap, nil
+}
+
+// matchesWord returns the subset of `files` that have an exact match (cases
+// ignored) of `word` as a whole word, sorted, or the error of `ctx` if it is
+// done before all the files are scanned.
+func (c *Client) matchesWord(ctx context.Context, files []string, word string) ([]string, error) {
+	return c.verifiedFiles(ctx, files, func(filename string) bool {
+		return containsWord(filename, word)
+	})
+}
+
+// SearchWordStrict is similar to `SearchWord`, but it eliminates the possible
+// false positives by scanning the files for the `word`, which must have an
+// exact match (cases ignored) as a whole word in the file.  The files are
+// scanned concurrently as set by `SetVerifyWorkers`, and the scan stops with
+// the error of `ctx` once it is canceled.
+func (c *Client) SearchWordStrict(ctx context.Context, directory, word string) ([]string, error) {
+	files, err := c.SearchWord(ctx, directory, word)
+	if err != nil {
+		return nil, err
+	}
+	return c.matchesWord(ctx, files, word)
+}
+
+// SearchWordsStrict is similar to `SearchWords`, but it eliminates the possible
+// false positives for each of the `words` as `SearchWordStrict` does.
+func (c *Client) SearchWordsStrict(ctx context.Context, directory string, words []string) (map[string][]string, error) {
+	filesMap, err := c.SearchWords(ctx, directory, words)
+	if err != nil {
+		return nil, err
+	}
+	for word, files := range filesMap {
+		if filesMap[word], err = c.matchesWord(ctx, files, word); err != nil {
+			return nil, err
+		}
+	}
+	return filesMap, nil
+}
+
+// SearchQuery searches for the files in `directory` matching all the terms of
+// `query`, where each term is either a word, a metadata keyword such as
+// "ext:pdf", "size:small" or "year:2023", or two words joined by "NEAR", e.g.
+// "alpha NEAR beta", matching the files where they are close together as set
+// by `SetNearWindow`, or a tag keyword such as "tag:urgent", matching the
+// files tagged with `AddTags`.  The terms are sent as a single conjunctive
+// query, so that the search server only returns the documents matching all of
+// them, apart from the tag keywords, which are sent as a conjunction of their
+// own as the tags are indexed apart from the content.
+// NOTE: False positives are possible.
+func (c *Client) SearchQuery(ctx context.Context, directory, query string) ([]string, error) {
+	defer c.metrics.searched(time.Now())
+	terms := libsearch.ParseNearQuery(strings.Fields(query))
+	if len(terms) == 0 {
+		return nil, errors.New("empty query")
+	}
+
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+	return c.searchTerms(ctx, dirInfo, terms)
+}
+
+// searchTerms searches for the files of the directory of `dirInfo` matching all
+// the `terms`.  The tag keywords only match the auxiliary indexes of the tags,
+// and the other terms the indexes of the content, so each of them is searched
+// as a conjunction of its own and the results are intersected.
+func (c *Client) searchTerms(ctx context.Context, dirInfo *DirectoryInfo, terms []string) ([]string, error) {
+	tags, others := splitTagTerms(terms)
+	if len(tags) == 0 || len(others) == 0 {
+		return c.searchConjunction(ctx, dirInfo, terms)
+	}
+	tagged, err := c.searchConjunction(ctx, dirInfo, tags)
+	if err != nil || len(tagged) == 0 {
+		return tagged, err
+	}
+	matching, err := c.searchConjunction(ctx, dirInfo, others)
+	if err != nil {
+		return nil, err
+	}
+	isTagged := make(map[string]bool, len(tagged))
+	for _, filename := range tagged {
+		isTagged[filename] = true
+	}
+	filenames := []string{}
+	for _, filename := range matching {
+		if isTagged[filename] {
+			filenames = append(filenames, filename)
+		}
+	}
 	return filenames, nil
 }
 
-// SearchWordStrict is similar to `SearchWord`, but it uses a `grep` command to
-// eliminate the possible false positives.  The `word` must have an exact match
-// (cases ignored) in the file.
-func (c *Client) SearchWordStrict(directory, word string) ([]string, error) {
-	files, err := c.SearchWord(directory, word)
+// searchConjunction searches for the files of the directory of `dirInfo`
+// matching all the `terms`, whose trapdoors are computed up front and sent as
+// a single conjunctive query, ordered by `planConjunction`.
+func (c *Client) searchConjunction(ctx context.Context, dirInfo *DirectoryInfo, terms []string) ([]string, error) {
+	cacheKey := queryCacheKey(terms)
+	terms = planConjunction(dirInfo, terms)
+	// A conjunction with a term in none of the files matches none of them,
+	// which is checked from the rarest term on.
+	for _, term := range terms {
+		if c.absentFromSummary(ctx, dirInfo, term) {
+			return []string{}, nil
+		}
+		if filenames, ok := c.cachedResults(dirInfo, wordCacheKey(term)); ok && len(filenames) == 0 {
+			return []string{}, nil
+		}
+	}
+	if filenames, ok := c.cachedResults(dirInfo, cacheKey); ok {
+		return filenames, nil
+	}
+
+	if c.throttle != nil {
+		if err := c.throttle.wait(ctx, len(terms)); err != nil {
+			return nil, err
+		}
+	}
+
+	keyGens, err := c.searchCli.GetKeyGens(ctx, dirInfo.tlfID)
 	if err != nil {
 		return nil, err
 	}
-	args := make([]string, len(files)+2)
-	args[0] = "-ilZw"
-	args[1] = word
-	copy(args[2:], files[:])
-	output, _ := exec.Command("grep", args...).Output()
-	filenames := strings.Split(string(output), "\x00")
-	filenames = filenames[:len(filenames)-1]
 
-	sort.Strings(filenames)
+	trapdoorMaps := make([]map[string]sserver1.Trapdoor, len(terms))
+	for i, term := range terms {
+		trapdoorMaps[i] = computeTrapdoorMap(dirInfo, keyGens, term)
+	}
+
+	documents, err := c.searchCli.SearchConjunction(ctx, sserver1.SearchConjunctionArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoorMaps, ResultBucketSize: c.resultBucket})
+	if err != nil {
+		return nil, err
+	}
+
+	filenames, err := c.docIDsToFilenames(dirInfo, documents)
+	if err != nil {
+		return nil, err
+	}
+	c.cacheResults(dirInfo, cacheKey, filenames)
+	if len(terms) == 1 {
+		dirInfo.termStats.record(terms[0], len(filenames))
+	}
+	return filenames, nil
+}
+
+// uniqueWords returns the distinct `words`, in the order of their first
+// occurrence.
+func uniqueWords(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	unique := make([]string, 0, len(words))
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			unique = append(unique, word)
+		}
+	}
+	return unique
+}
+
+// SearchWordsAll searches for the files in `directory` possibly containing all
+// the `words`.  The trapdoors of the words are computed up front and sent as a
+// single conjunctive query, so that the search server only returns the
+// documents matching all of them, and the caller does not intersect the
+// results of each word.
+// NOTE: False positives are possible.
+func (c *Client) SearchWordsAll(ctx context.Context, directory string, words []string) ([]string, error) {
+	defer c.metrics.searched(time.Now())
+	if len(words) == 0 {
+		return nil, errors.New("no word to search for")
+	}
 
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+	return c.searchTerms(ctx, dirInfo, uniqueWords(words))
+}
+
+// SearchWordsAny searches for the files in `directory` possibly containing any
+// of the `words`.  The words are searched in a single round trip to the search
+// server as by `SearchWords`, skipping the ones in none of the files or whose
+// results are cached, and the union of their results is returned, sorted in
+// increasing order.
+// NOTE: False positives are possible.
+func (c *Client) SearchWordsAny(ctx context.Context, directory string, words []string) ([]string, error) {
+	if len(words) == 0 {
+		return nil, errors.New("no word to search for")
+	}
+	filenamesMap, err := c.SearchWords(ctx, directory, uniqueWords(words))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	filenames := []string{}
+	for _, results := range filenamesMap {
+		for _, filename := range results {
+			if !seen[filename] {
+				seen[filename] = true
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	sort.Strings(filenames)
 	return filenames, nil
 }
 
+// matchesMetadata returns the subset of `files` whose current metadata have
+// the metadata `keyword`.
+func matchesMetadata(files []string, keyword string) []string {
+	var filenames []string
+	for _, filename := range files {
+		fileInfo, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		for _, metadata := range libsearch.ComputeMetadataKeywords(fileInfo.Name(), fileInfo.Size(), fileInfo.ModTime()) {
+			if metadata == keyword {
+				filenames = append(filenames, filename)
+				break
+			}
+		}
+	}
+	return filenames
+}
+
+// matchesNear returns the subset of `files` where the normalized words `a` and
+// `b` appear within `window` words of each other, sorted, or the error of `ctx`
+// if it is done before all the files are scanned.
+func (c *Client) matchesNear(ctx context.Context, files []string, a, b string, window int) ([]string, error) {
+	return c.verifiedFiles(ctx, files, func(filename string) bool {
+		return containsNear(filename, a, b, window)
+	})
+}
+
+// SearchQueryStrict is similar to `SearchQuery`, but eliminates the possible
+// false positives by scanning the files for the words, the tag keywords
+// against the tags of the files, the other metadata keywords against the
+// current metadata of the files, and the words joined by
+// "NEAR" against the content of the files with the window set by
+// `SetNearWindow`, or `libsearch.DefaultNearWindow` if none.
+func (c *Client) SearchQueryStrict(ctx context.Context, directory, query string) ([]string, error) {
+	files, err := c.SearchQuery(ctx, directory, query)
+	if err != nil {
+		return nil, err
+	}
+	window := c.dirParams.nearWindow
+	if window == 0 {
+		window = libsearch.DefaultNearWindow
+	}
+	for _, term := range libsearch.ParseNearQuery(strings.Fields(query)) {
+		if len(files) == 0 {
+			break
+		}
+		if keyword, ok := libsearch.ParseMetadataKeyword(term); ok && isTagKeyword(term) {
+			if files, err = matchesTag(directory, files, keyword); err != nil {
+				return nil, err
+			}
+		} else if ok {
+			files = matchesMetadata(files, keyword)
+		} else if a, b, ok := libsearch.ParseNearKeyword(term); ok {
+			if files, err = c.matchesNear(ctx, files, a, b, window); err != nil {
+				return nil, err
+			}
+		} else if files, err = c.matchesWord(ctx, files, term); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
 // updateKeys fetches the new master secrets from `currKeyGen` to `newKeyGen`.
 func (c *Client) updateKeys(dirInfo *DirectoryInfo, newKeyGen, currKeyGen libkbfs.KeyGen) {
 	dirInfo.keyGenLock.Lock()
@@ -409,7 +1214,7 @@ func (c *Client) updateKeys(dirInfo *DirectoryInfo, newKeyGen, currKeyGen libkbf
 		if err != nil {
 			return
 		}
-		dirInfo.indexers = append(dirInfo.indexers, libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, dirInfo.tlfInfo.Salts, uint64(dirInfo.tlfInfo.Size)))
+		dirInfo.indexers = append(dirInfo.indexers, newIndexer(masterSecret, dirInfo.tlfInfo, dirInfo.blinding, dirInfo.nearWindow))
 		var pathnameKey [32]byte
 		copy(pathnameKey[:], masterSecret[0:32])
 		dirInfo.pathnameKeys = append(dirInfo.pathnameKeys, pathnameKey)
@@ -417,22 +1222,70 @@ func (c *Client) updateKeys(dirInfo *DirectoryInfo, newKeyGen, currKeyGen libkbf
 	}
 }
 
-// periodicKeyGenCheck checks every hour and updates the master secrets if a
-// rekey has occurred.
+// refreshKeys checks the latest key generation of the directory of `dirInfo`
+// and updates the master secrets if a rekey has occurred.
+func (c *Client) refreshKeys(dirInfo *DirectoryInfo) {
+	_, newKeyGen, err := getTlfIDAndKeyGen(dirInfo.absDir)
+	if err != nil {
+		return
+	}
+	dirInfo.keyGenLock.RLock()
+	currKeyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	if newKeyGen > currKeyGen {
+		c.updateKeys(dirInfo, newKeyGen, currKeyGen)
+	}
+}
+
+// Shutdown stops the background loops of the client.  Safe to call more than
+// once.
+func (c *Client) Shutdown() {
+	c.shutdownOnce.Do(func() {
+		close(c.shutdownCh)
+	})
+}
+
+// isShutdown returns whether the client has been shut down.
+func (c *Client) isShutdown() bool {
+	select {
+	case <-c.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close shuts the client down, sends the uploads held back by the padding
+// policies and closes the connection to the search server.  The scans running
+// in the background stop after the directory they are processing, so callers
+// wanting them to record the time of their last scan should wait for
+// `PeriodicAdd`, `WatchFiles` and `PeriodicReindexStale` to return before
+// calling `Close`.  The connection is closed even if the flush fails, in which
+// case the error is returned.
+func (c *Client) Close() error {
+	c.Shutdown()
+	err := c.Flush(context.Background())
+	if c.conn != nil {
+		c.conn.Shutdown()
+	}
+	if c.standby != nil && c.standby.conn != nil {
+		c.standby.conn.Shutdown()
+	}
+	return err
+}
+
+// periodicKeyGenCheck checks the `.kbfs_status` files every
+// `keyGenCheckInterval` and updates the master secrets if a rekey has
+// occurred, until the client is shut down.
 func (c *Client) periodicKeyGenCheck() {
 	for {
-		time.Sleep(time.Hour)
-		for _, dirInfo := range c.directoryInfos {
-			_, newKeyGen, err := getTlfIDAndKeyGen(dirInfo.absDir)
-			if err != nil {
-				continue
-			}
-			dirInfo.keyGenLock.RLock()
-			currKeyGen := dirInfo.keyGen
-			dirInfo.keyGenLock.RUnlock()
-			if newKeyGen > currKeyGen {
-				c.updateKeys(dirInfo, newKeyGen, currKeyGen)
-			}
+		select {
+		case <-c.clock.After(keyGenCheckInterval):
+		case <-c.shutdownCh:
+			return
+		}
+		for _, dirInfo := range c.getDirectoryInfos() {
+			c.refreshKeys(dirInfo)
 		}
 	}
 }
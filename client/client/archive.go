@@ -0,0 +1,49 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// parseArchiveFolders parses the comma-separated archive folders of
+// `-archive_folders`, each either the name of the folders anywhere in the
+// client directories, or a path relative to a client directory.
+func parseArchiveFolders(value string) []string {
+	var archives []string
+	for _, archive := range strings.Split(value, ",") {
+		if archive = strings.TrimSpace(archive); archive != "" {
+			archives = append(archives, filepath.Clean(archive))
+		}
+	}
+	return archives
+}
+
+// isArchived returns whether the file at `path`, in the client directory
+// `directory`, is within one of the `archives`: under the archive relative to
+// the client directory if it holds a separator, or in any folder of that name,
+// regardless of the case, otherwise.
+func isArchived(archives []string, directory, path string) bool {
+	relPath, err := filepath.Rel(directory, path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return false
+	}
+	folders := strings.Split(filepath.Dir(relPath), string(filepath.Separator))
+	for _, archive := range archives {
+		if strings.ContainsRune(archive, filepath.Separator) {
+			if strings.HasPrefix(relPath, archive+string(filepath.Separator)) {
+				return true
+			}
+			continue
+		}
+		for _, folder := range folders {
+			if strings.EqualFold(folder, archive) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestIsArchived tests the `parseArchiveFolders` and `isArchived` functions.
+// Checks that a file is archived in a folder named like an archive at any
+// depth, or under an archive given by its relative path, and that the archived
+// files are left out of the results by the filter.
+func TestIsArchived(t *testing.T) {
+	archives := parseArchiveFolders(" Trash, old/reports/ ,,")
+	if !reflect.DeepEqual([]string{"Trash", "old/reports"}, archives) {
+		t.Fatalf("incorrect archive folders parsed: %v", archives)
+	}
+	dir := "/keybase/private/alice"
+	tests := map[string]bool{
+		dir + "/Trash/a.txt":               true,
+		dir + "/notes/trash/b.txt":         true,
+		dir + "/old/reports/2015/c.txt":    true,
+		dir + "/old/reports.txt":           false,
+		dir + "/notes/old/reports/d.txt":   false,
+		dir + "/Trash.txt":                 false,
+		"/keybase/private/bob/Trash/e.txt": false,
+	}
+	for path, expected := range tests {
+		if isArchived(archives, dir, path) != expected {
+			t.Fatalf("incorrect archived state of %s: expected %v", path, expected)
+		}
+	}
+
+	results := []searchResult{{"word", dir, dir + "/Trash/a.txt"}, {"word", dir, dir + "/a.txt"}}
+	filtered := timeFilter{archives: archives}.apply(results)
+	if len(filtered) != 1 || filtered[0].Path != dir+"/a.txt" {
+		t.Fatalf("archived file not filtered out: %v", filtered)
+	}
+	if len(timeFilter{}.apply(results)) != 2 {
+		t.Fatalf("archived file filtered out without archive folders")
+	}
+}
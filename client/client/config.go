@@ -0,0 +1,177 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"gopkg.in/yaml.v2"
+)
+
+// dirConfig holds the section of a directory in the config file.  The
+// parameters left unset fall back to the top-level ones.
+type dirConfig struct {
+	Path         string   `yaml:"path"`
+	LenMS        *int     `yaml:"len_ms"`
+	LenSalt      *int     `yaml:"len_salt"`
+	FpRate       *float64 `yaml:"fp_rate"`
+	NumUniqWords *uint64  `yaml:"num_words"`
+	EncryptSalts *bool    `yaml:"encrypt_salts"`
+	IndexType    *string  `yaml:"index_type"`
+}
+
+// config is the content of the config file of the client.  The top-level keys
+// are the names of the command line flags, e.g. `port: 8022`, along with the
+// `directories` sections.
+type config struct {
+	Directories []dirConfig            `yaml:"directories"`
+	Flags       map[string]interface{} `yaml:",inline"`
+}
+
+// indexParams are the parameters the indexes of a directory are built with.
+// The directories sharing the same parameters are served by the same client.
+type indexParams struct {
+	lenMS        int
+	lenSalt      int
+	fpRate       float64
+	numUniqWords uint64
+	encryptSalts bool
+	indexType    string
+}
+
+// parseIndexType parses the name of an index type, e.g. "cuckoo", as passed to
+// `-index_type`.
+func parseIndexType(name string) (sserver1.IndexType, error) {
+	indexType, ok := sserver1.IndexTypeMap[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown index type \"%s\"", name)
+	}
+	return indexType, nil
+}
+
+// parseBlindingPolicy parses the name of a blinding policy, e.g. "size_bucket"
+// or "percent:10", as passed to `-blinding`.
+func parseBlindingPolicy(name string) (libsearch.BlindingPolicy, error) {
+	switch {
+	case name == "length":
+		return libsearch.LengthBlinding{}, nil
+	case name == "size_bucket":
+		return libsearch.SizeBucketBlinding{}, nil
+	case strings.HasPrefix(name, "percent:"):
+		percent, err := strconv.ParseFloat(strings.TrimPrefix(name, "percent:"), 64)
+		if err != nil || percent < 0 {
+			return nil, fmt.Errorf("invalid percentage in \"%s\"", name)
+		}
+		return libsearch.PercentageBlinding{Percent: percent}, nil
+	default:
+		return nil, fmt.Errorf("unknown blinding policy \"%s\"", name)
+	}
+}
+
+// dirGroup is a group of directories sharing the same index parameters.
+type dirGroup struct {
+	params      indexParams
+	directories []string
+}
+
+// loadConfig reads the config file at `path`.  A missing file yields an empty
+// config, unless `mustExist` is set.
+func loadConfig(path string, mustExist bool) (*config, error) {
+	cfg := new(config)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// setFlags returns the names of the flags set on the command line.
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfig sets the flags not set on the command line to their values in
+// `cfg`, so that the command line overrides the config file.
+func applyConfig(cfg *config, cmdline map[string]bool) error {
+	for name, value := range cfg.Flags {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown config key \"%s\"", name)
+		}
+		if cmdline[name] {
+			continue
+		}
+		if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value for the config key \"%s\": %s", name, err)
+		}
+	}
+	return nil
+}
+
+// groupDirectories returns the directories to index, grouped by their index
+// parameters.  The directories of `-client_dirs` use the parameters of the
+// flags.  Unless `-client_dirs` is set on the command line, the directories of
+// the sections of `cfg` are added, with their own parameters overriding the
+// flags not set on the command line.
+func groupDirectories(cfg *config, cmdline map[string]bool) []dirGroup {
+	defaults := indexParams{*lenMS, *lenSalt, *fpRate, *numUniqWords, *encryptSalts, *indexType}
+	var groups []dirGroup
+	add := func(params indexParams, directory string) {
+		for i := range groups {
+			if groups[i].params == params {
+				groups[i].directories = append(groups[i].directories, directory)
+				return
+			}
+		}
+		groups = append(groups, dirGroup{params: params, directories: []string{directory}})
+	}
+
+	if *clientDirectories != "" {
+		for _, directory := range strings.Split(*clientDirectories, ";") {
+			add(defaults, directory)
+		}
+	}
+	if cmdline["client_dirs"] {
+		return groups
+	}
+	for _, section := range cfg.Directories {
+		params := defaults
+		if section.LenMS != nil && !cmdline["len_ms"] {
+			params.lenMS = *section.LenMS
+		}
+		if section.LenSalt != nil && !cmdline["len_salt"] {
+			params.lenSalt = *section.LenSalt
+		}
+		if section.FpRate != nil && !cmdline["fp_rate"] {
+			params.fpRate = *section.FpRate
+		}
+		if section.NumUniqWords != nil && !cmdline["num_words"] {
+			params.numUniqWords = *section.NumUniqWords
+		}
+		if section.EncryptSalts != nil && !cmdline["encrypt_salts"] {
+			params.encryptSalts = *section.EncryptSalts
+		}
+		if section.IndexType != nil && !cmdline["index_type"] {
+			params.indexType = *section.IndexType
+		}
+		add(params, section.Path)
+	}
+	return groups
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// TestConfig tests the `loadConfig`, `applyConfig` and `groupDirectories`
+// functions.  Checks that the config file sets the flags not set on the
+// command line, and that the directories are grouped by their parameters,
+// with the command line overriding the per-directory sections.
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestConfig")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		for _, name := range []string{"port", "len_ms", "len_salt", "client_dirs"} {
+			f := flag.Lookup(name)
+			f.Value.Set(f.DefValue)
+		}
+	}()
+
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `
+port: 9000
+len_salt: 24
+directories:
+  - path: /keybase/private/alice
+  - path: /keybase/private/alice,bob
+    fp_rate: 0.001
+    len_ms: 48
+  - path: /keybase/public/alice
+    index_type: cuckoo
+`
+	if err := ioutil.WriteFile(configPath, []byte(content), 0666); err != nil {
+		t.Fatalf("error when writing the config file: %s", err)
+	}
+	if _, err := loadConfig(filepath.Join(dir, "missing.yaml"), true); err == nil {
+		t.Fatalf("no error for a missing config file")
+	}
+	if cfg, err := loadConfig(filepath.Join(dir, "missing.yaml"), false); err != nil || len(cfg.Directories) != 0 {
+		t.Fatalf("incorrect config for a missing default config file: %v, %v", cfg, err)
+	}
+	cfg, err := loadConfig(configPath, true)
+	if err != nil {
+		t.Fatalf("error when loading the config file: %s", err)
+	}
+
+	flag.Set("port", "8023")
+	flag.Set("len_ms", "40")
+	cmdline := map[string]bool{"port": true, "len_ms": true}
+	if err := applyConfig(cfg, cmdline); err != nil {
+		t.Fatalf("error when applying the config: %s", err)
+	}
+	if *port != 8023 || *lenSalt != 24 {
+		t.Fatalf("incorrect flags: port %d, len_salt %d", *port, *lenSalt)
+	}
+
+	defaults := indexParams{40, 24, 0.000001, 100000, false, "bloom"}
+	custom := defaults
+	custom.fpRate = 0.001
+	cuckoo := defaults
+	cuckoo.indexType = "cuckoo"
+	expected := []dirGroup{
+		{defaults, []string{"/keybase/private/alice"}},
+		{custom, []string{"/keybase/private/alice,bob"}},
+		{cuckoo, []string{"/keybase/public/alice"}},
+	}
+	if groups := groupDirectories(cfg, cmdline); !reflect.DeepEqual(expected, groups) {
+		t.Fatalf("incorrect directory groups: expected %v actual %v", expected, groups)
+	}
+
+	flag.Set("client_dirs", "/keybase/private/bob")
+	cmdline["client_dirs"] = true
+	expected = []dirGroup{{defaults, []string{"/keybase/private/bob"}}}
+	if groups := groupDirectories(cfg, cmdline); !reflect.DeepEqual(expected, groups) {
+		t.Fatalf("incorrect directory groups: expected %v actual %v", expected, groups)
+	}
+
+	cfg.Flags["no_such_flag"] = 1
+	if err := applyConfig(cfg, cmdline); err == nil {
+		t.Fatalf("no error for an unknown config key")
+	}
+}
+
+// TestParseBlindingPolicy tests the `parseBlindingPolicy` function.
+func TestParseBlindingPolicy(t *testing.T) {
+	tests := map[string]libsearch.BlindingPolicy{
+		"length":      libsearch.LengthBlinding{},
+		"size_bucket": libsearch.SizeBucketBlinding{},
+		"percent:2.5": libsearch.PercentageBlinding{Percent: 2.5},
+	}
+	for name, expected := range tests {
+		if policy, err := parseBlindingPolicy(name); err != nil || policy != expected {
+			t.Fatalf("incorrect policy parsed from \"%s\": %v, %v", name, policy, err)
+		}
+	}
+	for _, name := range []string{"", "percent:", "percent:-1", "bucket"} {
+		if _, err := parseBlindingPolicy(name); err == nil {
+			t.Fatalf("no error when parsing \"%s\"", name)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	"github.com/keybase/search/client"
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+	"golang.org/x/net/context"
+)
+
+// controlHandler implements the control interface of the daemon, which lets
+// other local processes drive the clients of the local directories.
+type controlHandler struct {
+	clients      []*client.Client // The clients of the local directories.
+	newDirClient *client.Client   // The client the directories are added to.  No directory can be added if nil.
+	indexing     *sync.WaitGroup  // Done once the background indexing of the directories has stopped.
+	startTime    time.Time        // The time the daemon started.
+	watching     bool             // Whether the directories are watched for changes.
+}
+
+// toMilliseconds converts `t` into milliseconds since the epoch, or 0 for the
+// zero time.
+func toMilliseconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Status implements the ControlInterface interface.
+func (h *controlHandler) Status(_ context.Context) (searchctl1.DaemonStatus, error) {
+	status := searchctl1.DaemonStatus{StartTime: toMilliseconds(h.startTime), Watching: h.watching}
+	for _, cli := range h.clients {
+		for _, directory := range cli.Directories() {
+			dirStatus, err := cli.GetDirectoryStatus(directory)
+			if err != nil {
+				return searchctl1.DaemonStatus{}, err
+			}
+			res := searchctl1.DirectoryStatus{
+				Directory:        dirStatus.Directory,
+				KeyGen:           int(dirStatus.KeyGen),
+				LastScanTime:     toMilliseconds(dirStatus.LastScan),
+				NumIssues:        dirStatus.NumIssues,
+				Conflicts:        dirStatus.Conflicts,
+				AnalysisMismatch: dirStatus.AnalysisMismatch,
+			}
+			if dirStatus.ScanError != nil {
+				res.ScanError = dirStatus.ScanError.Error()
+			}
+			progress, scanning, err := cli.GetScanProgress(directory)
+			if err != nil {
+				return searchctl1.DaemonStatus{}, err
+			}
+			if scanning {
+				res.Progress = &searchctl1.ScanProgress{
+					StartTime:  toMilliseconds(progress.Start),
+					Discovered: progress.Discovered,
+					Processed:  progress.Processed,
+					Bytes:      progress.Bytes,
+				}
+			}
+			status.Directories = append(status.Directories, res)
+		}
+	}
+	return status, nil
+}
+
+// ListDirs implements the ControlInterface interface.
+func (h *controlHandler) ListDirs(_ context.Context) ([]string, error) {
+	var directories []string
+	for _, cli := range h.clients {
+		directories = append(directories, cli.Directories()...)
+	}
+	return directories, nil
+}
+
+// findClient returns the client of `directory`, along with its absolute path.
+func (h *controlHandler) findClient(directory string) (*client.Client, string, error) {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, cli := range h.clients {
+		for _, clientDir := range cli.Directories() {
+			if clientDir == absDir {
+				return cli, absDir, nil
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("unknown directory \"%s\"", directory)
+}
+
+// Reindex implements the ControlInterface interface.
+func (h *controlHandler) Reindex(ctx context.Context, directory string) (searchctl1.ReindexResult, error) {
+	cli, absDir, err := h.findClient(directory)
+	if err != nil {
+		return searchctl1.ReindexResult{}, err
+	}
+	report := cli.ReindexStale(ctx, absDir)
+	return searchctl1.ReindexResult{Added: report.Added}, report.Err
+}
+
+// ListIssues implements the ControlInterface interface.
+func (h *controlHandler) ListIssues(_ context.Context, directory string) ([]searchctl1.FileIssue, error) {
+	cli, absDir, err := h.findClient(directory)
+	if err != nil {
+		return nil, err
+	}
+	issues, err := cli.GetFileIssues(absDir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]searchctl1.FileIssue, 0, len(issues))
+	for _, issue := range issues {
+		res = append(res, searchctl1.FileIssue{
+			Path:   issue.Path,
+			Reason: string(issue.Reason),
+			Error:  issue.Err,
+			Time:   toMilliseconds(issue.Time),
+		})
+	}
+	return res, nil
+}
+
+// AddDir implements the ControlInterface interface.
+func (h *controlHandler) AddDir(ctx context.Context, directory string) error {
+	if h.newDirClient == nil {
+		return errors.New("no client to add the directories to")
+	}
+	if _, _, err := h.findClient(directory); err == nil {
+		return fmt.Errorf("directory \"%s\" is already indexed", directory)
+	}
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	return addDirectory(ctx, h.newDirClient, absDir, h.indexing)
+}
+
+// RemoveDir implements the ControlInterface interface.
+func (h *controlHandler) RemoveDir(ctx context.Context, directory string) error {
+	cli, absDir, err := h.findClient(directory)
+	if err != nil {
+		return err
+	}
+	return cli.RemoveDirectory(ctx, absDir)
+}
+
+// Search implements the ControlInterface interface.
+func (h *controlHandler) Search(ctx context.Context, query string) ([]string, error) {
+	var filenames []string
+	for _, cli := range h.clients {
+		for _, directory := range cli.Directories() {
+			results, err := cli.SearchQueryStrict(ctx, directory, query)
+			if err != nil {
+				return nil, err
+			}
+			filenames = append(filenames, results...)
+		}
+	}
+	return filenames, nil
+}
+
+// Stats implements the ControlInterface interface.
+func (h *controlHandler) Stats(_ context.Context) ([]searchctl1.DirectoryStats, error) {
+	var res []searchctl1.DirectoryStats
+	for _, cli := range h.clients {
+		for _, directory := range cli.Directories() {
+			stats, err := cli.GetDirectoryStats(directory)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, searchctl1.DirectoryStats{
+				Directory:        stats.Directory,
+				IndexedFiles:     stats.IndexedFiles,
+				UploadedBytes:    stats.UploadedBytes,
+				LastScanTime:     toMilliseconds(stats.LastScan),
+				LastScanDuration: int64(stats.LastScanDuration / time.Millisecond),
+				PendingFiles:     stats.PendingFiles,
+				PendingOps:       stats.PendingOps,
+			})
+		}
+	}
+	return res, nil
+}
+
+// ResetDir implements the ControlInterface interface.
+func (h *controlHandler) ResetDir(ctx context.Context, arg searchctl1.ResetDirArg) error {
+	cli, absDir, err := h.findClient(arg.Directory)
+	if err != nil {
+		return err
+	}
+	dirty, err := cli.ResetDirectory(ctx, absDir, arg.DropIndexes)
+	if err != nil {
+		return err
+	}
+	startAddedDirectory(cli, absDir, dirty, h.indexing)
+	return nil
+}
+
+// controlSocketPath returns the path of the Unix socket of the control
+// interface, set by `-control_socket` or in the state directory by default.
+func controlSocketPath() string {
+	if *controlSocket != "" {
+		return *controlSocket
+	}
+	return filepath.Join(*stateDir, "control.sock")
+}
+
+// dialControl connects to the control interface of the daemon serving on the
+// Unix socket at `path`.  The connection is closed by the returned function.
+func dialControl(path string) (searchctl1.ControlInterface, func() error, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to the daemon on %s: %s", path, err)
+	}
+	return searchctl1.ControlClient{Cli: rpc.NewClient(rpc.NewTransport(conn, nil, nil), nil)}, conn.Close, nil
+}
+
+// serveControl serves the control interface of `handler` on the Unix socket at
+// `path` in the background.  Returns an error if another daemon is already
+// listening on the socket.
+func serveControl(path string, handler *controlHandler) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon is listening on %s", path)
+	}
+	// Removes the socket left behind by a daemon that did not shut down
+	// cleanly.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv := rpc.NewServer(rpc.NewTransport(conn, nil, nil), nil)
+			if err := srv.Register(searchctl1.ControlProtocol(handler)); err != nil {
+				conn.Close()
+				continue
+			}
+			srv.Run()
+		}
+	}()
+	return nil
+}
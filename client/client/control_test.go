@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+	"golang.org/x/net/context"
+)
+
+// TestServeControl tests the `serveControl` function.  Checks that the control
+// interface is reachable through the socket, that errors are forwarded to the
+// caller, and that a second daemon cannot serve on the same socket.
+func TestServeControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestServeControl")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "control.sock")
+	startTime := time.Unix(1500000000, 0)
+	handler := &controlHandler{startTime: startTime, watching: true}
+	if err := serveControl(socketPath, handler); err != nil {
+		t.Fatalf("error when serving the control interface: %s", err)
+	}
+	if err := serveControl(socketPath, handler); err == nil {
+		t.Fatalf("no error when serving twice on the same socket")
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("error when connecting to the control socket: %s", err)
+	}
+	defer conn.Close()
+	ctl := searchctl1.ControlClient{Cli: rpc.NewClient(rpc.NewTransport(conn, nil, nil), nil)}
+	ctx := context.Background()
+
+	status, err := ctl.Status(ctx)
+	if err != nil {
+		t.Fatalf("error when getting the status: %s", err)
+	}
+	if status.StartTime != toMilliseconds(startTime) || !status.Watching || len(status.Directories) != 0 {
+		t.Fatalf("incorrect status: %+v", status)
+	}
+	if dirs, err := ctl.ListDirs(ctx); err != nil || len(dirs) != 0 {
+		t.Fatalf("incorrect directories: %v, %v", dirs, err)
+	}
+	if _, err := ctl.Reindex(ctx, "/keybase/private/nobody"); err == nil {
+		t.Fatalf("no error when reindexing an unknown directory")
+	}
+	if _, err := ctl.ListIssues(ctx, "/keybase/private/nobody"); err == nil {
+		t.Fatalf("no error when listing the issues of an unknown directory")
+	}
+	if err := ctl.AddDir(ctx, "/keybase/private/nobody"); err == nil {
+		t.Fatalf("no error when adding a directory without a client")
+	}
+	if err := ctl.RemoveDir(ctx, "/keybase/private/nobody"); err == nil {
+		t.Fatalf("no error when removing an unknown directory")
+	}
+	if stats, err := ctl.Stats(ctx); err != nil || len(stats) != 0 {
+		t.Fatalf("incorrect statistics: %v, %v", stats, err)
+	}
+	if err := runStats(ctl, []string{"/keybase/private/nobody"}); err == nil {
+		t.Fatalf("no error when getting the statistics of an unknown directory")
+	}
+	if err := ctl.ResetDir(ctx, searchctl1.ResetDirArg{Directory: "/keybase/private/nobody", DropIndexes: true}); err == nil {
+		t.Fatalf("no error when resetting an unknown directory")
+	}
+	if err := runReindex(ctl, []string{"--drop"}); err == nil {
+		t.Fatalf("no error when reindexing no directory")
+	}
+}
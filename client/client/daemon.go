@@ -0,0 +1,241 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/keybase/search/client"
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+const (
+	// pidFileName is the name of the pidfile of the daemon within the state
+	// directory.
+	pidFileName = "daemon.pid"
+	// daemonOutputName is the name of the file within the log directory the
+	// standard output and error of a detached daemon are redirected to.
+	daemonOutputName = "daemon.out"
+	// detachedEnv is the environment variable marking the daemon started in
+	// the background by `detach`, so that it does not detach again.
+	detachedEnv = "KBFS_SEARCH_DETACHED"
+	// takeoverTimeout is how long a client taking over waits for the previous
+	// one to shut down, which first completes its scans in progress.
+	takeoverTimeout = 5 * time.Minute
+	// takeoverPollInterval is the interval between two checks of whether the
+	// previous client has shut down.
+	takeoverPollInterval = 500 * time.Millisecond
+)
+
+// pidFilePath returns the path of the pidfile of the daemon.
+func pidFilePath() string {
+	if *pidFile != "" {
+		return *pidFile
+	}
+	return filepath.Join(*stateDir, pidFileName)
+}
+
+// daemonOutputPath returns the path of the file the standard output and error
+// of a detached daemon are redirected to, or "" if they are discarded.
+func daemonOutputPath() string {
+	dir := getLogDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, daemonOutputName)
+}
+
+// isDetached returns whether the process is the daemon started in the
+// background by `detach`.
+func isDetached() bool {
+	return os.Getenv(detachedEnv) != ""
+}
+
+// processRunning returns whether the process of `pid` is running.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// takeOver asks the client of `pid` to shut down, and waits until it has or
+// `takeoverTimeout` elapses.
+func takeOver(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot ask the client with pid %d to shut down: %s", pid, err)
+	}
+	logger.Infof("Taking over from the client with pid %d, waiting for it to shut down.", pid)
+	deadline := time.Now().Add(takeoverTimeout)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the client with pid %d has not shut down after %s", pid, takeoverTimeout)
+		}
+		time.Sleep(takeoverPollInterval)
+	}
+	return nil
+}
+
+// lockDirectories locks the directories of `cli` with `LockDirectories`.  With
+// `-takeover`, the other client on this machine already indexing any of them
+// is asked to shut down, and the directories are locked once it has.
+func lockDirectories(cli *client.Client) ([]string, error) {
+	unclean, err := cli.LockDirectories(*stateDir)
+	lockedErr, ok := err.(client.DirectoryLockedError)
+	if !ok || !*takeover || lockedErr.Holder == 0 || lockedErr.Holder == os.Getpid() {
+		return unclean, err
+	}
+	if err := takeOver(lockedErr.Holder); err != nil {
+		return nil, err
+	}
+	return cli.LockDirectories(*stateDir)
+}
+
+// writePidFile writes the pid of the process to the pidfile at `path`.
+// Returns an error if the pidfile names another process still running, e.g.
+// another daemon on the same state directory, unless `-takeover` is set, in
+// which case that daemon is asked to shut down first.
+func writePidFile(path string) error {
+	if content, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err == nil && pid != os.Getpid() && processRunning(pid) {
+			if !*takeover {
+				return fmt.Errorf("the daemon is already running with pid %d", pid)
+			}
+			if err := takeOver(pid); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(path, []byte(strconv.Itoa(os.Getpid())+"\n"))
+}
+
+// configDirectories returns the index parameters of each directory of
+// `groups`, keyed by its absolute path.
+func configDirectories(groups []dirGroup) map[string]indexParams {
+	directories := make(map[string]indexParams)
+	for _, group := range groups {
+		for _, directory := range group.directories {
+			if absDir, err := filepath.Abs(directory); err == nil {
+				directories[absDir] = group.params
+			}
+		}
+	}
+	return directories
+}
+
+// diffDirectories returns the directories of `curr` that are not in `prev` or
+// have other index parameters, and the directories of `prev` that are not in
+// `curr`, both sorted.
+func diffDirectories(prev, curr map[string]indexParams) (added, removed []string) {
+	for directory, params := range curr {
+		if prevParams, ok := prev[directory]; !ok || prevParams != params {
+			added = append(added, directory)
+		}
+	}
+	for directory := range prev {
+		if _, ok := curr[directory]; !ok {
+			removed = append(removed, directory)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// configReloader applies the config file to the running daemon again on
+// SIGHUP, so that a service manager can reconfigure it without a restart.
+type configReloader struct {
+	cmdline     map[string]bool                // The flags set on the command line, which the config file does not override.
+	clients     map[indexParams]*client.Client // The local clients, by the index parameters of their directories.
+	directories map[string]indexParams         // The directories of the config file as of its last read.
+	indexing    *sync.WaitGroup                // Done once the background indexing of all the directories has stopped.
+}
+
+// reload reads the config file again and applies it: the flags not set on the
+// command line take their new values, and the directories added to or removed
+// from the config file since it was last read are added to or removed from
+// the clients of their index parameters.  The directories added through the
+// control interface are left alone.  A directory whose index parameters are
+// not those of any client, or have changed, requires a restart.
+func (r *configReloader) reload() error {
+	cfg, err := loadConfig(*configFile, r.cmdline["config"])
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(cfg, r.cmdline); err != nil {
+		return err
+	}
+	directories := configDirectories(groupDirectories(cfg, r.cmdline))
+	added, removed := diffDirectories(r.directories, directories)
+	for _, directory := range removed {
+		if err := r.clients[r.directories[directory]].RemoveDirectory(context.Background(), directory); err != nil {
+			logger.Warnf("Cannot remove directory \"%s\": %s", directory, err)
+			continue
+		}
+		logger.Infof("Removed directory \"%s\" from the config file.", directory)
+	}
+	for _, directory := range added {
+		if _, ok := r.directories[directory]; ok {
+			logger.Warnf("The index parameters of directory \"%s\" only change with a restart.", directory)
+			directories[directory] = r.directories[directory]
+			continue
+		}
+		cli, ok := r.clients[directories[directory]]
+		if !ok {
+			logger.Warnf("Directory \"%s\" is only added with a restart, as no client has its index parameters.", directory)
+			delete(directories, directory)
+			continue
+		}
+		if err := addDirectory(context.Background(), cli, directory, r.indexing); err != nil {
+			logger.Warnf("Cannot add directory \"%s\": %s", directory, err)
+			delete(directories, directory)
+			continue
+		}
+		logger.Infof("Added directory \"%s\" from the config file.", directory)
+	}
+	r.directories = directories
+	return nil
+}
+
+// serveDaemon runs the daemon without the prompt until a signal is received
+// from `signals`, reloading the config file with `reloader` on each SIGHUP.
+func serveDaemon(reloader *configReloader, signals <-chan os.Signal) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	logger.Infof("Running as a daemon with pid %d.", os.Getpid())
+	for {
+		select {
+		case <-hangups:
+			if err := reloader.reload(); err != nil {
+				logger.Errorf("Cannot reload the config file: %s", err)
+				continue
+			}
+			logger.Infof("Reloaded the config file.")
+		case <-signals:
+			return
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package main
+
+import "errors"
+
+// detach always fails on this platform, where the daemon should be run with
+// `-foreground` under the service manager instead.
+func detach(output string) (int, error) {
+	return 0, errors.New("detaching is not supported on this platform, use -foreground")
+}
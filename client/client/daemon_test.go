@@ -0,0 +1,84 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestWritePidFile tests the `writePidFile` function.  Checks that the pid of
+// the process is written, replacing a stale pidfile, and that a pidfile naming
+// another running process is left alone.
+func TestWritePidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWritePidFile")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", pidFileName)
+	if err := writePidFile(path); err != nil {
+		t.Fatalf("error when writing the pidfile: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("stale"), 0600); err != nil {
+		t.Fatalf("error when writing a stale pidfile: %s", err)
+	}
+	if err := writePidFile(path); err != nil {
+		t.Fatalf("error when replacing a stale pidfile: %s", err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil || string(content) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Fatalf("incorrect pidfile written: %q, %v", content, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0600); err != nil {
+		t.Fatalf("error when writing the pidfile of another process: %s", err)
+	}
+	if err := writePidFile(path); err == nil {
+		t.Fatalf("no error when another process holds the pidfile")
+	}
+}
+
+// TestDiffDirectories tests the `diffDirectories` function.  Checks that the
+// directories that appeared or whose index parameters changed are added, and
+// that the directories that are gone are removed.
+func TestDiffDirectories(t *testing.T) {
+	params := indexParams{lenMS: 64, lenSalt: 32, fpRate: 0.000001, numUniqWords: 100000, indexType: "bloom"}
+	otherParams := params
+	otherParams.numUniqWords = 1000000
+	prev := map[string]indexParams{"/keybase/private/alice": params, "/keybase/team/bigteam": params, "/keybase/private/bob": params}
+	curr := map[string]indexParams{"/keybase/private/alice": params, "/keybase/team/bigteam": otherParams, "/keybase/public/alice": params}
+
+	added, removed := diffDirectories(prev, curr)
+	if expected := []string{"/keybase/public/alice", "/keybase/team/bigteam"}; !reflect.DeepEqual(expected, added) {
+		t.Fatalf("incorrect directories added: expected %v actual %v", expected, added)
+	}
+	if expected := []string{"/keybase/private/bob"}; !reflect.DeepEqual(expected, removed) {
+		t.Fatalf("incorrect directories removed: expected %v actual %v", expected, removed)
+	}
+}
+
+// TestTakeOver tests the `takeOver` function.  Checks that the other process
+// is asked to shut down, and waited for.
+func TestTakeOver(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error when starting the other process: %s", err)
+	}
+	// Reaps the process once it exits, so that it is no longer running.
+	go cmd.Wait()
+	if err := takeOver(cmd.Process.Pid); err != nil {
+		t.Fatalf("error when taking over: %s", err)
+	}
+	if processRunning(cmd.Process.Pid) {
+		t.Fatalf("the other process is still running")
+	}
+}
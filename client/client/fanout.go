@@ -0,0 +1,103 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/keybase/search/client"
+	"golang.org/x/net/context"
+)
+
+// dirSearch is the search for the words of a query in a directory of a client.
+type dirSearch struct {
+	cli       *client.Client
+	directory string
+	results   map[string][]string // The files found, keyed by word.
+	elapsed   time.Duration       // How long the search took.
+	err       error               // The error of the search, if it failed.
+}
+
+// forEachBounded calls `f` with each integer from 0 to `n`-1, with up to
+// `workers` calls running at once, and returns once they have all returned.
+func forEachBounded(n, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// searchDirectories searches for the `keywords` in all the directories of the
+// `clients` within `scope` with `SearchWordsStrict`, with up to
+// `-search_workers` directories searched at once.  Returns the searches in the
+// order of the clients and of their directories, whatever the order they
+// completed in.
+func searchDirectories(ctx context.Context, clients []*client.Client, scope searchScope, keywords []string) []dirSearch {
+	var searches []dirSearch
+	for _, cli := range clients {
+		for _, directory := range scope.directories(cli) {
+			searches = append(searches, dirSearch{cli: cli, directory: directory})
+		}
+	}
+	forEachBounded(len(searches), *searchWorkers, func(i int) {
+		s := &searches[i]
+		start := time.Now()
+		s.results, s.err = s.cli.SearchWordsStrict(ctx, s.directory, keywords)
+		s.elapsed = time.Since(start)
+	})
+	return searches
+}
+
+// mergeSearches merges the results of the `searches` for each word, dropping
+// the files found by more than one of them, e.g. in a directory indexed by two
+// clients.  The results keep the order of the searches.
+func mergeSearches(searches []dirSearch) map[string][]searchResult {
+	merged := make(map[string][]searchResult)
+	seen := make(map[string]map[string]bool)
+	for _, s := range searches {
+		for keyword, filenames := range s.results {
+			if seen[keyword] == nil {
+				seen[keyword] = make(map[string]bool)
+			}
+			for _, filename := range filenames {
+				if seen[keyword][filename] {
+					continue
+				}
+				seen[keyword][filename] = true
+				merged[keyword] = append(merged[keyword], searchResult{keyword, s.directory, filename})
+			}
+		}
+	}
+	return merged
+}
+
+// reportSearchTimes logs how long each of the `searches` took, and prints it
+// out to the standard error as well with `-search_timing`.
+func reportSearchTimes(searches []dirSearch) {
+	for _, s := range searches {
+		logger.Debugf("Searched directory \"%s\" in %s", s.directory, s.elapsed)
+		if *searchTiming {
+			fmt.Fprintf(os.Stderr, "Searched \"%s\" in %s.\n", s.directory, s.elapsed.Round(time.Millisecond))
+		}
+	}
+}
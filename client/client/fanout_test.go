@@ -0,0 +1,60 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestForEachBounded tests the `forEachBounded` function.  Checks that each
+// index is handled once, with no more calls at once than the workers.
+func TestForEachBounded(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	handled := make([]int, 10)
+	forEachBounded(len(handled), 3, func(i int) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		handled[i]++
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+	})
+	for i, n := range handled {
+		if n != 1 {
+			t.Fatalf("index %d handled %d times", i, n)
+		}
+	}
+	if maxRunning > 3 || maxRunning < 2 {
+		t.Fatalf("incorrect number of calls at once: %d", maxRunning)
+	}
+}
+
+// TestMergeSearches tests the `mergeSearches` function.  Checks that the
+// results keep the order of the searches, and that a file found by two
+// searches is only listed once.
+func TestMergeSearches(t *testing.T) {
+	searches := []dirSearch{
+		{directory: "/keybase/a", results: map[string][]string{"word": {"/keybase/a/1", "/keybase/a/2"}}},
+		{directory: "/keybase/b", results: map[string][]string{"word": {"/keybase/b/1"}, "other": {"/keybase/b/2"}}},
+		{directory: "/keybase/a", results: map[string][]string{"word": {"/keybase/a/2"}}},
+	}
+	merged := mergeSearches(searches)
+	expected := map[string][]searchResult{
+		"word":  {{"word", "/keybase/a", "/keybase/a/1"}, {"word", "/keybase/a", "/keybase/a/2"}, {"word", "/keybase/b", "/keybase/b/1"}},
+		"other": {{"other", "/keybase/b", "/keybase/b/2"}},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("incorrect merged results: %v, expected %v", merged, expected)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/keybase/search/client"
+)
+
+// searchResult is a single file matching a search, as rendered by the
+// `-format` template.
+type searchResult struct {
+	Query     string // The keyword or query the file matches.
+	Directory string // The client directory the file belongs to.
+	Path      string // The absolute path of the file.
+}
+
+// Revision returns the revision of the TLF of the file if it is a prior version
+// of a file, or 0 for the current version.
+func (r searchResult) Revision() int64 {
+	_, revision, _ := client.ParseArchivedPath(r.Directory, r.Path)
+	return revision
+}
+
+// label returns the path of the file as printed out in the human-readable
+// listing, where a prior version has its current path and its revision.
+func (r searchResult) label() string {
+	if current, revision, ok := client.ParseArchivedPath(r.Directory, r.Path); ok {
+		return fmt.Sprintf("%s (revision %d)", current, revision)
+	}
+	return r.Path
+}
+
+// jsonMatch is a single file matching a query in the `-json` output.
+type jsonMatch struct {
+	Path     string `json:"path"`               // The absolute path of the file.
+	Tlf      string `json:"tlf"`                // The client directory the file belongs to.
+	Revision int64  `json:"revision,omitempty"` // The revision of the TLF of a prior version of a file.
+}
+
+// jsonResult is the `-json` output of a query.
+type jsonResult struct {
+	Word       string      `json:"word"`                 // The keyword or query searched for.
+	Matches    []jsonMatch `json:"matches"`              // The files matching the query.
+	FPEstimate float64     `json:"fp_estimate"`          // The expected number of false positives among the unverified matches, 0 if verified by the search server.
+	Unverified bool        `json:"unverified,omitempty"` // Whether the matches have been found locally while the search server was unreachable.
+}
+
+// builtinFormats are the names accepted by `-format` as shorthands for common
+// templates.
+var builtinFormats = map[string]string{
+	"paths": "{{.Path}}",
+	"tsv":   "{{.Query}}\t{{.Directory}}\t{{.Path}}",
+}
+
+// parseFormat parses `format`, either the name of a built-in format or a
+// text/template over `searchResult`, into the template the results are
+// rendered with.
+func parseFormat(format string) (*template.Template, error) {
+	if builtin, ok := builtinFormats[format]; ok {
+		format = builtin
+	}
+	return template.New("format").Parse(format)
+}
+
+// writeResults renders each of the `results` with `tmpl` to `w`, one per line.
+func writeResults(w io.Writer, tmpl *template.Template, results []searchResult) error {
+	for _, result := range results {
+		if err := tmpl.Execute(w, result); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSONResult writes the `results` of `query`, with the expected number of
+// false positives `fpEstimate`, to `w` as a single line of JSON.  The results
+// are labeled as `unverified` if found without the search server.
+func writeJSONResult(w io.Writer, query string, results []searchResult, fpEstimate float64, unverified bool) error {
+	res := jsonResult{Word: query, Matches: make([]jsonMatch, 0, len(results)), FPEstimate: fpEstimate, Unverified: unverified}
+	for _, result := range results {
+		res.Matches = append(res.Matches, jsonMatch{Path: result.Path, Tlf: result.Directory, Revision: result.Revision()})
+	}
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWriteResults tests the `parseFormat` and `writeResults` functions.
+// Checks that the built-in and custom formats render one line per result, and
+// that an invalid template is rejected.
+func TestWriteResults(t *testing.T) {
+	results := []searchResult{
+		{"hello", "/keybase/private/alice", "/keybase/private/alice/a.txt"},
+		{"hello", "/keybase/private/bob", "/keybase/private/bob/b.txt"},
+	}
+	expected := map[string]string{
+		"paths":                      "/keybase/private/alice/a.txt\n/keybase/private/bob/b.txt\n",
+		"tsv":                        "hello\t/keybase/private/alice\t/keybase/private/alice/a.txt\nhello\t/keybase/private/bob\t/keybase/private/bob/b.txt\n",
+		"{{.Directory}}: {{.Query}}": "/keybase/private/alice: hello\n/keybase/private/bob: hello\n",
+	}
+	for format, output := range expected {
+		tmpl, err := parseFormat(format)
+		if err != nil {
+			t.Fatalf("error when parsing the format %q: %s", format, err)
+		}
+		var buf bytes.Buffer
+		if err := writeResults(&buf, tmpl, results); err != nil {
+			t.Fatalf("error when writing the results with %q: %s", format, err)
+		}
+		if buf.String() != output {
+			t.Fatalf("incorrect output for %q: expected %q actual %q", format, output, buf.String())
+		}
+	}
+
+	if _, err := parseFormat("{{.Path"); err == nil {
+		t.Fatalf("no error for an invalid template")
+	}
+	tmpl, _ := parseFormat("{{.Size}}")
+	if err := writeResults(&bytes.Buffer{}, tmpl, results); err == nil {
+		t.Fatalf("no error for an unknown field")
+	}
+}
+
+// TestWriteJSONResult tests the `writeJSONResult` function.  Checks that the
+// results of a query are written as a single line of JSON, with an empty list
+// of matches if nothing matches, and labeled if unverified.
+func TestWriteJSONResult(t *testing.T) {
+	results := []searchResult{
+		{"hello", "/keybase/private/alice", "/keybase/private/alice/a.txt"},
+	}
+	var buf bytes.Buffer
+	if err := writeJSONResult(&buf, "hello", results, 0.25, false); err != nil {
+		t.Fatalf("error when writing the JSON result: %s", err)
+	}
+	if err := writeJSONResult(&buf, "world", nil, 0, false); err != nil {
+		t.Fatalf("error when writing the JSON result: %s", err)
+	}
+	if err := writeJSONResult(&buf, "offline", results[:0], 0, true); err != nil {
+		t.Fatalf("error when writing the JSON result: %s", err)
+	}
+	expected := `{"word":"hello","matches":[{"path":"/keybase/private/alice/a.txt","tlf":"/keybase/private/alice"}],"fp_estimate":0.25}
+{"word":"world","matches":[],"fp_estimate":0}
+{"word":"offline","matches":[],"fp_estimate":0,"unverified":true}
+`
+	if buf.String() != expected {
+		t.Fatalf("incorrect JSON output: %s", buf.String())
+	}
+}
+
+// TestSearchResultRevision tests the `Revision` and `label` methods of
+// `searchResult`.  Checks that a prior version of a file is labeled with its
+// current path and its revision, in the listing and in the JSON, while the
+// current version is left as is.
+func TestSearchResultRevision(t *testing.T) {
+	current := searchResult{"hello", "/keybase/private/alice", "/keybase/private/alice/a.txt"}
+	prior := searchResult{"hello", "/keybase/private/alice", "/keybase/private/alice/.kbfs_archived_rev=42/a.txt"}
+	if current.Revision() != 0 || current.label() != current.Path {
+		t.Fatalf("current version labeled: %d, %s", current.Revision(), current.label())
+	}
+	if prior.Revision() != 42 || prior.label() != "/keybase/private/alice/a.txt (revision 42)" {
+		t.Fatalf("incorrect label of the prior version: %d, %s", prior.Revision(), prior.label())
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSONResult(&buf, "hello", []searchResult{prior}, 0, false); err != nil {
+		t.Fatalf("error when writing the JSON result: %s", err)
+	}
+	expected := `{"word":"hello","matches":[{"path":"/keybase/private/alice/.kbfs_archived_rev=42/a.txt","tlf":"/keybase/private/alice","revision":42}],"fp_estimate":0}
+`
+	if buf.String() != expected {
+		t.Fatalf("incorrect JSON output: %s", buf.String())
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of lines kept in the history of the prompt.
+const maxHistory = 1000
+
+// lineEditor reads the lines typed at the search prompt with basic editing:
+// the cursor moves with the arrows and the usual Emacs keys, the previous
+// lines are recalled with the up and down arrows, and Tab completes the word
+// under the cursor with the words searched before and the directory names.
+// The history is kept in a file across the runs of the client.
+type lineEditor struct {
+	in          *bufio.Reader   // The keys typed.
+	out         io.Writer       // The terminal the line is echoed to.
+	prompt      string          // The prompt printed out before the line.
+	terminal    *os.File        // The terminal put in raw mode while a line is read.  Nil if the keys are not read from a terminal.
+	historyPath string          // The file the history is kept in.  Not kept if empty.
+	history     []string        // The lines entered, oldest first.
+	names       func() []string // Returns the names completed besides the words of the history.
+
+	lock    sync.Mutex // Protects `restore`.
+	restore func()     // Restores the state of `terminal`, if in raw mode.
+}
+
+// newLineEditor creates a `lineEditor` reading the keys from `in` and echoing
+// the line to `out` after `prompt`, with the history loaded from
+// `historyPath`, and completing the `names` in addition to the previous words.
+// The lines are read without editing if `in` is not a terminal.
+func newLineEditor(in *os.File, out io.Writer, prompt, historyPath string, names func() []string) (*lineEditor, error) {
+	e := &lineEditor{in: bufio.NewReader(in), out: out, prompt: prompt, historyPath: historyPath, names: names}
+	if restore, err := makeRaw(in.Fd()); err == nil {
+		restore()
+		e.terminal = in
+	}
+	if err := e.loadHistory(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// loadHistory reads the history from `historyPath`, trimming the file down to
+// the last `maxHistory` lines.
+func (e *lineEditor) loadHistory() error {
+	if e.historyPath == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(e.historyPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	e.history = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(e.history) == 1 && e.history[0] == "" {
+		e.history = nil
+	}
+	if len(e.history) <= maxHistory {
+		return nil
+	}
+	e.history = e.history[len(e.history)-maxHistory:]
+	return ioutil.WriteFile(e.historyPath, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+}
+
+// addHistory appends `line` to the history, unless it repeats the last line.
+func (e *lineEditor) addHistory(line string) error {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return nil
+	}
+	e.history = append(e.history, line)
+	if e.historyPath == "" {
+		return nil
+	}
+	file, err := os.OpenFile(e.historyPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// completions returns the candidates starting with `prefix`, sorted: the
+// words of the history, and the names.
+func (e *lineEditor) completions(prefix string) []string {
+	seen := make(map[string]bool)
+	var candidates []string
+	add := func(candidate string) {
+		if strings.HasPrefix(candidate, prefix) && candidate != prefix && !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	for _, line := range e.history {
+		for _, word := range strings.Fields(line) {
+			add(word)
+		}
+	}
+	if e.names != nil {
+		for _, name := range e.names() {
+			add(name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// commonPrefix returns the longest common prefix of the `candidates`, cut
+// between two characters.
+func commonPrefix(candidates []string) string {
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// readLine prints out the prompt and reads a line, returning it without the
+// newline once entered.  Returns `io.EOF` on the end of the input, or on
+// Ctrl-D with an empty line.
+func (e *lineEditor) readLine() (string, error) {
+	if e.terminal == nil {
+		fmt.Fprint(e.out, e.prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\n")
+		return line, e.addHistory(line)
+	}
+
+	e.lock.Lock()
+	restore, err := makeRaw(e.terminal.Fd())
+	e.restore = restore
+	e.lock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	defer e.restoreTerminal()
+	return e.edit()
+}
+
+// readKey reads a single key typed at the terminal, without echoing it, e.g. to
+// page through the results.  Fails if the keys are not read from a terminal.
+func (e *lineEditor) readKey() (rune, error) {
+	if e.terminal == nil {
+		return 0, errors.New("the keys are not read from a terminal")
+	}
+	e.lock.Lock()
+	restore, err := makeRaw(e.terminal.Fd())
+	e.restore = restore
+	e.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	defer e.restoreTerminal()
+	r, _, err := e.in.ReadRune()
+	return r, err
+}
+
+// edit reads the keys typed until a line is entered, editing the line and
+// echoing it as the keys come in.
+func (e *lineEditor) edit() (string, error) {
+	var line []rune
+	pos := 0
+	// The line being edited is kept aside while browsing the history.
+	historyPos := len(e.history)
+	var edited []rune
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) || i == historyPos {
+			return
+		}
+		if historyPos == len(e.history) {
+			edited = line
+		}
+		historyPos = i
+		if i == len(e.history) {
+			line = edited
+		} else {
+			line = []rune(e.history[i])
+		}
+		pos = len(line)
+	}
+	insert := func(s []rune) {
+		line = append(line[:pos], append(s, line[pos:]...)...)
+		pos += len(s)
+	}
+	complete := func() {
+		start := pos
+		for start > 0 && !unicode.IsSpace(line[start-1]) {
+			start--
+		}
+		candidates := e.completions(string(line[start:pos]))
+		switch {
+		case len(candidates) == 1:
+			insert([]rune(strings.TrimPrefix(candidates[0], string(line[start:pos])) + " "))
+		case len(candidates) > 1:
+			if prefix := commonPrefix(candidates); prefix != string(line[start:pos]) {
+				insert([]rune(strings.TrimPrefix(prefix, string(line[start:pos]))))
+			} else {
+				fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+			}
+		}
+	}
+
+	redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprintln(e.out)
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprintln(e.out)
+			return string(line), e.addHistory(string(line))
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprintln(e.out)
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(line) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 21: // Ctrl-U
+			line = append([]rune(nil), line[pos:]...)
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case 16: // Ctrl-P
+			recall(historyPos - 1)
+		case 14: // Ctrl-N
+			recall(historyPos + 1)
+		case '\t':
+			complete()
+		case 27: // Escape sequence of an arrow or another special key.
+			if next, _, err := e.in.ReadRune(); err != nil || (next != '[' && next != 'O') {
+				continue
+			}
+			key, _, err := e.in.ReadRune()
+			if err != nil {
+				continue
+			}
+			switch key {
+			case 'A':
+				recall(historyPos - 1)
+			case 'B':
+				recall(historyPos + 1)
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3':
+				// Delete, followed by '~'.
+				e.in.ReadRune()
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				insert([]rune{r})
+			}
+		}
+		redraw()
+	}
+}
+
+// restoreTerminal leaves the raw mode of the terminal, if in it.  Safe to call
+// from another goroutine, e.g. on an interrupt while a line is being read.
+func (e *lineEditor) restoreTerminal() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.restore != nil {
+		e.restore()
+		e.restore = nil
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLineEditor tests the `edit` function of the `lineEditor`.  Checks that
+// the keys edit the line, that the history is recalled with the arrows, and
+// that Tab completes the previous words and the names.
+func TestLineEditor(t *testing.T) {
+	history := []string{"hello world", "help ext:pdf"}
+	e := &lineEditor{out: &bytes.Buffer{}, prompt: "> "}
+	e.names = func() []string { return []string{"in:alice", "in:alice,bob"} }
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"abc\x7fd\n", "abd"},
+		{"bc\x01a\x05d\n", "abcd"},
+		{"one two\x17three\n", "one three"},
+		{"abc\x1b[D\x1b[Dx\n", "axbc"},
+		{"\x1b[A\n", "help ext:pdf"},
+		{"\x1b[A\x1b[A\x1b[B\n", "help ext:pdf"},
+		{"draft\x1b[A\x1b[B\n", "draft"},
+		{"wo\t\n", "world "},
+		{"hel\t\n", "hel"},
+		{"hel\tl\t\n", "hello "},
+		{"x in:al\t\n", "x in:alice"},
+	}
+	for _, test := range tests {
+		e.history = append([]string(nil), history...)
+		e.in = bufio.NewReader(strings.NewReader(test.keys))
+		if line, err := e.edit(); err != nil || line != test.expected {
+			t.Fatalf("incorrect line for the keys %q: expected %q actual %q, %v", test.keys, test.expected, line, err)
+		}
+	}
+	e.in = bufio.NewReader(strings.NewReader("\x04"))
+	if _, err := e.edit(); err != io.EOF {
+		t.Fatalf("no end of the input on Ctrl-D: %v", err)
+	}
+	if !reflect.DeepEqual(append(history, "x in:alice"), e.history) {
+		t.Fatalf("incorrect history: %v", e.history)
+	}
+}
+
+// TestLineEditorHistory tests that the history of the `lineEditor` is kept in
+// its file across the runs, trimmed to the last `maxHistory` lines.
+func TestLineEditorHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLineEditorHistory")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	historyPath := filepath.Join(dir, "history")
+	lines := make([]string, maxHistory+10)
+	for i := range lines {
+		lines[i] = "query " + strings.Repeat("x", i%7)
+	}
+	if err := ioutil.WriteFile(historyPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("error when writing the history: %s", err)
+	}
+
+	e := &lineEditor{historyPath: historyPath}
+	if err := e.loadHistory(); err != nil || !reflect.DeepEqual(lines[10:], e.history) {
+		t.Fatalf("incorrect history loaded: %d lines, %v", len(e.history), err)
+	}
+	if err := e.addHistory("new query"); err != nil {
+		t.Fatalf("error when adding to the history: %s", err)
+	}
+	if err := e.addHistory("new query"); err != nil {
+		t.Fatalf("error when adding to the history: %s", err)
+	}
+	reloaded := &lineEditor{historyPath: historyPath}
+	if err := reloaded.loadHistory(); err != nil || len(reloaded.history) != maxHistory || reloaded.history[maxHistory-1] != "new query" || reloaded.history[maxHistory-2] == "new query" {
+		t.Fatalf("incorrect history reloaded: %d lines, %v", len(reloaded.history), err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"path/filepath"
+
+	"github.com/keybase/search/client"
+)
+
+// logFileName is the name of the log file of the client within `-log_dir`.
+const logFileName = "client.log"
+
+// logger is the logger of the client, which discards all the entries until
+// `openLog` is called.
+var logger = client.NewLogger()
+
+// getLogDir returns the directory of the log files, or "" if the entries are
+// not written to a file.
+func getLogDir() string {
+	switch *logDir {
+	case "none":
+		return ""
+	case "":
+		return filepath.Join(*stateDir, "logs")
+	default:
+		return *logDir
+	}
+}
+
+// logLevels returns the lowest levels of the entries written to the standard
+// error and to the log file: the warnings and all the entries but the debug
+// ones by default, the debug entries too with `-v`, and the level of
+// `-log_level` for both if set.
+func logLevels() (client.LogLevel, client.LogLevel, error) {
+	if *logLevel != "" {
+		level, err := client.ParseLogLevel(*logLevel)
+		return level, level, err
+	} else if *verbose {
+		return client.LogDebug, client.LogDebug, nil
+	}
+	return client.LogWarn, client.LogInfo, nil
+}
+
+// openLog has `logger` write the entries to the standard error and to the
+// rotated log file under `-log_dir` at the levels of `logLevels`.  With
+// `-quiet`, nothing is written to the standard error.  The traces of the RPCs
+// go through `logger` too.  Returns the log file to be closed on exit, if any.
+func openLog(console io.Writer) (*client.RotatingFile, error) {
+	consoleLevel, fileLevel, err := logLevels()
+	if err != nil {
+		return nil, err
+	}
+	logger = client.NewLogger()
+	if !*quiet {
+		logger.AddOutput(console, consoleLevel)
+	}
+	dir := getLogDir()
+	if dir == "" {
+		return nil, nil
+	}
+	logFile, err := client.OpenRotatingFile(dir, logFileName, *logMaxSize, *logMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	logger.AddOutput(logFile, fileLevel)
+	return logFile, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/search/client"
+)
+
+// TestOpenLog tests the `openLog` function.  Checks that the warnings are
+// printed out and that the log file under `-log_dir` also receives the info
+// entries, but not the debug ones.
+func TestOpenLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestOpenLog")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		f := flag.Lookup("log_dir")
+		f.Value.Set(f.DefValue)
+		logger = client.NewLogger()
+	}()
+
+	flag.Set("log_dir", dir)
+	var console bytes.Buffer
+	logFile, err := openLog(&console)
+	if err != nil {
+		t.Fatalf("error when opening the log: %s", err)
+	}
+	logger.Debugf("debug entry")
+	logger.Infof("info entry")
+	logger.Warnf("warning entry")
+	if err := logFile.Close(); err != nil {
+		t.Fatalf("error when closing the log file: %s", err)
+	}
+
+	if !strings.Contains(console.String(), "[WARN] warning entry") || strings.Count(console.String(), "\n") != 1 {
+		t.Fatalf("incorrect console output: %q", console.String())
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatalf("error when reading the log file: %s", err)
+	}
+	if strings.Contains(string(content), "debug entry") || !strings.Contains(string(content), "[INFO] info entry") || !strings.Contains(string(content), "[WARN] warning entry") {
+		t.Fatalf("incorrect log file content: %q", content)
+	}
+
+	flag.Set("log_dir", "none")
+	if logFile, err := openLog(&console); err != nil || logFile != nil {
+		t.Fatalf("log file opened with -log_dir=none: %v, %v", logFile, err)
+	}
+}
+
+// TestOpenLogLevels tests the `openLog` function with `-log_level` and
+// `-quiet`.  Checks that the level applies to both outputs, that an unknown
+// level is rejected, and that nothing is printed out with `-quiet`.
+func TestOpenLogLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestOpenLogLevels")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		for _, name := range []string{"log_dir", "log_level", "quiet"} {
+			f := flag.Lookup(name)
+			f.Value.Set(f.DefValue)
+		}
+		logger = client.NewLogger()
+	}()
+
+	flag.Set("log_dir", dir)
+	flag.Set("log_level", "error")
+	var console bytes.Buffer
+	logFile, err := openLog(&console)
+	if err != nil {
+		t.Fatalf("error when opening the log: %s", err)
+	}
+	logger.Warnf("warning entry")
+	logger.Errorf("error entry")
+	if err := logFile.Close(); err != nil {
+		t.Fatalf("error when closing the log file: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatalf("error when reading the log file: %s", err)
+	}
+	for _, output := range []string{console.String(), string(content)} {
+		if strings.Contains(output, "warning entry") || !strings.Contains(output, "[ERROR] error entry") {
+			t.Fatalf("incorrect output with -log_level=error: %q", output)
+		}
+	}
+
+	flag.Set("log_level", "loud")
+	if _, err := openLog(&console); err == nil {
+		t.Fatalf("no error with an unknown log level")
+	}
+
+	flag.Set("log_level", "debug")
+	flag.Set("quiet", "true")
+	console.Reset()
+	logFile, err = openLog(&console)
+	if err != nil {
+		t.Fatalf("error when opening the log: %s", err)
+	}
+	logger.Errorf("quiet error entry")
+	logFile.Close()
+	if console.Len() != 0 {
+		t.Fatalf("entries printed out with -quiet: %q", console.String())
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, logFileName)); err != nil || !strings.Contains(string(content), "quiet error entry") {
+		t.Fatalf("entry not written to the log file with -quiet: %v", err)
+	}
+}
@@ -5,139 +5,1118 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/keybase/search/client"
+	"github.com/keybase/search/libsearch"
 	"golang.org/x/net/context"
 )
 
-var lenSalt = flag.Int("len_salt", 8, "the length of the salts used to generate the PRFs")
+var lenSalt = flag.Int("len_salt", 32, "the length of the salts used to generate the PRFs (at least 16)")
 var fpRate = flag.Float64("fp_rate", 0.000001, "the desired false positive rate for searchable encryption")
 var numUniqWords = flag.Uint64("num_words", uint64(100000), "the expected number of unique words in all the documents within one TLF")
 var clientDirectories = flag.String("client_dirs", "", "the keybase directories for the client where the files should be indexed, separated by ';'")
+var configFile = flag.String("config", filepath.Join(os.Getenv("HOME"), ".kbfs_search", "config.yaml"), "the YAML config file, holding the values of the flags not set on the command line and the per-directory parameters")
 var port = flag.Int("port", 8022, "the port that the search server is listening on")
 var ipAddr = flag.String("ip_addr", "127.0.0.1", "the IP address that the search server is listening on")
-var lenMS = flag.Int("len_ms", 64, "the length of the master secret")
-var verbose = flag.Bool("v", false, "whether log outputs should be printed out")
-
-// addAllFiles adds all the non-hidden files that have been modified after
-// `lastIndexed`.
-func addAllFiles(cli *client.Client, clientDir string, lastIndexed time.Time) filepath.WalkFunc {
-	return func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() && (info.Name()[0] == '.' || info.ModTime().Before(lastIndexed)) {
-			return filepath.SkipDir
-		} else if !info.IsDir() && info.Name()[0] != '.' {
-			if info.ModTime().After(lastIndexed) {
-				cli.AddFile(clientDir, path)
-				if *verbose {
-					fmt.Println("Added:", path)
-				}
+var lenMS = flag.Int("len_ms", 64, "the length of the master secret (at least 32, or the minimum set by the TLF policy)")
+var queryLimit = flag.Int("query_limit", 120, "the maximum number of words searched for per minute, beyond which the searches are delayed and an alert is printed out (0 for no limit)")
+var resultBucket = flag.Int("result_bucket", 0, "the bucket size the search server should pad the search results to with dummy results, hiding the exact number of matches (0 for no padding)")
+var encryptSalts = flag.Bool("encrypt_salts", false, "whether the salts of newly registered TLFs should be generated by the client and only stored encrypted on the search server")
+var indexType = flag.String("index_type", "bloom", "the type of the indexes of newly registered TLFs: 'bloom', or 'cuckoo' for indexes that are updated in place when words are removed from a file")
+var verbose = flag.Bool("v", false, "whether the debug log entries, such as the files indexed and the traces of the RPCs, should be printed out and written to the log file, as with -log_level=debug")
+var logLevel = flag.String("log_level", "", "the lowest level of the log entries of the scans and the RPCs printed out and written to the log file: 'debug', 'info', 'warn' or 'error' (defaults to 'warn' for the standard error and 'info' for the log file)")
+var quiet = flag.Bool("quiet", false, "whether nothing but the search results and the fatal errors should be printed out, the log entries still being written to the log file")
+var logDir = flag.String("log_dir", "", "the directory the log file of the client is written to, rotated as it grows ('none' to disable, defaults to logs in the state directory)")
+var logMaxSize = flag.Int64("log_max_size", 10<<20, "the size in bytes beyond which the log file is rotated")
+var logMaxFiles = flag.Int("log_max_files", 5, "the number of rotated log files kept besides the current one")
+var indexWorkers = flag.Int("index_workers", 4, "the number of files indexed concurrently by the scans")
+var uploadRetries = flag.Int("upload_retries", 3, "the number of times a failed upload of an index is retried, with exponential backoff, before the file is left to the next scan")
+var uploadRetryDelay = flag.Duration("upload_retry_delay", time.Second, "the delay before the first retry of a failed upload, doubled for each next retry")
+var maxUploadBps = flag.Int64("max_upload_bps", 0, "the maximum average number of bytes of indexes uploaded per second (0 for no limit)")
+var blinding = flag.String("blinding", "length", "the policy the indexes are blinded with: 'length' for one random entry per byte of the files, 'size_bucket' for as many entries for all the files whose sizes round up to the same power of two, or 'percent:N' for N random entries per hundred bytes on top of the words")
+var sizeBuckets = flag.Bool("size_buckets", false, "whether each index is padded up to the next power of two in size, so that the search server cannot tell the files apart by the exact sizes of their indexes, at the cost of up to twice the storage")
+var nearWindow = flag.Int("near_window", 0, "the number of following words each word of the files is indexed as co-occurring with, so that the queries such as 'alpha NEAR beta' match the files where the two words are close together, at the cost of a higher false positive rate (0 to disable)")
+var tlfSummaries = flag.Bool("tlf_summary", false, "whether the words of the indexed files are merged into a summary of each TLF on the search server, which the client downloads to skip the searches for the words in none of the files, at the cost of letting the server tell which files share words")
+var memBudget = flag.Int64("mem_budget", 0, "the maximum number of bytes of memory used by concurrent index builds (0 for no limit)")
+var standbyServer = flag.String("standby_server", "", "a standby search server, in the form of 'IP_ADDR:PORT', the index writes of the client directories are mirrored to, so that the client can fail over to it without indexing them again (none by default)")
+var standbyFailover = flag.Duration("standby_failover", 0, "how long the search server may stay unreachable before the client fails over to the -standby_server for good (0 to never fail over)")
+var extraServers = flag.String("extra_servers", "", "additional search servers and their keybase directories, in the form of 'IP_ADDR:PORT=DIR1;DIR2', separated by ','")
+var historyRevisions = flag.Int("history_revisions", 0, "the number of prior versions of each file kept searchable, indexed from the archived revisions of KBFS as the files change (0 to disable)")
+var scanInterval = flag.Duration("scan_interval", time.Minute, "the interval between two scans of the client directories for updated files, when not watching them")
+var maxFileSize = flag.Int64("max_file_size", 100<<20, "the size in bytes beyond which the files are skipped instead of indexed (0 for no limit)")
+var skipBinary = flag.Bool("skip_binary", true, "whether the files with binary content, i.e. with a NUL byte among their first 512 bytes, are skipped instead of indexed")
+var backfillThreshold = flag.Int("backfill_threshold", 100, "the number of files from which a scan announces a backfill to the search server and paces its index writes to the budget granted by the server, shared among the clients backfilling at once (0 to disable)")
+var refusePattern = flag.String("refuse_pattern", "", "a regular expression refusing the files with a word matching it, normalized to lowercase letters and digits, from being indexed, e.g. '^[0-9]{13,19}$' for the credit card numbers (none by default)")
+var symlinks = flag.String("symlinks", "skip", "how the scans handle the symlinks in the client directories: 'skip' them, or 'follow' the ones resolving within their directory, indexing their targets under their real paths")
+var claimTTL = flag.Duration("claim_ttl", 0, "how long the client claims the upload of the index of a file on the search server, so that the other members of a shared folder do not index it too (0 disables the claims, the default)")
+var progressInterval = flag.Duration("progress_interval", 10*time.Second, "the interval between two summaries of the progress of the scans with files remaining to be indexed, printed out to the standard error (0 to disable)")
+var simLatency = flag.Duration("sim_latency", 0, "the one-way latency simulated on the link to the search servers, as modeled by the prototype, for performance experiments (0 for none)")
+var simBandwidth = flag.Int64("sim_bandwidth", 0, "the bandwidth in bits per second simulated on the link to the search servers, as modeled by the prototype, for performance experiments (0 for unlimited)")
+var stateDir = flag.String("state_dir", filepath.Join(os.Getenv("HOME"), ".kbfs_search"), "the local directory holding the state of the client, such as the locks preventing several clients from indexing the same directories")
+var controlSocket = flag.String("control_socket", "", "the Unix socket the control interface of the daemon is served on, for other processes to query the status, reindex and search ('none' to disable, defaults to control.sock in the state directory)")
+var watch = flag.Bool("watch", false, "whether the client directories should be watched for file changes and indexed right away, instead of being scanned periodically")
+var outputFormat = flag.String("format", "", "the Go template each matching file is printed out with, over its Query, Directory and Path, e.g. '{{.Path}}', or 'paths' or 'tsv' (defaults to a human-readable listing)")
+var jsonOutput = flag.Bool("json", false, "whether the results of each query should be printed out as a line of JSON, with the matching files and the expected number of false positives among them")
+var picker = flag.String("picker", "", "the fuzzy picker command the results of each query are streamed into for selection, e.g. 'fzf' (none by default)")
+var openCommand = flag.String("open", "", "the command the file selected in the picker is opened with, e.g. 'xdg-open' (printed out by default)")
+var showCoverage = flag.Bool("coverage", false, "whether to print out how many of the files in each client directory are indexed on the search server, and why the other ones are not, then exit")
+var dryRun = flag.Bool("dry_run", false, "whether to print out the files of the client directories that the next scan would index, with the estimated sizes of their indexes, without contacting the search server, then exit")
+var sortResults = flag.String("sort", "", "the order of the results of each query: by path by default, 'mtime' for the most recently modified files first, or 'name_match' for the files with the most words of the query in their names first, boosted by -recency_boost")
+var recencyWeight = flag.Float64("recency_boost", 1, "the boost of a file modified just now with -sort=name_match, in words of the query matched by its name, halved every -recency_half_life (0 to disable)")
+var recencyHalfLife = flag.Duration("recency_half_life", 7*24*time.Hour, "the age at which the recency boost of a file is halved with -sort=name_match")
+var archiveFolders = flag.String("archive_folders", "Trash", "the comma-separated archive folders, either names of folders anywhere in the client directories or paths relative to them, whose files are indexed but left out of the search results unless -include_archived is set")
+var includeArchived = flag.Bool("include_archived", false, "whether the files of the -archive_folders are included in the search results")
+var offlineSearch = flag.Bool("offline_search", false, "whether the queries are answered approximately from the files indexed by the client while the search server is unreachable, with the results labeled as unverified")
+var debug = flag.Bool("debug", false, "whether the daemon profiles itself, writing a CPU profile during the indexing bursts and a heap profile after the scans to profiles in the state directory, and serves the pprof endpoints at -debug_addr")
+var debugAddr = flag.String("debug_addr", "localhost:6060", "the address the pprof endpoints are served on with -debug")
+var metricsAddr = flag.String("metrics_addr", "", "the address the Prometheus metrics of the client are served on under /metrics, e.g. 'localhost:9100' (not served by default)")
+var daemon = flag.Bool("daemon", false, "whether the client runs as a background service without the prompt: detached from the terminal unless -foreground is set, with a pidfile, and reloading the config file on SIGHUP")
+var foreground = flag.Bool("foreground", false, "whether the daemon stays in the foreground with -daemon, e.g. under systemd or launchd")
+var pidFile = flag.String("pidfile", "", "the pidfile written by the daemon with -daemon (defaults to daemon.pid in the state directory)")
+var selftestTimeout = flag.Duration("selftest_timeout", 2*time.Minute, "how long the selftest subcommand waits for the test file of each directory to be found by a search before failing")
+var takeover = flag.Bool("takeover", false, "whether a client started on directories that another client on this machine is already indexing asks it to shut down and takes over once it has, instead of exiting")
+var prototypeSecret = flag.String("prototype_secret", "", "the file holding the hex-encoded master secret of the owner of the prototype corpus imported by the import subcommand")
+var searchTimeout = flag.Duration("search_timeout", 0, "how long a query waits for the search servers before failing (0 for no limit)")
+var searchWorkers = flag.Int("search_workers", 8, "the number of directories searched concurrently by a query")
+var searchTiming = flag.Bool("search_timing", false, "whether to print out how long the search of each directory took to the standard error")
+var resultCacheSize = flag.Int("result_cache", 0, "the number of recent search results cached per directory, encrypted with the master secret of the TLF, to answer the repeated queries at once and while the search server is unreachable (0 to disable)")
+var wildcard = flag.Bool("wildcard", false, "whether each query should be fanned out to all the directories on all the search servers, with the results labeled per folder")
+
+// reportScan logs the outcome of a scan of a client directory.  A failed scan
+// is only logged, as the directory is retried by the next scan, and its error
+// is shown by the status of the daemon meanwhile.
+func reportScan(report client.IndexReport) {
+	profiler.afterScan(report)
+	if report.Err != nil && report.Directory == "" {
+		logger.Errorf("Error when watching the files: %s", report.Err)
+		return
+	} else if report.Err != nil {
+		logger.Errorf("Error when indexing the files under directory \"%s\", retrying with the next scan: %s", report.Directory, report.Err)
+		return
+	}
+	for _, path := range report.Added {
+		logger.Debugf("Added: %s", path)
+	}
+	for _, path := range report.Deleted {
+		logger.Debugf("Deleted: %s", path)
+	}
+	for orig, curr := range report.Renamed {
+		logger.Debugf("Renamed: %s -> %s", orig, curr)
+	}
+	if report.Skipped > 0 {
+		logger.Infof("Skipped %d files under directory \"%s\" as too large or binary", report.Skipped, report.Directory)
+	}
+	if report.Unchanged > 0 {
+		logger.Infof("Kept the indexes of %d files under directory \"%s\" whose content is unchanged", report.Unchanged, report.Directory)
+	}
+	if report.Deferred > 0 {
+		logger.Infof("Left %d files under directory \"%s\" to the other clients that claimed them", report.Deferred, report.Directory)
+	}
+	logger.Infof("All files under directory \"%s\" indexed in %s by the scan started at %s", report.Directory, report.Elapsed, report.Start.Format("2006-01-02 15:04:05"))
+}
+
+// indexFiles keeps the files under `directories` indexed on `cli`, either by
+// watching them for changes if `-watch` is set, or by scanning them every
+// `-scan_interval`.  Falls back to the scans if the directories cannot be watched.
+func indexFiles(cli *client.Client, directories []string) {
+	if *watch {
+		err := cli.WatchFiles(context.Background(), directories, reportScan)
+		if err == nil {
+			return
+		}
+		logger.Warnf("Error when watching the files, falling back to periodic scans: %s", err)
+	}
+	cli.PeriodicAdd(context.Background(), directories, reportScan)
+}
+
+// migrateIndexes rebuilds the indexes of `directory` of `cli` if they have been
+// built with an outdated schema, resuming the migration interrupted by a
+// previous client if any.
+func migrateIndexes(cli *client.Client, directory string) {
+	progress, pending, err := cli.GetMigrationProgress(directory)
+	if err != nil {
+		logger.Warnf("Cannot check the schema of the indexes of directory \"%s\": %s", directory, err)
+		return
+	} else if !pending {
+		return
+	}
+	logger.Infof("Migrating the indexes of directory \"%s\" to the current schema, %d of %d files done.", directory, progress.Migrated, progress.Total)
+	report := cli.MigrateIndexes(context.Background(), directory)
+	if report.Err == client.ErrDocIDSchemeChanged {
+		logger.Warnf("WARNING: the indexes of directory \"%s\" cannot be migrated in place, as their document IDs have changed.  Run `reindex --drop %s` to rebuild them.", directory, directory)
+		return
+	}
+	reportScan(report)
+}
+
+// indexDirectories keeps the files under `directories` of `cli` indexed in the
+// background, until `cli` is shut down or the directories are removed from
+// it.  The `unclean` directories, whose previous client has not shut down
+// cleanly, are first reconciled with the search server, then the indexes built
+// with an outdated schema are migrated.  `indexing` is done once the
+// background indexing has stopped.
+func indexDirectories(cli *client.Client, directories, unclean []string, indexing *sync.WaitGroup) {
+	indexing.Add(2)
+	go func() {
+		defer indexing.Done()
+		for _, directory := range unclean {
+			logger.Warnf("Recovering from an unclean shutdown of the client of \"%s\".", directory)
+			reportScan(cli.ReindexStale(context.Background(), directory))
+		}
+		for _, directory := range directories {
+			migrateIndexes(cli, directory)
+		}
+		indexFiles(cli, directories)
+	}()
+	go func() {
+		defer indexing.Done()
+		cli.PeriodicReindexStale(context.Background(), directories, reportScan)
+	}()
+}
+
+// warnAnalysisMismatch warns about the `directories` of `cli` whose TLF has
+// been registered by a client analyzing the words differently, as the
+// searches then silently miss words.
+func warnAnalysisMismatch(cli *client.Client, directories []string) {
+	for _, directory := range directories {
+		if status, err := cli.GetDirectoryStatus(directory); err == nil && status.AnalysisMismatch {
+			logger.Warnf("WARNING: the TLF of directory \"%s\" has been registered by a client splitting or normalizing the words differently, so the searches may miss the words of the files indexed by either client.  All the clients of the TLF should run the same version.", directory)
+		}
+	}
+}
+
+// startIndexing locks the directories of `cli` and keeps their files indexed
+// in the background with `indexDirectories`.  Exits if another client is
+// already indexing any of the directories, unless it is taken over with
+// `-takeover`.
+func startIndexing(cli *client.Client, indexing *sync.WaitGroup) {
+	directories := cli.Directories()
+	warnAnalysisMismatch(cli, directories)
+	unclean, err := lockDirectories(cli)
+	if err != nil {
+		logger.Errorf("Cannot lock the client directories: %s", err)
+		os.Exit(1)
+	}
+	indexDirectories(cli, directories, unclean, indexing)
+}
+
+// addDirectory adds `absDir` to `cli`, and keeps its files indexed in the
+// background with `indexDirectories`, starting with a full scan.
+func addDirectory(ctx context.Context, cli *client.Client, absDir string, indexing *sync.WaitGroup) error {
+	dirty, err := cli.AddDirectory(ctx, absDir)
+	if err != nil {
+		return err
+	}
+	startAddedDirectory(cli, absDir, dirty, indexing)
+	return nil
+}
+
+// startAddedDirectory keeps the files of `absDir`, just added to `cli`, indexed
+// in the background with `indexDirectories`, starting with a full scan, or with
+// a reconciliation if its previous client is `dirty`.
+func startAddedDirectory(cli *client.Client, absDir string, dirty bool, indexing *sync.WaitGroup) {
+	warnAnalysisMismatch(cli, []string{absDir})
+	var unclean []string
+	if dirty {
+		unclean = append(unclean, absDir)
+	}
+	indexDirectories(cli, []string{absDir}, unclean, indexing)
+}
+
+// shutdown stops the background indexing of `clients`, waits for the scans in
+// progress to complete, then sends the pending uploads and closes the
+// connections to the search servers.  The shutdown of a client is only marked
+// as clean if nothing has been lost.  Exits right away on another signal from
+// `signals`.
+func shutdown(clients []*client.Client, indexing *sync.WaitGroup, signals <-chan os.Signal) {
+	for _, c := range clients {
+		c.Shutdown()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		indexing.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-signals:
+		logger.Warnf("Exiting without waiting for the scans in progress.")
+		os.Exit(1)
+	}
+
+	for _, c := range clients {
+		if err := c.Close(); err != nil {
+			logger.Errorf("Error when flushing the pending uploads: %s", err)
+			continue
+		}
+		if err := c.UnlockDirectories(); err != nil {
+			logger.Errorf("Error when unlocking the client directories: %s", err)
+		}
+	}
+}
+
+// reportDryRun prints out the files of the directories of `groups` that the
+// next scan would index, with the estimated sizes of their indexes.
+func reportDryRun(groups []dirGroup) {
+	for _, group := range groups {
+		groupIndexType, err := parseIndexType(group.params.indexType)
+		if err != nil {
+			fmt.Printf("Invalid index type: %s\n", err)
+			os.Exit(1)
+		}
+		opts := client.DryRunOptions{MaxFileSize: *maxFileSize, SkipBinary: *skipBinary, FpRate: group.params.fpRate, NumUniqWords: group.params.numUniqWords, IndexType: groupIndexType, Blinding: blindingPolicy, SizeBuckets: *sizeBuckets, Symlinks: client.SymlinkPolicy(*symlinks)}
+		for _, directory := range group.directories {
+			report, err := client.DryRun(directory, opts)
+			if err != nil {
+				fmt.Printf("Error during the dry run of \"%s\": %s\n", directory, err)
+				continue
+			}
+			for _, file := range report.Files {
+				fmt.Printf("%s\t%d bytes, index of about %d bytes\n", file.Path, file.Size, file.IndexSize)
+			}
+			var origs []string
+			for orig := range report.Renamed {
+				origs = append(origs, orig)
+			}
+			sort.Strings(origs)
+			for _, orig := range origs {
+				fmt.Printf("%s\trenamed from %s\n", report.Renamed[orig], orig)
+			}
+			numSkipped := 0
+			for _, n := range report.Skipped {
+				numSkipped += n
+			}
+			fmt.Printf("%s: %d files to index, about %d bytes of indexes, %d renamed, %d unchanged, %d skipped\n", report.Directory, len(report.Files), report.IndexBytes, len(report.Renamed), report.Unchanged, numSkipped)
+			var reasons []string
+			for reason := range report.Skipped {
+				reasons = append(reasons, string(reason))
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				fmt.Printf("\t%s: %d\n", reason, report.Skipped[client.SkipReason(reason)])
 			}
 		}
-		return nil
 	}
 }
 
-// periodicAdd scans the files in the client directories every minute and adds
-// the updated files to the search server.
-func periodicAdd(cli *client.Client, clientDirs []string) {
-	for {
-		for _, clientDir := range clientDirs {
-			currTime := time.Now()
+// reportCoverage prints out the coverage of the directories of `clients` by
+// the indexes on the search servers.
+func reportCoverage(clients []*client.Client) {
+	for _, cli := range clients {
+		for _, directory := range cli.Directories() {
+			coverage, err := cli.GetCoverage(context.Background(), directory)
+			if err != nil {
+				fmt.Printf("Error when getting the coverage of \"%s\": %s\n", directory, err)
+				continue
+			}
+			skipped := coverage.Present - coverage.Indexed - coverage.Stale
+			fmt.Printf("%s: %d of %d files indexed, %d stale, %d skipped\n", coverage.Directory, coverage.Indexed, coverage.Present, coverage.Stale, skipped)
+			var reasons []string
+			for reason := range coverage.Skipped {
+				reasons = append(reasons, string(reason))
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				fmt.Printf("\t%s: %d\n", reason, coverage.Skipped[client.SkipReason(reason)])
+			}
+			stats, err := cli.GetIndexStats(context.Background(), directory)
+			if err != nil {
+				fmt.Printf("Error when getting the index statistics of \"%s\": %s\n", directory, err)
+				continue
+			}
+			fmt.Printf("\t%d indexes, %d bytes in total, %d bytes median, %d bytes at the 90th percentile, fill ratio %.2f on average and %.2f at most\n", stats.NumIndexes, stats.TotalBytes, stats.MedianBytes, stats.P90Bytes, stats.MeanFillRatio, stats.MaxFillRatio)
+			if stats.NumOverfilled > 0 {
+				fmt.Printf("\tWARNING: %d indexes are filled beyond what the TLF has been sized for, and have a higher false positive rate.  Consider indexing the TLF with a larger -num_words.\n", stats.NumOverfilled)
+			}
+		}
+	}
+}
+
+// reportQueryAnomaly alerts the user of an unusually high volume of searches.
+func reportQueryAnomaly(anomaly client.QueryAnomaly) {
+	logger.Warnf("%d words searched for within %s, the searches are being throttled.  Make sure that no rogue program is using the search client.", anomaly.NumQueries, anomaly.Window)
+}
 
-			var lastIndexed time.Time
+// blindingPolicy is the blinding policy parsed from `-blinding`.
+var blindingPolicy libsearch.BlindingPolicy = libsearch.LengthBlinding{}
 
-			lastIndexedJSON, err := ioutil.ReadFile(filepath.Join(clientDir, ".search_kbfs_timestamp"))
-			if err == nil {
-				if err := lastIndexed.UnmarshalJSON(lastIndexedJSON); err != nil {
-					panic(fmt.Sprintf("Error when accessing the last indexed timestamp: %s", err))
-				}
-			} else if !os.IsNotExist(err) {
-				panic(fmt.Sprintf("Error when accessing the last indexed timestamp: %s", err))
+// refusedWords is the pattern parsed from `-refuse_pattern`, or nil.
+var refusedWords *regexp.Regexp
+
+// resultTemplate is the template the matching files are printed out with, or
+// nil for the human-readable listing.
+var resultTemplate *template.Template
+
+// structuredOutput returns whether the results are printed out with `-json` or
+// `-format` instead of the default listing.
+func structuredOutput() bool {
+	return *jsonOutput || resultTemplate != nil
+}
+
+// estimateFalsePositives returns the expected number of false positives of an
+// unverified query over the directories of the `clients` within `scope`.
+func estimateFalsePositives(clients []*client.Client, scope searchScope) (float64, error) {
+	var estimate float64
+	for _, cli := range clients {
+		for _, clientDir := range scope.directories(cli) {
+			dirEstimate, err := cli.EstimateFalsePositives(clientDir)
+			if err != nil {
+				return 0, err
 			}
+			estimate += dirEstimate
+		}
+	}
+	return estimate, nil
+}
+
+// printResults prints out the `results` of `query` as JSON if `-json` is set,
+// or with `resultTemplate` otherwise.  The results are labeled as `unverified`
+// in the JSON if found without the search server, along with the expected
+// number of false positives `fpEstimate` among them.  The results verified by
+// the search server have no false positives.
+func printResults(query string, results []searchResult, fpEstimate float64, unverified bool) {
+	var err error
+	if *jsonOutput {
+		err = writeJSONResult(os.Stdout, query, results, fpEstimate, unverified)
+	} else {
+		err = writeResults(os.Stdout, resultTemplate, results)
+	}
+	if err != nil {
+		fmt.Printf("Error when printing out the results: %s\n", err)
+	}
+}
+
+// searchScope holds the directories a search is restricted to with the `in:`
+// keywords, matched by their paths or base names.  All the directories are
+// searched if empty.
+type searchScope []string
+
+// parseSearchScope separates the `in:` keywords from the other `keywords`.
+func parseSearchScope(keywords []string) ([]string, searchScope) {
+	var rest []string
+	var scope searchScope
+	for _, keyword := range keywords {
+		if strings.HasPrefix(keyword, "in:") && len(keyword) > len("in:") {
+			scope = append(scope, strings.TrimPrefix(keyword, "in:"))
+		} else {
+			rest = append(rest, keyword)
+		}
+	}
+	return rest, scope
+}
 
-			if err := filepath.Walk(clientDir, addAllFiles(cli, clientDir, lastIndexed)); err != nil {
-				panic(fmt.Sprintf("Error when indexing the files: %s", err))
+// contains returns whether `directory` is within the scope.
+func (s searchScope) contains(directory string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, name := range s {
+		if name == directory || name == filepath.Base(directory) {
+			return true
+		}
+	}
+	return false
+}
+
+// directories returns the directories of `cli` within the scope.
+func (s searchScope) directories(cli *client.Client) []string {
+	var directories []string
+	for _, directory := range cli.Directories() {
+		if s.contains(directory) {
+			directories = append(directories, directory)
+		}
+	}
+	return directories
+}
+
+// directoryNames returns the `in:` keywords of the directories of the
+// `clients`, as completed at the prompt.
+func directoryNames(clients []*client.Client) []string {
+	var names []string
+	for _, cli := range clients {
+		for _, directory := range cli.Directories() {
+			names = append(names, "in:"+filepath.Base(directory))
+		}
+	}
+	return names
+}
+
+// performSearchWords searches for all the `keywords` in the directories of the
+// `clients` within `scope` in parallel, with a single round trip per
+// directory, and prints out the merged results for each keyword that pass the
+// `filter`.
+func performSearchWords(ctx context.Context, clients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	searches := searchDirectories(ctx, clients, scope, keywords)
+	reportSearchTimes(searches)
+	for _, s := range searches {
+		if s.err != nil {
+			return s.err
+		}
+	}
+	allResults := mergeSearches(searches)
+	for keyword, results := range allResults {
+		allResults[keyword] = filter.apply(results)
+	}
+	if structuredOutput() {
+		for _, keyword := range keywords {
+			printResults(keyword, filter.truncate(allResults[keyword]), 0, false)
+		}
+		return nil
+	}
+	for _, keyword := range keywords {
+		if len(allResults[keyword]) == 0 {
+			fmt.Printf("No file contains the word \"%s\".\n", keyword)
+		} else {
+			fmt.Printf("Files containing the word \"%s\":\n", keyword)
+			listing := newListing(os.Stdout, filter.limit)
+			for _, result := range allResults[keyword] {
+				listing.result(result.label())
 			}
+			listing.end()
+		}
+		fmt.Println()
+	}
+	return nil
+}
 
-			currTimeJSON, err := currTime.MarshalJSON()
+// performFilteredSearch searches for the files matching all the `keywords`,
+// some of which are metadata keywords such as "ext:pdf" or NEAR operators, in
+// the directories of the `clients` within `scope`, and prints out the results
+// that pass the `filter`.
+func performFilteredSearch(ctx context.Context, clients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	query := strings.Join(keywords, " ")
+	var allResults []searchResult
+	for _, cli := range clients {
+		for _, clientDir := range scope.directories(cli) {
+			filenames, err := cli.SearchQueryStrict(ctx, clientDir, query)
 			if err != nil {
-				panic(fmt.Sprintf("Error when writing the timestamp: %s", err))
+				return err
+			}
+			for _, filename := range filenames {
+				allResults = append(allResults, searchResult{query, clientDir, filename})
+			}
+		}
+	}
+	allResults = filter.apply(allResults)
+	if structuredOutput() {
+		printResults(query, filter.truncate(allResults), 0, false)
+		return nil
+	}
+	if len(allResults) == 0 {
+		fmt.Printf("No file matches \"%s\".\n", query)
+	} else {
+		fmt.Printf("Files matching \"%s\":\n", query)
+		listing := newListing(os.Stdout, filter.limit)
+		for _, result := range allResults {
+			listing.result(result.label())
+		}
+		listing.end()
+	}
+	fmt.Println()
+	return nil
+}
+
+// isFilteredQuery returns whether any of the `keywords` is a metadata keyword
+// or the NEAR operator, in which case the keywords are searched as a filtered
+// query.
+func isFilteredQuery(keywords []string) bool {
+	for _, keyword := range keywords {
+		if _, ok := libsearch.ParseMetadataKeyword(keyword); ok || keyword == libsearch.NearOperator {
+			return true
+		}
+	}
+	return false
+}
+
+// performWildcardSearch searches for all the `keywords` in all the directories
+// registered on all of the `clients`, and prints out the results within `scope`
+// that pass the `filter`, labeled by the folder they come from.
+func performWildcardSearch(ctx context.Context, clients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	results, err := client.SearchWordsAllTlfs(ctx, clients, keywords, true)
+	if err != nil {
+		return err
+	}
+	for keyword, tlfResults := range results {
+		var inScope []client.TlfSearchResult
+		for _, tlfResult := range tlfResults {
+			if scope.contains(tlfResult.Directory) {
+				tlfResult.Filenames = filter.applyPaths(tlfResult.Directory, tlfResult.Filenames)
+				inScope = append(inScope, tlfResult)
+			}
+		}
+		results[keyword] = inScope
+	}
+	if structuredOutput() {
+		for _, keyword := range keywords {
+			var keywordResults []searchResult
+			for _, tlfResult := range results[keyword] {
+				for _, filename := range tlfResult.Filenames {
+					keywordResults = append(keywordResults, searchResult{keyword, tlfResult.Directory, filename})
+				}
 			}
-			if err := ioutil.WriteFile(filepath.Join(clientDir, ".search_kbfs_timestamp"), currTimeJSON, 0666); err != nil {
-				panic(fmt.Sprintf("Error when writing the timestamp: %s", err))
+			printResults(keyword, filter.truncate(keywordResults), 0, false)
+		}
+		return nil
+	}
+	for _, keyword := range keywords {
+		if len(results[keyword]) == 0 {
+			fmt.Printf("No file contains the word \"%s\".\n", keyword)
+		} else {
+			fmt.Printf("Files containing the word \"%s\":\n", keyword)
+			listing := newListing(os.Stdout, filter.limit)
+			for _, tlfResult := range results[keyword] {
+				listing.header("  [" + tlfResult.Directory + "]")
+				for _, filename := range tlfResult.Filenames {
+					listing.result(searchResult{keyword, tlfResult.Directory, filename}.label())
+				}
 			}
+			listing.end()
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// serverUnreachable returns whether the search server of any of the `clients`
+// is unreachable, or `err` reveals that it is.
+func serverUnreachable(clients []*client.Client, err error) bool {
+	for _, cli := range clients {
+		if cli.ServerUnreachable(err) {
+			return true
+		}
+	}
+	return false
+}
 
-			if *verbose {
-				fmt.Printf("\n[%s]: All files under directory \"%s\" indexed in %s\n", currTime.Format("2006-01-02 15:04:05"), clientDir, time.Since(currTime))
+// performOfflineSearch searches for the `keywords` in the directories of the
+// `clients` within `scope` without the search server, as a single query if
+// `isFilteredQuery` holds and for each of them otherwise, and
+// prints out the results that pass the `filter` labeled as unverified.
+func performOfflineSearch(clients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	queries := keywords
+	if isFilteredQuery(keywords) {
+		queries = []string{strings.Join(keywords, " ")}
+	}
+	allResults := make(map[string][]searchResult)
+	for _, cli := range clients {
+		for _, clientDir := range scope.directories(cli) {
+			for _, query := range queries {
+				filenames, err := cli.SearchQueryOffline(clientDir, query)
+				if err != nil {
+					return err
+				}
+				for _, filename := range filenames {
+					allResults[query] = append(allResults[query], searchResult{query, clientDir, filename})
+				}
 			}
 		}
-		time.Sleep(time.Second * 60)
 	}
+	for query, results := range allResults {
+		allResults[query] = filter.apply(results)
+	}
+	if structuredOutput() {
+		if !*jsonOutput && !*quiet {
+			fmt.Fprintln(os.Stderr, "The search server is unreachable, the results are unverified.")
+		}
+		fpEstimate, err := estimateFalsePositives(clients, scope)
+		if err != nil {
+			return err
+		}
+		for _, query := range queries {
+			printResults(query, filter.truncate(allResults[query]), fpEstimate, true)
+		}
+		return nil
+	}
+	for _, query := range queries {
+		if len(allResults[query]) == 0 {
+			fmt.Printf("No file indexed by this client matches \"%s\" (unverified, the search server is unreachable).\n", query)
+		} else {
+			fmt.Printf("Files indexed by this client matching \"%s\" (unverified, the search server is unreachable):\n", query)
+			listing := newListing(os.Stdout, filter.limit)
+			for _, result := range allResults[query] {
+				listing.result(result.label())
+			}
+			listing.end()
+		}
+		fmt.Println()
+	}
+	return nil
 }
 
-// performSearchWord searches for the word `keyword` on `cli`, and prints out
-// the results.
-// TODO: Parallelize the search on different TLFs for performance optimization.
-func performSearchWord(cli *client.Client, clientDirs []string, keyword string) {
-	var allFiles []string
-	for _, clientDir := range clientDirs {
-		filenames, err := cli.SearchWordStrict(clientDir, keyword)
+// performSearch searches for the `keywords` in the directories of the
+// `localClients`, or of the `allClients` with `-wildcard`, the way the flags
+// select, and prints out the results.  The `in:` keywords, e.g. "in:alice,bob",
+// restrict the search to the directories with these paths or base names, and
+// the `after:` and `before:` keywords, e.g. "after:2016-01-01", to the files
+// modified within these dates, and `--limit N` to the first N results of each
+// query.  The search fails once it has waited `-search_timeout` for the
+// search servers.  With `-offline_search`, the search falls back
+// to `performOfflineSearch` while the search server is unreachable.
+func performSearch(localClients, allClients []*client.Client, keywords []string) error {
+	keywords, scope := parseSearchScope(keywords)
+	keywords, filter, err := parseTimeFilter(keywords)
+	if err != nil {
+		return err
+	}
+	if len(keywords) == 0 {
+		return errors.New("no word to search for")
+	}
+	ctx := context.Background()
+	if *searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *searchTimeout)
+		defer cancel()
+	}
+	if !*offlineSearch {
+		return performOnlineSearch(ctx, localClients, allClients, scope, filter, keywords)
+	}
+	// The searches would block until the connection is reestablished.
+	if serverUnreachable(localClients, nil) {
+		return performOfflineSearch(localClients, scope, filter, keywords)
+	}
+	err = performOnlineSearch(ctx, localClients, allClients, scope, filter, keywords)
+	if err != nil && serverUnreachable(localClients, err) {
+		return performOfflineSearch(localClients, scope, filter, keywords)
+	}
+	return err
+}
+
+// performOnlineSearch searches for the `keywords` within `scope` on the search
+// servers, as selected by the flags for `performSearch`.
+func performOnlineSearch(ctx context.Context, localClients, allClients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	if *picker != "" {
+		return performPickSearch(ctx, localClients, scope, filter, keywords)
+	} else if *wildcard {
+		return performWildcardSearch(ctx, allClients, scope, filter, keywords)
+	} else if isFilteredQuery(keywords) {
+		return performFilteredSearch(ctx, localClients, scope, filter, keywords)
+	}
+	return performSearchWords(ctx, localClients, scope, filter, keywords)
+}
+
+// parseExtraServers parses the `-extra_servers` flag into a map from the
+// server addresses to the directories to search on that server.
+func parseExtraServers(servers string) (map[string][]string, error) {
+	serverDirs := make(map[string][]string)
+	if servers == "" {
+		return serverDirs, nil
+	}
+	for _, server := range strings.Split(servers, ",") {
+		parts := strings.SplitN(server, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid server specification \"%s\"", server)
+		}
+		serverDirs[parts[0]] = strings.Split(parts[1], ";")
+	}
+	return serverDirs, nil
+}
+
+// createExtraClients initializes one search client for each of the servers in
+// `serverDirs`, with the index parameters of the flags and sharing `budget`.
+func createExtraClients(serverDirs map[string][]string, budget *client.MemoryBudget) ([]*client.Client, error) {
+	var clients []*client.Client
+	defaults := indexParams{*lenMS, *lenSalt, *fpRate, *numUniqWords, *encryptSalts, *indexType}
+	opts, err := clientOptions(defaults, budget)
+	if err != nil {
+		return nil, err
+	}
+	for serverAddr, dirs := range serverDirs {
+		host, portStr, err := net.SplitHostPort(serverAddr)
 		if err != nil {
-			fmt.Printf("Error when searching word %s: %s", keyword, err)
-			return
+			return nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, err
+		}
+		cli, err := client.NewClient(context.TODO(), host, port, dirs, opts...)
+		if err != nil {
+			return nil, err
 		}
-		allFiles = append(allFiles, filenames...)
+		clients = append(clients, cli)
 	}
-	if len(allFiles) == 0 {
-		fmt.Printf("No file contains the word \"%s\".\n", keyword)
-	} else {
-		fmt.Printf("Files containing the word \"%s\":\n", keyword)
-		for _, filename := range allFiles {
-			fmt.Printf("\t%s\n", filename)
+	return clients, nil
+}
+
+// standbyCheckInterval is the interval between two checks of the connection to
+// the search server, while waiting to fail over to the standby search server.
+const standbyCheckInterval = 5 * time.Second
+
+// setStandby has `cli` mirror its index writes to the standby search server at
+// `serverAddr`, and fail over to it once the search server has been
+// unreachable for `-standby_failover`, if set.
+func setStandby(cli *client.Client, serverAddr string) error {
+	host, portStr, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	if err := cli.SetStandby(context.TODO(), host, port, logger); err != nil {
+		return err
+	}
+	if *standbyFailover > 0 {
+		go watchFailover(cli, *standbyFailover)
+	}
+	return nil
+}
+
+// watchFailover fails `cli` over to its standby search server once the search
+// server has been unreachable for `after`.
+func watchFailover(cli *client.Client, after time.Duration) {
+	var unreachableSince time.Time
+	for range time.Tick(standbyCheckInterval) {
+		if !cli.ServerUnreachable(nil) {
+			unreachableSince = time.Time{}
+			continue
 		}
+		if unreachableSince.IsZero() {
+			unreachableSince = time.Now()
+		}
+		if time.Since(unreachableSince) < after {
+			continue
+		}
+		logger.Warnf("The search server has been unreachable for %s, failing over to the standby search server.", after)
+		if err := cli.FailOver(context.TODO()); err != nil {
+			logger.Errorf("Error when indexing again the files missed by the standby search server: %s", err)
+		}
+		return
 	}
-	fmt.Println()
+}
+
+// clientOptions returns the options of a client with the index parameters
+// `params`, sharing `budget`, and otherwise set by the flags.
+func clientOptions(params indexParams, budget *client.MemoryBudget) ([]client.Option, error) {
+	paramsIndexType, err := parseIndexType(params.indexType)
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.Option{
+		client.WithMasterSecretLength(params.lenMS),
+		client.WithSaltLength(params.lenSalt),
+		client.WithFPRate(params.fpRate),
+		client.WithNumUniqWords(params.numUniqWords),
+		client.WithEncryptedSalts(params.encryptSalts),
+		client.WithIndexType(paramsIndexType),
+		client.WithLogger(logger),
+		client.WithMemoryBudget(budget),
+		client.WithIndexWorkers(*indexWorkers),
+		client.WithUploadRetries(*uploadRetries, *uploadRetryDelay),
+		client.WithMaxUploadRate(*maxUploadBps),
+		client.WithBlindingPolicy(blindingPolicy),
+		client.WithResultBucketSize(*resultBucket),
+		client.WithIndexSizeBuckets(*sizeBuckets),
+		client.WithTlfSummaries(*tlfSummaries),
+		client.WithNearWindow(*nearWindow),
+		client.WithQueryLimit(*queryLimit, time.Minute, reportQueryAnomaly),
+		client.WithScanInterval(*scanInterval),
+		client.WithHistoryRevisions(*historyRevisions),
+		client.WithResultCache(*resultCacheSize),
+		client.WithMaxFileSize(*maxFileSize),
+		client.WithSkipBinary(*skipBinary),
+		client.WithSymlinkPolicy(client.SymlinkPolicy(*symlinks)),
+		client.WithClaimTTL(*claimTTL),
+		client.WithBackfillThreshold(*backfillThreshold),
+	}
+	if refusedWords != nil {
+		opts = append(opts, client.WithContentClassifier(client.NewPatternClassifier(refusedWords)))
+	}
+	if *simLatency > 0 || *simBandwidth > 0 {
+		opts = append(opts, client.WithSimulatedLink(*simLatency, *simBandwidth))
+	}
+	return opts, nil
 }
 
 func main() {
+	flag.Usage = func() { printUsage(os.Stderr) }
 	flag.Parse()
 
-	if *clientDirectories == "" {
+	cmdline := setFlags()
+	cfg, err := loadConfig(*configFile, cmdline["config"])
+	if err != nil {
+		fmt.Printf("Cannot load the config file: %s\n", err)
+		os.Exit(1)
+	}
+	if err := applyConfig(cfg, cmdline); err != nil {
+		fmt.Printf("Cannot apply the config file: %s\n", err)
+		os.Exit(1)
+	}
+
+	logFile, err := openLog(os.Stderr)
+	if err != nil {
+		fmt.Printf("Cannot set up the logging: %s\n", err)
+		os.Exit(1)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	if *jsonOutput && *outputFormat != "" {
+		fmt.Printf("Cannot use both -json and -format.\n")
+		os.Exit(1)
+	}
+	if *outputFormat != "" {
+		if resultTemplate, err = parseFormat(*outputFormat); err != nil {
+			fmt.Printf("Invalid output format: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *sortResults != "" && *sortResults != "mtime" && *sortResults != "name_match" {
+		fmt.Printf("Invalid sort order: %s\n", *sortResults)
+		os.Exit(1)
+	}
+
+	if policy := client.SymlinkPolicy(*symlinks); policy != client.SymlinkSkip && policy != client.SymlinkFollow {
+		fmt.Printf("Invalid symlink policy: %s\n", *symlinks)
+		os.Exit(1)
+	}
+
+	if *refusePattern != "" {
+		if refusedWords, err = regexp.Compile(*refusePattern); err != nil {
+			fmt.Printf("Invalid refuse pattern: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if blindingPolicy, err = parseBlindingPolicy(*blinding); err != nil {
+		fmt.Printf("Invalid blinding policy: %s\n", err)
+		os.Exit(1)
+	}
+
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "daemon" {
+		if len(args) > 1 {
+			fmt.Printf("Cannot run a subcommand with daemon.\n")
+			os.Exit(1)
+		}
+		*daemon = true
+		args = nil
+	}
+	// The subcommands querying the daemon, or needing no client, run without
+	// clients of their own, as do the unknown ones.
+	if len(args) > 0 {
+		if sub, _, _, err := lookupSubcommand(args); err != nil || sub.run == nil {
+			os.Exit(runSubcommand(nil, nil, args))
+		}
+	}
+
+	if *daemon {
+		if len(args) > 0 {
+			fmt.Printf("Cannot run a subcommand with -daemon.\n")
+			os.Exit(1)
+		}
+		if !*foreground && !isDetached() {
+			pid, err := detach(daemonOutputPath())
+			if err != nil {
+				fmt.Printf("Cannot detach the daemon: %s\n", err)
+				os.Exit(1)
+			}
+			if !*quiet {
+				fmt.Printf("Started the daemon in the background with pid %d.\n", pid)
+			}
+			return
+		}
+		if err := writePidFile(pidFilePath()); err != nil {
+			fmt.Printf("Cannot write the pidfile: %s\n", err)
+			os.Exit(1)
+		}
+		defer os.Remove(pidFilePath())
+	}
+
+	groups := groupDirectories(cfg, cmdline)
+	if len(groups) == 0 {
 		fmt.Printf("Please provide at least one client directory.\n")
 		os.Exit(1)
 	}
+	if *dryRun {
+		reportDryRun(groups)
+		return
+	}
+	// The directories added through the control interface are indexed with
+	// the parameters of the flags, by the client of the directories sharing
+	// them.
+	defaults := indexParams{*lenMS, *lenSalt, *fpRate, *numUniqWords, *encryptSalts, *indexType}
+	defaultGroup := -1
+	for i, group := range groups {
+		if group.params == defaults {
+			defaultGroup = i
+		}
+	}
+	if defaultGroup < 0 && len(args) == 0 {
+		defaultGroup = len(groups)
+		groups = append(groups, dirGroup{params: defaults})
+	}
 
-	clientDirs := strings.Split(*clientDirectories, ";")
+	// A single memory budget bounds the index builds of all the clients.
+	budget := client.NewMemoryBudget(*memBudget)
 
-	// Initiate the search client
-	cli, err := client.CreateClient(context.TODO(), *ipAddr, *port, clientDirs, *lenMS, *lenSalt, *fpRate, *numUniqWords, *verbose)
+	// Initiate one search client per set of index parameters.
+	var indexing sync.WaitGroup
+	var localClients []*client.Client
+	groupClients := make(map[indexParams]*client.Client)
+	for _, group := range groups {
+		params := group.params
+		opts, err := clientOptions(params, budget)
+		if err != nil {
+			fmt.Printf("Invalid index type: %s\n", err)
+			os.Exit(1)
+		}
+		cli, err := client.NewClient(context.TODO(), *ipAddr, *port, group.directories, opts...)
+		if err != nil {
+			fmt.Printf("Cannot initialize the client: %s\n", err)
+			os.Exit(1)
+		}
+		if *standbyServer != "" {
+			if err := setStandby(cli, *standbyServer); err != nil {
+				fmt.Printf("Cannot set the standby search server: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		localClients = append(localClients, cli)
+		groupClients[params] = cli
+	}
+
+	serverDirs, err := parseExtraServers(*extraServers)
+	if err != nil {
+		fmt.Printf("Cannot parse the extra servers: %s\n", err)
+		os.Exit(1)
+	}
+	extraClients, err := createExtraClients(serverDirs, budget)
 	if err != nil {
-		fmt.Printf("Cannot initialize the client: %s\n", err)
+		fmt.Printf("Cannot initialize the extra clients: %s\n", err)
 		os.Exit(1)
 	}
+	allClients := append(localClients, extraClients...)
+
+	if *showCoverage {
+		reportCoverage(allClients)
+		return
+	}
+	if len(args) > 0 {
+		os.Exit(runSubcommand(localClients, allClients, args))
+	}
+	if *debug {
+		if profiler, err = newSelfProfiler(filepath.Join(*stateDir, profileDirName)); err != nil {
+			fmt.Printf("Cannot create the profile directory: %s\n", err)
+			os.Exit(1)
+		}
+		serveDebug(*debugAddr)
+		go profiler.watchBursts(allClients)
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr, allClients)
+	}
+	for _, cli := range allClients {
+		startIndexing(cli, &indexing)
+	}
+	if *progressInterval > 0 && !*quiet {
+		go reportProgress(allClients, *progressInterval)
+	}
+
+	if *controlSocket != "none" {
+		socketPath := controlSocketPath()
+		handler := &controlHandler{clients: localClients, startTime: time.Now(), watching: *watch, indexing: &indexing}
+		if defaultGroup >= 0 {
+			handler.newDirClient = localClients[defaultGroup]
+		}
+		if err := serveControl(socketPath, handler); err != nil {
+			fmt.Printf("Cannot serve the control interface: %s\n", err)
+			os.Exit(1)
+		}
+		defer os.Remove(socketPath)
+	}
 
-	go periodicAdd(cli, clientDirs)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	if *daemon {
+		serveDaemon(&configReloader{cmdline, groupClients, configDirectories(groups), &indexing}, signals)
+		logger.Infof("Shutting down, waiting for the scans in progress.")
+		shutdown(allClients, &indexing, signals)
+		return
+	}
+
+	// Keeps the prompt out of the formatted results, which are meant to be
+	// piped into other tools.
+	prompt := os.Stdout
+	if structuredOutput() {
+		prompt = os.Stderr
+	}
+	const instructions = "Please enter words to search for separated by spaces, optionally filtered by ext:, size:, year: or in: (enter to exit)"
+	editor, err := newLineEditor(os.Stdin, prompt, instructions+": ", filepath.Join(*stateDir, "history"), func() []string {
+		return directoryNames(localClients)
+	})
+	if err != nil {
+		fmt.Printf("Cannot load the search history: %s\n", err)
+		os.Exit(1)
+	}
+	if editor.terminal != nil {
+		// The line is redrawn on each key, which requires a prompt
+		// fitting on the line.
+		fmt.Fprintf(prompt, "%s, with Tab to complete and the arrows to recall the previous searches.\n", instructions)
+		editor.prompt = "search> "
+		// The results are only paged when listed on the terminal too.
+		if restore, err := makeRaw(os.Stdout.Fd()); err == nil {
+			restore()
+			if !structuredOutput() {
+				resultPager = &pager{out: os.Stdout, readKey: editor.readKey, size: pageSize}
+			}
+		}
+	}
 
-	reader := bufio.NewReader(os.Stdin)
+	// Reads the queries in the background, so that a signal interrupts the
+	// prompt.  A line is only read when requested, so that the terminal is
+	// left to the picker in between.
+	requests := make(chan struct{})
+	queries := make(chan string)
+	go func() {
+		for range requests {
+			input, err := editor.readLine()
+			if err != nil && err != io.EOF {
+				logger.Warnf("Error when reading the query: %s", err)
+			}
+			queries <- input
+		}
+	}()
 
+loop:
 	for {
-		fmt.Print("Please enter words to search for separated by spaces (enter to exit): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimRight(input, "\n")
+		requests <- struct{}{}
+		var input string
+		select {
+		case input = <-queries:
+		case <-signals:
+			editor.restoreTerminal()
+			fmt.Fprintln(prompt)
+			break loop
+		}
 		if input == "" {
-			break
+			break loop
 		}
-		keywords := strings.Split(input, " ")
-		for _, keyword := range keywords {
-			performSearchWord(cli, clientDirs, keyword)
+		if err := performSearch(localClients, allClients, strings.Fields(input)); err != nil {
+			fmt.Printf("Error when searching \"%s\": %s\n", input, err)
 		}
 	}
+
+	fmt.Fprintln(prompt, "Shutting down, waiting for the scans in progress (interrupt again to exit right away).")
+	shutdown(allClients, &indexing, signals)
 }
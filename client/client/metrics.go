@@ -0,0 +1,37 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/keybase/search/client"
+)
+
+// metricsHandler serves the metrics of its clients in the Prometheus text
+// format.
+type metricsHandler struct {
+	clients []*client.Client
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := client.WriteMetrics(w, h.clients); err != nil {
+		logger.Warnf("Cannot write the metrics: %s", err)
+	}
+}
+
+// serveMetrics serves the metrics of the `clients` under /metrics at `addr` in
+// the background.  Unlike `serveDebug`, the pprof endpoints are not served.
+func serveMetrics(addr string, clients []*client.Client) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler{clients: clients})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Errorf("Cannot serve the metrics at %s: %s", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mtimeLayout is the layout of the dates of the `after:` and `before:`
+// keywords.
+const mtimeLayout = "2006-01-02"
+
+// timeFilter holds the `after:` and `before:` keywords restricting the results
+// of a query to the files modified within a range of dates, as well as the
+// order of the results set by `-sort`, the archive folders left out of them
+// and the maximum number of results set by `--limit`.  The modification times
+// are those of the local files, as the search server does not know them.
+type timeFilter struct {
+	after       time.Time    // The files modified before are filtered out.  No bound if zero.
+	before      time.Time    // The files modified at or after are filtered out.  No bound if zero.
+	archives    []string     // The archive folders whose files are filtered out, as parsed by `parseArchiveFolders`.
+	byModified  bool         // Whether the results are sorted by modification time, newest first, instead of by path.
+	byNameMatch bool         // Whether the results are ranked by the words of the query in their names, most first, instead of sorted by path.
+	recency     recencyBoost // The boost of the recently modified files when ranked by the words in their names.
+	limit       int          // The maximum number of results printed out per query.  No limit if 0.
+}
+
+// parseTimeFilter separates the `after:` and `before:` keywords and the
+// `--limit N` option from the other `keywords`, and returns them along with the
+// order set by `-sort` and the archive folders of `-archive_folders`, unless
+// `-include_archived` is set.  Returns an error if a date or the limit is
+// invalid.
+func parseTimeFilter(keywords []string) ([]string, timeFilter, error) {
+	var rest []string
+	filter := timeFilter{
+		byModified:  *sortResults == "mtime",
+		byNameMatch: *sortResults == "name_match",
+		recency:     recencyBoost{weight: *recencyWeight, halfLife: *recencyHalfLife},
+	}
+	if !*includeArchived {
+		filter.archives = parseArchiveFolders(*archiveFolders)
+	}
+	for i := 0; i < len(keywords); i++ {
+		keyword := keywords[i]
+		var bound *time.Time
+		var value string
+		if keyword == "--limit" || strings.HasPrefix(keyword, "--limit=") {
+			value = strings.TrimPrefix(keyword, "--limit=")
+			if keyword == "--limit" && i+1 < len(keywords) {
+				i++
+				value = keywords[i]
+			}
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return nil, timeFilter{}, fmt.Errorf("invalid limit \"%s\", expected a positive number of results", value)
+			}
+			filter.limit = limit
+			continue
+		} else if strings.HasPrefix(keyword, "after:") {
+			bound, value = &filter.after, strings.TrimPrefix(keyword, "after:")
+		} else if strings.HasPrefix(keyword, "before:") {
+			bound, value = &filter.before, strings.TrimPrefix(keyword, "before:")
+		} else {
+			rest = append(rest, keyword)
+			continue
+		}
+		date, err := time.ParseInLocation(mtimeLayout, value, time.Local)
+		if err != nil {
+			return nil, timeFilter{}, fmt.Errorf("invalid date in \"%s\", expected e.g. %s", keyword, mtimeLayout)
+		}
+		*bound = date
+	}
+	return rest, filter, nil
+}
+
+// isSet returns whether the filter restricts the results by date or reorders
+// them.
+func (f timeFilter) isSet() bool {
+	return !f.after.IsZero() || !f.before.IsZero() || f.byModified || f.byNameMatch
+}
+
+// applyPaths filters the `filenames` of the client directory `directory`
+// modified within the range of dates and out of the archive folders, and sorts
+// them by modification time or ranks them by the words of the query in their
+// names if requested.  The
+// files that cannot be stat'ed are filtered out by a range of dates, and
+// sorted last otherwise.
+func (f timeFilter) applyPaths(directory string, filenames []string) []string {
+	results := make([]searchResult, len(filenames))
+	for i, filename := range filenames {
+		results[i].Directory, results[i].Path = directory, filename
+	}
+	results = f.apply(results)
+	filtered := make([]string, len(results))
+	for i, result := range results {
+		filtered[i] = result.Path
+	}
+	return filtered
+}
+
+// truncate returns the first of the `results` up to the limit, if any.
+func (f timeFilter) truncate(results []searchResult) []searchResult {
+	if f.limit > 0 && len(results) > f.limit {
+		return results[:f.limit]
+	}
+	return results
+}
+
+// apply is similar to `applyPaths`, but for the files of the `results`.
+func (f timeFilter) apply(results []searchResult) []searchResult {
+	if len(f.archives) > 0 {
+		var kept []searchResult
+		for _, result := range results {
+			if !isArchived(f.archives, result.Directory, result.Path) {
+				kept = append(kept, result)
+			}
+		}
+		results = kept
+	}
+	if !f.isSet() {
+		return results
+	}
+	modTimes := make(map[string]time.Time, len(results))
+	var filtered []searchResult
+	for _, result := range results {
+		info, err := os.Stat(result.Path)
+		if err != nil {
+			if f.after.IsZero() && f.before.IsZero() {
+				filtered = append(filtered, result)
+			}
+			continue
+		}
+		modTime := info.ModTime()
+		if (!f.after.IsZero() && modTime.Before(f.after)) || (!f.before.IsZero() && !modTime.Before(f.before)) {
+			continue
+		}
+		modTimes[result.Path] = modTime
+		filtered = append(filtered, result)
+	}
+	if f.byModified {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return modTimes[filtered[i].Path].After(modTimes[filtered[j].Path])
+		})
+	} else if f.byNameMatch {
+		now := time.Now()
+		scores := make([]float64, len(filtered))
+		for i, result := range filtered {
+			if modTime, ok := modTimes[result.Path]; ok {
+				scores[i] = f.recency.nameMatch(result.Query, result.Path, modTime, now)
+			} else {
+				scores[i] = math.Inf(-1)
+			}
+		}
+		sort.Stable(byScore{filtered, scores})
+	}
+	return filtered
+}
+
+// byScore sorts the results by decreasing score.
+type byScore struct {
+	results []searchResult
+	scores  []float64
+}
+
+// Len implements the sort.Interface interface.
+func (s byScore) Len() int { return len(s.results) }
+
+// Less implements the sort.Interface interface.
+func (s byScore) Less(i, j int) bool { return s.scores[i] > s.scores[j] }
+
+// Swap implements the sort.Interface interface.
+func (s byScore) Swap(i, j int) {
+	s.results[i], s.results[j] = s.results[j], s.results[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
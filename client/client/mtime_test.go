@@ -0,0 +1,97 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestParseTimeFilter tests the `parseTimeFilter` function.  Checks that the
+// `after:` and `before:` keywords and the `--limit` option are separated from
+// the other keywords, and that an invalid date or limit is rejected.
+func TestParseTimeFilter(t *testing.T) {
+	keywords, filter, err := parseTimeFilter([]string{"report", "after:2016-01-01", "ext:pdf", "before:2016-02-01"})
+	if err != nil {
+		t.Fatalf("error when parsing the time filter: %s", err)
+	}
+	if !reflect.DeepEqual([]string{"report", "ext:pdf"}, keywords) {
+		t.Fatalf("incorrect keywords left: %v", keywords)
+	}
+	if !filter.after.Equal(time.Date(2016, 1, 1, 0, 0, 0, 0, time.Local)) || !filter.before.Equal(time.Date(2016, 2, 1, 0, 0, 0, 0, time.Local)) || filter.byModified {
+		t.Fatalf("incorrect time filter parsed: %+v", filter)
+	}
+	if _, _, err := parseTimeFilter([]string{"report", "after:yesterday"}); err == nil {
+		t.Fatalf("no error for an invalid date")
+	}
+
+	for _, option := range [][]string{{"--limit", "5"}, {"--limit=5"}} {
+		keywords, filter, err := parseTimeFilter(append([]string{"report"}, option...))
+		if err != nil || !reflect.DeepEqual([]string{"report"}, keywords) || filter.limit != 5 {
+			t.Fatalf("incorrect limit parsed from %v: %v, %+v, %v", option, keywords, filter, err)
+		}
+	}
+	for _, option := range [][]string{{"--limit"}, {"--limit", "0"}, {"--limit=many"}} {
+		if _, _, err := parseTimeFilter(append([]string{"report"}, option...)); err == nil {
+			t.Fatalf("no error for the invalid limit %v", option)
+		}
+	}
+}
+
+// TestTimeFilterApply tests the `apply` function of the `timeFilter`.  Checks
+// that the results are filtered by the range of modification times, and sorted
+// by modification time with the missing files last.
+func TestTimeFilterApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtime")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var results []searchResult
+	for i, modTime := range []time.Time{
+		time.Date(2015, 6, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2016, 6, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2016, 3, 1, 0, 0, 0, 0, time.Local),
+	} {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(path, []byte("content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+		results = append(results, searchResult{"content", dir, path})
+	}
+	missing := searchResult{"content", dir, filepath.Join(dir, "missing")}
+	results = append(results, missing)
+
+	paths := func(results []searchResult) []string {
+		var paths []string
+		for _, result := range results {
+			paths = append(paths, filepath.Base(result.Path))
+		}
+		return paths
+	}
+	tests := []struct {
+		filter   timeFilter
+		expected []string
+	}{
+		{timeFilter{}, []string{"a", "b", "c", "missing"}},
+		{timeFilter{after: time.Date(2016, 1, 1, 0, 0, 0, 0, time.Local)}, []string{"b", "c"}},
+		{timeFilter{before: time.Date(2016, 6, 1, 0, 0, 0, 0, time.Local)}, []string{"a", "c"}},
+		{timeFilter{byModified: true}, []string{"b", "c", "a", "missing"}},
+		{timeFilter{after: time.Date(2016, 1, 1, 0, 0, 0, 0, time.Local), byModified: true}, []string{"b", "c"}},
+	}
+	for _, test := range tests {
+		if actual := paths(test.filter.apply(results)); !reflect.DeepEqual(test.expected, actual) {
+			t.Fatalf("incorrect results for %+v: expected %v actual %v", test.filter, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// pageSize is the number of lines of results listed at once at the interactive
+// prompt, before waiting for a key to list more.
+const pageSize = 20
+
+// morePrompt is printed out at the end of each page of results.
+const morePrompt = "-- More: space for the next page, enter for the next line, q to stop --"
+
+// resultPager lists the results at the interactive prompt a page at a time.
+// Nil if the queries and the results are not both on a terminal, in which case
+// the results are listed at once.
+var resultPager *pager
+
+// pager pauses the listing of the results once a page is full, until a key is
+// typed.
+type pager struct {
+	out     io.Writer            // The terminal the results are listed on.
+	readKey func() (rune, error) // Reads a key typed at the terminal.
+	size    int                  // The number of lines per page.
+}
+
+// more waits for a key at the end of a page, and returns the number of lines
+// to list before the next pause: a page on space, a line on enter, and none
+// otherwise, e.g. on `q`, to stop the listing.
+func (p *pager) more() int {
+	fmt.Fprint(p.out, morePrompt)
+	key, err := p.readKey()
+	fmt.Fprint(p.out, "\r\x1b[K")
+	switch {
+	case err != nil:
+		return 0
+	case key == ' ':
+		return p.size
+	case key == '\r' || key == '\n':
+		return 1
+	}
+	return 0
+}
+
+// listing prints out the results of a query at the interactive prompt, up to
+// a limit, and a page at a time with a pager.
+type listing struct {
+	out     io.Writer
+	pager   *pager // Pauses the listing once a page is full.  Lists all the results at once if nil.
+	limit   int    // The maximum number of results listed.  No limit if 0.
+	left    int    // The number of lines left to list before the next pause.
+	listed  int    // The number of results listed so far.
+	omitted int    // The number of results left out by the limit or by stopping the listing.
+	stopped bool   // Whether the listing has been stopped at the end of a page.
+}
+
+// newListing creates a `listing` of at most `limit` results on `out`, paged
+// with `resultPager` if set.
+func newListing(out io.Writer, limit int) *listing {
+	l := &listing{out: out, pager: resultPager, limit: limit}
+	if l.pager != nil {
+		l.left = l.pager.size
+	}
+	return l
+}
+
+// line prints out `line` as part of the listing, first waiting for a key if
+// the page is full.  Returns false if the listing has been stopped.
+func (l *listing) line(line string) bool {
+	if l.stopped {
+		return false
+	}
+	if l.pager != nil && l.left == 0 {
+		if l.left = l.pager.more(); l.left == 0 {
+			l.stopped = true
+			return false
+		}
+	}
+	fmt.Fprintln(l.out, line)
+	l.left--
+	return true
+}
+
+// header lists `line` heading the next results, unless no more results are
+// listed.
+func (l *listing) header(line string) {
+	if l.limit == 0 || l.listed < l.limit {
+		l.line(line)
+	}
+}
+
+// result lists the result labeled `label`, unless beyond the limit or the
+// listing has been stopped.
+func (l *listing) result(label string) {
+	if (l.limit > 0 && l.listed >= l.limit) || !l.line("\t"+label) {
+		l.omitted++
+		return
+	}
+	l.listed++
+}
+
+// end prints out the number of results left out of the listing, if any.
+func (l *listing) end() {
+	if l.omitted > 0 {
+		fmt.Fprintf(l.out, "\t(%d more not listed)\n", l.omitted)
+	}
+}
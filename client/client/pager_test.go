@@ -0,0 +1,45 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestListing tests the `listing` type.  Checks that the results beyond the
+// limit are counted instead of listed, and that the pager waits for a key at
+// the end of each page, going on by a page or a line, or stopping the listing.
+func TestListing(t *testing.T) {
+	var out bytes.Buffer
+	l := &listing{out: &out, limit: 2}
+	for _, label := range []string{"a", "b", "c"} {
+		l.result(label)
+	}
+	l.end()
+	if expected := "\ta\n\tb\n\t(1 more not listed)\n"; out.String() != expected {
+		t.Fatalf("incorrect limited listing: %q, expected %q", out.String(), expected)
+	}
+
+	keys := []rune{' ', '\n', 'q'}
+	out.Reset()
+	p := &pager{out: &out, size: 2, readKey: func() (rune, error) {
+		key := keys[0]
+		keys = keys[1:]
+		return key, nil
+	}}
+	l = &listing{out: &out, pager: p, left: p.size}
+	for _, label := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		l.result(label)
+	}
+	l.end()
+	if len(keys) != 0 || l.listed != 5 || l.omitted != 2 {
+		t.Fatalf("incorrect paged listing: %d keys left, %d listed, %d omitted", len(keys), l.listed, l.omitted)
+	}
+	if strings.Count(out.String(), morePrompt) != 3 || !strings.HasSuffix(out.String(), "\t(2 more not listed)\n") {
+		t.Fatalf("incorrect paged listing: %q", out.String())
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/keybase/search/client"
+	"golang.org/x/net/context"
+)
+
+// drain discards the rest of `paths` in the background, so that the search
+// streaming them is not blocked.
+func drain(paths <-chan string) {
+	go func() {
+		for range paths {
+		}
+	}()
+}
+
+// pickPath runs the fuzzy picker `command`, e.g. "fzf", with the `paths`
+// streamed to its standard input as they are received, and returns the path
+// selected by the user.  Returns an empty path if nothing has been selected.
+// `paths` is consumed until closed in all cases.
+func pickPath(command string, paths <-chan string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		drain(paths)
+		return "", errors.New("empty picker command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		drain(paths)
+		return "", err
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		drain(paths)
+		return "", err
+	}
+
+	go func() {
+		// Keeps draining `paths` after the picker has exited, so that
+		// the search is not blocked.
+		var writeErr error
+		for path := range paths {
+			if writeErr == nil {
+				_, writeErr = io.WriteString(stdin, path+"\n")
+			}
+		}
+		stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	selected := strings.TrimRight(stdout.String(), "\n")
+	if _, ok := err.(*exec.ExitError); ok && selected == "" {
+		// The pickers exit with an error when the selection is
+		// aborted or nothing matches.
+		return "", nil
+	}
+	return selected, err
+}
+
+// performPickSearch searches for the files matching all the `keywords` in the
+// directories of the `clients` within `scope`, streaming the results into the `-picker` as
+// they come in.  The results of each directory are filtered and sorted by the
+// `filter` separately.  The file selected by the user is opened with `-open` if set,
+// or printed out otherwise.
+func performPickSearch(ctx context.Context, clients []*client.Client, scope searchScope, filter timeFilter, keywords []string) error {
+	query := strings.Join(keywords, " ")
+	paths := make(chan string)
+	searchErrs := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		for _, cli := range clients {
+			for _, clientDir := range scope.directories(cli) {
+				filenames, err := cli.SearchQueryStrict(ctx, clientDir, query)
+				if err != nil {
+					searchErrs <- err
+					return
+				}
+				for _, filename := range filter.applyPaths(clientDir, filenames) {
+					paths <- filename
+				}
+			}
+		}
+		searchErrs <- nil
+	}()
+
+	selected, err := pickPath(*picker, paths)
+	if searchErr := <-searchErrs; searchErr != nil {
+		return searchErr
+	}
+	if err != nil {
+		return fmt.Errorf("error when running the picker: %s", err)
+	}
+	if selected == "" {
+		return nil
+	}
+	if *openCommand == "" {
+		fmt.Println(selected)
+		return nil
+	}
+	args := append(strings.Fields(*openCommand), selected)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error when opening \"%s\": %s", selected, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestPickPath tests the `pickPath` function.  Checks that the paths are
+// streamed to the picker, that its selection is returned, and that an aborted
+// selection yields an empty path without blocking the stream.
+func TestPickPath(t *testing.T) {
+	stream := func(paths ...string) <-chan string {
+		ch := make(chan string)
+		go func() {
+			for _, path := range paths {
+				ch <- path
+			}
+			close(ch)
+		}()
+		return ch
+	}
+
+	selected, err := pickPath("tail -n 1", stream("/keybase/private/alice/a.txt", "/keybase/private/alice/b.txt"))
+	if err != nil || selected != "/keybase/private/alice/b.txt" {
+		t.Fatalf("incorrect selection: %q, %v", selected, err)
+	}
+	if selected, err := pickPath("false", stream("/keybase/private/alice/a.txt")); err != nil || selected != "" {
+		t.Fatalf("incorrect aborted selection: %q, %v", selected, err)
+	}
+	if _, err := pickPath("no-such-picker-command", stream()); err == nil {
+		t.Fatalf("no error for a missing picker")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	_ "net/http/pprof" // Registers the /debug/pprof endpoints.
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/search/client"
+)
+
+const (
+	// profileDirName is the name of the directory within the state directory
+	// the self-profiles are written to.
+	profileDirName = "profiles"
+	// cpuProfileDuration is how long the CPU is profiled for once an indexing
+	// burst is detected.
+	cpuProfileDuration = 30 * time.Second
+	// profileInterval is the minimum interval between two self-profiles of
+	// the same kind, which bounds the overhead of the profiling.
+	profileInterval = 10 * time.Minute
+	// burstCheckInterval is the interval between two checks for indexing
+	// bursts.
+	burstCheckInterval = 5 * time.Second
+	// burstMinRemaining is the number of files remaining to be indexed by a
+	// scan beyond which it is considered an indexing burst.
+	burstMinRemaining = 100
+	// maxProfiles is the number of self-profiles of each kind kept, the
+	// oldest ones being removed first.
+	maxProfiles = 10
+)
+
+// profiler writes the self-profiles of the daemon, or is nil if `-debug` is
+// not set.
+var profiler *selfProfiler
+
+// selfProfiler writes the CPU profiles of the daemon during the indexing
+// bursts, and its heap profiles after the scans, to a directory.
+type selfProfiler struct {
+	dir      string     // The directory the profiles are written to.
+	lock     sync.Mutex // Protects the fields below.
+	lastCPU  time.Time  // The time the last CPU profile started.
+	lastHeap time.Time  // The time the last heap profile was written.
+}
+
+// newSelfProfiler creates a profiler writing its profiles under `dir`.
+func newSelfProfiler(dir string) (*selfProfiler, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &selfProfiler{dir: dir}, nil
+}
+
+// profilePath returns the path of a new profile of `kind` started at `now`.
+func (p *selfProfiler) profilePath(kind string, now time.Time) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%s-%s.pprof", kind, now.Format("20060102-150405")))
+}
+
+// startCPUProfile profiles the CPU for `cpuProfileDuration` from `now`, unless
+// a CPU profile has been started in the last `profileInterval`.  Does nothing
+// if the CPU is already being profiled, e.g. through the pprof endpoints.
+func (p *selfProfiler) startCPUProfile(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.lastCPU.IsZero() && now.Sub(p.lastCPU) < profileInterval {
+		return
+	}
+	p.lastCPU = now
+	path := p.profilePath("cpu", now)
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Warnf("Cannot create the CPU profile: %s", err)
+		return
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		logger.Warnf("Cannot start the CPU profile: %s", err)
+		f.Close()
+		os.Remove(path)
+		return
+	}
+	logger.Infof("Profiling the CPU during an indexing burst to \"%s\"", path)
+	time.AfterFunc(cpuProfileDuration, func() {
+		pprof.StopCPUProfile()
+		f.Close()
+		p.prune("cpu")
+	})
+}
+
+// writeHeapProfile writes a heap profile as of `now`, unless one has been
+// written in the last `profileInterval`.
+func (p *selfProfiler) writeHeapProfile(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.lastHeap.IsZero() && now.Sub(p.lastHeap) < profileInterval {
+		return
+	}
+	p.lastHeap = now
+	f, err := os.Create(p.profilePath("heap", now))
+	if err != nil {
+		logger.Warnf("Cannot create the heap profile: %s", err)
+		return
+	}
+	defer f.Close()
+	// Collects the garbage first so that the profile reflects the memory
+	// actually retained by the scan.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		logger.Warnf("Cannot write the heap profile: %s", err)
+		return
+	}
+	p.prune("heap")
+}
+
+// prune removes the oldest profiles of `kind` beyond the `maxProfiles` most
+// recent ones.
+func (p *selfProfiler) prune(kind string) {
+	entries, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), kind+"-") && strings.HasSuffix(entry.Name(), ".pprof") {
+			names = append(names, entry.Name())
+		}
+	}
+	// The names sort by time, as their timestamps have a fixed width.
+	sort.Strings(names)
+	for len(names) > maxProfiles {
+		os.Remove(filepath.Join(p.dir, names[0]))
+		names = names[1:]
+	}
+}
+
+// afterScan writes a heap profile after the successful scan of `report`.
+// Does nothing if `p` is nil.
+func (p *selfProfiler) afterScan(report client.IndexReport) {
+	if p == nil || report.Err != nil {
+		return
+	}
+	p.writeHeapProfile(time.Now())
+}
+
+// watchBursts profiles the CPU whenever a scan of the directories of `clients`
+// has at least `burstMinRemaining` files remaining to be indexed.
+func (p *selfProfiler) watchBursts(clients []*client.Client) {
+	for range time.Tick(burstCheckInterval) {
+		for _, cli := range clients {
+			for _, directory := range cli.Directories() {
+				progress, scanning, err := cli.GetScanProgress(directory)
+				if err == nil && scanning && progress.Remaining() >= burstMinRemaining {
+					p.startCPUProfile(time.Now())
+				}
+			}
+		}
+	}
+}
+
+// serveDebug serves the pprof endpoints under /debug/pprof/ at `addr` in the
+// background.
+func serveDebug(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logger.Errorf("Cannot serve the pprof endpoints at %s: %s", addr, err)
+		}
+	}()
+}
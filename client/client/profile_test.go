@@ -0,0 +1,46 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWriteHeapProfile tests the `writeHeapProfile` function.  Checks that the
+// heap profiles are written at most once per `profileInterval`, and that only
+// the `maxProfiles` most recent ones are kept.
+func TestWriteHeapProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := newSelfProfiler(filepath.Join(dir, profileDirName))
+	if err != nil {
+		t.Fatalf("error when creating the profiler: %s", err)
+	}
+	start := time.Date(2016, 6, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < maxProfiles+3; i++ {
+		now := start.Add(time.Duration(i) * profileInterval)
+		p.writeHeapProfile(now)
+		p.writeHeapProfile(now.Add(time.Minute))
+	}
+
+	entries, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		t.Fatalf("error when listing the profiles: %s", err)
+	}
+	if len(entries) != maxProfiles {
+		t.Fatalf("incorrect number of profiles kept: %d", len(entries))
+	}
+	if oldest := filepath.Base(p.profilePath("heap", start.Add(3*profileInterval))); entries[0].Name() != oldest {
+		t.Fatalf("incorrect oldest profile kept: expected %s actual %s", oldest, entries[0].Name())
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/keybase/search/client"
+)
+
+// formatBytes formats `n` bytes with a binary unit prefix, e.g. "1.5 MB".
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// formatProgress summarizes `progress` as of `now` on a single line.
+func formatProgress(progress client.ScanProgress, now time.Time) string {
+	return fmt.Sprintf("Indexing \"%s\": %d/%d files processed, %d remaining, %s/s",
+		progress.Directory, progress.Processed, progress.Discovered, progress.Remaining(), formatBytes(progress.BytesPerSecond(now)))
+}
+
+// reportProgress prints out a summary of the progress of the scans of the
+// directories of `clients` with files remaining to be indexed to the standard
+// error every `interval`, so that long initial scans give some feedback.
+func reportProgress(clients []*client.Client, interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, cli := range clients {
+			for _, directory := range cli.Directories() {
+				progress, scanning, err := cli.GetScanProgress(directory)
+				if err != nil || !scanning || progress.Remaining() == 0 {
+					continue
+				}
+				fmt.Fprintln(os.Stderr, formatProgress(progress, time.Now()))
+			}
+		}
+	}
+}
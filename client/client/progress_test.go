@@ -0,0 +1,28 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/search/client"
+)
+
+// TestFormatProgress tests the `formatProgress` function.  Checks that the
+// counts of files and the rate are rendered with readable units.
+func TestFormatProgress(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	progress := client.ScanProgress{Directory: "/keybase/private/alice", Start: start, Discovered: 500, Processed: 120, Bytes: 3 << 20}
+	expected := "Indexing \"/keybase/private/alice\": 120/500 files processed, 380 remaining, 1.5 MB/s"
+	if line := formatProgress(progress, start.Add(2*time.Second)); line != expected {
+		t.Fatalf("incorrect progress: %q", line)
+	}
+	for n, expected := range map[float64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 << 30: "5.0 GB"} {
+		if formatted := formatBytes(n); formatted != expected {
+			t.Fatalf("incorrect formatting of %.0f bytes: %q", n, formatted)
+		}
+	}
+}
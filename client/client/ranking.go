@@ -0,0 +1,66 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// recencyBoost is the boost the recently modified files get when the results
+// are ranked by the words of the query in their names, so that the documents
+// being edited surface above the stale ones for the ambiguous queries.
+type recencyBoost struct {
+	weight   float64       // The boost of a file modified just now, in words of the query matched by its name.  No boost if 0.
+	halfLife time.Duration // The age at which the boost of a file is halved.
+}
+
+// of returns the boost of a file modified at `modTime`, as of `now`.  A file
+// modified in the future, e.g. through clock skew, gets the full boost.
+func (b recencyBoost) of(modTime, now time.Time) float64 {
+	if b.weight == 0 || b.halfLife <= 0 {
+		return 0
+	}
+	age := now.Sub(modTime)
+	if age < 0 {
+		age = 0
+	}
+	return b.weight * math.Exp2(-float64(age)/float64(b.halfLife))
+}
+
+// nameWords returns the normalized words of the name of the file at `path`,
+// split on anything but the letters and the digits.
+func nameWords(path string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(filepath.Base(path), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[libsearch.NormalizeKeyword(word)] = true
+	}
+	return words
+}
+
+// nameMatch returns the score of the file at `path` modified at `modTime` as a
+// result of `query`, as of `now`: the number of words of the query in the name
+// of the file, plus the recency boost of the file.  The content is not
+// weighed, as all the results match the query.
+func (b recencyBoost) nameMatch(query, path string, modTime, now time.Time) float64 {
+	names := nameWords(path)
+	score := 0.0
+	for _, term := range strings.Fields(query) {
+		if _, ok := libsearch.ParseMetadataKeyword(term); ok || term == libsearch.NearOperator {
+			continue
+		}
+		if names[libsearch.NormalizeKeyword(term)] {
+			score++
+		}
+	}
+	return score + b.of(modTime, now)
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestRecencyBoost tests the `of` function of the `recencyBoost`.  Checks that
+// the boost is full for the files modified just now or in the future, halved
+// after the half-life, and disabled with no weight.
+func TestRecencyBoost(t *testing.T) {
+	now := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	boost := recencyBoost{weight: 2, halfLife: time.Hour}
+	for _, test := range []struct {
+		modTime  time.Time
+		expected float64
+	}{
+		{now, 2},
+		{now.Add(time.Minute), 2},
+		{now.Add(-time.Hour), 1},
+		{now.Add(-2 * time.Hour), 0.5},
+	} {
+		if actual := boost.of(test.modTime, now); actual != test.expected {
+			t.Fatalf("incorrect boost of a file modified at %s: expected %v actual %v", test.modTime, test.expected, actual)
+		}
+	}
+	if actual := (recencyBoost{halfLife: time.Hour}).of(now, now); actual != 0 {
+		t.Fatalf("boost without weight: %v", actual)
+	}
+}
+
+// TestTimeFilterNameMatch tests the `apply` function of the `timeFilter` with
+// the results ranked by the words of the query in their names.  Checks that the
+// files with the words of the query in their names come first, unless
+// outweighed by the recency boost of the others, and that the missing files
+// come last.
+func TestTimeFilterNameMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "name_match")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var results []searchResult
+	for name, age := range map[string]time.Duration{"annual-report.txt": 30 * 24 * time.Hour, "notes.txt": 0} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("report"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	for _, name := range []string{"annual-report.txt", "missing", "notes.txt"} {
+		results = append(results, searchResult{"report", dir, filepath.Join(dir, name)})
+	}
+
+	for weight, expected := range map[float64][]string{
+		0:   {"annual-report.txt", "notes.txt", "missing"},
+		0.5: {"annual-report.txt", "notes.txt", "missing"},
+		2:   {"notes.txt", "annual-report.txt", "missing"},
+	} {
+		filter := timeFilter{byNameMatch: true, recency: recencyBoost{weight: weight, halfLife: 24 * time.Hour}}
+		var actual []string
+		for _, result := range filter.apply(append([]searchResult(nil), results...)) {
+			actual = append(actual, filepath.Base(result.Path))
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("incorrect ranking with a boost of %v: expected %v actual %v", weight, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+	"golang.org/x/net/context"
+)
+
+const (
+	// selftestTokenPrefix is the prefix of the random word written to the
+	// self-test files.
+	selftestTokenPrefix = "kbfssearchselftest"
+	// selftestPollInterval is the interval between two searches for the word
+	// of a self-test file.
+	selftestPollInterval = time.Second
+)
+
+// newSelftestToken returns a random word that is in no other file.
+func newSelftestToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return selftestTokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// selftestDirectory checks end to end that the daemon of `ctl` indexes and
+// finds the files of `directory`: writes a throwaway file holding a random
+// word, has the daemon index it right away, and searches for the word until
+// the file is found or `timeout` elapses.  The file is removed afterwards, and
+// its index deleted by the next scan of the directory.  Returns how long the
+// file took to be found.
+func selftestDirectory(ctl searchctl1.ControlInterface, directory string, timeout time.Duration) (time.Duration, error) {
+	token, err := newSelftestToken()
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(directory, fmt.Sprintf("search_selftest_%s.txt", token[len(selftestTokenPrefix):]))
+	content := "This file is written by the self-test of the KBFS search client, and should have been removed.\n" + token + "\n"
+	start := time.Now()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		return 0, fmt.Errorf("cannot write the test file: %s", err)
+	}
+	defer os.Remove(path)
+
+	// Indexes the file right away instead of waiting for the next scan.
+	if _, err := ctl.Reindex(context.TODO(), directory); err != nil {
+		return 0, fmt.Errorf("cannot index the test file: %s", err)
+	}
+	for {
+		results, err := ctl.Search(context.TODO(), token)
+		if err != nil {
+			return 0, fmt.Errorf("cannot search for the test file: %s", err)
+		}
+		for _, result := range results {
+			if result == path {
+				return time.Since(start), nil
+			}
+		}
+		if time.Since(start) >= timeout {
+			return 0, fmt.Errorf("the test file is not found by a search after %s", timeout)
+		}
+		time.Sleep(selftestPollInterval)
+	}
+}
+
+// selftest runs `selftestDirectory` within `timeout` on the client directories
+// `dirs` of the daemon of `ctl`, or on all of them if none is given, and writes
+// the outcome for each of them to `w`.  Returns an error if any of them fails.
+func selftest(w io.Writer, ctl searchctl1.ControlInterface, dirs []string, timeout time.Duration) error {
+	directories, err := ctl.ListDirs(context.TODO())
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, directory := range directories {
+		known[directory] = true
+	}
+	if len(dirs) > 0 {
+		directories = nil
+		for _, dir := range dirs {
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			if !known[absDir] {
+				return fmt.Errorf("\"%s\" is not a directory of the daemon", dir)
+			}
+			directories = append(directories, absDir)
+		}
+	}
+	if len(directories) == 0 {
+		return fmt.Errorf("the daemon has no directories")
+	}
+
+	failed := 0
+	for _, directory := range directories {
+		elapsed, err := selftestDirectory(ctl, directory, timeout)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %s\n", directory, err)
+			continue
+		}
+		fmt.Fprintf(w, "OK   %s: found after %s\n", directory, elapsed.Round(time.Millisecond))
+	}
+	if failed > 0 {
+		return fmt.Errorf("the self-test failed for %d of %d directories", failed, len(directories))
+	}
+	return nil
+}
+
+// runSelftest runs the self-test on the client directories `dirs` of the
+// running daemon, or on all of them if none is given.
+func runSelftest(ctl searchctl1.ControlInterface, dirs []string) error {
+	return selftest(os.Stdout, ctl, dirs, *selftestTimeout)
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+	"golang.org/x/net/context"
+)
+
+// fakeSelftestControl is a control interface over `directory` whose reindex
+// records the files holding a word, and whose search returns them, unless
+// `broken` is set.
+type fakeSelftestControl struct {
+	searchctl1.ControlInterface
+	directory string
+	broken    bool
+	indexed   map[string][]string
+}
+
+// ListDirs implements the ControlInterface interface.
+func (f *fakeSelftestControl) ListDirs(_ context.Context) ([]string, error) {
+	return []string{f.directory}, nil
+}
+
+// Reindex implements the ControlInterface interface.
+func (f *fakeSelftestControl) Reindex(_ context.Context, directory string) (searchctl1.ReindexResult, error) {
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil || f.broken {
+		return searchctl1.ReindexResult{}, err
+	}
+	for _, info := range infos {
+		path := filepath.Join(directory, info.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return searchctl1.ReindexResult{}, err
+		}
+		for _, word := range strings.Fields(string(content)) {
+			f.indexed[word] = append(f.indexed[word], path)
+		}
+	}
+	return searchctl1.ReindexResult{}, nil
+}
+
+// Search implements the ControlInterface interface.
+func (f *fakeSelftestControl) Search(_ context.Context, query string) ([]string, error) {
+	return f.indexed[query], nil
+}
+
+// TestSelftest tests the `selftest` function.  Checks that the test file is
+// found when the daemon indexes it, that the test fails when it does not, and
+// that the test file is removed in both cases.
+func TestSelftest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSelftest")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctl := &fakeSelftestControl{directory: dir, indexed: make(map[string][]string)}
+	var out bytes.Buffer
+	if err := selftest(&out, ctl, nil, 0); err != nil {
+		t.Fatalf("error when running the self-test: %s, %s", err, out.String())
+	}
+	if !strings.HasPrefix(out.String(), "OK   "+dir) {
+		t.Fatalf("incorrect output of the self-test: %s", out.String())
+	}
+
+	ctl.broken = true
+	out.Reset()
+	if err := selftest(&out, ctl, []string{dir}, 0); err == nil {
+		t.Fatalf("no error when the test file is not found")
+	}
+	if !strings.HasPrefix(out.String(), "FAIL "+dir) {
+		t.Fatalf("incorrect output of the failed self-test: %s", out.String())
+	}
+	if err := selftest(&out, ctl, []string{filepath.Join(dir, "unknown")}, 0); err == nil {
+		t.Fatalf("no error when testing an unknown directory")
+	}
+
+	if infos, err := ioutil.ReadDir(dir); err != nil || len(infos) != 0 {
+		t.Fatalf("test files left behind: %v, %v", infos, err)
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keybase/search/client"
+	"github.com/keybase/search/libsearch/eval"
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+	"golang.org/x/net/context"
+)
+
+// subcommand is an operation run once from the command line instead of the
+// interactive prompt, e.g. `searchclient query <word>...`.
+type subcommand struct {
+	usage    string // The arguments expected after the name of the subcommand.
+	summary  string // What the subcommand does, listed by `printUsage`.
+	lock     bool   // Whether the directories of the local clients are locked while the subcommand runs.
+	optional bool   // Whether the subcommand can run without arguments.
+	// run runs the subcommand over its `args` with the local and all the
+	// clients.
+	run func(localClients, allClients []*client.Client, args []string) error
+	// runControl runs the subcommand over its `args` through the control
+	// interface of the running daemon instead, if set.
+	runControl func(ctl searchctl1.ControlInterface, args []string) error
+	// runAlone runs the subcommand over its `args` without any client
+	// instead, if set.
+	runAlone func(args []string) error
+}
+
+// subcommands are the subcommands accepted by the client, by name.  `daemon`
+// is run by `main` itself, as with -daemon, and `admin` by the operation named
+// by its first argument.
+var subcommands = map[string]subcommand{
+	"daemon": {summary: "index the directories and answer the queries in the background, as -daemon does", optional: true},
+	"query":  {usage: "<word>...", summary: "search for the words and print out the matching files", run: runSearch},
+	"admin":  {usage: "<operation> <args>...", summary: "run one of the administration operations listed below"},
+	"eval":   {usage: "[<eval flags>]", summary: "measure the recall, precision and latency of the indexes over a corpus (eval -help lists its flags)", optional: true, runAlone: runEval},
+	"tune":   {usage: "[<tune flags>]", summary: "compare the evaluations of a corpus at several false positive rates (tune -help lists its flags)", optional: true, runAlone: runTune},
+}
+
+// adminOperations are the operations of the `admin` subcommand, by name.
+var adminOperations = map[string]subcommand{
+	"index":    {usage: "<dir>...", summary: "scan the directories once and bring their indexes up to date", lock: true, run: runIndex},
+	"delete":   {usage: "<path>...", summary: "delete the indexes of the files from the search server", lock: true, run: runDelete},
+	"stats":    {usage: "[<dir>...]", summary: "print out the indexing statistics of the running daemon", optional: true, runControl: runStats},
+	"selftest": {usage: "[<dir>...]", summary: "check that a test file gets indexed and found by the running daemon", optional: true, runControl: runSelftest},
+	"reindex":  {usage: "[--drop] <dir>...", summary: "have the running daemon index the directories again from scratch", runControl: runReindex},
+	"import":   {usage: "<prototype_server_dir> <dir>", summary: "import the corpus of a prototype server into a directory", lock: true, run: runImport},
+	"tag":      {usage: "add|remove|list <path> [<tag>...]", summary: "attach tags to a file, detach them, or print out its tags", lock: true, run: runTag},
+}
+
+// printUsage prints out to `out` how to run the client: the modes it runs in,
+// the subcommands and the operations of `admin` with what they do, and the
+// flags.
+func printUsage(out io.Writer) {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(out, "Usage: %s [flags] [<subcommand> <args>...]\n\n", name)
+	fmt.Fprintf(out, "Without a subcommand, the client indexes its directories and answers the\n")
+	fmt.Fprintf(out, "queries at the interactive prompt.\n\n")
+	fmt.Fprintf(out, "Subcommands:\n")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, name := range sortedNames(subcommands) {
+		fmt.Fprintf(w, "  %s\t%s\n", strings.TrimSpace(name+" "+subcommands[name].usage), subcommands[name].summary)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nOperations of admin:\n")
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, name := range sortedNames(adminOperations) {
+		fmt.Fprintf(w, "  admin %s %s\t%s\n", name, adminOperations[name].usage, adminOperations[name].summary)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nFlags:\n")
+	defer flag.CommandLine.SetOutput(nil)
+	flag.CommandLine.SetOutput(out)
+	flag.PrintDefaults()
+}
+
+// sortedNames returns the sorted names of the subcommands `subs`.
+func sortedNames(subs map[string]subcommand) []string {
+	var names []string
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupSubcommand returns the subcommand named by the first of the `args`, or
+// the operation named by the second for `admin`, along with its full name and
+// its arguments.  The operations of `admin` are also accepted on their own,
+// and `query` as `search`, as they were run before `admin` and `query`
+// existed.
+func lookupSubcommand(args []string) (subcommand, string, []string, error) {
+	name := args[0]
+	if name == "search" {
+		name = "query"
+	} else if _, ok := adminOperations[name]; ok {
+		return adminOperations[name], "admin " + name, args[1:], nil
+	}
+	sub, ok := subcommands[name]
+	if !ok {
+		return subcommand{}, "", nil, fmt.Errorf("unknown subcommand \"%s\", expected one of %s", args[0], strings.Join(sortedNames(subcommands), ", "))
+	}
+	if name != "admin" || len(args) < 2 {
+		return sub, name, args[1:], nil
+	}
+	op, ok := adminOperations[args[1]]
+	if !ok {
+		return subcommand{}, "", nil, fmt.Errorf("unknown operation \"%s\", expected one of %s", args[1], strings.Join(sortedNames(adminOperations), ", "))
+	}
+	return op, "admin " + args[1], args[2:], nil
+}
+
+// findDirectory returns the client among `clients` whose directory contains
+// `path`, along with that directory and the absolute form of `path`.
+func findDirectory(clients []*client.Client, path string) (*client.Client, string, string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	for _, cli := range clients {
+		for _, directory := range cli.Directories() {
+			relPath, err := filepath.Rel(directory, absPath)
+			if err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+				return cli, directory, absPath, nil
+			}
+		}
+	}
+	return nil, "", "", fmt.Errorf("\"%s\" is not under any of the client directories", path)
+}
+
+// runIndex scans each of the client directories `dirs` once and brings their
+// indexes on the search server up to date.
+func runIndex(localClients, _ []*client.Client, dirs []string) error {
+	for _, dir := range dirs {
+		cli, directory, absDir, err := findDirectory(localClients, dir)
+		if err != nil {
+			return err
+		}
+		if absDir != directory {
+			return fmt.Errorf("\"%s\" is not a client directory", dir)
+		}
+		report := cli.IndexUpdatedFiles(context.Background(), directory)
+		if report.Err != nil {
+			return fmt.Errorf("error when indexing \"%s\": %s", dir, report.Err)
+		}
+		reportScan(report)
+	}
+	return nil
+}
+
+// runEval evaluates the secure indexes over a corpus as set by the flags
+// `args`, and prints out the report.
+func runEval(args []string) error {
+	err := eval.Run(filepath.Base(os.Args[0])+" eval", args, os.Stdout)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+// runTune evaluates the secure indexes over a corpus at each of the false
+// positive rates set by the flags `args`, and prints out the reports side by
+// side.
+func runTune(args []string) error {
+	err := eval.RunTune(filepath.Base(os.Args[0])+" tune", args, os.Stdout)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+// runSearch searches for the `keywords` and prints out the results, as the
+// interactive prompt does.
+func runSearch(localClients, allClients []*client.Client, keywords []string) error {
+	return performSearch(localClients, allClients, keywords)
+}
+
+// runDelete deletes the indexes of the files at `paths` from the search server.
+func runDelete(localClients, _ []*client.Client, paths []string) error {
+	for _, path := range paths {
+		cli, directory, absPath, err := findDirectory(localClients, path)
+		if err != nil {
+			return err
+		}
+		if err := cli.DeleteFile(context.Background(), directory, absPath); err != nil {
+			return fmt.Errorf("error when deleting the index of \"%s\": %s", path, err)
+		}
+	}
+	return nil
+}
+
+// runStats prints out the indexing statistics of the running daemon for the
+// client directories `dirs`, or for all of them if none is given.
+func runStats(ctl searchctl1.ControlInterface, dirs []string) error {
+	allStats, err := ctl.Stats(context.TODO())
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]bool)
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		selected[absDir] = true
+	}
+	var stats []searchctl1.DirectoryStats
+	for _, dirStats := range allStats {
+		if len(selected) == 0 || selected[dirStats.Directory] {
+			stats = append(stats, dirStats)
+			delete(selected, dirStats.Directory)
+		}
+	}
+	for dir := range selected {
+		return fmt.Errorf("\"%s\" is not a directory of the daemon", dir)
+	}
+	return writeStats(os.Stdout, stats)
+}
+
+// runReindex has the running daemon index each of the client directories
+// `dirs` again from scratch, after dropping their indexes and the registration
+// of their TLFs on the search server if the first of the `args` is `--drop`.
+func runReindex(ctl searchctl1.ControlInterface, args []string) error {
+	drop := len(args) > 0 && (args[0] == "--drop" || args[0] == "-drop")
+	dirs := args
+	if drop {
+		dirs = args[1:]
+	}
+	if len(dirs) == 0 {
+		return errors.New("no directory to reindex")
+	}
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if err := ctl.ResetDir(context.TODO(), searchctl1.ResetDirArg{Directory: absDir, DropIndexes: drop}); err != nil {
+			return fmt.Errorf("error when resetting \"%s\": %s", dir, err)
+		}
+		fmt.Printf("Reindexing \"%s\" from scratch in the background.\n", absDir)
+	}
+	return nil
+}
+
+// runImport imports the corpus of the server of the prototype whose mount
+// point is the first of the `args` into the client directory given second,
+// with the master secret of its owner read from `-prototype_secret`.
+func runImport(localClients, _ []*client.Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected the mount point of the prototype server and a client directory")
+	}
+	if *prototypeSecret == "" {
+		return errors.New("no master secret given with -prototype_secret")
+	}
+	secretHex, err := ioutil.ReadFile(*prototypeSecret)
+	if err != nil {
+		return fmt.Errorf("cannot read the master secret: %s", err)
+	}
+	masterSecret, err := hex.DecodeString(strings.TrimSpace(string(secretHex)))
+	if err != nil {
+		return fmt.Errorf("invalid master secret: %s", err)
+	}
+	cli, directory, absDir, err := findDirectory(localClients, args[1])
+	if err != nil {
+		return err
+	}
+	if absDir != directory {
+		return fmt.Errorf("\"%s\" is not a client directory", args[1])
+	}
+	report := cli.ImportPrototype(context.Background(), args[0], directory, masterSecret)
+	if report.Err != nil {
+		return fmt.Errorf("error when importing \"%s\": %s", args[0], report.Err)
+	}
+	fmt.Printf("Imported %d files from \"%s\" into \"%s\".\n", len(report.Added)+report.Unchanged+report.Deferred, args[0], directory)
+	if report.Skipped > 0 {
+		fmt.Printf("Skipped %d files, either too large or binary, or already in \"%s\" with another content.\n", report.Skipped, directory)
+	}
+	return nil
+}
+
+// runTag attaches the tags following the path of the file in `args` to it with
+// "add", detaches them with "remove", or prints out its tags with "list".
+func runTag(localClients, _ []*client.Client, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected add, remove or list and the path of a file")
+	}
+	cli, directory, absPath, err := findDirectory(localClients, args[1])
+	if err != nil {
+		return err
+	}
+	tags := args[2:]
+	switch args[0] {
+	case "add", "remove":
+		if len(tags) == 0 {
+			return errors.New("no tag given")
+		}
+		update := cli.AddTags
+		if args[0] == "remove" {
+			update = cli.RemoveTags
+		}
+		if err := update(context.Background(), directory, absPath, tags); err != nil {
+			return fmt.Errorf("error when updating the tags of \"%s\": %s", args[1], err)
+		}
+		return nil
+	case "list":
+		tags, err := cli.GetTags(directory, absPath)
+		if err != nil {
+			return fmt.Errorf("error when reading the tags of \"%s\": %s", args[1], err)
+		}
+		for _, tag := range tags {
+			fmt.Println(tag)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown tag operation \"%s\", expected add, remove or list", args[0])
+}
+
+// writeStats writes the `stats` of the directories to `w`, as one line of JSON
+// per directory with `-json`, or as a table otherwise.
+func writeStats(w io.Writer, stats []searchctl1.DirectoryStats) error {
+	if *jsonOutput {
+		encoder := json.NewEncoder(w)
+		for _, dirStats := range stats {
+			if err := encoder.Encode(dirStats); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIRECTORY\tFILES\tUPLOADED\tLAST SCAN\tDURATION\tPENDING FILES\tPENDING OPS")
+	for _, dirStats := range stats {
+		lastScan := "never"
+		if dirStats.LastScanTime > 0 {
+			lastScan = time.Unix(0, dirStats.LastScanTime*int64(time.Millisecond)).Format(time.RFC3339)
+		}
+		duration := "-"
+		if dirStats.LastScanDuration > 0 {
+			duration = (time.Duration(dirStats.LastScanDuration) * time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%d\t%d\n", dirStats.Directory, dirStats.IndexedFiles, dirStats.UploadedBytes, lastScan, duration, dirStats.PendingFiles, dirStats.PendingOps)
+	}
+	return tw.Flush()
+}
+
+// lockClients locks the directories of the `clients` as the daemon does, and
+// reconciles the directories whose previous client has not shut down cleanly
+// with the search server.  On error, the locks taken are left to be released
+// by the exit of the process, so that the directories are still reconciled by
+// the next client.
+func lockClients(clients []*client.Client) error {
+	for _, cli := range clients {
+		unclean, err := cli.LockDirectories(*stateDir)
+		if err != nil {
+			return err
+		}
+		for _, directory := range unclean {
+			logger.Warnf("Recovering from an unclean shutdown of the client of \"%s\".", directory)
+			if report := cli.ReindexStale(context.Background(), directory); report.Err != nil {
+				return report.Err
+			}
+		}
+	}
+	return nil
+}
+
+// runSubcommand runs the subcommand named by the first of the `args` over the
+// rest of them, then flushes the pending uploads and closes the `allClients`.
+// The subcommands querying the daemon run through its control interface
+// instead.  Returns the exit status of the client: 0 on success, and 1 on
+// error, which is printed out to the standard error.
+func runSubcommand(localClients, allClients []*client.Client, args []string) int {
+	sub, name, args, err := lookupSubcommand(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s.\n", err)
+		return 1
+	}
+	if len(args) == 0 && !sub.optional {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s %s\n", filepath.Base(os.Args[0]), name, sub.usage)
+		return 1
+	}
+	if sub.runControl != nil {
+		return runControlSubcommand(sub, args)
+	}
+	if sub.runAlone != nil {
+		return runAloneSubcommand(sub, args)
+	}
+
+	if sub.lock {
+		if err = lockClients(localClients); err != nil {
+			err = fmt.Errorf("cannot lock the client directories: %s", err)
+		}
+	}
+	// The shutdown is only marked as clean once the clients are locked and
+	// reconciled.
+	clean := err == nil
+	if err == nil {
+		err = sub.run(localClients, allClients, args)
+	}
+	for _, cli := range allClients {
+		closeErr := cli.Close()
+		if closeErr == nil && clean {
+			// Only releases the locks taken, if any.
+			closeErr = cli.UnlockDirectories()
+		}
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("error when shutting down the client: %s", closeErr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runControlSubcommand runs `sub`, which queries the running daemon, over the
+// `args`.  Returns the exit status of the client, as `runSubcommand` does.
+func runControlSubcommand(sub subcommand, args []string) int {
+	ctl, closeCtl, err := dialControl(controlSocketPath())
+	if err == nil {
+		err = sub.runControl(ctl, args)
+		closeCtl()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runAloneSubcommand runs `sub`, which needs no client, over the `args`.
+// Returns the exit status of the client, as `runSubcommand` does.
+func runAloneSubcommand(sub subcommand, args []string) int {
+	if err := sub.runAlone(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	searchctl1 "github.com/keybase/search/protocol/searchctl"
+)
+
+// TestPrintUsage tests the `printUsage` function.  Checks that every
+// subcommand and operation of `admin` is listed with its arguments and what it
+// does, along with the flags.
+func TestPrintUsage(t *testing.T) {
+	var out bytes.Buffer
+	printUsage(&out)
+	for _, name := range sortedNames(subcommands) {
+		sub := subcommands[name]
+		if sub.summary == "" || !strings.Contains(out.String(), strings.TrimSpace(name+" "+sub.usage)) || !strings.Contains(out.String(), sub.summary) {
+			t.Fatalf("subcommand %s not listed: %s", name, out.String())
+		}
+	}
+	for _, name := range sortedNames(adminOperations) {
+		op := adminOperations[name]
+		if op.summary == "" || !strings.Contains(out.String(), "admin "+name+" "+op.usage) || !strings.Contains(out.String(), op.summary) {
+			t.Fatalf("operation %s not listed: %s", name, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "-daemon") {
+		t.Fatalf("flags not listed: %s", out.String())
+	}
+}
+
+// TestLookupSubcommand tests the `lookupSubcommand` function.  Checks that the
+// operations of `admin` are found with and without `admin`, `query` as
+// `search`, and that the unknown names are rejected.
+func TestLookupSubcommand(t *testing.T) {
+	for _, args := range [][]string{{"admin", "tag", "list", "file"}, {"tag", "list", "file"}} {
+		sub, name, rest, err := lookupSubcommand(args)
+		if err != nil || name != "admin tag" || sub.run == nil || !reflect.DeepEqual([]string{"list", "file"}, rest) {
+			t.Fatalf("incorrect lookup of %v: %s, %v, %v", args, name, rest, err)
+		}
+	}
+	if _, name, rest, err := lookupSubcommand([]string{"search", "word"}); err != nil || name != "query" || !reflect.DeepEqual([]string{"word"}, rest) {
+		t.Fatalf("incorrect lookup of search: %s, %v, %v", name, rest, err)
+	}
+	if sub, name, rest, err := lookupSubcommand([]string{"admin"}); err != nil || name != "admin" || sub.run != nil || len(rest) != 0 {
+		t.Fatalf("incorrect lookup of admin: %s, %v, %v", name, rest, err)
+	}
+	for _, args := range [][]string{{"serve"}, {"admin", "frobnicate"}} {
+		if _, _, _, err := lookupSubcommand(args); err == nil {
+			t.Fatalf("%v not rejected", args)
+		}
+	}
+}
+
+// TestRunSubcommand tests the `runSubcommand` function.  Checks that unknown
+// subcommands and missing arguments are rejected with a non-zero exit status,
+// as well as the subcommands querying a daemon that is not running.
+func TestRunSubcommand(t *testing.T) {
+	for _, args := range [][]string{{"frobnicate", "x"}, {"admin", "frobnicate", "x"}, {"eval", "-frobnicate"}, {"eval", "x"}, {"tune", "-frobnicate"}, {"tune", "-fp_rates=x"}} {
+		if status := runSubcommand(nil, nil, args); status != 1 {
+			t.Fatalf("incorrect exit status for %v: %d", args, status)
+		}
+	}
+	for _, name := range sortedNames(subcommands) {
+		if subcommands[name].optional {
+			continue
+		}
+		if status := runSubcommand(nil, nil, []string{name}); status != 1 {
+			t.Fatalf("incorrect exit status for %s without arguments: %d", name, status)
+		}
+	}
+	for _, name := range sortedNames(adminOperations) {
+		if adminOperations[name].optional {
+			continue
+		}
+		if status := runSubcommand(nil, nil, []string{"admin", name}); status != 1 {
+			t.Fatalf("incorrect exit status for admin %s without arguments: %d", name, status)
+		}
+	}
+	if status := runSubcommand(nil, nil, []string{"admin", "index", "/keybase/private/nobody"}); status != 1 {
+		t.Fatalf("incorrect exit status for an unknown directory: %d", status)
+	}
+	if status := runSubcommand(nil, nil, []string{"delete", filepath.Join("/keybase/private/nobody", "file")}); status != 1 {
+		t.Fatalf("incorrect exit status for a file outside the client directories: %d", status)
+	}
+	defer func(socket string) { *controlSocket = socket }(*controlSocket)
+	*controlSocket = filepath.Join("/keybase/private/nobody", "control.sock")
+	if status := runSubcommand(nil, nil, []string{"admin", "stats"}); status != 1 {
+		t.Fatalf("incorrect exit status without a daemon: %d", status)
+	}
+}
+
+// TestParseSearchScope tests the `parseSearchScope` function and the
+// `contains` function of the `searchScope`.
+func TestParseSearchScope(t *testing.T) {
+	keywords, scope := parseSearchScope([]string{"word", "in:alice,bob", "ext:pdf", "in:"})
+	if !reflect.DeepEqual([]string{"word", "ext:pdf", "in:"}, keywords) || !reflect.DeepEqual(searchScope{"alice,bob"}, scope) {
+		t.Fatalf("incorrect scope parsed: %v, %v", keywords, scope)
+	}
+	if !scope.contains("/keybase/private/alice,bob") || scope.contains("/keybase/private/alice") {
+		t.Fatalf("incorrect directories in the scope")
+	}
+	if !(searchScope{}).contains("/keybase/private/alice") {
+		t.Fatalf("directory not in the empty scope")
+	}
+}
+
+// TestWriteStats tests the `writeStats` function.  Checks that the statistics
+// of each directory are written as a row of the table.
+func TestWriteStats(t *testing.T) {
+	stats := []searchctl1.DirectoryStats{
+		{Directory: "/keybase/private/alice", IndexedFiles: 12, UploadedBytes: 34567, LastScanDuration: 1500, PendingFiles: 3, PendingOps: 1},
+		{Directory: "/keybase/private/bob"},
+	}
+	var buf bytes.Buffer
+	if err := writeStats(&buf, stats); err != nil {
+		t.Fatalf("error when writing the statistics: %s", err)
+	}
+	expected := `DIRECTORY               FILES  UPLOADED  LAST SCAN  DURATION  PENDING FILES  PENDING OPS
+/keybase/private/alice  12     34567     never      1.5s      3              1
+/keybase/private/bob    0      0         never      -         0              0
+`
+	if buf.String() != expected {
+		t.Fatalf("incorrect statistics written:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package main
+
+import "errors"
+
+// makeRaw always fails on this platform, so that the lines are read without
+// editing.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("line editing not supported on this platform")
+}
@@ -13,8 +13,10 @@ import (
 	"sort"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
 	sserver1 "github.com/keybase/search/protocol/sserver"
 	"golang.org/x/net/context"
 )
@@ -25,9 +27,9 @@ type FakeServerClient struct {
 	searchCount int                   // The number of times `SearchWord` has been called.  Needed to return the expected results.
 }
 
-func (c *FakeServerClient) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) error {
+func (c *FakeServerClient) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
 	c.docIDs = append(c.docIDs, arg.DocID)
-	return nil
+	return sserver1.WriteResult{Revision: 1}, nil
 }
 
 func (c *FakeServerClient) RenameIndex(_ context.Context, arg sserver1.RenameIndexArg) error {
@@ -64,8 +66,94 @@ func (c *FakeServerClient) SearchWord(_ context.Context, arg sserver1.SearchWord
 	}
 }
 
+func (c *FakeServerClient) SearchWords(ctx context.Context, arg sserver1.SearchWordsArg) ([][]sserver1.DocumentID, error) {
+	results := make([][]sserver1.DocumentID, len(arg.Trapdoors))
+	for i, trapdoors := range arg.Trapdoors {
+		result, err := c.SearchWord(ctx, sserver1.SearchWordArg{TlfID: arg.TlfID, Trapdoors: trapdoors})
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+func (c *FakeServerClient) SearchConjunction(ctx context.Context, arg sserver1.SearchConjunctionArg) ([]sserver1.DocumentID, error) {
+	if len(arg.Trapdoors) == 0 {
+		return nil, nil
+	}
+	return c.SearchWord(ctx, sserver1.SearchWordArg{TlfID: arg.TlfID, Trapdoors: arg.Trapdoors[0]})
+}
+
+func (c *FakeServerClient) GetDocumentInfo(_ context.Context, _ sserver1.GetDocumentInfoArg) ([]sserver1.DocumentInfo, error) {
+	return nil, nil
+}
+
+func (c *FakeServerClient) GetChanges(_ context.Context, _ sserver1.GetChangesArg) (sserver1.ChangeSet, error) {
+	return sserver1.ChangeSet{}, nil
+}
+
+func (c *FakeServerClient) ClaimIndex(_ context.Context, _ sserver1.ClaimIndexArg) (bool, error) {
+	return true, nil
+}
+
 func (c *FakeServerClient) RegisterTlfIfNotExists(_ context.Context, _ sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
-	return sserver1.TlfInfo{Salts: nil, Size: 10000}, nil
+	return sserver1.TlfInfo{Salts: nil, Size: 10000, Fingerprint: libsearch.ComputeTlfFingerprint(nil, 10000)}, nil
+}
+
+func (c *FakeServerClient) GetIndexStats(_ context.Context, _ sserver1.FolderID) (sserver1.TlfIndexStats, error) {
+	return sserver1.TlfIndexStats{}, nil
+}
+
+func (c *FakeServerClient) SearchWordPage(ctx context.Context, arg sserver1.SearchWordPageArg) (sserver1.SearchPage, error) {
+	docIDs, err := c.SearchWord(ctx, sserver1.SearchWordArg{TlfID: arg.TlfID, Trapdoors: arg.Trapdoors, ResultBucketSize: arg.ResultBucketSize})
+	return sserver1.SearchPage{DocIDs: docIDs}, err
+}
+
+func (c *FakeServerClient) MergeTlfSummary(_ context.Context, _ sserver1.MergeTlfSummaryArg) error {
+	return nil
+}
+
+func (c *FakeServerClient) GetTlfSummary(_ context.Context, _ sserver1.FolderID) (sserver1.TlfSummary, error) {
+	return sserver1.TlfSummary{}, nil
+}
+
+func (c *FakeServerClient) DropTlf(_ context.Context, _ sserver1.FolderID) error {
+	c.docIDs = c.docIDs[:0]
+	return nil
+}
+
+func (c *FakeServerClient) BeginBackfill(_ context.Context, _ sserver1.BeginBackfillArg) (sserver1.BackfillGrant, error) {
+	return sserver1.BackfillGrant{}, nil
+}
+
+func (c *FakeServerClient) EndBackfill(_ context.Context, _ sserver1.EndBackfillArg) error {
+	return nil
+}
+
+func (c *FakeServerClient) WriteIndexes(_ context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteResult, error) {
+	results := make([]sserver1.WriteResult, len(arg.Writes))
+	for i, write := range arg.Writes {
+		c.docIDs = append(c.docIDs, write.DocID)
+		results[i] = sserver1.WriteResult{Revision: 1}
+	}
+	return results, nil
+}
+
+// writeTestKbfsStatus writes a fake `.kbfs_status` file with `keyGen` as the
+// latest key generation into `dir`.
+func writeTestKbfsStatus(t *testing.T, dir string, keyGen libkbfs.KeyGen) {
+	var status libkbfs.FolderBranchStatus
+	status.FolderID = "aRandomTLFID"
+	status.LatestKeyGeneration = keyGen
+	bytes, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		t.Fatalf("error when writing the TLF status: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, ".kbfs_status"), bytes, 0666)
+	if err != nil {
+		t.Fatalf("error when writing the TLF status: %s", err)
+	}
 }
 
 // startTestClient creates an instance of a test client and returns a pointer to
@@ -73,6 +161,12 @@ func (c *FakeServerClient) RegisterTlfIfNotExists(_ context.Context, _ sserver1.
 // to later manually clean up the directory.  If `dir` is set, initializes the
 // client at `dir` instead of creating a temporary directory
 func startTestClient(t *testing.T, cliDir string) (*Client, string) {
+	return startTestClientWithServer(t, cliDir, &FakeServerClient{docIDs: make([]sserver1.DocumentID, 0, 5)})
+}
+
+// startTestClientWithServer is similar to `startTestClient`, but the client
+// talks to `searchCli` instead of a `FakeServerClient`.
+func startTestClientWithServer(t *testing.T, cliDir string, searchCli sserver1.SearchServerInterface) (*Client, string) {
 	var err error
 	if cliDir == "" {
 		cliDir, err = ioutil.TempDir("", "TestClient")
@@ -81,21 +175,9 @@ func startTestClient(t *testing.T, cliDir string) (*Client, string) {
 		}
 	}
 
-	var status libkbfs.FolderBranchStatus
-	status.FolderID = "aRandomTLFID"
-	status.LatestKeyGeneration = 1
-	bytes, err := json.MarshalIndent(status, "", "  ")
-	if err != nil {
-		t.Fatalf("error when writing the TLF status: %s", err)
-	}
-	err = ioutil.WriteFile(filepath.Join(cliDir, ".kbfs_status"), bytes, 0666)
-	if err != nil {
-		t.Fatalf("error when writing the TLF status: %s", err)
-	}
+	writeTestKbfsStatus(t, cliDir, 1)
 
-	searchCli := &FakeServerClient{docIDs: make([]sserver1.DocumentID, 0, 5)}
-
-	cli, err := createClientWithClient(context.Background(), searchCli, []string{cliDir}, 64, 8, 0.000001, 1000)
+	cli, err := createClientWithClient(context.Background(), searchCli, []string{cliDir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
 	if err != nil {
 		t.Fatalf("Error when creating the client: %s", err)
 	}
@@ -103,6 +185,15 @@ func startTestClient(t *testing.T, cliDir string) (*Client, string) {
 	return cli, cliDir
 }
 
+// lockTestClient locks the directories of `cli` in the hidden `.state`
+// directory of `dir`, so that the local state of the client, e.g. the word set
+// digests, is stored and removed along with `dir`.
+func lockTestClient(t *testing.T, cli *Client, dir string) {
+	if _, err := cli.LockDirectories(filepath.Join(dir, ".state")); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+}
+
 // TestCreateClient tests the `CreateClient` function.  Checks that a client can
 // be successfully created and that two clients have the same `indexer` and
 // `pathnameKey` if created with the same master secret.
@@ -119,6 +210,48 @@ func TestCreateClient(t *testing.T) {
 	}
 }
 
+// TestCreateClientShortSalt tests that the `CreateClient` function refuses to
+// register TLFs with salts shorter than the minimum length.
+func TestCreateClientShortSalt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestClient")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	searchCli := &FakeServerClient{}
+	if _, err := createClientWithClient(context.Background(), searchCli, []string{dir}, 64, libsearch.MinLenSalt-1, 0.000001, 1000, false, sserver1.IndexType_BLOOM); err == nil {
+		t.Fatalf("client created with salts shorter than the minimum length")
+	}
+}
+
+// TestCreateClientShortTlfSalt tests that the `CreateClient` function refuses
+// the salts shorter than the minimum length of a TLF already registered, e.g.
+// with the former default length.
+func TestCreateClientShortTlfSalt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestClient")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+	tlfID, _, err := getTlfIDAndKeyGen(dir)
+	if err != nil {
+		t.Fatalf("error when getting the TLF ID: %s", err)
+	}
+
+	searchCli := newMemoryServerClient()
+	salts, err := libsearch.GenerateSalts(libsearch.ComputeNumKeys(0.000001), 8)
+	if err != nil {
+		t.Fatalf("error when generating the salts: %s", err)
+	}
+	searchCli.tlfInfos[tlfID] = sserver1.TlfInfo{Salts: salts, Size: 1000, Fingerprint: libsearch.ComputeTlfFingerprint(salts, 1000)}
+	_, err = createClientWithClient(context.Background(), searchCli, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != (libsearch.ShortSaltError{LenSalt: 8}) {
+		t.Fatalf("incorrect error for the short salts of the TLF: %v", err)
+	}
+}
+
 // TestAddFile tests the `AddFile` function.  Checks that the index is properly
 // written by the server, and that errors are properly returned when the file is
 // not valid.
@@ -131,11 +264,11 @@ func TestAddFile(t *testing.T) {
 		t.Fatalf("error when writing test file: %s", err)
 	}
 
-	if err := client.AddFile(dir, filepath.Join(dir, "testFile")); err != nil {
+	if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "testFile")); err != nil {
 		t.Fatalf("error when adding the file: %s", err)
 	}
 
-	if err := client.AddFile(dir, filepath.Join(dir, "nonExisting")); !os.IsNotExist(err) {
+	if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "nonExisting")); !os.IsNotExist(err) {
 		t.Fatalf("no error returned for non-existing file")
 	}
 
@@ -145,7 +278,7 @@ func TestAddFile(t *testing.T) {
 	}
 	defer os.Remove(fileNotInDir.Name())
 
-	if err := client.AddFile(dir, fileNotInDir.Name()); err.Error() != "target path not within base path" {
+	if err := client.AddFile(context.Background(), dir, fileNotInDir.Name()); err.Error() != "target path not within base path" {
 		t.Fatalf("error not properly returned for file not in the client directory")
 	}
 }
@@ -161,17 +294,17 @@ func TestRenameFile(t *testing.T) {
 		t.Fatalf("error when writing test file: %s", err)
 	}
 
-	if err := client.AddFile(dir, filepath.Join(dir, "testRenameFile")); err != nil {
+	if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "testRenameFile")); err != nil {
 		t.Fatalf("error when adding the file: %s", err)
 	}
 
-	if err := client.RenameFile(dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
+	if err := client.RenameFile(context.Background(), dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
 		t.Fatalf("error when renaming file: %s", err)
 	}
 
 	// Doing the renaming second time should still succeed, even though nothing
 	// real has been done.
-	if err := client.RenameFile(dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
+	if err := client.RenameFile(context.Background(), dir, filepath.Join(dir, "testRenameFile"), filepath.Join(dir, "testRename")); err != nil {
 		t.Fatalf("error when renaming a non-existing file: %s", err)
 	}
 }
@@ -187,16 +320,16 @@ func TestDeleteFile(t *testing.T) {
 		t.Fatalf("error when writing test file: %s", err)
 	}
 
-	if err := client.AddFile(dir, filepath.Join(dir, "testDeleteFile")); err != nil {
+	if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "testDeleteFile")); err != nil {
 		t.Fatalf("error when adding the file: %s", err)
 	}
 
-	if err := client.DeleteFile(dir, filepath.Join(dir, "testDeleteFile")); err != nil {
+	if err := client.DeleteFile(context.Background(), dir, filepath.Join(dir, "testDeleteFile")); err != nil {
 		t.Fatalf("error when deleting file: %s", err)
 	}
 
 	// Doing the deleting second time should still succeed.
-	if err := client.DeleteFile(dir, filepath.Join(dir, "testDeleteFile")); err != nil {
+	if err := client.DeleteFile(context.Background(), dir, filepath.Join(dir, "testDeleteFile")); err != nil {
 		t.Fatalf("error when deleting a non-existing file: %s", err)
 	}
 }
@@ -221,7 +354,7 @@ func testSearchWordHelper(t *testing.T, searchFunc func(*Client, string, string)
 		if err := ioutil.WriteFile(filenames[i], []byte(fileContent), 0666); err != nil {
 			t.Fatalf("error when writing test file: %s", err)
 		}
-		if err := client.AddFile(dir, filenames[i]); err != nil {
+		if err := client.AddFile(context.Background(), dir, filenames[i]); err != nil {
 			t.Fatalf("error when adding the file: %s", err)
 		}
 	}
@@ -257,12 +390,12 @@ func testSearchWordHelper(t *testing.T, searchFunc func(*Client, string, string)
 
 // searchWordWrapper is the wrapper function for `SearchWord`.
 func searchWordWrapper(client *Client, directory string, word string) ([]string, error) {
-	return client.SearchWord(directory, word)
+	return client.SearchWord(context.Background(), directory, word)
 }
 
 // searchWordStrictWrapper is the wrapper function for `SearchWordStrict`.
 func searchWordStrictWrapper(client *Client, directory, word string) ([]string, error) {
-	return client.SearchWordStrict(directory, word)
+	return client.SearchWordStrict(context.Background(), directory, word)
 }
 
 // TestSearchWord tests the 'SearchWord' function.  Checks that the correct set
@@ -276,3 +409,642 @@ func TestSearchWord(t *testing.T) {
 func TestSearchWordStrict(t *testing.T) {
 	testSearchWordHelper(t, searchWordStrictWrapper)
 }
+
+// testSearchWordsHelper tests the provided 'searchFunc' function for
+// searching multiple words at once.  Checks that the correct set of filenames
+// are returned for each of the words.
+func testSearchWordsHelper(t *testing.T, searchFunc func(*Client, context.Context, string, []string) (map[string][]string, error)) {
+	client, dir := startTestClient(t, "")
+	defer os.RemoveAll(dir)
+
+	contents := []string{
+		"This is a simple test file",
+		"This is another test file",
+		"This is a different test file",
+		"This is yet another test file",
+		"This is the last test file",
+	}
+	filenames := make([]string, len(contents))
+
+	for i, fileContent := range contents {
+		filenames[i] = filepath.Join(dir, "testSearchFile"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filenames[i], []byte(fileContent), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, filenames[i]); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	actual, err := searchFunc(client, context.Background(), dir, []string{"another", "non-existing", "file"})
+	if err != nil {
+		t.Fatalf("error when searching words: %s", err)
+	}
+	if len(actual) != 3 {
+		t.Fatalf("incorrect number of results: expected 3 actual %d", len(actual))
+	}
+
+	expected := []string{filenames[1], filenames[3]}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(expected, actual["another"]) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual["another"])
+	}
+
+	if len(actual["non-existing"]) > 0 {
+		t.Fatalf("filenames found for non-existing word")
+	}
+
+	expected = filenames
+	sort.Strings(expected)
+	if !reflect.DeepEqual(expected, actual["file"]) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual["file"])
+	}
+}
+
+// TestSearchWords tests the 'SearchWords' function.  Checks that the correct
+// set of filenames are returned for each word.
+func TestSearchWords(t *testing.T) {
+	testSearchWordsHelper(t, (*Client).SearchWords)
+}
+
+// TestSearchWordsStrict tests the 'SearchWordsStrict' function.  Checks that
+// the correct set of filenames are returned for each word.
+func TestSearchWordsStrict(t *testing.T) {
+	testSearchWordsHelper(t, (*Client).SearchWordsStrict)
+}
+
+// TestWordSetDigest tests that the word set digests are persisted when a file
+// is added, and moved or removed along with the renamed or deleted indexes.
+func TestWordSetDigest(t *testing.T) {
+	client, dir := startTestClient(t, "")
+	defer os.RemoveAll(dir)
+	lockTestClient(t, client, dir)
+
+	content := "a random content"
+	if err := ioutil.WriteFile(filepath.Join(dir, "testDigestFile"), []byte(content), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "testDigestFile")); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+
+	digest, key, err := client.GetWordSetDigest(dir, filepath.Join(dir, "testDigestFile"))
+	if err != nil {
+		t.Fatalf("error when reading the word set digest: %s", err)
+	}
+	for _, word := range []string{"a", "random", "content"} {
+		if !digest.Contains(key, word) {
+			t.Fatalf("word \"%s\" not found in the digest", word)
+		}
+	}
+
+	if err := client.RenameFile(context.Background(), dir, filepath.Join(dir, "testDigestFile"), filepath.Join(dir, "testDigest")); err != nil {
+		t.Fatalf("error when renaming file: %s", err)
+	}
+	if _, _, err := client.GetWordSetDigest(dir, filepath.Join(dir, "testDigest")); err != nil {
+		t.Fatalf("word set digest not renamed along with the index: %s", err)
+	}
+
+	if err := client.DeleteFile(context.Background(), dir, filepath.Join(dir, "testDigest")); err != nil {
+		t.Fatalf("error when deleting file: %s", err)
+	}
+	if _, _, err := client.GetWordSetDigest(dir, filepath.Join(dir, "testDigest")); !os.IsNotExist(err) {
+		t.Fatalf("word set digest not deleted along with the index")
+	}
+}
+
+// TestWordSetDigestLocation tests that the word set digests are stored with
+// the local state rather than in the shared directory, and that the digests
+// stored in the shared directory by the earlier versions are copied to the
+// local state when the directories are locked.
+func TestWordSetDigestLocation(t *testing.T) {
+	client1, dir := startTestClient(t, "")
+	defer os.RemoveAll(dir)
+	lockTestClient(t, client1, dir)
+	pathname := filepath.Join(dir, "testDigestFile")
+	if err := ioutil.WriteFile(pathname, []byte("a random content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client1.AddFile(context.Background(), dir, pathname); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyWordSetDigestDir)); !os.IsNotExist(err) {
+		t.Fatalf("word set digests stored in the shared directory")
+	}
+	if err := client1.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+
+	// Moves the digests where the earlier versions stored them.
+	if err := os.Rename(getWordSetDigestDir(filepath.Join(dir, ".state"), dir), filepath.Join(dir, legacyWordSetDigestDir)); err != nil {
+		t.Fatalf("error when moving the digests: %s", err)
+	}
+	client2, _ := startTestClient(t, dir)
+	if _, _, err := client2.GetWordSetDigest(dir, pathname); !os.IsNotExist(err) {
+		t.Fatalf("word set digest found without a local state: %v", err)
+	}
+	lockTestClient(t, client2, dir)
+	digest, key, err := client2.GetWordSetDigest(dir, pathname)
+	if err != nil {
+		t.Fatalf("word set digest not copied to the local state: %s", err)
+	}
+	if !digest.Contains(key, "random") {
+		t.Fatalf("incorrect word set digest copied")
+	}
+}
+
+// TestSearchWordUnknownKeyGen tests that the `SearchWord` function refreshes the
+// keys when the server returns a document ID encrypted with a newer key
+// generation, instead of failing or panicking.
+func TestSearchWordUnknownKeyGen(t *testing.T) {
+	client, dir := startTestClient(t, "")
+	defer os.RemoveAll(dir)
+
+	// Simulates a rekey that the client has not yet noticed.
+	writeTestKbfsStatus(t, dir, 2)
+	masterSecret, err := fetchMasterSecret(dir, 2, 64)
+	if err != nil {
+		t.Fatalf("error when generating master secret: %s", err)
+	}
+	var pathnameKey libsearch.PathnameKeyType
+	copy(pathnameKey[:], masterSecret[0:32])
+	docID, err := libsearch.PathnameToDocID(2, "rekeyedFile", pathnameKey)
+	if err != nil {
+		t.Fatalf("error when encrypting the pathname: %s", err)
+	}
+
+	searchCli := client.searchCli.(*FakeServerClient)
+	searchCli.docIDs = []sserver1.DocumentID{docID}
+	searchCli.searchCount = 2
+
+	filenames, err := client.SearchWord(context.Background(), dir, "whatever")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(filenames, []string{filepath.Join(dir, "rekeyedFile")}) {
+		t.Fatalf("incorrect search result after rekey: %s", filenames)
+	}
+}
+
+// TestSearchWordMemoryServer tests the `SearchWord` function against a server
+// that actually evaluates the indexes.  Checks that the files containing the
+// word are found, and that renamed and deleted files are handled.
+func TestSearchWordMemoryServer(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	contents := []string{"apple banana", "banana cherry", "cherry apple"}
+	for i, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, filepath.Join(dir, "file"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	actual, err := client.SearchWord(context.Background(), dir, "banana")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	expected := []string{filepath.Join(dir, "file0"), filepath.Join(dir, "file1")}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+
+	if err := client.RenameFile(context.Background(), dir, filepath.Join(dir, "file0"), filepath.Join(dir, "renamed")); err != nil {
+		t.Fatalf("error when renaming file: %s", err)
+	}
+	if err := client.DeleteFile(context.Background(), dir, filepath.Join(dir, "file1")); err != nil {
+		t.Fatalf("error when deleting file: %s", err)
+	}
+
+	actual, err = client.SearchWord(context.Background(), dir, "banana")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	expected = []string{filepath.Join(dir, "renamed")}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+}
+
+// TestCreateClientEncryptedSalts tests the `createClientWithClient` function
+// with the salts encrypted.  Checks that the server never sees the salts in
+// the clear, and that another client of the TLF without the option decrypts
+// the same salts and finds the files indexed by the first client.
+func TestCreateClientEncryptedSalts(t *testing.T) {
+	server := newMemoryServerClient()
+	dir, err := ioutil.TempDir("", "TestClient")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+
+	client1, err := createClientWithClient(context.Background(), server, []string{dir}, 64, 32, 0.000001, 1000, true, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	client2, err := createClientWithClient(context.Background(), server, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+
+	tlfInfo := server.tlfInfos[client1.directoryInfos[dir].tlfID]
+	if len(tlfInfo.Salts) != 0 || len(tlfInfo.EncryptedSalts) == 0 {
+		t.Fatalf("salts stored in the clear on the server")
+	}
+	salts := client1.directoryInfos[dir].tlfInfo.Salts
+	if len(salts) != libsearch.ComputeNumKeys(0.000001) {
+		t.Fatalf("incorrect number of salts: expected %d actual %d", libsearch.ComputeNumKeys(0.000001), len(salts))
+	}
+	if !reflect.DeepEqual(salts, client2.directoryInfos[dir].tlfInfo.Salts) {
+		t.Fatalf("salts differ between the clients")
+	}
+
+	filename := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(filename, []byte("encrypted salts"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client1.AddFile(context.Background(), dir, filename); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	actual, err := client2.SearchWord(context.Background(), dir, "salts")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{filename}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+}
+
+// TestCreateClientCuckooIndex tests the `createClientWithClient` function with
+// the cuckoo index type.  Checks that the index type of the client registering
+// the TLF is kept by the other clients of the TLF, and that the files indexed
+// by one client are found by the other.
+func TestCreateClientCuckooIndex(t *testing.T) {
+	server := newMemoryServerClient()
+	dir, err := ioutil.TempDir("", "TestClient")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+
+	client1, err := createClientWithClient(context.Background(), server, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_CUCKOO)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	client2, err := createClientWithClient(context.Background(), server, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	if indexType := client2.directoryInfos[dir].tlfInfo.IndexType; indexType != sserver1.IndexType_CUCKOO {
+		t.Fatalf("incorrect index type of the TLF: %s", sserver1.IndexTypeRevMap[indexType])
+	}
+
+	filename := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(filename, []byte("cuckoo index"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client2.AddFile(context.Background(), dir, filename); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	actual, err := client1.SearchWord(context.Background(), dir, "cuckoo")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{filename}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+}
+
+// TestSearchWordPaddedResults tests the `SearchWord` and `SearchWords`
+// functions with the results padded by the server.  Checks that the dummy
+// results are filtered out.
+func TestSearchWordPaddedResults(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client.SetResultBucketSize(8)
+
+	var expected []string
+	for i := 0; i < 3; i++ {
+		filename := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filename, []byte("padded results"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+		expected = append(expected, filename)
+	}
+
+	dirInfo := client.directoryInfos[dir]
+	trapdoors := computeTrapdoorMap(dirInfo, []int{1}, "padded")
+	raw, err := server.SearchWord(context.Background(), sserver1.SearchWordArg{TlfID: dirInfo.tlfID, Trapdoors: trapdoors, ResultBucketSize: 8})
+	if err != nil || len(raw) != 8 {
+		t.Fatalf("results not padded by the server: %d results, %v", len(raw), err)
+	}
+
+	actual, err := client.SearchWord(context.Background(), dir, "padded")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+
+	actualMap, err := client.SearchWords(context.Background(), dir, []string{"results", "missing"})
+	if err != nil {
+		t.Fatalf("error when searching words: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actualMap["results"]) || len(actualMap["missing"]) != 0 {
+		t.Fatalf("incorrect search results: %v", actualMap)
+	}
+}
+
+// TestIndexSizeBuckets tests the `SetIndexSizeBuckets` function.  Checks that
+// the indexes of files of different lengths are stored with the same size, and
+// that the files are still found.
+func TestIndexSizeBuckets(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client.SetIndexSizeBuckets(true)
+
+	contents := []string{"bucketed index", "bucketed indexes"}
+	var expected []string
+	for i, content := range contents {
+		filename := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+		expected = append(expected, filename)
+	}
+
+	tlfID := client.directoryInfos[dir].tlfID
+	docIDs, err := server.indexes.list(tlfID)
+	if err != nil || len(docIDs) != 2 {
+		t.Fatalf("incorrect indexes stored: %v, %v", docIDs, err)
+	}
+	var sizes []int
+	for _, docID := range docIDs {
+		secIndex, _, err := server.indexes.get(tlfID, docID)
+		if err != nil || len(secIndex) != libsearch.IndexSizeBucket(len(secIndex)) {
+			t.Fatalf("index not padded to its size bucket: %d bytes, %v", len(secIndex), err)
+		}
+		sizes = append(sizes, len(secIndex))
+	}
+	if sizes[0] != sizes[1] {
+		t.Fatalf("indexes of similar files in different size buckets: %v", sizes)
+	}
+
+	actual, err := client.SearchWord(context.Background(), dir, "bucketed")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+}
+
+// TestSearchQueryMetadata tests the `SearchQuery` and `SearchQueryStrict`
+// functions with metadata keywords.  Checks that the words are filtered by the
+// extension and the year of modification of the files, all through trapdoors.
+func TestSearchQueryMetadata(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	modTime := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	files := map[string]string{"report.pdf": "quarterly report", "report.txt": "another report", "old.pdf": "an old report", "notes.pdf": "some notes"}
+	for name, content := range files {
+		pathname := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		fileModTime := modTime
+		if name == "old.pdf" {
+			fileModTime = modTime.AddDate(-10, 0, 0)
+		}
+		if err := os.Chtimes(pathname, fileModTime, fileModTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	for _, searchFunc := range []func(context.Context, string, string) ([]string, error){client.SearchQuery, client.SearchQueryStrict} {
+		for query, expected := range map[string][]string{
+			"report ext:pdf":           {filepath.Join(dir, "old.pdf"), filepath.Join(dir, "report.pdf")},
+			"report ext:pdf year:2023": {filepath.Join(dir, "report.pdf")},
+			"ext:PDF size:tiny":        {filepath.Join(dir, "notes.pdf"), filepath.Join(dir, "old.pdf"), filepath.Join(dir, "report.pdf")},
+			"report ext:doc":           {},
+		} {
+			actual, err := searchFunc(context.Background(), dir, query)
+			if err != nil {
+				t.Fatalf("error when searching %q: %s", query, err)
+			}
+			if len(expected) != len(actual) || (len(expected) > 0 && !reflect.DeepEqual(expected, actual)) {
+				t.Fatalf("incorrect search result for %q: expected %s actual %s", query, expected, actual)
+			}
+		}
+	}
+}
+
+// TestSearchQueryNear tests the `SearchQuery` and `SearchQueryStrict`
+// functions with the NEAR operator.  Checks that only the files where the two
+// words are within the window set by `SetNearWindow` match.
+func TestSearchQueryNear(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	client.SetNearWindow(2)
+
+	files := map[string]string{"close": "alpha and beta", "far": "alpha is far away from beta", "alpha": "alpha only"}
+	for name, content := range files {
+		pathname := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	expected := []string{filepath.Join(dir, "close")}
+	for _, searchFunc := range []func(context.Context, string, string) ([]string, error){client.SearchQuery, client.SearchQueryStrict} {
+		actual, err := searchFunc(context.Background(), dir, "beta NEAR alpha")
+		if err != nil {
+			t.Fatalf("error when searching: %s", err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("incorrect search result: expected %s actual %s", expected, actual)
+		}
+	}
+}
+
+// conjunctionCountingServerClient counts the single-word and conjunctive
+// searches sent to an in-memory server.
+type conjunctionCountingServerClient struct {
+	*memoryServerClient
+	wordSearches int
+	conjunctions int
+}
+
+func (c *conjunctionCountingServerClient) SearchWord(ctx context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	c.wordSearches++
+	return c.memoryServerClient.SearchWord(ctx, arg)
+}
+
+func (c *conjunctionCountingServerClient) SearchWords(ctx context.Context, arg sserver1.SearchWordsArg) ([][]sserver1.DocumentID, error) {
+	c.wordSearches++
+	return c.memoryServerClient.SearchWords(ctx, arg)
+}
+
+func (c *conjunctionCountingServerClient) SearchConjunction(ctx context.Context, arg sserver1.SearchConjunctionArg) ([]sserver1.DocumentID, error) {
+	c.conjunctions++
+	return c.memoryServerClient.SearchConjunction(ctx, arg)
+}
+
+// TestSearchQueryConjunction tests the `SearchQuery` function.  Checks that
+// the terms are evaluated by the server in a single conjunctive query instead
+// of being filtered by the client.
+func TestSearchQueryConjunction(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	for i, content := range []string{"apple banana", "banana cherry", "cherry apple"} {
+		pathname := filepath.Join(dir, "file"+strconv.Itoa(i)+".txt")
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	actual, err := client.SearchQuery(context.Background(), dir, "banana ext:txt apple")
+	if err != nil {
+		t.Fatalf("error when searching: %s", err)
+	}
+	if expected := []string{filepath.Join(dir, "file0.txt")}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected %s actual %s", expected, actual)
+	}
+	if server.conjunctions != 1 || server.wordSearches != 0 {
+		t.Fatalf("incorrect queries sent to the server: %d conjunctive and %d word searches", server.conjunctions, server.wordSearches)
+	}
+}
+
+// TestSearchWordsAllAny tests the `SearchWordsAll` and `SearchWordsAny`
+// functions.  Checks that they return the intersection and the union of the
+// files containing the words, each with a single query sent to the server.
+func TestSearchWordsAllAny(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	var filenames []string
+	for i, content := range []string{"apple banana", "banana cherry", "cherry apple", "durian"} {
+		pathname := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+		filenames = append(filenames, pathname)
+	}
+
+	intersection, err := client.SearchWordsAll(context.Background(), dir, []string{"banana", "apple", "banana"})
+	if err != nil {
+		t.Fatalf("error when searching for all the words: %s", err)
+	}
+	if expected := filenames[:1]; !reflect.DeepEqual(expected, intersection) {
+		t.Fatalf("incorrect intersection: expected %s actual %s", expected, intersection)
+	}
+	if server.conjunctions != 1 || server.wordSearches != 0 {
+		t.Fatalf("incorrect queries sent for all the words: %d conjunctive and %d word searches", server.conjunctions, server.wordSearches)
+	}
+
+	union, err := client.SearchWordsAny(context.Background(), dir, []string{"banana", "cherry"})
+	if err != nil {
+		t.Fatalf("error when searching for any of the words: %s", err)
+	}
+	if expected := filenames[:3]; !reflect.DeepEqual(expected, union) {
+		t.Fatalf("incorrect union: expected %s actual %s", expected, union)
+	}
+	if server.conjunctions != 1 || server.wordSearches != 1 {
+		t.Fatalf("incorrect queries sent for any of the words: %d conjunctive and %d word searches", server.conjunctions, server.wordSearches)
+	}
+
+	if _, err := client.SearchWordsAll(context.Background(), dir, nil); err == nil {
+		t.Fatalf("no error when searching for no word")
+	}
+}
+
+// uploadedMapping adds a test file to `dir` with `cli`, and returns the
+// codeword mapping of its index as uploaded to `server`.
+func uploadedMapping(t *testing.T, cli *Client, server *memoryServerClient, dir string) libsearch.CodewordMapping {
+	filename := filepath.Join(dir, "mapping.txt")
+	if err := ioutil.WriteFile(filename, []byte("some words"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := cli.AddFile(context.Background(), dir, filename); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	tlfID := cli.directoryInfos[dir].tlfID
+	docIDs := server.docIDs(tlfID)
+	if len(docIDs) != 1 {
+		t.Fatalf("incorrect number of indexes on the server: %d", len(docIDs))
+	}
+	secIndexBytes, _, err := server.indexes.get(tlfID, docIDs[0])
+	if err != nil {
+		t.Fatalf("error when reading the index: %s", err)
+	}
+	var secIndex libsearch.SecureIndex
+	if err := secIndex.UnmarshalBinary(secIndexBytes); err != nil {
+		t.Fatalf("error when parsing the index: %s", err)
+	}
+	return secIndex.Mapping
+}
+
+// TestRegisteredCodewordMapping tests the codeword mapping recorded for the
+// TLFs.  Checks that a newly registered TLF records the unbiased mapping and
+// gets its indexes built with it, that a TLF recording no mapping keeps the
+// legacy one, and that a mapping not going with the index type is refused.
+func TestRegisteredCodewordMapping(t *testing.T) {
+	server := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	tlfID := cli.directoryInfos[dir].tlfID
+	if mapping := server.tlfInfos[tlfID].CodewordMapping; mapping != int(libsearch.RegisteredCodewordMapping) {
+		t.Fatalf("incorrect mapping recorded for a new TLF: %d", mapping)
+	}
+	if mapping := uploadedMapping(t, cli, server, dir); mapping != libsearch.CodewordMappingFixed64 {
+		t.Fatalf("incorrect mapping for the indexes of a new TLF: %d", mapping)
+	}
+
+	legacy := newMemoryServerClient()
+	if _, err := legacy.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 32, FpRate: 0.000001, NumUniqWords: 1000}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	cli, _ = startTestClientWithServer(t, dir, legacy)
+	if mapping := uploadedMapping(t, cli, legacy, dir); mapping != libsearch.CodewordMappingUvarint {
+		t.Fatalf("incorrect mapping for the indexes of a legacy TLF: %d", mapping)
+	}
+
+	invalid := newMemoryServerClient()
+	if _, err := invalid.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: tlfID, LenSalt: 32, FpRate: 0.000001, NumUniqWords: 1000, CodewordMapping: int(libsearch.CodewordMappingCuckoo)}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	if _, err := createClientWithClient(context.Background(), invalid, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM); err == nil {
+		t.Fatalf("cuckoo mapping accepted for a bloom TLF")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// getContentHashesPath returns the path of the file persisting the content
+// hashes of the files of `directory` within `stateDir`.
+func getContentHashesPath(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, ".hashes")
+}
+
+// readContentHashes reads the content hashes of the files of `directory`
+// persisted within `stateDir`.
+func readContentHashes(stateDir, directory string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	hashesJSON, err := ioutil.ReadFile(getContentHashesPath(stateDir, directory))
+	if os.IsNotExist(err) {
+		return hashes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(hashesJSON, &hashes)
+	return hashes, err
+}
+
+// hashContent returns the hash of everything the index of the file at `path`
+// in the directory of `dirInfo` is built from: its content, its metadata
+// keywords and the latest key generation of the directory.  Also returns the
+// info of the file the hash is computed for.
+func hashContent(dirInfo *DirectoryInfo, path string) (string, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", nil, err
+	}
+	for _, keyword := range libsearch.ComputeMetadataKeywords(info.Name(), info.Size(), info.ModTime()) {
+		hash.Write([]byte(keyword + "\n"))
+	}
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	hash.Write([]byte(strconv.Itoa(int(keyGen))))
+	return hex.EncodeToString(hash.Sum(nil)), info, nil
+}
+
+// contentUnchanged returns whether `hash` is the content hash recorded when
+// the file at `path` in `directory` was last indexed.
+func (c *Client) contentUnchanged(directory, path, hash string) bool {
+	directory, _ = filepath.Abs(directory)
+	path, _ = filepath.Abs(path)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	recorded, ok := c.contentHashes[directory][path]
+	return ok && recorded == hash
+}
+
+// recordContentHash records `hash` as the content hash of the file at `path` in
+// `directory` as of its last index, or forgets it if `hash` is empty, e.g. once
+// its index is deleted.
+func (c *Client) recordContentHash(directory, path, hash string) {
+	directory, _ = filepath.Abs(directory)
+	path, _ = filepath.Abs(path)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	hashes := c.contentHashes[directory]
+	if hash == "" {
+		delete(hashes, path)
+		return
+	}
+	if hashes == nil {
+		hashes = make(map[string]string)
+		c.contentHashes[directory] = hashes
+	}
+	hashes[path] = hash
+}
+
+// renameContentHash moves the content hash of the file at `origPath` in
+// `directory` to `currPath`, along with its index.
+func (c *Client) renameContentHash(directory, origPath, currPath string) {
+	directory, _ = filepath.Abs(directory)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	hashes := c.contentHashes[directory]
+	if hash, ok := hashes[origPath]; ok {
+		delete(hashes, origPath)
+		hashes[currPath] = hash
+	}
+}
+
+// pruneContentHashes drops the content hashes of the files of `directory`
+// for which `keep` returns false given their relative path.
+func (c *Client) pruneContentHashes(directory string, keep func(relPath string) bool) {
+	directory, _ = filepath.Abs(directory)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	for path := range c.contentHashes[directory] {
+		if relPath, err := filepath.Rel(directory, path); err != nil || !keep(relPath) {
+			delete(c.contentHashes[directory], path)
+		}
+	}
+}
+
+// saveContentHashes persists the content hashes of the files of `directory` in
+// the state directory of the client, if its directories are locked.
+func (c *Client) saveContentHashes(directory string) error {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	if c.stateDir == "" {
+		return nil
+	}
+	hashesJSON, err := json.Marshal(c.contentHashes[directory])
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(getContentHashesPath(c.stateDir, directory), hashesJSON)
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestContentHashes tests the content hashes recorded by the scans.  Checks
+// that a file whose modification time changes without its content is not
+// indexed again, across a rename and a restart, and that a file deleted then
+// recreated with the same content is indexed again.
+func TestContentHashes(t *testing.T) {
+	server := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	stateDir, err := ioutil.TempDir("", "TestContentHashes")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+	if _, err := cli.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	touch := func(pathname string, offset time.Duration) {
+		modTime := time.Now().Add(offset)
+		if err := os.Chtimes(pathname, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	touch(pathname, time.Minute)
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 0 || report.Unchanged != 1 {
+		t.Fatalf("touched file indexed again: %+v", report)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(pathname, renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Renamed) != 1 {
+		t.Fatalf("incorrect scan of the rename: %+v", report)
+	}
+	if err := cli.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+	cli, _ = startTestClientWithServer(t, dir, server)
+	if _, err := cli.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories again: %s", err)
+	}
+	touch(renamed, 2*time.Minute)
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 0 || report.Unchanged != 1 {
+		t.Fatalf("renamed file indexed again after the restart: %+v", report)
+	}
+
+	if err := os.Remove(renamed); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Deleted) != 1 {
+		t.Fatalf("incorrect scan of the deletion: %+v", report)
+	}
+	if err := ioutil.WriteFile(renamed, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	touch(renamed, 3*time.Minute)
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 || report.Unchanged != 0 {
+		t.Fatalf("recreated file not indexed again: %+v", report)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// SkipReason is the reason a file under a client directory has no index on
+// the search server.
+type SkipReason string
+
+const (
+	// SkipExcluded is the reason of the hidden files and the files under
+	// hidden directories, which are never indexed.
+	SkipExcluded SkipReason = "excluded"
+	// SkipFailed is the reason of the files that should be indexed but have
+	// no index on the search server, e.g. as their upload failed or is held
+	// back by the padding policy.
+	SkipFailed SkipReason = "failed"
+	// SkipTooLarge is the reason of the files larger than the limit set by
+	// `SetMaxFileSize`.
+	SkipTooLarge SkipReason = "too_large"
+	// SkipBinary is the reason of the files with binary content, skipped as
+	// set by `SetSkipBinary`.
+	SkipBinary SkipReason = "binary"
+	// SkipRefused is the reason of the files refused by the classifier set by
+	// `SetContentClassifier`.
+	SkipRefused SkipReason = "refused"
+)
+
+// Coverage summarizes how much of the files under a client directory are
+// covered by the indexes on the search server.  The files present are either
+// indexed, stale or skipped.
+type Coverage struct {
+	Directory string             // The directory covered.
+	Present   int                // The number of files under the directory.
+	Indexed   int                // The number of files with an up-to-date index.
+	Stale     int                // The number of files modified after their index was written.
+	Skipped   map[SkipReason]int // The number of files without an index, per reason.
+}
+
+// GetCoverage reports how many of the files under `directory` are indexed on
+// the search server, and why the other ones are not.  The state files of the
+// client and the special files of KBFS are not counted.
+func (c *Client) GetCoverage(ctx context.Context, directory string) (Coverage, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return Coverage{}, err
+	}
+
+	coverage := Coverage{Directory: dirInfo.absDir, Skipped: make(map[SkipReason]int)}
+	reasons := make(map[string]SkipReason)
+	c.issuesLock.Lock()
+	for path, issue := range c.fileIssues[dirInfo.absDir] {
+		reasons[path] = issue.Reason
+	}
+	c.issuesLock.Unlock()
+	err = c.walkDocumentInfos(ctx, dirInfo, func(relPath string, modTime time.Time, info *DocumentInfo) {
+		coverage.Present++
+		switch {
+		case info == nil:
+			reason := SkipFailed
+			if recorded, ok := reasons[filepath.Join(dirInfo.absDir, relPath)]; ok {
+				reason = recorded
+			}
+			coverage.Skipped[reason]++
+		case modTime.After(info.LastWrite):
+			coverage.Stale++
+		default:
+			coverage.Indexed++
+		}
+	}, func(string) {
+		coverage.Present++
+		coverage.Skipped[SkipExcluded]++
+	})
+	if err != nil {
+		return Coverage{}, err
+	}
+	return coverage, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestGetCoverage tests the `GetCoverage` function.  Checks that the files are
+// counted as indexed, stale, failed or excluded, and that the state files of
+// the client and of KBFS are not counted.
+func TestGetCoverage(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	hiddenDir := filepath.Join(dir, ".hidden", "subdir")
+	if err := os.MkdirAll(hiddenDir, 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	indexed := filepath.Join(dir, "indexed")
+	stale := filepath.Join(dir, "stale")
+	failed := filepath.Join(dir, "failed")
+	for _, pathname := range []string{indexed, stale, failed, filepath.Join(dir, ".hiddenFile"), filepath.Join(hiddenDir, "file")} {
+		if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	for _, pathname := range []string{indexed, stale} {
+		if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(stale, future, future); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+
+	coverage, err := client.GetCoverage(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("error when getting the coverage: %s", err)
+	}
+	expected := Coverage{
+		Directory: dir,
+		Present:   5,
+		Indexed:   1,
+		Stale:     1,
+		Skipped:   map[SkipReason]int{SkipFailed: 1, SkipExcluded: 2},
+	}
+	if !reflect.DeepEqual(expected, coverage) {
+		t.Fatalf("incorrect coverage: expected %+v actual %+v", expected, coverage)
+	}
+}
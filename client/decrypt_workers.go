@@ -0,0 +1,83 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// minDocIDsPerDecryptWorker is the number of document IDs below which a worker
+// is not worth starting, the decryption of a single ID being cheap.
+const minDocIDsPerDecryptWorker = 256
+
+// SetDecryptWorkers sets the number of workers decrypting the document IDs of
+// the search results concurrently, which speeds up the searches matching
+// thousands of files.  Defaults to the number of CPUs.  A `workers` below 1 is
+// treated as 1.  Should be called before the searches are performed.
+func (c *Client) SetDecryptWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	c.decryptWorkers = workers
+}
+
+// decryptDocIDs decrypts the `documents` into pathnames relative to the
+// directory of `dirInfo`, in the order of `documents`, on as many concurrent
+// workers as set by `SetDecryptWorkers`.  The pathnames of the dummy document
+// IDs padding the results, if requested, are left empty.  Returns the error of
+// the first document that fails to be decrypted, if any.
+func (c *Client) decryptDocIDs(dirInfo *DirectoryInfo, documents []sserver1.DocumentID) ([]string, error) {
+	// The keys are only ever appended to, so the snapshot stays valid once
+	// the lock is released.
+	dirInfo.keyGenLock.RLock()
+	pathnameKeys := dirInfo.pathnameKeys
+	dirInfo.keyGenLock.RUnlock()
+
+	pathnames := make([]string, len(documents))
+	errs := make([]error, len(documents))
+	decrypt := func(start, end int) {
+		for i := start; i < end; i++ {
+			pathname, err := libsearch.DocIDToPathname(documents[i], pathnameKeys)
+			if err == libsearch.ErrInvalidDocID && c.resultBucket > 0 {
+				continue
+			}
+			pathnames[i], errs[i] = pathname, err
+		}
+	}
+
+	workers := c.decryptWorkers
+	if maxWorkers := len(documents) / minDocIDsPerDecryptWorker; workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		decrypt(0, len(documents))
+	} else {
+		// Each worker decrypts a contiguous chunk of the documents.
+		chunkSize := (len(documents) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for start := 0; start < len(documents); start += chunkSize {
+			end := start + chunkSize
+			if end > len(documents) {
+				end = len(documents)
+			}
+			wg.Add(1)
+			go func(start, end int) {
+				defer wg.Done()
+				decrypt(start, end)
+			}(start, end)
+		}
+		wg.Wait()
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pathnames, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestDocIDsToFilenamesWorkers tests the `docIDsToFilenames` function with
+// several decryption workers.  Checks that a large result set is decrypted into
+// the same sorted filenames as with a single worker, with the dummy documents
+// filtered out, and that an invalid document ID is reported.
+func TestDocIDsToFilenamesWorkers(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	dirInfo, err := client.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+
+	numDocs := 5 * minDocIDsPerDecryptWorker
+	var documents []sserver1.DocumentID
+	var expected []string
+	for i := 0; i < numDocs; i++ {
+		pathname := "file" + strconv.Itoa(i)
+		if i%100 == 0 {
+			pathname = dummyPathnamePrefix + strconv.Itoa(i)
+		} else {
+			expected = append(expected, filepath.Join(dir, pathname))
+		}
+		docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, pathname, dirInfo.getPathnameKey(0))
+		if err != nil {
+			t.Fatalf("error when encrypting the pathname: %s", err)
+		}
+		documents = append(documents, docID)
+	}
+	sort.Strings(expected)
+
+	for _, workers := range []int{1, 4} {
+		client.SetDecryptWorkers(workers)
+		actual, err := client.docIDsToFilenames(dirInfo, documents)
+		if err != nil {
+			t.Fatalf("error when decrypting the document IDs with %d workers: %s", workers, err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("incorrect filenames with %d workers", workers)
+		}
+	}
+
+	documents[numDocs-1] = "invalid"
+	if _, err := client.docIDsToFilenames(dirInfo, documents); err == nil {
+		t.Fatalf("no error for an invalid document ID")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// AddDirectory starts indexing `directory` on the running client, with the
+// index parameters the client has been created with.  The TLF of the directory
+// is registered on the search server if needed, and the directory is locked in
+// the state directory if the client holds its locks.  Returns whether the
+// previous client of the directory has not shut down cleanly, in which case
+// the directory should be reconciled with `ReindexStale`.  The caller is
+// expected to kick off the indexing of the directory, e.g. with `PeriodicAdd`.
+// Safe to call concurrently with the searches, the scans and
+// `RemoveDirectory`.
+func (c *Client) AddDirectory(ctx context.Context, directory string) (bool, error) {
+	dirInfo, err := newDirectoryInfo(ctx, c.searchCli, directory, c.dirParams)
+	if err != nil {
+		return false, err
+	}
+	if c.standby != nil && !c.standby.isFailedOver() {
+		if err := registerStandby(ctx, c.standby.standby, dirInfo, c.dirParams); err != nil {
+			return false, err
+		}
+	}
+
+	c.dirLock.Lock()
+	defer c.dirLock.Unlock()
+	if _, ok := c.directoryInfos[dirInfo.absDir]; ok {
+		return false, fmt.Errorf("directory %s is already indexed", dirInfo.absDir)
+	}
+	c.issuesLock.Lock()
+	stateDir := c.stateDir
+	c.issuesLock.Unlock()
+	unclean := false
+	if stateDir != "" {
+		lock, dirty, err := acquireStateLock(stateDir, dirInfo.absDir)
+		if err != nil {
+			return false, err
+		}
+		issues, err := readFileIssues(stateDir, dirInfo.absDir)
+		if err != nil {
+			lock.abandon()
+			return false, err
+		}
+		queue, err := readOfflineQueue(stateDir, dirInfo.absDir)
+		if err != nil {
+			lock.abandon()
+			return false, err
+		}
+		hashes, err := readContentHashes(stateDir, dirInfo.absDir)
+		if err != nil {
+			lock.abandon()
+			return false, err
+		}
+		if err := migrateWordSetDigests(stateDir, dirInfo.absDir); err != nil {
+			lock.abandon()
+			return false, err
+		}
+		unclean = dirty
+		c.stateLocks[dirInfo.absDir] = lock
+		c.issuesLock.Lock()
+		c.fileIssues[dirInfo.absDir] = issues
+		c.offlineQueues[dirInfo.absDir] = queue
+		c.contentHashes[dirInfo.absDir] = hashes
+		c.issuesLock.Unlock()
+	}
+	c.directoryInfos[dirInfo.absDir] = dirInfo
+	if dirInfo.padding.isBatched() {
+		go c.periodicFlush(dirInfo)
+	}
+	return unclean, nil
+}
+
+// RemoveDirectory stops indexing `directory` on the running client.  The
+// background loops drop the directory once their current scan completes, and
+// the uploads held back by the padding policy of the directory are sent.  The
+// indexes of the directory are kept on the search server.  Safe to call
+// concurrently with the searches, the scans and `AddDirectory`.
+func (c *Client) RemoveDirectory(ctx context.Context, directory string) error {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	c.dirLock.Lock()
+	dirInfo, ok := c.directoryInfos[absDir]
+	if !ok {
+		c.dirLock.Unlock()
+		return errors.New("invalid directory name provided")
+	}
+	delete(c.directoryInfos, absDir)
+	close(dirInfo.removedCh)
+	close(c.removals)
+	c.removals = make(chan struct{})
+	lock := c.stateLocks[absDir]
+	delete(c.stateLocks, absDir)
+	c.dirLock.Unlock()
+
+	c.issuesLock.Lock()
+	delete(c.fileIssues, absDir)
+	delete(c.contentHashes, absDir)
+	c.issuesLock.Unlock()
+	err = c.flushPending(ctx, dirInfo)
+	// The operations still queued are replayed by the next client of the
+	// directory.
+	c.issuesLock.Lock()
+	delete(c.offlineQueues, absDir)
+	c.issuesLock.Unlock()
+	c.progressLock.Lock()
+	delete(c.scanErrors, absDir)
+	delete(c.scanDurations, absDir)
+	delete(c.uploadedBytes, absDir)
+	c.progressLock.Unlock()
+	c.metrics.forget(absDir)
+	if lock == nil {
+		return err
+	} else if err != nil {
+		// Leaves the directory to be reconciled by its next client.
+		lock.abandon()
+		return err
+	}
+	return lock.release()
+}
+
+// trackDirectories returns the DirectoryInfo's of `directories`, or nil for
+// the unknown ones, so that the background loops can drop the directories
+// removed since they started.
+func (c *Client) trackDirectories(directories []string) []*DirectoryInfo {
+	dirInfos := make([]*DirectoryInfo, len(directories))
+	for i, directory := range directories {
+		dirInfos[i], _ = c.getDirectoryInfo(directory)
+	}
+	return dirInfos
+}
+
+// allRemoved returns whether all the `dirInfos` have been removed from the
+// client.
+func allRemoved(dirInfos []*DirectoryInfo) bool {
+	for _, dirInfo := range dirInfos {
+		if !dirInfo.isRemoved() {
+			return false
+		}
+	}
+	return len(dirInfos) > 0
+}
+
+// waitTracked waits for `interval` in the background loop of the `dirInfos`.
+// Returns false, possibly before the end of the interval, if the client has
+// been shut down, `ctx` is done or all the `dirInfos` have been removed, in
+// which case the loop should stop.
+func (c *Client) waitTracked(ctx context.Context, dirInfos []*DirectoryInfo, interval time.Duration) bool {
+	timer := c.clock.After(interval)
+	for {
+		c.dirLock.RLock()
+		removals := c.removals
+		c.dirLock.RUnlock()
+		if allRemoved(dirInfos) {
+			return false
+		}
+		select {
+		case <-timer:
+			return true
+		case <-removals:
+		case <-c.shutdownCh:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestAddRemoveDirectory tests the `AddDirectory` and `RemoveDirectory`
+// functions.  Checks that a directory added at runtime is locked and
+// searchable, that the background loops stop once their directories are
+// removed, and that the lock of a removed directory is released cleanly.
+func TestAddRemoveDirectory(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "TestAddRemoveDirectoryState")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+	server := newMemoryServerClient()
+	cli, dir1 := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir1)
+	if _, err := cli.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", "TestAddRemoveDirectory")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir2)
+	writeTestKbfsStatus(t, dir2, 1)
+
+	if unclean, err := cli.AddDirectory(context.Background(), dir2); err != nil || unclean {
+		t.Fatalf("incorrect addition of the directory: %t, %v", unclean, err)
+	}
+	if _, err := cli.AddDirectory(context.Background(), dir2); err == nil {
+		t.Fatalf("no error when adding the directory twice")
+	}
+	expected := []string{dir1, dir2}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(expected, cli.Directories()) {
+		t.Fatalf("incorrect directories: expected %s actual %s", expected, cli.Directories())
+	}
+	other, _ := startTestClientWithServer(t, dir2, server)
+	if _, err := other.LockDirectories(stateDir); err != (DirectoryLockedError{Directory: dir2, Holder: os.Getpid()}) {
+		t.Fatalf("added directory not locked: %v", err)
+	}
+	writeTestFiles(t, cli, dir2, 1)
+	if filenames, err := cli.SearchWord(context.Background(), dir2, "common"); err != nil || len(filenames) != 1 {
+		t.Fatalf("incorrect search results in the added directory: %s, %v", filenames, err)
+	}
+
+	scanned := make(chan IndexReport, 10)
+	done := make(chan struct{})
+	go func() {
+		cli.PeriodicAdd(context.Background(), expected, func(report IndexReport) {
+			scanned <- report
+		})
+		close(done)
+	}()
+	for i := 0; i < len(expected); i++ {
+		if report := <-scanned; report.Err != nil {
+			t.Fatalf("error when scanning the directory: %s", report.Err)
+		}
+	}
+
+	if err := cli.RemoveDirectory(context.Background(), dir2); err != nil {
+		t.Fatalf("error when removing the directory: %s", err)
+	}
+	if err := cli.RemoveDirectory(context.Background(), dir2); err == nil {
+		t.Fatalf("no error when removing the directory twice")
+	}
+	if _, err := cli.SearchWord(context.Background(), dir2, "common"); err == nil {
+		t.Fatalf("no error when searching the removed directory")
+	}
+	if unclean, err := other.LockDirectories(stateDir); err != nil || len(unclean) != 0 {
+		t.Fatalf("lock of the removed directory not released cleanly: %s, %v", unclean, err)
+	}
+	select {
+	case <-done:
+		t.Fatalf("scans stopped while a directory remains")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := cli.RemoveDirectory(context.Background(), dir1); err != nil {
+		t.Fatalf("error when removing the directory: %s", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("scans not stopped after all the directories were removed")
+	}
+	if len(cli.Directories()) != 0 {
+		t.Fatalf("directories left after removal: %s", cli.Directories())
+	}
+}
+
+// TestConcurrentDirectoryChanges tests the `AddDirectory` and
+// `RemoveDirectory` functions called while the other directories are
+// searched.  Checks that each directory ends up either added or removed, and
+// that the searches of the other directories are unaffected.
+func TestConcurrentDirectoryChanges(t *testing.T) {
+	cli, dir1 := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir1)
+	writeTestFiles(t, cli, dir1, 1)
+	var dirs []string
+	for i := 0; i < 4; i++ {
+		dir, err := ioutil.TempDir("", "TestConcurrentDirectoryChanges")
+		if err != nil {
+			t.Fatalf("error when creating the test client directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		writeTestKbfsStatus(t, dir, 1)
+		dirs = append(dirs, dir)
+	}
+
+	errs := make(chan error, len(dirs)+1)
+	for _, dir := range dirs {
+		go func(dir string) {
+			for i := 0; i < 5; i++ {
+				if _, err := cli.AddDirectory(context.Background(), dir); err != nil {
+					errs <- err
+					return
+				}
+				if err := cli.RemoveDirectory(context.Background(), dir); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(dir)
+	}
+	go func() {
+		for i := 0; i < 20; i++ {
+			if filenames, err := cli.SearchWord(context.Background(), dir1, "common"); err != nil || len(filenames) != 1 {
+				errs <- fmt.Errorf("incorrect search results: %s, %v", filenames, err)
+				return
+			}
+			cli.Directories()
+		}
+		errs <- nil
+	}()
+	for i := 0; i < len(dirs)+1; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("error when changing the directories: %s", err)
+		}
+	}
+	if !reflect.DeepEqual([]string{dir1}, cli.Directories()) {
+		t.Fatalf("incorrect directories: %s", cli.Directories())
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// ErrNotIndexed is returned when the search server holds no index for a file.
+var ErrNotIndexed = errors.New("file not indexed on the search server")
+
+// DocumentInfo describes the index stored on the search server for a file.
+type DocumentInfo struct {
+	IndexSize int64          // The size in bytes of the marshaled index.
+	LastWrite time.Time      // The time the index was last written.
+	KeyGen    libkbfs.KeyGen // The key generation the index was built with.
+	Revision  int64          // The revision of the index, incremented by each write.
+}
+
+// toDocumentInfo converts the information returned by the search server.
+func toDocumentInfo(info sserver1.DocumentInfo) DocumentInfo {
+	return DocumentInfo{
+		IndexSize: info.IndexSize,
+		LastWrite: time.Unix(0, info.LastWriteTime*int64(time.Millisecond)),
+		KeyGen:    libkbfs.KeyGen(info.KeyGen),
+		Revision:  info.Revision,
+	}
+}
+
+// getDocumentInfos returns the information on the indexes stored on the search
+// server for the files with the relative paths `relPaths` in the directory of
+// `dirInfo`, in a single round trip.  As the document ID of a file depends on
+// the key generation it was indexed with, the document IDs for all the known
+// key generations are looked up, and the index with the latest key generation
+// is returned.  The files without an index are omitted.
+func (c *Client) getDocumentInfos(ctx context.Context, dirInfo *DirectoryInfo, relPaths []string) (map[string]DocumentInfo, error) {
+	dirInfo.keyGenLock.RLock()
+	docIDs := make([]sserver1.DocumentID, 0, len(relPaths)*len(dirInfo.pathnameKeys))
+	docIDToRelPath := make(map[sserver1.DocumentID]string, cap(docIDs))
+	for _, relPath := range relPaths {
+		for index, pathnameKey := range dirInfo.pathnameKeys {
+			keyGen := libkbfs.KeyGen(index) + libkbfs.FirstValidKeyGen
+			if dirInfo.keyGen == libkbfs.PublicKeyGen {
+				keyGen = libkbfs.PublicKeyGen
+			}
+			docID, err := libsearch.PathnameToDocID(keyGen, relPath, pathnameKey)
+			if err != nil {
+				dirInfo.keyGenLock.RUnlock()
+				return nil, err
+			}
+			docIDs = append(docIDs, docID)
+			docIDToRelPath[docID] = relPath
+		}
+	}
+	dirInfo.keyGenLock.RUnlock()
+
+	infos, err := c.searchCli.GetDocumentInfo(ctx, sserver1.GetDocumentInfoArg{TlfID: dirInfo.tlfID, DocIDs: docIDs})
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]DocumentInfo, len(relPaths))
+	for _, info := range infos {
+		relPath, ok := docIDToRelPath[info.DocID]
+		if !ok {
+			continue
+		}
+		dirInfo.revisions.seen(info.DocID, info.Revision)
+		if prev, ok := latest[relPath]; !ok || libkbfs.KeyGen(info.KeyGen) > prev.KeyGen {
+			latest[relPath] = toDocumentInfo(info)
+		}
+	}
+	return latest, nil
+}
+
+// GetDocumentInfo returns the information on the index stored on the search
+// server for the file with `pathname` in `directory`.  If the file has been
+// indexed with several key generations, the index with the latest one is
+// returned.  Returns `ErrNotIndexed` if no index is stored for the file.
+func (c *Client) GetDocumentInfo(ctx context.Context, directory, pathname string) (DocumentInfo, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+
+	infos, err := c.getDocumentInfos(ctx, dirInfo, []string{relPath})
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+	info, ok := infos[relPath]
+	if !ok {
+		return DocumentInfo{}, ErrNotIndexed
+	}
+	return info, nil
+}
+
+// walkDocumentInfos walks the files under the directory of `dirInfo` with
+// `walkScannedFiles`, and calls `fn` with the path relative to the directory
+// and the modification time of each, along with the information on its index
+// on the search server, or nil if it has no index.  The information is
+// requested in batches of `reindexStaleBatchSize` files.  The hidden files are
+// skipped, unless `onHidden` is set, in which case it is called with their
+// relative paths instead.
+func (c *Client) walkDocumentInfos(ctx context.Context, dirInfo *DirectoryInfo, fn func(relPath string, modTime time.Time, info *DocumentInfo), onHidden func(relPath string)) error {
+	var relPaths []string
+	modTimes := make(map[string]time.Time)
+	flush := func() error {
+		infos, err := c.getDocumentInfos(ctx, dirInfo, relPaths)
+		if err != nil {
+			return err
+		}
+		for _, relPath := range relPaths {
+			if info, ok := infos[relPath]; ok {
+				fn(relPath, modTimes[relPath], &info)
+			} else {
+				fn(relPath, modTimes[relPath], nil)
+			}
+		}
+		relPaths = relPaths[:0]
+		modTimes = make(map[string]time.Time)
+		return nil
+	}
+
+	err := walkScannedFiles(dirInfo.absDir, c.symlinks, func(relPath string, info os.FileInfo) error {
+		relPaths = append(relPaths, relPath)
+		modTimes[relPath] = info.ModTime()
+		if len(relPaths) == reindexStaleBatchSize {
+			return flush()
+		}
+		return nil
+	}, onHidden)
+	if err == nil && len(relPaths) > 0 {
+		err = flush()
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestGetDocumentInfo tests the `GetDocumentInfo` function.  Checks that the
+// information on the index of a file is returned, that the index built with
+// the latest key generation wins after a rekey, and that `ErrNotIndexed` is
+// returned for the files without an index.
+func TestGetDocumentInfo(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	pathname := filepath.Join(dir, "infoFile")
+	if err := ioutil.WriteFile(pathname, []byte("some indexed content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if _, err := client.GetDocumentInfo(context.Background(), dir, pathname); err != ErrNotIndexed {
+		t.Fatalf("incorrect error for a file not indexed: %v", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	info, err := client.GetDocumentInfo(context.Background(), dir, pathname)
+	if err != nil {
+		t.Fatalf("error when getting the document info: %s", err)
+	}
+	if info.IndexSize <= 0 || info.KeyGen != 1 || info.LastWrite.Before(before) || info.LastWrite.After(time.Now()) {
+		t.Fatalf("incorrect document info: %+v", info)
+	}
+
+	writeTestKbfsStatus(t, dir, 2)
+	client.refreshKeys(client.directoryInfos[dir])
+	if info, err = client.GetDocumentInfo(context.Background(), dir, pathname); err != nil || info.KeyGen != 1 {
+		t.Fatalf("incorrect document info after the rekey: %+v, %v", info, err)
+	}
+	if err := client.AddFile(context.Background(), dir, pathname); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	if info, err = client.GetDocumentInfo(context.Background(), dir, pathname); err != nil || info.KeyGen != 2 {
+		t.Fatalf("incorrect document info after the reindex: %+v, %v", info, err)
+	}
+}
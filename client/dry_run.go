@@ -0,0 +1,116 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// DryRunOptions are the skip rules and the index parameters a dry run applies,
+// as they would be set on a client.
+type DryRunOptions struct {
+	MaxFileSize  int64                    // The size beyond which the files are skipped.  No limit if 0.
+	SkipBinary   bool                     // Whether the files with binary content are skipped.
+	FpRate       float64                  // The desired false positive rate of the indexes.
+	NumUniqWords uint64                   // The expected number of unique words in the TLF.
+	IndexType    sserver1.IndexType       // The type of the indexes of the TLF.
+	Blinding     libsearch.BlindingPolicy // The policy the indexes are blinded with.  `libsearch.LengthBlinding` if nil.
+	SizeBuckets  bool                     // Whether the indexes are padded up to their size buckets.
+	Symlinks     SymlinkPolicy            // How the symlinks are handled.  Skipped unless `SymlinkFollow`.
+}
+
+// DryRunFile is a file whose index a scan would upload.
+type DryRunFile struct {
+	Path      string // The absolute path of the file.
+	Size      int64  // The size of the file in bytes.
+	IndexSize int64  // The estimated size in bytes of the index of the file.
+}
+
+// DryRunReport lists what the next scan of a directory would send to the
+// search server.
+type DryRunReport struct {
+	Directory  string             // The directory scanned.
+	Files      []DryRunFile       // The files that would be indexed, sorted by path.
+	IndexBytes int64              // The estimated total size in bytes of their indexes.
+	Renamed    map[string]string  // The files that would only have their index renamed, from the original to the current path.
+	Unchanged  int                // The number of files not modified since the last scan.
+	Skipped    map[SkipReason]int // The number of files that would be skipped, per reason.
+}
+
+// DryRun walks `directory` like `IndexUpdatedFiles` would, applying the skip
+// rules of `opts` and the indexed state of the directory, and reports the files
+// whose index would be uploaded along with the estimated sizes of their
+// indexes, without contacting the search server nor recording the scan.  The
+// sizes are estimated with the bloom filters sized as the search server would
+// for a new TLF with the parameters of `opts`.  With
+// `libsearch.PercentageBlinding`, the words of the files are left out of the
+// estimates.
+func DryRun(directory string, opts DryRunOptions) (DryRunReport, error) {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return DryRunReport{}, err
+	}
+	report := DryRunReport{Directory: absDir, Skipped: make(map[SkipReason]int)}
+	state, err := loadIndexState(absDir)
+	if err != nil {
+		return DryRunReport{}, err
+	}
+	plan, err := planScan(absDir, state, opts.Symlinks, func(string) {
+		report.Skipped[SkipExcluded]++
+	})
+	if err != nil {
+		return DryRunReport{}, err
+	}
+	report.Unchanged = len(plan.unchanged)
+	var updated []string
+	for _, file := range plan.updated {
+		updated = append(updated, file.relPath)
+	}
+	for orig, curr := range matchRenames(plan.gone, plan.appeared) {
+		if report.Renamed == nil {
+			report.Renamed = make(map[string]string)
+		}
+		report.Renamed[filepath.Join(absDir, orig)] = filepath.Join(absDir, curr)
+		delete(plan.appeared, curr)
+	}
+	for relPath := range plan.appeared {
+		updated = append(updated, relPath)
+	}
+
+	numKeys := libsearch.ComputeNumKeys(opts.FpRate)
+	size := libsearch.ComputeFilterSize(opts.NumUniqWords, numKeys)
+	blinding := opts.Blinding
+	if blinding == nil {
+		blinding = libsearch.LengthBlinding{}
+	}
+	mapping := registeredMapping(opts.IndexType)
+	for _, relPath := range updated {
+		path := filepath.Join(absDir, relPath)
+		if reason, _ := checkSkipRules(path, opts.MaxFileSize, opts.SkipBinary); reason != "" {
+			report.Skipped[reason]++
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			report.Skipped[SkipFailed]++
+			continue
+		}
+		// The estimate only depends on the number of entries of the
+		// index, words and random ones alike.
+		numEntries := blinding.NumEntries(info.Size(), 0)
+		file := DryRunFile{Path: path, Size: info.Size(), IndexSize: libsearch.EstimateIndexSize(numEntries, numKeys, size, mapping, blinding.Version(), opts.SizeBuckets)}
+		report.Files = append(report.Files, file)
+		report.IndexBytes += file.IndexSize
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	return report, nil
+}
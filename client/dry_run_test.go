@@ -0,0 +1,79 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestDryRun tests the `DryRun` function.  Checks that the files that would
+// be indexed are listed with an estimated index size, that the skip rules and
+// the timestamps of the last scan are applied, that the renames are detected,
+// and that the dry run does not record any scan.
+func TestDryRun(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	client.SetMaxFileSize(100)
+	client.SetSkipBinary(true)
+	if err := os.Mkdir(filepath.Join(dir, ".hidden"), 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	contents := map[string]string{
+		"alpha":         "some text",
+		"beta":          "some other text",
+		"binary":        "some\x00binary",
+		"large":         strings.Repeat("large ", 20),
+		".hiddenFile":   "hidden",
+		".hidden/other": "hidden",
+	}
+	for name, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+
+	opts := DryRunOptions{MaxFileSize: 100, SkipBinary: true, FpRate: 0.000001, NumUniqWords: 1000, IndexType: sserver1.IndexType_BLOOM}
+	report, err := DryRun(dir, opts)
+	if err != nil {
+		t.Fatalf("error during the dry run: %s", err)
+	}
+	if len(report.Files) != 2 || report.Files[0].Path != filepath.Join(dir, "alpha") || report.Files[1].Path != filepath.Join(dir, "beta") {
+		t.Fatalf("incorrect files: %+v", report.Files)
+	}
+	if report.Files[0].Size != 9 || report.Files[0].IndexSize <= 0 || report.IndexBytes != report.Files[0].IndexSize+report.Files[1].IndexSize {
+		t.Fatalf("incorrect sizes: %+v", report)
+	}
+	expectedSkipped := map[SkipReason]int{SkipExcluded: 2, SkipBinary: 1, SkipTooLarge: 1}
+	if !reflect.DeepEqual(expectedSkipped, report.Skipped) {
+		t.Fatalf("incorrect skipped files: expected %v actual %v", expectedSkipped, report.Skipped)
+	}
+	if lastIndexed, err := readLastScan(dir); err != nil || !lastIndexed.IsZero() {
+		t.Fatalf("scan recorded by the dry run: %s, %v", lastIndexed, err)
+	}
+
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when indexing the files: %s", report.Err)
+	}
+	if err := os.Rename(filepath.Join(dir, "alpha"), filepath.Join(dir, "gamma")); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	report, err = DryRun(dir, opts)
+	if err != nil {
+		t.Fatalf("error during the dry run: %s", err)
+	}
+	// The skipped files are recorded by the scan, and so are unchanged too.
+	expectedRenamed := map[string]string{filepath.Join(dir, "alpha"): filepath.Join(dir, "gamma")}
+	if len(report.Files) != 0 || report.Unchanged != 3 || !reflect.DeepEqual(expectedRenamed, report.Renamed) {
+		t.Fatalf("incorrect dry run after the scan: %+v", report)
+	}
+}
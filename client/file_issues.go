@@ -0,0 +1,203 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+// FileIssue records why a file under a client directory has been skipped or
+// has failed to be indexed.
+type FileIssue struct {
+	Path   string     `json:"path"`   // The absolute path of the file.
+	Reason SkipReason `json:"reason"` // The reason the file has no up-to-date index.
+	Err    string     `json:"err"`    // The error encountered, if any.
+	Time   time.Time  `json:"time"`   // The time the issue was last encountered.
+}
+
+// getFileIssuesPath returns the path of the file persisting the issues of
+// `directory` within `stateDir`.
+func getFileIssuesPath(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, ".issues")
+}
+
+// readFileIssues reads the issues of `directory` persisted within `stateDir`.
+func readFileIssues(stateDir, directory string) (map[string]FileIssue, error) {
+	issues := make(map[string]FileIssue)
+	issuesJSON, err := ioutil.ReadFile(getFileIssuesPath(stateDir, directory))
+	if os.IsNotExist(err) {
+		return issues, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(issuesJSON, &issues)
+	return issues, err
+}
+
+// recordFileIssue records the outcome of indexing the file at `path` in
+// `directory`: the issue is recorded with `reason` if `err` is set, and the
+// previous issue of the file is cleared otherwise.
+func (c *Client) recordFileIssue(directory, path string, reason SkipReason, err error) {
+	directory, _ = filepath.Abs(directory)
+	path, _ = filepath.Abs(path)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	issues := c.fileIssues[directory]
+	if err == nil {
+		delete(issues, path)
+		return
+	}
+	if issues == nil {
+		issues = make(map[string]FileIssue)
+		c.fileIssues[directory] = issues
+	}
+	issues[path] = FileIssue{Path: path, Reason: reason, Err: err.Error(), Time: c.clock.Now()}
+}
+
+// addScannedFile adds the file at `path` in `directory` found by a scan to the
+// search server, unless the skip rules of the client exclude it, another
+// client has claimed it or its content is refused, and updates `report` and the
+// issues of the file accordingly.  Returns false if the file has failed to be
+// added, so that it is retried by the next scan.
+func (c *Client) addScannedFile(ctx context.Context, report *IndexReport, directory, path string) bool {
+	reason, err := c.checkSkipRules(path)
+	if reason != "" {
+		c.recordFileIssue(directory, path, reason, err)
+		if reason == SkipFailed {
+			return false
+		}
+		report.Skipped++
+		return true
+	}
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		c.recordFileIssue(directory, path, SkipFailed, err)
+		return false
+	}
+	// The content only touched, e.g. copied back, is not indexed again.
+	hash, info, err := hashContent(dirInfo, path)
+	if err == nil && c.contentUnchanged(directory, path, hash) {
+		c.recordFileIssue(directory, path, SkipFailed, nil)
+		report.Unchanged++
+		return true
+	}
+	claimed, err := c.claimFile(ctx, directory, path)
+	if err != nil {
+		c.recordFileIssue(directory, path, SkipFailed, err)
+		return false
+	} else if !claimed {
+		// The index is left to the client holding the claim, and
+		// `ReindexStale` catches the uploads it fails.
+		report.Deferred++
+		return true
+	}
+	err = c.AddFile(ctx, directory, path)
+	if _, refused := err.(ContentRefusedError); refused {
+		// The index of a version of the file indexed before it was
+		// refused is deleted.
+		if err := c.DeleteFile(ctx, directory, path); err != nil {
+			c.recordFileIssue(directory, path, SkipFailed, err)
+			return false
+		}
+		c.recordFileIssue(directory, path, SkipRefused, err)
+		c.recordContentHash(directory, path, "")
+		report.Skipped++
+		return true
+	}
+	c.recordFileIssue(directory, path, SkipFailed, err)
+	if err != nil {
+		return false
+	}
+	// The hash is only recorded if the file has not changed while being
+	// indexed, so that it matches the content of the index.
+	if curr, statErr := os.Stat(path); hash != "" && statErr == nil && curr.Size() == info.Size() && curr.ModTime().Equal(info.ModTime()) {
+		c.recordContentHash(directory, path, hash)
+	} else {
+		c.recordContentHash(directory, path, "")
+	}
+	report.Added = append(report.Added, path)
+	return true
+}
+
+// deleteScannedFile deletes the index of the file at `path` in `directory`
+// found gone by a scan, along with its tags, and records the failure, if any.
+// Returns whether the index has been deleted.
+func (c *Client) deleteScannedFile(ctx context.Context, directory, path string) bool {
+	err := c.DeleteFile(ctx, directory, path)
+	if err == nil {
+		err = c.dropTags(ctx, directory, path)
+	}
+	c.recordFileIssue(directory, path, SkipFailed, err)
+	if err == nil {
+		c.recordContentHash(directory, path, "")
+	}
+	return err == nil
+}
+
+// pruneFileIssues drops the issues of the files of `directory` that no longer
+// exist, unless `keep` returns true for their relative path.
+func (c *Client) pruneFileIssues(directory string, keep func(relPath string) bool) {
+	directory, _ = filepath.Abs(directory)
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	for path := range c.fileIssues[directory] {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			continue
+		}
+		if relPath, err := filepath.Rel(directory, path); err == nil && keep(relPath) {
+			continue
+		}
+		delete(c.fileIssues[directory], path)
+	}
+}
+
+// saveFileIssues persists the issues of `directory` in the state directory of
+// the client, if its directories are locked.
+func (c *Client) saveFileIssues(directory string) error {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	if c.stateDir == "" {
+		return nil
+	}
+	issuesJSON, err := json.Marshal(c.fileIssues[directory])
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(getFileIssuesPath(c.stateDir, directory), issuesJSON)
+}
+
+// GetFileIssues returns the files of `directory` that have been skipped or
+// have failed to be indexed since they were last indexed successfully, sorted
+// by path.  The issues are kept across restarts in the state directory passed
+// to `LockDirectories`.
+func (c *Client) GetFileIssues(directory string) ([]FileIssue, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	issues := make([]FileIssue, 0, len(c.fileIssues[dirInfo.absDir]))
+	for _, issue := range c.fileIssues[dirInfo.absDir] {
+		issues = append(issues, issue)
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Path < issues[j].Path
+	})
+	return issues, nil
+}
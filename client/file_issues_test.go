@@ -0,0 +1,92 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// rejectingWriteServerClient is an in-memory server on which the index writes
+// can be made to fail.
+type rejectingWriteServerClient struct {
+	*memoryServerClient
+	reject bool
+}
+
+func (c *rejectingWriteServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	if c.reject {
+		return sserver1.WriteResult{}, errors.New("index rejected")
+	}
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestGetFileIssues tests the `GetFileIssues` function.  Checks that the files
+// failing to be indexed by a scan are recorded with the error, that the issues
+// are persisted in the state directory across clients, and that an issue is
+// cleared once the file is indexed.
+func TestGetFileIssues(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "TestGetFileIssuesState")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	server := &rejectingWriteServerClient{memoryServerClient: newMemoryServerClient(), reject: true}
+	client1, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	if _, err := client1.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := client1.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 0 {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	issues, err := client1.GetFileIssues(dir)
+	if err != nil {
+		t.Fatalf("error when getting the file issues: %s", err)
+	}
+	if len(issues) != 1 || issues[0].Path != pathname || issues[0].Reason != SkipFailed || issues[0].Err != "index rejected" || issues[0].Time.IsZero() {
+		t.Fatalf("incorrect file issues: %+v", issues)
+	}
+	if status, err := client1.GetDirectoryStatus(dir); err != nil || status.NumIssues != 1 {
+		t.Fatalf("incorrect directory status: %+v, %v", status, err)
+	}
+	if err := client1.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+
+	server.reject = false
+	client2, _ := startTestClientWithServer(t, dir, server)
+	if _, err := client2.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	defer client2.UnlockDirectories()
+	if issues, err := client2.GetFileIssues(dir); err != nil || len(issues) != 1 || issues[0].Path != pathname {
+		t.Fatalf("file issues not persisted: %+v, %v", issues, err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(pathname, future, future); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+	if report := client2.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	if issues, err := client2.GetFileIssues(dir); err != nil || len(issues) != 0 {
+		t.Fatalf("file issue not cleared: %+v, %v", issues, err)
+	}
+}
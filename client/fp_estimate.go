@@ -0,0 +1,27 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"math"
+)
+
+// EstimateFalsePositives returns the expected number of documents of
+// `directory` falsely matching a search for a word they do not contain, when
+// the matches are not verified by the search server.  The false positive rate
+// of the indexes of a TLF is 2^-k for its k salts, which is multiplied by the
+// number of documents in the indexed state of the directory.  For a query of
+// several terms, this is an upper bound.
+func (c *Client) EstimateFalsePositives(directory string) (float64, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return 0, err
+	}
+	state, err := loadIndexState(dirInfo.absDir)
+	if err != nil {
+		return 0, err
+	}
+	return float64(len(state.Files)) * math.Exp2(-float64(len(dirInfo.tlfInfo.Salts))), nil
+}
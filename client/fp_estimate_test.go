@@ -0,0 +1,49 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestEstimateFalsePositives tests the `EstimateFalsePositives` function.
+// Checks that the estimate scales with the number of documents in the indexed
+// state of the directory, at the false positive rate given by the number of
+// salts of the TLF.
+func TestEstimateFalsePositives(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	lockTestClient(t, cli, dir)
+
+	if estimate, err := cli.EstimateFalsePositives(dir); err != nil || estimate != 0 {
+		t.Fatalf("incorrect estimate for an empty directory: %f, %v", estimate, err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("common word"+strconv.Itoa(i)), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when scanning the directory: %s", report.Err)
+	}
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+	expected := 4 * math.Exp2(-float64(len(dirInfo.tlfInfo.Salts)))
+	if estimate, err := cli.EstimateFalsePositives(dir); err != nil || estimate != expected || estimate <= 0 {
+		t.Fatalf("incorrect estimate: %g instead of %g, %v", estimate, expected, err)
+	}
+	if _, err := cli.EstimateFalsePositives("/keybase/private/nobody"); err == nil {
+		t.Fatalf("no error for an unknown directory")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// archivedRevPrefix is the prefix of the directories at the root of a TLF that
+// KBFS serves its archived revisions under, e.g. `.kbfs_archived_rev=42`.
+const archivedRevPrefix = ".kbfs_archived_rev="
+
+// archivedPath returns the path, relative to the root of a TLF, of the version
+// of the file at `relPath` as of the revision `revision` of the TLF.  The
+// indexes of the prior versions of the files are stored under these paths, so
+// that their document IDs encode both the path and the revision.
+func archivedPath(relPath string, revision int64) string {
+	return filepath.Join(archivedRevPrefix+strconv.FormatInt(revision, 10), relPath)
+}
+
+// ParseArchivedPath checks whether `path`, a result of a search in
+// `directory`, is a prior version of a file indexed with `SetHistoryRevisions`.
+// If so, returns the path the file had at the time, and the revision of the
+// TLF of the version.
+func ParseArchivedPath(directory, path string) (string, int64, bool) {
+	relPath, err := filepath.Rel(directory, path)
+	if err != nil || !strings.HasPrefix(relPath, archivedRevPrefix) {
+		return "", 0, false
+	}
+	parts := strings.SplitN(relPath, string(filepath.Separator), 2)
+	revision, err := strconv.ParseInt(strings.TrimPrefix(parts[0], archivedRevPrefix), 10, 64)
+	if err != nil || len(parts) != 2 || revision <= 0 {
+		return "", 0, false
+	}
+	return filepath.Join(directory, parts[1]), revision, true
+}
+
+// readTLFRevision returns the latest revision of the TLF at `directory`.
+func readTLFRevision(directory string) (int64, error) {
+	statusJSON, err := ioutil.ReadFile(filepath.Join(directory, ".kbfs_status"))
+	if err != nil {
+		return 0, err
+	}
+	var folderStatus libkbfs.FolderBranchStatus
+	if err := json.Unmarshal(statusJSON, &folderStatus); err != nil {
+		return 0, err
+	}
+	return int64(folderStatus.Revision), nil
+}
+
+// SetHistoryRevisions sets how many prior versions of each file of all the
+// directories are kept searchable, none by default.  When a scan indexes a new
+// version of a file, the version it replaces is indexed as well, as read from
+// the archived revision of the TLF it was indexed at, and the oldest versions
+// beyond `revisions` are deleted.  The prior versions are found by the
+// searches under their archived paths, as told apart by `ParseArchivedPath`.
+// Should be called before any scan.
+func (c *Client) SetHistoryRevisions(revisions int) {
+	if revisions < 0 {
+		revisions = 0
+	}
+	c.historyDepth = revisions
+}
+
+// updateHistory indexes the version of the file at `relPath` in `directory`
+// described by `prev`, replaced by a new version, and deletes the prior
+// versions beyond the limit set by `SetHistoryRevisions`.  Returns the archived
+// paths of the prior versions still indexed, oldest first.  A version whose
+// index cannot be deleted is kept, so that its deletion is retried the next
+// time the file changes.
+func (c *Client) updateHistory(ctx context.Context, directory, relPath string, prev indexedEntry) []string {
+	history := prev.History
+	if c.historyDepth > 0 && prev.Revision > 0 {
+		archived := archivedPath(relPath, prev.Revision)
+		if err := c.AddFile(ctx, directory, filepath.Join(directory, archived)); err == nil {
+			history = append(history[:len(history):len(history)], archived)
+		}
+	}
+	for len(history) > c.historyDepth {
+		if c.DeleteFile(ctx, directory, filepath.Join(directory, history[0])) != nil {
+			break
+		}
+		history = history[1:]
+	}
+	return history
+}
+
+// deleteHistory deletes the indexes of the prior versions of a file at the
+// archived paths of its `history`, once the file is gone.
+func (c *Client) deleteHistory(ctx context.Context, directory string, history []string) {
+	for _, archived := range history {
+		c.DeleteFile(ctx, directory, filepath.Join(directory, archived))
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// writeTestRevision writes a fake `.kbfs_status` file with `revision` as the
+// latest revision of the TLF into `dir`, and the `content` of the file `name`
+// as of that revision into its archive.
+func writeTestRevision(t *testing.T, dir string, revision int64, name, content string) {
+	status := libkbfs.FolderBranchStatus{FolderID: "aRandomTLFID", LatestKeyGeneration: 1, Revision: revision}
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("error when writing the TLF status: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".kbfs_status"), statusJSON, 0666); err != nil {
+		t.Fatalf("error when writing the TLF status: %s", err)
+	}
+	archived := filepath.Join(dir, archivedPath(name, revision))
+	if err := os.MkdirAll(filepath.Dir(archived), 0777); err != nil {
+		t.Fatalf("error when creating the archive: %s", err)
+	}
+	for _, path := range []string{filepath.Join(dir, name), archived} {
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		modTime := time.Unix(revision*1000, 0)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+}
+
+// TestHistory tests the indexing of the prior versions of the files.  Checks
+// that a version replaced by a scan is found under its archived path, that the
+// versions beyond the limit are deleted, and that the history of a file goes
+// away with the file.
+func TestHistory(t *testing.T) {
+	server := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	cli.SetHistoryRevisions(1)
+
+	expectResults := func(word string, expected ...string) {
+		results, err := cli.SearchWord(context.Background(), dir, word)
+		if err != nil {
+			t.Fatalf("error when searching for %s: %s", word, err)
+		}
+		if len(results) == 0 {
+			results = nil
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Fatalf("incorrect results for %s: %v, expected %v", word, results, expected)
+		}
+	}
+	scan := func() {
+		if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+			t.Fatalf("error when scanning the directory: %s", report.Err)
+		}
+	}
+
+	writeTestRevision(t, dir, 5, "notes", "alpha")
+	scan()
+	writeTestRevision(t, dir, 6, "notes", "beta")
+	scan()
+	alphaPath := filepath.Join(dir, archivedPath("notes", 5))
+	expectResults("alpha", alphaPath)
+	expectResults("beta", filepath.Join(dir, "notes"))
+	if current, revision, ok := ParseArchivedPath(dir, alphaPath); !ok || current != filepath.Join(dir, "notes") || revision != 5 {
+		t.Fatalf("incorrect archived path parsed: %s, %d, %v", current, revision, ok)
+	}
+	if _, _, ok := ParseArchivedPath(dir, filepath.Join(dir, "notes")); ok {
+		t.Fatalf("current version parsed as an archived one")
+	}
+
+	writeTestRevision(t, dir, 7, "notes", "gamma")
+	scan()
+	expectResults("alpha")
+	expectResults("beta", filepath.Join(dir, archivedPath("notes", 6)))
+
+	if err := os.Remove(filepath.Join(dir, "notes")); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	scan()
+	expectResults("beta")
+}
@@ -0,0 +1,240 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+)
+
+const (
+	// indexStateFile is the name of the file in a directory storing the
+	// indexed state of the directory as of its last completed scan.
+	indexStateFile = ".search_kbfs_state"
+	// indexStateJournalFile is the name of the file in a directory the files
+	// indexed or deleted since the last completed scan are appended to, so
+	// that a scan interrupted by a crash resumes where it stopped.
+	indexStateJournalFile = ".search_kbfs_state.journal"
+	// legacyLastIndexedFile is the name of the file in a directory that used
+	// to store the time the directory was last scanned, read once to migrate
+	// to the indexed state.
+	legacyLastIndexedFile = ".search_kbfs_timestamp"
+	// legacyIndexedFile is the name of the file in a directory that used to
+	// list the files indexed as of the last scan, read once to migrate to the
+	// indexed state.
+	legacyIndexedFile = ".search_kbfs_indexed"
+)
+
+// fileChange is how a file found by a scan compares to the indexed state of
+// its directory.
+type fileChange int
+
+const (
+	// fileUnchanged is a file indexed with its current size and
+	// modification time.
+	fileUnchanged fileChange = iota
+	// fileUpdated is a file indexed with another size or modification time.
+	fileUpdated
+	// fileAppeared is a file not indexed under its path, either new or
+	// renamed.
+	fileAppeared
+)
+
+// indexStateOp is a line of the journal of the indexed state of a directory.
+type indexStateOp struct {
+	Path  string        `json:"path"`            // The path of the file relative to the directory.
+	Entry *indexedEntry `json:"entry,omitempty"` // The size and modification time the file is indexed with, or nil if its index is deleted.
+}
+
+// indexState is the indexed state of a directory: the size and modification
+// time of each of its files as of its index, which tells the files updated
+// since then apart from the other ones, whatever the time of the last scan.
+// The state is stored in the directory as a snapshot written by each completed
+// scan, and a journal of the files indexed or deleted since the snapshot.
+type indexState struct {
+	directory string                  // The directory of the state.
+	LastScan  time.Time               `json:"lastScan"` // The time the last completed scan started, zero if the directory has never been scanned.
+	Files     map[string]indexedEntry `json:"files"`    // The files indexed, keyed by their path relative to the directory, or nil if they have never been recorded.
+
+	journalLock sync.Mutex // Protects `journal`.
+	journal     *os.File   // The journal opened for appending, or nil if not yet opened.
+}
+
+// loadIndexState reads the indexed state of `directory`, replaying the journal
+// of the files indexed or deleted since the last completed scan.  Migrates the
+// timestamp and the list of the indexed files of the earlier versions if the
+// directory has no indexed state yet.
+func loadIndexState(directory string) (*indexState, error) {
+	state := &indexState{directory: directory}
+	stateJSON, err := ioutil.ReadFile(filepath.Join(directory, indexStateFile))
+	switch {
+	case os.IsNotExist(err):
+		if err := state.readLegacy(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(stateJSON, state); err != nil {
+			return nil, err
+		}
+	}
+
+	journal, err := os.Open(filepath.Join(directory, indexStateJournalFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+	scanner := bufio.NewScanner(journal)
+	for scanner.Scan() {
+		var op indexStateOp
+		// A line cut short by a crash is ignored, as its file is handled
+		// again anyway.
+		if json.Unmarshal(scanner.Bytes(), &op) != nil {
+			continue
+		}
+		if state.Files == nil {
+			state.Files = make(map[string]indexedEntry)
+		}
+		if op.Entry == nil {
+			delete(state.Files, op.Path)
+		} else {
+			state.Files[op.Path] = *op.Entry
+		}
+	}
+	return state, scanner.Err()
+}
+
+// readLegacy fills `s` from the timestamp and the list of the indexed files of
+// the earlier versions, if any.
+func (s *indexState) readLegacy() error {
+	lastIndexedJSON, err := ioutil.ReadFile(filepath.Join(s.directory, legacyLastIndexedFile))
+	if err == nil {
+		err = s.LastScan.UnmarshalJSON(lastIndexedJSON)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	indexedJSON, err := ioutil.ReadFile(filepath.Join(s.directory, legacyIndexedFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(indexedJSON, &s.Files)
+}
+
+// change returns how the file at `relPath` with `info` compares to its indexed
+// state.  Without any file recorded, e.g. as only the timestamp of an earlier
+// version has been migrated, the files modified after the last scan are the
+// updated ones.
+func (s *indexState) change(relPath string, info os.FileInfo) fileChange {
+	entry, ok := s.Files[relPath]
+	switch {
+	case s.Files == nil && info.ModTime().After(s.LastScan):
+		return fileUpdated
+	case s.Files == nil:
+		return fileUnchanged
+	case !ok:
+		return fileAppeared
+	case entry.fingerprint() != newIndexedEntry(info).fingerprint():
+		return fileUpdated
+	default:
+		return fileUnchanged
+	}
+}
+
+// appendOp appends `op` to the journal, opening it first if needed.
+func (s *indexState) appendOp(op indexStateOp) error {
+	opJSON, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	s.journalLock.Lock()
+	defer s.journalLock.Unlock()
+	if s.journal == nil {
+		s.journal, err = os.OpenFile(filepath.Join(s.directory, indexStateJournalFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.journal.Write(append(opJSON, '\n'))
+	return err
+}
+
+// record journals the file at `relPath` as indexed with `entry`.  Safe for
+// concurrent use, but leaves `s.Files` as is: the scans hand the files
+// indexed over to `commit` as a whole.  A failure to journal only costs the
+// file being handled again after a crash, so the scans carry on regardless.
+func (s *indexState) record(relPath string, entry indexedEntry) error {
+	return s.appendOp(indexStateOp{Path: relPath, Entry: &entry})
+}
+
+// forget journals the index of the file at `relPath` as deleted.
+func (s *indexState) forget(relPath string) error {
+	return s.appendOp(indexStateOp{Path: relPath})
+}
+
+// close closes the journal, if open.
+func (s *indexState) close() {
+	s.journalLock.Lock()
+	defer s.journalLock.Unlock()
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+}
+
+// commit writes `files` as the files indexed as of the scan started at
+// `lastScan`, then drops the journal and the files of the earlier versions,
+// now superseded.
+func (s *indexState) commit(files map[string]indexedEntry, lastScan time.Time) error {
+	s.close()
+	s.Files, s.LastScan = files, lastScan
+	stateJSON, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := libsearch.WriteFileAtomic(filepath.Join(s.directory, indexStateFile), stateJSON); err != nil {
+		return err
+	}
+	for _, name := range []string{indexStateJournalFile, legacyLastIndexedFile, legacyIndexedFile} {
+		if err := os.Remove(filepath.Join(s.directory, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLastScan reads the time the last completed scan of `directory` started.
+// Returns the zero time if the directory has never been scanned.
+func readLastScan(directory string) (time.Time, error) {
+	state, err := loadIndexState(directory)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return state.LastScan, nil
+}
+
+// removeIndexState removes the indexed state of `directory`, along with its
+// journal and the state of the earlier versions, so that the next scan indexes
+// all the files again.
+func removeIndexState(directory string) error {
+	for _, name := range []string{indexStateFile, indexStateJournalFile, legacyLastIndexedFile, legacyIndexedFile} {
+		if err := os.Remove(filepath.Join(directory, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
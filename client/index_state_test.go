@@ -0,0 +1,140 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestIndexStateJournal tests the journal of the indexed state.  Checks that
+// the files recorded and forgotten since the last commit are replayed when
+// the state is loaded again, as after a crash, that a line cut short is
+// ignored, and that the commit drops the journal.
+func TestIndexStateJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIndexStateJournal")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	state, err := loadIndexState(dir)
+	if err != nil || state.Files != nil || !state.LastScan.IsZero() {
+		t.Fatalf("incorrect state of a new directory: %+v, %v", state, err)
+	}
+	modTime := time.Unix(1000, 0)
+	for _, relPath := range []string{"a", "b"} {
+		if err := state.record(relPath, indexedEntry{Size: 1, ModTime: modTime}); err != nil {
+			t.Fatalf("error when recording %s: %s", relPath, err)
+		}
+	}
+	if err := state.forget("b"); err != nil {
+		t.Fatalf("error when forgetting b: %s", err)
+	}
+	state.close()
+	journal, err := os.OpenFile(filepath.Join(dir, indexStateJournalFile), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("error when opening the journal: %s", err)
+	}
+	journal.Write([]byte(`{"path":"c","ent`))
+	journal.Close()
+
+	state, err = loadIndexState(dir)
+	if err != nil {
+		t.Fatalf("error when loading the state: %s", err)
+	}
+	if len(state.Files) != 1 || !state.Files["a"].ModTime.Equal(modTime) {
+		t.Fatalf("incorrect files replayed from the journal: %+v", state.Files)
+	}
+
+	lastScan := time.Unix(2000, 0)
+	if err := state.commit(state.Files, lastScan); err != nil {
+		t.Fatalf("error when committing the state: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexStateJournalFile)); !os.IsNotExist(err) {
+		t.Fatalf("journal not dropped by the commit: %v", err)
+	}
+	state, err = loadIndexState(dir)
+	if err != nil || len(state.Files) != 1 || !state.LastScan.Equal(lastScan) {
+		t.Fatalf("incorrect committed state: %+v, %v", state, err)
+	}
+}
+
+// TestIndexStateMigration tests the migration of the timestamp and the list of
+// the indexed files of the earlier versions.  Checks that both are read when
+// the directory has no indexed state yet, and removed by the commit.
+func TestIndexStateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIndexStateMigration")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	legacy := map[string]string{
+		legacyLastIndexedFile: `"2016-09-01T12:00:00Z"`,
+		legacyIndexedFile:     `{"file":{"size":3,"modTime":"2016-08-01T12:00:00Z"}}`,
+	}
+	for name, content := range legacy {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing %s: %s", name, err)
+		}
+	}
+	state, err := loadIndexState(dir)
+	if err != nil {
+		t.Fatalf("error when loading the state: %s", err)
+	}
+	if state.LastScan.Year() != 2016 || state.Files["file"].Size != 3 {
+		t.Fatalf("legacy state not migrated: %+v", state)
+	}
+	if err := state.commit(state.Files, state.LastScan); err != nil {
+		t.Fatalf("error when committing the state: %s", err)
+	}
+	for name := range legacy {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("legacy file %s not removed: %v", name, err)
+		}
+	}
+}
+
+// TestIndexUpdatedFilesResume tests the `IndexUpdatedFiles` function after a
+// scan interrupted by a crash.  Checks that the files journaled by the
+// interrupted scan are not indexed again, while the other ones are.
+func TestIndexUpdatedFilesResume(t *testing.T) {
+	server := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"done", "todo1", "todo2"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, "done"))
+	if err != nil {
+		t.Fatalf("error when reading the test file: %s", err)
+	}
+	state, err := loadIndexState(dir)
+	if err != nil {
+		t.Fatalf("error when loading the state: %s", err)
+	}
+	if err := state.record("done", newIndexedEntry(info)); err != nil {
+		t.Fatalf("error when journaling the test file: %s", err)
+	}
+	state.close()
+
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != 2 {
+		t.Fatalf("incorrect resumed scan: %+v", report)
+	}
+	state, err = loadIndexState(dir)
+	if err != nil || len(state.Files) != 3 {
+		t.Fatalf("incorrect state after the resumed scan: %+v, %v", state, err)
+	}
+}
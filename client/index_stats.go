@@ -0,0 +1,23 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// GetIndexStats returns the aggregate statistics the search server computes
+// over the indexes of the TLF of `directory`, such as the fill ratios of their
+// filters and the distribution of their sizes.  The indexes filled beyond the
+// ratio the TLF has been sized for are counted in `NumOverfilled`, hinting that
+// the TLF should have been registered with a larger number of unique words.
+func (c *Client) GetIndexStats(ctx context.Context, directory string) (sserver1.TlfIndexStats, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return sserver1.TlfIndexStats{}, err
+	}
+	return c.searchCli.GetIndexStats(ctx, dirInfo.tlfID)
+}
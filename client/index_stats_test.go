@@ -0,0 +1,46 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestGetIndexStats tests the `GetIndexStats` function.  Checks that the
+// statistics of the indexes of the TLF are returned by the search server.
+func TestGetIndexStats(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	stats, err := client.GetIndexStats(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("error when getting the index statistics: %s", err)
+	}
+	if stats.NumIndexes != 0 || stats.TotalBytes != 0 {
+		t.Fatalf("incorrect statistics of an empty TLF: %+v", stats)
+	}
+
+	writeTestFiles(t, client, dir, 5)
+	stats, err = client.GetIndexStats(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("error when getting the index statistics: %s", err)
+	}
+	if stats.NumIndexes != 5 || stats.NumOverfilled != 0 {
+		t.Fatalf("incorrect number of indexes: %+v", stats)
+	}
+	if stats.MinBytes <= 0 || stats.MinBytes > stats.MedianBytes || stats.MedianBytes > stats.MaxBytes || stats.TotalBytes < 5*stats.MinBytes {
+		t.Fatalf("inconsistent index sizes: %+v", stats)
+	}
+	if stats.MeanFillRatio <= 0 || stats.MeanFillRatio > stats.MaxFillRatio || stats.FillRatioHistogram[0] != 5 {
+		t.Fatalf("incorrect fill ratios: %+v", stats)
+	}
+
+	if _, err := client.GetIndexStats(context.Background(), "/no/such/directory"); err == nil {
+		t.Fatalf("no error for an unknown directory")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// SetIndexWorkers sets the number of files the scans index concurrently, each
+// building and uploading the index of a different file.  Combine with
+// `SetMemoryBudget` to bound the memory used by the concurrent index builds.
+// A `workers` below 1 is treated as 1.  Should be called before the scans are
+// started.
+func (c *Client) SetIndexWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	c.indexWorkers = workers
+}
+
+// addScannedFiles adds the files at `paths` in `directory` found by a scan with
+// `addScannedFile`, on as many concurrent workers as set by `SetIndexWorkers`.
+// Returns whether each of the files has been handled, in the order of `paths`.
+// `onHandled`, if not nil, is called with the index in `paths` of each file as
+// soon as it is handled, possibly concurrently.  A backfill is announced to the
+// search server for as many files as set by `SetBackfillThreshold`.  The files
+// not started yet once `ctx` is done are left unhandled.
+func (c *Client) addScannedFiles(ctx context.Context, report *IndexReport, directory string, paths []string, onHandled func(i int)) []bool {
+	handled := make([]bool, len(paths))
+	if c.backfillMin > 0 && len(paths) >= c.backfillMin {
+		if dirInfo, err := c.getDirectoryInfo(directory); err == nil {
+			// The files are indexed at the pace of the client alone if
+			// the search server grants no budget.
+			if c.beginBackfill(ctx, dirInfo, len(paths)) == nil {
+				defer c.endBackfill(ctx, dirInfo)
+			}
+		}
+	}
+	c.updateProgress(directory, len(paths), "")
+	add := func(partial *IndexReport, i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		handled[i] = c.addScannedFile(ctx, partial, directory, paths[i])
+		if handled[i] && onHandled != nil {
+			onHandled(i)
+		}
+		c.updateProgress(directory, 0, paths[i])
+	}
+	workers := c.indexWorkers
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	if workers <= 1 {
+		for i := range paths {
+			add(report, i)
+		}
+		return handled
+	}
+
+	// Each worker fills its own report, merged once all the files are
+	// handled.
+	partials := make([]IndexReport, workers)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(partial *IndexReport) {
+			defer wg.Done()
+			for i := range next {
+				add(partial, i)
+			}
+		}(&partials[w])
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, partial := range partials {
+		report.Added = append(report.Added, partial.Added...)
+		report.Skipped += partial.Skipped
+		report.Deferred += partial.Deferred
+		report.Unchanged += partial.Unchanged
+	}
+	return handled
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// concurrencyServerClient is an in-memory server recording the maximum number
+// of index writes in progress at once.
+type concurrencyServerClient struct {
+	*memoryServerClient
+	lock       sync.Mutex
+	inProgress int
+	maxWrites  int
+}
+
+func (c *concurrencyServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	c.lock.Lock()
+	c.inProgress++
+	if c.inProgress > c.maxWrites {
+		c.maxWrites = c.inProgress
+	}
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		c.inProgress--
+		c.lock.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestSetIndexWorkers tests the `SetIndexWorkers` function.  Checks that the
+// files of a scan are indexed concurrently, each of them exactly once, and that
+// the report lists them all.
+func TestSetIndexWorkers(t *testing.T) {
+	server := &concurrencyServerClient{memoryServerClient: newMemoryServerClient()}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	cli.SetIndexWorkers(4)
+
+	const numFiles = 20
+	filenames := make([]string, numFiles)
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filenames[i], []byte("common word"+strconv.Itoa(i)), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	sort.Strings(filenames)
+
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != numFiles {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	for i, filename := range filenames {
+		if report.Added[i] != filename {
+			t.Fatalf("incorrect added files: %v", report.Added)
+		}
+	}
+	if server.maxWrites < 2 || server.maxWrites > 4 {
+		t.Fatalf("incorrect number of concurrent writes: %d", server.maxWrites)
+	}
+	for _, docIDs := range server.writes {
+		if len(docIDs) != numFiles {
+			t.Fatalf("incorrect number of indexes written: %d", len(docIDs))
+		}
+		for docID, writes := range docIDs {
+			if writes != 1 {
+				t.Fatalf("index %s written %d times", docID, writes)
+			}
+		}
+	}
+}
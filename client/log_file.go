@@ -0,0 +1,119 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file rotated once it reaches a maximum size: the file
+// is renamed with the suffix ".1", the previous ".1" becomes ".2", and so on,
+// and the oldest files beyond the number of files kept are deleted.
+type RotatingFile struct {
+	lock     sync.Mutex // Protects the fields below.
+	path     string     // The path of the current log file.
+	maxSize  int64      // The size in bytes beyond which the file is rotated.
+	maxFiles int        // The number of rotated files kept.
+	file     *os.File   // The current log file.
+	size     int64      // The size in bytes of the current log file.
+}
+
+// OpenRotatingFile opens the log file `name` under `dir` for appending,
+// creating the directory if needed.  The file is rotated before it exceeds
+// `maxSize` bytes, and `maxFiles` rotated files are kept.
+func OpenRotatingFile(dir, name string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	rf := &RotatingFile{path: filepath.Join(dir, name), maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the current log file for appending.
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file, rf.size = file, fileInfo.Size()
+	return nil
+}
+
+// rotatedPath returns the path of the `i`th rotated file.
+func (rf *RotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// rotate renames the current log file and the rotated files, deletes the
+// oldest one, and opens a new current log file.  The current log file is
+// reopened even if the renames fail, so that the logging goes on.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	err := rf.shiftFiles()
+	if openErr := rf.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// shiftFiles renames the current log file and the rotated files, and deletes
+// the oldest one.
+func (rf *RotatingFile) shiftFiles() error {
+	if rf.maxFiles <= 0 {
+		return os.Remove(rf.path)
+	}
+	if err := os.Remove(rf.rotatedPath(rf.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rf.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rf.rotatedPath(i), rf.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(rf.path, rf.rotatedPath(1))
+}
+
+// Write implements the io.Writer interface.  The file is rotated first if `p`
+// would make it exceed its maximum size, unless it is empty.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
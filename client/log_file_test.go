@@ -0,0 +1,76 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRotatingFile tests the `RotatingFile` type.  Checks that the log file is
+// rotated before it exceeds its maximum size, that only the configured number
+// of rotated files is kept, and that an existing log file is appended to.
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRotatingFile")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+
+	rf, err := OpenRotatingFile(logDir, "test.log", 10, 2)
+	if err != nil {
+		t.Fatalf("error when opening the log file: %s", err)
+	}
+	for _, entry := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(entry)); err != nil {
+			t.Fatalf("error when writing to the log file: %s", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("error when closing the log file: %s", err)
+	}
+	if _, err := rf.Write([]byte("closed\n")); err == nil {
+		t.Fatalf("no error when writing to a closed log file")
+	}
+
+	expected := map[string]string{
+		"test.log":   "fourth\n",
+		"test.log.1": "third\n",
+		"test.log.2": "second\n",
+	}
+	checkLogFiles := func() {
+		files, err := ioutil.ReadDir(logDir)
+		if err != nil {
+			t.Fatalf("error when listing the log files: %s", err)
+		}
+		if len(files) != len(expected) {
+			t.Fatalf("incorrect number of log files: expected %d actual %d", len(expected), len(files))
+		}
+		for name, content := range expected {
+			actual, err := ioutil.ReadFile(filepath.Join(logDir, name))
+			if err != nil {
+				t.Fatalf("error when reading the log file %s: %s", name, err)
+			}
+			if string(actual) != content {
+				t.Fatalf("incorrect content of %s: expected %q actual %q", name, content, actual)
+			}
+		}
+	}
+	checkLogFiles()
+
+	rf, err = OpenRotatingFile(logDir, "test.log", 20, 2)
+	if err != nil {
+		t.Fatalf("error when reopening the log file: %s", err)
+	}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("fifth\n")); err != nil {
+		t.Fatalf("error when writing to the log file: %s", err)
+	}
+	expected["test.log"] = "fourth\nfifth\n"
+	checkLogFiles()
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	// LogDebug is the level of the details only useful when debugging, such
+	// as each file indexed or the traces of the RPCs.
+	LogDebug LogLevel = iota
+	// LogInfo is the level of the normal operation of the client, such as the
+	// completion of a scan.
+	LogInfo
+	// LogWarn is the level of the unexpected events the client recovers
+	// from.
+	LogWarn
+	// LogError is the level of the failures of an operation.
+	LogError
+)
+
+// logLevelNames are the names of the log levels, as printed in the entries.
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "DEBUG",
+	LogInfo:  "INFO",
+	LogWarn:  "WARN",
+	LogError: "ERROR",
+}
+
+// String implements the fmt.Stringer interface.
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL%d", int(l))
+}
+
+// ParseLogLevel parses the name of a log level, e.g. "warn", case
+// insensitively.
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogDebug, nil
+	case "info":
+		return LogInfo, nil
+	case "warn", "warning":
+		return LogWarn, nil
+	case "error":
+		return LogError, nil
+	}
+	return 0, fmt.Errorf("unknown log level \"%s\", expected one of debug, info, warn and error", name)
+}
+
+// logOutputSink is an output of a `Logger` along with the lowest level of the
+// entries written to it.
+type logOutputSink struct {
+	w     io.Writer
+	level LogLevel
+}
+
+// Logger writes timestamped log entries to its outputs, each of which only
+// receives the entries at or above its own level.  A nil `Logger` discards all
+// the entries.
+type Logger struct {
+	lock    sync.Mutex      // Serializes the writes of the entries.
+	clock   clockwork.Clock // The clock the entries are timestamped with.
+	outputs []logOutputSink // The outputs of the entries.
+}
+
+// NewLogger creates a `Logger` without any output.
+func NewLogger() *Logger {
+	return newLoggerWithClock(clockwork.NewRealClock())
+}
+
+// newLoggerWithClock creates a `Logger` timestamping the entries with `clock`.
+func newLoggerWithClock(clock clockwork.Clock) *Logger {
+	return &Logger{clock: clock}
+}
+
+// AddOutput has the entries at or above `level` written to `w`.  Should be
+// called before the logger is used.
+func (l *Logger) AddOutput(w io.Writer, level LogLevel) {
+	l.outputs = append(l.outputs, logOutputSink{w: w, level: level})
+}
+
+// Enabled returns whether the entries at `level` are written to any output.
+func (l *Logger) Enabled(level LogLevel) bool {
+	if l == nil {
+		return false
+	}
+	for _, output := range l.outputs {
+		if level >= output.level {
+			return true
+		}
+	}
+	return false
+}
+
+// Logf writes an entry at `level`, formatted from `format` and `args`, to the
+// outputs accepting the level.  The errors when writing the entry are ignored.
+func (l *Logger) Logf(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entry := fmt.Sprintf("%s [%s] %s\n", l.clock.Now().Format("2006-01-02 15:04:05.000"), level, msg)
+	for _, output := range l.outputs {
+		if level >= output.level {
+			io.WriteString(output.w, entry)
+		}
+	}
+}
+
+// Debugf writes an entry at the debug level.
+func (l *Logger) Debugf(format string, args ...interface{}) { l.Logf(LogDebug, format, args...) }
+
+// Infof writes an entry at the info level.
+func (l *Logger) Infof(format string, args ...interface{}) { l.Logf(LogInfo, format, args...) }
+
+// Warnf writes an entry at the warning level.
+func (l *Logger) Warnf(format string, args ...interface{}) { l.Logf(LogWarn, format, args...) }
+
+// Errorf writes an entry at the error level.
+func (l *Logger) Errorf(format string, args ...interface{}) { l.Logf(LogError, format, args...) }
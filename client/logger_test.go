@@ -0,0 +1,60 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// TestLogger tests the `Logger` type.  Checks that the entries are timestamped
+// and written to the outputs accepting their level only, and that a nil logger
+// discards them.
+func TestLogger(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2016, 7, 1, 12, 30, 15, 0, time.UTC))
+	logger := newLoggerWithClock(clock)
+	var console, file bytes.Buffer
+	logger.AddOutput(&console, LogWarn)
+	logger.AddOutput(&file, LogInfo)
+
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warning %s\n", "three")
+	logger.Errorf("error")
+
+	expected := "2016-07-01 12:30:15.000 [WARN] warning three\n2016-07-01 12:30:15.000 [ERROR] error\n"
+	if console.String() != expected {
+		t.Fatalf("incorrect console output: expected %q actual %q", expected, console.String())
+	}
+	expected = "2016-07-01 12:30:15.000 [INFO] info 2\n" + expected
+	if file.String() != expected {
+		t.Fatalf("incorrect file output: expected %q actual %q", expected, file.String())
+	}
+	if logger.Enabled(LogDebug) || !logger.Enabled(LogInfo) {
+		t.Fatalf("incorrect enabled levels")
+	}
+
+	var nilLogger *Logger
+	nilLogger.Errorf("discarded")
+	if nilLogger.Enabled(LogError) {
+		t.Fatalf("nil logger enabled")
+	}
+}
+
+// TestParseLogLevel tests the `ParseLogLevel` function.  Checks that the names
+// are parsed case insensitively, and that an unknown name is rejected.
+func TestParseLogLevel(t *testing.T) {
+	for name, expected := range map[string]LogLevel{"debug": LogDebug, "INFO": LogInfo, "warn": LogWarn, "Warning": LogWarn, "error": LogError} {
+		if actual, err := ParseLogLevel(name); err != nil || actual != expected {
+			t.Fatalf("incorrect level for %q: %s, %v", name, actual, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatalf("no error for an unknown level")
+	}
+}
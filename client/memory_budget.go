@@ -0,0 +1,90 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// MemoryBudget limits the total estimated memory used by the index builds that
+// run concurrently on the clients sharing it.  It behaves like a weighted
+// semaphore: index builds block until enough of the budget is available.
+type MemoryBudget struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	capacity int64 // The total number of bytes in the budget.
+	used     int64 // The number of bytes currently acquired.
+}
+
+// NewMemoryBudget creates a memory budget of `capacity` bytes, to be shared by
+// the clients with `ShareMemoryBudget`.  Returns nil, which sets no limit, if
+// `capacity` is not positive.
+func NewMemoryBudget(capacity int64) *MemoryBudget {
+	if capacity <= 0 {
+		return nil
+	}
+	b := &MemoryBudget{capacity: capacity}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// acquire blocks until `size` bytes of the budget are available and reserves
+// them.  Requests larger than the whole budget are clamped to the capacity, so
+// that they are serialized with all the other index builds instead of blocking
+// forever.  Returns the number of bytes actually reserved, which should later
+// be passed to `release`, or the error of `ctx` if it is done before they are
+// available.
+func (b *MemoryBudget) acquire(ctx context.Context, size int64) (int64, error) {
+	if size > b.capacity {
+		size = b.capacity
+	}
+	// Wakes up the wait below once `ctx` is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.lock.Lock()
+			b.cond.Broadcast()
+			b.lock.Unlock()
+		case <-stop:
+		}
+	}()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for b.used+size > b.capacity {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		b.cond.Wait()
+	}
+	b.used += size
+	return size, nil
+}
+
+// release returns `size` bytes to the budget and wakes up the waiting index
+// builds.
+func (b *MemoryBudget) release(size int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.used -= size
+	b.cond.Broadcast()
+}
+
+// estimateIndexMemory returns a rough upper bound on the number of bytes needed
+// to build the index of a file with `fileLen` bytes with `numKeys` PRFs and a
+// bloom filter of `size` buckets.  Accounts for the map of unique words, which
+// is bounded by the file length, and the sparse bit array, which holds at most
+// `fileLen * numKeys` set bits but never more than the filter size.
+func estimateIndexMemory(fileLen int64, numKeys int, size uint64) int64 {
+	numBits := uint64(fileLen) * uint64(numKeys)
+	if numBits > size {
+		numBits = size
+	}
+	return 4*fileLen + int64(numBits/4)
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestMemoryBudget tests the `acquire` and `release` functions of
+// `MemoryBudget`.  Checks that the total acquired amount never exceeds the
+// capacity, and that requests larger than the capacity are serialized instead
+// of blocking forever.
+func TestMemoryBudget(t *testing.T) {
+	capacity := int64(100)
+	budget := NewMemoryBudget(capacity)
+
+	var used int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(size int64) {
+			defer wg.Done()
+			acquired, err := budget.acquire(context.Background(), size)
+			if err != nil {
+				t.Errorf("error when acquiring %d bytes: %s", size, err)
+				return
+			}
+			if acquired > capacity {
+				t.Errorf("acquired %d bytes with a capacity of %d", acquired, capacity)
+			}
+			if total := atomic.AddInt64(&used, acquired); total > capacity {
+				t.Errorf("memory budget exceeded: %d bytes used with a capacity of %d", total, capacity)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&used, -acquired)
+			budget.release(acquired)
+		}(int64(i * 10))
+	}
+	wg.Wait()
+
+	if budget.used != 0 {
+		t.Fatalf("memory budget not fully released: %d bytes still used", budget.used)
+	}
+}
+
+// TestMemoryBudgetCancel tests the `acquire` function of `MemoryBudget` when
+// its context is canceled while waiting.  Checks that the wait ends with the
+// error of the context without reserving anything.
+func TestMemoryBudgetCancel(t *testing.T) {
+	budget := NewMemoryBudget(100)
+	if _, err := budget.acquire(context.Background(), 100); err != nil {
+		t.Fatalf("error when acquiring the budget: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := budget.acquire(ctx, 10)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("acquisition not waiting for the budget: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("incorrect error once canceled: %v", err)
+	}
+	if budget.used != 100 {
+		t.Fatalf("budget reserved by a canceled acquisition: %d bytes used", budget.used)
+	}
+}
+
+// TestEstimateIndexMemory tests the `estimateIndexMemory` function.  Checks
+// that the estimate grows with the file length and is bounded by the filter
+// size for the bit array.
+func TestEstimateIndexMemory(t *testing.T) {
+	small := estimateIndexMemory(100, 20, 1000000)
+	large := estimateIndexMemory(10000, 20, 1000000)
+	if small >= large {
+		t.Fatalf("estimate not increasing with the file length: %d >= %d", small, large)
+	}
+	if estimate := estimateIndexMemory(1000000, 20, 1000); estimate != 4*1000000+1000/4 {
+		t.Fatalf("bit array estimate not bounded by the filter size: %d", estimate)
+	}
+}
+
+// TestShareMemoryBudget tests the `ShareMemoryBudget` function.  Checks that
+// the index builds of two clients wait for the budget they share, and complete
+// once it is available.
+func TestShareMemoryBudget(t *testing.T) {
+	budget := NewMemoryBudget(1 << 20)
+	server := newMemoryServerClient()
+	var clients []*Client
+	var pathnames []string
+	for i := 0; i < 2; i++ {
+		cli, dir := startTestClientWithServer(t, "", server)
+		defer os.RemoveAll(dir)
+		cli.ShareMemoryBudget(budget)
+		pathname := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(pathname, []byte("budgeted"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		clients = append(clients, cli)
+		pathnames = append(pathnames, pathname)
+	}
+
+	// The whole budget is taken, e.g. by the builds of a third client.
+	acquired, err := budget.acquire(context.Background(), budget.capacity)
+	if err != nil {
+		t.Fatalf("error when acquiring the budget: %s", err)
+	}
+	done := make(chan error, len(clients))
+	for i, cli := range clients {
+		go func(cli *Client, pathname string) {
+			done <- cli.AddFile(context.Background(), filepath.Dir(pathname), pathname)
+		}(cli, pathnames[i])
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("index build not waiting for the shared budget: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	budget.release(acquired)
+	for range clients {
+		if err := <-done; err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	if budget.used != 0 {
+		t.Fatalf("memory budget not fully released: %d bytes still used", budget.used)
+	}
+}
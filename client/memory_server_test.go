@@ -0,0 +1,594 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// indexStore is the storage backend of the marshaled indexes of a
+// `memoryServerClient`.  Accesses are serialized by the server.
+type indexStore interface {
+	put(tlfID sserver1.FolderID, docID sserver1.DocumentID, secIndex []byte) error
+	get(tlfID sserver1.FolderID, docID sserver1.DocumentID) ([]byte, bool, error)
+	remove(tlfID sserver1.FolderID, docID sserver1.DocumentID) error
+	list(tlfID sserver1.FolderID) ([]sserver1.DocumentID, error)
+}
+
+// memIndexStore is an `indexStore` keeping the indexes in memory.
+type memIndexStore map[sserver1.FolderID]map[sserver1.DocumentID][]byte
+
+func (m memIndexStore) put(tlfID sserver1.FolderID, docID sserver1.DocumentID, secIndex []byte) error {
+	if m[tlfID] == nil {
+		m[tlfID] = make(map[sserver1.DocumentID][]byte)
+	}
+	m[tlfID][docID] = secIndex
+	return nil
+}
+
+func (m memIndexStore) get(tlfID sserver1.FolderID, docID sserver1.DocumentID) ([]byte, bool, error) {
+	secIndex, ok := m[tlfID][docID]
+	return secIndex, ok, nil
+}
+
+func (m memIndexStore) remove(tlfID sserver1.FolderID, docID sserver1.DocumentID) error {
+	delete(m[tlfID], docID)
+	return nil
+}
+
+func (m memIndexStore) list(tlfID sserver1.FolderID) ([]sserver1.DocumentID, error) {
+	docIDs := make([]sserver1.DocumentID, 0, len(m[tlfID]))
+	for docID := range m[tlfID] {
+		docIDs = append(docIDs, docID)
+	}
+	return docIDs, nil
+}
+
+// fsIndexStore is an `indexStore` keeping each index in its own file under a
+// directory per TLF.
+type fsIndexStore string
+
+// indexPath returns the path of the file storing the index of `docID`.
+func (f fsIndexStore) indexPath(tlfID sserver1.FolderID, docID sserver1.DocumentID) string {
+	return filepath.Join(string(f), string(tlfID), hex.EncodeToString([]byte(docID)))
+}
+
+func (f fsIndexStore) put(tlfID sserver1.FolderID, docID sserver1.DocumentID, secIndex []byte) error {
+	if err := os.MkdirAll(filepath.Join(string(f), string(tlfID)), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.indexPath(tlfID, docID), secIndex, 0666)
+}
+
+func (f fsIndexStore) get(tlfID sserver1.FolderID, docID sserver1.DocumentID) ([]byte, bool, error) {
+	secIndex, err := ioutil.ReadFile(f.indexPath(tlfID, docID))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	return secIndex, err == nil, err
+}
+
+func (f fsIndexStore) remove(tlfID sserver1.FolderID, docID sserver1.DocumentID) error {
+	if err := os.Remove(f.indexPath(tlfID, docID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f fsIndexStore) list(tlfID sserver1.FolderID) ([]sserver1.DocumentID, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(string(f), string(tlfID)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	docIDs := make([]sserver1.DocumentID, 0, len(infos))
+	for _, info := range infos {
+		docID, err := hex.DecodeString(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		docIDs = append(docIDs, sserver1.DocumentID(docID))
+	}
+	return docIDs, nil
+}
+
+// memoryServerClient implements a SearchServerInterface that stores the
+// indexes and evaluates the searches the same way the real search server does.
+// Unlike `FakeServerClient`, the search results are computed from the stored
+// indexes.  The indexes are kept in memory unless another `indexStore` is
+// provided.
+type memoryServerClient struct {
+	lock       sync.Mutex
+	tlfInfos   map[sserver1.FolderID]sserver1.TlfInfo                   // The registered TLFs.
+	indexes    indexStore                                               // The marshaled indexes stored for each TLF.
+	writes     map[sserver1.FolderID]map[sserver1.DocumentID]int        // The number of times each index has been written.
+	written    map[sserver1.FolderID]map[sserver1.DocumentID]time.Time  // The time each index was last written.
+	revisions  map[sserver1.FolderID]map[sserver1.DocumentID]int64      // The revision of each index.
+	journal    map[sserver1.FolderID][]sserver1.Change                  // The latest changes of each TLF, oldest first.
+	seqs       map[sserver1.FolderID]int64                              // The sequence number of the latest change of each TLF.
+	journalLen int                                                      // The number of changes kept in the journal of each TLF.
+	claims     map[sserver1.FolderID]map[sserver1.DocumentID]indexClaim // The claims on the uploads of the indexes.
+	summaries  map[sserver1.FolderID]*libsearch.TlfSummary              // The summary of each TLF.
+	summarized map[sserver1.FolderID]map[sserver1.DocumentID]bool       // The documents that contributed to the summary since their last write.
+	budget     int                                                      // The number of index writes per second shared among the backfills.  No limit if 0.
+	backfills  map[backfill]time.Time                                   // The expiry of the grant of each backfill in progress.
+}
+
+// backfill identifies the backfill of a TLF by a client.
+type backfill struct {
+	tlfID    sserver1.FolderID
+	claimant string
+}
+
+// backfillTTL is the duration of the grants of the backfills.
+const backfillTTL = 30 * time.Second
+
+// indexClaim is the claim of a client on the upload of an index.
+type indexClaim struct {
+	claimant string    // The client holding the claim.
+	expiry   time.Time // The time the claim expires.
+}
+
+// newMemoryServerClient creates an empty `memoryServerClient`.
+func newMemoryServerClient() *memoryServerClient {
+	return newMemoryServerClientWithStore(make(memIndexStore))
+}
+
+// newMemoryServerClientWithStore creates an empty `memoryServerClient` storing
+// the indexes in `store`.
+func newMemoryServerClientWithStore(store indexStore) *memoryServerClient {
+	return &memoryServerClient{
+		tlfInfos:   make(map[sserver1.FolderID]sserver1.TlfInfo),
+		indexes:    store,
+		writes:     make(map[sserver1.FolderID]map[sserver1.DocumentID]int),
+		written:    make(map[sserver1.FolderID]map[sserver1.DocumentID]time.Time),
+		revisions:  make(map[sserver1.FolderID]map[sserver1.DocumentID]int64),
+		journal:    make(map[sserver1.FolderID][]sserver1.Change),
+		seqs:       make(map[sserver1.FolderID]int64),
+		journalLen: 1024,
+		claims:     make(map[sserver1.FolderID]map[sserver1.DocumentID]indexClaim),
+		summaries:  make(map[sserver1.FolderID]*libsearch.TlfSummary),
+		summarized: make(map[sserver1.FolderID]map[sserver1.DocumentID]bool),
+		backfills:  make(map[backfill]time.Time),
+	}
+}
+
+// recordChange appends `change` to the journal of `tlfID`, dropping the oldest
+// changes if the journal is full.  Must be called with the lock held.
+func (s *memoryServerClient) recordChange(tlfID sserver1.FolderID, change sserver1.Change) {
+	s.seqs[tlfID]++
+	change.Seq = s.seqs[tlfID]
+	s.journal[tlfID] = append(s.journal[tlfID], change)
+	if excess := len(s.journal[tlfID]) - s.journalLen; excess > 0 {
+		s.journal[tlfID] = s.journal[tlfID][excess:]
+	}
+}
+
+// docIDs returns the sorted document IDs of the indexes stored for `tlfID`.
+func (s *memoryServerClient) docIDs(tlfID sserver1.FolderID) []sserver1.DocumentID {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	docIDs, _ := s.indexes.list(tlfID)
+	sort.Slice(docIDs, func(i, j int) bool { return docIDs[i] < docIDs[j] })
+	return docIDs
+}
+
+func (s *memoryServerClient) WriteIndex(_ context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tlfInfos[arg.TlfID]; !ok {
+		return sserver1.WriteResult{}, errors.New("TLF not registered")
+	}
+	if err := s.indexes.put(arg.TlfID, arg.DocID, arg.SecureIndex); err != nil {
+		return sserver1.WriteResult{}, err
+	}
+	s.writes[arg.TlfID][arg.DocID]++
+	s.written[arg.TlfID][arg.DocID] = time.Now()
+	delete(s.summarized[arg.TlfID], arg.DocID)
+	revision := s.revisions[arg.TlfID][arg.DocID]
+	s.revisions[arg.TlfID][arg.DocID] = revision + 1
+	s.recordChange(arg.TlfID, sserver1.Change{Type: sserver1.ChangeType_WRITE, DocID: arg.DocID})
+	return sserver1.WriteResult{Revision: revision + 1, Conflict: arg.BaseRevision != 0 && arg.BaseRevision != revision}, nil
+}
+
+func (s *memoryServerClient) RenameIndex(_ context.Context, arg sserver1.RenameIndexArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	secIndex, ok, err := s.indexes.get(arg.TlfID, arg.Orig)
+	if err != nil || !ok {
+		return err
+	}
+	if err := s.indexes.remove(arg.TlfID, arg.Orig); err != nil {
+		return err
+	}
+	if written, ok := s.written[arg.TlfID][arg.Orig]; ok {
+		delete(s.written[arg.TlfID], arg.Orig)
+		s.written[arg.TlfID][arg.Curr] = written
+	}
+	if revision, ok := s.revisions[arg.TlfID][arg.Orig]; ok {
+		delete(s.revisions[arg.TlfID], arg.Orig)
+		s.revisions[arg.TlfID][arg.Curr] = revision
+	}
+	if s.summarized[arg.TlfID][arg.Orig] {
+		delete(s.summarized[arg.TlfID], arg.Orig)
+		s.summarized[arg.TlfID][arg.Curr] = true
+	}
+	if err := s.indexes.put(arg.TlfID, arg.Curr, secIndex); err != nil {
+		return err
+	}
+	s.recordChange(arg.TlfID, sserver1.Change{Type: sserver1.ChangeType_RENAME, DocID: arg.Curr, OrigDocID: arg.Orig})
+	return nil
+}
+
+func (s *memoryServerClient) DeleteIndex(_ context.Context, arg sserver1.DeleteIndexArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.written[arg.TlfID], arg.DocID)
+	delete(s.revisions[arg.TlfID], arg.DocID)
+	delete(s.summarized[arg.TlfID], arg.DocID)
+	if err := s.indexes.remove(arg.TlfID, arg.DocID); err != nil {
+		return err
+	}
+	s.recordChange(arg.TlfID, sserver1.Change{Type: sserver1.ChangeType_DELETE, DocID: arg.DocID})
+	return nil
+}
+
+func (s *memoryServerClient) GetKeyGens(_ context.Context, tlfID sserver1.FolderID) ([]int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	docIDs, err := s.indexes.list(tlfID)
+	if err != nil {
+		return nil, err
+	}
+	keyGenSet := make(map[int]bool)
+	for _, docID := range docIDs {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		keyGenSet[keyGen] = true
+	}
+	keyGens := make([]int, 0, len(keyGenSet))
+	for keyGen := range keyGenSet {
+		keyGens = append(keyGens, keyGen)
+	}
+	sort.Ints(keyGens)
+	return keyGens, nil
+}
+
+// searchConjunction returns the documents of `tlfID` whose indexes contain the
+// trapdoors of all the terms in `trapdoorMaps`, padded to the next multiple of
+// `resultBucketSize` if positive.  The caller must hold the lock.
+func (s *memoryServerClient) searchConjunction(tlfID sserver1.FolderID, trapdoorMaps []map[string]sserver1.Trapdoor, resultBucketSize int) ([]sserver1.DocumentID, error) {
+	if len(trapdoorMaps) == 0 {
+		return nil, errors.New("no trapdoors to search for")
+	}
+	docIDs, err := s.indexes.list(tlfID)
+	if err != nil {
+		return nil, err
+	}
+	var result []sserver1.DocumentID
+	for _, docID := range docIDs {
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := trapdoorMaps[0][strconv.Itoa(keyGen)]; !ok {
+			continue
+		}
+		secIndexBytes, _, err := s.indexes.get(tlfID, docID)
+		if err != nil {
+			return nil, err
+		}
+		var secIndex libsearch.SecureIndex
+		if err := secIndex.UnmarshalBinary(secIndexBytes); err != nil {
+			return nil, err
+		}
+		matches := true
+		for _, trapdoors := range trapdoorMaps {
+			trapdoor, ok := trapdoors[strconv.Itoa(keyGen)]
+			if !ok || !secIndex.ContainsTrapdoors(trapdoor.Codeword) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			result = append(result, docID)
+		}
+	}
+	return padResults(result, trapdoorMaps[0], resultBucketSize)
+}
+
+// padResults pads `result` to the next multiple of `resultBucketSize` with
+// dummies taking the key generations of `trapdoors`, if `resultBucketSize` is
+// positive.
+func padResults(result []sserver1.DocumentID, trapdoors map[string]sserver1.Trapdoor, resultBucketSize int) ([]sserver1.DocumentID, error) {
+	if resultBucketSize <= 0 {
+		return result, nil
+	}
+	keyGens := make([]int, 0, len(trapdoors))
+	for keyGen := range trapdoors {
+		if k, err := strconv.Atoi(keyGen); err == nil {
+			keyGens = append(keyGens, k)
+		}
+	}
+	return libsearch.PadDocIDs(result, resultBucketSize, keyGens)
+}
+
+func (s *memoryServerClient) SearchWord(_ context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.searchConjunction(arg.TlfID, []map[string]sserver1.Trapdoor{arg.Trapdoors}, arg.ResultBucketSize)
+}
+
+func (s *memoryServerClient) SearchWords(ctx context.Context, arg sserver1.SearchWordsArg) ([][]sserver1.DocumentID, error) {
+	results := make([][]sserver1.DocumentID, len(arg.Trapdoors))
+	for i, trapdoors := range arg.Trapdoors {
+		result, err := s.SearchWord(ctx, sserver1.SearchWordArg{TlfID: arg.TlfID, Trapdoors: trapdoors, ResultBucketSize: arg.ResultBucketSize})
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+func (s *memoryServerClient) SearchConjunction(_ context.Context, arg sserver1.SearchConjunctionArg) ([]sserver1.DocumentID, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.searchConjunction(arg.TlfID, arg.Trapdoors, arg.ResultBucketSize)
+}
+
+func (s *memoryServerClient) GetChanges(_ context.Context, arg sserver1.GetChangesArg) (sserver1.ChangeSet, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	journal := s.journal[arg.TlfID]
+	changeSet := sserver1.ChangeSet{LatestSeq: s.seqs[arg.TlfID]}
+	if len(journal) > 0 && journal[0].Seq > arg.SinceSeq+1 {
+		changeSet.Truncated = true
+	}
+	for _, change := range journal {
+		if change.Seq > arg.SinceSeq {
+			changeSet.Changes = append(changeSet.Changes, change)
+		}
+	}
+	return changeSet, nil
+}
+
+func (s *memoryServerClient) ClaimIndex(_ context.Context, arg sserver1.ClaimIndexArg) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if claim, ok := s.claims[arg.TlfID][arg.DocID]; ok && claim.claimant != arg.Claimant && now.Before(claim.expiry) {
+		return false, nil
+	}
+	if s.claims[arg.TlfID] == nil {
+		s.claims[arg.TlfID] = make(map[sserver1.DocumentID]indexClaim)
+	}
+	s.claims[arg.TlfID][arg.DocID] = indexClaim{claimant: arg.Claimant, expiry: now.Add(time.Duration(arg.Ttl) * time.Millisecond)}
+	return true, nil
+}
+
+func (s *memoryServerClient) GetDocumentInfo(_ context.Context, arg sserver1.GetDocumentInfoArg) ([]sserver1.DocumentInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var infos []sserver1.DocumentInfo
+	for _, docID := range arg.DocIDs {
+		secIndex, ok, err := s.indexes.get(arg.TlfID, docID)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		keyGen, err := libsearch.GetKeyGenFromDocID(docID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, sserver1.DocumentInfo{
+			DocID:         docID,
+			IndexSize:     int64(len(secIndex)),
+			LastWriteTime: s.written[arg.TlfID][docID].UnixNano() / int64(time.Millisecond),
+			KeyGen:        keyGen,
+			Revision:      s.revisions[arg.TlfID][docID],
+		})
+	}
+	return infos, nil
+}
+
+func (s *memoryServerClient) RegisterTlfIfNotExists(_ context.Context, arg sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if tlfInfo, ok := s.tlfInfos[arg.TlfID]; ok {
+		return tlfInfo, nil
+	}
+	if err := libsearch.ValidateLenSalt(arg.LenSalt); err != nil {
+		return sserver1.TlfInfo{}, err
+	}
+	numKeys := libsearch.ComputeNumKeys(arg.FpRate)
+	size := int64(math.Ceil(float64(arg.NumUniqWords) * float64(numKeys) / math.Log(2)))
+	var tlfInfo sserver1.TlfInfo
+	if len(arg.EncryptedSalts) > 0 {
+		// The server only relays the salts generated by the client.
+		tlfInfo = sserver1.TlfInfo{Size: size, EncryptedSalts: arg.EncryptedSalts, Fingerprint: libsearch.ComputeSealedTlfFingerprint(arg.EncryptedSalts, uint64(size))}
+	} else {
+		salts, err := libsearch.GenerateSalts(numKeys, arg.LenSalt)
+		if err != nil {
+			return sserver1.TlfInfo{}, err
+		}
+		tlfInfo = sserver1.TlfInfo{Salts: salts, Size: size, Fingerprint: libsearch.ComputeTlfFingerprint(salts, uint64(size))}
+	}
+	tlfInfo.IndexType = arg.IndexType
+	tlfInfo.AnalysisFingerprint = arg.AnalysisFingerprint
+	tlfInfo.CodewordMapping = arg.CodewordMapping
+	s.tlfInfos[arg.TlfID] = tlfInfo
+	s.writes[arg.TlfID] = make(map[sserver1.DocumentID]int)
+	s.written[arg.TlfID] = make(map[sserver1.DocumentID]time.Time)
+	s.revisions[arg.TlfID] = make(map[sserver1.DocumentID]int64)
+	s.summaries[arg.TlfID] = libsearch.NewTlfSummary()
+	s.summarized[arg.TlfID] = make(map[sserver1.DocumentID]bool)
+	return tlfInfo, nil
+}
+
+func (s *memoryServerClient) GetIndexStats(_ context.Context, tlfID sserver1.FolderID) (sserver1.TlfIndexStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	docIDs, err := s.indexes.list(tlfID)
+	if err != nil {
+		return sserver1.TlfIndexStats{}, err
+	}
+	indexStats := make([]libsearch.IndexStats, 0, len(docIDs))
+	for _, docID := range docIDs {
+		secIndexBytes, ok, err := s.indexes.get(tlfID, docID)
+		if err != nil {
+			return sserver1.TlfIndexStats{}, err
+		} else if !ok {
+			continue
+		}
+		stats, err := libsearch.ComputeIndexStats(secIndexBytes)
+		if err != nil {
+			return sserver1.TlfIndexStats{}, err
+		}
+		indexStats = append(indexStats, stats)
+	}
+	return libsearch.AggregateIndexStats(indexStats), nil
+}
+
+func (s *memoryServerClient) SearchWordPage(_ context.Context, arg sserver1.SearchWordPageArg) (sserver1.SearchPage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result, err := s.searchConjunction(arg.TlfID, []map[string]sserver1.Trapdoor{arg.Trapdoors}, 0)
+	if err != nil {
+		return sserver1.SearchPage{}, err
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	start := sort.Search(len(result), func(i int) bool { return result[i] > arg.After })
+	end := len(result)
+	if arg.PageSize > 0 && start+arg.PageSize < end {
+		end = start + arg.PageSize
+	}
+	page := sserver1.SearchPage{}
+	if end < len(result) {
+		page.Next = result[end-1]
+	}
+	page.DocIDs, err = padResults(result[start:end], arg.Trapdoors, arg.ResultBucketSize)
+	return page, err
+}
+
+func (s *memoryServerClient) MergeTlfSummary(_ context.Context, arg sserver1.MergeTlfSummaryArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tlfInfos[arg.TlfID]; !ok {
+		return errors.New("TLF not registered")
+	}
+	if _, ok, err := s.indexes.get(arg.TlfID, arg.DocID); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("no index for the document")
+	}
+	contribution := new(libsearch.TlfSummary)
+	if err := contribution.UnmarshalBinary(arg.Filter); err != nil {
+		return err
+	}
+	s.summaries[arg.TlfID].Merge(contribution)
+	s.summarized[arg.TlfID][arg.DocID] = true
+	return nil
+}
+
+func (s *memoryServerClient) GetTlfSummary(_ context.Context, tlfID sserver1.FolderID) (sserver1.TlfSummary, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tlfInfos[tlfID]; !ok {
+		return sserver1.TlfSummary{}, errors.New("TLF not registered")
+	}
+	filter, err := s.summaries[tlfID].MarshalBinary()
+	if err != nil {
+		return sserver1.TlfSummary{}, err
+	}
+	docIDs, err := s.indexes.list(tlfID)
+	if err != nil {
+		return sserver1.TlfSummary{}, err
+	}
+	complete := true
+	for _, docID := range docIDs {
+		complete = complete && s.summarized[tlfID][docID]
+	}
+	return sserver1.TlfSummary{Filter: filter, Complete: complete}, nil
+}
+
+func (s *memoryServerClient) DropTlf(_ context.Context, tlfID sserver1.FolderID) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	docIDs, err := s.indexes.list(tlfID)
+	if err != nil {
+		return err
+	}
+	for _, docID := range docIDs {
+		if err := s.indexes.remove(tlfID, docID); err != nil {
+			return err
+		}
+		s.recordChange(tlfID, sserver1.Change{Type: sserver1.ChangeType_DELETE, DocID: docID})
+	}
+	delete(s.tlfInfos, tlfID)
+	delete(s.writes, tlfID)
+	delete(s.written, tlfID)
+	delete(s.revisions, tlfID)
+	delete(s.claims, tlfID)
+	delete(s.summaries, tlfID)
+	delete(s.summarized, tlfID)
+	return nil
+}
+
+func (s *memoryServerClient) BeginBackfill(_ context.Context, arg sserver1.BeginBackfillArg) (sserver1.BackfillGrant, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for b, expiry := range s.backfills {
+		if !now.Before(expiry) {
+			delete(s.backfills, b)
+		}
+	}
+	s.backfills[backfill{arg.TlfID, arg.Claimant}] = now.Add(backfillTTL)
+	grant := sserver1.BackfillGrant{Ttl: int64(backfillTTL / time.Millisecond)}
+	if s.budget > 0 {
+		grant.WritesPerSec = s.budget / len(s.backfills)
+		if grant.WritesPerSec == 0 {
+			grant.WritesPerSec = 1
+		}
+	}
+	return grant, nil
+}
+
+func (s *memoryServerClient) EndBackfill(_ context.Context, arg sserver1.EndBackfillArg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.backfills, backfill{arg.TlfID, arg.Claimant})
+	return nil
+}
+
+func (s *memoryServerClient) WriteIndexes(ctx context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteResult, error) {
+	results := make([]sserver1.WriteResult, 0, len(arg.Writes))
+	for _, write := range arg.Writes {
+		res, err := s.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: arg.TlfID, SecureIndex: write.SecureIndex, DocID: write.DocID, BaseRevision: write.BaseRevision})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	"golang.org/x/net/context"
+)
+
+var (
+	// scanDurationBuckets are the upper bounds, in seconds, of the buckets of
+	// the histogram of the durations of the scans.
+	scanDurationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+	// searchLatencyBuckets are the upper bounds, in seconds, of the buckets of
+	// the histogram of the latencies of the searches.
+	searchLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// histogram is a cumulative histogram of observations, in the Prometheus way.
+type histogram struct {
+	bounds []float64 // The upper bounds of the buckets, in increasing order.
+	counts []uint64  // The number of observations in each bucket, excluding the previous ones.
+	sum    float64   // The sum of the observations.
+	count  uint64    // The number of observations.
+}
+
+// newHistogram creates an empty histogram with the buckets of `bounds`.
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// observe adds the observation `v` to the histogram.
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// merge adds the observations of `other`, which has the same buckets, to the
+// histogram.
+func (h *histogram) merge(other *histogram) {
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.sum += other.sum
+	h.count += other.count
+}
+
+// clientMetrics are the counters of a client exported by `WriteMetrics`, on top
+// of the bytes of indexes uploaded counted by the client itself.
+type clientMetrics struct {
+	lock         sync.Mutex
+	filesIndexed map[string]int64      // The number of files indexed since the client started, keyed by directory.
+	scans        map[string]*histogram // The durations of the scans that succeeded, keyed by directory.
+	rpcErrors    map[string]int64      // The number of RPCs to the search server that failed, keyed by method.
+	searches     *histogram            // The latencies of the searches on the search server.
+}
+
+// newClientMetrics creates the metrics of a new client.
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		filesIndexed: make(map[string]int64),
+		scans:        make(map[string]*histogram),
+		rpcErrors:    make(map[string]int64),
+		searches:     newHistogram(searchLatencyBuckets),
+	}
+}
+
+// fileIndexed counts a file of `directory` indexed.
+func (m *clientMetrics) fileIndexed(directory string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.filesIndexed[directory]++
+}
+
+// scanned records a scan of `directory` that succeeded in `elapsed`.
+func (m *clientMetrics) scanned(directory string, elapsed time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.scans[directory]
+	if !ok {
+		h = newHistogram(scanDurationBuckets)
+		m.scans[directory] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// searched records a search that started at `start` and just returned.  Meant
+// to be deferred.
+func (m *clientMetrics) searched(start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.searches.observe(elapsed)
+}
+
+// forget drops the metrics of `directory`, once removed from the client.
+func (m *clientMetrics) forget(directory string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.filesIndexed, directory)
+	delete(m.scans, directory)
+}
+
+// meteredClient is an RPC client counting the calls that fail in the metrics
+// of a client.
+type meteredClient struct {
+	rpc.GenericClient
+	metrics *clientMetrics
+}
+
+// Call implements the GenericClient interface.
+func (m meteredClient) Call(ctx context.Context, method string, arg interface{}, res interface{}) error {
+	err := m.GenericClient.Call(ctx, method, arg, res)
+	if err != nil {
+		m.metrics.lock.Lock()
+		m.metrics.rpcErrors[method]++
+		m.metrics.lock.Unlock()
+	}
+	return err
+}
+
+// metricsWriter writes the metrics in the Prometheus text format, keeping the
+// first error.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// family writes the header of the metric `name` of type `kind`.
+func (m *metricsWriter) family(name, kind, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample of the metric `name` with the `labels`, given as
+// pairs of names and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.printf("%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// histogram writes the samples of the histogram `h` of the metric `name` with
+// the `labels`.
+func (m *metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	m.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", h.sum, labels...)
+	m.sample(name+"_count", float64(h.count), labels...)
+}
+
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+// sortedKeys returns the keys of `m` in increasing order.
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMetrics writes the metrics of the `clients` to `w` in the Prometheus
+// text format: the files indexed, the bytes of indexes uploaded and the
+// durations of the scans of each directory, and the RPCs to the search
+// servers that failed and the latencies of the searches, summed over the
+// clients.
+func WriteMetrics(w io.Writer, clients []*Client) error {
+	filesIndexed := make(map[string]int64)
+	uploadedBytes := make(map[string]int64)
+	scans := make(map[string]*histogram)
+	rpcErrors := make(map[string]int64)
+	searches := newHistogram(searchLatencyBuckets)
+	for _, c := range clients {
+		c.progressLock.Lock()
+		for directory, n := range c.uploadedBytes {
+			uploadedBytes[directory] += n
+		}
+		c.progressLock.Unlock()
+
+		c.metrics.lock.Lock()
+		for directory, n := range c.metrics.filesIndexed {
+			filesIndexed[directory] += n
+		}
+		for directory, h := range c.metrics.scans {
+			if _, ok := scans[directory]; !ok {
+				scans[directory] = newHistogram(scanDurationBuckets)
+			}
+			scans[directory].merge(h)
+		}
+		for method, n := range c.metrics.rpcErrors {
+			rpcErrors[method] += n
+		}
+		searches.merge(c.metrics.searches)
+		c.metrics.lock.Unlock()
+	}
+
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+	m.family("kbfs_search_files_indexed_total", "counter", "The number of files indexed since the client started.")
+	for _, directory := range sortedKeys(filesIndexed) {
+		m.sample("kbfs_search_files_indexed_total", float64(filesIndexed[directory]), "directory", directory)
+	}
+	m.family("kbfs_search_index_bytes_uploaded_total", "counter", "The number of bytes of indexes uploaded since the client started, dummy indexes included.")
+	for _, directory := range sortedKeys(uploadedBytes) {
+		m.sample("kbfs_search_index_bytes_uploaded_total", float64(uploadedBytes[directory]), "directory", directory)
+	}
+	m.family("kbfs_search_scan_duration_seconds", "histogram", "The durations of the scans that succeeded.")
+	directories := make([]string, 0, len(scans))
+	for directory := range scans {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+	for _, directory := range directories {
+		m.histogram("kbfs_search_scan_duration_seconds", scans[directory], "directory", directory)
+	}
+	m.family("kbfs_search_rpc_errors_total", "counter", "The number of RPCs to the search servers that failed.")
+	for _, method := range sortedKeys(rpcErrors) {
+		m.sample("kbfs_search_rpc_errors_total", float64(rpcErrors[method]), "method", method)
+	}
+	m.family("kbfs_search_search_duration_seconds", "histogram", "The latencies of the searches on the search servers.")
+	m.histogram("kbfs_search_search_duration_seconds", searches)
+	if m.err != nil {
+		return m.err
+	}
+	return m.w.Flush()
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// failingGenericClient is an RPC client whose calls all fail.
+type failingGenericClient struct{}
+
+// Call implements the GenericClient interface.
+func (failingGenericClient) Call(_ context.Context, _ string, _ interface{}, _ interface{}) error {
+	return errors.New("call failed")
+}
+
+// Notify implements the GenericClient interface.
+func (failingGenericClient) Notify(_ context.Context, _ string, _ interface{}) error {
+	return nil
+}
+
+// TestWriteMetrics tests the `WriteMetrics` function.  Checks that the files
+// indexed by a scan, the scan itself, the searches and the failed RPCs are
+// reported.
+func TestWriteMetrics(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when scanning the directory: %s", report.Err)
+	}
+	if _, err := client.SearchWord(context.Background(), dir, "content"); err != nil {
+		t.Fatalf("error when searching: %s", err)
+	}
+	metered := meteredClient{GenericClient: failingGenericClient{}, metrics: client.metrics}
+	metered.Call(context.Background(), "test.method", nil, nil)
+
+	var out bytes.Buffer
+	if err := WriteMetrics(&out, []*Client{client}); err != nil {
+		t.Fatalf("error when writing the metrics: %s", err)
+	}
+	directory := strconv.Quote(dir)
+	for _, expected := range []string{
+		"kbfs_search_files_indexed_total{directory=" + directory + "} 3\n",
+		"kbfs_search_scan_duration_seconds_count{directory=" + directory + "} 1\n",
+		"kbfs_search_rpc_errors_total{method=\"test.method\"} 1\n",
+		"kbfs_search_search_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"kbfs_search_search_duration_seconds_count 1\n",
+		"# TYPE kbfs_search_index_bytes_uploaded_total counter\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("missing %q in the metrics:\n%s", expected, out.String())
+		}
+	}
+}
+
+// TestHistogram tests the `histogram` type.  Checks that the observations land
+// in the right buckets, and that merged histograms add up.
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.observe(v)
+	}
+	if h.counts[0] != 2 || h.counts[1] != 1 || h.count != 4 || h.sum != 106.5 {
+		t.Fatalf("incorrect histogram: %+v", h)
+	}
+	h.merge(h)
+	if h.counts[0] != 4 || h.count != 8 {
+		t.Fatalf("incorrect merged histogram: %+v", h)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+const (
+	// schemaFile is the name of the file in a directory storing the schema
+	// its indexes have been built with.
+	schemaFile = ".search_kbfs_schema"
+	// migrationFile is the name of the file in a directory storing the
+	// progress of the migration of its indexes to a new schema, so that a
+	// migration interrupted by a shutdown or a crash resumes where it
+	// stopped.
+	migrationFile = ".search_kbfs_migration"
+	// migrationBatchSize is the number of files whose indexes are rebuilt
+	// between two saves of the progress of a migration.
+	migrationBatchSize = 64
+)
+
+// ErrDocIDSchemeChanged is returned by `MigrateIndexes` for a directory whose
+// indexes have been written under the document IDs of another scheme, which
+// cannot be migrated in place as the previous indexes can no longer be found
+// by their pathnames.  The directory has to be reset with its indexes dropped
+// instead, see `ResetDirectory`.
+var ErrDocIDSchemeChanged = errors.New("the indexes have been written under document IDs of another scheme, reset the directory with its indexes dropped to migrate them")
+
+// IndexSchema identifies the format the indexes of a directory are built in.
+type IndexSchema struct {
+	Mapping     libsearch.CodewordMapping `json:"mapping"`     // The mapping of the words to the buckets of the indexes.
+	Analysis    []byte                    `json:"analysis"`    // The fingerprint of the way the words are analyzed, see `libsearch.ComputeAnalysisFingerprint`.
+	DocIDScheme int                       `json:"docIDScheme"` // The scheme of the document IDs the indexes are written under.
+}
+
+// currentSchema returns the schema the indexes of the directory of `dirInfo`
+// are built in by this client, with the mapping recorded for its TLF.
+func currentSchema(dirInfo *DirectoryInfo) IndexSchema {
+	return IndexSchema{Mapping: indexMapping(dirInfo.tlfInfo), Analysis: libsearch.ComputeAnalysisFingerprint(), DocIDScheme: libsearch.DocIDScheme}
+}
+
+// equal returns whether `s` and `other` are the same schema.
+func (s IndexSchema) equal(other IndexSchema) bool {
+	return s.Mapping == other.Mapping && bytes.Equal(s.Analysis, other.Analysis) && s.DocIDScheme == other.DocIDScheme
+}
+
+// readSchema reads the schema the indexes of the directory of `dirInfo` have
+// been built with.  A directory indexed before the schemas were recorded is
+// assumed to be built with the current schema, which is recorded then.
+func readSchema(dirInfo *DirectoryInfo) (IndexSchema, error) {
+	schemaJSON, err := ioutil.ReadFile(filepath.Join(dirInfo.absDir, schemaFile))
+	if os.IsNotExist(err) {
+		schema := currentSchema(dirInfo)
+		return schema, writeSchema(dirInfo.absDir, schema)
+	} else if err != nil {
+		return IndexSchema{}, err
+	}
+	var schema IndexSchema
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		return IndexSchema{}, err
+	}
+	return schema, nil
+}
+
+// writeSchema records `schema` as the schema the indexes of `absDir` have been
+// built with.
+func writeSchema(absDir string, schema IndexSchema) error {
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(filepath.Join(absDir, schemaFile), schemaJSON)
+}
+
+// migrationState is the progress of the migration of the indexes of a
+// directory, as stored in its migration file.
+type migrationState struct {
+	Target   IndexSchema     `json:"target"`   // The schema the indexes are migrated to.
+	Migrated map[string]bool `json:"migrated"` // The paths, relative to the directory, of the files whose indexes have been rebuilt.
+}
+
+// readMigrationState reads the progress of the migration of the indexes of
+// `absDir` to `target`.  A migration to another schema starts over.
+func readMigrationState(absDir string, target IndexSchema) (*migrationState, error) {
+	state := &migrationState{Target: target, Migrated: make(map[string]bool)}
+	stateJSON, err := ioutil.ReadFile(filepath.Join(absDir, migrationFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved migrationState
+	if err := json.Unmarshal(stateJSON, &saved); err != nil {
+		return nil, err
+	}
+	if !saved.Target.equal(target) || saved.Migrated == nil {
+		return state, nil
+	}
+	return &saved, nil
+}
+
+// save writes the progress of the migration of the indexes of `absDir`.
+func (s *migrationState) save(absDir string) error {
+	stateJSON, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(filepath.Join(absDir, migrationFile), stateJSON)
+}
+
+// migrationPaths returns the paths, relative to the directory, of the files
+// of the indexed state `files` whose indexes have to be rebuilt by a
+// migration, the prior versions of the files included, in increasing order.
+func migrationPaths(files map[string]indexedEntry) []string {
+	var paths []string
+	for relPath, entry := range files {
+		paths = append(paths, relPath)
+		paths = append(paths, entry.History...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// MigrationProgress describes the migration of the indexes of a client
+// directory built with an outdated schema.
+type MigrationProgress struct {
+	Directory string      // The absolute path of the directory.
+	From      IndexSchema // The schema the indexes have been built with.
+	To        IndexSchema // The schema the indexes are migrated to.
+	Total     int         // The number of files whose indexes have to be rebuilt.
+	Migrated  int         // The number of files whose indexes have been rebuilt so far.
+}
+
+// GetMigrationProgress returns the progress of the migration of the indexes of
+// `directory`, and false if they are built with the current schema.  The
+// migration may be pending, in progress, or interrupted until the next call to
+// `MigrateIndexes`.
+func (c *Client) GetMigrationProgress(directory string) (MigrationProgress, bool, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return MigrationProgress{}, false, err
+	}
+	schema, err := readSchema(dirInfo)
+	if err != nil {
+		return MigrationProgress{}, false, err
+	}
+	target := currentSchema(dirInfo)
+	if schema.equal(target) {
+		return MigrationProgress{}, false, nil
+	}
+	indexState, err := loadIndexState(dirInfo.absDir)
+	if err != nil {
+		return MigrationProgress{}, false, err
+	}
+	state, err := readMigrationState(dirInfo.absDir, target)
+	if err != nil {
+		return MigrationProgress{}, false, err
+	}
+	progress := MigrationProgress{Directory: dirInfo.absDir, From: schema, To: target}
+	for _, relPath := range migrationPaths(indexState.Files) {
+		progress.Total++
+		if state.Migrated[relPath] {
+			progress.Migrated++
+		}
+	}
+	return progress, true, nil
+}
+
+// MigrateIndexes rebuilds the indexes of the files of `directory` built with
+// an outdated schema, e.g. after an upgrade of the client changing the way the
+// words are analyzed or mapped to the buckets of the indexes, and records the
+// current schema once they are all rebuilt.  Does nothing if the indexes are
+// built with the current schema.  The indexes are overwritten in batches, and
+// the progress is saved after each batch, so that a migration interrupted by
+// the shutdown of the client or a failure resumes where it stopped, as
+// reported by `GetMigrationProgress` in the meantime.  The files failing to be
+// indexed are retried by the next call.  The rebuilt indexes are listed as
+// added by the report, and the migration shows as a scan of the directory with
+// `GetScanProgress`.  Returns `ErrDocIDSchemeChanged` if the document IDs of
+// the indexes have changed as well.
+func (c *Client) MigrateIndexes(ctx context.Context, directory string) IndexReport {
+	report := IndexReport{Directory: directory, Start: c.clock.Now()}
+	defer func() {
+		report.Elapsed = c.clock.Since(report.Start)
+		c.recordScan(report)
+	}()
+	defer c.endProgress(directory, c.startProgress(directory))
+
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	schema, err := readSchema(dirInfo)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	target := currentSchema(dirInfo)
+	if schema.equal(target) {
+		return report
+	} else if schema.DocIDScheme != target.DocIDScheme {
+		report.Err = ErrDocIDSchemeChanged
+		return report
+	}
+	indexState, err := loadIndexState(dirInfo.absDir)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	state, err := readMigrationState(dirInfo.absDir, target)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	var pending []string
+	for _, relPath := range migrationPaths(indexState.Files) {
+		if !state.Migrated[relPath] {
+			pending = append(pending, relPath)
+		}
+	}
+	failed := false
+	for start := 0; start < len(pending); start += migrationBatchSize {
+		if c.isShutdown() || dirInfo.isRemoved() {
+			return report
+		}
+		batch := pending[start:]
+		if len(batch) > migrationBatchSize {
+			batch = batch[:migrationBatchSize]
+		}
+		paths := make([]string, len(batch))
+		for i, relPath := range batch {
+			paths[i] = filepath.Join(dirInfo.absDir, relPath)
+			// The indexes are rebuilt even if their files are
+			// unchanged.
+			c.recordContentHash(directory, paths[i], "")
+		}
+		for i, handled := range c.addScannedFiles(ctx, &report, directory, paths, nil) {
+			if handled {
+				state.Migrated[batch[i]] = true
+			} else {
+				failed = true
+			}
+		}
+		if report.Err = state.save(dirInfo.absDir); report.Err != nil {
+			return report
+		}
+	}
+	if report.Err = c.saveFileIssues(directory); report.Err != nil {
+		return report
+	}
+	if report.Err = c.saveContentHashes(directory); report.Err != nil || failed {
+		return report
+	}
+	if report.Err = writeSchema(dirInfo.absDir, target); report.Err != nil {
+		return report
+	}
+	if err := os.Remove(filepath.Join(dirInfo.absDir, migrationFile)); err != nil && !os.IsNotExist(err) {
+		report.Err = err
+	}
+	return report
+}
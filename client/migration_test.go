@@ -0,0 +1,105 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestMigrateIndexes tests the `MigrateIndexes` function.  Checks that the
+// indexes of a directory built with the current schema are left alone, that
+// those built with an outdated one are rebuilt, skipping the files already
+// migrated by an interrupted migration, and that a change of the document IDs
+// is refused.
+func TestMigrateIndexes(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	if _, pending, err := cli.GetMigrationProgress(dir); err != nil || pending {
+		t.Fatalf("migration pending for a directory without a schema: %v, %v", pending, err)
+	}
+	if report := cli.MigrateIndexes(context.Background(), dir); report.Err != nil || len(report.Added) != 0 {
+		t.Fatalf("indexes of the current schema migrated: %+v", report)
+	}
+
+	outdated := currentSchema(dirInfo)
+	outdated.Mapping = libsearch.CodewordMappingUvarint
+	if err := writeSchema(dir, outdated); err != nil {
+		t.Fatalf("error when writing the schema: %s", err)
+	}
+	interrupted := &migrationState{Target: currentSchema(dirInfo), Migrated: map[string]bool{"file0": true}}
+	if err := interrupted.save(dir); err != nil {
+		t.Fatalf("error when writing the migration progress: %s", err)
+	}
+	if progress, pending, err := cli.GetMigrationProgress(dir); err != nil || !pending || progress.Total != 3 || progress.Migrated != 1 {
+		t.Fatalf("incorrect migration progress: %+v, %v, %v", progress, pending, err)
+	}
+	if report := cli.MigrateIndexes(context.Background(), dir); report.Err != nil || len(report.Added) != 2 {
+		t.Fatalf("incorrect migration: %+v", report)
+	}
+	if _, pending, err := cli.GetMigrationProgress(dir); err != nil || pending {
+		t.Fatalf("migration still pending once done: %v, %v", pending, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, migrationFile)); !os.IsNotExist(err) {
+		t.Fatalf("migration progress not removed once done: %v", err)
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "content"); err != nil || len(results) != 3 {
+		t.Fatalf("incorrect results after the migration: %v, %v", results, err)
+	}
+
+	outdated = currentSchema(dirInfo)
+	outdated.DocIDScheme--
+	if err := writeSchema(dir, outdated); err != nil {
+		t.Fatalf("error when writing the schema: %s", err)
+	}
+	if report := cli.MigrateIndexes(context.Background(), dir); report.Err != ErrDocIDSchemeChanged {
+		t.Fatalf("change of the document IDs not refused: %+v", report)
+	}
+}
+
+// TestCurrentSchemaIndexType tests the `currentSchema` function.  Checks that
+// the mapping of the schema is the one a TLF of the index type is registered
+// with.
+func TestCurrentSchemaIndexType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestClient")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+	for indexType, mapping := range map[sserver1.IndexType]libsearch.CodewordMapping{sserver1.IndexType_BLOOM: libsearch.RegisteredCodewordMapping, sserver1.IndexType_CUCKOO: libsearch.CodewordMappingCuckoo} {
+		cli, err := createClientWithClient(context.Background(), newMemoryServerClient(), []string{dir}, 64, 32, 0.000001, 1000, false, indexType)
+		if err != nil {
+			t.Fatalf("error when creating the client: %s", err)
+		}
+		dirInfo, err := cli.getDirectoryInfo(dir)
+		if err != nil {
+			t.Fatalf("error when getting the directory info: %s", err)
+		}
+		if schema := currentSchema(dirInfo); schema.Mapping != mapping {
+			t.Fatalf("incorrect mapping for the index type %d: %d", indexType, schema.Mapping)
+		}
+	}
+}
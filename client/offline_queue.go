@@ -0,0 +1,297 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// queuedOpType is the type of an operation on the indexes of a directory.
+type queuedOpType string
+
+const (
+	queuedWrite  queuedOpType = "write"  // The upload of an index.
+	queuedDelete queuedOpType = "delete" // The deletion of an index.
+	queuedRename queuedOpType = "rename" // The rename of an index.
+)
+
+// queuedOp is an operation on the indexes of a directory, as sent to the
+// search server or queued while the server is unreachable.
+type queuedOp struct {
+	Type        queuedOpType        `json:"type"`                  // The type of the operation.
+	DocID       sserver1.DocumentID `json:"docID"`                 // The document of the index.  The original document for a rename.
+	CurrDocID   sserver1.DocumentID `json:"currDocID,omitempty"`   // The new document of a renamed index.
+	SecureIndex []byte              `json:"secureIndex,omitempty"` // The marshaled index of an upload.
+	Summary     []byte              `json:"summary,omitempty"`     // The marshaled contribution of the document of an upload to the summary of the TLF.
+}
+
+// offlineQueue is the queue of the operations on the indexes of a directory
+// that could not be sent while the search server was unreachable.  The queue is
+// persisted as one JSON operation per line, so that appending an operation
+// does not rewrite the whole queue.
+type offlineQueue struct {
+	lock sync.Mutex // Protects `ops` and serializes the replays.
+	path string     // The path of the file persisting the queue.
+	ops  []queuedOp // The operations queued, oldest first.
+}
+
+// getOfflineQueuePath returns the path of the file persisting the offline
+// queue of `directory` within `stateDir`.
+func getOfflineQueuePath(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, ".queue")
+}
+
+// readOfflineQueue reads the offline queue of `directory` persisted within
+// `stateDir`.  A truncated last operation, left by a crash while it was
+// appended, is dropped.
+func readOfflineQueue(stateDir, directory string) (*offlineQueue, error) {
+	queue := &offlineQueue{path: getOfflineQueuePath(stateDir, directory)}
+	file, err := os.Open(queue.path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	for {
+		var op queuedOp
+		if err := dec.Decode(&op); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return queue, nil
+		} else if err != nil {
+			return nil, err
+		}
+		queue.ops = append(queue.ops, op)
+	}
+}
+
+// appendLocked appends `op` to the queue and to its file.  Should be called
+// with the lock held.
+func (q *offlineQueue) appendLocked(op queuedOp) error {
+	opJSON, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(opJSON, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	q.ops = append(q.ops, op)
+	return nil
+}
+
+// saveLocked persists the operations left in the queue, deleting its file if
+// the queue is empty.  Should be called with the lock held.
+func (q *offlineQueue) saveLocked() error {
+	if len(q.ops) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, op := range q.ops {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+	return libsearch.WriteFileAtomic(q.path, buf.Bytes())
+}
+
+// isConnectionError returns whether `err` reveals that the search server is
+// unreachable, as opposed to the server rejecting the request.
+func isConnectionError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// isOffline returns whether the connection to the search server is known to be
+// down, in which case the RPCs would block until it is reestablished.  Once
+// failed over, the search server is the standby.
+func (c *Client) isOffline() bool {
+	if c.standby != nil && c.standby.isFailedOver() {
+		return c.standby.conn != nil && !c.standby.conn.IsConnected()
+	}
+	return c.conn != nil && !c.conn.IsConnected()
+}
+
+// getOfflineQueue returns the offline queue of `directory`, or nil if the
+// directories of the client are not locked, in which case the operations are
+// never queued.
+func (c *Client) getOfflineQueue(directory string) *offlineQueue {
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	return c.offlineQueues[directory]
+}
+
+// isQueueing returns whether the operations on the indexes of the directory of
+// `dirInfo` are currently queued instead of sent to the search server.
+func (c *Client) isQueueing(dirInfo *DirectoryInfo) bool {
+	queue := c.getOfflineQueue(dirInfo.absDir)
+	if queue == nil {
+		return false
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.ops) > 0 || c.isOffline()
+}
+
+// sendOp sends `op` on the indexes of the directory of `dirInfo` to the search
+// server, retried as set by `SetUploadRetries`, updates the revisions last
+// seen by the client and drops the cached search results.
+func (c *Client) sendOp(ctx context.Context, dirInfo *DirectoryInfo, op queuedOp) error {
+	switch op.Type {
+	case queuedWrite:
+		arg := sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: op.SecureIndex, DocID: op.DocID, BaseRevision: dirInfo.revisions.base(op.DocID)}
+		var res sserver1.WriteResult
+		err := c.withRetries(ctx, func() (err error) {
+			c.waitUpload(len(arg.SecureIndex))
+			c.waitBackfill(ctx, dirInfo)
+			res, err = c.searchCli.WriteIndex(ctx, arg)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		dirInfo.revisions.written(op.DocID, res)
+		c.recordUpload(dirInfo, len(arg.SecureIndex))
+		c.metrics.fileIndexed(dirInfo.absDir)
+		if len(op.Summary) > 0 {
+			if err := c.mergeSummary(ctx, dirInfo, op.DocID, op.Summary); err != nil {
+				return err
+			}
+		}
+	case queuedDelete:
+		err := c.withRetries(ctx, func() error {
+			return c.searchCli.DeleteIndex(ctx, sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: op.DocID})
+		})
+		if err != nil {
+			return err
+		}
+		dirInfo.revisions.deleted(op.DocID)
+	case queuedRename:
+		err := c.withRetries(ctx, func() error {
+			return c.searchCli.RenameIndex(ctx, sserver1.RenameIndexArg{TlfID: dirInfo.tlfID, Orig: op.DocID, Curr: op.CurrDocID})
+		})
+		if err != nil {
+			return err
+		}
+		dirInfo.revisions.renamed(op.DocID, op.CurrDocID)
+	}
+	c.invalidateResults(dirInfo)
+	return nil
+}
+
+// replayLocked sends the operations of `queue` to the search server in order,
+// until the server turns out to be unreachable again.  The operations
+// rejected by the server are dropped, and the first rejection is returned once
+// the rest of the queue has been replayed.  Should be called with the lock of
+// `queue` held.
+func (c *Client) replayLocked(ctx context.Context, dirInfo *DirectoryInfo, queue *offlineQueue) error {
+	if len(queue.ops) == 0 {
+		return nil
+	}
+	var firstErr error
+	sent := 0
+	for ; sent < len(queue.ops) && !c.isOffline(); sent++ {
+		err := c.sendOp(ctx, dirInfo, queue.ops[sent])
+		if err != nil && isConnectionError(err) {
+			break
+		} else if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if sent == 0 {
+		return nil
+	}
+	queue.ops = queue.ops[sent:]
+	if err := queue.saveLocked(); firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// replayOfflineQueue sends the operations queued for the directory of
+// `dirInfo` while the search server was unreachable.  Does nothing if the
+// server is still unreachable.
+func (c *Client) replayOfflineQueue(ctx context.Context, dirInfo *DirectoryInfo) error {
+	queue := c.getOfflineQueue(dirInfo.absDir)
+	if queue == nil {
+		return nil
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return c.replayLocked(ctx, dirInfo, queue)
+}
+
+// sendOrQueue sends `op` to the search server, or queues it in the offline
+// queue of the directory of `dirInfo` if the server is unreachable.  The
+// operations queued earlier are replayed first, and `op` is queued behind them
+// if they cannot all be sent, so that the operations reach the server in
+// order.  The operations are only queued while the directories are locked.
+func (c *Client) sendOrQueue(ctx context.Context, dirInfo *DirectoryInfo, op queuedOp) error {
+	queue := c.getOfflineQueue(dirInfo.absDir)
+	if queue == nil {
+		return c.sendOp(ctx, dirInfo, op)
+	}
+	queue.lock.Lock()
+	err := c.replayLocked(ctx, dirInfo, queue)
+	queued := len(queue.ops) > 0 || c.isOffline()
+	if err == nil && queued {
+		err = queue.appendLocked(op)
+	}
+	queue.lock.Unlock()
+	if err != nil || queued {
+		return err
+	}
+
+	// The lock is not held while sending, so that the uploads of the
+	// directory are not serialized while the server is reachable.
+	if err := c.sendOp(ctx, dirInfo, op); err == nil || !isConnectionError(err) {
+		return err
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return queue.appendLocked(op)
+}
+
+// GetOfflineQueueLength returns the number of operations on the indexes of
+// `directory` queued while the search server was unreachable, and not yet
+// replayed.
+func (c *Client) GetOfflineQueueLength(directory string) (int, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return 0, err
+	}
+	queue := c.getOfflineQueue(dirInfo.absDir)
+	if queue == nil {
+		return 0, nil
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.ops), nil
+}
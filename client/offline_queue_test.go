@@ -0,0 +1,144 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// offlineServerClient is an in-memory server that can be made unreachable, in
+// which case the updates of the indexes fail with a connection error.
+type offlineServerClient struct {
+	*memoryServerClient
+	offline bool
+}
+
+// errUnreachable is the error of the updates sent to an unreachable server.
+var errUnreachable = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func (c *offlineServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	if c.offline {
+		return sserver1.WriteResult{}, errUnreachable
+	}
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+func (c *offlineServerClient) RenameIndex(ctx context.Context, arg sserver1.RenameIndexArg) error {
+	if c.offline {
+		return errUnreachable
+	}
+	return c.memoryServerClient.RenameIndex(ctx, arg)
+}
+
+func (c *offlineServerClient) DeleteIndex(ctx context.Context, arg sserver1.DeleteIndexArg) error {
+	if c.offline {
+		return errUnreachable
+	}
+	return c.memoryServerClient.DeleteIndex(ctx, arg)
+}
+
+// TestOfflineQueue tests the `sendOrQueue` function.  Checks that the updates
+// of the indexes are queued while the server is unreachable, that they are
+// replayed in order once it is reachable again, and that the queue is
+// persisted in the state directory across clients.
+func TestOfflineQueue(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "TestOfflineQueueState")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	server := &offlineServerClient{memoryServerClient: newMemoryServerClient()}
+	client1, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	if _, err := client1.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+
+	orig := filepath.Join(dir, "orig")
+	curr := filepath.Join(dir, "curr")
+	if err := ioutil.WriteFile(orig, []byte("written offline"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	server.offline = true
+	if err := client1.AddFile(context.Background(), dir, orig); err != nil {
+		t.Fatalf("upload not queued: %s", err)
+	}
+	if err := os.Rename(orig, curr); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	if err := client1.RenameFile(context.Background(), dir, orig, curr); err != nil {
+		t.Fatalf("rename not queued: %s", err)
+	}
+	if length, err := client1.GetOfflineQueueLength(dir); err != nil || length != 2 {
+		t.Fatalf("incorrect queue length: %d, %v", length, err)
+	}
+
+	// The next update replays the queue once the server is reachable.
+	server.offline = false
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("written online"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := client1.AddFile(context.Background(), dir, other); err != nil {
+		t.Fatalf("error when adding file: %s", err)
+	}
+	if length, err := client1.GetOfflineQueueLength(dir); err != nil || length != 0 {
+		t.Fatalf("queue not replayed: %d, %v", length, err)
+	}
+	actual, err := client1.SearchWord(context.Background(), dir, "written")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{curr, other}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+
+	server.offline = true
+	if err := os.Remove(curr); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	if err := client1.DeleteFile(context.Background(), dir, curr); err != nil {
+		t.Fatalf("deletion not queued: %s", err)
+	}
+	if err := client1.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+
+	client2, _ := startTestClientWithServer(t, dir, server)
+	if _, err := client2.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	defer client2.UnlockDirectories()
+	if length, err := client2.GetOfflineQueueLength(dir); err != nil || length != 1 {
+		t.Fatalf("queue not persisted: %d, %v", length, err)
+	}
+	server.offline = false
+	if report := client2.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when scanning the directory: %s", report.Err)
+	}
+	if length, err := client2.GetOfflineQueueLength(dir); err != nil || length != 0 {
+		t.Fatalf("queue not replayed: %d, %v", length, err)
+	}
+	actual, err = client2.SearchWord(context.Background(), dir, "written")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{other}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+	if _, err := os.Stat(getOfflineQueuePath(stateDir, dir)); !os.IsNotExist(err) {
+		t.Fatalf("queue file not deleted: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// ServerUnreachable returns whether the search server is known to be
+// unreachable, or `err` returned by a search reveals that it is, in which case
+// the searches can only be answered locally with `SearchQueryOffline`.
+func (c *Client) ServerUnreachable(err error) bool {
+	return c.isOffline() || (err != nil && isConnectionError(err))
+}
+
+// SearchQueryOffline is similar to `SearchQuery`, but answers the query without
+// the search server, from the word set digests the client keeps for the files
+// it indexed in `directory` as of its last scan.  The results are unverified:
+// the files indexed by the other clients since the last scan are missed, and
+// the files modified since they were indexed are matched by their previous
+// content.  The tag keywords are checked against the tags of the files.
+// NOTE: False positives are possible, although with a negligible probability.
+func (c *Client) SearchQueryOffline(directory, query string) ([]string, error) {
+	terms := libsearch.ParseNearQuery(strings.Fields(query))
+	if len(terms) == 0 {
+		return nil, errors.New("empty query")
+	}
+
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadIndexState(dirInfo.absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pathnameKey := dirInfo.getPathnameKey(dirInfo.getLatestKeyIndex())
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil {
+		return nil, err
+	}
+	tagTerms, terms := splitTagTerms(terms)
+
+	filenames := []string{}
+	for relPath := range state.Files {
+		docID, err := libsearch.PathnameToDocID(keyGen, relPath, pathnameKey)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := c.readWordSetDigest(dirInfo.absDir, docID, pathnameKey)
+		if err != nil {
+			// Indexed under a previous key generation, or skipped by the
+			// scan.
+			continue
+		}
+		matches := true
+		for _, term := range tagTerms {
+			keyword, _ := libsearch.ParseMetadataKeyword(term)
+			tagged := false
+			for _, tag := range tags[relPath] {
+				tagged = tagged || libsearch.MetadataKeyword(libsearch.MetadataTag, tag) == keyword
+			}
+			matches = matches && tagged
+		}
+		for _, term := range terms {
+			matches = matches && digest.Contains(pathnameKey, term)
+		}
+		if matches {
+			filenames = append(filenames, filepath.Join(dirInfo.absDir, relPath))
+		}
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
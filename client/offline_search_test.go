@@ -0,0 +1,63 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestSearchQueryOffline tests the `SearchQueryOffline` function.  Checks that
+// the files indexed by the last scan are matched from the local word set
+// digests, including by their metadata keywords, without any search request.
+func TestSearchQueryOffline(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	lockTestClient(t, client, dir)
+
+	contents := map[string]string{"a.txt": "apple banana", "b.md": "banana cherry"}
+	for name, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when scanning the directory: %s", report.Err)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"Banana", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.md")}},
+		{"banana apple", []string{filepath.Join(dir, "a.txt")}},
+		{"banana ext:md", []string{filepath.Join(dir, "b.md")}},
+		{"durian", []string{}},
+	}
+	for _, test := range tests {
+		actual, err := client.SearchQueryOffline(dir, test.query)
+		if err != nil {
+			t.Fatalf("error when searching offline for %q: %s", test.query, err)
+		}
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Fatalf("incorrect offline result for %q: expected %v actual %v", test.query, test.expected, actual)
+		}
+	}
+	if server.wordSearches != 0 || server.conjunctions != 0 {
+		t.Fatalf("search requests sent by the offline searches")
+	}
+
+	if !client.ServerUnreachable(io.EOF) || client.ServerUnreachable(errors.New("rejected")) || client.ServerUnreachable(nil) {
+		t.Fatalf("incorrect detection of the unreachable server")
+	}
+}
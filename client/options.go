@@ -0,0 +1,298 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// Option sets a parameter of a `Client` created with `NewClient`, so that the
+// embedders only pass the parameters they care about, and keep compiling as
+// new ones are added.
+type Option func(*clientOptions)
+
+// clientOptions are the parameters of a `Client` created with `NewClient`.
+type clientOptions struct {
+	lenMS        int                // The length of the master secrets.
+	lenSalt      int                // The length of the salts.
+	fpRate       float64            // The desired false positive rate of the indexes.
+	numUniqWords uint64             // The expected number of unique words in a TLF.
+	encryptSalts bool               // Whether the salts are generated by the client and encrypted.
+	indexType    sserver1.IndexType // The index type requested when registering a TLF.
+	logger       *Logger            // The logger the RPCs are logged to, if set.
+	standbyAddr  string             // The IP address of the standby search server, if set.
+	standbyPort  int                // The port of the standby search server.
+	// setters apply the parameters set after the creation of the client, in
+	// the order of the options.
+	setters []func(ctx context.Context, cli *Client) error
+}
+
+// newClientOptions returns the parameters set by `opts` over the defaults,
+// which are those of the daemon.
+func newClientOptions(opts []Option) clientOptions {
+	o := clientOptions{
+		lenMS:        64,
+		lenSalt:      32,
+		fpRate:       0.000001,
+		numUniqWords: 100000,
+		indexType:    sserver1.IndexType_BLOOM,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// configure applies the parameters of `o` set after the creation of `cli`.
+// Returns the error of the first parameter that fails to be applied, if any.
+func (o clientOptions) configure(ctx context.Context, cli *Client) error {
+	for _, set := range o.setters {
+		if err := set(ctx, cli); err != nil {
+			return err
+		}
+	}
+	if o.standbyAddr != "" {
+		return cli.SetStandby(ctx, o.standbyAddr, o.standbyPort, o.logger)
+	}
+	return nil
+}
+
+// withSetter returns an `Option` applying `set` to the client once created.
+func withSetter(set func(cli *Client)) Option {
+	return func(o *clientOptions) {
+		o.setters = append(o.setters, func(_ context.Context, cli *Client) error {
+			set(cli)
+			return nil
+		})
+	}
+}
+
+// WithMasterSecretLength sets the length of the master secrets, 64 bytes by
+// default.
+func WithMasterSecretLength(lenMS int) Option {
+	return func(o *clientOptions) { o.lenMS = lenMS }
+}
+
+// WithSaltLength sets the length of the salts the TLFs not registered yet are
+// registered with, 32 bytes by default.
+func WithSaltLength(lenSalt int) Option {
+	return func(o *clientOptions) { o.lenSalt = lenSalt }
+}
+
+// WithFPRate sets the desired false positive rate the TLFs not registered yet
+// are registered with, 0.000001 by default.
+func WithFPRate(fpRate float64) Option {
+	return func(o *clientOptions) { o.fpRate = fpRate }
+}
+
+// WithNumUniqWords sets the expected number of unique words in a TLF the TLFs
+// not registered yet are registered with, 100000 by default.
+func WithNumUniqWords(numUniqWords uint64) Option {
+	return func(o *clientOptions) { o.numUniqWords = numUniqWords }
+}
+
+// WithEncryptedSalts sets whether the salts of the TLFs not registered yet are
+// generated by the client and only handed to the search server encrypted.
+func WithEncryptedSalts(enabled bool) Option {
+	return func(o *clientOptions) { o.encryptSalts = enabled }
+}
+
+// WithIndexType sets the index type the TLFs not registered yet are
+// registered with, bloom filters by default.
+func WithIndexType(indexType sserver1.IndexType) Option {
+	return func(o *clientOptions) { o.indexType = indexType }
+}
+
+// WithLogger has the RPCs logged to `logger`.
+func WithLogger(logger *Logger) Option {
+	return func(o *clientOptions) { o.logger = logger }
+}
+
+// WithScanInterval sets the interval between two scans of the directories by
+// `PeriodicAdd`, as `SetScanInterval` does.
+func WithScanInterval(interval time.Duration) Option {
+	return withSetter(func(cli *Client) { cli.SetScanInterval(interval) })
+}
+
+// WithMemoryBudget has the index builds of the client share `budget` with
+// those of the other clients given it, as `ShareMemoryBudget` does.  Pass
+// `NewMemoryBudget` for a budget of the client's own.
+func WithMemoryBudget(budget *MemoryBudget) Option {
+	return withSetter(func(cli *Client) { cli.ShareMemoryBudget(budget) })
+}
+
+// WithIndexWorkers sets the number of files the scans index concurrently, as
+// `SetIndexWorkers` does.
+func WithIndexWorkers(workers int) Option {
+	return withSetter(func(cli *Client) { cli.SetIndexWorkers(workers) })
+}
+
+// WithDecryptWorkers sets the number of workers decrypting the document IDs of
+// the search results, as `SetDecryptWorkers` does.
+func WithDecryptWorkers(workers int) Option {
+	return withSetter(func(cli *Client) { cli.SetDecryptWorkers(workers) })
+}
+
+// WithVerifyWorkers sets the number of files the strict searches scan
+// concurrently, as `SetVerifyWorkers` does.
+func WithVerifyWorkers(workers int) Option {
+	return withSetter(func(cli *Client) { cli.SetVerifyWorkers(workers) })
+}
+
+// WithQueryLimit throttles the search queries to `limit` words every
+// `window`, as `SetQueryLimit` does.
+func WithQueryLimit(limit int, window time.Duration, onAnomaly func(QueryAnomaly)) Option {
+	return withSetter(func(cli *Client) { cli.SetQueryLimit(limit, window, onAnomaly) })
+}
+
+// WithMaxUploadRate limits the uploads of indexes to `bytesPerSec` bytes per
+// second, as `SetMaxUploadRate` does.
+func WithMaxUploadRate(bytesPerSec int64) Option {
+	return withSetter(func(cli *Client) { cli.SetMaxUploadRate(bytesPerSec) })
+}
+
+// WithUploadRetries sets the number of times the failing uploads are retried,
+// as `SetUploadRetries` does.
+func WithUploadRetries(retries int, initialDelay time.Duration) Option {
+	return withSetter(func(cli *Client) { cli.SetUploadRetries(retries, initialDelay) })
+}
+
+// WithBlindingPolicy sets the policy blinding the indexes, as
+// `SetBlindingPolicy` does.  `NewClient` fails if the version of `policy` is
+// unknown.
+func WithBlindingPolicy(policy libsearch.BlindingPolicy) Option {
+	return func(o *clientOptions) {
+		o.setters = append(o.setters, func(_ context.Context, cli *Client) error {
+			return cli.SetBlindingPolicy(policy)
+		})
+	}
+}
+
+// WithResultBucketSize pads the lists of search results to the multiples of
+// `bucketSize`, as `SetResultBucketSize` does.
+func WithResultBucketSize(bucketSize int) Option {
+	return withSetter(func(cli *Client) { cli.SetResultBucketSize(bucketSize) })
+}
+
+// WithIndexSizeBuckets sets whether the indexes are padded to the standard
+// size buckets, as `SetIndexSizeBuckets` does.
+func WithIndexSizeBuckets(enabled bool) Option {
+	return withSetter(func(cli *Client) { cli.SetIndexSizeBuckets(enabled) })
+}
+
+// WithTlfSummaries sets whether the summaries of the TLFs are kept, as
+// `SetTlfSummaries` does.
+func WithTlfSummaries(enabled bool) Option {
+	return withSetter(func(cli *Client) { cli.SetTlfSummaries(enabled) })
+}
+
+// WithNearWindow sets the window of the proximity queries, as `SetNearWindow`
+// does.
+func WithNearWindow(window int) Option {
+	return withSetter(func(cli *Client) { cli.SetNearWindow(window) })
+}
+
+// WithHistoryRevisions sets the number of prior versions of each file kept
+// searchable, as `SetHistoryRevisions` does.
+func WithHistoryRevisions(revisions int) Option {
+	return withSetter(func(cli *Client) { cli.SetHistoryRevisions(revisions) })
+}
+
+// WithResultCache sets the number of search results cached per directory, as
+// `SetResultCache` does.
+func WithResultCache(entries int) Option {
+	return withSetter(func(cli *Client) { cli.SetResultCache(entries) })
+}
+
+// WithSearchPageSize sets the number of results fetched from the search server
+// at once, as `SetSearchPageSize` does.
+func WithSearchPageSize(size int) Option {
+	return withSetter(func(cli *Client) { cli.SetSearchPageSize(size) })
+}
+
+// WithMaxFileSize sets the size beyond which the files are skipped by the
+// scans, as `SetMaxFileSize` does.
+func WithMaxFileSize(size int64) Option {
+	return withSetter(func(cli *Client) { cli.SetMaxFileSize(size) })
+}
+
+// WithSkipBinary sets whether the binary files are skipped by the scans, as
+// `SetSkipBinary` does.
+func WithSkipBinary(skip bool) Option {
+	return withSetter(func(cli *Client) { cli.SetSkipBinary(skip) })
+}
+
+// WithSymlinkPolicy sets how the scans handle the symlinks, as
+// `SetSymlinkPolicy` does.
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return withSetter(func(cli *Client) { cli.SetSymlinkPolicy(policy) })
+}
+
+// WithContentClassifier sets the classifier deciding which files are indexed,
+// as `SetContentClassifier` does.
+func WithContentClassifier(classifier ContentClassifier) Option {
+	return withSetter(func(cli *Client) { cli.SetContentClassifier(classifier) })
+}
+
+// WithClaimTTL sets the duration of the claims taken before uploading an
+// index, as `SetClaimTTL` does.
+func WithClaimTTL(ttl time.Duration) Option {
+	return withSetter(func(cli *Client) { cli.SetClaimTTL(ttl) })
+}
+
+// WithBackfillThreshold sets the number of files from which a scan is paced as
+// a backfill, as `SetBackfillThreshold` does.
+func WithBackfillThreshold(numFiles int) Option {
+	return withSetter(func(cli *Client) { cli.SetBackfillThreshold(numFiles) })
+}
+
+// WithSimulatedLink delays the calls to the search server as over a link with
+// `latency` and `bandwidth`, as `SimulateLink` does.
+func WithSimulatedLink(latency time.Duration, bandwidth int64) Option {
+	return withSetter(func(cli *Client) { cli.SimulateLink(latency, bandwidth) })
+}
+
+// WithStandby mirrors the index writes to the standby search server at
+// `ipAddr`:`port`, as `SetStandby` does, with the RPCs logged to the logger
+// set by `WithLogger`.  `NewClient` fails if the standby cannot be set up.
+func WithStandby(ipAddr string, port int) Option {
+	return func(o *clientOptions) { o.standbyAddr, o.standbyPort = ipAddr, port }
+}
+
+// NewClient creates a new `Client` connected to the search server at
+// `ipAddr`:`port` for the `directories`, with the parameters set by `opts`
+// over the defaults.  If encrypted salts are set, the salts of the TLFs not
+// registered yet are generated by the client and only handed to the search
+// server encrypted.  The TLFs not registered yet are registered with the index
+// type set, and the other TLFs keep their own.  Returns an error on any
+// failure.
+func NewClient(ctx context.Context, ipAddr string, port int, directories []string, opts ...Option) (*Client, error) {
+	o := newClientOptions(opts)
+	serverAddr := fmt.Sprintf("%s:%d", ipAddr, port)
+	conn := rpc.NewTLSConnection(serverAddr, libsearch.GetRootCerts(serverAddr), libkb.ErrorUnwrapper{}, &Client{}, true, rpc.NewSimpleLogFactory(logOutput{logger: o.logger}, nil), libkb.WrapError, logOutput{logger: o.logger}, logTags)
+
+	metrics := newClientMetrics()
+	searchCli := sserver1.SearchServerClient{Cli: meteredClient{GenericClient: conn.GetClient(), metrics: metrics}}
+
+	cli, err := createClientWithClient(ctx, searchCli, directories, o.lenMS, o.lenSalt, o.fpRate, o.numUniqWords, o.encryptSalts, o.indexType)
+	if err != nil {
+		conn.Shutdown()
+		return nil, err
+	}
+	cli.conn = conn
+	cli.metrics = metrics
+	if err := o.configure(ctx, cli); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
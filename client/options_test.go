@@ -0,0 +1,69 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestClientOptions tests the options of `NewClient`.  Checks that the
+// parameters not set keep the defaults of the daemon, and that the ones set
+// are applied to the client.
+func TestClientOptions(t *testing.T) {
+	o := newClientOptions(nil)
+	if o.lenMS != 64 || o.lenSalt != 32 || o.fpRate != 0.000001 || o.numUniqWords != 100000 || o.encryptSalts || o.indexType != sserver1.IndexType_BLOOM {
+		t.Fatalf("incorrect default options: %+v", o)
+	}
+
+	budget := NewMemoryBudget(1 << 20)
+	o = newClientOptions([]Option{WithFPRate(0.001), WithNumUniqWords(500), WithScanInterval(time.Hour), WithMemoryBudget(budget), WithDecryptWorkers(3), WithVerifyWorkers(0), WithQueryLimit(10, time.Minute, nil), WithMaxFileSize(1000), WithClaimTTL(time.Minute), WithBlindingPolicy(libsearch.SizeBucketBlinding{})})
+	dir, err := ioutil.TempDir("", "TestClientOptions")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+	cli, err := createClientWithClient(context.Background(), newMemoryServerClient(), []string{dir}, o.lenMS, o.lenSalt, o.fpRate, o.numUniqWords, o.encryptSalts, o.indexType)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	if err := o.configure(context.Background(), cli); err != nil {
+		t.Fatalf("error when applying the options: %s", err)
+	}
+	if cli.dirParams.fpRate != 0.001 || cli.dirParams.numUniqWords != 500 || cli.dirParams.lenMS != 64 {
+		t.Fatalf("options not applied to the directories: %+v", cli.dirParams)
+	}
+	if cli.scanInterval != time.Hour {
+		t.Fatalf("scan interval not applied: %s", cli.scanInterval)
+	}
+	if cli.memBudget != budget || cli.decryptWorkers != 3 || cli.verifyWorkers != 1 || cli.throttle == nil || cli.maxFileSize != 1000 || cli.claimTTL != time.Minute {
+		t.Fatalf("options not applied to the client")
+	}
+	if cli.dirParams.blinding.Version() != libsearch.BlindingPolicySizeBucket {
+		t.Fatalf("blinding policy not applied: %d", cli.dirParams.blinding.Version())
+	}
+
+	o = newClientOptions([]Option{WithBlindingPolicy(unknownBlinding{})})
+	if err := o.configure(context.Background(), cli); err == nil {
+		t.Fatalf("unknown blinding policy applied")
+	}
+}
+
+// unknownBlinding is a blinding policy of a version unknown to the indexes.
+type unknownBlinding struct {
+	libsearch.LengthBlinding
+}
+
+// Version implements the BlindingPolicy interface.
+func (unknownBlinding) Version() libsearch.BlindingPolicyVersion {
+	return 0xff
+}
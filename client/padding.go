@@ -0,0 +1,408 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+const (
+	// paddingPolicyFile is the name of the optional file in a TLF that enables
+	// the padding of the number of documents the search server sees for that
+	// TLF.  As the file is synced through KBFS, the policy applies to all the
+	// devices of all the members.
+	paddingPolicyFile = ".search_kbfs_padding"
+	// dummiesFile is the name of the file in a TLF listing the document IDs
+	// of the dummy indexes uploaded for that TLF.
+	dummiesFile = ".search_kbfs_dummies"
+	// dummyPathnamePrefix prefixes the pathnames encrypted into the document
+	// IDs of the dummy indexes, so that they can be filtered out of the search
+	// results.
+	dummyPathnamePrefix = ".search_kbfs_dummy_"
+	// defaultDummySize is the default average length in bytes of the
+	// documents the dummy indexes pretend to be.
+	defaultDummySize = 4096
+)
+
+// paddingPolicy is the padding policy of a TLF, as stored in
+// `paddingPolicyFile`.
+type paddingPolicy struct {
+	BucketSize int    `json:"bucketSize"` // The number of documents seen by the server is padded to a multiple of this.
+	BatchDelay string `json:"batchDelay"` // How long the uploads are held back to be sent in batches, e.g. "10m".  Uploads are immediate if empty.
+	DummySize  int64  `json:"dummySize"`  // The average length in bytes of the documents the dummy indexes pretend to be.
+}
+
+// pendingWrite is an index held back until the next batch of uploads.
+type pendingWrite struct {
+	arg     sserver1.WriteIndexArg    // The index to upload.
+	digest  libsearch.WordSetDigest   // The word set digest of the document.
+	key     libsearch.PathnameKeyType // The key to seal the digest with.
+	summary []byte                    // The marshaled contribution of the document to the summary of the TLF.  Nil if the summaries are disabled.
+}
+
+// tlfPadding holds the padding state of a TLF.
+type tlfPadding struct {
+	bucketSize int           // The number of documents seen by the server is padded to a multiple of this.
+	batchDelay time.Duration // How long the uploads are held back.  No batching if 0.
+	dummySize  int64         // The average length of the documents the dummy indexes pretend to be.
+
+	padLock sync.Mutex // Serializes the updates of the dummy indexes.
+
+	pendingLock    sync.Mutex                           // Protects `pendingWrites` and `pendingDeletes`.
+	pendingWrites  map[sserver1.DocumentID]pendingWrite // The indexes waiting to be uploaded.
+	pendingDeletes map[sserver1.DocumentID]bool         // The indexes waiting to be deleted.
+}
+
+// readPaddingPolicy reads the padding policy of the TLF at `directory`.
+// Returns nil if the TLF does not have a padding policy.
+func readPaddingPolicy(directory string) (*tlfPadding, error) {
+	policyJSON, err := ioutil.ReadFile(filepath.Join(directory, paddingPolicyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var policy paddingPolicy
+	if err := json.Unmarshal(policyJSON, &policy); err != nil {
+		return nil, err
+	}
+	if policy.BucketSize < 1 {
+		return nil, errors.New("invalid padding bucket size")
+	}
+
+	padding := &tlfPadding{
+		bucketSize:     policy.BucketSize,
+		dummySize:      policy.DummySize,
+		pendingWrites:  make(map[sserver1.DocumentID]pendingWrite),
+		pendingDeletes: make(map[sserver1.DocumentID]bool),
+	}
+	if padding.dummySize <= 0 {
+		padding.dummySize = defaultDummySize
+	}
+	if policy.BatchDelay != "" {
+		if padding.batchDelay, err = time.ParseDuration(policy.BatchDelay); err != nil {
+			return nil, err
+		}
+	}
+	return padding, nil
+}
+
+// isBatched returns whether the uploads are held back to be sent in batches.
+func (p *tlfPadding) isBatched() bool {
+	return p != nil && p.batchDelay > 0
+}
+
+// addWrite queues the upload of an index, superseding any pending deletion.
+func (p *tlfPadding) addWrite(write pendingWrite) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	delete(p.pendingDeletes, write.arg.DocID)
+	p.pendingWrites[write.arg.DocID] = write
+}
+
+// addDelete queues the deletion of an index, superseding any pending upload.
+func (p *tlfPadding) addDelete(docID sserver1.DocumentID) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	delete(p.pendingWrites, docID)
+	p.pendingDeletes[docID] = true
+}
+
+// renamePending moves the pending upload of `orig` to `curr`.  Returns false
+// if there is no pending upload for `orig`.
+func (p *tlfPadding) renamePending(orig, curr sserver1.DocumentID) bool {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	write, ok := p.pendingWrites[orig]
+	if !ok {
+		return false
+	}
+	delete(p.pendingWrites, orig)
+	write.arg.DocID = curr
+	p.pendingWrites[curr] = write
+	return true
+}
+
+// takePending removes and returns all the pending uploads and deletions.
+func (p *tlfPadding) takePending() (map[sserver1.DocumentID]pendingWrite, map[sserver1.DocumentID]bool) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	writes, deletes := p.pendingWrites, p.pendingDeletes
+	p.pendingWrites = make(map[sserver1.DocumentID]pendingWrite)
+	p.pendingDeletes = make(map[sserver1.DocumentID]bool)
+	return writes, deletes
+}
+
+// numPending returns the number of pending uploads and deletions.
+func (p *tlfPadding) numPending() int {
+	if p == nil {
+		return 0
+	}
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	return len(p.pendingWrites) + len(p.pendingDeletes)
+}
+
+// isDummyPathname returns whether `pathname` belongs to a dummy index.
+func isDummyPathname(pathname string) bool {
+	return strings.HasPrefix(filepath.Base(pathname), dummyPathnamePrefix)
+}
+
+// readDummies reads the document IDs of the dummy indexes of the TLF at
+// `directory`.
+func readDummies(directory string) ([]sserver1.DocumentID, error) {
+	dummiesJSON, err := ioutil.ReadFile(filepath.Join(directory, dummiesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var dummies []sserver1.DocumentID
+	err = json.Unmarshal(dummiesJSON, &dummies)
+	return dummies, err
+}
+
+// writeDummies records `dummies` as the document IDs of the dummy indexes of
+// the TLF at `directory`.
+func writeDummies(directory string, dummies []sserver1.DocumentID) error {
+	dummiesJSON, err := json.Marshal(dummies)
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(filepath.Join(directory, dummiesFile), dummiesJSON)
+}
+
+// countIndexedDocuments returns the number of real documents indexed for the
+// TLF at `directory`, i.e. the number of word set digests.  Always 0 if the
+// directories of the client are not locked, as the digests are not stored.
+func (c *Client) countIndexedDocuments(directory string) (int, error) {
+	stateDir := c.getLocalStateDir()
+	if stateDir == "" {
+		return 0, nil
+	}
+	infos, err := ioutil.ReadDir(getWordSetDigestDir(stateDir, directory))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return len(infos), err
+}
+
+// writeIndex uploads the index of `write` and stores its word set digest.  The
+// write wins over the writes of the other clients, but is counted as a conflict
+// if another client has written the index since the client last saw it.  The
+// upload is retried as set by `SetUploadRetries`, and queued if the search
+// server is unreachable.
+func (c *Client) writeIndex(ctx context.Context, dirInfo *DirectoryInfo, write pendingWrite) error {
+	err := c.sendOrQueue(ctx, dirInfo, queuedOp{Type: queuedWrite, DocID: write.arg.DocID, SecureIndex: write.arg.SecureIndex, Summary: write.summary})
+	if err != nil {
+		return err
+	}
+	return c.writeWordSetDigest(dirInfo.absDir, write.arg.DocID, write.digest, write.key)
+}
+
+// deleteIndex deletes the index of `docID` and its word set digest.  The
+// deletion is retried as set by `SetUploadRetries`, and queued if the search
+// server is unreachable.
+func (c *Client) deleteIndex(ctx context.Context, dirInfo *DirectoryInfo, docID sserver1.DocumentID) error {
+	err := c.sendOrQueue(ctx, dirInfo, queuedOp{Type: queuedDelete, DocID: docID})
+	if err != nil {
+		return err
+	}
+	return c.removeWordSetDigest(dirInfo.absDir, docID)
+}
+
+// writeDummyIndex uploads a new dummy index for the directory of `dirInfo` and
+// returns its document ID.
+func (c *Client) writeDummyIndex(ctx context.Context, dirInfo *DirectoryInfo) (sserver1.DocumentID, error) {
+	var nameBytes [16]byte
+	if _, err := rand.Read(nameBytes[:]); err != nil {
+		return "", err
+	}
+	keyIndex := dirInfo.getLatestKeyIndex()
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	docID, err := libsearch.PathnameToDocID(keyGen, dummyPathnamePrefix+hex.EncodeToString(nameBytes[:]), dirInfo.getPathnameKey(keyIndex))
+	if err != nil {
+		return "", err
+	}
+
+	// Varies the length of the pretended documents between 50% and 150% of
+	// the average.
+	jitter, err := rand.Int(rand.Reader, big.NewInt(dirInfo.padding.dummySize+1))
+	if err != nil {
+		return "", err
+	}
+	length := dirInfo.padding.dummySize/2 + jitter.Int64()
+	secIndex, err := dirInfo.getIndexer(keyIndex).BuildDummySecureIndex(length)
+	if err != nil {
+		return "", err
+	}
+	secIndex.SizeBucket = c.sizeBuckets
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	c.waitUpload(len(secIndexBytes))
+	c.waitBackfill(ctx, dirInfo)
+	if _, err := c.searchCli.WriteIndex(ctx, sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndexBytes, DocID: docID}); err != nil {
+		return "", err
+	}
+	c.recordUpload(dirInfo, len(secIndexBytes))
+	if c.tlfSummaries {
+		// Contributes random words, so that the dummy indexes cannot be
+		// told apart by their contributions.
+		summary, err := dirInfo.getIndexer(keyIndex).BuildDummySummary(int(length / dummySummaryWordLength))
+		if err != nil {
+			return "", err
+		}
+		summaryBytes, err := summary.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		if err := c.mergeSummary(ctx, dirInfo, docID, summaryBytes); err != nil {
+			return "", err
+		}
+	}
+	return docID, nil
+}
+
+// padDocumentCount uploads or deletes dummy indexes, so that the number of
+// documents the server sees for the directory of `dirInfo` is the smallest
+// multiple of the bucket size that is at least the number of real documents.
+// Does nothing if the TLF does not have a padding policy.
+func (c *Client) padDocumentCount(ctx context.Context, dirInfo *DirectoryInfo) error {
+	padding := dirInfo.padding
+	if padding == nil {
+		return nil
+	}
+	// The padding catches up with the queued operations once they are
+	// replayed.
+	if c.isQueueing(dirInfo) {
+		return nil
+	}
+	padding.padLock.Lock()
+	defer padding.padLock.Unlock()
+
+	numReal, err := c.countIndexedDocuments(dirInfo.absDir)
+	if err != nil {
+		return err
+	}
+	dummies, err := readDummies(dirInfo.absDir)
+	if err != nil {
+		return err
+	}
+	numDummies := (numReal+padding.bucketSize-1)/padding.bucketSize*padding.bucketSize - numReal
+
+	// The list of dummies is updated after each RPC, so that no dummy index
+	// is orphaned on the server if the padding is interrupted.
+	for len(dummies) < numDummies {
+		docID, err := c.writeDummyIndex(ctx, dirInfo)
+		if err != nil {
+			return err
+		}
+		dummies = append(dummies, docID)
+		if err := writeDummies(dirInfo.absDir, dummies); err != nil {
+			return err
+		}
+	}
+	for len(dummies) > numDummies {
+		docID := dummies[len(dummies)-1]
+		if err := c.searchCli.DeleteIndex(ctx, sserver1.DeleteIndexArg{TlfID: dirInfo.tlfID, DocID: docID}); err != nil {
+			return err
+		}
+		dummies = dummies[:len(dummies)-1]
+		if err := writeDummies(dirInfo.absDir, dummies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushPending uploads and deletes the indexes held back for the directory of
+// `dirInfo`, then pads its number of documents.  The operations that fail are
+// queued again for the next batch, unless superseded in the meantime.
+func (c *Client) flushPending(ctx context.Context, dirInfo *DirectoryInfo) error {
+	padding := dirInfo.padding
+	if !padding.isBatched() {
+		return nil
+	}
+
+	if err := c.replayOfflineQueue(ctx, dirInfo); err != nil {
+		return err
+	}
+	writes, deletes := padding.takePending()
+	var firstErr error
+	for docID, write := range writes {
+		if err := c.writeIndex(ctx, dirInfo, write); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			padding.pendingLock.Lock()
+			if _, ok := padding.pendingWrites[docID]; !ok && !padding.pendingDeletes[docID] {
+				padding.pendingWrites[docID] = write
+			}
+			padding.pendingLock.Unlock()
+		}
+	}
+	for docID := range deletes {
+		if err := c.deleteIndex(ctx, dirInfo, docID); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			padding.pendingLock.Lock()
+			if _, ok := padding.pendingWrites[docID]; !ok {
+				padding.pendingDeletes[docID] = true
+			}
+			padding.pendingLock.Unlock()
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return c.padDocumentCount(ctx, dirInfo)
+}
+
+// Flush immediately sends the uploads and deletions held back by the padding
+// policies of the directories.  Should be called before exiting, as the
+// pending operations are otherwise lost.
+func (c *Client) Flush(ctx context.Context) error {
+	for _, dirInfo := range c.getDirectoryInfos() {
+		if err := c.flushPending(ctx, dirInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// periodicFlush sends the uploads and deletions held back for the directory of
+// `dirInfo` in batches, until the client is shut down.
+func (c *Client) periodicFlush(dirInfo *DirectoryInfo) {
+	for {
+		select {
+		case <-c.clock.After(dirInfo.padding.batchDelay):
+		case <-dirInfo.removedCh:
+			return
+		case <-c.shutdownCh:
+			return
+		}
+		// Failed operations are retried with the next batch.
+		c.flushPending(context.Background(), dirInfo)
+	}
+}
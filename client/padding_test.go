@@ -0,0 +1,153 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// startTestPaddedClient creates a client for a new directory with the padding
+// `policy`, backed by a new `memoryServerClient`.
+func startTestPaddedClient(t *testing.T, policy string, clock clockwork.Clock) (*Client, *memoryServerClient, string) {
+	dir, err := ioutil.TempDir("", "TestPadding")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	writeTestKbfsStatus(t, dir, 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, paddingPolicyFile), []byte(policy), 0666); err != nil {
+		t.Fatalf("error when writing the padding policy: %s", err)
+	}
+	server := newMemoryServerClient()
+	cli, err := createClientWithClock(context.Background(), server, clock, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	lockTestClient(t, cli, dir)
+	return cli, server, dir
+}
+
+// writeTestFiles writes and adds `num` test files containing "common" to
+// `dir`, and returns their names.
+func writeTestFiles(t *testing.T, cli *Client, dir string, num int) []string {
+	filenames := make([]string, num)
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(filenames[i], []byte("common word"+strconv.Itoa(i)), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := cli.AddFile(context.Background(), dir, filenames[i]); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	return filenames
+}
+
+// TestPadDocumentCount tests the `padDocumentCount` function.  Checks that the
+// number of indexes on the server is always padded to a multiple of the bucket
+// size, and that the dummy indexes never show up in the search results.
+func TestPadDocumentCount(t *testing.T) {
+	cli, server, dir := startTestPaddedClient(t, `{"bucketSize": 4}`, clockwork.NewRealClock())
+	defer os.RemoveAll(dir)
+	defer cli.Shutdown()
+	tlfID := cli.directoryInfos[dir].tlfID
+
+	filenames := writeTestFiles(t, cli, dir, 5)
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 8 {
+		t.Fatalf("incorrect number of indexes on the server: expected 8 actual %d", numIndexes)
+	}
+
+	actual, err := cli.SearchWord(context.Background(), dir, "common")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if !reflect.DeepEqual(filenames, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", filenames, actual)
+	}
+
+	for _, filename := range filenames[:2] {
+		if err := cli.DeleteFile(context.Background(), dir, filename); err != nil {
+			t.Fatalf("error when deleting file: %s", err)
+		}
+	}
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 4 {
+		t.Fatalf("incorrect number of indexes on the server: expected 4 actual %d", numIndexes)
+	}
+	dummies, err := readDummies(dir)
+	if err != nil || len(dummies) != 1 {
+		t.Fatalf("incorrect dummies recorded: %v, %v", dummies, err)
+	}
+}
+
+// TestBatchedUploads tests the batching of the uploads by the padding policy.
+// Checks that nothing reaches the server before the batch delay, and that the
+// pending uploads, renames and deletions are then sent together.
+func TestBatchedUploads(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	cli, server, dir := startTestPaddedClient(t, `{"bucketSize": 2, "batchDelay": "10m"}`, clock)
+	defer os.RemoveAll(dir)
+	defer cli.Shutdown()
+	tlfID := cli.directoryInfos[dir].tlfID
+
+	filenames := writeTestFiles(t, cli, dir, 3)
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(filenames[0], renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	if err := cli.RenameFile(context.Background(), dir, filenames[0], renamed); err != nil {
+		t.Fatalf("error when renaming file: %s", err)
+	}
+	if err := cli.DeleteFile(context.Background(), dir, filenames[1]); err != nil {
+		t.Fatalf("error when deleting file: %s", err)
+	}
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 0 {
+		t.Fatalf("indexes uploaded before the batch delay: %d", numIndexes)
+	}
+
+	// Waits for the key generation check and the flush loops.
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Minute)
+	clock.BlockUntil(2)
+
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 2 {
+		t.Fatalf("incorrect number of indexes on the server: expected 2 actual %d", numIndexes)
+	}
+	actual, err := cli.SearchWord(context.Background(), dir, "common")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{filenames[2], renamed}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+}
+
+// TestClose tests the `Close` function.  Checks that the uploads held back by
+// the padding policy are sent before the client is closed.
+func TestClose(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Now())
+	cli, server, dir := startTestPaddedClient(t, `{"bucketSize": 2, "batchDelay": "10m"}`, clock)
+	defer os.RemoveAll(dir)
+	tlfID := cli.directoryInfos[dir].tlfID
+
+	writeTestFiles(t, cli, dir, 3)
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 0 {
+		t.Fatalf("indexes uploaded before the batch delay: %d", numIndexes)
+	}
+	if err := cli.Close(); err != nil {
+		t.Fatalf("error when closing the client: %s", err)
+	}
+	if numIndexes := len(server.docIDs(tlfID)); numIndexes != 4 {
+		t.Fatalf("incorrect number of indexes on the server: expected 4 actual %d", numIndexes)
+	}
+}
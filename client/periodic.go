@@ -0,0 +1,388 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultScanInterval is the default interval between two scans of the
+	// directories for updated files.
+	defaultScanInterval = time.Minute
+	// keyGenCheckInterval is the interval between two checks of the
+	// `.kbfs_status` files for rekeys, on top of the check at the start of
+	// each scan, so that the files are indexed with the latest keys soon
+	// after a rekey.
+	keyGenCheckInterval = 10 * time.Second
+	// reindexStaleInterval is the interval between two passes reconciling the
+	// indexes on the search server with the files.
+	reindexStaleInterval = 6 * time.Hour
+	// reindexStaleBatchSize is the number of files whose index information
+	// is requested from the search server in a single round trip.
+	reindexStaleBatchSize = 256
+)
+
+// IndexReport summarizes a scan of a directory for updated files, or a batch
+// of file events when watching the directory.
+type IndexReport struct {
+	Directory string            // The directory scanned.
+	Start     time.Time         // The time the scan started.
+	Elapsed   time.Duration     // The time the scan took.
+	Added     []string          // The files added to the search server.
+	Skipped   int               // The number of files skipped as too large or binary.
+	Deferred  int               // The number of files left to another client that claimed them.
+	Unchanged int               // The number of files modified whose content is unchanged, not indexed again.
+	Deleted   []string          // The files deleted from the search server.
+	Renamed   map[string]string // The files renamed on the search server, from their original to their new paths.
+	Err       error             // The error that aborted the scan, if any.
+}
+
+// indexedEntry describes a file indexed by a scan, as recorded in the indexed
+// state of its directory.  A rename preserves both the size and the
+// modification time of a file, which allows the renames to be told from the
+// deletions.
+type indexedEntry struct {
+	Size     int64     `json:"size"`               // The size of the file in bytes.
+	ModTime  time.Time `json:"modTime"`            // The modification time of the file.
+	Revision int64     `json:"revision,omitempty"` // The revision of the TLF the file was indexed at, if recorded for its history.
+	History  []string  `json:"history,omitempty"`  // The archived paths of the prior versions of the file indexed, oldest first.
+}
+
+// newIndexedEntry returns the entry describing the file of `info`.
+func newIndexedEntry(info os.FileInfo) indexedEntry {
+	return indexedEntry{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// fingerprint returns the key identifying the file of `e` across renames.
+func (e indexedEntry) fingerprint() [2]int64 {
+	return [2]int64{e.Size, e.ModTime.UnixNano()}
+}
+
+// matchRenames pairs the files that are `gone` with the files that have
+// `appeared` under a new path, keyed by their relative paths, when they share
+// a fingerprint that no other file of either side has.  Returns a map from the
+// original to the new relative paths.
+func matchRenames(gone, appeared map[string]indexedEntry) map[string]string {
+	goneByFingerprint := make(map[[2]int64][]string)
+	for relPath, entry := range gone {
+		goneByFingerprint[entry.fingerprint()] = append(goneByFingerprint[entry.fingerprint()], relPath)
+	}
+	appearedByFingerprint := make(map[[2]int64][]string)
+	for relPath, entry := range appeared {
+		appearedByFingerprint[entry.fingerprint()] = append(appearedByFingerprint[entry.fingerprint()], relPath)
+	}
+	renames := make(map[string]string)
+	for fingerprint, origs := range goneByFingerprint {
+		if currs := appearedByFingerprint[fingerprint]; len(origs) == 1 && len(currs) == 1 {
+			renames[origs[0]] = currs[0]
+		}
+	}
+	return renames
+}
+
+// scanPlan is how the files found by the walk of a scan compare to the indexed
+// state of the directory, keyed by their paths relative to the directory.
+type scanPlan struct {
+	unchanged map[string]indexedEntry // The files not modified since they were indexed, with their indexed entries.
+	updated   []scannedFile           // The files modified since they were indexed, in the order of the walk.
+	appeared  map[string]indexedEntry // The files not indexed, some of which may have been renamed from the gone ones.
+	gone      map[string]indexedEntry // The files indexed but no longer found, with their indexed entries.
+}
+
+// planScan walks the files of `directory` with `walkScannedFiles`, handling the
+// symlinks as set by `policy` and the hidden files with `onHidden`, and
+// compares them to the indexed `state` of the directory.
+func planScan(directory string, state *indexState, policy SymlinkPolicy, onHidden func(relPath string)) (scanPlan, error) {
+	plan := scanPlan{unchanged: make(map[string]indexedEntry), appeared: make(map[string]indexedEntry), gone: make(map[string]indexedEntry)}
+	seen := make(map[string]bool)
+	err := walkScannedFiles(directory, policy, func(relPath string, info os.FileInfo) error {
+		seen[relPath] = true
+		switch state.change(relPath, info) {
+		case fileAppeared:
+			plan.appeared[relPath] = newIndexedEntry(info)
+		case fileUnchanged:
+			if prev, ok := state.Files[relPath]; ok {
+				plan.unchanged[relPath] = prev
+			} else {
+				plan.unchanged[relPath] = newIndexedEntry(info)
+			}
+		default:
+			plan.updated = append(plan.updated, scannedFile{relPath, newIndexedEntry(info)})
+		}
+		return nil
+	}, onHidden)
+	if err != nil {
+		return scanPlan{}, err
+	}
+	for relPath, entry := range state.Files {
+		if !seen[relPath] {
+			plan.gone[relPath] = entry
+		}
+	}
+	return plan, nil
+}
+
+// startScan starts a scan of `directory`, by a pass over the whole directory or
+// over the events of the watcher, and returns its report.  The keys of the TLF
+// are refreshed, and the operations queued while the search server was
+// unreachable are sent ahead of the ones of the scan.  The scan should be
+// ended with `endScan` whatever its outcome.
+func (c *Client) startScan(ctx context.Context, directory string) IndexReport {
+	report := IndexReport{Directory: directory, Start: c.clock.Now()}
+	if dirInfo, err := c.getDirectoryInfo(directory); err == nil {
+		c.refreshKeys(dirInfo)
+		report.Err = c.replayOfflineQueue(ctx, dirInfo)
+	}
+	return report
+}
+
+// endScan ends the scan of `report` started with `startScan`, recording its
+// duration and outcome.
+func (c *Client) endScan(report *IndexReport) {
+	report.Elapsed = c.clock.Since(report.Start)
+	c.recordScan(*report)
+}
+
+// IndexUpdatedFiles adds all the non-hidden files under `directory` that have
+// been modified since its last scan or were not indexed by it, renames the
+// indexes of the files that have been moved, deletes the indexes of the files
+// that are gone, and records the time of this scan.  The files moved within
+// the directory are detected by their size and modification time, and only
+// renamed on the search server instead of being indexed again.  The small
+// files modified recently are added ahead of the other ones, as ordered by
+// `prioritizeUploads`.  The modification times of the subdirectories are not
+// relied upon, as updating a file in place does not change them.  Once `ctx`
+// is done, the files not handled yet are left to the next scan, and the error
+// of `ctx` is returned.
+func (c *Client) IndexUpdatedFiles(ctx context.Context, directory string) IndexReport {
+	report := c.startScan(ctx, directory)
+	defer c.endScan(&report)
+	defer c.endProgress(directory, c.startProgress(directory))
+	if report.Err != nil {
+		return report
+	}
+
+	state, err := loadIndexState(directory)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	defer state.close()
+	prevIndexed := state.Files
+	// The revision is only recorded when the prior versions are indexed, as
+	// reading it does not come for free on KBFS.
+	var revision int64
+	if c.historyDepth > 0 {
+		revision, _ = readTLFRevision(directory)
+	}
+
+	plan, err := planScan(directory, state, c.symlinks, nil)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	indexed := plan.unchanged
+	// The files modified since the last scan, added once the renames are
+	// matched along with the files that appeared and were not renamed.
+	scanned := plan.updated
+	for _, file := range scanned {
+		indexed[file.relPath] = file.entry
+	}
+	appeared, gone := plan.appeared, plan.gone
+
+	// The renames are matched before the files are added, as the updated
+	// files are never gone.
+	for orig, curr := range matchRenames(gone, appeared) {
+		origPath, currPath := filepath.Join(directory, orig), filepath.Join(directory, curr)
+		if c.RenameFile(ctx, directory, origPath, currPath) != nil {
+			continue
+		}
+		if report.Renamed == nil {
+			report.Renamed = make(map[string]string)
+		}
+		report.Renamed[origPath] = currPath
+		c.renameContentHash(directory, origPath, currPath)
+		entry := appeared[curr]
+		entry.Revision, entry.History = gone[orig].Revision, gone[orig].History
+		appeared[curr] = entry
+		state.forget(orig)
+		state.record(curr, appeared[curr])
+		indexed[curr] = appeared[curr]
+		delete(appeared, curr)
+		delete(gone, orig)
+	}
+
+	for relPath, entry := range appeared {
+		scanned = append(scanned, scannedFile{relPath, entry})
+	}
+	prioritizeUploads(scanned, report.Start)
+	scannedPaths := make([]string, len(scanned))
+	for i, file := range scanned {
+		scannedPaths[i] = filepath.Join(directory, file.relPath)
+		scanned[i].entry.Revision = revision
+	}
+	// Each file handled is journaled right away, so that a scan interrupted
+	// by a crash does not handle it again.  The version a file replaces
+	// joins its history first.
+	onHandled := func(i int) {
+		if prev, ok := prevIndexed[scanned[i].relPath]; ok && (c.historyDepth > 0 || len(prev.History) > 0) {
+			scanned[i].entry.History = c.updateHistory(ctx, directory, scanned[i].relPath, prev)
+		}
+		state.record(scanned[i].relPath, scanned[i].entry)
+	}
+	for i, handled := range c.addScannedFiles(ctx, &report, directory, scannedPaths, onHandled) {
+		relPath := scanned[i].relPath
+		if handled {
+			indexed[relPath] = scanned[i].entry
+		} else if prevEntry, ok := prevIndexed[relPath]; ok {
+			// The previous index is still on the search server.
+			indexed[relPath] = prevEntry
+		} else {
+			delete(indexed, relPath)
+		}
+	}
+	// The files whose index could not be deleted are kept, so that the
+	// deletion is retried by the next scan.
+	for relPath, entry := range gone {
+		path := filepath.Join(directory, relPath)
+		if c.deleteScannedFile(ctx, directory, path) {
+			c.deleteHistory(ctx, directory, entry.History)
+			report.Deleted = append(report.Deleted, path)
+			state.forget(relPath)
+		} else {
+			indexed[relPath] = entry
+		}
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Deleted)
+
+	c.pruneFileIssues(directory, func(relPath string) bool {
+		_, ok := indexed[relPath]
+		return ok
+	})
+	c.pruneContentHashes(directory, func(relPath string) bool {
+		_, ok := indexed[relPath]
+		return ok
+	})
+	if report.Err = c.saveFileIssues(directory); report.Err != nil {
+		return report
+	}
+	if report.Err = c.saveContentHashes(directory); report.Err != nil {
+		return report
+	}
+	if report.Err = state.commit(indexed, report.Start); report.Err != nil {
+		return report
+	}
+	report.Err = ctx.Err()
+	return report
+}
+
+// PeriodicAdd scans `directories` at the interval set by `SetScanInterval`,
+// every minute by default, and adds the updated files to the search server,
+// until the client is shut down, `ctx` is done or all the `directories` are
+// removed with `RemoveDirectory`.  A scan in progress at shutdown completes and
+// records its time before `PeriodicAdd` returns.  `onScan` is called with the
+// report of each scan, except the scans interrupted by the removal of their
+// directory.  A directory whose scan fails, e.g. as its KBFS mount is offline,
+// is retried by the next round, without holding up the other directories.
+func (c *Client) PeriodicAdd(ctx context.Context, directories []string, onScan func(IndexReport)) {
+	dirInfos := c.trackDirectories(directories)
+	for {
+		for i, directory := range directories {
+			if c.isShutdown() || ctx.Err() != nil {
+				return
+			}
+			if dirInfos[i].isRemoved() {
+				continue
+			}
+			report := c.IndexUpdatedFiles(ctx, directory)
+			if !dirInfos[i].isRemoved() {
+				onScan(report)
+			}
+		}
+		if !c.waitTracked(ctx, dirInfos, c.scanInterval) {
+			return
+		}
+	}
+}
+
+// ReindexStale reconciles the indexes stored on the search server with the
+// non-hidden files under `directory`, and re-adds the files that have no index
+// or have been modified after their index was last written.  This catches the
+// uploads that silently failed, independently of the time of the last scan.
+// Once `ctx` is done, the files not handled yet are left to the next pass.
+func (c *Client) ReindexStale(ctx context.Context, directory string) IndexReport {
+	report := IndexReport{Directory: directory, Start: c.clock.Now()}
+	defer func() {
+		report.Elapsed = c.clock.Since(report.Start)
+		c.recordScan(report)
+	}()
+	defer c.endProgress(directory, c.startProgress(directory))
+
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	if report.Err = c.replayOfflineQueue(ctx, dirInfo); report.Err != nil {
+		return report
+	}
+
+	var stale []string
+	report.Err = c.walkDocumentInfos(ctx, dirInfo, func(relPath string, modTime time.Time, info *DocumentInfo) {
+		if info != nil && !modTime.After(info.LastWrite) {
+			return
+		}
+		stale = append(stale, filepath.Join(dirInfo.absDir, relPath))
+	}, nil)
+	if report.Err != nil {
+		return report
+	}
+	// The stale indexes are rebuilt even if their files are unchanged.
+	for _, path := range stale {
+		c.recordContentHash(directory, path, "")
+	}
+	c.addScannedFiles(ctx, &report, directory, stale, nil)
+	sort.Strings(report.Added)
+	if report.Err = c.saveFileIssues(directory); report.Err != nil {
+		return report
+	}
+	if report.Err = c.saveContentHashes(directory); report.Err != nil {
+		return report
+	}
+	report.Err = ctx.Err()
+	return report
+}
+
+// PeriodicReindexStale reconciles the indexes of `directories` every few hours
+// with `ReindexStale`, until the client is shut down, `ctx` is done or all the
+// `directories` are removed.  `onReindex` is called with the report of each
+// pass, except the passes interrupted by the removal of their directory.
+func (c *Client) PeriodicReindexStale(ctx context.Context, directories []string, onReindex func(IndexReport)) {
+	dirInfos := c.trackDirectories(directories)
+	for {
+		if !c.waitTracked(ctx, dirInfos, reindexStaleInterval) {
+			return
+		}
+		for i, directory := range directories {
+			if c.isShutdown() || ctx.Err() != nil {
+				return
+			}
+			if dirInfos[i].isRemoved() {
+				continue
+			}
+			report := c.ReindexStale(ctx, directory)
+			if !dirInfos[i].isRemoved() {
+				onReindex(report)
+			}
+		}
+	}
+}
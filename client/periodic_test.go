@@ -0,0 +1,443 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestIndexUpdatedFiles tests the `IndexUpdatedFiles` function.  Checks that
+// only the non-hidden files modified since the last scan are added, including
+// the ones updated in place within unchanged subdirectories.
+func TestIndexUpdatedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIndexUpdatedFiles")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+
+	clock := clockwork.NewFakeClockAt(time.Now())
+	cli, err := createClientWithClock(context.Background(), newMemoryServerClient(), clock, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	defer cli.Shutdown()
+
+	subDir := filepath.Join(dir, "subdir")
+	hiddenDir := filepath.Join(dir, ".hidden")
+	for _, d := range []string{subDir, hiddenDir} {
+		if err := os.Mkdir(d, 0777); err != nil {
+			t.Fatalf("error when creating a test subdirectory: %s", err)
+		}
+	}
+	writeFile := func(pathname string, modTime time.Time) {
+		if err := ioutil.WriteFile(pathname, []byte("some content "+modTime.String()), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := os.Chtimes(pathname, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(subDir, "file2")
+	writeFile(file1, clock.Now())
+	writeFile(file2, clock.Now())
+	writeFile(filepath.Join(dir, ".hiddenFile"), clock.Now())
+	writeFile(filepath.Join(hiddenDir, "file3"), clock.Now())
+
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil {
+		t.Fatalf("error when indexing the files: %s", report.Err)
+	}
+	if expected := []string{file1, file2}; !reflect.DeepEqual(expected, report.Added) {
+		t.Fatalf("incorrect files added: expected %s actual %s", expected, report.Added)
+	}
+
+	clock.Advance(time.Minute)
+	report = cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != 0 {
+		t.Fatalf("unchanged files added: %s, %v", report.Added, report.Err)
+	}
+
+	writeFile(file2, clock.Now().Add(time.Second))
+	if err := os.Chtimes(subDir, clock.Now().Add(-time.Hour), clock.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+	clock.Advance(time.Minute)
+	report = cli.IndexUpdatedFiles(context.Background(), dir)
+	if expected := []string{file2}; report.Err != nil || !reflect.DeepEqual(expected, report.Added) {
+		t.Fatalf("incorrect files added: expected %s actual %s", expected, report.Added)
+	}
+}
+
+// TestIndexUpdatedFilesDeleted tests the detection of deleted files by
+// `IndexUpdatedFiles`.  Checks that the indexes of the files removed between
+// two scans, including within a removed subdirectory, are deleted, and that
+// they no longer show up in the search results.
+func TestIndexUpdatedFilesDeleted(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	subDir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subDir, 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(dir, "file2")
+	file3 := filepath.Join(subDir, "file3")
+	for _, pathname := range []string{file1, file2, file3} {
+		if err := ioutil.WriteFile(pathname, []byte("common"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 || len(report.Deleted) != 0 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+
+	if err := os.Remove(file1); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	if err := os.RemoveAll(subDir); err != nil {
+		t.Fatalf("error when removing test subdirectory: %s", err)
+	}
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != 0 {
+		t.Fatalf("incorrect second scan: %+v", report)
+	}
+	if expected := []string{file1, file3}; !reflect.DeepEqual(expected, report.Deleted) {
+		t.Fatalf("incorrect files deleted: expected %s actual %s", expected, report.Deleted)
+	}
+	actual, err := cli.SearchWord(context.Background(), dir, "common")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{file2}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected %s actual %s", expected, actual)
+	}
+
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Deleted) != 0 {
+		t.Fatalf("files deleted twice: %+v", report)
+	}
+}
+
+// TestIndexUpdatedFilesRekeyed tests the detection of rekeys by
+// `IndexUpdatedFiles`.  Checks that a file updated after a rekey is indexed
+// with the new key generation by the next scan, without waiting for the
+// periodic check.
+func TestIndexUpdatedFilesRekeyed(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("before"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+
+	writeTestKbfsStatus(t, dir, 2)
+	modTime := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(pathname, []byte("after"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := os.Chtimes(pathname, modTime, modTime); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect second scan: %+v", report)
+	}
+	if info, err := cli.GetDocumentInfo(context.Background(), dir, pathname); err != nil || info.KeyGen != 2 {
+		t.Fatalf("file not indexed with the new key generation: %+v, %v", info, err)
+	}
+}
+
+// TestIndexUpdatedFilesRenamed tests the detection of renamed files by
+// `IndexUpdatedFiles`.  Checks that a file moved within the directory has its
+// index renamed instead of being indexed again, that the files appearing with
+// an old modification time are still indexed, and that ambiguous moves fall
+// back to a deletion and an addition.
+func TestIndexUpdatedFilesRenamed(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	subDir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subDir, 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	writeFile := func(pathname, content string, modTime time.Time) {
+		if err := ioutil.WriteFile(pathname, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := os.Chtimes(pathname, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	moved := filepath.Join(dir, "moved")
+	twin1 := filepath.Join(dir, "twin1")
+	twin2 := filepath.Join(dir, "twin2")
+	writeFile(moved, "apple", time.Now())
+	writeFile(twin1, "banana", modTime)
+	writeFile(twin2, "cherry", modTime)
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+
+	movedCurr := filepath.Join(subDir, "moved")
+	if err := os.Rename(moved, movedCurr); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	// Both twins share the same size and modification time, so their
+	// moves cannot be told apart.
+	twin1Curr := filepath.Join(subDir, "twin1")
+	twin2Curr := filepath.Join(subDir, "twin2")
+	if err := os.Rename(twin1, twin1Curr); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	if err := os.Rename(twin2, twin2Curr); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	copied := filepath.Join(dir, "copied")
+	writeFile(copied, "durian", modTime)
+
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil {
+		t.Fatalf("error when indexing the files: %s", report.Err)
+	}
+	if expected := map[string]string{moved: movedCurr}; !reflect.DeepEqual(expected, report.Renamed) {
+		t.Fatalf("incorrect files renamed: expected %s actual %s", expected, report.Renamed)
+	}
+	if expected := []string{copied, twin1Curr, twin2Curr}; !reflect.DeepEqual(expected, report.Added) {
+		t.Fatalf("incorrect files added: expected %s actual %s", expected, report.Added)
+	}
+	if expected := []string{twin1, twin2}; !reflect.DeepEqual(expected, report.Deleted) {
+		t.Fatalf("incorrect files deleted: expected %s actual %s", expected, report.Deleted)
+	}
+	for word, expected := range map[string][]string{"apple": {movedCurr}, "banana": {twin1Curr}, "durian": {copied}} {
+		actual, err := cli.SearchWord(context.Background(), dir, word)
+		if err != nil {
+			t.Fatalf("error when searching word: %s", err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("incorrect search result for %q: expected %s actual %s", word, expected, actual)
+		}
+	}
+}
+
+// failingWriteServerClient is an in-memory server on which the index writes
+// can be made to silently fail.
+type failingWriteServerClient struct {
+	*memoryServerClient
+	dropWrites bool
+}
+
+func (c *failingWriteServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	if c.dropWrites {
+		return sserver1.WriteResult{}, nil
+	}
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestReindexStale tests the `ReindexStale` function.  Checks that the files
+// whose upload has been lost, or that have been modified after their index was
+// written, are re-added regardless of the time of the last scan, and that the
+// up-to-date files are left alone.
+func TestReindexStale(t *testing.T) {
+	server := &failingWriteServerClient{memoryServerClient: newMemoryServerClient()}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	upToDate := filepath.Join(dir, "upToDate")
+	lost := filepath.Join(dir, "lost")
+	modified := filepath.Join(dir, "modified")
+	for _, pathname := range []string{upToDate, lost, modified} {
+		if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		modTime := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(pathname, modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	for _, pathname := range []string{upToDate, modified} {
+		if err := cli.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	server.dropWrites = true
+	if err := cli.AddFile(context.Background(), dir, lost); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+	server.dropWrites = false
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(modified, modTime, modTime); err != nil {
+		t.Fatalf("error when setting the modification time: %s", err)
+	}
+
+	report := cli.ReindexStale(context.Background(), dir)
+	if report.Err != nil {
+		t.Fatalf("error when reindexing the files: %s", report.Err)
+	}
+	sort.Strings(report.Added)
+	if expected := []string{lost, modified}; !reflect.DeepEqual(expected, report.Added) {
+		t.Fatalf("incorrect files reindexed: expected %s actual %s", expected, report.Added)
+	}
+	if _, err := cli.GetDocumentInfo(context.Background(), dir, lost); err != nil {
+		t.Fatalf("lost upload not recovered: %s", err)
+	}
+}
+
+// TestSetScanInterval tests the `SetScanInterval` function.  Checks that
+// `PeriodicAdd` waits for the configured interval between two scans, and that
+// a non-positive interval restores the default.
+func TestSetScanInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSetScanInterval")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKbfsStatus(t, dir, 1)
+
+	clock := clockwork.NewFakeClockAt(time.Now())
+	cli, err := createClientWithClock(context.Background(), newMemoryServerClient(), clock, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+	cli.SetScanInterval(0)
+	if cli.scanInterval != defaultScanInterval {
+		t.Fatalf("incorrect default scan interval: %s", cli.scanInterval)
+	}
+	cli.SetScanInterval(time.Hour)
+
+	scans := make(chan IndexReport, 10)
+	done := make(chan struct{})
+	go func() {
+		cli.PeriodicAdd(context.Background(), []string{dir}, func(report IndexReport) {
+			scans <- report
+		})
+		close(done)
+	}()
+	<-scans
+
+	// Waits for both the add and the key generation check loops.
+	clock.BlockUntil(2)
+	clock.Advance(59 * time.Minute)
+	clock.BlockUntil(2)
+	select {
+	case <-scans:
+		t.Fatalf("directory scanned before the interval elapsed")
+	default:
+	}
+	clock.Advance(time.Minute)
+	<-scans
+
+	cli.Shutdown()
+	<-done
+}
+
+// TestPeriodicAddShutdown tests the shutdown of `PeriodicAdd`.  Checks that a
+// scan in progress at shutdown completes and records its time, and that the
+// remaining directories are not scanned.
+func TestPeriodicAddShutdown(t *testing.T) {
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "TestPeriodicAddShutdown")
+		if err != nil {
+			t.Fatalf("error when creating the test client directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		writeTestKbfsStatus(t, dir, 1)
+		dirs = append(dirs, dir)
+	}
+	cli, err := createClientWithClient(context.Background(), newMemoryServerClient(), dirs, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+
+	var scanned []string
+	cli.PeriodicAdd(context.Background(), dirs, func(report IndexReport) {
+		if report.Err != nil {
+			t.Fatalf("error when scanning the directory: %s", report.Err)
+		}
+		scanned = append(scanned, report.Directory)
+		cli.Shutdown()
+	})
+	if !reflect.DeepEqual(dirs[:1], scanned) {
+		t.Fatalf("incorrect directories scanned: expected %s actual %s", dirs[:1], scanned)
+	}
+	if lastIndexed, err := readLastScan(dirs[0]); err != nil || lastIndexed.IsZero() {
+		t.Fatalf("time of the scan not recorded: %s, %v", lastIndexed, err)
+	}
+}
+
+// cancelingServerClient is an in-memory server honoring the cancellation of
+// the contexts of the index writes and searches, which cancels `cancel` on the
+// first index write.
+type cancelingServerClient struct {
+	*memoryServerClient
+	cancel context.CancelFunc
+}
+
+func (c *cancelingServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return sserver1.WriteResult{}, err
+	}
+	defer c.cancel()
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+func (c *cancelingServerClient) SearchWord(ctx context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.memoryServerClient.SearchWord(ctx, arg)
+}
+
+// TestIndexUpdatedFilesCanceled tests the cancellation of the context of
+// `IndexUpdatedFiles`.  Checks that the scan stops adding files once its
+// context is canceled and returns the error of the context, that a search
+// with the canceled context fails, and that the next scan adds the files left.
+func TestIndexUpdatedFilesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := &cancelingServerClient{memoryServerClient: newMemoryServerClient(), cancel: cancel}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	cli.SetIndexWorkers(1)
+	for _, name := range []string{"file1", "file2", "file3"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("canceled content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+
+	report := cli.IndexUpdatedFiles(ctx, dir)
+	if report.Err != context.Canceled || len(report.Added) != 1 {
+		t.Fatalf("scan not stopped by the cancellation: %v, %s", report.Err, report.Added)
+	}
+	if _, err := cli.SearchWord(ctx, dir, "canceled"); err != context.Canceled {
+		t.Fatalf("search not failed by the cancellation: %v", err)
+	}
+
+	report = cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != 2 {
+		t.Fatalf("files left by the canceled scan not added: %v, %s", report.Err, report.Added)
+	}
+	results, err := cli.SearchWord(context.Background(), dir, "canceled")
+	if err != nil || len(results) != 3 {
+		t.Fatalf("incorrect search results: %s, %v", results, err)
+	}
+}
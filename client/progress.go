@@ -0,0 +1,99 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ScanProgress describes the progress of the scan of a client directory in
+// progress.
+type ScanProgress struct {
+	Directory  string    // The absolute path of the directory scanned.
+	Start      time.Time // The time the scan started.
+	Discovered int       // The number of files found to be indexed so far.
+	Processed  int       // The number of files indexed, skipped or failed so far.
+	Bytes      int64     // The number of bytes of the files processed so far.
+}
+
+// Remaining returns the number of files found to be indexed but not processed
+// yet.
+func (p ScanProgress) Remaining() int {
+	return p.Discovered - p.Processed
+}
+
+// BytesPerSecond returns the rate the files have been processed at between
+// the start of the scan and `now`.
+func (p ScanProgress) BytesPerSecond(now time.Time) float64 {
+	elapsed := now.Sub(p.Start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / elapsed
+}
+
+// startProgress records that a scan of `directory` has started, and returns
+// its progress to be passed to `endProgress`.  The progress of a concurrent
+// scan of `directory` is superseded.
+func (c *Client) startProgress(directory string) *ScanProgress {
+	directory, _ = filepath.Abs(directory)
+	progress := &ScanProgress{Directory: directory, Start: c.clock.Now()}
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	c.progress[directory] = progress
+	return progress
+}
+
+// endProgress records that the scan of `directory` with `progress` has ended.
+func (c *Client) endProgress(directory string, progress *ScanProgress) {
+	directory, _ = filepath.Abs(directory)
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	if c.progress[directory] == progress {
+		delete(c.progress, directory)
+	}
+}
+
+// updateProgress adds `discovered` files found to be indexed, and the file at
+// `processed` if not empty, to the progress of the scan of `directory`.  Does
+// nothing if no scan of `directory` is in progress.
+func (c *Client) updateProgress(directory string, discovered int, processed string) {
+	var size int64
+	if processed != "" {
+		if info, err := os.Stat(processed); err == nil {
+			size = info.Size()
+		}
+	}
+	directory, _ = filepath.Abs(directory)
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	progress, ok := c.progress[directory]
+	if !ok {
+		return
+	}
+	progress.Discovered += discovered
+	if processed != "" {
+		progress.Processed++
+		progress.Bytes += size
+	}
+}
+
+// GetScanProgress returns the progress of the scan of `directory` in
+// progress, and false if `directory` is not being scanned.
+func (c *Client) GetScanProgress(directory string) (ScanProgress, bool, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return ScanProgress{}, false, err
+	}
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	progress, ok := c.progress[dirInfo.absDir]
+	if !ok {
+		return ScanProgress{}, false, nil
+	}
+	return *progress, true, nil
+}
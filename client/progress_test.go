@@ -0,0 +1,75 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// blockingWriteServerClient is an in-memory server on which each index write
+// waits to be released.
+type blockingWriteServerClient struct {
+	*memoryServerClient
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingWriteServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	c.writing <- struct{}{}
+	<-c.release
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestGetScanProgress tests the `GetScanProgress` function.  Checks that the
+// files discovered and processed by a scan are reported while the scan is in
+// progress, and that nothing is reported once it is done.
+func TestGetScanProgress(t *testing.T) {
+	server := &blockingWriteServerClient{
+		memoryServerClient: newMemoryServerClient(),
+		writing:            make(chan struct{}),
+		release:            make(chan struct{}),
+	}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	const numFiles = 3
+	for i := 0; i < numFiles; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("0123456789"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if _, scanning, err := cli.GetScanProgress(dir); err != nil || scanning {
+		t.Fatalf("scan reported before it started: %t, %v", scanning, err)
+	}
+
+	reports := make(chan IndexReport)
+	go func() {
+		reports <- cli.IndexUpdatedFiles(context.Background(), dir)
+	}()
+	for i := 0; i < numFiles; i++ {
+		<-server.writing
+		progress, scanning, err := cli.GetScanProgress(dir)
+		if err != nil || !scanning {
+			t.Fatalf("scan not reported: %t, %v", scanning, err)
+		}
+		if progress.Discovered != numFiles || progress.Processed != i || progress.Remaining() != numFiles-i || progress.Bytes != int64(10*i) {
+			t.Fatalf("incorrect progress after %d files: %+v", i, progress)
+		}
+		server.release <- struct{}{}
+	}
+	if report := <-reports; report.Err != nil || len(report.Added) != numFiles {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	if _, scanning, err := cli.GetScanProgress(dir); err != nil || scanning {
+		t.Fatalf("scan reported after it ended: %t, %v", scanning, err)
+	}
+}
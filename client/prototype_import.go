@@ -0,0 +1,170 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+const (
+	// prototypeMetadataFile is the name of the file in the mount point of a
+	// server of the prototype storing its metadata.
+	prototypeMetadataFile = "serverMD"
+	// prototypeLookupTableFile is the name of the file in the mount point of
+	// a server of the prototype mapping the document IDs to the filenames.
+	prototypeLookupTableFile = "lookupTable"
+)
+
+// prototypeServer is the metadata of a server of the prototype, as written to
+// its mount point.
+type prototypeServer struct {
+	numFiles  int      // The number of files stored, numbered from 0.
+	keyHalves [][]byte // The server-side halves of the master secrets of the clients.
+	lenMS     int      // The length of the master secrets.
+}
+
+// loadPrototypeServer reads the metadata of the server of the prototype whose
+// mount point is `directory`.  The metadata is a stream of values encoded with
+// gob one after the other, in the order the prototype writes them.
+func loadPrototypeServer(directory string) (*prototypeServer, error) {
+	input, err := os.Open(filepath.Join(directory, prototypeMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	var s prototypeServer
+	var serverDir string
+	var salts [][]byte
+	var size uint64
+	var latency time.Duration
+	var bandwidth int
+	dec := gob.NewDecoder(input)
+	for _, v := range []interface{}{&serverDir, &s.numFiles, &salts, &s.keyHalves, &s.lenMS, &size, &latency, &bandwidth} {
+		if err := dec.Decode(v); err != nil {
+			return nil, fmt.Errorf("invalid prototype server metadata: %s", err)
+		}
+	}
+	return &s, nil
+}
+
+// ownedBy returns whether `masterSecret` is the master secret of one of the
+// clients of the prototype server, each of which derives it from its client
+// number and its key half.
+func (s *prototypeServer) ownedBy(masterSecret []byte) bool {
+	for i, keyHalf := range s.keyHalves {
+		cksum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		if len(masterSecret) == s.lenMS && len(keyHalf) == s.lenMS && s.lenMS <= len(cksum) && bytes.Equal(libsearch.XorBytes(masterSecret, keyHalf, s.lenMS), cksum[:s.lenMS]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportPrototype imports the corpus stored by a server of the prototype at
+// its mount point `prototypeDir` into the client directory `directory`, so
+// that the files indexed with the prototype are not lost.  `masterSecret` is
+// the master secret of the owner of the corpus, i.e. of one of the clients of
+// the prototype server, and proves the ownership of the corpus.  The prototype
+// stored the files as is, numbered by document ID, along with a lookup table
+// of their names: each file is written to `directory` under its name, and
+// indexed and uploaded to the search server with the keys of the TLF of
+// `directory`, registered with the parameters of the client, as the indexes of
+// the prototype are bound to its own keys.  The files already in `directory`
+// with the same name but another content are left alone and counted as
+// skipped.  The report lists the files indexed as added.
+func (c *Client) ImportPrototype(ctx context.Context, prototypeDir, directory string, masterSecret []byte) IndexReport {
+	report := IndexReport{Directory: directory, Start: c.clock.Now()}
+	defer func() {
+		report.Elapsed = c.clock.Since(report.Start)
+	}()
+	defer c.endProgress(directory, c.startProgress(directory))
+
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	server, err := loadPrototypeServer(prototypeDir)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	if !server.ownedBy(masterSecret) {
+		report.Err = errors.New("the master secret does not match any client of the prototype server")
+		return report
+	}
+	tableJSON, err := ioutil.ReadFile(filepath.Join(prototypeDir, prototypeLookupTableFile))
+	if os.IsNotExist(err) {
+		// No file has ever been added to the prototype server.
+		return report
+	} else if err != nil {
+		report.Err = err
+		return report
+	}
+	var table map[string]string
+	if report.Err = json.Unmarshal(tableJSON, &table); report.Err != nil {
+		return report
+	}
+
+	docIDs := make([]int, 0, len(table))
+	for docID := range table {
+		n, err := strconv.Atoi(docID)
+		if err != nil || n < 0 || n >= server.numFiles {
+			report.Err = fmt.Errorf("invalid document ID \"%s\" in the prototype lookup table", docID)
+			return report
+		}
+		docIDs = append(docIDs, n)
+	}
+	sort.Ints(docIDs)
+	var paths []string
+	for _, docID := range docIDs {
+		name := table[strconv.Itoa(docID)]
+		if name == "" || name != filepath.Base(name) || name[0] == '.' {
+			report.Err = fmt.Errorf("invalid filename \"%s\" in the prototype lookup table", name)
+			return report
+		}
+		content, err := ioutil.ReadFile(filepath.Join(prototypeDir, strconv.Itoa(docID)))
+		if err != nil {
+			report.Err = err
+			return report
+		}
+		path := filepath.Join(dirInfo.absDir, name)
+		existing, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil && !bytes.Equal(existing, content):
+			report.Skipped++
+			continue
+		case os.IsNotExist(err):
+			if report.Err = ioutil.WriteFile(path, content, 0666); report.Err != nil {
+				return report
+			}
+		case err != nil:
+			report.Err = err
+			return report
+		}
+		paths = append(paths, path)
+	}
+	c.addScannedFiles(ctx, &report, directory, paths, nil)
+	sort.Strings(report.Added)
+	if report.Err = c.saveFileIssues(directory); report.Err != nil {
+		return report
+	}
+	report.Err = c.saveContentHashes(directory)
+	return report
+}
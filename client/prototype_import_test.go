@@ -0,0 +1,91 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+// writeTestPrototype writes the mount point of a server of the prototype with
+// two clients to `dir`, storing the `files` with their `contents`, and returns
+// the master secret of its first client.
+func writeTestPrototype(t *testing.T, dir string, files []string, contents []string) []byte {
+	const lenMS = 8
+	masterSecret := []byte("8 secret")
+	var keyHalves [][]byte
+	for i := 0; i < 2; i++ {
+		cksum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		keyHalves = append(keyHalves, libsearch.XorBytes(masterSecret, cksum[:], lenMS))
+	}
+	metadata, err := os.Create(filepath.Join(dir, prototypeMetadataFile))
+	if err != nil {
+		t.Fatalf("error when writing the prototype metadata: %s", err)
+	}
+	defer metadata.Close()
+	enc := gob.NewEncoder(metadata)
+	for _, v := range []interface{}{dir, len(files), [][]byte{[]byte("salt")}, keyHalves, lenMS, uint64(1000), 100 * time.Millisecond, 1024} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("error when writing the prototype metadata: %s", err)
+		}
+	}
+	table := make(map[string]string)
+	for i, name := range files {
+		table[strconv.Itoa(i)] = name
+		if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte(contents[i]), 0666); err != nil {
+			t.Fatalf("error when writing a prototype file: %s", err)
+		}
+	}
+	tableJSON, err := json.Marshal(table)
+	if err != nil {
+		t.Fatalf("error when writing the prototype lookup table: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, prototypeLookupTableFile), tableJSON, 0666); err != nil {
+		t.Fatalf("error when writing the prototype lookup table: %s", err)
+	}
+	return masterSecret
+}
+
+// TestImportPrototype tests the `ImportPrototype` function.  Checks that the
+// files of the prototype corpus are written to the client directory and found
+// by the searches, that a file of the directory with another content is left
+// alone, and that a wrong master secret is rejected.
+func TestImportPrototype(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	prototypeDir, err := ioutil.TempDir("", "prototype")
+	if err != nil {
+		t.Fatalf("error when creating the prototype directory: %s", err)
+	}
+	defer os.RemoveAll(prototypeDir)
+	masterSecret := writeTestPrototype(t, prototypeDir, []string{"notes", "todo", "draft"}, []string{"alpha beta", "alpha gamma", "prototype draft"})
+	if err := ioutil.WriteFile(filepath.Join(dir, "draft"), []byte("local draft"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	if report := cli.ImportPrototype(context.Background(), prototypeDir, dir, []byte("a secret")); report.Err == nil {
+		t.Fatalf("wrong master secret accepted")
+	}
+	report := cli.ImportPrototype(context.Background(), prototypeDir, dir, masterSecret)
+	if report.Err != nil || len(report.Added) != 2 || report.Skipped != 1 {
+		t.Fatalf("incorrect import: %+v", report)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "draft")); err != nil || string(content) != "local draft" {
+		t.Fatalf("local file overwritten: %q, %v", content, err)
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "alpha"); err != nil || len(results) != 2 {
+		t.Fatalf("incorrect results after the import: %v, %v", results, err)
+	}
+}
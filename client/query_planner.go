@@ -0,0 +1,82 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+)
+
+const (
+	// maxTermStats bounds the number of terms whose number of results is
+	// kept per directory.  The counts are forgotten at once when reached.
+	maxTermStats = 4096
+	// nearTermEstimate is the number of results assumed for the words joined
+	// by "NEAR" never searched alone, as their co-occurrence is rarer than
+	// any of them.
+	nearTermEstimate = 1
+	// wordTermEstimate is the number of results assumed for the words never
+	// searched alone.
+	wordTermEstimate = 100
+	// metadataTermEstimate is the number of results assumed for the
+	// metadata keywords never searched alone, as each of them is shared by
+	// many files, e.g. "size:small".
+	metadataTermEstimate = 10000
+)
+
+// termStats holds the number of results of the terms last searched alone in a
+// directory, as the estimates of their selectivity.
+type termStats struct {
+	lock   sync.Mutex
+	counts map[string]int // The number of results of each term.
+}
+
+// record records that the search for `term` alone has returned `count`
+// results.
+func (s *termStats) record(term string, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.counts == nil || len(s.counts) >= maxTermStats {
+		s.counts = make(map[string]int)
+	}
+	s.counts[term] = count
+}
+
+// estimate returns the estimated number of results of `term`: the number of
+// results of its last search alone if any, or the default one of its kind.
+func (s *termStats) estimate(term string) int {
+	s.lock.Lock()
+	count, ok := s.counts[term]
+	s.lock.Unlock()
+	if ok {
+		return count
+	}
+	if _, _, ok := libsearch.ParseNearKeyword(term); ok {
+		return nearTermEstimate
+	} else if _, ok := libsearch.ParseMetadataKeyword(term); ok {
+		return metadataTermEstimate
+	}
+	return wordTermEstimate
+}
+
+// planConjunction returns the `terms` of a conjunctive query in the order they
+// should be evaluated in: the most selective first, as estimated by the number
+// of results of their last search alone in the directory of `dirInfo`, or by
+// their kind, so that the search server rules out most of the documents with
+// the first term and skips the other ones for them.  The terms estimated
+// equally keep their order.
+func planConjunction(dirInfo *DirectoryInfo, terms []string) []string {
+	estimates := make(map[string]int, len(terms))
+	for _, term := range terms {
+		estimates[term] = dirInfo.termStats.estimate(term)
+	}
+	planned := append([]string(nil), terms...)
+	sort.SliceStable(planned, func(i, j int) bool {
+		return estimates[planned[i]] < estimates[planned[j]]
+	})
+	return planned
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+// TestPlanConjunction tests the `planConjunction` function.  Checks that the
+// terms are ordered by the number of results of their last search alone, or
+// by the default estimate of their kind.
+func TestPlanConjunction(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+	dirInfo.termStats.record("common", 50)
+	dirInfo.termStats.record("rare", 2)
+	near := libsearch.NearKeyword("alpha", "beta")
+
+	planned := planConjunction(dirInfo, []string{"size:small", "common", "unknown", "rare", near})
+	if expected := []string{near, "rare", "common", "unknown", "size:small"}; !reflect.DeepEqual(planned, expected) {
+		t.Fatalf("incorrect plan: expected %v, actual %v", expected, planned)
+	}
+}
+
+// TestSearchQueryShortCircuit tests the `SearchQuery` function with a term
+// known to match no file.  Checks that the query is answered without being
+// sent to the server, and that the term stats are kept up to date by the
+// searches.
+func TestSearchQueryShortCircuit(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	cli.SetResultCache(16)
+	ctx := context.Background()
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("present words"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if err := cli.AddFile(ctx, dir, pathname); err != nil {
+		t.Fatalf("error when adding the file: %s", err)
+	}
+
+	if results, err := cli.SearchWord(ctx, dir, "missing"); err != nil || len(results) != 0 {
+		t.Fatalf("incorrect results: %v, %v", results, err)
+	}
+	if results, err := cli.SearchQuery(ctx, dir, "present missing"); err != nil || len(results) != 0 {
+		t.Fatalf("incorrect results: %v, %v", results, err)
+	}
+	if server.conjunctions != 0 {
+		t.Fatalf("conjunction with a term matching no file sent to the server")
+	}
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+	if count := dirInfo.termStats.estimate("missing"); count != 0 {
+		t.Fatalf("incorrect term stats: %d", count)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/net/context"
+)
+
+// QueryAnomaly describes an unusually high volume of search queries, which
+// might come from malware exfiltrating data through the search server.
+type QueryAnomaly struct {
+	Time       time.Time     // The time the anomaly was detected.
+	NumQueries int           // The number of queries within the window.
+	Window     time.Duration // The sliding window the queries are counted in.
+}
+
+// queryThrottle limits the number of queries within a sliding window.  As each
+// query leaks information to the search server, the queries beyond the limit
+// are delayed until the window frees up, and an alert is raised once per
+// burst.
+type queryThrottle struct {
+	clock     clockwork.Clock    // The clock the window slides with.
+	limit     int                // The maximum number of queries within the window.
+	window    time.Duration      // The length of the sliding window.
+	onAnomaly func(QueryAnomaly) // Called when a burst of queries hits the limit.
+
+	lock    sync.Mutex  // Serializes the throttled queries and protects the fields below.
+	times   []time.Time // The times of the queries within the window, in increasing order.
+	alerted bool        // Whether an alert has been raised for the current burst.
+}
+
+// newQueryThrottle creates a `queryThrottle` allowing `limit` queries every
+// `window`.
+func newQueryThrottle(clock clockwork.Clock, limit int, window time.Duration, onAnomaly func(QueryAnomaly)) *queryThrottle {
+	return &queryThrottle{
+		clock:     clock,
+		limit:     limit,
+		window:    window,
+		onAnomaly: onAnomaly,
+	}
+}
+
+// prune drops the queries that have left the window ending at `now`.
+func (q *queryThrottle) prune(now time.Time) {
+	i := 0
+	for i < len(q.times) && !q.times[i].Add(q.window).After(now) {
+		i++
+	}
+	q.times = q.times[i:]
+}
+
+// wait records `numQueries` queries, blocking until they fit in the window.
+// More queries than the limit are counted as the limit.  Returns the error of
+// `ctx`, without recording the queries, if it is done before they fit.
+func (q *queryThrottle) wait(ctx context.Context, numQueries int) error {
+	if numQueries > q.limit {
+		numQueries = q.limit
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		now := q.clock.Now()
+		q.prune(now)
+		if len(q.times)+numQueries <= q.limit {
+			if len(q.times) == 0 {
+				// The burst is over.
+				q.alerted = false
+			}
+			for i := 0; i < numQueries; i++ {
+				q.times = append(q.times, now)
+			}
+			return nil
+		}
+		if !q.alerted {
+			q.alerted = true
+			if q.onAnomaly != nil {
+				q.onAnomaly(QueryAnomaly{Time: now, NumQueries: len(q.times) + numQueries, Window: q.window})
+			}
+		}
+		select {
+		case <-q.clock.After(q.times[len(q.times)+numQueries-q.limit-1].Add(q.window).Sub(now)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
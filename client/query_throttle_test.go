@@ -0,0 +1,93 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/net/context"
+)
+
+// TestQueryThrottle tests the `queryThrottle` type.  Checks that the queries
+// within the limit go through, that the ones beyond it wait for the window to
+// slide, and that a single alert is raised per burst.
+func TestQueryThrottle(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	var anomalies []QueryAnomaly
+	throttle := newQueryThrottle(clock, 4, time.Minute, func(anomaly QueryAnomaly) {
+		anomalies = append(anomalies, anomaly)
+	})
+
+	throttle.wait(context.Background(), 3)
+	clock.Advance(10 * time.Second)
+	throttle.wait(context.Background(), 1)
+	if len(anomalies) != 0 {
+		t.Fatalf("alert raised within the limit")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		throttle.wait(context.Background(), 2)
+		throttle.wait(context.Background(), 2)
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	if len(anomalies) != 1 || anomalies[0].NumQueries != 6 {
+		t.Fatalf("incorrect alerts: %v", anomalies)
+	}
+
+	// The first 3 queries leave the window, leaving room for 2 more, but
+	// not for 2 others.
+	clock.Advance(50 * time.Second)
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatalf("query not throttled")
+	default:
+	}
+	clock.Advance(10 * time.Second)
+	<-done
+	if len(anomalies) != 1 {
+		t.Fatalf("more than one alert raised for a single burst: %v", anomalies)
+	}
+
+	// Once the burst is over, a new one raises a new alert.
+	clock.Advance(time.Hour)
+	throttle.wait(context.Background(), 4)
+	go throttle.wait(context.Background(), 1)
+	clock.BlockUntil(1)
+	if len(anomalies) != 2 {
+		t.Fatalf("no alert raised for a new burst: %v", anomalies)
+	}
+	clock.Advance(time.Minute)
+}
+
+// TestQueryThrottleCancel tests the `wait` function of `queryThrottle` when its
+// context is canceled while waiting.  Checks that the wait ends with the error
+// of the context without recording the queries.
+func TestQueryThrottleCancel(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	throttle := newQueryThrottle(clock, 2, time.Minute, nil)
+	if err := throttle.wait(context.Background(), 2); err != nil {
+		t.Fatalf("error when waiting within the limit: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- throttle.wait(ctx, 1)
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("incorrect error once canceled: %v", err)
+	}
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	if len(throttle.times) != 2 {
+		t.Fatalf("queries of a canceled wait recorded: %d queries", len(throttle.times))
+	}
+}
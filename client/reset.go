@@ -0,0 +1,99 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// resetPollInterval is the interval between two checks for the end of the scan
+// in progress of a directory being reset.
+const resetPollInterval = 100 * time.Millisecond
+
+// waitScanEnd waits for the scan of `absDir` in progress, if any, to end.
+func (c *Client) waitScanEnd(absDir string) {
+	for {
+		c.progressLock.Lock()
+		_, scanning := c.progress[absDir]
+		c.progressLock.Unlock()
+		if !scanning {
+			return
+		}
+		c.clock.Sleep(resetPollInterval)
+	}
+}
+
+// removeLocalState removes the local state of `absDir`: its indexed state, the
+// schema of its indexes and the progress of their migration, and the issues
+// and the content hashes of its files and the cached search results within
+// `stateDir` if set, so that all its files are indexed again.  If `dropped`,
+// as its indexes are gone from the search server, the dummy indexes, the word
+// set digests and the operations queued while the search server was
+// unreachable go as well.
+func removeLocalState(stateDir, absDir string, dropped bool) error {
+	if err := removeIndexState(absDir); err != nil {
+		return err
+	}
+	// The indexes left are overwritten with the current schema as the files
+	// are indexed again.
+	paths := []string{filepath.Join(absDir, schemaFile), filepath.Join(absDir, migrationFile)}
+	if stateDir != "" {
+		paths = append(paths, getFileIssuesPath(stateDir, absDir), getContentHashesPath(stateDir, absDir), getResultCachePath(stateDir, absDir))
+		if dropped {
+			paths = append(paths, getOfflineQueuePath(stateDir, absDir), getWordSetDigestDir(stateDir, absDir))
+		}
+	}
+	if dropped {
+		paths = append(paths, filepath.Join(absDir, dummiesFile), filepath.Join(absDir, legacyWordSetDigestDir))
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetDirectory forgets what has been indexed of `directory` so that it is
+// indexed again from scratch, e.g. after suspected corruption: the directory
+// is removed from the client as with `RemoveDirectory`, once its scan in
+// progress ends, its local state is removed, and it is added back as with
+// `AddDirectory`.  If `dropIndexes` is set, all the indexes and the
+// registration of its TLF are also dropped from the search server, so that
+// the TLF is registered anew with the index parameters of the client, e.g.
+// after a change of the false positive rate or of the number of unique words;
+// the other clients of the TLF then have to reset it as well.  Otherwise, the
+// indexes are overwritten as the files are indexed again, and the TLF keeps
+// its parameters.  Returns whether the previous client of the directory has
+// not shut down cleanly, as `AddDirectory` does.  The caller is expected to
+// kick off the indexing of the directory, e.g. with `PeriodicAdd`.
+func (c *Client) ResetDirectory(ctx context.Context, directory string, dropIndexes bool) (bool, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return false, err
+	}
+	if err := c.RemoveDirectory(ctx, dirInfo.absDir); err != nil {
+		return false, err
+	}
+	c.waitScanEnd(dirInfo.absDir)
+
+	if dropIndexes {
+		if err := c.searchCli.DropTlf(ctx, dirInfo.tlfID); err != nil {
+			return false, fmt.Errorf("cannot drop the indexes of directory %s, which is no longer indexed: %s", dirInfo.absDir, err)
+		}
+	}
+	c.issuesLock.Lock()
+	stateDir := c.stateDir
+	c.issuesLock.Unlock()
+	if err := removeLocalState(stateDir, dirInfo.absDir, dropIndexes); err != nil {
+		return false, fmt.Errorf("cannot remove the local state of directory %s, which is no longer indexed: %s", dirInfo.absDir, err)
+	}
+	return c.AddDirectory(ctx, dirInfo.absDir)
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestResetDirectory tests the `ResetDirectory` function.  Checks that all the
+// files are indexed again after a reset, that the indexes are dropped from the
+// search server only if asked to, and that the TLF is then registered anew
+// with the current parameters of the client.
+func TestResetDirectory(t *testing.T) {
+	server := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	tlfID := cli.directoryInfos[dir].tlfID
+	size := cli.directoryInfos[dir].tlfInfo.Size
+
+	if _, err := cli.ResetDirectory(context.Background(), dir, false); err != nil {
+		t.Fatalf("error when resetting the directory: %s", err)
+	}
+	if len(server.docIDs(tlfID)) != 3 {
+		t.Fatalf("indexes dropped without being asked to: %d left", len(server.docIDs(tlfID)))
+	}
+	if lastScan, err := readLastScan(dir); err != nil || !lastScan.IsZero() {
+		t.Fatalf("indexed state not removed: %s, %v", lastScan, err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("files not indexed again after the reset: %+v", report)
+	}
+
+	cli.dirParams.numUniqWords *= 2
+	if _, err := cli.ResetDirectory(context.Background(), dir, true); err != nil {
+		t.Fatalf("error when resetting the directory with its indexes: %s", err)
+	}
+	if len(server.docIDs(tlfID)) != 0 {
+		t.Fatalf("indexes not dropped: %d left", len(server.docIDs(tlfID)))
+	}
+	if cli.directoryInfos[dir].tlfInfo.Size <= size {
+		t.Fatalf("TLF not registered anew: size %d, previously %d", cli.directoryInfos[dir].tlfInfo.Size, size)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 3 {
+		t.Fatalf("files not indexed again after the drop: %+v", report)
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "content"); err != nil || len(results) != 3 {
+		t.Fatalf("incorrect results after the drop: %v, %v", results, err)
+	}
+}
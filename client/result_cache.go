@@ -0,0 +1,204 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// cachedResult is the result of a recent search of a directory.
+type cachedResult struct {
+	Key       string   `json:"key"`       // The kind of the search and the words searched for, as given by `wordCacheKey` or `queryCacheKey`.
+	Filenames []string `json:"filenames"` // The files returned by the search server.
+}
+
+// resultCache holds the results of the recent searches of a directory, as
+// enabled by `SetResultCache`.
+type resultCache struct {
+	lock    sync.Mutex
+	loaded  bool           // Whether the cache persisted in the state directory, if any, has been read.
+	entries []cachedResult // The results cached, the most recently used last.
+}
+
+// wordCacheKey is the key of the results of the search for `word` in the
+// cache.
+func wordCacheKey(word string) string {
+	return "word:" + word
+}
+
+// queryCacheKey is the key of the results of the search for the `terms` of a
+// conjunctive query in the cache.
+func queryCacheKey(terms []string) string {
+	key := "query:"
+	for _, term := range terms {
+		key += " " + term
+	}
+	return key
+}
+
+// getResultCachePath returns the path of the file persisting the encrypted
+// cache of the search results of `directory` within `stateDir`.
+func getResultCachePath(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, ".results")
+}
+
+// SetResultCache enables a cache of the results of the `entries` most recent
+// searches of each directory, none by default.  A repeated search is then
+// answered by the client alone, even while the search server is unreachable.
+// While the directories are locked, the cache is persisted in the state
+// directory, encrypted with the master secret of the TLF, so that it survives
+// a restart of the client.  The cache of a directory is dropped as soon as an
+// index of the directory is written, renamed or deleted, or a scan finds files
+// changed, e.g. by another client left to index them, so that the cached
+// results stay those of the search server.  Should be called before any
+// search.
+func (c *Client) SetResultCache(entries int) {
+	if entries < 0 {
+		entries = 0
+	}
+	c.cacheEntries = entries
+}
+
+// resultCacheSecret returns the master secret the cache of the search results
+// of the directory of `dirInfo` is encrypted with: the one of the key
+// generation the salts are encrypted with, which does not change on a rekey.
+func resultCacheSecret(dirInfo *DirectoryInfo) ([]byte, error) {
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	return fetchMasterSecret(dirInfo.absDir, getSaltsKeyGen(keyGen), dirInfo.lenMS)
+}
+
+// loadResults reads the cache of the search results of the directory of
+// `dirInfo` persisted in the state directory, if any and not yet read.  A
+// cache that cannot be read or decrypted is dropped.  Must be called with the
+// lock of the cache held.
+func (c *Client) loadResults(dirInfo *DirectoryInfo) {
+	cache := dirInfo.results
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+	c.issuesLock.Lock()
+	stateDir := c.stateDir
+	c.issuesLock.Unlock()
+	if stateDir == "" {
+		return
+	}
+	sealed, err := ioutil.ReadFile(getResultCachePath(stateDir, dirInfo.absDir))
+	if err != nil {
+		return
+	}
+	masterSecret, err := resultCacheSecret(dirInfo)
+	if err != nil {
+		return
+	}
+	entriesJSON, err := libsearch.OpenResultCache(sealed, masterSecret)
+	if err != nil {
+		return
+	}
+	var entries []cachedResult
+	if json.Unmarshal(entriesJSON, &entries) == nil {
+		cache.entries = entries
+	}
+}
+
+// saveResults persists the cache of the search results of the directory of
+// `dirInfo` in the state directory, if its directories are locked, or removes
+// it once empty.  Must be called with the lock of the cache held.
+func (c *Client) saveResults(dirInfo *DirectoryInfo) error {
+	c.issuesLock.Lock()
+	stateDir := c.stateDir
+	c.issuesLock.Unlock()
+	if stateDir == "" {
+		return nil
+	}
+	path := getResultCachePath(stateDir, dirInfo.absDir)
+	if len(dirInfo.results.entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	entriesJSON, err := json.Marshal(dirInfo.results.entries)
+	if err != nil {
+		return err
+	}
+	masterSecret, err := resultCacheSecret(dirInfo)
+	if err != nil {
+		return err
+	}
+	sealed, err := libsearch.SealResultCache(entriesJSON, masterSecret)
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(path, sealed)
+}
+
+// cachedResults returns the cached results of the search of the directory of
+// `dirInfo` with `key`, and false if not cached.
+func (c *Client) cachedResults(dirInfo *DirectoryInfo, key string) ([]string, bool) {
+	if c.cacheEntries == 0 {
+		return nil, false
+	}
+	cache := dirInfo.results
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	c.loadResults(dirInfo)
+	for i, entry := range cache.entries {
+		if entry.Key == key {
+			cache.entries = append(append(cache.entries[:i:i], cache.entries[i+1:]...), entry)
+			return append([]string{}, entry.Filenames...), true
+		}
+	}
+	return nil, false
+}
+
+// cacheResults caches the `filenames` returned by the search of the directory
+// of `dirInfo` with `key`, evicting the least recently used results beyond
+// the size of the cache.  The cache is only persisted on a best effort basis.
+func (c *Client) cacheResults(dirInfo *DirectoryInfo, key string, filenames []string) {
+	if c.cacheEntries == 0 {
+		return
+	}
+	cache := dirInfo.results
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	c.loadResults(dirInfo)
+	entries := cache.entries[:0:0]
+	for _, entry := range cache.entries {
+		if entry.Key != key {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, cachedResult{Key: key, Filenames: append([]string{}, filenames...)})
+	if len(entries) > c.cacheEntries {
+		entries = entries[len(entries)-c.cacheEntries:]
+	}
+	cache.entries = entries
+	c.saveResults(dirInfo)
+}
+
+// invalidateResults drops the cached search results of the directory of
+// `dirInfo`, as its indexes have changed.
+func (c *Client) invalidateResults(dirInfo *DirectoryInfo) {
+	if c.cacheEntries == 0 {
+		return
+	}
+	cache := dirInfo.results
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.loaded && len(cache.entries) == 0 {
+		return
+	}
+	cache.loaded = true
+	cache.entries = nil
+	c.saveResults(dirInfo)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestResultCache tests the cache of the search results.  Checks that a
+// repeated search, single-word or conjunctive, is answered without the search
+// server, that the cache is persisted encrypted in the state directory, and
+// that it is dropped once a scan finds a new file.
+func TestResultCache(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	cli, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	stateDir, err := ioutil.TempDir("", "TestResultCache")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+	if _, err := cli.LockDirectories(stateDir); err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	cli.SetResultCache(4)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "first"), []byte("cached apple banana"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect first scan: %+v", report)
+	}
+	for i := 0; i < 2; i++ {
+		if results, err := cli.SearchWord(context.Background(), dir, "cached"); err != nil || len(results) != 1 {
+			t.Fatalf("incorrect search results: %v, %v", results, err)
+		}
+		if results, err := cli.SearchQuery(context.Background(), dir, "apple banana"); err != nil || len(results) != 1 {
+			t.Fatalf("incorrect query results: %v, %v", results, err)
+		}
+	}
+	if server.wordSearches != 1 || server.conjunctions != 1 {
+		t.Fatalf("repeated searches sent to the server: %d searches, %d conjunctions", server.wordSearches, server.conjunctions)
+	}
+	sealed, err := ioutil.ReadFile(getResultCachePath(stateDir, dir))
+	if err != nil {
+		t.Fatalf("cache not persisted: %s", err)
+	}
+	if bytes.Contains(sealed, []byte("cached")) || bytes.Contains(sealed, []byte("first")) {
+		t.Fatalf("cache persisted in the clear")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "second"), []byte("cached again"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 1 {
+		t.Fatalf("incorrect second scan: %+v", report)
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "cached"); err != nil || len(results) != 2 {
+		t.Fatalf("stale results after a scan: %v, %v", results, err)
+	}
+	if server.wordSearches != 2 {
+		t.Fatalf("search after a scan not sent to the server: %d searches", server.wordSearches)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxRetryDelay bounds the delay between two attempts of an upload.
+const maxRetryDelay = time.Minute
+
+// SetUploadRetries sets the number of times the uploads, renames and deletions
+// of the indexes failing, e.g. on a restart of the search server or a network
+// blip, are retried before the file is left to the next scan.  The first retry
+// waits about `initialDelay`, and each next one twice as long as the previous
+// one, up to a minute.  No upload is retried by default.  Should be called
+// before the scans are started.
+func (c *Client) SetUploadRetries(retries int, initialDelay time.Duration) {
+	if retries < 0 {
+		retries = 0
+	}
+	c.uploadRetries = retries
+	c.retryDelay = initialDelay
+}
+
+// withRetries calls `op` until it succeeds or the retries set by
+// `SetUploadRetries` are exhausted, with exponential backoff between the
+// attempts.  The delays are randomized by up to half their length, so that the
+// clients of a restarted server do not retry in lockstep.  Stops retrying once
+// the client is shut down or `ctx` is done.  Returns the error of the last
+// attempt.
+func (c *Client) withRetries(ctx context.Context, op func() error) error {
+	err := op()
+	delay := c.retryDelay
+	for retry := 0; err != nil && retry < c.uploadRetries; retry++ {
+		wait := delay
+		if delay >= 2 {
+			wait = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+		}
+		select {
+		case <-c.clock.After(wait):
+		case <-c.shutdownCh:
+			return err
+		case <-ctx.Done():
+			return err
+		}
+		err = op()
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// flakyWriteServerClient is an in-memory server on which a number of index
+// writes fail before the next ones succeed.
+type flakyWriteServerClient struct {
+	*memoryServerClient
+	lock     sync.Mutex
+	failures int // The number of the next writes failing.
+	attempts int // The number of writes attempted.
+}
+
+func (c *flakyWriteServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	c.lock.Lock()
+	c.attempts++
+	fail := c.failures > 0
+	if fail {
+		c.failures--
+	}
+	c.lock.Unlock()
+	if fail {
+		return sserver1.WriteResult{}, errors.New("server restarting")
+	}
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestUploadRetries tests the `SetUploadRetries` function.  Checks that the
+// failed uploads are retried up to the set number of times, that they are not
+// retried by default, and that the retries stop once the client is shut down.
+func TestUploadRetries(t *testing.T) {
+	server := &flakyWriteServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("retried upload"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	server.failures = 1
+	if err := client.AddFile(context.Background(), dir, pathname); err == nil || server.attempts != 1 {
+		t.Fatalf("upload retried by default: %d attempts, %v", server.attempts, err)
+	}
+
+	client.SetUploadRetries(2, time.Millisecond)
+	server.failures, server.attempts = 2, 0
+	if err := client.AddFile(context.Background(), dir, pathname); err != nil || server.attempts != 3 {
+		t.Fatalf("upload not retried: %d attempts, %v", server.attempts, err)
+	}
+	actual, err := client.SearchWord(context.Background(), dir, "retried")
+	if err != nil {
+		t.Fatalf("error when searching word: %s", err)
+	}
+	if expected := []string{pathname}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", expected, actual)
+	}
+
+	server.failures, server.attempts = 3, 0
+	if err := client.AddFile(context.Background(), dir, pathname); err == nil || server.attempts != 3 {
+		t.Fatalf("incorrect retries: %d attempts, %v", server.attempts, err)
+	}
+
+	client.SetUploadRetries(5, time.Hour)
+	client.Shutdown()
+	server.failures, server.attempts = 1, 0
+	if err := client.AddFile(context.Background(), dir, pathname); err == nil || server.attempts != 1 {
+		t.Fatalf("upload retried after the shutdown: %d attempts, %v", server.attempts, err)
+	}
+}
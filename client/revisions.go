@@ -0,0 +1,83 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// indexRevisions tracks the revisions of the indexes of a directory last seen
+// by the client, so that the server can detect when another client has written
+// an index in between.  The last write wins, and the conflicts are counted.
+type indexRevisions struct {
+	lock      sync.Mutex
+	revisions map[sserver1.DocumentID]int64 // The revision last seen of each index.
+	conflicts int                           // The number of writes that overwrote the index of another client.
+}
+
+// newIndexRevisions creates an empty `indexRevisions`.
+func newIndexRevisions() *indexRevisions {
+	return &indexRevisions{revisions: make(map[sserver1.DocumentID]int64)}
+}
+
+// base returns the revision of the index of `docID` last seen, or 0 if
+// unknown.
+func (r *indexRevisions) base(docID sserver1.DocumentID) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.revisions[docID]
+}
+
+// seen records that the index of `docID` has been seen at `revision`.
+func (r *indexRevisions) seen(docID sserver1.DocumentID, revision int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.revisions[docID] = revision
+}
+
+// written records the result of a write of the index of `docID`.
+func (r *indexRevisions) written(docID sserver1.DocumentID, res sserver1.WriteResult) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.revisions[docID] = res.Revision
+	if res.Conflict {
+		r.conflicts++
+	}
+}
+
+// clear forgets the revisions last seen, e.g. once the indexes are served by
+// another search server.
+func (r *indexRevisions) clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.revisions = make(map[sserver1.DocumentID]int64)
+}
+
+// renamed moves the revision of the index of `orig` to `curr`.
+func (r *indexRevisions) renamed(orig, curr sserver1.DocumentID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if revision, ok := r.revisions[orig]; ok {
+		delete(r.revisions, orig)
+		r.revisions[curr] = revision
+	}
+}
+
+// deleted forgets the revision of the index of `docID`.
+func (r *indexRevisions) deleted(docID sserver1.DocumentID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.revisions, docID)
+}
+
+// numConflicts returns the number of writes that overwrote the index of
+// another client.
+func (r *indexRevisions) numConflicts() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.conflicts
+}
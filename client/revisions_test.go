@@ -0,0 +1,64 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestWriteConflicts tests the conflict detection of the index writes.  Checks
+// that a client overwriting an index written by another client since it last
+// wrote it counts a conflict, that the last write wins, and that the revisions
+// seen by `GetDocumentInfo` are taken into account.
+func TestWriteConflicts(t *testing.T) {
+	server := newMemoryServerClient()
+	client1, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client2, _ := startTestClientWithServer(t, dir, server)
+
+	pathname := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(pathname, []byte("some content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	addFile := func(cli *Client) {
+		if err := cli.AddFile(context.Background(), dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	numConflicts := func(cli *Client) int {
+		status, err := cli.GetDirectoryStatus(dir)
+		if err != nil {
+			t.Fatalf("error when getting the directory status: %s", err)
+		}
+		return status.Conflicts
+	}
+
+	addFile(client1)
+	addFile(client2)
+	if numConflicts(client1) != 0 || numConflicts(client2) != 0 {
+		t.Fatalf("conflict counted for a write without a known revision")
+	}
+	addFile(client1)
+	if numConflicts(client1) != 1 {
+		t.Fatalf("conflicting write not counted: %d", numConflicts(client1))
+	}
+	info, err := client2.GetDocumentInfo(context.Background(), dir, pathname)
+	if err != nil {
+		t.Fatalf("error when getting the document info: %s", err)
+	}
+	if info.Revision != 3 {
+		t.Fatalf("incorrect revision: %d", info.Revision)
+	}
+	addFile(client2)
+	addFile(client2)
+	if numConflicts(client2) != 0 {
+		t.Fatalf("conflict counted for a write of the revision seen: %d", numConflicts(client2))
+	}
+}
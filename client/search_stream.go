@@ -0,0 +1,102 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// defaultSearchPageSize is the default number of results fetched at once by
+// `SearchWordStream`.
+const defaultSearchPageSize = 1000
+
+// Result is a result of a streamed search: either a file possibly containing
+// the searched word, or the error that ended the search.
+type Result struct {
+	Filename string // The absolute filename of the file.
+	Err      error  // The error that ended the search, if any.
+}
+
+// SetSearchPageSize sets the number of results `SearchWordStream` fetches from
+// the search server at once.  Defaults to 1000.  A `size` below 1 is treated
+// as 1.  Should be called before the searches are performed.
+func (c *Client) SetSearchPageSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	c.searchPageSize = size
+}
+
+// SearchWordStream is similar to `SearchWord`, but fetches the results from
+// the search server by pages and sends the filenames on the returned channel
+// as soon as each page is decrypted, so that the large results can be shown
+// incrementally.  The filenames are only sorted within each page.  The channel
+// is closed once all the results have been sent, right after a result with the
+// error if the search fails midway, or once `ctx` is canceled.
+// NOTE: False positives are possible.
+func (c *Client) SearchWordStream(ctx context.Context, directory, word string) (<-chan Result, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.throttle != nil {
+		if err := c.throttle.wait(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	keyGens, err := c.searchCli.GetKeyGens(ctx, dirInfo.tlfID)
+	if err != nil {
+		return nil, err
+	}
+	arg := sserver1.SearchWordPageArg{
+		TlfID:            dirInfo.tlfID,
+		Trapdoors:        computeTrapdoorMap(dirInfo, keyGens, word),
+		ResultBucketSize: c.resultBucket,
+		PageSize:         c.searchPageSize,
+	}
+
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+		send := func(result Result) bool {
+			// Checked first, as the select picks randomly among the
+			// ready cases.
+			if ctx.Err() != nil {
+				return false
+			}
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			page, err := c.searchCli.SearchWordPage(ctx, arg)
+			if err != nil {
+				send(Result{Err: err})
+				return
+			}
+			filenames, err := c.docIDsToFilenames(dirInfo, page.DocIDs)
+			if err != nil {
+				send(Result{Err: err})
+				return
+			}
+			for _, filename := range filenames {
+				if !send(Result{Filename: filename}) {
+					return
+				}
+			}
+			if page.Next == "" {
+				return
+			}
+			arg.After = page.Next
+		}
+	}()
+	return results, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestSearchWordStream tests the `SearchWordStream` function.  Checks that all
+// the results are streamed by pages, with the dummies padding each page
+// filtered out, and that the stream is closed once the context is canceled.
+func TestSearchWordStream(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	filenames := writeTestFiles(t, client, dir, 7)
+	client.SetSearchPageSize(3)
+	client.SetResultBucketSize(4)
+
+	results, err := client.SearchWordStream(context.Background(), dir, "common")
+	if err != nil {
+		t.Fatalf("error when starting the search: %s", err)
+	}
+	var actual []string
+	for result := range results {
+		if result.Err != nil {
+			t.Fatalf("error when streaming the results: %s", result.Err)
+		}
+		actual = append(actual, result.Filename)
+	}
+	sort.Strings(actual)
+	if !reflect.DeepEqual(filenames, actual) {
+		t.Fatalf("incorrect search result: expected \"%s\" actual \"%s\"", filenames, actual)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err = client.SearchWordStream(ctx, dir, "common")
+	if err != nil {
+		t.Fatalf("error when starting the search: %s", err)
+	}
+	if result := <-results; result.Err != nil || result.Filename == "" {
+		t.Fatalf("incorrect first result: %+v", result)
+	}
+	cancel()
+	for result := range results {
+		t.Fatalf("result streamed after the cancellation: %+v", result)
+	}
+}
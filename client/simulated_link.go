@@ -0,0 +1,85 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"time"
+
+	"github.com/keybase/go-codec/codec"
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// simulatedLink is an `rpc.GenericClient` delaying each call as if the search
+// server were reached through a link with the given latency and bandwidth.
+// The delays follow the network model of the prototype server: twice the
+// latency per round trip, plus the time to transfer the payloads.
+type simulatedLink struct {
+	inner     rpc.GenericClient   // The client the calls are forwarded to.
+	latency   time.Duration       // The one-way latency of the link.
+	bandwidth int64               // The bandwidth of the link, in bits per second.  Unlimited if 0.
+	sleep     func(time.Duration) // Applies the delays.
+}
+
+// payloadSize returns the size in bytes of `v` encoded in msgpack, as sent on
+// the wire.
+func payloadSize(v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	var buf []byte
+	if err := codec.NewEncoderBytes(&buf, &codec.MsgpackHandle{WriteExt: true}).Encode(v); err != nil {
+		return 0
+	}
+	return int64(len(buf))
+}
+
+// delay applies the delay of a round trip transferring `size` bytes.
+func (l *simulatedLink) delay(size int64) {
+	d := 2 * l.latency
+	if l.bandwidth > 0 {
+		d += time.Duration(float64(size) * 8 * float64(time.Second) / float64(l.bandwidth))
+	}
+	l.sleep(d)
+}
+
+func (l *simulatedLink) Call(ctx context.Context, method string, arg interface{}, res interface{}) error {
+	err := l.inner.Call(ctx, method, arg, res)
+	size := payloadSize(arg)
+	if err == nil {
+		size += payloadSize(res)
+	}
+	l.delay(size)
+	return err
+}
+
+func (l *simulatedLink) Notify(ctx context.Context, method string, arg interface{}) error {
+	err := l.inner.Notify(ctx, method, arg)
+	l.delay(payloadSize(arg))
+	return err
+}
+
+// SimulateLink delays all the subsequent calls to the search server as if it
+// were reached through a link with the one-way `latency` and the `bandwidth` in
+// bits per second, as modeled by the prototype server, so that its performance
+// experiments can be reproduced against the production code path.  A
+// non-positive `bandwidth` leaves the bandwidth unlimited.  Only applies to the
+// clients created by `NewClient`, and should be called before any file is
+// added.
+func (c *Client) SimulateLink(latency time.Duration, bandwidth int64) {
+	if c.conn == nil {
+		return
+	}
+	if bandwidth < 0 {
+		bandwidth = 0
+	}
+	c.searchCli = sserver1.SearchServerClient{Cli: &simulatedLink{
+		inner:     c.conn.GetClient(),
+		latency:   latency,
+		bandwidth: bandwidth,
+		sleep:     time.Sleep,
+	}}
+}
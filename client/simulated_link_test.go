@@ -0,0 +1,70 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// echoGenericClient is an `rpc.GenericClient` answering each call with a fixed
+// result, or failing it.
+type echoGenericClient struct {
+	res []sserver1.DocumentID
+	err error
+}
+
+func (c echoGenericClient) Call(_ context.Context, _ string, _ interface{}, res interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	*res.(*[]sserver1.DocumentID) = c.res
+	return nil
+}
+
+func (c echoGenericClient) Notify(_ context.Context, _ string, _ interface{}) error {
+	return c.err
+}
+
+// TestSimulatedLink tests the `simulatedLink` type.  Checks that each call is
+// delayed by twice the latency plus the transfer time of its argument and
+// result, and that the result of the call is forwarded.
+func TestSimulatedLink(t *testing.T) {
+	var slept time.Duration
+	res := []sserver1.DocumentID{"doc1", "doc2"}
+	link := &simulatedLink{
+		inner:     echoGenericClient{res: res},
+		latency:   10 * time.Millisecond,
+		bandwidth: 8000,
+		sleep:     func(d time.Duration) { slept += d },
+	}
+	arg := []interface{}{sserver1.SearchWordArg{TlfID: "tlf"}}
+	var got []sserver1.DocumentID
+	if err := link.Call(context.Background(), "searchWord", arg, &got); err != nil {
+		t.Fatalf("error when calling through the link: %s", err)
+	}
+	if len(got) != len(res) || got[0] != res[0] || got[1] != res[1] {
+		t.Fatalf("incorrect result: %v", got)
+	}
+	// 8000 bps transfers one byte per millisecond.
+	expected := 20*time.Millisecond + time.Duration(payloadSize(arg)+payloadSize(&got))*time.Millisecond
+	if slept != expected || payloadSize(arg) == 0 || payloadSize(&got) == 0 {
+		t.Fatalf("incorrect delay: %s instead of %s", slept, expected)
+	}
+
+	slept = 0
+	link.inner = echoGenericClient{err: errors.New("call failed")}
+	link.bandwidth = 0
+	if err := link.Call(context.Background(), "searchWord", arg, &got); err == nil {
+		t.Fatalf("error not forwarded")
+	}
+	if slept != 20*time.Millisecond {
+		t.Fatalf("incorrect delay with an unlimited bandwidth: %s", slept)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// binarySniffLen is the number of bytes at the start of a file sniffed for
+// binary content.
+const binarySniffLen = 512
+
+// SetMaxFileSize sets the size in bytes beyond which the files found by the
+// scans are skipped instead of indexed.  A non-positive `size` removes the
+// limit.  Should be called before the scans are started.
+func (c *Client) SetMaxFileSize(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	c.maxFileSize = size
+}
+
+// SetSkipBinary sets whether the files found by the scans whose content looks
+// binary, i.e. with a NUL byte among their first bytes, are skipped instead of
+// indexed.  Should be called before the scans are started.
+func (c *Client) SetSkipBinary(skip bool) {
+	c.skipBinary = skip
+}
+
+// isBinary returns whether the content of the file at `path` looks binary.
+func isBinary(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	buf := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
+// checkSkipRules returns the reason the file at `path` should be skipped by
+// the scans, along with an error describing it, or an empty reason if the file
+// should be indexed.
+func (c *Client) checkSkipRules(path string) (SkipReason, error) {
+	return checkSkipRules(path, c.maxFileSize, c.skipBinary)
+}
+
+// checkSkipRules returns the reason the file at `path` should be skipped when
+// the files larger than `maxFileSize` bytes, unless 0, and the binary files if
+// `skipBinary` are skipped, along with an error describing it, or an empty
+// reason if the file should be indexed.
+func checkSkipRules(path string, maxFileSize int64, skipBinary bool) (SkipReason, error) {
+	if maxFileSize == 0 && !skipBinary {
+		return "", nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return SkipFailed, err
+	}
+	if maxFileSize > 0 && info.Size() > maxFileSize {
+		return SkipTooLarge, fmt.Errorf("file size of %d bytes exceeds the limit of %d bytes", info.Size(), maxFileSize)
+	}
+	if !skipBinary {
+		return "", nil
+	}
+	if binary, err := isBinary(path); err != nil {
+		return SkipFailed, err
+	} else if binary {
+		return SkipBinary, errors.New("binary content")
+	}
+	return "", nil
+}
+
+// isSpecialFile returns whether the file or directory named `name` is a state
+// file of the client or a special file of KBFS, such as `.kbfs_status`, which
+// are never walked.
+func isSpecialFile(name string) bool {
+	return strings.HasPrefix(name, ".search_kbfs") || strings.HasPrefix(name, ".kbfs_")
+}
+
+// isHidden returns whether the file or directory named `name` is hidden, in
+// which case it is excluded from the scans along with the files under it.
+func isHidden(name string) bool {
+	return name[0] == '.'
+}
+
+// walkScannedFiles walks the files under `directory` that the scans index,
+// handling the symlinks as set by `policy`, and calls `fn` with the path
+// relative to the directory and the info of each.  The hidden files and the
+// files under hidden subdirectories are skipped, unless `onHidden` is set, in
+// which case it is called with their relative paths instead.  The special
+// files are always skipped.  The files are not checked against the skip rules
+// set on the client, which need to read them.
+func walkScannedFiles(directory string, policy SymlinkPolicy, fn func(relPath string, info os.FileInfo) error, onHidden func(relPath string)) error {
+	hiddenDir := ""
+	return walkFiles(directory, directory, policy, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == directory {
+			return nil
+		}
+		relPath, err := relPathStrict(directory, path)
+		if err != nil {
+			return err
+		}
+		if isSpecialFile(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		hidden := isHidden(info.Name()) || (hiddenDir != "" && strings.HasPrefix(relPath, hiddenDir))
+		if info.IsDir() {
+			if !hidden {
+				return nil
+			} else if onHidden == nil {
+				return filepath.SkipDir
+			} else if hiddenDir == "" || !strings.HasPrefix(relPath, hiddenDir) {
+				hiddenDir = relPath + string(filepath.Separator)
+			}
+			return nil
+		}
+		if hidden {
+			if onHidden != nil {
+				onHidden(relPath)
+			}
+			return nil
+		}
+		return fn(relPath, info)
+	})
+}
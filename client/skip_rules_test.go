@@ -0,0 +1,119 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestSkipRules tests the `SetMaxFileSize` and `SetSkipBinary` functions.
+// Checks that the scans skip the files that are too large or binary, that the
+// skipped files are counted and recorded with their reason, and that they are
+// indexed once modified after the rules are lifted.
+func TestSkipRules(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	cli.SetMaxFileSize(16)
+	cli.SetSkipBinary(true)
+
+	files := map[string][]byte{
+		"text":   []byte("some content"),
+		"large":  []byte("some content that is too large"),
+		"binary": []byte("some\x00content"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+
+	report := cli.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil || len(report.Added) != 1 || report.Added[0] != filepath.Join(dir, "text") || report.Skipped != 2 {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	issues, err := cli.GetFileIssues(dir)
+	if err != nil {
+		t.Fatalf("error when getting the file issues: %s", err)
+	}
+	if len(issues) != 2 || issues[0].Reason != SkipBinary || issues[1].Reason != SkipTooLarge {
+		t.Fatalf("incorrect file issues: %+v", issues)
+	}
+	coverage, err := cli.GetCoverage(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("error when getting the coverage: %s", err)
+	}
+	if coverage.Indexed != 1 || coverage.Skipped[SkipBinary] != 1 || coverage.Skipped[SkipTooLarge] != 1 {
+		t.Fatalf("incorrect coverage: %+v", coverage)
+	}
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 0 || report.Skipped != 0 {
+		t.Fatalf("skipped files scanned again: %+v", report)
+	}
+
+	cli.SetMaxFileSize(0)
+	cli.SetSkipBinary(false)
+	future := time.Now().Add(time.Hour)
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(dir, name), future, future); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	// The text file is already indexed with the same content.
+	if report := cli.IndexUpdatedFiles(context.Background(), dir); report.Err != nil || len(report.Added) != 2 || report.Skipped != 0 || report.Unchanged != 1 {
+		t.Fatalf("incorrect scan: %+v", report)
+	}
+	if issues, err := cli.GetFileIssues(dir); err != nil || len(issues) != 0 {
+		t.Fatalf("file issues not cleared: %+v, %v", issues, err)
+	}
+}
+
+// TestWalkScannedFiles tests the `walkScannedFiles` function.  Checks that the
+// hidden files and the files under hidden subdirectories are skipped, or passed
+// to `onHidden` if set, and that the special files are always skipped.
+func TestWalkScannedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWalkScannedFiles")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, relPath := range []string{"a", "sub/b", ".hidden", ".dir/c", ".kbfs_status", ".search_kbfs_state/d"} {
+		path := filepath.Join(dir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("error when creating a test subdirectory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+
+	var walked, hidden []string
+	walk := func(relPath string, _ os.FileInfo) error {
+		walked = append(walked, relPath)
+		return nil
+	}
+	if err := walkScannedFiles(dir, SymlinkSkip, walk, nil); err != nil {
+		t.Fatalf("error when walking the files: %s", err)
+	}
+	if expected := []string{"a", filepath.Join("sub", "b")}; !reflect.DeepEqual(expected, walked) {
+		t.Fatalf("incorrect files walked: expected %s actual %s", expected, walked)
+	}
+
+	walked = nil
+	if err := walkScannedFiles(dir, SymlinkSkip, walk, func(relPath string) { hidden = append(hidden, relPath) }); err != nil {
+		t.Fatalf("error when walking the files: %s", err)
+	}
+	if expected := []string{"a", filepath.Join("sub", "b")}; !reflect.DeepEqual(expected, walked) {
+		t.Fatalf("incorrect files walked: expected %s actual %s", expected, walked)
+	}
+	if expected := []string{filepath.Join(".dir", "c"), ".hidden"}; !reflect.DeepEqual(expected, hidden) {
+		t.Fatalf("incorrect hidden files: expected %s actual %s", expected, hidden)
+	}
+}
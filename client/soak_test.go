@@ -0,0 +1,158 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build soak
+// +build soak
+
+package client
+
+import (
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/keybase/kbfs/libkbfs"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+var soakWeeks = flag.Int("soak_weeks", 2, "the number of simulated weeks the soak test runs for")
+
+// heapInUse returns the number of bytes of the heap in use after a garbage
+// collection.
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+// TestSoak runs the background loops of the client with a mock clock over
+// simulated weeks of file churn and daily rekeys.  Checks that every update is
+// eventually searchable, that the rekeys are picked up, that the memory usage
+// stays bounded, and that no goroutine is leaked once the client is shut down.
+func TestSoak(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("soak seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	dir, err := ioutil.TempDir("", "TestSoak")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	keyGen := libkbfs.KeyGen(1)
+	writeTestKbfsStatus(t, dir, keyGen)
+
+	baseGoroutines := runtime.NumGoroutine()
+
+	clock := clockwork.NewFakeClockAt(time.Now())
+	cli, err := createClientWithClock(context.Background(), newMemoryServerClient(), clock, []string{dir}, 64, 32, 0.000001, 1000, false, sserver1.IndexType_BLOOM)
+	if err != nil {
+		t.Fatalf("error when creating the client: %s", err)
+	}
+
+	numFiles := 20
+	versions := make([]int, numFiles)
+	filenames := make([]string, numFiles)
+	writeFile := func(i int) {
+		versions[i]++
+		content := "soak file" + strconv.Itoa(i) + "v" + strconv.Itoa(versions[i])
+		if err := ioutil.WriteFile(filenames[i], []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		modTime := clock.Now().Add(time.Second)
+		if err := os.Chtimes(filenames[i], modTime, modTime); err != nil {
+			t.Fatalf("error when setting the modification time: %s", err)
+		}
+	}
+	for i := range filenames {
+		filenames[i] = filepath.Join(dir, "soakFile"+strconv.Itoa(i))
+		writeFile(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cli.PeriodicAdd(context.Background(), []string{dir}, func(report IndexReport) {
+			if report.Err != nil {
+				t.Errorf("error when indexing the files: %s", report.Err)
+			}
+		})
+		close(done)
+	}()
+
+	var dayOneHeap uint64
+	numDays := 7 * *soakWeeks
+	for day := 0; day < numDays; day++ {
+		updated := make(map[int]bool)
+		for minute := 0; minute < 24*60; minute++ {
+			// Waits for both the add and the key generation check loops to
+			// be idle before touching the files.
+			clock.BlockUntil(2)
+			if minute%15 == 0 {
+				i := rng.Intn(numFiles)
+				writeFile(i)
+				updated[i] = true
+			}
+			if minute == 12*60 {
+				keyGen++
+				writeTestKbfsStatus(t, dir, keyGen)
+			}
+			clock.Advance(time.Minute)
+		}
+		clock.BlockUntil(2)
+
+		if latest := cli.directoryInfos[cli.Directories()[0]].getLatestKeyIndex(); latest != getNormalizedKeyIndex(keyGen) {
+			t.Fatalf("rekey missed on day %d: expected key index %d actual %d", day, getNormalizedKeyIndex(keyGen), latest)
+		}
+
+		var words []string
+		expected := make(map[string][]string)
+		for i := range updated {
+			word := "file" + strconv.Itoa(i) + "v" + strconv.Itoa(versions[i])
+			words = append(words, word)
+			expected[word] = []string{filenames[i]}
+		}
+		results, err := cli.SearchWordsStrict(context.Background(), dir, words)
+		if err != nil {
+			t.Fatalf("error when searching on day %d: %s", day, err)
+		}
+		for _, word := range words {
+			if !reflect.DeepEqual(expected[word], results[word]) {
+				t.Fatalf("update missed on day %d: expected %s for %q actual %s", day, expected[word], word, results[word])
+			}
+		}
+
+		if day == 0 {
+			dayOneHeap = heapInUse()
+		}
+	}
+
+	// The indexes kept by the in-memory server grow with the rekeys, so some
+	// slack is allowed on top of the first day's heap.
+	if finalHeap := heapInUse(); finalHeap > 2*dayOneHeap+(16<<20) {
+		t.Fatalf("unbounded memory usage: %d bytes after the first day, %d bytes after %d days", dayOneHeap, finalHeap, numDays)
+	}
+
+	cli.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("PeriodicAdd still running after shutdown")
+	}
+	for attempt := 0; runtime.NumGoroutine() > baseGoroutines; attempt++ {
+		if attempt == 100 {
+			t.Fatalf("goroutines leaked: %d before the client started, %d after shutdown", baseGoroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
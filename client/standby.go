@@ -0,0 +1,384 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/keybase/client/go/libkb"
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// standbyServerClient is the client of a search server whose index writes are
+// mirrored to a standby search server, so that the searches can fail over to
+// the standby without indexing the directories again.  The writes are only
+// reported as failed if they fail on the active server; the document IDs whose
+// mirroring has failed are recorded, so that their files are indexed again on
+// failover.
+type standbyServerClient struct {
+	lock       sync.RWMutex
+	primary    sserver1.SearchServerInterface                     // The search server the client talks to until it fails over.
+	standby    sserver1.SearchServerInterface                     // The standby search server the writes are mirrored to.
+	conn       *rpc.Connection                                    // The connection to the standby.  Nil if not owned by the client.
+	failedOver bool                                               // Whether the client talks to the standby instead of the primary.
+	missed     map[sserver1.FolderID]map[sserver1.DocumentID]bool // The document IDs whose mirroring has failed, keyed by TLF.
+}
+
+// active returns the search server the client talks to, and whether the writes
+// should be mirrored to the standby.
+func (s *standbyServerClient) active() (sserver1.SearchServerInterface, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.failedOver {
+		return s.standby, false
+	}
+	return s.primary, true
+}
+
+// isFailedOver returns whether the client talks to the standby.
+func (s *standbyServerClient) isFailedOver() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.failedOver
+}
+
+// mirror sends the write of `docIDs` of `tlfID` to the standby with `op`, and
+// records the document IDs as missed by the standby if it fails.
+func (s *standbyServerClient) mirror(tlfID sserver1.FolderID, docIDs []sserver1.DocumentID, op func(standby sserver1.SearchServerInterface) error) {
+	if err := op(s.standby); err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.missed[tlfID] == nil {
+		s.missed[tlfID] = make(map[sserver1.DocumentID]bool)
+	}
+	for _, docID := range docIDs {
+		s.missed[tlfID][docID] = true
+	}
+}
+
+// failOver has the client talk to the standby from now on, and returns the
+// document IDs whose mirroring has failed, keyed by TLF.
+func (s *standbyServerClient) failOver() map[sserver1.FolderID]map[sserver1.DocumentID]bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failedOver = true
+	missed := s.missed
+	s.missed = make(map[sserver1.FolderID]map[sserver1.DocumentID]bool)
+	return missed
+}
+
+func (s *standbyServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	active, mirrored := s.active()
+	res, err := active.WriteIndex(ctx, arg)
+	if err == nil && mirrored {
+		// The revisions seen by the client are the ones of the primary.
+		mirror := arg
+		mirror.BaseRevision = 0
+		s.mirror(arg.TlfID, []sserver1.DocumentID{arg.DocID}, func(standby sserver1.SearchServerInterface) error {
+			_, err := standby.WriteIndex(ctx, mirror)
+			return err
+		})
+	}
+	return res, err
+}
+
+func (s *standbyServerClient) WriteIndexes(ctx context.Context, arg sserver1.WriteIndexesArg) ([]sserver1.WriteResult, error) {
+	active, mirrored := s.active()
+	res, err := active.WriteIndexes(ctx, arg)
+	if err == nil && mirrored {
+		mirror := sserver1.WriteIndexesArg{TlfID: arg.TlfID, Writes: make([]sserver1.IndexWrite, len(arg.Writes))}
+		docIDs := make([]sserver1.DocumentID, len(arg.Writes))
+		for i, write := range arg.Writes {
+			mirror.Writes[i] = sserver1.IndexWrite{SecureIndex: write.SecureIndex, DocID: write.DocID}
+			docIDs[i] = write.DocID
+		}
+		s.mirror(arg.TlfID, docIDs, func(standby sserver1.SearchServerInterface) error {
+			_, err := standby.WriteIndexes(ctx, mirror)
+			return err
+		})
+	}
+	return res, err
+}
+
+func (s *standbyServerClient) RenameIndex(ctx context.Context, arg sserver1.RenameIndexArg) error {
+	active, mirrored := s.active()
+	err := active.RenameIndex(ctx, arg)
+	if err == nil && mirrored {
+		s.mirror(arg.TlfID, []sserver1.DocumentID{arg.Orig, arg.Curr}, func(standby sserver1.SearchServerInterface) error {
+			return standby.RenameIndex(ctx, arg)
+		})
+	}
+	return err
+}
+
+func (s *standbyServerClient) DeleteIndex(ctx context.Context, arg sserver1.DeleteIndexArg) error {
+	active, mirrored := s.active()
+	err := active.DeleteIndex(ctx, arg)
+	if err == nil && mirrored {
+		s.mirror(arg.TlfID, []sserver1.DocumentID{arg.DocID}, func(standby sserver1.SearchServerInterface) error {
+			return standby.DeleteIndex(ctx, arg)
+		})
+	}
+	return err
+}
+
+func (s *standbyServerClient) MergeTlfSummary(ctx context.Context, arg sserver1.MergeTlfSummaryArg) error {
+	active, mirrored := s.active()
+	err := active.MergeTlfSummary(ctx, arg)
+	if err == nil && mirrored {
+		s.mirror(arg.TlfID, []sserver1.DocumentID{arg.DocID}, func(standby sserver1.SearchServerInterface) error {
+			return standby.MergeTlfSummary(ctx, arg)
+		})
+	}
+	return err
+}
+
+func (s *standbyServerClient) DropTlf(ctx context.Context, tlfID sserver1.FolderID) error {
+	active, mirrored := s.active()
+	err := active.DropTlf(ctx, tlfID)
+	if err == nil && mirrored {
+		// The files of the dropped TLF are indexed again from scratch anyway.
+		s.standby.DropTlf(ctx, tlfID)
+	}
+	return err
+}
+
+func (s *standbyServerClient) GetKeyGens(ctx context.Context, tlfID sserver1.FolderID) ([]int, error) {
+	active, _ := s.active()
+	return active.GetKeyGens(ctx, tlfID)
+}
+
+func (s *standbyServerClient) SearchWord(ctx context.Context, arg sserver1.SearchWordArg) ([]sserver1.DocumentID, error) {
+	active, _ := s.active()
+	return active.SearchWord(ctx, arg)
+}
+
+func (s *standbyServerClient) SearchWords(ctx context.Context, arg sserver1.SearchWordsArg) ([][]sserver1.DocumentID, error) {
+	active, _ := s.active()
+	return active.SearchWords(ctx, arg)
+}
+
+func (s *standbyServerClient) SearchConjunction(ctx context.Context, arg sserver1.SearchConjunctionArg) ([]sserver1.DocumentID, error) {
+	active, _ := s.active()
+	return active.SearchConjunction(ctx, arg)
+}
+
+func (s *standbyServerClient) GetDocumentInfo(ctx context.Context, arg sserver1.GetDocumentInfoArg) ([]sserver1.DocumentInfo, error) {
+	active, _ := s.active()
+	return active.GetDocumentInfo(ctx, arg)
+}
+
+func (s *standbyServerClient) GetChanges(ctx context.Context, arg sserver1.GetChangesArg) (sserver1.ChangeSet, error) {
+	active, _ := s.active()
+	return active.GetChanges(ctx, arg)
+}
+
+func (s *standbyServerClient) ClaimIndex(ctx context.Context, arg sserver1.ClaimIndexArg) (bool, error) {
+	active, _ := s.active()
+	return active.ClaimIndex(ctx, arg)
+}
+
+func (s *standbyServerClient) RegisterTlfIfNotExists(ctx context.Context, arg sserver1.RegisterTlfIfNotExistsArg) (sserver1.TlfInfo, error) {
+	active, _ := s.active()
+	return active.RegisterTlfIfNotExists(ctx, arg)
+}
+
+func (s *standbyServerClient) GetIndexStats(ctx context.Context, tlfID sserver1.FolderID) (sserver1.TlfIndexStats, error) {
+	active, _ := s.active()
+	return active.GetIndexStats(ctx, tlfID)
+}
+
+func (s *standbyServerClient) SearchWordPage(ctx context.Context, arg sserver1.SearchWordPageArg) (sserver1.SearchPage, error) {
+	active, _ := s.active()
+	return active.SearchWordPage(ctx, arg)
+}
+
+func (s *standbyServerClient) GetTlfSummary(ctx context.Context, tlfID sserver1.FolderID) (sserver1.TlfSummary, error) {
+	active, _ := s.active()
+	return active.GetTlfSummary(ctx, tlfID)
+}
+
+func (s *standbyServerClient) BeginBackfill(ctx context.Context, arg sserver1.BeginBackfillArg) (sserver1.BackfillGrant, error) {
+	active, _ := s.active()
+	return active.BeginBackfill(ctx, arg)
+}
+
+func (s *standbyServerClient) EndBackfill(ctx context.Context, arg sserver1.EndBackfillArg) error {
+	active, _ := s.active()
+	return active.EndBackfill(ctx, arg)
+}
+
+// SetStandby connects to the standby search server at `ipAddr`:`port`, with
+// the RPCs logged to `logger` if set, and mirrors the index writes of the
+// client to it from now on, so that the client can switch to it with
+// `FailOver` if the search server disappears for good, without indexing its
+// directories again.  The TLFs of the directories are registered on the
+// standby with the salts and the parameters they have on the search server,
+// the salts being encrypted under the master secret of each TLF.  Only the
+// indexes written from now on are mirrored: the directories already indexed
+// can be brought up to date on the standby with `ResetDirectory`.  Should be
+// called before the scans are started.
+func (c *Client) SetStandby(ctx context.Context, ipAddr string, port int, logger *Logger) error {
+	serverAddr := fmt.Sprintf("%s:%d", ipAddr, port)
+	conn := rpc.NewTLSConnection(serverAddr, libsearch.GetRootCerts(serverAddr), libkb.ErrorUnwrapper{}, &Client{}, true, rpc.NewSimpleLogFactory(logOutput{logger: logger}, nil), libkb.WrapError, logOutput{logger: logger}, logTags)
+	standby := sserver1.SearchServerClient{Cli: meteredClient{GenericClient: conn.GetClient(), metrics: c.metrics}}
+	if err := c.setStandbyClient(ctx, standby); err != nil {
+		conn.Shutdown()
+		return err
+	}
+	c.standby.conn = conn
+	return nil
+}
+
+// setStandbyClient mirrors the index writes of the client to `standby`, once
+// the TLFs of the directories are registered on it.
+func (c *Client) setStandbyClient(ctx context.Context, standby sserver1.SearchServerInterface) error {
+	if c.standby != nil {
+		return errors.New("standby search server already set")
+	}
+	for _, dirInfo := range c.getDirectoryInfos() {
+		if err := registerStandby(ctx, standby, dirInfo, c.dirParams); err != nil {
+			return err
+		}
+	}
+	c.standby = &standbyServerClient{primary: c.searchCli, standby: standby, missed: make(map[sserver1.FolderID]map[sserver1.DocumentID]bool)}
+	c.searchCli = c.standby
+	return nil
+}
+
+// registerStandby registers the TLF of the directory of `dirInfo` on the
+// `standby` search server with `params`, and the salts it has on the search
+// server of the client, so that the indexes mirrored to the standby are
+// searched with the same trapdoors.  Returns an error if the TLF turns out to
+// have other parameters on the standby, e.g. as it has been registered on the
+// search server by a client with other parameters.
+func registerStandby(ctx context.Context, standby sserver1.SearchServerInterface, dirInfo *DirectoryInfo, params directoryParams) error {
+	dirInfo.keyGenLock.RLock()
+	keyGen := dirInfo.keyGen
+	dirInfo.keyGenLock.RUnlock()
+	tlfInfo := dirInfo.tlfInfo
+	if len(tlfInfo.Salts) == 0 {
+		return fmt.Errorf("no salt for the TLF of directory %s", dirInfo.absDir)
+	}
+
+	registerArg := sserver1.RegisterTlfIfNotExistsArg{TlfID: dirInfo.tlfID, LenSalt: len(tlfInfo.Salts[0]), FpRate: params.fpRate, NumUniqWords: int64(params.numUniqWords), EncryptedSalts: tlfInfo.EncryptedSalts, IndexType: tlfInfo.IndexType, AnalysisFingerprint: tlfInfo.AnalysisFingerprint, CodewordMapping: tlfInfo.CodewordMapping}
+	if len(registerArg.EncryptedSalts) == 0 {
+		masterSecret, err := fetchMasterSecret(dirInfo.absDir, getSaltsKeyGen(keyGen), dirInfo.lenMS)
+		if err != nil {
+			return err
+		}
+		if registerArg.EncryptedSalts, err = libsearch.SealSalts(tlfInfo.Salts, masterSecret); err != nil {
+			return err
+		}
+	}
+
+	standbyInfo, err := standby.RegisterTlfIfNotExists(ctx, registerArg)
+	if err != nil {
+		return err
+	}
+	if len(standbyInfo.EncryptedSalts) > 0 {
+		standbyInfo.Salts, err = openEncryptedSalts(dirInfo.absDir, keyGen, dirInfo.lenMS, standbyInfo.EncryptedSalts)
+		if err != nil {
+			return err
+		}
+	}
+	if standbyInfo.Size != tlfInfo.Size || standbyInfo.IndexType != tlfInfo.IndexType || standbyInfo.CodewordMapping != tlfInfo.CodewordMapping || !equalSalts(standbyInfo.Salts, tlfInfo.Salts) {
+		return fmt.Errorf("the TLF of directory %s is registered on the standby search server with other parameters", dirInfo.absDir)
+	}
+	return nil
+}
+
+// equalSalts returns whether the salts `a` and `b` are the same.
+func equalSalts(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FailOver has the client talk to the standby search server set with
+// `SetStandby` instead of the search server, e.g. once the search server has
+// disappeared for good.  The files whose indexes could not be mirrored to the
+// standby are indexed again, or their indexes deleted if they are gone.  The
+// sequence numbers of the changes returned by `GetChanges` restart with the
+// ones of the standby.
+func (c *Client) FailOver(ctx context.Context) error {
+	if c.standby == nil {
+		return errors.New("no standby search server")
+	}
+	if c.standby.isFailedOver() {
+		return nil
+	}
+	missed := c.standby.failOver()
+	var firstErr error
+	for _, dirInfo := range c.getDirectoryInfos() {
+		// The revisions seen are the ones of the former search server.
+		dirInfo.revisions.clear()
+		c.invalidateResults(dirInfo)
+		if err := c.remirror(ctx, dirInfo, missed[dirInfo.tlfID]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// remirror indexes again the files of the directory of `dirInfo` whose
+// document IDs are among the `missed` ones, or deletes their indexes if they
+// are gone.
+func (c *Client) remirror(ctx context.Context, dirInfo *DirectoryInfo, missed map[sserver1.DocumentID]bool) error {
+	if len(missed) == 0 {
+		return nil
+	}
+	docIDs := make([]sserver1.DocumentID, 0, len(missed))
+	for docID := range missed {
+		docIDs = append(docIDs, docID)
+	}
+	pathnames, err := c.decryptDocIDs(dirInfo, docIDs)
+	if err != nil {
+		return err
+	}
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, relPath := range pathnames {
+		if relPath == "" || isDummyPathname(relPath) {
+			continue
+		}
+		if tagged, ok := parseTagsPathname(relPath); ok {
+			err = c.writeTagsIndex(ctx, dirInfo, tagged, tags[tagged])
+		} else if pathname := filepath.Join(dirInfo.absDir, relPath); fileExists(pathname) {
+			err = c.AddFile(ctx, dirInfo.absDir, pathname)
+		} else {
+			err = c.DeleteFile(ctx, dirInfo.absDir, pathname)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// fileExists returns whether there is a file at `pathname`.
+func fileExists(pathname string) bool {
+	_, err := os.Stat(pathname)
+	return err == nil
+}
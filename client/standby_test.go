@@ -0,0 +1,80 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// unavailableWriteServerClient fails the index writes to an in-memory server
+// while it is down.
+type unavailableWriteServerClient struct {
+	*memoryServerClient
+	down bool // Whether the writes fail.
+}
+
+func (c *unavailableWriteServerClient) WriteIndex(ctx context.Context, arg sserver1.WriteIndexArg) (sserver1.WriteResult, error) {
+	if c.down {
+		return sserver1.WriteResult{}, errors.New("server down")
+	}
+	return c.memoryServerClient.WriteIndex(ctx, arg)
+}
+
+// TestStandby tests the `SetStandby` and `FailOver` functions.  Checks that the
+// TLF is registered on the standby with the salts of the search server, that
+// the index writes are mirrored to it, and that after the failover the
+// searches are answered by the standby, including the file whose mirroring
+// failed, indexed again on failover.
+func TestStandby(t *testing.T) {
+	primary := newMemoryServerClient()
+	cli, dir := startTestClientWithServer(t, "", primary)
+	defer os.RemoveAll(dir)
+	standby := &unavailableWriteServerClient{memoryServerClient: newMemoryServerClient()}
+	ctx := context.Background()
+	if err := cli.setStandbyClient(ctx, standby); err != nil {
+		t.Fatalf("error when setting the standby: %s", err)
+	}
+	dirInfo, err := cli.getDirectoryInfo(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory info: %s", err)
+	}
+	if _, ok := standby.tlfInfos[dirInfo.tlfID]; !ok {
+		t.Fatalf("TLF not registered on the standby")
+	}
+
+	mirrored := filepath.Join(dir, "mirrored")
+	missed := filepath.Join(dir, "missed")
+	for _, pathname := range []string{mirrored, missed} {
+		if err := ioutil.WriteFile(pathname, []byte("failover"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		standby.down = pathname == missed
+		if err := cli.AddFile(ctx, dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+	standby.down = false
+	if results, err := cli.SearchWord(ctx, dir, "failover"); err != nil || !reflect.DeepEqual(results, []string{mirrored, missed}) {
+		t.Fatalf("incorrect results before the failover: %v, %v", results, err)
+	}
+
+	// The search server disappears for good.
+	primary.tlfInfos = make(map[sserver1.FolderID]sserver1.TlfInfo)
+	primary.indexes = make(memIndexStore)
+	if err := cli.FailOver(ctx); err != nil {
+		t.Fatalf("error when failing over: %s", err)
+	}
+	if results, err := cli.SearchWord(ctx, dir, "failover"); err != nil || !reflect.DeepEqual(results, []string{mirrored, missed}) {
+		t.Fatalf("incorrect results after the failover: %v, %v", results, err)
+	}
+}
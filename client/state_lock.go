@@ -0,0 +1,209 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// DirectoryLockedError is returned when a directory is already being indexed
+// by another instance of the client.
+type DirectoryLockedError struct {
+	Directory string // The directory locked by the other instance.
+	Holder    int    // The process ID of the other instance, or 0 if unknown.
+}
+
+// Error implements the error interface.
+func (e DirectoryLockedError) Error() string {
+	if e.Holder == 0 {
+		return fmt.Sprintf("directory %s is already being indexed by another client", e.Directory)
+	}
+	return fmt.Sprintf("directory %s is already being indexed by another client with pid %d", e.Directory, e.Holder)
+}
+
+// errLockHeld is returned by `lockFile` when the file is already locked by
+// another process.
+var errLockHeld = errors.New("lock held by another process")
+
+// stateLock is an exclusive lock on the local state of a directory, held by a
+// running client.  The lock file records the process ID of the holder while
+// the lock is held, and is emptied on a clean shutdown, so that a leftover
+// process ID reveals an unclean shutdown.  The lock itself is released by the
+// kernel when the process dies.
+type stateLock struct {
+	file *os.File // The open lock file.
+}
+
+// getStatePath returns the path of the local state file of `directory` with
+// the extension `ext` within `stateDir`.  The local state is kept on the local
+// disk instead of in the directory itself, as the directories are shared with
+// the other devices.
+func getStatePath(stateDir, directory, ext string) string {
+	hash := sha256.Sum256([]byte(directory))
+	return filepath.Join(stateDir, hex.EncodeToString(hash[:8])+ext)
+}
+
+// getStateLockPath returns the path of the lock file of `directory` within
+// `stateDir`.
+func getStateLockPath(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, ".lock")
+}
+
+// acquireStateLock locks the local state of `directory` within `stateDir`.
+// Returns whether the previous client of the directory has not shut down
+// cleanly, and a `DirectoryLockedError` if the lock is already held.
+func acquireStateLock(stateDir, directory string) (*stateLock, bool, error) {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, false, err
+	}
+	file, err := os.OpenFile(getStateLockPath(stateDir, directory), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := lockFile(file); err == errLockHeld {
+		// The holder records its process ID once it has the lock.
+		holder, _ := ioutil.ReadAll(file)
+		pid, _ := strconv.Atoi(string(holder))
+		file.Close()
+		return nil, false, DirectoryLockedError{Directory: directory, Holder: pid}
+	} else if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+
+	holder, err := ioutil.ReadAll(file)
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return &stateLock{file: file}, len(holder) > 0, nil
+}
+
+// abandon releases the lock without marking the shutdown as clean, so that the
+// next client of the directory reconciles it with the search server.
+func (l *stateLock) abandon() error {
+	return l.file.Close()
+}
+
+// release marks the shutdown as clean and releases the lock.
+func (l *stateLock) release() error {
+	err := l.file.Truncate(0)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LockDirectories locks the local state of all the directories of the client
+// within `stateDir`, so that no other instance of the client indexes them
+// concurrently, and loads the issues recorded for their files and the
+// operations queued while the search server was unreachable.  The word set
+// digests that earlier versions stored in the directories are copied to
+// `stateDir`.  Returns the directories whose previous client has not shut
+// down cleanly, which should be reconciled with the search server, e.g. with
+// `ReindexStale`, as the uploads in flight or held back by the padding
+// policies have been lost.  The directories are locked in lexical order.
+// Returns a `DirectoryLockedError` if any of the directories is locked by
+// another instance, in which case no lock is held and the unclean shutdowns
+// are left to be reported by the next call.
+func (c *Client) LockDirectories(stateDir string) ([]string, error) {
+	var unclean []string
+	fileIssues := make(map[string]map[string]FileIssue)
+	offlineQueues := make(map[string]*offlineQueue)
+	contentHashes := make(map[string]map[string]string)
+	stateLocks := make(map[string]*stateLock)
+	// The locks taken are abandoned rather than released on failure, so that
+	// the unclean shutdowns just detected are still reported next time.
+	abandon := func() {
+		for _, lock := range stateLocks {
+			lock.abandon()
+		}
+	}
+	// Holds the lock on the directories, so that none is added meanwhile.
+	c.dirLock.Lock()
+	defer c.dirLock.Unlock()
+	directories := make([]string, 0, len(c.directoryInfos))
+	for directory := range c.directoryInfos {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+	for _, directory := range directories {
+		lock, dirty, err := acquireStateLock(stateDir, directory)
+		if err != nil {
+			abandon()
+			return nil, err
+		}
+		stateLocks[directory] = lock
+		if dirty {
+			unclean = append(unclean, directory)
+		}
+		if fileIssues[directory], err = readFileIssues(stateDir, directory); err != nil {
+			abandon()
+			return nil, err
+		}
+		if offlineQueues[directory], err = readOfflineQueue(stateDir, directory); err != nil {
+			abandon()
+			return nil, err
+		}
+		if contentHashes[directory], err = readContentHashes(stateDir, directory); err != nil {
+			abandon()
+			return nil, err
+		}
+		if err := migrateWordSetDigests(stateDir, directory); err != nil {
+			abandon()
+			return nil, err
+		}
+	}
+	sort.Strings(unclean)
+
+	c.stateLocks = stateLocks
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	c.stateDir = stateDir
+	c.fileIssues = fileIssues
+	c.offlineQueues = offlineQueues
+	c.contentHashes = contentHashes
+	return unclean, nil
+}
+
+// UnlockDirectories marks the shutdown of the client as clean and releases the
+// locks taken by `LockDirectories`.  Should be called once the pending uploads
+// have been flushed.
+func (c *Client) UnlockDirectories() error {
+	c.dirLock.Lock()
+	defer c.dirLock.Unlock()
+	var err error
+	for _, lock := range c.stateLocks {
+		if releaseErr := lock.release(); err == nil {
+			err = releaseErr
+		}
+	}
+	c.stateLocks = nil
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	c.stateDir = ""
+	c.offlineQueues = nil
+	return err
+}
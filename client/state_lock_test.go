@@ -0,0 +1,115 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestLockDirectories tests the `LockDirectories` and `UnlockDirectories`
+// functions.  Checks that a second client cannot lock the same directories,
+// that a clean shutdown is not reported as unclean, and that a client dying
+// with the lock held is reported as an unclean shutdown.
+func TestLockDirectories(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "TestLockDirectoriesState")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	client1, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	client2, _ := startTestClientWithServer(t, dir, newMemoryServerClient())
+
+	unclean, err := client1.LockDirectories(stateDir)
+	if err != nil || len(unclean) != 0 {
+		t.Fatalf("incorrect first lock: %s, %v", unclean, err)
+	}
+	if _, err := client2.LockDirectories(stateDir); err != (DirectoryLockedError{Directory: client1.Directories()[0], Holder: os.Getpid()}) {
+		t.Fatalf("directory locked twice: %v", err)
+	}
+
+	if err := client1.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+	unclean, err = client2.LockDirectories(stateDir)
+	if err != nil || len(unclean) != 0 {
+		t.Fatalf("incorrect lock after a clean shutdown: %s, %v", unclean, err)
+	}
+
+	// Simulates a crash by closing the lock file without marking the
+	// shutdown as clean.
+	client2.stateLocks[client2.Directories()[0]].abandon()
+	client2.stateLocks = nil
+	unclean, err = client1.LockDirectories(stateDir)
+	if err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	if expected := client1.Directories(); !reflect.DeepEqual(expected, unclean) {
+		t.Fatalf("unclean shutdown not detected: expected %s actual %s", expected, unclean)
+	}
+	if err := client1.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+}
+
+// TestLockDirectoriesFailure tests the `LockDirectories` function when one of
+// the directories is locked by another instance.  Checks that the unclean
+// shutdown of a directory locked before the failure is still reported once
+// the lock can be taken.
+func TestLockDirectoriesFailure(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "TestLockDirectoriesFailureState")
+	if err != nil {
+		t.Fatalf("error when creating the state directory: %s", err)
+	}
+	defer os.RemoveAll(stateDir)
+	cli, dir1 := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "TestLockDirectoriesFailure")
+	if err != nil {
+		t.Fatalf("error when creating the test client directory: %s", err)
+	}
+	defer os.RemoveAll(dir2)
+	writeTestKbfsStatus(t, dir2, 1)
+	if _, err := cli.AddDirectory(context.Background(), dir2); err != nil {
+		t.Fatalf("error when adding the directory: %s", err)
+	}
+	directories := cli.Directories()
+	first, second := directories[0], directories[1]
+
+	// The previous client of the first directory has crashed, and the second
+	// one is held by another instance.
+	crashed, _, err := acquireStateLock(stateDir, first)
+	if err != nil {
+		t.Fatalf("error when locking the first directory: %s", err)
+	}
+	crashed.abandon()
+	held, _, err := acquireStateLock(stateDir, second)
+	if err != nil {
+		t.Fatalf("error when locking the second directory: %s", err)
+	}
+	if _, err := cli.LockDirectories(stateDir); err != (DirectoryLockedError{Directory: second, Holder: os.Getpid()}) {
+		t.Fatalf("incorrect error when the second directory is locked: %v", err)
+	}
+
+	if err := held.release(); err != nil {
+		t.Fatalf("error when releasing the lock: %s", err)
+	}
+	unclean, err := cli.LockDirectories(stateDir)
+	if err != nil {
+		t.Fatalf("error when locking the directories: %s", err)
+	}
+	if expected := []string{first}; !reflect.DeepEqual(expected, unclean) {
+		t.Fatalf("unclean shutdown lost: expected %s actual %s", expected, unclean)
+	}
+	if err := cli.UnlockDirectories(); err != nil {
+		t.Fatalf("error when unlocking the directories: %s", err)
+	}
+}
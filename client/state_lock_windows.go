@@ -0,0 +1,43 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package client
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// lockfileFailImmediately and lockfileExclusiveLock are the flags of
+	// `LockFileEx` for a non-blocking exclusive lock.
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	// errorLockViolation is the error of `LockFileEx` when the range is
+	// already locked.
+	errorLockViolation syscall.Errno = 33
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// lockFile takes an exclusive lock on `file` without blocking, released by the
+// system once the file is closed or the process dies.  Returns `errLockHeld`
+// if another process holds the lock.  The locked byte lies far beyond the end
+// of the file, as the locks are mandatory on Windows and the other processes
+// must still be able to read the process ID of the holder.
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 0x7fffffff}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return errLockHeld
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"time"
+)
+
+// DirectoryStats are the indexing statistics of a directory of the client.
+type DirectoryStats struct {
+	Directory        string        // The absolute path of the directory.
+	IndexedFiles     int           // The number of files with an index on the search server.
+	UploadedBytes    int64         // The number of bytes of indexes uploaded since the client started.
+	LastScan         time.Time     // The time the directory was last scanned, or the zero time if never.
+	LastScanDuration time.Duration // The duration of the last successful scan since the client started, or 0 if none.
+	PendingFiles     int           // The number of files left to index by the scan in progress.
+	PendingOps       int           // The number of uploads, renames and deletions of indexes queued while the search server is unreachable or held back for the next batch.
+}
+
+// recordUpload counts the upload of an index of `length` bytes for the
+// directory of `dirInfo`.
+func (c *Client) recordUpload(dirInfo *DirectoryInfo, length int) {
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	c.uploadedBytes[dirInfo.absDir] += int64(length)
+}
+
+// GetDirectoryStats returns the indexing statistics of `directory`.
+func (c *Client) GetDirectoryStats(directory string) (DirectoryStats, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return DirectoryStats{}, err
+	}
+
+	indexedFiles, err := c.countIndexedDocuments(dirInfo.absDir)
+	if err != nil {
+		return DirectoryStats{}, err
+	}
+	lastScan, err := readLastScan(dirInfo.absDir)
+	if err != nil {
+		return DirectoryStats{}, err
+	}
+	pendingOps, err := c.GetOfflineQueueLength(dirInfo.absDir)
+	if err != nil {
+		return DirectoryStats{}, err
+	}
+	pendingOps += dirInfo.padding.numPending()
+
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	stats := DirectoryStats{
+		Directory:        dirInfo.absDir,
+		IndexedFiles:     indexedFiles,
+		UploadedBytes:    c.uploadedBytes[dirInfo.absDir],
+		LastScan:         lastScan,
+		LastScanDuration: c.scanDurations[dirInfo.absDir],
+		PendingOps:       pendingOps,
+	}
+	if progress, ok := c.progress[dirInfo.absDir]; ok {
+		stats.PendingFiles = progress.Remaining()
+	}
+	return stats, nil
+}
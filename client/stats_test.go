@@ -0,0 +1,48 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestGetDirectoryStats tests the `GetDirectoryStats` function.  Checks that
+// the indexed files and the bytes of the indexes uploaded by a scan are
+// counted, and that no work is left pending after it.
+func TestGetDirectoryStats(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	lockTestClient(t, client, dir)
+
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file"+strconv.Itoa(i)), []byte("some content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when scanning the directory: %s", report.Err)
+	}
+
+	stats, err := client.GetDirectoryStats(dir)
+	if err != nil {
+		t.Fatalf("error when getting the statistics: %s", err)
+	}
+	var uploaded int64
+	tlfID := client.directoryInfos[dir].tlfID
+	for _, docID := range server.docIDs(tlfID) {
+		secIndex, _, _ := server.indexes.get(tlfID, docID)
+		uploaded += int64(len(secIndex))
+	}
+	if stats.IndexedFiles != 3 || stats.UploadedBytes != uploaded || stats.LastScan.IsZero() || stats.PendingFiles != 0 || stats.PendingOps != 0 {
+		t.Fatalf("incorrect statistics: %+v, expected %d bytes uploaded", stats, uploaded)
+	}
+}
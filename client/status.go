@@ -0,0 +1,74 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
+)
+
+// DirectoryStatus describes the indexing state of a directory of the client.
+type DirectoryStatus struct {
+	Directory string         // The absolute path of the directory.
+	KeyGen    libkbfs.KeyGen // The latest key generation known for the directory.
+	LastScan  time.Time      // The time the directory was last scanned, or the zero time if never.
+	NumIssues int            // The number of files skipped or failed to be indexed.
+	Conflicts int            // The number of index writes that overwrote the write of another client.
+	ScanError error          // The error of the last scan of the directory, or of the read of the time of its last scan.  Nil if it succeeded.
+	// Whether the TLF of the directory has been registered by a client
+	// analyzing the words differently, in which case the searches may miss
+	// the words of the files indexed by either client.
+	AnalysisMismatch bool
+}
+
+// GetDirectoryStatus returns the indexing state of `directory`.  A directory
+// that cannot be read, e.g. as its KBFS mount is offline, has its error in the
+// status instead of failing the call.
+func (c *Client) GetDirectoryStatus(directory string) (DirectoryStatus, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return DirectoryStatus{}, err
+	}
+
+	c.progressLock.Lock()
+	scanErr := c.scanErrors[dirInfo.absDir]
+	c.progressLock.Unlock()
+	lastScan, err := readLastScan(dirInfo.absDir)
+	if err != nil && scanErr == nil {
+		scanErr = err
+	}
+
+	c.issuesLock.Lock()
+	numIssues := len(c.fileIssues[dirInfo.absDir])
+	c.issuesLock.Unlock()
+
+	dirInfo.keyGenLock.RLock()
+	defer dirInfo.keyGenLock.RUnlock()
+	return DirectoryStatus{Directory: dirInfo.absDir, KeyGen: dirInfo.keyGen, LastScan: lastScan, NumIssues: numIssues, Conflicts: dirInfo.revisions.numConflicts(), ScanError: scanErr, AnalysisMismatch: libsearch.AnalysisMismatch(dirInfo.tlfInfo.AnalysisFingerprint)}, nil
+}
+
+// recordScan records the outcome of the scan of `report`: its error, clearing
+// the error of a previous scan if nil, or its duration if it succeeded.  The
+// cached search results are dropped if the scan failed or found files changed.
+func (c *Client) recordScan(report IndexReport) {
+	directory, _ := filepath.Abs(report.Directory)
+	if report.Err != nil || len(report.Added) > 0 || len(report.Deleted) > 0 || len(report.Renamed) > 0 || report.Deferred > 0 {
+		if dirInfo, err := c.getDirectoryInfo(directory); err == nil {
+			c.invalidateResults(dirInfo)
+		}
+	}
+	c.progressLock.Lock()
+	defer c.progressLock.Unlock()
+	if report.Err == nil {
+		delete(c.scanErrors, directory)
+		c.scanDurations[directory] = report.Elapsed
+		c.metrics.scanned(directory, report.Elapsed)
+	} else {
+		c.scanErrors[directory] = report.Err
+	}
+}
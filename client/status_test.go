@@ -0,0 +1,86 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestGetDirectoryStatus tests the `GetDirectoryStatus` function.  Checks that
+// the key generation is reported, and that the time of the last scan is only
+// set once the directory has been scanned.
+func TestGetDirectoryStatus(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	status, err := client.GetDirectoryStatus(dir)
+	if err != nil {
+		t.Fatalf("error when getting the directory status: %s", err)
+	}
+	if status.KeyGen != 1 || !status.LastScan.IsZero() {
+		t.Fatalf("incorrect status before the first scan: %+v", status)
+	}
+
+	report := client.IndexUpdatedFiles(context.Background(), dir)
+	if report.Err != nil {
+		t.Fatalf("error when indexing the files: %s", report.Err)
+	}
+	if status, err = client.GetDirectoryStatus(dir); err != nil || !status.LastScan.Equal(report.Start) {
+		t.Fatalf("incorrect status after a scan: %+v, %v", status, err)
+	}
+}
+
+// TestScanErrorStatus tests that the error of a failed scan, e.g. as the KBFS
+// mount of the directory is offline, is reported by `GetDirectoryStatus`
+// instead of failing it, and that it is cleared by the next successful scan.
+func TestScanErrorStatus(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	if err := os.Rename(dir, dir+".offline"); err != nil {
+		t.Fatalf("error when moving the test directory: %s", err)
+	}
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err == nil {
+		t.Fatalf("no error when scanning a missing directory")
+	}
+	if status, err := client.GetDirectoryStatus(dir); err != nil || status.ScanError == nil {
+		t.Fatalf("incorrect status after a failed scan: %+v, %v", status, err)
+	}
+
+	if err := os.Rename(dir+".offline", dir); err != nil {
+		t.Fatalf("error when moving the test directory back: %s", err)
+	}
+	if report := client.IndexUpdatedFiles(context.Background(), dir); report.Err != nil {
+		t.Fatalf("error when indexing the files: %s", report.Err)
+	}
+	if status, err := client.GetDirectoryStatus(dir); err != nil || status.ScanError != nil {
+		t.Fatalf("incorrect status after a successful scan: %+v, %v", status, err)
+	}
+}
+
+// TestAnalysisMismatchStatus tests the `AnalysisMismatch` field of the
+// `DirectoryStatus`.  Checks that it is only set for a TLF registered by a
+// client analyzing the words differently.
+func TestAnalysisMismatchStatus(t *testing.T) {
+	server := newMemoryServerClient()
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	if status, err := client.GetDirectoryStatus(dir); err != nil || status.AnalysisMismatch {
+		t.Fatalf("incorrect status for a TLF registered by this client: %+v, %v", status, err)
+	}
+
+	other := newMemoryServerClient()
+	if _, err := other.RegisterTlfIfNotExists(context.Background(), sserver1.RegisterTlfIfNotExistsArg{TlfID: "aRandomTLFID", LenSalt: 32, FpRate: 0.000001, NumUniqWords: 1000, AnalysisFingerprint: []byte("another profile")}); err != nil {
+		t.Fatalf("error when registering the TLF: %s", err)
+	}
+	client, _ = startTestClientWithServer(t, dir, other)
+	if status, err := client.GetDirectoryStatus(dir); err != nil || !status.AnalysisMismatch {
+		t.Fatalf("incorrect status for a TLF registered by another profile: %+v, %v", status, err)
+	}
+}
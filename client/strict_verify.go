@@ -0,0 +1,165 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/keybase/search/libsearch"
+	"golang.org/x/net/context"
+)
+
+// SetVerifyWorkers sets the number of files the strict searches scan
+// concurrently to eliminate the false positives.  Defaults to the number of
+// CPUs.  A `workers` below 1 is treated as 1.  Should be called before the
+// searches are performed.
+func (c *Client) SetVerifyWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	c.verifyWorkers = workers
+}
+
+// SearchWordStrictStream is similar to `SearchWordStrict`, but sends the
+// filenames on the returned channel as soon as each file is confirmed to
+// contain `word`, in no particular order, so that the first results can be
+// shown before all the files are scanned.  Stops scanning once `limit` files
+// have been confirmed, if `limit` is positive.  The channel is closed once all
+// the results have been sent, or once `ctx` is canceled.
+func (c *Client) SearchWordStrictStream(ctx context.Context, directory, word string, limit int) (<-chan Result, error) {
+	files, err := c.SearchWord(ctx, directory, word)
+	if err != nil {
+		return nil, err
+	}
+	confirmed := c.verifyFiles(ctx, files, limit, func(filename string) bool {
+		return containsWord(filename, word)
+	})
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+		for filename := range confirmed {
+			select {
+			case results <- Result{Filename: filename}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results, nil
+}
+
+// verifyFiles scans the `files` with `match` on as many concurrent workers as
+// set by `SetVerifyWorkers`, and sends the files matching on the returned
+// channel as soon as each is confirmed, in no particular order.  Stops scanning
+// once `limit` files have been confirmed, if `limit` is positive, or once `ctx`
+// is done.  The channel is closed once the scan is over; the caller must read
+// it until then or cancel `ctx`.
+func (c *Client) verifyFiles(ctx context.Context, files []string, limit int, match func(filename string) bool) <-chan string {
+	ctx, cancel := context.WithCancel(ctx)
+	workers := c.verifyWorkers
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	next := make(chan string)
+	go func() {
+		defer close(next)
+		for _, filename := range files {
+			select {
+			case next <- filename:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	confirmed := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range next {
+				if ctx.Err() != nil || !match(filename) {
+					continue
+				}
+				select {
+				case confirmed <- filename:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(confirmed)
+	}()
+
+	results := make(chan string)
+	go func() {
+		defer close(results)
+		// Stops the workers still scanning once the results are over.
+		defer cancel()
+		count := 0
+		for filename := range confirmed {
+			// Checked first, as the select picks randomly among the
+			// ready cases.
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case results <- filename:
+			case <-ctx.Done():
+				return
+			}
+			if count++; limit > 0 && count >= limit {
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// verifiedFiles returns the subset of `files` matching `match`, sorted, as
+// scanned by `verifyFiles`, or the error of `ctx` if it is done before all the
+// files are scanned.
+func (c *Client) verifiedFiles(ctx context.Context, files []string, match func(filename string) bool) ([]string, error) {
+	filenames := []string{}
+	for filename := range c.verifyFiles(ctx, files, 0, match) {
+		filenames = append(filenames, filename)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// containsWord returns whether the file at `filename` has an exact match (cases
+// ignored) of `word` as a whole word.  A file that cannot be read does not.
+func containsWord(filename, word string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	found, err := libsearch.ContainsWord(file, word)
+	return err == nil && found
+}
+
+// containsNear returns whether the normalized words `a` and `b` appear within
+// `window` words of each other in the file at `filename`.  A file that cannot
+// be read does not.
+func containsNear(filename, a, b string, window int) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	found, err := libsearch.ContainsNear(file, a, b, window)
+	return err == nil && found
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestSearchWordStrictStream tests the `SearchWordStrictStream` function.
+// Checks that all the files containing the word are streamed without the false
+// positives, and that no more than the limit are.
+func TestSearchWordStrictStream(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	cli.SetVerifyWorkers(3)
+	ctx := context.Background()
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		pathname := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(pathname, []byte("streamed"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := cli.AddFile(ctx, dir, pathname); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+		expected = append(expected, pathname)
+	}
+	// Indexed, but no longer containing the word.
+	if err := ioutil.WriteFile(expected[9], []byte("changed"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	expected = expected[:9]
+
+	for _, limit := range []int{0, 4} {
+		results, err := cli.SearchWordStrictStream(ctx, dir, "streamed", limit)
+		if err != nil {
+			t.Fatalf("error when searching: %s", err)
+		}
+		var actual []string
+		for result := range results {
+			if result.Err != nil {
+				t.Fatalf("error in the results: %s", result.Err)
+			}
+			actual = append(actual, result.Filename)
+		}
+		sort.Strings(actual)
+		if limit == 0 && !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("incorrect results: expected %v, actual %v", expected, actual)
+		} else if limit > 0 && len(actual) != limit {
+			t.Fatalf("incorrect number of results with a limit of %d: %d", limit, len(actual))
+		}
+	}
+}
+
+// TestVerifyFilesStop tests the `verifyFiles` and `verifiedFiles` functions.
+// Checks that the scan stops soon after the limit is reached, and that a
+// canceled context is reported.
+func TestVerifyFilesStop(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	cli.SetVerifyWorkers(1)
+	files := make([]string, 1000)
+	for i := range files {
+		files[i] = "file" + strconv.Itoa(i)
+	}
+
+	var scanned int32
+	match := func(string) bool {
+		atomic.AddInt32(&scanned, 1)
+		return true
+	}
+	count := 0
+	for range cli.verifyFiles(context.Background(), files, 2, match) {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("incorrect number of results: %d", count)
+	}
+	if n := atomic.LoadInt32(&scanned); n > 4 {
+		t.Fatalf("scan not stopped at the limit: %d files scanned", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cli.verifiedFiles(ctx, files, match); err != context.Canceled {
+		t.Fatalf("incorrect error for a canceled context: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+const (
+	// summaryRefreshInterval is how long the summary of a TLF downloaded from
+	// the search server is relied on before being downloaded again.
+	summaryRefreshInterval = time.Minute
+	// dummySummaryWordLength is the number of bytes per unique word the
+	// contributions of the dummy indexes to the summaries pretend.
+	dummySummaryWordLength = 8
+)
+
+// tlfSummaryCache is the summary of a TLF last downloaded from the search
+// server, along with the contributions of the client since.
+type tlfSummaryCache struct {
+	lock    sync.Mutex            // Protects `summary` and `fetched`.
+	summary *libsearch.TlfSummary // The summary.  Nil if not downloaded yet, or if the summary was incomplete.
+	fetched time.Time             // The time the summary was downloaded.
+}
+
+// SetTlfSummaries sets whether the client contributes the words of the files
+// it indexes to the summaries of the TLFs, and downloads the summaries to skip
+// the search requests for the words in none of the files.  The summaries let
+// the search server tell which files share a word, so they are disabled by
+// default.  The summary is only relied on once all the files of the TLF have
+// contributed, and the files indexed by the other clients are missed for up to
+// `summaryRefreshInterval`.  Should be called before any file is added.
+func (c *Client) SetTlfSummaries(enabled bool) {
+	c.tlfSummaries = enabled
+}
+
+// buildSummary returns the marshaled contribution of the document with
+// `words` to the summary of the TLF of `dirInfo`, built with the indexer at
+// `keyIndex`.  Returns nil if the summaries are disabled.
+func (c *Client) buildSummary(dirInfo *DirectoryInfo, keyIndex int, words []string) ([]byte, error) {
+	if !c.tlfSummaries {
+		return nil, nil
+	}
+	return dirInfo.getIndexer(keyIndex).BuildSummary(words).MarshalBinary()
+}
+
+// mergeSummary sends the marshaled contribution `summary` of `docID` to the
+// summary of the TLF of `dirInfo`, retried as set by `SetUploadRetries`, and
+// merges it into the summary cached by the client.
+func (c *Client) mergeSummary(ctx context.Context, dirInfo *DirectoryInfo, docID sserver1.DocumentID, summary []byte) error {
+	err := c.withRetries(ctx, func() error {
+		return c.searchCli.MergeTlfSummary(ctx, sserver1.MergeTlfSummaryArg{TlfID: dirInfo.tlfID, DocID: docID, Filter: summary})
+	})
+	if err != nil {
+		return err
+	}
+	contribution := new(libsearch.TlfSummary)
+	if err := contribution.UnmarshalBinary(summary); err != nil {
+		return err
+	}
+	dirInfo.summary.lock.Lock()
+	defer dirInfo.summary.lock.Unlock()
+	if dirInfo.summary.summary != nil {
+		dirInfo.summary.summary.Merge(contribution)
+	}
+	return nil
+}
+
+// getTlfSummary returns the summary of the TLF of `dirInfo`, downloaded again
+// if older than `summaryRefreshInterval`.  Returns nil if the summary cannot be
+// relied on.
+func (c *Client) getTlfSummary(ctx context.Context, dirInfo *DirectoryInfo) *libsearch.TlfSummary {
+	dirInfo.summary.lock.Lock()
+	defer dirInfo.summary.lock.Unlock()
+	if !dirInfo.summary.fetched.IsZero() && c.clock.Since(dirInfo.summary.fetched) < summaryRefreshInterval {
+		return dirInfo.summary.summary
+	}
+	dirInfo.summary.summary = nil
+	res, err := c.searchCli.GetTlfSummary(ctx, dirInfo.tlfID)
+	if err != nil {
+		// Retried on the next search.
+		return nil
+	}
+	dirInfo.summary.fetched = c.clock.Now()
+	if !res.Complete {
+		return nil
+	}
+	summary := new(libsearch.TlfSummary)
+	if err := summary.UnmarshalBinary(res.Filter); err != nil {
+		return nil
+	}
+	dirInfo.summary.summary = summary
+	return summary
+}
+
+// absentFromSummary returns true if `word` is in none of the files of the TLF
+// of `dirInfo` according to its summary, under any of the key generations of
+// the client, so that searching for it can be skipped.  Always returns false if
+// the summaries are disabled or cannot be relied on.
+func (c *Client) absentFromSummary(ctx context.Context, dirInfo *DirectoryInfo, word string) bool {
+	if !c.tlfSummaries {
+		return false
+	}
+	summary := c.getTlfSummary(ctx, dirInfo)
+	if summary == nil {
+		return false
+	}
+	for keyIndex := 0; keyIndex <= dirInfo.getLatestKeyIndex(); keyIndex++ {
+		if summary.ContainsBuckets(dirInfo.getIndexer(keyIndex).SummaryBuckets(word)) {
+			return false
+		}
+	}
+	return true
+}
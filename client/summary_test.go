@@ -0,0 +1,73 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+// TestTlfSummaries tests the `SetTlfSummaries` function.  Checks that the
+// searches for the words in none of the files are answered without a search
+// request, that the words in some of the files are still found, and that the
+// summary is no longer relied on once a file has not contributed to it.
+func TestTlfSummaries(t *testing.T) {
+	server := &conjunctionCountingServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	client.SetTlfSummaries(true)
+
+	var filenames []string
+	for i, content := range []string{"apple banana", "banana cherry"} {
+		filename := filepath.Join(dir, "file"+strconv.Itoa(i)+".txt")
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := client.AddFile(context.Background(), dir, filename); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+		filenames = append(filenames, filename)
+	}
+
+	actual, err := client.SearchWord(context.Background(), dir, "apple")
+	if err != nil || !reflect.DeepEqual(actual, filenames[:1]) || server.wordSearches != 1 {
+		t.Fatalf("incorrect search for a word in a file: %v, %d searches, %v", actual, server.wordSearches, err)
+	}
+	actual, err = client.SearchWord(context.Background(), dir, "durian")
+	if err != nil || len(actual) != 0 || server.wordSearches != 1 {
+		t.Fatalf("incorrect search for a word in no file: %v, %d searches, %v", actual, server.wordSearches, err)
+	}
+	results, err := client.SearchWords(context.Background(), dir, []string{"banana", "durian"})
+	if err != nil || !reflect.DeepEqual(results["banana"], filenames) || len(results["durian"]) != 0 || server.wordSearches != 2 {
+		t.Fatalf("incorrect search for several words: %v, %d searches, %v", results, server.wordSearches, err)
+	}
+	actual, err = client.SearchQuery(context.Background(), dir, "banana durian")
+	if err != nil || len(actual) != 0 || server.conjunctions != 0 {
+		t.Fatalf("incorrect query with a word in no file: %v, %d conjunctions, %v", actual, server.conjunctions, err)
+	}
+
+	// An index written without a contribution makes the summary incomplete.
+	dirInfo := client.directoryInfos[dir]
+	docID := server.docIDs(dirInfo.tlfID)[0]
+	secIndex, _, err := server.indexes.get(dirInfo.tlfID, docID)
+	if err != nil {
+		t.Fatalf("error when reading the index: %s", err)
+	}
+	if _, err := server.WriteIndex(context.Background(), sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndex, DocID: docID}); err != nil {
+		t.Fatalf("error when writing the index: %s", err)
+	}
+	dirInfo.summary.fetched = time.Time{}
+	if _, err := client.SearchWord(context.Background(), dir, "durian"); err != nil || server.wordSearches != 3 {
+		t.Fatalf("incomplete summary relied on: %d searches, %v", server.wordSearches, err)
+	}
+}
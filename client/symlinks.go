@@ -0,0 +1,157 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy is how the scans handle the symlinks in the directories.
+type SymlinkPolicy string
+
+const (
+	// SymlinkSkip skips the symlinks, the default.
+	SymlinkSkip SymlinkPolicy = "skip"
+	// SymlinkFollow follows the symlinks resolving within the directory, and
+	// skips the other ones.  The files reached through a symlink are indexed
+	// under their real path, once whatever the number of symlinks to them.
+	SymlinkFollow SymlinkPolicy = "follow"
+)
+
+// errSymlinkSkipped is the error of adding a symlink while the symlinks are
+// skipped.
+var errSymlinkSkipped = errors.New("symlinks are skipped")
+
+// SetSymlinkPolicy sets how the scans handle the symlinks in the directories,
+// `SymlinkSkip` by default.  Should be called before the scans are started.
+func (c *Client) SetSymlinkPolicy(policy SymlinkPolicy) {
+	c.symlinks = policy
+}
+
+// isHiddenPath returns whether a component of `relPath` is hidden.
+func isHiddenPath(relPath string) bool {
+	for _, component := range strings.Split(relPath, string(filepath.Separator)) {
+		if strings.HasPrefix(component, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveInDirectory returns the real path of `path` expressed within
+// `directory`, i.e. with all its symlinks resolved, and false if it resolves
+// outside `directory` or within a hidden subdirectory.
+func resolveInDirectory(directory, path string) (string, bool) {
+	realDir, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return "", false
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	relPath, err := relPathStrict(realDir, realPath)
+	if err != nil || isHiddenPath(relPath) {
+		return "", false
+	}
+	return filepath.Join(directory, relPath), true
+}
+
+// realPath returns the path the file at `pathname` in `directory` is indexed
+// under: its real path if it is a symlink followed as set by
+// `SetSymlinkPolicy`, so that its document ID derives from the file it points
+// to.  Returns an error if the symlink is skipped.
+func (c *Client) realPath(directory, pathname string) (string, error) {
+	info, err := os.Lstat(pathname)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return pathname, nil
+	}
+	if c.symlinks != SymlinkFollow {
+		return "", errSymlinkSkipped
+	}
+	realPath, ok := resolveInDirectory(directory, pathname)
+	if !ok {
+		return "", errors.New("symlink resolving outside the directory")
+	}
+	return realPath, nil
+}
+
+// walkFiles walks the file tree of `directory` rooted at `root` like
+// `filepath.Walk`, handling the symlinks as set by `policy`: they are skipped,
+// or followed if they resolve within `directory`, in which case `walkFn` is
+// called with the real path of their target expressed within `directory` and
+// its info.  Each file or subdirectory is walked once, whatever the number of
+// symlinks to it, so that the symlink cycles are broken.
+func walkFiles(directory, root string, policy SymlinkPolicy, walkFn filepath.WalkFunc) error {
+	if policy != SymlinkFollow {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			return walkFn(path, info, err)
+		})
+	}
+	w := symlinkWalker{directory: directory, visited: make(map[string]bool), walkFn: walkFn}
+	return w.walk(root)
+}
+
+// symlinkWalker walks a file tree following the symlinks resolving within a
+// directory.
+type symlinkWalker struct {
+	directory string            // The directory the symlinks are followed within.
+	visited   map[string]bool   // The real paths of the files and subdirectories already walked.
+	walkFn    filepath.WalkFunc // Called for each file and subdirectory walked.
+}
+
+// walk walks the file tree rooted at `root`, whose path within the directory
+// is its real one unless it is the root of the walk.
+func (w *symlinkWalker) walk(root string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		info, _ := os.Lstat(root)
+		return w.walkFn(root, info, err)
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return w.walkFn(path, info, err)
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		realPath := filepath.Join(realRoot, relPath)
+		if w.visited[realPath] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		w.visited[realPath] = true
+		if info.Mode()&os.ModeSymlink == 0 {
+			return w.walkFn(path, info, nil)
+		}
+
+		target, ok := resolveInDirectory(w.directory, path)
+		if !ok {
+			return nil
+		}
+		realTarget, err := filepath.EvalSymlinks(target)
+		if err != nil || w.visited[realTarget] {
+			return nil
+		}
+		targetInfo, err := os.Stat(target)
+		if err != nil {
+			return nil
+		}
+		if targetInfo.IsDir() {
+			return w.walk(target)
+		}
+		w.visited[realTarget] = true
+		return w.walkFn(target, targetInfo, nil)
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestWalkFiles tests the `walkFiles` function.  Checks that the symlinks are
+// skipped by default, and that with `SymlinkFollow` the ones resolving within
+// the directory are followed to the real paths of their targets, once each,
+// while the ones resolving outside the directory are skipped and the cycles
+// are broken.
+func TestWalkFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWalkFiles")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "TestWalkFilesOutside")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(outside)
+
+	for _, relPath := range []string{"sub/file", "other/target", filepath.Join(outside, "secret")} {
+		path := relPath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, relPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("error when creating the test directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("content"), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+	}
+	links := map[string]string{
+		"sub/link":     "../other/target",
+		"sub/loop":     "..",
+		"sub/escape":   outside,
+		"sub/again":    "file",
+		"other/subdir": "../sub",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatalf("error when creating symlink: %s", err)
+		}
+	}
+
+	walk := func(policy SymlinkPolicy) []string {
+		var files []string
+		err := walkFiles(dir, filepath.Join(dir, "sub"), policy, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				relPath, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				files = append(files, relPath)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("error when walking the directory: %s", err)
+		}
+		sort.Strings(files)
+		return files
+	}
+	if files := walk(SymlinkSkip); !reflect.DeepEqual(files, []string{"sub/file"}) {
+		t.Fatalf("symlinks not skipped: %v", files)
+	}
+	if files := walk(SymlinkFollow); !reflect.DeepEqual(files, []string{"other/target", "sub/file"}) {
+		t.Fatalf("symlinks incorrectly followed: %v", files)
+	}
+}
+
+// TestAddFileSymlink tests the `AddFile` function on a symlink.  Checks that
+// it is rejected while the symlinks are skipped, and indexed under the real
+// path of its target once followed.
+func TestAddFileSymlink(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+	if err := ioutil.WriteFile(target, []byte("linked content"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatalf("error when creating symlink: %s", err)
+	}
+
+	if err := cli.AddFile(context.Background(), dir, link); err != errSymlinkSkipped {
+		t.Fatalf("symlink not skipped: %v", err)
+	}
+	cli.SetSymlinkPolicy(SymlinkFollow)
+	if err := cli.AddFile(context.Background(), dir, link); err != nil {
+		t.Fatalf("error when adding the symlink: %s", err)
+	}
+	if results, err := cli.SearchWord(context.Background(), dir, "linked"); err != nil || !reflect.DeepEqual(results, []string{target}) {
+		t.Fatalf("symlink not indexed under the real path: %v, %v", results, err)
+	}
+}
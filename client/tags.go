@@ -0,0 +1,311 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tagsFile is the name of the file in a TLF mapping the relative paths
+	// of its files to their tags.  As the file is synced through KBFS, the
+	// tags are shared by all the devices of all the members.
+	tagsFile = ".search_kbfs_tags"
+	// tagsPathnamePrefix is the directory the pathnames encrypted into the
+	// document IDs of the auxiliary indexes of the tags are under, followed
+	// by the pathname of their file, so that the searches map them back to
+	// the file.
+	tagsPathnamePrefix = ".search_kbfs_tagged"
+)
+
+// tagsPathname returns the pathname the auxiliary index of the tags of the file
+// at `relPath` is stored under.
+func tagsPathname(relPath string) string {
+	return filepath.Join(tagsPathnamePrefix, relPath)
+}
+
+// parseTagsPathname checks whether `pathname` is the one of the auxiliary index
+// of the tags of a file.  If so, returns the pathname of the file.
+func parseTagsPathname(pathname string) (string, bool) {
+	prefix := tagsPathnamePrefix + string(filepath.Separator)
+	if !strings.HasPrefix(pathname, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(pathname, prefix), true
+}
+
+// isTagKeyword returns whether `term` of a query is a tag keyword, e.g.
+// "tag:urgent".
+func isTagKeyword(term string) bool {
+	keyword, ok := libsearch.ParseMetadataKeyword(term)
+	return ok && strings.HasPrefix(keyword, libsearch.MetadataTag+":")
+}
+
+// splitTagTerms splits the `terms` of a query into the tag keywords and the
+// other terms.
+func splitTagTerms(terms []string) (tags []string, others []string) {
+	for _, term := range terms {
+		if isTagKeyword(term) {
+			tags = append(tags, term)
+		} else {
+			others = append(others, term)
+		}
+	}
+	return tags, others
+}
+
+// normalizeTags returns the distinct `tags` normalized as the words of the
+// indexes, sorted in increasing order.  The empty ones are dropped.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = libsearch.NormalizeKeyword(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return uniqueWords(normalized)
+}
+
+// readTags reads the tags of the files of the TLF at `directory`, keyed by
+// their relative path.
+func readTags(directory string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	tagsJSON, err := ioutil.ReadFile(filepath.Join(directory, tagsFile))
+	if os.IsNotExist(err) {
+		return tags, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(tagsJSON, &tags)
+	return tags, err
+}
+
+// writeTags writes the `tags` of the files of the TLF at `directory`.
+func writeTags(directory string, tags map[string][]string) error {
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(filepath.Join(directory, tagsFile), tagsJSON)
+}
+
+// GetTags returns the tags attached to the file at `pathname` in `directory`,
+// sorted in increasing order.
+func (c *Client) GetTags(directory, pathname string) ([]string, error) {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil {
+		return nil, err
+	}
+	return tags[relPath], nil
+}
+
+// AddTags attaches the `tags` to the file at `pathname` in `directory`, so that
+// the queries with the keywords "tag:" followed by a tag, e.g. "tag:urgent",
+// match the file even if the tag is not among its words.  The tags are
+// normalized as the words of the indexes.  They are indexed as synthetic
+// keywords in an auxiliary index of the file, apart from its content, so that
+// tagging a file does not index it again, and listed in the hidden
+// `.search_kbfs_tags` file of the TLF.
+func (c *Client) AddTags(ctx context.Context, directory, pathname string, tags []string) error {
+	return c.updateTags(ctx, directory, pathname, func(curr []string) []string {
+		return normalizeTags(append(curr[:len(curr):len(curr)], tags...))
+	})
+}
+
+// RemoveTags detaches the `tags` from the file at `pathname` in `directory`.
+// The auxiliary index of the tags is deleted once the file has none left.
+func (c *Client) RemoveTags(ctx context.Context, directory, pathname string, tags []string) error {
+	removed := make(map[string]bool)
+	for _, tag := range normalizeTags(tags) {
+		removed[tag] = true
+	}
+	return c.updateTags(ctx, directory, pathname, func(curr []string) []string {
+		var kept []string
+		for _, tag := range curr {
+			if !removed[tag] {
+				kept = append(kept, tag)
+			}
+		}
+		return kept
+	})
+}
+
+// updateTags replaces the tags of the file at `pathname` in `directory` with
+// the ones `update` returns from the current ones, and writes its auxiliary
+// index accordingly.
+func (c *Client) updateTags(ctx context.Context, directory, pathname string, update func(curr []string) []string) error {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return err
+	}
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(pathname); err != nil {
+		return err
+	}
+
+	dirInfo.tagsLock.Lock()
+	defer dirInfo.tagsLock.Unlock()
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil {
+		return err
+	}
+	updated := update(tags[relPath])
+	if strings.Join(updated, " ") == strings.Join(tags[relPath], " ") {
+		return nil
+	}
+	if err := c.writeTagsIndex(ctx, dirInfo, relPath, updated); err != nil {
+		return err
+	}
+	if len(updated) == 0 {
+		delete(tags, relPath)
+	} else {
+		tags[relPath] = updated
+	}
+	return writeTags(dirInfo.absDir, tags)
+}
+
+// writeTagsIndex writes the auxiliary index of the `tags` of the file at
+// `relPath` in the directory of `dirInfo`, or deletes it if there are none.
+func (c *Client) writeTagsIndex(ctx context.Context, dirInfo *DirectoryInfo, relPath string, tags []string) error {
+	if len(tags) == 0 {
+		return c.DeleteFile(ctx, dirInfo.absDir, filepath.Join(dirInfo.absDir, tagsPathname(relPath)))
+	}
+
+	keyIndex := dirInfo.getLatestKeyIndex()
+	pathnameKey := dirInfo.getPathnameKey(keyIndex)
+	docID, err := libsearch.PathnameToDocID(dirInfo.keyGen, tagsPathname(relPath), pathnameKey)
+	if err != nil {
+		return err
+	}
+
+	keywords := make([]string, len(tags))
+	for i, tag := range tags {
+		keywords[i] = libsearch.MetadataKeyword(libsearch.MetadataTag, tag)
+	}
+	secIndex, words, err := dirInfo.getIndexer(keyIndex).BuildSecureIndexWithKeywords(strings.NewReader(""), 0, keywords)
+	if err != nil {
+		return err
+	}
+	secIndex.SizeBucket = c.sizeBuckets
+
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	summary, err := c.buildSummary(dirInfo, keyIndex, words)
+	if err != nil {
+		return err
+	}
+
+	write := pendingWrite{
+		arg:     sserver1.WriteIndexArg{TlfID: dirInfo.tlfID, SecureIndex: secIndexBytes, DocID: docID},
+		digest:  libsearch.ComputeWordSetDigest(pathnameKey, words),
+		key:     pathnameKey,
+		summary: summary,
+	}
+	if dirInfo.padding.isBatched() {
+		dirInfo.padding.addWrite(write)
+		return nil
+	}
+
+	if err := c.writeIndex(ctx, dirInfo, write); err != nil {
+		return err
+	}
+	return c.padDocumentCount(ctx, dirInfo)
+}
+
+// renameTags moves the tags of the file renamed from `relOrig` to `relCurr` in
+// the directory of `dirInfo`, along with their auxiliary index.
+func (c *Client) renameTags(ctx context.Context, dirInfo *DirectoryInfo, relOrig, relCurr string) error {
+	if _, ok := parseTagsPathname(relOrig); ok {
+		return nil
+	}
+	dirInfo.tagsLock.Lock()
+	defer dirInfo.tagsLock.Unlock()
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil || len(tags[relOrig]) == 0 {
+		return err
+	}
+	err = c.RenameFile(ctx, dirInfo.absDir, filepath.Join(dirInfo.absDir, tagsPathname(relOrig)), filepath.Join(dirInfo.absDir, tagsPathname(relCurr)))
+	if err != nil {
+		return err
+	}
+	tags[relCurr] = tags[relOrig]
+	delete(tags, relOrig)
+	return writeTags(dirInfo.absDir, tags)
+}
+
+// dropTags drops the tags of the file at `pathname` in `directory`, gone from
+// the directory, along with their auxiliary index.
+func (c *Client) dropTags(ctx context.Context, directory, pathname string) error {
+	dirInfo, err := c.getDirectoryInfo(directory)
+	if err != nil {
+		return err
+	}
+	relPath, err := relPathStrict(dirInfo.absDir, pathname)
+	if err != nil {
+		return err
+	}
+	dirInfo.tagsLock.Lock()
+	defer dirInfo.tagsLock.Unlock()
+	tags, err := readTags(dirInfo.absDir)
+	if err != nil || len(tags[relPath]) == 0 {
+		return err
+	}
+	if err := c.writeTagsIndex(ctx, dirInfo, relPath, nil); err != nil {
+		return err
+	}
+	delete(tags, relPath)
+	return writeTags(dirInfo.absDir, tags)
+}
+
+// matchesTag returns the subset of `files` of `directory` with the tag keyword
+// `keyword` as listed in the tags file of the TLF.
+func matchesTag(directory string, files []string, keyword string) ([]string, error) {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readTags(directory)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for _, filename := range files {
+		relPath, err := filepath.Rel(directory, filename)
+		if err != nil {
+			continue
+		}
+		for _, tag := range tags[relPath] {
+			if libsearch.MetadataKeyword(libsearch.MetadataTag, tag) == keyword {
+				filenames = append(filenames, filename)
+				break
+			}
+		}
+	}
+	return filenames, nil
+}
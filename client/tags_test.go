@@ -0,0 +1,74 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestTags tests the `AddTags`, `RemoveTags` and `GetTags` functions.  Checks
+// that the tag keywords match the tagged files even if the tags are not among
+// their words, alone or along with words of their content, that the tags follow
+// the renames of their file, and that the removed tags no longer match.
+func TestTags(t *testing.T) {
+	cli, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	notes := filepath.Join(dir, "notes")
+	other := filepath.Join(dir, "other")
+	for path, content := range map[string]string{notes: "the budget draft", other: "the budget review"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatalf("error when writing test file: %s", err)
+		}
+		if err := cli.AddFile(ctx, dir, path); err != nil {
+			t.Fatalf("error when adding the file: %s", err)
+		}
+	}
+
+	if err := cli.AddTags(ctx, dir, notes, []string{"Urgent", "work", "urgent"}); err != nil {
+		t.Fatalf("error when adding the tags: %s", err)
+	}
+	if tags, err := cli.GetTags(dir, notes); err != nil || !reflect.DeepEqual(tags, []string{"urgent", "work"}) {
+		t.Fatalf("incorrect tags: %v, %v", tags, err)
+	}
+	for _, query := range []string{"tag:urgent", "tag:urgent budget", "budget tag:work tag:urgent"} {
+		if results, err := cli.SearchQuery(ctx, dir, query); err != nil || !reflect.DeepEqual(results, []string{notes}) {
+			t.Fatalf("incorrect results for %q: %v, %v", query, results, err)
+		}
+		if results, err := cli.SearchQueryStrict(ctx, dir, query); err != nil || !reflect.DeepEqual(results, []string{notes}) {
+			t.Fatalf("incorrect strict results for %q: %v, %v", query, results, err)
+		}
+	}
+	if results, err := cli.SearchQuery(ctx, dir, "tag:urgent review"); err != nil || len(results) != 0 {
+		t.Fatalf("tag matching the files without the words: %v, %v", results, err)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(notes, renamed); err != nil {
+		t.Fatalf("error when renaming the file: %s", err)
+	}
+	if err := cli.RenameFile(ctx, dir, notes, renamed); err != nil {
+		t.Fatalf("error when renaming the index: %s", err)
+	}
+	if results, err := cli.SearchQuery(ctx, dir, "tag:urgent budget"); err != nil || !reflect.DeepEqual(results, []string{renamed}) {
+		t.Fatalf("tags not renamed along with the file: %v, %v", results, err)
+	}
+
+	if err := cli.RemoveTags(ctx, dir, renamed, []string{"urgent"}); err != nil {
+		t.Fatalf("error when removing the tags: %s", err)
+	}
+	if results, err := cli.SearchQuery(ctx, dir, "tag:urgent"); err != nil || len(results) != 0 {
+		t.Fatalf("removed tag still matching: %v, %v", results, err)
+	}
+	if results, err := cli.SearchQuery(ctx, dir, "tag:work"); err != nil || !reflect.DeepEqual(results, []string{renamed}) {
+		t.Fatalf("remaining tag not matching: %v, %v", results, err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// priorityMaxSize is the size in bytes up to which a recently modified
+	// file is uploaded ahead of the other files of a scan.
+	priorityMaxSize = 1 << 20
+	// priorityMaxAge is how recently a small file must have been modified, as
+	// of the start of a scan, to be uploaded ahead of the other files.
+	priorityMaxAge = 24 * time.Hour
+)
+
+// scannedFile is a file found by a scan to be added to the search server.
+type scannedFile struct {
+	relPath string       // The path of the file relative to the directory.
+	entry   indexedEntry // The size and modification time of the file.
+}
+
+// isPriority returns whether `f` is small and has been modified recently as of
+// `now`, so that its index is uploaded ahead of the bulk of the scan.
+func (f scannedFile) isPriority(now time.Time) bool {
+	return f.entry.Size <= priorityMaxSize && now.Sub(f.entry.ModTime) <= priorityMaxAge
+}
+
+// prioritizeUploads orders `files` so that the small files modified recently
+// as of `now` are added first, the most recently modified first, ahead of the
+// other files kept in their order.  This way, the files the user is working on
+// become searchable quickly even while a large directory is initially indexed.
+func prioritizeUploads(files []scannedFile, now time.Time) {
+	sort.SliceStable(files, func(i, j int) bool {
+		iPriority, jPriority := files[i].isPriority(now), files[j].isPriority(now)
+		if iPriority != jPriority {
+			return iPriority
+		}
+		return iPriority && files[i].entry.ModTime.After(files[j].entry.ModTime)
+	})
+}
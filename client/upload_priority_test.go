@@ -0,0 +1,35 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestPrioritizeUploads tests the `prioritizeUploads` function.  Checks that
+// the small files modified recently come first, the most recently modified
+// first, and that the other files keep their order.
+func TestPrioritizeUploads(t *testing.T) {
+	now := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	files := []scannedFile{
+		{"old", indexedEntry{Size: 100, ModTime: now.Add(-30 * 24 * time.Hour)}},
+		{"recent", indexedEntry{Size: 100, ModTime: now.Add(-time.Hour)}},
+		{"large", indexedEntry{Size: priorityMaxSize + 1, ModTime: now.Add(-time.Minute)}},
+		{"older", indexedEntry{Size: 100, ModTime: now.Add(-2 * priorityMaxAge)}},
+		{"newest", indexedEntry{Size: priorityMaxSize, ModTime: now.Add(-time.Minute)}},
+	}
+	prioritizeUploads(files, now)
+
+	var actual []string
+	for _, file := range files {
+		actual = append(actual, file.relPath)
+	}
+	expected := []string{"newest", "recent", "old", "large", "older"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect upload order: expected %v actual %v", expected, actual)
+	}
+}
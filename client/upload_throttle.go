@@ -0,0 +1,81 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// uploadThrottle is a token bucket limiting the rate of the bytes of the
+// indexes uploaded, so that the background indexing does not saturate the
+// uplink of the user.  The bucket holds up to one second worth of bytes, and
+// an upload larger than the bucket drives it into debt, which the next uploads
+// wait out.
+type uploadThrottle struct {
+	clock clockwork.Clock // The clock the bucket refills with.
+	rate  int64           // The number of bytes allowed per second.
+
+	lock   sync.Mutex // Serializes the throttled uploads and protects the fields below.
+	tokens float64    // The number of bytes that can be uploaded right away, negative if in debt.
+	last   time.Time  // The time the tokens were last refilled.
+}
+
+// newUploadThrottle creates an `uploadThrottle` allowing `rate` bytes per
+// second.
+func newUploadThrottle(clock clockwork.Clock, rate int64) *uploadThrottle {
+	return &uploadThrottle{
+		clock:  clock,
+		rate:   rate,
+		tokens: float64(rate),
+		last:   clock.Now(),
+	}
+}
+
+// wait takes `numBytes` tokens from the bucket, blocking until the bucket is
+// out of debt or `cancel` is closed.
+func (u *uploadThrottle) wait(numBytes int, cancel <-chan struct{}) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	now := u.clock.Now()
+	u.tokens += now.Sub(u.last).Seconds() * float64(u.rate)
+	if u.tokens > float64(u.rate) {
+		u.tokens = float64(u.rate)
+	}
+	u.last = now
+	u.tokens -= float64(numBytes)
+	if u.tokens >= 0 {
+		return
+	}
+	// The lock is held while waiting, so that the next uploads wait
+	// behind this one.
+	select {
+	case <-u.clock.After(time.Duration(-u.tokens / float64(u.rate) * float64(time.Second))):
+	case <-cancel:
+	}
+}
+
+// SetMaxUploadRate limits the uploads of indexes to `bytesPerSec` bytes per
+// second on average, so that indexing a large directory stays unobtrusive.  A
+// non-positive `bytesPerSec` removes the limit.  Should be called before any
+// file is added.
+func (c *Client) SetMaxUploadRate(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		c.uploadThrottle = nil
+		return
+	}
+	c.uploadThrottle = newUploadThrottle(c.clock, bytesPerSec)
+}
+
+// waitUpload waits for the upload of an index of `numBytes` bytes to fit in the
+// rate set by `SetMaxUploadRate`, unless the client is shut down meanwhile.
+func (c *Client) waitUpload(numBytes int) {
+	if c.uploadThrottle != nil {
+		c.uploadThrottle.wait(numBytes, c.shutdownCh)
+	}
+}
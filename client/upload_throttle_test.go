@@ -0,0 +1,52 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// TestUploadThrottle tests the `uploadThrottle` type.  Checks that the uploads
+// within one second worth of bytes go through, that an upload beyond it waits
+// for the bucket to refill, and that the wait stops once canceled.
+func TestUploadThrottle(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	throttle := newUploadThrottle(clock, 1000)
+
+	throttle.wait(600, nil)
+	throttle.wait(400, nil)
+
+	// The bucket is empty, so that 500 bytes take half a second.
+	done := make(chan struct{})
+	go func() {
+		throttle.wait(500, nil)
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(400 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("upload not throttled")
+	default:
+	}
+	clock.Advance(100 * time.Millisecond)
+	<-done
+
+	// The bucket never holds more than one second worth of bytes.
+	clock.Advance(time.Hour)
+	throttle.wait(1000, nil)
+	cancel := make(chan struct{})
+	done = make(chan struct{})
+	go func() {
+		throttle.wait(2000, cancel)
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	close(cancel)
+	<-done
+}
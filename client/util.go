@@ -5,9 +5,13 @@
 package client
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -15,6 +19,7 @@ import (
 	"strings"
 
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
 	sserver1 "github.com/keybase/search/protocol/sserver"
 )
 
@@ -59,6 +64,79 @@ func getTlfIDAndKeyGen(directory string) (sserver1.FolderID, libkbfs.KeyGen, err
 	return sserver1.FolderID(folderStatus.FolderID), folderStatus.LatestKeyGeneration, nil
 }
 
+// minLenMSPolicyFile is the name of the optional file in a TLF that sets the
+// minimum length of the master secrets for that TLF.  As the file is synced
+// through KBFS, the policy applies to all the devices of all the members.
+const minLenMSPolicyFile = ".search_kbfs_min_len_ms"
+
+// getMinLenMS returns the minimum length of the master secrets required by the
+// policy of the TLF at `directory`.  Defaults to `libsearch.MinLenMS` if the
+// TLF does not have a policy, and never returns anything lower than that.
+func getMinLenMS(directory string) (int, error) {
+	policy, err := ioutil.ReadFile(filepath.Join(directory, minLenMSPolicyFile))
+	if os.IsNotExist(err) {
+		return libsearch.MinLenMS, nil
+	} else if err != nil {
+		return 0, err
+	}
+	minLenMS, err := strconv.Atoi(strings.TrimSpace(string(policy)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid master secret length policy in %s: %s", minLenMSPolicyFile, err)
+	}
+	if minLenMS < libsearch.MinLenMS {
+		minLenMS = libsearch.MinLenMS
+	}
+	return minLenMS, nil
+}
+
+// checkMasterSecretPolicy returns an error if master secrets of `lenMS` bytes
+// do not satisfy the policy of the TLF at `directory`.
+func checkMasterSecretPolicy(directory string, lenMS int) error {
+	minLenMS, err := getMinLenMS(directory)
+	if err != nil {
+		return err
+	}
+	if lenMS < minLenMS {
+		return fmt.Errorf("master secret length of %d bytes is shorter than the minimum of %d bytes required for %s; restart with a longer master secret length and remove the existing .search_kbfs_secret_* files to regenerate them", lenMS, minLenMS, directory)
+	}
+	return nil
+}
+
+// getSaltsKeyGen returns the key generation of the master secret the salts of
+// a TLF are encrypted under.  The first key generation is used for private
+// TLFs, as the salts must stay the same across rekeys.
+func getSaltsKeyGen(keyGen libkbfs.KeyGen) libkbfs.KeyGen {
+	if keyGen == libkbfs.PublicKeyGen {
+		return libkbfs.PublicKeyGen
+	}
+	return libkbfs.FirstValidKeyGen
+}
+
+// generateEncryptedSalts generates the salts for the TLF at `directory` with
+// the latest key generation `keyGen`, and encrypts them under the master
+// secret of the key generation given by `getSaltsKeyGen`.
+func generateEncryptedSalts(directory string, keyGen libkbfs.KeyGen, lenMS, lenSalt int, fpRate float64) ([]byte, error) {
+	masterSecret, err := fetchMasterSecret(directory, getSaltsKeyGen(keyGen), lenMS)
+	if err != nil {
+		return nil, err
+	}
+	salts, err := libsearch.GenerateSalts(libsearch.ComputeNumKeys(fpRate), lenSalt)
+	if err != nil {
+		return nil, err
+	}
+	return libsearch.SealSalts(salts, masterSecret)
+}
+
+// openEncryptedSalts decrypts the `encryptedSalts` of the TLF at `directory`
+// with the latest key generation `keyGen`.
+func openEncryptedSalts(directory string, keyGen libkbfs.KeyGen, lenMS int, encryptedSalts []byte) ([][]byte, error) {
+	masterSecret, err := fetchMasterSecret(directory, getSaltsKeyGen(keyGen), lenMS)
+	if err != nil {
+		return nil, err
+	}
+	return libsearch.OpenSalts(encryptedSalts, masterSecret)
+}
+
 // fetchMasterSecret returns the master secret of the specific `keyGen` under
 // `directory`.
 func fetchMasterSecret(directory string, keyGen libkbfs.KeyGen, lenMS int) ([]byte, error) {
@@ -91,3 +169,154 @@ func fetchMasterSecret(directory string, keyGen libkbfs.KeyGen, lenMS int) ([]by
 	}
 	return masterSecret, nil
 }
+
+// wordSetDigestExt is the extension of the directory within the state
+// directory holding the sealed word set digests of the indexed files of a
+// client directory.
+const wordSetDigestExt = ".digests"
+
+// legacyWordSetDigestDir is the name of the hidden directory under a client
+// directory where the word set digests were stored, shared with the other
+// devices, before they were kept with the local state.
+const legacyWordSetDigestDir = ".search_kbfs_digests"
+
+// getWordSetDigestDir returns the directory holding the word set digests of
+// `directory` within `stateDir`.
+func getWordSetDigestDir(stateDir, directory string) string {
+	return getStatePath(stateDir, directory, wordSetDigestExt)
+}
+
+// getWordSetDigestPath returns the path of the file storing the word set digest
+// for `docID` of `directory` within `stateDir`.  The document ID is hashed to
+// keep the filename short regardless of the length of the pathname.
+func getWordSetDigestPath(stateDir, directory string, docID sserver1.DocumentID) string {
+	cksum := sha256.Sum256([]byte(docID))
+	return filepath.Join(getWordSetDigestDir(stateDir, directory), hex.EncodeToString(cksum[:]))
+}
+
+// getLocalStateDir returns the directory holding the local state of the
+// client, or an empty string if its directories are not locked.
+func (c *Client) getLocalStateDir() string {
+	c.issuesLock.Lock()
+	defer c.issuesLock.Unlock()
+	return c.stateDir
+}
+
+// writeWordSetDigest seals `digest` with `key` and writes it for `docID` of
+// `directory` in the state directory of the client, if its directories are
+// locked.
+func (c *Client) writeWordSetDigest(directory string, docID sserver1.DocumentID, digest libsearch.WordSetDigest, key libsearch.PathnameKeyType) error {
+	stateDir := c.getLocalStateDir()
+	if stateDir == "" {
+		return nil
+	}
+	sealed, err := digest.Seal(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(getWordSetDigestDir(stateDir, directory), 0700); err != nil {
+		return err
+	}
+	return libsearch.WriteFileAtomic(getWordSetDigestPath(stateDir, directory, docID), sealed)
+}
+
+// readWordSetDigest reads the word set digest for `docID` of `directory` and
+// opens it with `key`.  Returns an error satisfying `os.IsNotExist` if the
+// digest has not been stored, e.g. as the directories are not locked.
+func (c *Client) readWordSetDigest(directory string, docID sserver1.DocumentID, key libsearch.PathnameKeyType) (libsearch.WordSetDigest, error) {
+	stateDir := c.getLocalStateDir()
+	if stateDir == "" {
+		return nil, os.ErrNotExist
+	}
+	sealed, err := ioutil.ReadFile(getWordSetDigestPath(stateDir, directory, docID))
+	if err != nil {
+		return nil, err
+	}
+	return libsearch.OpenWordSetDigest(sealed, key)
+}
+
+// renameWordSetDigest moves the word set digest of `directory` stored for
+// `origDocID` to `currDocID`, if any.
+func (c *Client) renameWordSetDigest(directory string, origDocID, currDocID sserver1.DocumentID) error {
+	stateDir := c.getLocalStateDir()
+	if stateDir == "" {
+		return nil
+	}
+	err := os.Rename(getWordSetDigestPath(stateDir, directory, origDocID), getWordSetDigestPath(stateDir, directory, currDocID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeWordSetDigest removes the word set digest of `directory` stored for
+// `docID`, if any.
+func (c *Client) removeWordSetDigest(directory string, docID sserver1.DocumentID) error {
+	stateDir := c.getLocalStateDir()
+	if stateDir == "" {
+		return nil
+	}
+	err := os.Remove(getWordSetDigestPath(stateDir, directory, docID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// migrateWordSetDigests copies the word set digests of `directory` from the
+// legacy directory shared with the other devices into `stateDir`, unless the
+// directory already has digests there.  The shared digests are left for the
+// other devices to copy.
+func migrateWordSetDigests(stateDir, directory string) error {
+	digestDir := getWordSetDigestDir(stateDir, directory)
+	if _, err := os.Stat(digestDir); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(directory, legacyWordSetDigestDir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// The digests are copied to a temporary directory first, so that an
+	// interrupted copy is started over.
+	tmpDir := digestDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		sealed, err := ioutil.ReadFile(filepath.Join(directory, legacyWordSetDigestDir, info.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, info.Name()), sealed, 0600); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpDir, digestDir)
+}
+
+// verifyTlfFingerprint checks that the fingerprint stored by the server in
+// `tlfInfo` matches the one computed locally from the TLF parameters, over the
+// sealed salts if the salts are encrypted.  The TLFs registered before the
+// fingerprints were stored have an empty one, which is accepted.  These record
+// no codeword mapping either, so a TLF recording one must have a fingerprint.
+func verifyTlfFingerprint(tlfInfo sserver1.TlfInfo) error {
+	if len(tlfInfo.Fingerprint) == 0 {
+		if tlfInfo.CodewordMapping != int(libsearch.DefaultCodewordMapping) {
+			return errors.New("missing TLF fingerprint: the parameters of this TLF cannot be checked")
+		}
+		return nil
+	}
+	expected := libsearch.ComputeTlfFingerprint(tlfInfo.Salts, uint64(tlfInfo.Size))
+	if len(tlfInfo.EncryptedSalts) > 0 {
+		expected = libsearch.ComputeSealedTlfFingerprint(tlfInfo.EncryptedSalts, uint64(tlfInfo.Size))
+	}
+	if !bytes.Equal(tlfInfo.Fingerprint, expected) {
+		return errors.New("mismatched TLF parameters: the indexes of this TLF are incompatible with the ones built by this client")
+	}
+	return nil
+}
@@ -13,6 +13,8 @@ import (
 	"testing"
 
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/search/libsearch"
+	sserver1 "github.com/keybase/search/protocol/sserver"
 )
 
 // testRelPathStrictHelper checks that the call to `relPathStrict` with
@@ -124,3 +126,87 @@ func TestFetchMasterSecret(t *testing.T) {
 		t.Fatalf("error not reported when master secret has unmatching length")
 	}
 }
+
+// TestVerifyTlfFingerprint tests the `verifyTlfFingerprint` function.  Checks
+// that matching fingerprints are accepted, over the sealed salts if encrypted,
+// that mismatching ones are rejected, and that missing ones are only accepted
+// for the TLFs recording no codeword mapping.
+func TestVerifyTlfFingerprint(t *testing.T) {
+	salts := [][]byte{[]byte("saltsaltsaltsalt"), []byte("pepperpepperpepp")}
+	tlfInfo := sserver1.TlfInfo{Salts: salts, Size: 10000}
+
+	if err := verifyTlfFingerprint(tlfInfo); err != nil {
+		t.Fatalf("missing fingerprint of a legacy TLF rejected: %s", err)
+	}
+	tlfInfo.CodewordMapping = int(libsearch.RegisteredCodewordMapping)
+	if err := verifyTlfFingerprint(tlfInfo); err == nil {
+		t.Fatalf("missing fingerprint of a new TLF accepted")
+	}
+
+	sealed, err := libsearch.SealSalts(salts, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("error when sealing the salts: %s", err)
+	}
+	encrypted := tlfInfo
+	encrypted.EncryptedSalts = sealed
+	encrypted.Fingerprint = libsearch.ComputeSealedTlfFingerprint(sealed, 10000)
+	if err := verifyTlfFingerprint(encrypted); err != nil {
+		t.Fatalf("matching fingerprint of the sealed salts rejected: %s", err)
+	}
+	encrypted.Fingerprint = libsearch.ComputeTlfFingerprint(salts, 10001)
+	if err := verifyTlfFingerprint(encrypted); err == nil {
+		t.Fatalf("mismatching fingerprint of the sealed salts accepted")
+	}
+
+	tlfInfo.Fingerprint = libsearch.ComputeTlfFingerprint(salts, 10000)
+	if err := verifyTlfFingerprint(tlfInfo); err != nil {
+		t.Fatalf("matching fingerprint rejected: %s", err)
+	}
+
+	tlfInfo.Size = 10001
+	if err := verifyTlfFingerprint(tlfInfo); err == nil {
+		t.Fatalf("mismatching fingerprint accepted")
+	}
+}
+
+// TestCheckMasterSecretPolicy tests the `checkMasterSecretPolicy` function.
+// Checks that the default and the per-TLF policies are enforced, and that
+// policies weaker than the default are ignored.
+func TestCheckMasterSecretPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msPolicy")
+	if err != nil {
+		t.Fatalf("error when creating test directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkMasterSecretPolicy(dir, libsearch.MinLenMS); err != nil {
+		t.Fatalf("master secret length rejected without a policy: %s", err)
+	}
+	if err := checkMasterSecretPolicy(dir, 8); err == nil {
+		t.Fatalf("master secret shorter than the default minimum accepted")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, minLenMSPolicyFile), []byte("128\n"), 0666); err != nil {
+		t.Fatalf("error when writing the policy: %s", err)
+	}
+	if err := checkMasterSecretPolicy(dir, 64); err == nil {
+		t.Fatalf("master secret shorter than the TLF policy accepted")
+	}
+	if err := checkMasterSecretPolicy(dir, 128); err != nil {
+		t.Fatalf("master secret satisfying the TLF policy rejected: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, minLenMSPolicyFile), []byte("8"), 0666); err != nil {
+		t.Fatalf("error when writing the policy: %s", err)
+	}
+	if err := checkMasterSecretPolicy(dir, 16); err == nil {
+		t.Fatalf("weak TLF policy not overridden by the default minimum")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, minLenMSPolicyFile), []byte("invalid"), 0666); err != nil {
+		t.Fatalf("error when writing the policy: %s", err)
+	}
+	if err := checkMasterSecretPolicy(dir, 64); err == nil {
+		t.Fatalf("no error returned for an invalid policy")
+	}
+}
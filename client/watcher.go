@@ -0,0 +1,259 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/context"
+)
+
+// watchDelay is the time the file events are collected for before the
+// affected files are indexed, so that a burst of writes to the same file only
+// triggers a single upload.
+const watchDelay = time.Second
+
+// findDirectory returns the directory among `directories` containing `path`,
+// and whether `path` is hidden within it, i.e. any of its components relative
+// to the directory starts with a dot.  Returns an empty directory if `path` is
+// not within any of the `directories`.
+func findDirectory(directories []string, path string) (string, bool) {
+	for _, directory := range directories {
+		relPath, err := relPathStrict(directory, path)
+		if err != nil {
+			continue
+		}
+		return directory, isHiddenPath(relPath)
+	}
+	return "", false
+}
+
+// watchTree adds `root` and all its non-hidden subdirectories to `watcher`.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && info.Name()[0] == '.' {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// indexPending indexes the `pending` paths of `directory` gathered from the
+// file events: the existing files are added, and the indexed files at or under
+// the paths that have been removed or renamed away are deleted.  Newly created
+// subdirectories are watched and their files added.  Records the time of the
+// batch as the last scan of the directory, so that the scan at the next
+// startup only picks up the changes made while the client was not running.
+// The batch is started and ended as a scan, as with `IndexUpdatedFiles`.
+func (c *Client) indexPending(ctx context.Context, watcher *fsnotify.Watcher, directory string, pending map[string]fsnotify.Op) IndexReport {
+	report := c.startScan(ctx, directory)
+	defer c.endScan(&report)
+	if report.Err != nil {
+		return report
+	}
+
+	state, err := loadIndexState(directory)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	defer state.close()
+	indexed := state.Files
+	if indexed == nil {
+		indexed = make(map[string]indexedEntry)
+	}
+	addFile := func(path string, info os.FileInfo) {
+		relPath, err := filepath.Rel(directory, path)
+		if err == nil && c.addScannedFile(ctx, &report, directory, path) {
+			// The revision is unknown, but the prior versions already
+			// indexed stay in the history.
+			entry := newIndexedEntry(info)
+			entry.History = indexed[relPath].History
+			indexed[relPath] = entry
+			state.record(relPath, indexed[relPath])
+		}
+	}
+
+	for path, op := range pending {
+		info, err := os.Stat(path)
+		if err == nil {
+			// The symlinks skipped are left alone, and the ones followed
+			// are indexed under the real paths of their targets.
+			if path, err = c.realPath(directory, path); err != nil {
+				continue
+			}
+		}
+		switch {
+		case err == nil && info.IsDir():
+			if op&fsnotify.Create == 0 {
+				continue
+			}
+			if report.Err = watchTree(watcher, path); report.Err != nil {
+				return report
+			}
+			report.Err = walkFiles(directory, path, c.symlinks, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() && info.Name()[0] == '.' {
+					return filepath.SkipDir
+				} else if !info.IsDir() && info.Name()[0] != '.' {
+					addFile(path, info)
+				}
+				return nil
+			})
+			if report.Err != nil {
+				return report
+			}
+		case err == nil:
+			addFile(path, info)
+		case os.IsNotExist(err) && op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			gone, err := filepath.Rel(directory, path)
+			if err != nil {
+				continue
+			}
+			matched := false
+			for relPath := range indexed {
+				if relPath != gone && !strings.HasPrefix(relPath, gone+string(filepath.Separator)) {
+					continue
+				}
+				matched = true
+				deleted := filepath.Join(directory, relPath)
+				if c.deleteScannedFile(ctx, directory, deleted) {
+					delete(indexed, relPath)
+					state.forget(relPath)
+					report.Deleted = append(report.Deleted, deleted)
+				}
+			}
+			// The files indexed before the set was recorded are deleted
+			// on their own.
+			if !matched && c.deleteScannedFile(ctx, directory, path) {
+				report.Deleted = append(report.Deleted, path)
+			}
+		}
+	}
+
+	if report.Err = c.saveFileIssues(directory); report.Err != nil {
+		return report
+	}
+	if report.Err = c.saveContentHashes(directory); report.Err != nil {
+		return report
+	}
+	report.Err = state.commit(indexed, report.Start)
+	return report
+}
+
+// WatchFiles indexes the files under `directories` as soon as they are
+// created, modified, renamed or deleted, until the client is shut down.  The
+// directories are only scanned once at startup for the files updated since
+// their last scan, and again if the kernel drops events.  As the events do
+// not pair the two sides of a rename, a renamed file is deleted under its
+// original name and added under the new one.  `onIndex` is called with the
+// report of each scan and each batch of events.  The directories removed with
+// `RemoveDirectory` are no longer indexed, and `WatchFiles` returns once all
+// of them are removed, or once `ctx` is done.  Returns an error if the
+// directories cannot be watched, in which case the caller should fall back to
+// `PeriodicAdd`.
+func (c *Client) WatchFiles(ctx context.Context, directories []string, onIndex func(IndexReport)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, directory := range directories {
+		if err := watchTree(watcher, directory); err != nil {
+			return err
+		}
+	}
+	dirInfos := c.trackDirectories(directories)
+	tracked := make(map[string]*DirectoryInfo)
+	for i, directory := range directories {
+		tracked[directory] = dirInfos[i]
+	}
+	// scan scans the directories not removed, e.g. when some events have
+	// been lost.
+	scan := func() {
+		for i, directory := range directories {
+			if dirInfos[i].isRemoved() {
+				continue
+			}
+			if report := c.IndexUpdatedFiles(ctx, directory); !dirInfos[i].isRemoved() {
+				onIndex(report)
+			}
+		}
+	}
+	// The scan happens after the watches are set up, so that no update
+	// falls in between.
+	scan()
+
+	pending := make(map[string]map[string]fsnotify.Op)
+	var flush <-chan time.Time
+	for {
+		c.dirLock.RLock()
+		removals := c.removals
+		c.dirLock.RUnlock()
+		if allRemoved(dirInfos) {
+			return nil
+		}
+		select {
+		case <-removals:
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			directory, hidden := findDirectory(directories, event.Name)
+			if directory == "" || hidden || event.Op == fsnotify.Chmod || tracked[directory].isRemoved() {
+				continue
+			}
+			if pending[directory] == nil {
+				pending[directory] = make(map[string]fsnotify.Op)
+			}
+			pending[directory][event.Name] |= event.Op
+			if flush == nil {
+				flush = c.clock.After(watchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if err != fsnotify.ErrEventOverflow {
+				onIndex(IndexReport{Start: c.clock.Now(), Err: err})
+				continue
+			}
+			// Some events have been lost, so falls back to a scan.
+			scan()
+		case <-flush:
+			flush = nil
+			for directory, paths := range pending {
+				if !tracked[directory].isRemoved() {
+					onIndex(c.indexPending(ctx, watcher, directory, paths))
+				}
+			}
+			pending = make(map[string]map[string]fsnotify.Op)
+		case <-c.shutdownCh:
+			// Indexes the events received so far, as the deletions
+			// would not be picked up by the scan at the next startup.
+			for directory, paths := range pending {
+				if !tracked[directory].isRemoved() {
+					onIndex(c.indexPending(ctx, watcher, directory, paths))
+				}
+			}
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/context"
+)
+
+// TestWatchFiles tests the `WatchFiles` function.  Checks that the files
+// present at startup are scanned, and that the files created, modified,
+// renamed and deleted afterwards, including in new subdirectories, are
+// reflected in the search results without another scan.
+func TestWatchFiles(t *testing.T) {
+	client, dir := startTestClientWithServer(t, "", newMemoryServerClient())
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("apple"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+
+	reports := make(chan IndexReport, 100)
+	done := make(chan error)
+	go func() {
+		done <- client.WatchFiles(context.Background(), []string{dir}, func(report IndexReport) {
+			reports <- report
+		})
+	}()
+	if report := <-reports; report.Err != nil || !reflect.DeepEqual([]string{existing}, report.Added) {
+		t.Fatalf("incorrect startup scan: %+v", report)
+	}
+
+	// waitForResult polls the search results of `word` until they match
+	// `expected`.
+	waitForResult := func(word string, expected []string) {
+		var actual []string
+		for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+			var err error
+			if actual, err = client.SearchWordStrict(context.Background(), dir, word); err != nil {
+				t.Fatalf("error when searching word: %s", err)
+			}
+			if len(actual) == len(expected) && (len(expected) == 0 || reflect.DeepEqual(expected, actual)) {
+				return
+			}
+		}
+		t.Fatalf("incorrect search result for %q: expected %s actual %s", word, expected, actual)
+	}
+
+	subDir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subDir, 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	created := filepath.Join(subDir, "created")
+	if err := ioutil.WriteFile(created, []byte("banana"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForResult("banana", []string{created})
+
+	if err := ioutil.WriteFile(existing, []byte("cherry"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForResult("cherry", []string{existing})
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(created, renamed); err != nil {
+		t.Fatalf("error when renaming test file: %s", err)
+	}
+	waitForResult("banana", []string{renamed})
+
+	if err := os.Remove(existing); err != nil {
+		t.Fatalf("error when removing test file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("cherry"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForResult("cherry", nil)
+
+	// Moves a directory out of the watched tree, which only triggers an
+	// event for the directory itself.
+	movedDir := filepath.Join(dir, "moved")
+	if err := os.Mkdir(movedDir, 0777); err != nil {
+		t.Fatalf("error when creating a test subdirectory: %s", err)
+	}
+	moved := filepath.Join(movedDir, "moved")
+	if err := ioutil.WriteFile(moved, []byte("durian"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	waitForResult("durian", []string{moved})
+	outside, err := ioutil.TempDir("", "TestWatchFilesOutside")
+	if err != nil {
+		t.Fatalf("error when creating the test directory: %s", err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.Rename(movedDir, filepath.Join(outside, "moved")); err != nil {
+		t.Fatalf("error when moving test subdirectory: %s", err)
+	}
+	waitForResult("durian", nil)
+
+	client.Shutdown()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("error when watching the files: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WatchFiles still running after shutdown")
+	}
+}
+
+// TestIndexPendingScan tests the `indexPending` function.  Checks that a batch
+// of events replays the updates queued while the server was unreachable ahead
+// of its own, and is recorded as a scan of the directory.
+func TestIndexPendingScan(t *testing.T) {
+	server := &offlineServerClient{memoryServerClient: newMemoryServerClient()}
+	client, dir := startTestClientWithServer(t, "", server)
+	defer os.RemoveAll(dir)
+	lockTestClient(t, client, dir)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("error when creating the watcher: %s", err)
+	}
+	defer watcher.Close()
+
+	queued := filepath.Join(dir, "queued")
+	if err := ioutil.WriteFile(queued, []byte("written offline"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	server.offline = true
+	if err := client.AddFile(context.Background(), dir, queued); err != nil {
+		t.Fatalf("upload not queued: %s", err)
+	}
+	server.offline = false
+
+	created := filepath.Join(dir, "created")
+	if err := ioutil.WriteFile(created, []byte("written online"), 0666); err != nil {
+		t.Fatalf("error when writing test file: %s", err)
+	}
+	report := client.indexPending(context.Background(), watcher, dir, map[string]fsnotify.Op{created: fsnotify.Create})
+	if report.Err != nil || !reflect.DeepEqual([]string{created}, report.Added) {
+		t.Fatalf("incorrect report: %+v", report)
+	}
+	if length, err := client.GetOfflineQueueLength(dir); err != nil || length != 0 {
+		t.Fatalf("queue not replayed: %d, %v", length, err)
+	}
+	if stats, err := client.GetDirectoryStats(dir); err != nil || stats.LastScanDuration == 0 {
+		t.Fatalf("batch not recorded as a scan: %+v, %v", stats, err)
+	}
+	if actual, err := client.SearchWordStrict(context.Background(), dir, "written"); err != nil || !reflect.DeepEqual([]string{created, queued}, actual) {
+		t.Fatalf("incorrect search result: %s, %v", actual, err)
+	}
+}
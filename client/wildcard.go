@@ -0,0 +1,84 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// TlfSearchResult holds the results of searching a word in a single TLF.
+type TlfSearchResult struct {
+	Directory string   // The absolute path of the TLF directory searched.
+	Filenames []string // The filenames in the directory possibly containing the word.
+}
+
+// Directories returns the sorted list of absolute paths of the directories
+// registered on this client.
+func (c *Client) Directories() []string {
+	c.dirLock.RLock()
+	defer c.dirLock.RUnlock()
+	directories := make([]string, 0, len(c.directoryInfos))
+	for absDir := range c.directoryInfos {
+		directories = append(directories, absDir)
+	}
+	sort.Strings(directories)
+	return directories
+}
+
+// SearchWordsAllTlfs fans out the search for `words` to all the TLFs
+// registered on all the `clients`, which may talk to different search
+// servers.  The per-folder results are merged for each word and labeled with
+// the directory they come from, sorted by the directory.  Uses
+// `SearchWordsStrict` if `strict` is set.  Returns the first error
+// encountered, if any.
+func SearchWordsAllTlfs(ctx context.Context, clients []*Client, words []string, strict bool) (map[string][]TlfSearchResult, error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	results := make(map[string][]TlfSearchResult, len(words))
+
+	for _, cli := range clients {
+		for _, directory := range cli.Directories() {
+			wg.Add(1)
+			go func(cli *Client, directory string) {
+				defer wg.Done()
+				search := cli.SearchWords
+				if strict {
+					search = cli.SearchWordsStrict
+				}
+				filenamesMap, err := search(ctx, directory, words)
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					return
+				}
+				for word, filenames := range filenamesMap {
+					if len(filenames) == 0 {
+						continue
+					}
+					results[word] = append(results[word], TlfSearchResult{Directory: directory, Filenames: filenames})
+				}
+			}(cli, directory)
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	for _, tlfResults := range results {
+		sort.Slice(tlfResults, func(i, j int) bool {
+			return tlfResults[i].Directory < tlfResults[j].Directory
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestSearchWordsAllTlfs tests the `SearchWordsAllTlfs` function.  Checks that
+// the search is fanned out to the directories of all the clients and that the
+// results are labeled by the directory they come from.
+func TestSearchWordsAllTlfs(t *testing.T) {
+	client1, dir1 := startTestClient(t, "")
+	defer os.RemoveAll(dir1)
+	client2, dir2 := startTestClient(t, "")
+	defer os.RemoveAll(dir2)
+
+	if !reflect.DeepEqual(client1.Directories(), []string{dir1}) {
+		t.Fatalf("incorrect directories: expected %s actual %s", []string{dir1}, client1.Directories())
+	}
+
+	filenames := make(map[string]string)
+	for _, dir := range []string{dir1, dir2} {
+		for i, content := range []string{"first file", "second file", "third file", "fourth file"} {
+			filename := filepath.Join(dir, "testWildcardFile"+strconv.Itoa(i))
+			if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+				t.Fatalf("error when writing test file: %s", err)
+			}
+			filenames[dir+content] = filename
+		}
+	}
+	for _, cli := range []*Client{client1, client2} {
+		dir := cli.Directories()[0]
+		for _, content := range []string{"first file", "second file", "third file", "fourth file"} {
+			if err := cli.AddFile(context.Background(), dir, filenames[dir+content]); err != nil {
+				t.Fatalf("error when adding the file: %s", err)
+			}
+		}
+	}
+
+	results, err := SearchWordsAllTlfs(context.Background(), []*Client{client1, client2}, []string{"second"}, false)
+	if err != nil {
+		t.Fatalf("error when searching all the TLFs: %s", err)
+	}
+	if len(results["second"]) != 2 {
+		t.Fatalf("incorrect number of TLFs in the results: expected 2 actual %d", len(results["second"]))
+	}
+	for _, result := range results["second"] {
+		expected := []string{filenames[result.Directory+"second file"], filenames[result.Directory+"fourth file"]}
+		if !reflect.DeepEqual(expected, result.Filenames) {
+			t.Fatalf("incorrect search result for %s: expected \"%s\" actual \"%s\"", result.Directory, expected, result.Filenames)
+		}
+	}
+	if results["second"][0].Directory > results["second"][1].Directory {
+		t.Fatalf("results not sorted by directory")
+	}
+}
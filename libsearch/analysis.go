@@ -0,0 +1,38 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"unicode"
+)
+
+// analysisProfile describes how the documents and the queries are turned into
+// words: split on white space, then lowercased with only the letters and the
+// digits kept by `NormalizeKeyword`, plus the metadata keywords of
+// `ComputeMetadataKeywords`.  It must change along with any of them, as the
+// clients of a TLF analyzing the words differently silently miss the words of
+// each other's indexes.
+const analysisProfile = "words:space;normalize:lower,letter,digit;metadata:size,year,ext"
+
+// ComputeAnalysisFingerprint computes a fingerprint of the way this client
+// analyzes the words, including the version of the Unicode tables the
+// normalization relies on.  It is stored with a TLF by the client registering
+// it, so that the clients analyzing the words differently can be detected.
+func ComputeAnalysisFingerprint() []byte {
+	h := sha256.New()
+	h.Write([]byte("kbfs_search_analysis_fingerprint"))
+	h.Write([]byte(analysisProfile))
+	h.Write([]byte(unicode.Version))
+	return h.Sum(nil)
+}
+
+// AnalysisMismatch returns whether `fingerprint`, as stored with a TLF,
+// differs from the one of this client.  TLFs registered before the
+// fingerprints were stored have an empty one, which matches any client.
+func AnalysisMismatch(fingerprint []byte) bool {
+	return len(fingerprint) > 0 && !bytes.Equal(fingerprint, ComputeAnalysisFingerprint())
+}
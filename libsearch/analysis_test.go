@@ -0,0 +1,22 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import "testing"
+
+// TestAnalysisMismatch tests the `AnalysisMismatch` function.  Checks that
+// only a fingerprint other than the one of this client is a mismatch, and that
+// an empty one is accepted.
+func TestAnalysisMismatch(t *testing.T) {
+	fingerprint := ComputeAnalysisFingerprint()
+	if len(fingerprint) == 0 || AnalysisMismatch(fingerprint) || AnalysisMismatch(nil) {
+		t.Fatalf("mismatch detected for a compatible fingerprint")
+	}
+	other := append([]byte{}, fingerprint...)
+	other[0] ^= 1
+	if !AnalysisMismatch(other) {
+		t.Fatalf("no mismatch detected for another fingerprint")
+	}
+}
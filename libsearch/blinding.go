@@ -0,0 +1,105 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"fmt"
+	"math"
+)
+
+// BlindingPolicyVersion identifies the policy an index has been blinded with.
+// It is recorded in each index, so that the indexes blinded under different
+// policies can be told apart when auditing what they leak.
+type BlindingPolicyVersion byte
+
+const (
+	// BlindingPolicyLength blinds an index up to one entry per byte of the
+	// document, so that the number of entries only depends on its length.
+	// Kept for the indexes built before the policies were versioned.
+	BlindingPolicyLength BlindingPolicyVersion = 0
+	// BlindingPolicySizeBucket blinds an index up to a fixed number of
+	// entries for all the documents within a size bucket, so that the
+	// number of entries does not reveal the exact length either.
+	BlindingPolicySizeBucket BlindingPolicyVersion = 1
+	// BlindingPolicyPercentage adds a number of random entries proportional
+	// to the length of the document on top of its words, for smaller indexes
+	// that reveal the number of unique words up to the noise.
+	BlindingPolicyPercentage BlindingPolicyVersion = 2
+)
+
+// validate returns an error if `v` is not a known policy version.
+func (v BlindingPolicyVersion) validate() error {
+	switch v {
+	case BlindingPolicyLength, BlindingPolicySizeBucket, BlindingPolicyPercentage:
+		return nil
+	default:
+		return fmt.Errorf("unknown blinding policy %d", v)
+	}
+}
+
+// BlindingPolicy decides how many random entries blind the index of a
+// document, hiding the number of unique words in it.
+type BlindingPolicy interface {
+	// Version returns the version recorded in the indexes blinded with the
+	// policy.
+	Version() BlindingPolicyVersion
+	// NumEntries returns the number of random entries to add to the index of
+	// a document with an *encrypted* length of `fileLen` and `numWords`
+	// unique words.  The bloom filters set one random bit per entry and PRF
+	// key.  A non-positive number means no blinding.
+	NumEntries(fileLen int64, numWords int) int64
+}
+
+// LengthBlinding is the `BlindingPolicyLength` policy, the default one.
+type LengthBlinding struct{}
+
+// Version implements the BlindingPolicy interface.
+func (LengthBlinding) Version() BlindingPolicyVersion {
+	return BlindingPolicyLength
+}
+
+// NumEntries implements the BlindingPolicy interface.
+func (LengthBlinding) NumEntries(fileLen int64, numWords int) int64 {
+	return fileLen - int64(numWords)
+}
+
+// MinSizeBucket is the upper bound of the smallest size bucket of
+// `SizeBucketBlinding`.
+const MinSizeBucket = 64
+
+// SizeBucketBlinding is the `BlindingPolicySizeBucket` policy.  The size
+// buckets are bounded by the powers of two, so that the indexes are at most
+// twice as large as with `LengthBlinding`.
+type SizeBucketBlinding struct{}
+
+// Version implements the BlindingPolicy interface.
+func (SizeBucketBlinding) Version() BlindingPolicyVersion {
+	return BlindingPolicySizeBucket
+}
+
+// NumEntries implements the BlindingPolicy interface.
+func (SizeBucketBlinding) NumEntries(fileLen int64, numWords int) int64 {
+	bound := int64(MinSizeBucket)
+	for bound < fileLen {
+		bound *= 2
+	}
+	return bound - int64(numWords)
+}
+
+// PercentageBlinding is the `BlindingPolicyPercentage` policy, adding
+// `Percent` random entries per hundred bytes of the document.
+type PercentageBlinding struct {
+	Percent float64 // The number of random entries per hundred bytes of the document.
+}
+
+// Version implements the BlindingPolicy interface.
+func (PercentageBlinding) Version() BlindingPolicyVersion {
+	return BlindingPolicyPercentage
+}
+
+// NumEntries implements the BlindingPolicy interface.
+func (p PercentageBlinding) NumEntries(fileLen int64, numWords int) int64 {
+	return int64(math.Ceil(float64(fileLen) * p.Percent / 100))
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// TestBlindingPolicies tests the `NumEntries` function of the blinding
+// policies.
+func TestBlindingPolicies(t *testing.T) {
+	tests := []struct {
+		policy   BlindingPolicy
+		fileLen  int64
+		numWords int
+		expected int64
+	}{
+		{LengthBlinding{}, 1000, 100, 900},
+		{SizeBucketBlinding{}, 10, 3, MinSizeBucket - 3},
+		{SizeBucketBlinding{}, 1000, 100, 924},
+		{SizeBucketBlinding{}, 1024, 100, 924},
+		{PercentageBlinding{Percent: 10}, 1000, 100, 100},
+		{PercentageBlinding{Percent: 10}, 1001, 100, 101},
+	}
+	for _, test := range tests {
+		if numEntries := test.policy.NumEntries(test.fileLen, test.numWords); numEntries != test.expected {
+			t.Fatalf("incorrect number of entries for %T with %d bytes and %d words: expected %d actual %d", test.policy, test.fileLen, test.numWords, test.expected, numEntries)
+		}
+	}
+}
+
+// TestSizeBucketBlinding tests the `SetBlindingPolicy` function.  Checks that
+// the documents of different lengths and words within a size bucket get
+// indexes with the same number of entries, that the dummy indexes do too, and
+// that the policy is recorded in the indexes through `MarshalBinary` and
+// `UnmarshalBinary`.
+func TestSizeBucketBlinding(t *testing.T) {
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), [][]byte{[]byte("salt")}, 1900000)
+	if err := sib.SetCodewordMapping(CodewordMappingCuckoo); err != nil {
+		t.Fatalf("error when setting the mapping: %s", err)
+	}
+	if err := sib.SetBlindingPolicy(SizeBucketBlinding{}); err != nil {
+		t.Fatalf("error when setting the blinding policy: %s", err)
+	}
+	documents := []string{strings.Repeat("a few words ", 25), strings.Repeat("many different words ", 20) + "and some more"}
+	for _, document := range documents {
+		si, err := sib.BuildSecureIndex(strings.NewReader(document), -1)
+		if err != nil {
+			t.Fatalf("error when building the index: %s", err)
+		}
+		if si.CuckooFilter.Count() != 512 || si.Blinding != BlindingPolicySizeBucket {
+			t.Fatalf("incorrect blinding of a document of %d bytes: %d entries, policy %d", len(document), si.CuckooFilter.Count(), si.Blinding)
+		}
+	}
+	dummy, err := sib.BuildDummySecureIndex(300)
+	if err != nil || dummy.CuckooFilter.Count() != 512 {
+		t.Fatalf("incorrect blinding of the dummy index: %d entries, %v", dummy.CuckooFilter.Count(), err)
+	}
+
+	input, err := dummy.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the index: %s", err)
+	}
+	var parsed SecureIndex
+	if err := parsed.UnmarshalBinary(input); err != nil || parsed.Blinding != BlindingPolicySizeBucket {
+		t.Fatalf("incorrect blinding policy unmarshaled: %d, %v", parsed.Blinding, err)
+	}
+	binary.PutUvarint(input[5*binary.MaxVarintLen64:], 0xff)
+	if err := parsed.UnmarshalBinary(input); err == nil {
+		t.Fatalf("no error when unmarshaling an unknown blinding policy")
+	}
+}
+
+// TestBlindCuckooFilter tests the `blindCuckooFilter` function on filters
+// filled near their capacity.  Checks that the random fingerprints never evict
+// the fingerprints of the words, and that the load stays within
+// `CuckooMaxLoad`.
+func TestBlindCuckooFilter(t *testing.T) {
+	type entry struct {
+		bucket uint64
+		fp     uint16
+	}
+	// In a filter of four buckets, the fingerprints that are multiples of 4
+	// have both their candidate buckets among the first two, which the
+	// words fill up.
+	var words []entry
+	for i := 0; i < 2*CuckooBucketSize; i++ {
+		words = append(words, entry{uint64(i % 2), uint16(4 * (i + 1))})
+	}
+	for trial := 0; trial < 100; trial++ {
+		cf := NewCuckooFilter(4)
+		for _, word := range words {
+			if err := cf.Insert(word.bucket, word.fp); err != nil {
+				t.Fatalf("error when inserting a word: %s", err)
+			}
+		}
+		if err := blindCuckooFilter(cf, int64(cf.Capacity())); err != nil {
+			t.Fatalf("error when blinding the filter: %s", err)
+		}
+		for _, word := range words {
+			if !cf.Contains(word.bucket, word.fp) {
+				t.Fatalf("word %v evicted by the blinding", word)
+			}
+		}
+		if maxCount := int(float64(cf.Capacity()) * CuckooMaxLoad); cf.Count() > maxCount {
+			t.Fatalf("load beyond the maximum: %d entries", cf.Count())
+		}
+	}
+}
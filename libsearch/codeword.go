@@ -0,0 +1,127 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// CodewordMapping is the versioned function mapping the trapdoors of a word to
+// the buckets of the bloom filter of an index, i.e. the type of the index.  The
+// mapping is recorded in each index, so that the indexes built with an older
+// mapping remain searchable.
+type CodewordMapping byte
+
+const (
+	// CodewordMappingUvarint decodes the MAC as a varint and reduces it modulo
+	// the size.  As the varint decoding stops at the first byte without its
+	// high bit set, the codewords are not uniformly distributed.  Kept for the
+	// indexes built before the mappings were versioned.
+	CodewordMappingUvarint CodewordMapping = 0
+	// CodewordMappingFixed64 truncates the MAC to its first 64 bits in
+	// little-endian order and reduces them modulo the size.  The bias of the
+	// reduction is below size/2^64.
+	CodewordMappingFixed64 CodewordMapping = 1
+	// CodewordMappingDoubleHashing derives the k buckets of a word from the
+	// MACs of its first two trapdoors only, with the double hashing of
+	// Kirsch and Mitzenmacher: bucket i is h1 + i*h2 modulo the size, for
+	// the MACs h1 and h2 truncated as with `CodewordMappingFixed64`.  This
+	// computes two MACs per word and document instead of k, with the same
+	// asymptotic false positive rate.
+	CodewordMappingDoubleHashing CodewordMapping = 2
+	// CodewordMappingCuckoo stores a 16-bit fingerprint of each word in a
+	// cuckoo filter instead of setting bits of a bloom filter, so that the
+	// words can be removed from an index without rebuilding it.  The
+	// fingerprint and the first candidate bucket are taken from the MAC of
+	// the first trapdoor of the word.
+	CodewordMappingCuckoo CodewordMapping = 3
+)
+
+// DefaultCodewordMapping is the mapping the bloom indexes are built with unless
+// another one is set with `SetCodewordMapping`.  It is the legacy mapping,
+// which every client and server can read, and the one of the TLFs that record
+// no mapping, i.e. registered before the mappings were recorded or with a
+// server that does not record them.
+const DefaultCodewordMapping = CodewordMappingUvarint
+
+// RegisteredCodewordMapping is the mapping the new bloom TLFs are registered
+// with, and their indexes built with.
+const RegisteredCodewordMapping = CodewordMappingFixed64
+
+// Validate returns an error if `m` is not a known mapping.
+func (m CodewordMapping) Validate() error {
+	switch m {
+	case CodewordMappingUvarint, CodewordMappingFixed64, CodewordMappingDoubleHashing, CodewordMappingCuckoo:
+		return nil
+	default:
+		return fmt.Errorf("unknown codeword mapping %d", m)
+	}
+}
+
+// Bucket maps the MAC `mac` of a trapdoor to a bucket of a bloom filter with
+// `size` buckets.  With `CodewordMappingDoubleHashing`, this is the first of
+// the buckets of the word.
+func (m CodewordMapping) Bucket(mac []byte, size uint64) uint64 {
+	if m == CodewordMappingUvarint {
+		// The error is ignored, as the MAC is truncated on purpose.
+		codeword, _ := binary.Uvarint(mac)
+		return codeword % size
+	}
+	return binary.LittleEndian.Uint64(mac[:8]) % size
+}
+
+// computeMAC returns the MAC of `nonce` under `trapdoor`, which is the codeword
+// of the trapdoor in the index with `nonce`.
+func computeMAC(h func() hash.Hash, trapdoor []byte, nonce uint64) []byte {
+	mac := hmac.New(h, trapdoor)
+	mac.Write(big.NewInt(int64(nonce)).Bytes())
+	return mac.Sum(nil)
+}
+
+// Buckets returns the buckets of a bloom filter with `size` buckets that the
+// word with `trapdoors` maps to in the index with `nonce`, whose MACs are
+// computed with `h`.
+func (m CodewordMapping) Buckets(h func() hash.Hash, trapdoors [][]byte, nonce, size uint64) []uint64 {
+	buckets := make([]uint64, len(trapdoors))
+	if m != CodewordMappingDoubleHashing {
+		for i, trapdoor := range trapdoors {
+			buckets[i] = m.Bucket(computeMAC(h, trapdoor, nonce), size)
+		}
+		return buckets
+	}
+	if len(trapdoors) == 0 {
+		return buckets
+	}
+	h1 := CodewordMappingFixed64.Bucket(computeMAC(h, trapdoors[0], nonce), size)
+	var h2 uint64
+	if len(trapdoors) > 1 {
+		h2 = CodewordMappingFixed64.Bucket(computeMAC(h, trapdoors[1], nonce), size)
+	}
+	if h2 == 0 {
+		// Keeps the buckets distinct.
+		h2 = 1
+	}
+	for i := range buckets {
+		buckets[i] = (h1 + uint64(i)*h2) % size
+	}
+	return buckets
+}
+
+// cuckooEntry returns the first candidate bucket and the fingerprint of the
+// word with `trapdoors` in the cuckoo filter of the index with `nonce`, whose
+// MACs are computed with `h`.
+func cuckooEntry(h func() hash.Hash, trapdoors [][]byte, nonce uint64) (uint64, uint16) {
+	mac := computeMAC(h, trapdoors[0], nonce)
+	fp := binary.LittleEndian.Uint16(mac[8:10])
+	if fp == 0 {
+		// 0 marks the empty slots.
+		fp = 1
+	}
+	return binary.LittleEndian.Uint64(mac[:8]), fp
+}
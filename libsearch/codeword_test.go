@@ -0,0 +1,116 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// TestCodewordMapping tests the `Bucket` function of the codeword mappings.
+// Checks that the legacy mapping is unchanged, and that the fixed-width
+// mapping spreads the codewords over the whole bloom filter where the legacy
+// one favors the first buckets.
+func TestCodewordMapping(t *testing.T) {
+	size := uint64(1900000)
+	numLow := map[CodewordMapping]int{}
+	const numMACs = 10000
+	for i := 0; i < numMACs; i++ {
+		var input [8]byte
+		binary.LittleEndian.PutUint64(input[:], uint64(i))
+		mac := sha256.Sum256(input[:])
+
+		legacy, _ := binary.Uvarint(mac[:])
+		if bucket := CodewordMappingUvarint.Bucket(mac[:], size); bucket != legacy%size {
+			t.Fatalf("legacy mapping changed: %d instead of %d", bucket, legacy%size)
+		}
+		if bucket := CodewordMappingFixed64.Bucket(mac[:], size); bucket != binary.LittleEndian.Uint64(mac[:8])%size {
+			t.Fatalf("incorrect fixed-width mapping: %d", bucket)
+		}
+		for _, mapping := range []CodewordMapping{CodewordMappingUvarint, CodewordMappingFixed64} {
+			if mapping.Bucket(mac[:], size) < 128 {
+				numLow[mapping]++
+			}
+		}
+	}
+	// The varint decoding of a uniform MAC stops after its first byte half
+	// of the time.
+	if numLow[CodewordMappingUvarint] < numMACs/3 {
+		t.Fatalf("legacy mapping not biased as expected: %d", numLow[CodewordMappingUvarint])
+	}
+	if numLow[CodewordMappingFixed64] > 10 {
+		t.Fatalf("fixed-width mapping biased towards the first buckets: %d", numLow[CodewordMappingFixed64])
+	}
+}
+
+// TestUnmarshalCodewordMapping tests that the codeword mapping of an index is
+// kept through `MarshalBinary` and `UnmarshalBinary`, and that an unknown
+// mapping is rejected.
+func TestUnmarshalCodewordMapping(t *testing.T) {
+	si := SecureIndex{BloomFilter: bitarray.NewSparseBitArray(), Nonce: 42, Size: 1900000, Hash: sha256.New, Mapping: CodewordMappingFixed64}
+	input, err := si.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the index: %s", err)
+	}
+	var parsed SecureIndex
+	if err := parsed.UnmarshalBinary(input); err != nil || parsed.Mapping != CodewordMappingFixed64 {
+		t.Fatalf("incorrect mapping unmarshaled: %d, %v", parsed.Mapping, err)
+	}
+	binary.PutUvarint(input[4*binary.MaxVarintLen64:], 0xff)
+	if err := parsed.UnmarshalBinary(input); err == nil {
+		t.Fatalf("no error when unmarshaling an unknown mapping")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), nil, 1900000)
+	if err := sib.SetCodewordMapping(CodewordMapping(0xff)); err == nil {
+		t.Fatalf("no error when setting an unknown mapping")
+	}
+}
+
+// TestDoubleHashingBuckets tests the `Buckets` function.  Checks that the older
+// mappings map each trapdoor on its own, and that double hashing derives all
+// the buckets from the first two trapdoors, spaced evenly.
+func TestDoubleHashingBuckets(t *testing.T) {
+	size := uint64(1900000)
+	nonce := uint64(42)
+	trapdoors := make([][]byte, 13)
+	for i := range trapdoors {
+		trapdoor := sha256.Sum256([]byte{byte(i)})
+		trapdoors[i] = trapdoor[:]
+	}
+	for _, mapping := range []CodewordMapping{CodewordMappingUvarint, CodewordMappingFixed64} {
+		buckets := mapping.Buckets(sha256.New, trapdoors, nonce, size)
+		for i, trapdoor := range trapdoors {
+			if expected := mapping.Bucket(computeMAC(sha256.New, trapdoor, nonce), size); buckets[i] != expected {
+				t.Fatalf("incorrect bucket %d for mapping %d: expected %d actual %d", i, mapping, expected, buckets[i])
+			}
+		}
+	}
+
+	buckets := CodewordMappingDoubleHashing.Buckets(sha256.New, trapdoors, nonce, size)
+	if len(buckets) != len(trapdoors) {
+		t.Fatalf("incorrect number of buckets: %d", len(buckets))
+	}
+	h1 := CodewordMappingFixed64.Bucket(computeMAC(sha256.New, trapdoors[0], nonce), size)
+	h2 := CodewordMappingFixed64.Bucket(computeMAC(sha256.New, trapdoors[1], nonce), size)
+	for i, bucket := range buckets {
+		if expected := (h1 + uint64(i)*h2) % size; bucket != expected {
+			t.Fatalf("incorrect bucket %d: expected %d actual %d", i, expected, bucket)
+		}
+	}
+	// Only the first two trapdoors are used.
+	modified := append([][]byte{}, trapdoors...)
+	modified[2] = trapdoors[3]
+	for i, bucket := range CodewordMappingDoubleHashing.Buckets(sha256.New, modified, nonce, size) {
+		if bucket != buckets[i] {
+			t.Fatalf("bucket %d depends on the third trapdoor", i)
+		}
+	}
+	if buckets := CodewordMappingDoubleHashing.Buckets(sha256.New, trapdoors[:1], nonce, size); len(buckets) != 1 || buckets[0] != h1 {
+		t.Fatalf("incorrect buckets for a single trapdoor: %v", buckets)
+	}
+}
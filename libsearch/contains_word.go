@@ -0,0 +1,47 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+)
+
+// wordConstituents are the characters a word is made of, as `grep -w` sees
+// them: the letters, the digits and the underscore.
+const wordConstituents = `\pL\pN_`
+
+// ContainsWord returns whether `word` appears in `document` as a whole word,
+// cases ignored, i.e. neither preceded nor followed by a letter, a digit or an
+// underscore, as matched by `grep -iw` with a fixed string.  The document is
+// scanned as a stream, without being read in memory at once.
+func ContainsWord(document io.Reader, word string) (bool, error) {
+	if word == "" {
+		return false, nil
+	}
+	re, err := regexp.Compile(`(?i)(?:^|[^` + wordConstituents + `])` + regexp.QuoteMeta(word) + `(?:$|[^` + wordConstituents + `])`)
+	if err != nil {
+		return false, err
+	}
+	reader := &errRuneReader{r: bufio.NewReader(document)}
+	found := re.MatchReader(reader)
+	return found, reader.err
+}
+
+// errRuneReader is an `io.RuneReader` keeping the first read error other than
+// `io.EOF`, which `regexp.Regexp.MatchReader` does not report.
+type errRuneReader struct {
+	r   io.RuneReader
+	err error
+}
+
+func (r *errRuneReader) ReadRune() (rune, int, error) {
+	ch, size, err := r.r.ReadRune()
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return ch, size, err
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestContainsWord tests the `ContainsWord` function.  Checks that the words
+// are matched as whole words with the cases ignored, as `grep -iw` does, and
+// that the other characters of the word are matched literally.
+func TestContainsWord(t *testing.T) {
+	document := "The Budget_2023 draft\nis due (c++ and go-lang), café.\nsubword"
+	for _, test := range []struct {
+		word     string
+		expected bool
+	}{
+		{"budget_2023", true},
+		{"budget", false},
+		{"DRAFT", true},
+		{"draft is", false},
+		{"c++", true},
+		{"++", false},
+		{"go", true},
+		{"lang", true},
+		{"CAFÉ", true},
+		{"word", false},
+		{"d.aft", false},
+		{"", false},
+	} {
+		actual, err := ContainsWord(strings.NewReader(document), test.word)
+		if err != nil || actual != test.expected {
+			t.Fatalf("incorrect match of %q: %v, %v", test.word, actual, err)
+		}
+	}
+}
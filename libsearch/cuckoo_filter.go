@@ -0,0 +1,214 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// CuckooBucketSize is the number of fingerprints each bucket of a cuckoo filter
+// holds.
+const CuckooBucketSize = 4
+
+// cuckooMaxKicks is the number of fingerprints relocated when inserting into
+// a cuckoo filter before the filter is considered full.
+const cuckooMaxKicks = 500
+
+// maxCuckooBuckets bounds the number of buckets of the unmarshaled cuckoo
+// filters, so that a corrupted index cannot exhaust the memory.
+const maxCuckooBuckets = 1 << 24
+
+// CuckooMaxLoad is the fraction of the slots of a cuckoo filter beyond which
+// the blinding stops, so that the words can still be inserted.
+const CuckooMaxLoad = 0.9
+
+// CuckooFilter is a cuckoo filter with partial-key cuckoo hashing: each entry
+// is a 16-bit fingerprint stored in one of its two candidate buckets, the
+// second of which is derived from the first and the fingerprint alone.  Unlike
+// a bloom filter, an entry can be deleted from the filter.
+type CuckooFilter struct {
+	numBuckets uint64   // The number of buckets, a power of two.
+	slots      []uint16 // The fingerprints of the buckets, 0 for an empty slot.
+	count      int      // The number of fingerprints in the filter.
+}
+
+// NewCuckooFilter creates an empty cuckoo filter with the largest power of two
+// number of buckets not exceeding `maxBuckets`, and at least one bucket.
+func NewCuckooFilter(maxBuckets uint64) *CuckooFilter {
+	numBuckets := cuckooNumBuckets(maxBuckets)
+	return &CuckooFilter{numBuckets: numBuckets, slots: make([]uint16, numBuckets*CuckooBucketSize)}
+}
+
+// cuckooNumBuckets returns the largest power of two not exceeding
+// `maxBuckets`, and at least one.
+func cuckooNumBuckets(maxBuckets uint64) uint64 {
+	numBuckets := uint64(1)
+	for numBuckets*2 <= maxBuckets {
+		numBuckets *= 2
+	}
+	return numBuckets
+}
+
+// NumBuckets returns the number of buckets of the filter.
+func (cf *CuckooFilter) NumBuckets() uint64 {
+	return cf.numBuckets
+}
+
+// Count returns the number of fingerprints in the filter.
+func (cf *CuckooFilter) Count() int {
+	return cf.count
+}
+
+// Capacity returns the number of fingerprints the filter can hold.
+func (cf *CuckooFilter) Capacity() int {
+	return len(cf.slots)
+}
+
+// altBucket returns the other candidate bucket of the fingerprint `fp` stored
+// in `bucket`.  The number of buckets being a power of two, this is an
+// involution.
+func (cf *CuckooFilter) altBucket(bucket uint64, fp uint16) uint64 {
+	// The multiplier of MurmurHash2 spreads the fingerprint over the
+	// buckets.
+	return (bucket ^ uint64(fp)*0x5bd1e995) & (cf.numBuckets - 1)
+}
+
+// bucketSlots returns the slots of `bucket`.
+func (cf *CuckooFilter) bucketSlots(bucket uint64) []uint16 {
+	return cf.slots[bucket*CuckooBucketSize : (bucket+1)*CuckooBucketSize]
+}
+
+// place stores `fp` in an empty slot of `bucket`, if any.
+func (cf *CuckooFilter) place(bucket uint64, fp uint16) bool {
+	slots := cf.bucketSlots(bucket)
+	for i := range slots {
+		if slots[i] == 0 {
+			slots[i] = fp
+			cf.count++
+			return true
+		}
+	}
+	return false
+}
+
+// Insert adds the fingerprint `fp`, which must not be 0, with the first
+// candidate bucket `bucket` to the filter.  The fingerprints are relocated
+// deterministically, so that the same insertions build the same filter.
+// Returns an error if the filter is full, in which case a fingerprint may have
+// been evicted.
+func (cf *CuckooFilter) Insert(bucket uint64, fp uint16) error {
+	bucket &= cf.numBuckets - 1
+	if cf.place(bucket, fp) || cf.place(cf.altBucket(bucket, fp), fp) {
+		return nil
+	}
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		slots := cf.bucketSlots(bucket)
+		victim := kick % CuckooBucketSize
+		fp, slots[victim] = slots[victim], fp
+		bucket = cf.altBucket(bucket, fp)
+		if cf.place(bucket, fp) {
+			return nil
+		}
+	}
+	return errors.New("cuckoo filter full")
+}
+
+// InsertFree adds the fingerprint `fp`, which must not be 0, with the first
+// candidate bucket `bucket` to the filter only if one of its candidate buckets
+// has an empty slot, without relocating the other fingerprints.  Returns
+// whether the fingerprint has been added.
+func (cf *CuckooFilter) InsertFree(bucket uint64, fp uint16) bool {
+	bucket &= cf.numBuckets - 1
+	return cf.place(bucket, fp) || cf.place(cf.altBucket(bucket, fp), fp)
+}
+
+// Contains returns whether the fingerprint `fp` with the first candidate
+// bucket `bucket` is in the filter.
+func (cf *CuckooFilter) Contains(bucket uint64, fp uint16) bool {
+	bucket &= cf.numBuckets - 1
+	for _, b := range []uint64{bucket, cf.altBucket(bucket, fp)} {
+		for _, slot := range cf.bucketSlots(b) {
+			if slot == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Delete removes one copy of the fingerprint `fp` with the first candidate
+// bucket `bucket` from the filter.  Returns false if the fingerprint is not in
+// the filter.  Only the fingerprints that have been inserted should be
+// deleted, as another entry sharing the fingerprint and the buckets would be
+// deleted otherwise.
+func (cf *CuckooFilter) Delete(bucket uint64, fp uint16) bool {
+	bucket &= cf.numBuckets - 1
+	for _, b := range []uint64{bucket, cf.altBucket(bucket, fp)} {
+		slots := cf.bucketSlots(b)
+		for i := range slots {
+			if slots[i] == fp {
+				slots[i] = 0
+				cf.count--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.  Only the
+// occupied slots are written, as the delta from the previous occupied slot
+// followed by the fingerprint.
+func (cf *CuckooFilter) MarshalBinary() ([]byte, error) {
+	result := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+cf.count*(binary.MaxVarintLen64+2))
+	binary.PutUvarint(result[0:], cf.numBuckets)
+	binary.PutUvarint(result[binary.MaxVarintLen64:], uint64(cf.count))
+	var buf [binary.MaxVarintLen64]byte
+	prev := 0
+	for i, slot := range cf.slots {
+		if slot == 0 {
+			continue
+		}
+		n := binary.PutUvarint(buf[:], uint64(i-prev))
+		result = append(result, buf[:n]...)
+		result = append(result, byte(slot), byte(slot>>8))
+		prev = i
+	}
+	return result, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (cf *CuckooFilter) UnmarshalBinary(input []byte) error {
+	if len(input) < 2*binary.MaxVarintLen64 {
+		return errors.New("insufficient binary length")
+	}
+	numBuckets, _ := binary.Uvarint(input[0:])
+	count, _ := binary.Uvarint(input[binary.MaxVarintLen64:])
+	if numBuckets == 0 || numBuckets&(numBuckets-1) != 0 || numBuckets > maxCuckooBuckets {
+		return errors.New("invalid number of buckets")
+	}
+	slots := make([]uint16, numBuckets*CuckooBucketSize)
+	if count > uint64(len(slots)) {
+		return errors.New("invalid number of fingerprints")
+	}
+	input = input[2*binary.MaxVarintLen64:]
+	slot := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(input)
+		if n <= 0 || len(input) < n+2 {
+			return errors.New("truncated cuckoo filter")
+		}
+		slot += delta
+		fp := uint16(input[n]) | uint16(input[n+1])<<8
+		if (i > 0 && delta == 0) || slot >= uint64(len(slots)) || fp == 0 {
+			return errors.New("invalid slot in the cuckoo filter")
+		}
+		slots[slot] = fp
+		input = input[n+2:]
+	}
+	cf.numBuckets, cf.slots, cf.count = numBuckets, slots, int(count)
+	return nil
+}
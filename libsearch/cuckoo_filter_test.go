@@ -0,0 +1,89 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCuckooFilter tests the `Insert`, `Contains` and `Delete` methods of
+// `CuckooFilter`.  Checks that the entries are found once inserted and no
+// longer found once deleted, and that a full filter reports an error.
+func TestCuckooFilter(t *testing.T) {
+	cf := NewCuckooFilter(100)
+	if cf.NumBuckets() != 64 || cf.Capacity() != 64*CuckooBucketSize {
+		t.Fatalf("incorrect number of buckets: %d", cf.NumBuckets())
+	}
+	numEntries := cf.Capacity() * 8 / 10
+	for i := 0; i < numEntries; i++ {
+		if err := cf.Insert(uint64(i)*7919, uint16(i+1)); err != nil {
+			t.Fatalf("error when inserting the entry %d: %s", i, err)
+		}
+	}
+	if cf.Count() != numEntries {
+		t.Fatalf("incorrect count: expected %d actual %d", numEntries, cf.Count())
+	}
+	for i := 0; i < numEntries; i++ {
+		if !cf.Contains(uint64(i)*7919, uint16(i+1)) {
+			t.Fatalf("entry %d not found after being inserted", i)
+		}
+	}
+	for i := 0; i < numEntries; i += 2 {
+		if !cf.Delete(uint64(i)*7919, uint16(i+1)) {
+			t.Fatalf("entry %d not deleted", i)
+		}
+	}
+	for i := 0; i < numEntries; i++ {
+		if found := cf.Contains(uint64(i)*7919, uint16(i+1)); found != (i%2 == 1) {
+			t.Fatalf("incorrect presence of the entry %d after the deletions: %t", i, found)
+		}
+	}
+	if cf.Delete(0, 1) {
+		t.Fatalf("entry deleted twice")
+	}
+
+	full := NewCuckooFilter(1)
+	for i := 0; i < CuckooBucketSize; i++ {
+		if err := full.Insert(0, uint16(i+1)); err != nil {
+			t.Fatalf("error when inserting into the filter: %s", err)
+		}
+	}
+	if err := full.Insert(0, 42); err == nil {
+		t.Fatalf("no error when inserting into a full filter")
+	}
+}
+
+// TestCuckooFilterMarshalBinary tests the `MarshalBinary` and `UnmarshalBinary`
+// methods of `CuckooFilter`.  Checks that a filter survives a round trip, and
+// that corrupted inputs are rejected.
+func TestCuckooFilterMarshalBinary(t *testing.T) {
+	cf := NewCuckooFilter(1024)
+	for i := 0; i < 500; i++ {
+		if err := cf.Insert(uint64(i)*104729, uint16(i*31+1)); err != nil {
+			t.Fatalf("error when inserting the entry %d: %s", i, err)
+		}
+	}
+	cfBytes, err := cf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the filter: %s", err)
+	}
+	var parsed CuckooFilter
+	if err := parsed.UnmarshalBinary(cfBytes); err != nil {
+		t.Fatalf("error when unmarshaling the filter: %s", err)
+	}
+	if !reflect.DeepEqual(*cf, parsed) {
+		t.Fatalf("the unmarshaled filter differs from the original one")
+	}
+
+	if err := parsed.UnmarshalBinary(cfBytes[:len(cfBytes)-1]); err == nil {
+		t.Fatalf("no error for a truncated filter")
+	}
+	corrupted := append([]byte(nil), cfBytes...)
+	corrupted[0] = 3
+	if err := parsed.UnmarshalBinary(corrupted); err == nil {
+		t.Fatalf("no error for a number of buckets that is not a power of two")
+	}
+}
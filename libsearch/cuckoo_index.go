@@ -0,0 +1,110 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// cuckooFingerprintBits is the number of bits of a fingerprint of a cuckoo
+// filter, by which the size of the bloom filters is divided so that the cuckoo
+// filters take about the same space.
+const cuckooFingerprintBits = 16
+
+// cuckooBlindingDraws is the number of random fingerprints drawn per blinding
+// entry before the blinding of a cuckoo filter gives up on the entries whose
+// buckets are all full.
+const cuckooBlindingDraws = 8
+
+// newCuckooFilter creates an empty cuckoo filter for an index, taking about the
+// space of a bloom filter with the size of the builder.
+func (sib *SecureIndexBuilder) newCuckooFilter() *CuckooFilter {
+	return NewCuckooFilter(sib.size / (CuckooBucketSize * cuckooFingerprintBits))
+}
+
+// buildCuckooFilter builds a cuckoo filter with the words of `document` and
+// the `keywords`, and returns it along with the set of words.  Returns an error
+// if the filter is full.
+func (sib *SecureIndexBuilder) buildCuckooFilter(nonce uint64, document io.Reader, keywords ...string) (*CuckooFilter, map[string]bool, error) {
+	cf := sib.newCuckooFilter()
+	var err error
+	words := scanWords(document, keywords, sib.nearWindow, func(word string) {
+		if err == nil {
+			err = cf.Insert(cuckooEntry(sib.hash, sib.trapdoorFunc(word), nonce))
+		}
+	})
+	return cf, words, err
+}
+
+// buildCuckooSecureIndex builds the index of `document` with an *encrypted*
+// length of `fileLen`, or the number of bytes read if negative, and the
+// `keywords` in a cuckoo filter, blinded with as many random fingerprints as the
+// blinding policy of the builder sets, and returns it along with the words of
+// the document.
+func (sib *SecureIndexBuilder) buildCuckooSecureIndex(nonce uint64, document *countingReader, fileLen int64, keywords []string) (SecureIndex, []string, error) {
+	cf, words, err := sib.buildCuckooFilter(nonce, document, keywords...)
+	if err != nil {
+		return SecureIndex{}, nil, err
+	}
+	err = blindCuckooFilter(cf, sib.blinding.NumEntries(document.length(fileLen), len(words)))
+	wordList := make([]string, 0, len(words))
+	for word := range words {
+		wordList = append(wordList, word)
+	}
+	return SecureIndex{CuckooFilter: cf, Nonce: nonce, Size: cf.NumBuckets(), Hash: sib.hash, Mapping: sib.mapping, Blinding: sib.blinding.Version()}, wordList, err
+}
+
+// blindCuckooFilter inserts `numEntries` random fingerprints into `cf`, or
+// fewer if the load of the filter would exceed `CuckooMaxLoad`, so that the
+// number of entries does not reveal the number of unique words.  The random
+// fingerprints only take the empty slots, as relocating the fingerprints of
+// the words could evict one of them, and are drawn again when both their
+// buckets are full, up to `cuckooBlindingDraws` times per entry.
+func blindCuckooFilter(cf *CuckooFilter, numEntries int64) error {
+	if maxEntries := int64(float64(cf.Capacity())*CuckooMaxLoad) - int64(cf.Count()); numEntries > maxEntries {
+		numEntries = maxEntries
+	}
+	for draws := numEntries * cuckooBlindingDraws; numEntries > 0 && draws > 0; {
+		randNums := make([]uint64, numEntries)
+		if err := binary.Read(rand.Reader, binary.LittleEndian, &randNums); err != nil {
+			return err
+		}
+		draws -= int64(len(randNums))
+		for _, randNum := range randNums {
+			fp := uint16(randNum >> (64 - cuckooFingerprintBits))
+			if fp == 0 {
+				fp = 1
+			}
+			if cf.InsertFree(randNum, fp) {
+				numEntries--
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateSecureIndex updates the index `si` of a document that has been
+// modified in place: the `removed` words, which must have been indexed, are
+// deleted from its cuckoo filter, and the `added` words are inserted, without
+// rebuilding the index.  The words are taken in the form they are indexed in,
+// e.g. as returned by `BuildSecureIndexWithKeywords`.  Returns an error if the
+// index is not a cuckoo filter, or if it is full.
+func (sib *SecureIndexBuilder) UpdateSecureIndex(si *SecureIndex, added, removed []string) error {
+	if si.Mapping != CodewordMappingCuckoo {
+		return errors.New("only the indexes with cuckoo filters can be updated")
+	}
+	for _, word := range removed {
+		si.CuckooFilter.Delete(cuckooEntry(sib.hash, sib.trapdoorFunc(word), si.Nonce))
+	}
+	for _, word := range added {
+		if err := si.CuckooFilter.Insert(cuckooEntry(sib.hash, sib.trapdoorFunc(word), si.Nonce)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// saltsKeyDomain separates the key used to encrypt the salts from the other
+// keys derived from the master secret.
+const saltsKeyDomain = "kbfs_search_salts"
+
+// saltsNonceLength is the length of the nonce used to seal the salts.
+const saltsNonceLength = 24
+
+// deriveSaltsKey derives the key used to encrypt the salts of a TLF from its
+// `masterSecret`.
+func deriveSaltsKey(masterSecret []byte) [32]byte {
+	mac := hmac.New(sha256.New, masterSecret)
+	mac.Write([]byte(saltsKeyDomain))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// SealSalts encrypts `salts` under a key derived from `masterSecret` with a
+// random nonce, so that the search server can store and relay the salts of a
+// TLF without learning them.
+func SealSalts(salts [][]byte, masterSecret []byte) ([]byte, error) {
+	var plaintext []byte
+	for _, salt := range salts {
+		var lenBytes [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBytes[:], uint64(len(salt)))
+		plaintext = append(plaintext, lenBytes[:n]...)
+		plaintext = append(plaintext, salt...)
+	}
+
+	var nonce [saltsNonceLength]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := deriveSaltsKey(masterSecret)
+
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &key), nil
+}
+
+// OpenSalts decrypts the salts sealed by `SealSalts` with `masterSecret`.
+// Returns an error if the sealed salts are malformed or the master secret is
+// incorrect.
+func OpenSalts(sealed []byte, masterSecret []byte) ([][]byte, error) {
+	if len(sealed) < saltsNonceLength {
+		return nil, errors.New("insufficient sealed salts length")
+	}
+	var nonce [saltsNonceLength]byte
+	copy(nonce[:], sealed[:saltsNonceLength])
+	key := deriveSaltsKey(masterSecret)
+
+	plaintext, ok := secretbox.Open(nil, sealed[saltsNonceLength:], &nonce, &key)
+	if !ok {
+		return nil, errors.New("invalid sealed salts")
+	}
+
+	var salts [][]byte
+	for len(plaintext) > 0 {
+		lenSalt, n := binary.Uvarint(plaintext)
+		if n <= 0 || lenSalt > uint64(len(plaintext)-n) {
+			return nil, errors.New("invalid salts encoding")
+		}
+		salts = append(salts, plaintext[n:n+int(lenSalt)])
+		plaintext = plaintext[n+int(lenSalt):]
+	}
+	return salts, nil
+}
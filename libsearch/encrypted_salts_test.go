@@ -0,0 +1,51 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestSealAndOpenSalts tests the `SealSalts` and `OpenSalts` functions.  Checks
+// that the salts survive the round trip, are not stored in the clear, and
+// cannot be opened with a different master secret or after tampering.
+func TestSealAndOpenSalts(t *testing.T) {
+	salts, err := GenerateSalts(10, 32)
+	if err != nil {
+		t.Fatalf("error when generating the salts: %s", err)
+	}
+	masterSecret := bytes.Repeat([]byte{0x42}, 64)
+
+	sealed, err := SealSalts(salts, masterSecret)
+	if err != nil {
+		t.Fatalf("error when sealing the salts: %s", err)
+	}
+	for _, salt := range salts {
+		if bytes.Contains(sealed, salt) {
+			t.Fatalf("salt stored in the clear")
+		}
+	}
+
+	opened, err := OpenSalts(sealed, masterSecret)
+	if err != nil {
+		t.Fatalf("error when opening the salts: %s", err)
+	}
+	if !reflect.DeepEqual(salts, opened) {
+		t.Fatalf("salts do not match after the round trip")
+	}
+
+	if _, err := OpenSalts(sealed, bytes.Repeat([]byte{0x43}, 64)); err == nil {
+		t.Fatalf("salts opened with the wrong master secret")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := OpenSalts(sealed, masterSecret); err == nil {
+		t.Fatalf("tampered salts opened")
+	}
+	if _, err := OpenSalts(sealed[:10], masterSecret); err == nil {
+		t.Fatalf("truncated salts opened")
+	}
+}
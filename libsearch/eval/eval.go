@@ -0,0 +1,433 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package eval measures the recall, precision and query latency of the secure
+// indexes over a labeled corpus, generated with planted keywords by default.
+package eval
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/search/libsearch"
+)
+
+// Options are the parameters of an evaluation, as set by the flags of `Run`.
+type Options struct {
+	CorpusDir    string  // The directory of a labeled corpus; a corpus is generated if empty.
+	NumDocs      int     // The number of documents in the generated corpus.
+	WordsPerDoc  int     // The number of filler words per document in the generated corpus.
+	VocabSize    int     // The number of distinct filler words in the generated corpus.
+	NumQueries   int     // The number of planted keywords, i.e. queries, in the generated corpus.
+	PlantRate    float64 // The fraction of the documents each keyword is planted in.
+	Seed         int64   // The seed used to generate the corpus.
+	LenSalt      int     // The length of the salts used to generate the PRFs.
+	FpRate       float64 // The desired false positive rate.
+	NumUniqWords uint64  // The expected number of unique words in all the documents.
+	JSON         bool    // Whether the report is printed out in JSON.
+}
+
+// newFlagSet returns the flags of `Run`, named `name`, which set `opts`.
+func newFlagSet(name string, opts *Options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.CorpusDir, "corpus", "", "the directory of a labeled corpus to evaluate; a corpus with planted keywords is generated if empty")
+	flags.IntVar(&opts.NumDocs, "num_docs", 1000, "the number of documents in the generated corpus")
+	flags.IntVar(&opts.WordsPerDoc, "words_per_doc", 200, "the number of filler words per document in the generated corpus")
+	flags.IntVar(&opts.VocabSize, "vocab_size", 20000, "the number of distinct filler words in the generated corpus")
+	flags.IntVar(&opts.NumQueries, "num_queries", 50, "the number of planted keywords, i.e. queries, in the generated corpus")
+	flags.Float64Var(&opts.PlantRate, "plant_rate", 0.05, "the fraction of the documents each keyword is planted in for the generated corpus")
+	flags.Int64Var(&opts.Seed, "seed", 1, "the seed used to generate the corpus")
+	flags.IntVar(&opts.LenSalt, "len_salt", 32, "the length of the salts used to generate the PRFs")
+	flags.Float64Var(&opts.FpRate, "fp_rate", 0.000001, "the desired false positive rate for searchable encryption")
+	flags.Uint64Var(&opts.NumUniqWords, "num_words", uint64(100000), "the expected number of unique words in all the documents")
+	flags.BoolVar(&opts.JSON, "json", false, "whether the report should be printed out in JSON")
+	return flags
+}
+
+// labelsFile is the name of the file in a labeled corpus that maps each query
+// keyword to the relative paths of the documents containing it.
+const labelsFile = "labels.json"
+
+// QueryResult holds the evaluation of a single query.
+type QueryResult struct {
+	Keyword        string        `json:"keyword"`
+	TruePositives  int           `json:"truePositives"`
+	FalsePositives int           `json:"falsePositives"`
+	FalseNegatives int           `json:"falseNegatives"`
+	Latency        time.Duration `json:"latency"`
+}
+
+// Report holds the evaluation of a query set over a corpus.
+type Report struct {
+	NumDocs        int           `json:"numDocs"`
+	NumKeys        int           `json:"numKeys"`
+	Size           uint64        `json:"size"`
+	IndexTime      time.Duration `json:"indexTime"`
+	TargetFpRate   float64       `json:"targetFpRate"`
+	Recall         float64       `json:"recall"`
+	Precision      float64       `json:"precision"`
+	FpRate         float64       `json:"fpRate"`
+	MedianLatency  time.Duration `json:"medianLatency"`
+	P99Latency     time.Duration `json:"p99Latency"`
+	Queries        []QueryResult `json:"queries"`
+	TruePositives  int           `json:"truePositives"`
+	FalsePositives int           `json:"falsePositives"`
+	FalseNegatives int           `json:"falseNegatives"`
+}
+
+// generateCorpus writes a corpus of random filler words to `directory`, plants
+// each query keyword in a random subset of the documents, and writes the
+// labels of the planted keywords.
+func generateCorpus(directory string, opts Options) error {
+	rng := mathrand.New(mathrand.NewSource(opts.Seed))
+	labels := make(map[string][]string)
+	keywords := make([]string, opts.NumQueries)
+	for i := range keywords {
+		keywords[i] = "planted" + strconv.Itoa(i)
+	}
+
+	for i := 0; i < opts.NumDocs; i++ {
+		name := "doc" + strconv.Itoa(i)
+		words := make([]string, opts.WordsPerDoc, opts.WordsPerDoc+len(keywords))
+		for j := range words {
+			words[j] = "filler" + strconv.Itoa(rng.Intn(opts.VocabSize))
+		}
+		for _, keyword := range keywords {
+			if rng.Float64() < opts.PlantRate {
+				// Plants the keyword with a random capitalization and
+				// punctuation to exercise the normalization.
+				planted := keyword
+				if rng.Intn(2) == 0 {
+					planted = strings.ToUpper(planted)
+				}
+				if rng.Intn(2) == 0 {
+					planted += "."
+				}
+				pos := rng.Intn(len(words) + 1)
+				words = append(words, "")
+				copy(words[pos+1:], words[pos:])
+				words[pos] = planted
+				labels[keyword] = append(labels[keyword], name)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(strings.Join(words, " ")), 0666); err != nil {
+			return err
+		}
+	}
+	for _, keyword := range keywords {
+		if _, ok := labels[keyword]; !ok {
+			labels[keyword] = []string{}
+		}
+	}
+
+	labelsJSON, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(directory, labelsFile), labelsJSON, 0666)
+}
+
+// readLabels reads the labels of the corpus at `directory`.
+func readLabels(directory string) (map[string][]string, error) {
+	labelsJSON, err := ioutil.ReadFile(filepath.Join(directory, labelsFile))
+	if err != nil {
+		return nil, err
+	}
+	var labels map[string][]string
+	err = json.Unmarshal(labelsJSON, &labels)
+	return labels, err
+}
+
+// indexCorpus builds a secure index for each of the non-hidden documents under
+// `directory` other than the labels, keyed by their relative paths.
+func indexCorpus(indexer *libsearch.SecureIndexBuilder, directory string) (map[string]libsearch.SecureIndex, error) {
+	indexes := make(map[string]libsearch.SecureIndex)
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != directory && info.Name()[0] == '.' {
+			return filepath.SkipDir
+		} else if info.IsDir() || info.Name()[0] == '.' || path == filepath.Join(directory, labelsFile) {
+			return nil
+		}
+		relPath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		secIndex, err := indexer.BuildSecureIndex(file, info.Size())
+		if err != nil {
+			return err
+		}
+		indexes[relPath] = secIndex
+		return nil
+	})
+	return indexes, err
+}
+
+// evaluate runs the query for each keyword in `labels` against `indexes` and
+// computes the recall, precision and false positive rate of the results.
+func evaluate(indexer *libsearch.SecureIndexBuilder, indexes map[string]libsearch.SecureIndex, labels map[string][]string) Report {
+	report := Report{NumDocs: len(indexes), NumKeys: indexer.NumKeys(), Size: indexer.Size()}
+	keywords := make([]string, 0, len(labels))
+	for keyword := range labels {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	var negatives int
+	latencies := make([]time.Duration, 0, len(keywords))
+	for _, keyword := range keywords {
+		expected := make(map[string]bool)
+		for _, doc := range labels[keyword] {
+			expected[filepath.Clean(doc)] = true
+		}
+
+		result := QueryResult{Keyword: keyword}
+		start := time.Now()
+		trapdoors := indexer.ComputeTrapdoors(keyword)
+		matches := make(map[string]bool)
+		for doc, secIndex := range indexes {
+			if secIndex.ContainsTrapdoors(trapdoors) {
+				matches[doc] = true
+			}
+		}
+		result.Latency = time.Since(start)
+
+		for doc := range matches {
+			if expected[doc] {
+				result.TruePositives++
+			} else {
+				result.FalsePositives++
+			}
+		}
+		result.FalseNegatives = len(expected) - result.TruePositives
+		negatives += len(indexes) - len(expected)
+
+		report.TruePositives += result.TruePositives
+		report.FalsePositives += result.FalsePositives
+		report.FalseNegatives += result.FalseNegatives
+		report.Queries = append(report.Queries, result)
+		latencies = append(latencies, result.Latency)
+	}
+
+	report.Recall = ratio(report.TruePositives, report.TruePositives+report.FalseNegatives)
+	report.Precision = ratio(report.TruePositives, report.TruePositives+report.FalsePositives)
+	report.FpRate = ratio(report.FalsePositives, negatives)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.MedianLatency = latencies[len(latencies)/2]
+		report.P99Latency = latencies[int(math.Ceil(0.99*float64(len(latencies))))-1]
+	}
+	return report
+}
+
+// ratio returns `num / denom`, or 1 if `denom` is 0.
+func ratio(num, denom int) float64 {
+	if denom == 0 {
+		return 1
+	}
+	return float64(num) / float64(denom)
+}
+
+// printReport prints out `report` to `out` in a human readable form.
+func printReport(out io.Writer, report Report) {
+	fmt.Fprintf(out, "Documents: %d, keys: %d, index size: %d bits, indexed in %s\n", report.NumDocs, report.NumKeys, report.Size, report.IndexTime)
+	fmt.Fprintf(out, "%-20s %8s %8s %8s %12s\n", "Keyword", "TP", "FP", "FN", "Latency")
+	for _, query := range report.Queries {
+		fmt.Fprintf(out, "%-20s %8d %8d %8d %12s\n", query.Keyword, query.TruePositives, query.FalsePositives, query.FalseNegatives, query.Latency)
+	}
+	fmt.Fprintf(out, "\nRecall: %.6f\nPrecision: %.6f\nFalse positive rate: %.8f (target %.8f)\n", report.Recall, report.Precision, report.FpRate, report.TargetFpRate)
+	fmt.Fprintf(out, "Median latency: %s, p99 latency: %s\n", report.MedianLatency, report.P99Latency)
+}
+
+// Run parses the flags `args` of an evaluation named `name`, runs it, and
+// prints out its report to `out`.
+func Run(name string, args []string, out io.Writer) error {
+	var opts Options
+	flags := newFlagSet(name, &opts)
+	flags.SetOutput(out)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	report, err := Evaluate(opts)
+	if err != nil {
+		return err
+	}
+	if !opts.JSON {
+		printReport(out, report)
+		return nil
+	}
+	return printJSON(out, report)
+}
+
+// printJSON prints out `v` to `out` in JSON.
+func printJSON(out io.Writer, v interface{}) error {
+	vJSON, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error when encoding the report: %s", err)
+	}
+	_, err = fmt.Fprintln(out, string(vJSON))
+	return err
+}
+
+// printTuning prints out the `reports` of a tuning to `out` in a human
+// readable form, one line per target false positive rate.
+func printTuning(out io.Writer, reports []Report) {
+	if len(reports) > 0 {
+		fmt.Fprintf(out, "Documents: %d\n", reports[0].NumDocs)
+	}
+	fmt.Fprintf(out, "%-12s %6s %12s %10s %10s %12s %12s %12s\n", "Target FP", "Keys", "Size (bits)", "Recall", "Precision", "FP rate", "Index time", "Latency")
+	for _, report := range reports {
+		fmt.Fprintf(out, "%-12g %6d %12d %10.6f %10.6f %12.8f %12s %12s\n", report.TargetFpRate, report.NumKeys, report.Size, report.Recall, report.Precision, report.FpRate, report.IndexTime, report.MedianLatency)
+	}
+}
+
+// parseFpRates parses the comma-separated false positive rates `list`.
+func parseFpRates(list string) ([]float64, error) {
+	var fpRates []float64
+	for _, field := range strings.Split(list, ",") {
+		fpRate, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || fpRate <= 0 || fpRate >= 1 {
+			return nil, fmt.Errorf("invalid false positive rate \"%s\"", field)
+		}
+		fpRates = append(fpRates, fpRate)
+	}
+	return fpRates, nil
+}
+
+// RunTune parses the flags `args` of a tuning named `name`, evaluates the
+// corpus at each of the false positive rates of its -fp_rates flag, and prints
+// out the reports side by side to `out`.
+func RunTune(name string, args []string, out io.Writer) error {
+	var opts Options
+	flags := newFlagSet(name, &opts)
+	fpRateList := flags.String("fp_rates", "0.01,0.0001,0.000001,0.00000001", "the comma-separated false positive rates to evaluate the corpus at, in place of -fp_rate")
+	flags.SetOutput(out)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	fpRates, err := parseFpRates(*fpRateList)
+	if err != nil {
+		return err
+	}
+	reports, err := Tune(opts, fpRates)
+	if err != nil {
+		return err
+	}
+	if !opts.JSON {
+		printTuning(out, reports)
+		return nil
+	}
+	return printJSON(out, reports)
+}
+
+// prepareCorpus returns the directory of the corpus of `opts`, generated in a
+// temporary directory if `opts` has none, and its labels.  The returned
+// function removes the generated corpus.
+func prepareCorpus(opts Options) (string, map[string][]string, func(), error) {
+	directory := opts.CorpusDir
+	cleanup := func() {}
+	if directory == "" {
+		var err error
+		directory, err = ioutil.TempDir("", "search_eval")
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("error when creating the corpus directory: %s", err)
+		}
+		cleanup = func() { os.RemoveAll(directory) }
+		if err := generateCorpus(directory, opts); err != nil {
+			cleanup()
+			return "", nil, nil, fmt.Errorf("error when generating the corpus: %s", err)
+		}
+	}
+
+	labels, err := readLabels(directory)
+	if err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("error when reading the labels: %s", err)
+	}
+	return directory, labels, cleanup, nil
+}
+
+// Evaluate indexes the corpus of `opts` and evaluates the queries of its
+// labels.
+func Evaluate(opts Options) (Report, error) {
+	directory, labels, cleanup, err := prepareCorpus(opts)
+	if err != nil {
+		return Report{}, err
+	}
+	defer cleanup()
+	return evaluateCorpus(directory, labels, opts)
+}
+
+// Tune indexes the corpus of `opts` once for each of the false positive rates
+// `fpRates`, in place of `opts.FpRate`, and evaluates the queries of its labels
+// against each of the indexes.  The reports are in the order of `fpRates`.
+func Tune(opts Options, fpRates []float64) ([]Report, error) {
+	directory, labels, cleanup, err := prepareCorpus(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	reports := make([]Report, len(fpRates))
+	for i, fpRate := range fpRates {
+		opts.FpRate = fpRate
+		if reports[i], err = evaluateCorpus(directory, labels, opts); err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+// evaluateCorpus indexes the corpus at `directory` with the parameters of
+// `opts` and evaluates the queries of its `labels`.
+func evaluateCorpus(directory string, labels map[string][]string, opts Options) (Report, error) {
+	numKeys := int(math.Ceil(-math.Log2(opts.FpRate)))
+	size := uint64(math.Ceil(float64(opts.NumUniqWords) * float64(numKeys) / math.Log(2)))
+	salts, err := libsearch.GenerateSalts(numKeys, opts.LenSalt)
+	if err != nil {
+		return Report{}, fmt.Errorf("error when generating the salts: %s", err)
+	}
+	masterSecret := make([]byte, 64)
+	if _, err := rand.Read(masterSecret); err != nil {
+		return Report{}, fmt.Errorf("error when generating the master secret: %s", err)
+	}
+	indexer := libsearch.CreateSecureIndexBuilder(sha256.New, masterSecret, salts, size)
+
+	start := time.Now()
+	indexes, err := indexCorpus(indexer, directory)
+	if err != nil {
+		return Report{}, fmt.Errorf("error when indexing the corpus: %s", err)
+	}
+	indexTime := time.Since(start)
+
+	report := evaluate(indexer, indexes, labels)
+	report.IndexTime = indexTime
+	report.TargetFpRate = opts.FpRate
+	return report, nil
+}
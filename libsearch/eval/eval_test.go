@@ -0,0 +1,64 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestRun tests the `Run` function over a small generated corpus.  Checks that
+// every planted keyword is found, and that the report is printed out in JSON.
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	args := []string{"-num_docs=20", "-words_per_doc=20", "-vocab_size=100", "-num_queries=5", "-plant_rate=0.5", "-num_words=1000", "-json"}
+	if err := Run("eval", args, &out); err != nil {
+		t.Fatalf("error when running the evaluation: %s", err)
+	}
+	var report Report
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("error when decoding the report: %s", err)
+	}
+	if report.NumDocs != 20 || len(report.Queries) != 5 || report.Recall != 1 || report.FalseNegatives != 0 {
+		t.Fatalf("incorrect report: %+v", report)
+	}
+}
+
+// TestRunArgs tests the `Run` function with invalid arguments.
+func TestRunArgs(t *testing.T) {
+	var out bytes.Buffer
+	if err := Run("eval", []string{"-frobnicate"}, &out); err == nil {
+		t.Fatalf("unknown flag accepted")
+	}
+	if err := Run("eval", []string{"corpus"}, &out); err == nil {
+		t.Fatalf("unexpected argument accepted")
+	}
+}
+
+// TestRunTune tests the `RunTune` function over a small generated corpus.
+// Checks that there is a report per false positive rate, in order, with fewer
+// keys for the higher rates.
+func TestRunTune(t *testing.T) {
+	var out bytes.Buffer
+	args := []string{"-num_docs=20", "-words_per_doc=20", "-vocab_size=100", "-num_queries=5", "-plant_rate=0.5", "-num_words=1000", "-fp_rates=0.1,0.0001", "-json"}
+	if err := RunTune("tune", args, &out); err != nil {
+		t.Fatalf("error when running the tuning: %s", err)
+	}
+	var reports []Report
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatalf("error when decoding the reports: %s", err)
+	}
+	if len(reports) != 2 || reports[0].TargetFpRate != 0.1 || reports[1].TargetFpRate != 0.0001 {
+		t.Fatalf("incorrect reports: %+v", reports)
+	}
+	if reports[0].NumKeys >= reports[1].NumKeys || reports[1].Recall != 1 {
+		t.Fatalf("incorrect reports: %+v", reports)
+	}
+
+	if err := RunTune("tune", []string{"-fp_rates=0.1,2"}, &out); err == nil {
+		t.Fatalf("invalid false positive rate accepted")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// ComputeFilterSize returns the number of buckets of the bloom filters of a
+// TLF holding `numUniqWords` unique words with `numKeys` PRF keys, as the
+// search server sizes them at registration.
+func ComputeFilterSize(numUniqWords uint64, numKeys int) uint64 {
+	return uint64(math.Ceil(float64(numUniqWords) * float64(numKeys) / math.Log(2)))
+}
+
+// EstimateIndexSize estimates the number of bytes of the marshaled index of a
+// document holding `numEntries` entries, words and random ones alike, built
+// with `numKeys` PRF keys and `mapping` for a TLF whose bloom filters have
+// `size` buckets, blinded with the policy of `blinding` and size-bucketed if
+// `sizeBucket` is set, without building it.  As the indexes are blinded up to
+// a number of entries set by the blinding policy, e.g. the *encrypted* length
+// of the document with `LengthBlinding`, the estimate does not depend on their
+// content.
+func EstimateIndexSize(numEntries int64, numKeys int, size uint64, mapping CodewordMapping, blinding BlindingPolicyVersion, sizeBucket bool) int64 {
+	length := int64(IndexHeaderLength(mapping, blinding, sizeBucket)) + estimateFilterLength(numEntries, numKeys, size, mapping)
+	if sizeBucket {
+		return int64(IndexSizeBucket(int(length)))
+	}
+	return length
+}
+
+// estimateFilterLength estimates the number of bytes of the marshaled filter
+// of an index, as `EstimateIndexSize` does for the whole index.
+func estimateFilterLength(numEntries int64, numKeys int, size uint64, mapping CodewordMapping) int64 {
+	if numEntries < 0 {
+		numEntries = 0
+	}
+	if mapping == CodewordMappingCuckoo {
+		// Each entry takes its fingerprint and the varint gap to the
+		// previous occupied slot.  The gaps are about geometric, and a
+		// gap of at least 128^k takes k more bytes.
+		capacity := cuckooNumBuckets(size/(CuckooBucketSize*cuckooFingerprintBits)) * CuckooBucketSize
+		entries := float64(numEntries)
+		if maxEntries := float64(capacity) * CuckooMaxLoad; entries > maxEntries {
+			entries = maxEntries
+		}
+		if entries < 1 {
+			return 2 * binary.MaxVarintLen64
+		}
+		gapLen := 1.0
+		for minGap := 128.0; minGap <= float64(capacity); minGap *= 128 {
+			gapLen += math.Pow(1-entries/float64(capacity), minGap)
+		}
+		return 2*binary.MaxVarintLen64 + int64(entries*(gapLen+2))
+	}
+
+	// The sparse bit array stores each block of 64 bits with a bit set,
+	// along with its index, and `numEntries * numKeys` bits are set at random.
+	numBlocks := float64((size + 63) / 64)
+	if numBlocks <= 1 || numEntries == 0 {
+		return 17 + 16*int64(math.Min(numBlocks, float64(numEntries)))
+	}
+	numBits := float64(numEntries) * float64(numKeys)
+	setBlocks := numBlocks * -math.Expm1(numBits*math.Log1p(-1/numBlocks))
+	return 17 + 16*int64(setBlocks)
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"math"
+	"testing"
+)
+
+// TestEstimateIndexSize tests the `EstimateIndexSize` function.  Checks that
+// the estimates are within 5% of the sizes of the marshaled indexes of the
+// bloom and cuckoo filters, for documents filling their filters more or less.
+func TestEstimateIndexSize(t *testing.T) {
+	numKeys := 13
+	size := ComputeFilterSize(10000, numKeys)
+	salts, err := GenerateSalts(numKeys, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	for _, mapping := range []CodewordMapping{DefaultCodewordMapping, CodewordMappingCuckoo} {
+		if err := sib.SetCodewordMapping(mapping); err != nil {
+			t.Fatalf("error when setting the codeword mapping: %s", err)
+		}
+		for _, fileLen := range []int64{100, 2000, 50000} {
+			secIndex, err := sib.BuildDummySecureIndex(fileLen)
+			if err != nil {
+				t.Fatalf("error when building the dummy index: %s", err)
+			}
+			secIndexBytes, err := secIndex.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error when marshaling the index: %s", err)
+			}
+			estimate := EstimateIndexSize(fileLen, numKeys, size, mapping, BlindingPolicyLength, false)
+			if actual := len(secIndexBytes); math.Abs(float64(estimate)-float64(actual)) > 0.05*float64(actual) {
+				t.Fatalf("incorrect estimate for mapping %d and %d bytes: expected %d actual %d", mapping, fileLen, actual, estimate)
+			}
+		}
+	}
+}
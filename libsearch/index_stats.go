@@ -0,0 +1,88 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"math"
+	"sort"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// DesignFillRatio is the fraction of the bits of a bloom filter set when the
+// TLF holds the number of unique words it has been sized for, at which the
+// false positive rate is 2^-k for k keys.  The indexes filled beyond it have a
+// higher false positive rate than requested.
+const DesignFillRatio = 0.5
+
+// NumFillRatioBuckets is the number of buckets of the histograms of the fill
+// ratios, each covering an equal range of ratios.
+const NumFillRatioBuckets = 10
+
+// IndexStats are the statistics of a single marshaled index, computed from the
+// index alone without any key.
+type IndexStats struct {
+	Mapping   CodewordMapping // The mapping of the index, telling the bloom and the cuckoo filters apart.
+	NumBytes  int             // The size in bytes of the marshaled index.
+	FillRatio float64         // The fraction of the bits or slots of the filter in use.
+}
+
+// ComputeIndexStats computes the statistics of the marshaled index
+// `secIndexBytes`.  Returns an error if the index cannot be parsed.
+func ComputeIndexStats(secIndexBytes []byte) (IndexStats, error) {
+	var si SecureIndex
+	if err := si.UnmarshalBinary(secIndexBytes); err != nil {
+		return IndexStats{}, err
+	}
+	stats := IndexStats{Mapping: si.Mapping, NumBytes: len(secIndexBytes)}
+	if si.Mapping == CodewordMappingCuckoo {
+		stats.FillRatio = float64(si.CuckooFilter.Count()) / float64(si.CuckooFilter.Capacity())
+	} else if si.Size > 0 {
+		stats.FillRatio = float64(len(si.BloomFilter.ToNums())) / float64(si.Size)
+	}
+	return stats, nil
+}
+
+// overfilled returns whether the filter of the index is filled beyond what its
+// TLF has been sized for.
+func (s IndexStats) overfilled() bool {
+	if s.Mapping == CodewordMappingCuckoo {
+		return s.FillRatio > CuckooMaxLoad
+	}
+	return s.FillRatio > DesignFillRatio
+}
+
+// AggregateIndexStats aggregates the statistics of the indexes of a TLF, as
+// returned by the search server.
+func AggregateIndexStats(indexStats []IndexStats) sserver1.TlfIndexStats {
+	agg := sserver1.TlfIndexStats{FillRatioHistogram: make([]int, NumFillRatioBuckets)}
+	if len(indexStats) == 0 {
+		return agg
+	}
+	sizes := make([]int, 0, len(indexStats))
+	var sumFillRatio float64
+	for _, stats := range indexStats {
+		sizes = append(sizes, stats.NumBytes)
+		agg.TotalBytes += int64(stats.NumBytes)
+		sumFillRatio += stats.FillRatio
+		agg.MaxFillRatio = math.Max(agg.MaxFillRatio, stats.FillRatio)
+		bucket := int(stats.FillRatio * NumFillRatioBuckets)
+		if bucket >= NumFillRatioBuckets {
+			bucket = NumFillRatioBuckets - 1
+		}
+		agg.FillRatioHistogram[bucket]++
+		if stats.overfilled() {
+			agg.NumOverfilled++
+		}
+	}
+	sort.Ints(sizes)
+	agg.NumIndexes = len(indexStats)
+	agg.MeanFillRatio = sumFillRatio / float64(len(indexStats))
+	agg.MinBytes = int64(sizes[0])
+	agg.MedianBytes = int64(sizes[len(sizes)/2])
+	agg.P90Bytes = int64(sizes[len(sizes)*9/10])
+	agg.MaxBytes = int64(sizes[len(sizes)-1])
+	return agg
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+// TestComputeIndexStats tests the `ComputeIndexStats` function.  Checks that
+// the fill ratios of the bloom and cuckoo filters are computed from the
+// marshaled indexes alone.
+func TestComputeIndexStats(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(100000))
+	fileLen := int64(1000)
+	for _, mapping := range []CodewordMapping{DefaultCodewordMapping, CodewordMappingCuckoo} {
+		if err := sib.SetCodewordMapping(mapping); err != nil {
+			t.Fatalf("error when setting the codeword mapping: %s", err)
+		}
+		secIndex, err := sib.BuildDummySecureIndex(fileLen)
+		if err != nil {
+			t.Fatalf("error when building the dummy index: %s", err)
+		}
+		secIndexBytes, err := secIndex.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error when marshaling the index: %s", err)
+		}
+		stats, err := ComputeIndexStats(secIndexBytes)
+		if err != nil {
+			t.Fatalf("error when computing the index statistics: %s", err)
+		}
+		var expected float64
+		if mapping == CodewordMappingCuckoo {
+			expected = float64(secIndex.CuckooFilter.Count()) / float64(secIndex.CuckooFilter.Capacity())
+		} else {
+			expected = float64(len(secIndex.BloomFilter.ToNums())) / float64(secIndex.Size)
+		}
+		if stats.Mapping != mapping || stats.NumBytes != len(secIndexBytes) || stats.FillRatio != expected {
+			t.Fatalf("incorrect statistics for the mapping %d: %+v, expected fill ratio %f", mapping, stats, expected)
+		}
+	}
+	if _, err := ComputeIndexStats([]byte("garbage")); err == nil {
+		t.Fatalf("no error for an invalid index")
+	}
+}
+
+// TestAggregateIndexStats tests the `AggregateIndexStats` function.  Checks
+// the fill ratios, the histogram, the size distribution and the count of the
+// overfilled indexes.
+func TestAggregateIndexStats(t *testing.T) {
+	stats := AggregateIndexStats([]IndexStats{
+		{Mapping: DefaultCodewordMapping, NumBytes: 300, FillRatio: 0.2},
+		{Mapping: DefaultCodewordMapping, NumBytes: 100, FillRatio: 0.6},
+		{Mapping: CodewordMappingCuckoo, NumBytes: 200, FillRatio: 0.7},
+		{Mapping: CodewordMappingCuckoo, NumBytes: 400, FillRatio: 1},
+	})
+	if stats.NumIndexes != 4 || stats.NumOverfilled != 2 {
+		t.Fatalf("incorrect number of indexes: %+v", stats)
+	}
+	if stats.MeanFillRatio < 0.62 || stats.MeanFillRatio > 0.63 || stats.MaxFillRatio != 1 {
+		t.Fatalf("incorrect fill ratios: %+v", stats)
+	}
+	if expected := []int{0, 0, 1, 0, 0, 0, 1, 1, 0, 1}; !reflect.DeepEqual(expected, stats.FillRatioHistogram) {
+		t.Fatalf("incorrect histogram: expected %v actual %v", expected, stats.FillRatioHistogram)
+	}
+	if stats.TotalBytes != 1000 || stats.MinBytes != 100 || stats.MedianBytes != 300 || stats.P90Bytes != 400 || stats.MaxBytes != 400 {
+		t.Fatalf("incorrect sizes: %+v", stats)
+	}
+	if empty := AggregateIndexStats(nil); empty.NumIndexes != 0 || len(empty.FillRatioHistogram) != NumFillRatioBuckets {
+		t.Fatalf("incorrect statistics of no index: %+v", empty)
+	}
+}
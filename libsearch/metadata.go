@@ -0,0 +1,88 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metadata attributes indexed as synthetic keywords along with the content
+// of a file.
+const (
+	MetadataExtension = "ext"  // The lower case extension of the filename, without the dot.
+	MetadataSize      = "size" // The size bucket of the file, see `SizeBucket`.
+	MetadataYear      = "year" // The year of the last modification of the file.
+	MetadataTag       = "tag"  // A tag attached to the file by its users, indexed apart from its content.
+)
+
+// metadataSeparator separates the attribute from the value in a metadata
+// keyword.  As `NormalizeKeyword` drops it from the words of the documents, a
+// metadata keyword never collides with a word of the content.
+const metadataSeparator = ":"
+
+// sizeBuckets are the upper bounds of the size buckets, in increasing order.
+// Files at least as large as the last bound fall in the "large" bucket.
+var sizeBuckets = []struct {
+	limit int64
+	name  string
+}{
+	{10 << 10, "tiny"},
+	{1 << 20, "small"},
+	{100 << 20, "medium"},
+}
+
+// SizeBucket returns the name of the size bucket of a file of `size` bytes.
+// Only the bucket is indexed, so that the index does not leak more about the
+// size than the obfuscated length already does.
+func SizeBucket(size int64) string {
+	for _, bucket := range sizeBuckets {
+		if size < bucket.limit {
+			return bucket.name
+		}
+	}
+	return "large"
+}
+
+// MetadataKeyword returns the synthetic keyword indexing `value` for the
+// metadata attribute `attr`.
+func MetadataKeyword(attr, value string) string {
+	return attr + metadataSeparator + NormalizeKeyword(value)
+}
+
+// ParseMetadataKeyword checks whether `term` of a query, e.g. "ext:pdf", is a
+// metadata keyword.  If so, returns the keyword in the form it is indexed in.
+func ParseMetadataKeyword(term string) (string, bool) {
+	parts := strings.SplitN(term, metadataSeparator, 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	attr := strings.ToLower(parts[0])
+	switch attr {
+	case MetadataExtension, MetadataSize, MetadataYear, MetadataTag:
+	default:
+		return "", false
+	}
+	value := NormalizeKeyword(parts[1])
+	if value == "" {
+		return "", false
+	}
+	return attr + metadataSeparator + value, true
+}
+
+// ComputeMetadataKeywords returns the metadata keywords of a file with the
+// given `name`, `size` and modification time `modTime`.
+func ComputeMetadataKeywords(name string, size int64, modTime time.Time) []string {
+	keywords := []string{
+		MetadataKeyword(MetadataSize, SizeBucket(size)),
+		MetadataKeyword(MetadataYear, strconv.Itoa(modTime.Year())),
+	}
+	if ext := NormalizeKeyword(filepath.Ext(name)); ext != "" {
+		keywords = append(keywords, MetadataKeyword(MetadataExtension, ext))
+	}
+	return keywords
+}
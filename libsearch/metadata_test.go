@@ -0,0 +1,83 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestComputeMetadataKeywords tests the `ComputeMetadataKeywords` function.
+// Checks that the extension, size bucket and year are turned into keywords,
+// and that files without an extension get no extension keyword.
+func TestComputeMetadataKeywords(t *testing.T) {
+	modTime := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	expected := []string{"size:small", "year:2023", "ext:pdf"}
+	if actual := ComputeMetadataKeywords("Report.PDF", 20<<10, modTime); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect metadata keywords: expected %s actual %s", expected, actual)
+	}
+	expected = []string{"size:large", "year:2023"}
+	if actual := ComputeMetadataKeywords("Makefile", 1<<30, modTime); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("incorrect metadata keywords: expected %s actual %s", expected, actual)
+	}
+}
+
+// TestParseMetadataKeyword tests the `ParseMetadataKeyword` function.  Checks
+// that only the terms with a known attribute and a non-empty value are
+// recognized, and that they are normalized.
+func TestParseMetadataKeyword(t *testing.T) {
+	for term, expected := range map[string]string{"ext:pdf": "ext:pdf", "EXT:.Pdf": "ext:pdf", "year:2023": "year:2023", "size:Tiny": "size:tiny", "Tag:Urgent": "tag:urgent"} {
+		if actual, ok := ParseMetadataKeyword(term); !ok || actual != expected {
+			t.Fatalf("incorrect metadata keyword for %q: expected %q actual %q", term, expected, actual)
+		}
+	}
+	for _, term := range []string{"pdf", "ext:", "author:me", "ext:!!"} {
+		if keyword, ok := ParseMetadataKeyword(term); ok {
+			t.Fatalf("%q parsed as the metadata keyword %q", term, keyword)
+		}
+	}
+}
+
+// TestBuildSecureIndexWithKeywords tests the `BuildSecureIndexWithKeywords`
+// function.  Checks that the metadata keywords are searchable through their
+// trapdoors, and that they do not collide with the same text in the content.
+func TestBuildSecureIndexWithKeywords(t *testing.T) {
+	salts, err := GenerateSalts(13, 16)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	doc, err := ioutil.TempFile("", "metadataTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file: %s", err)
+	}
+	defer os.Remove(doc.Name())
+	if _, err := doc.Write([]byte("quarterly report year:1999")); err != nil {
+		t.Fatalf("cannot write to the temporary test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file: %s", err)
+	}
+
+	bf, words := sib.buildBloomFilter(42, doc, "ext:pdf", "year:2023")
+	if !words["ext:pdf"] || !words["year1999"] {
+		t.Fatalf("incorrect words returned: %v", words)
+	}
+	si := SecureIndex{BloomFilter: bf, Nonce: 42, Size: sib.size, Hash: sha256.New, Mapping: sib.mapping}
+	for _, word := range []string{"report", "ext:pdf", "Ext:PDF", "year:2023", "year1999"} {
+		if !si.ContainsTrapdoors(sib.ComputeTrapdoors(word)) {
+			t.Fatalf("word \"%s\" not found in the index", word)
+		}
+	}
+	for _, word := range []string{"extpdf", "year:1999", "ext:txt"} {
+		if si.ContainsTrapdoors(sib.ComputeTrapdoors(word)) {
+			t.Fatalf("word \"%s\" found in the index", word)
+		}
+	}
+}
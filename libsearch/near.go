@@ -0,0 +1,107 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// NearOperator is the operator of the queries, e.g. "alpha NEAR beta", matching
+// the files where two words appear within the co-occurrence window of each
+// other.
+const NearOperator = "NEAR"
+
+// DefaultNearWindow is the co-occurrence window the matches of the queries are
+// verified against when the client indexes no co-occurrence keyword itself.
+const DefaultNearWindow = 8
+
+// nearAttr is the attribute of the co-occurrence keywords.
+const nearAttr = "near"
+
+// NearKeyword returns the synthetic keyword indexing that the normalized words
+// `a` and `b` appear within the co-occurrence window of each other, in either
+// order.
+func NearKeyword(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return nearAttr + metadataSeparator + a + metadataSeparator + b
+}
+
+// ParseNearKeyword checks whether `term` is a co-occurrence keyword as returned
+// by `NearKeyword`.  If so, returns its two normalized words.
+func ParseNearKeyword(term string) (string, string, bool) {
+	parts := strings.Split(term, metadataSeparator)
+	if len(parts) != 3 || parts[0] != nearAttr || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// ParseNearQuery replaces each `a NEAR b` among the `terms` of a query with the
+// co-occurrence keyword of the normalized `a` and `b`, so that the query only
+// matches the files where they appear close together.  Chained operators such
+// as `a NEAR b NEAR c` require each pair of neighbors to be close together.
+// The other terms are kept as is.
+func ParseNearQuery(terms []string) []string {
+	var res []string
+	for i := 0; i < len(terms); i++ {
+		if i+2 >= len(terms) || terms[i+1] != NearOperator {
+			res = append(res, terms[i])
+			continue
+		}
+		for i+2 < len(terms) && terms[i+1] == NearOperator {
+			a, b := NormalizeKeyword(terms[i]), NormalizeKeyword(terms[i+2])
+			if a != "" && b != "" {
+				res = append(res, NearKeyword(a, b))
+			}
+			i += 2
+		}
+	}
+	return res
+}
+
+// nearWindow tracks the last normalized words of a document, to pair each word
+// with the ones preceding it within the co-occurrence window.
+type nearWindow struct {
+	size  int      // The number of words each word is paired with.
+	words []string // The last `size` words, oldest first.
+}
+
+// add pairs `word` with the words in the window, calling `addPair` with the
+// co-occurrence keyword of each pair of distinct words, then slides the window
+// past `word`.
+func (w *nearWindow) add(word string, addPair func(keyword string)) {
+	if w.size <= 0 || word == "" {
+		return
+	}
+	for _, prev := range w.words {
+		if prev != word {
+			addPair(NearKeyword(prev, word))
+		}
+	}
+	if len(w.words) == w.size {
+		w.words = w.words[1:]
+	}
+	w.words = append(w.words, word)
+}
+
+// ContainsNear returns whether the normalized words `a` and `b` appear within
+// `window` words of each other in `document`.
+func ContainsNear(document io.Reader, a, b string, window int) (bool, error) {
+	keyword := NearKeyword(a, b)
+	found := false
+	w := nearWindow{size: window}
+	scanner := bufio.NewScanner(document)
+	scanner.Split(bufio.ScanWords)
+	for !found && scanner.Scan() {
+		w.add(NormalizeKeyword(scanner.Text()), func(pair string) {
+			found = found || pair == keyword
+		})
+	}
+	return found, scanner.Err()
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestParseNearQuery tests the `ParseNearQuery` function.  Checks that the
+// NEAR operators are replaced with the co-occurrence keywords of their
+// normalized neighbors, including when chained, and that the other terms are
+// kept.
+func TestParseNearQuery(t *testing.T) {
+	for query, expected := range map[string][]string{
+		"alpha NEAR Beta":        {"near:alpha:beta"},
+		"beta NEAR alpha ext:md": {"near:alpha:beta", "ext:md"},
+		"a NEAR b NEAR c":        {"near:a:b", "near:b:c"},
+		"alpha near beta":        {"alpha", "near", "beta"},
+		"alpha NEAR":             {"alpha", "NEAR"},
+	} {
+		if actual := ParseNearQuery(strings.Fields(query)); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("incorrect terms for %q: expected %s actual %s", query, expected, actual)
+		}
+	}
+	if a, b, ok := ParseNearKeyword(NearKeyword("beta", "alpha")); !ok || a != "alpha" || b != "beta" {
+		t.Fatalf("incorrect words of the co-occurrence keyword: %s %s %v", a, b, ok)
+	}
+	if _, _, ok := ParseNearKeyword("ext:md"); ok {
+		t.Fatalf("metadata keyword parsed as a co-occurrence keyword")
+	}
+}
+
+// TestContainsNear tests the `ContainsNear` function.  Checks that two words
+// match within the window in either order, and not beyond it.
+func TestContainsNear(t *testing.T) {
+	document := "alpha one two beta three four five gamma"
+	for _, test := range []struct {
+		a, b     string
+		window   int
+		expected bool
+	}{
+		{"alpha", "beta", 3, true},
+		{"beta", "alpha", 3, true},
+		{"alpha", "beta", 2, false},
+		{"beta", "gamma", 4, true},
+		{"alpha", "gamma", 4, false},
+	} {
+		actual, err := ContainsNear(strings.NewReader(document), test.a, test.b, test.window)
+		if err != nil || actual != test.expected {
+			t.Fatalf("incorrect match of %s NEAR %s within %d: %v, %v", test.a, test.b, test.window, actual, err)
+		}
+	}
+}
+
+// TestBuildSecureIndexNear tests the `BuildSecureIndexWithWords` function with
+// a co-occurrence window.  Checks that the pairs of distinct words within the
+// window are indexed, and none without a window.
+func TestBuildSecureIndexNear(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	document := "alpha beta Alpha gamma"
+	_, words, err := sib.BuildSecureIndexWithWords(strings.NewReader(document), -1)
+	if err != nil || len(words) != 3 {
+		t.Fatalf("incorrect words without a window: %s, %v", words, err)
+	}
+
+	sib.SetNearWindow(1)
+	_, words, err = sib.BuildSecureIndexWithWords(strings.NewReader(document), -1)
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	sort.Strings(words)
+	expected := []string{"alpha", "beta", "gamma", "near:alpha:beta", "near:alpha:gamma"}
+	if !reflect.DeepEqual(expected, words) {
+		t.Fatalf("incorrect words returned: expected %s actual %s", expected, words)
+	}
+	if !reflect.DeepEqual(sib.ComputeTrapdoors("near:alpha:beta"), sib.trapdoorFunc("near:alpha:beta")) {
+		t.Fatalf("co-occurrence keyword normalized by the trapdoors")
+	}
+}
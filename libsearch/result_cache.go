@@ -0,0 +1,58 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// resultCacheKeyDomain separates the key used to encrypt the local cache of
+// the search results from the other keys derived from the master secret.
+const resultCacheKeyDomain = "kbfs_search_result_cache"
+
+// deriveResultCacheKey derives the key used to encrypt the local cache of the
+// search results of a TLF from its `masterSecret`.
+func deriveResultCacheKey(masterSecret []byte) [32]byte {
+	mac := hmac.New(sha256.New, masterSecret)
+	mac.Write([]byte(resultCacheKeyDomain))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// SealResultCache encrypts the serialized cache of the search results of a
+// TLF, `plaintext`, under a key derived from `masterSecret` with a random
+// nonce, so that the results are not stored in the clear on the disk of the
+// client.
+func SealResultCache(plaintext []byte, masterSecret []byte) ([]byte, error) {
+	var nonce [saltsNonceLength]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := deriveResultCacheKey(masterSecret)
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &key), nil
+}
+
+// OpenResultCache decrypts the cache of the search results sealed by
+// `SealResultCache` with `masterSecret`.  Returns an error if the sealed cache
+// is malformed or the master secret is incorrect.
+func OpenResultCache(sealed []byte, masterSecret []byte) ([]byte, error) {
+	if len(sealed) < saltsNonceLength {
+		return nil, errors.New("insufficient sealed result cache length")
+	}
+	var nonce [saltsNonceLength]byte
+	copy(nonce[:], sealed[:saltsNonceLength])
+	key := deriveResultCacheKey(masterSecret)
+	plaintext, ok := secretbox.Open(nil, sealed[saltsNonceLength:], &nonce, &key)
+	if !ok {
+		return nil, errors.New("invalid sealed result cache")
+	}
+	return plaintext, nil
+}
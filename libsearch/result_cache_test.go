@@ -0,0 +1,48 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSealAndOpenResultCache tests the `SealResultCache` and `OpenResultCache`
+// functions.  Checks that the cache survives the round trip, is not stored in
+// the clear, cannot be opened with a different master secret or after
+// tampering, and is not sealed under the key of the salts.
+func TestSealAndOpenResultCache(t *testing.T) {
+	plaintext := []byte(`[{"key":"word:secret","filenames":["/keybase/private/alice/plans"]}]`)
+	masterSecret := bytes.Repeat([]byte{0x42}, 64)
+
+	sealed, err := SealResultCache(plaintext, masterSecret)
+	if err != nil {
+		t.Fatalf("error when sealing the result cache: %s", err)
+	}
+	if bytes.Contains(sealed, []byte("plans")) {
+		t.Fatalf("result cache stored in the clear")
+	}
+	opened, err := OpenResultCache(sealed, masterSecret)
+	if err != nil {
+		t.Fatalf("error when opening the result cache: %s", err)
+	}
+	if !bytes.Equal(plaintext, opened) {
+		t.Fatalf("result cache does not match after the round trip")
+	}
+
+	if _, err := OpenResultCache(sealed, bytes.Repeat([]byte{0x43}, 64)); err == nil {
+		t.Fatalf("result cache opened with the wrong master secret")
+	}
+	if deriveResultCacheKey(masterSecret) == deriveSaltsKey(masterSecret) {
+		t.Fatalf("result cache sealed under the key of the salts")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := OpenResultCache(sealed, masterSecret); err == nil {
+		t.Fatalf("tampered result cache opened")
+	}
+	if _, err := OpenResultCache(sealed[:10], masterSecret); err == nil {
+		t.Fatalf("truncated result cache opened")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// minDummyDocIDLength is the length of the shortest raw document ID, i.e. the
+// one of an empty pathname.
+const minDummyDocIDLength = docIDPrefixLength + secretbox.Overhead + padPrefixLength
+
+// randInt returns a uniformly random int in the range of [0, n).
+func randInt(n int) (int, error) {
+	r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(r.Int64()), nil
+}
+
+// generateDummyDocID generates a random document ID of the key generation
+// `keyGen` that is `length` bytes long before encoding.  Only the owners of
+// the pathname keys can tell it apart from a real one, as it fails to decrypt.
+func generateDummyDocID(keyGen int, length int) (sserver1.DocumentID, error) {
+	versionBuf := new(bytes.Buffer)
+	if err := binary.Write(versionBuf, binary.LittleEndian, int64(keyGen)); err != nil {
+		return "", err
+	}
+	docIDRaw := make([]byte, length)
+	copy(docIDRaw, versionBuf.Bytes())
+	if _, err := rand.Read(docIDRaw[docIDVersionLength:]); err != nil {
+		return "", err
+	}
+	return sserver1.DocumentID(base64.RawURLEncoding.EncodeToString(docIDRaw)), nil
+}
+
+// PadDocIDs pads the search results `docIDs` with dummy document IDs up to the
+// next multiple of `bucketSize`, so that the number of results does not reveal
+// the exact document frequency of the word, and shuffles them.  The dummies
+// take the key generations in `keyGens` and the lengths of the real document
+// IDs, and fail to decrypt on the client.
+func PadDocIDs(docIDs []sserver1.DocumentID, bucketSize int, keyGens []int) ([]sserver1.DocumentID, error) {
+	if bucketSize < 1 {
+		return nil, errors.New("invalid result bucket size")
+	} else if len(keyGens) == 0 {
+		return nil, errors.New("no key generation for the dummy document IDs")
+	}
+
+	numPadded := (len(docIDs) + bucketSize - 1) / bucketSize * bucketSize
+	if numPadded == 0 {
+		numPadded = bucketSize
+	}
+	padded := make([]sserver1.DocumentID, len(docIDs), numPadded)
+	copy(padded, docIDs)
+
+	for len(padded) < numPadded {
+		length := minDummyDocIDLength + 16
+		if len(docIDs) > 0 {
+			i, err := randInt(len(docIDs))
+			if err != nil {
+				return nil, err
+			}
+			docIDRaw, err := base64.RawURLEncoding.DecodeString(docIDs[i].String())
+			if err == nil && len(docIDRaw) >= minDummyDocIDLength {
+				length = len(docIDRaw)
+			}
+		}
+		i, err := randInt(len(keyGens))
+		if err != nil {
+			return nil, err
+		}
+		dummy, err := generateDummyDocID(keyGens[i], length)
+		if err != nil {
+			return nil, err
+		}
+		padded = append(padded, dummy)
+	}
+
+	// Fisher-Yates shuffle, so that the dummies cannot be told apart by their
+	// positions.
+	for i := len(padded) - 1; i > 0; i-- {
+		j, err := randInt(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		padded[i], padded[j] = padded[j], padded[i]
+	}
+	return padded, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"strconv"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// TestPadDocIDs tests the `PadDocIDs` function.  Checks that the results are
+// padded to the bucket size, that the real document IDs are kept, and that the
+// dummies have a known key generation but fail to decrypt.
+func TestPadDocIDs(t *testing.T) {
+	var key PathnameKeyType
+	copy(key[:], "This is a test key that has 32 b")
+	var docIDs []sserver1.DocumentID
+	for i := 0; i < 5; i++ {
+		docID, err := PathnameToDocID(1, "file"+strconv.Itoa(i), key)
+		if err != nil {
+			t.Fatalf("error when computing the document ID: %s", err)
+		}
+		docIDs = append(docIDs, docID)
+	}
+
+	for _, test := range []struct {
+		numReal, bucketSize, expected int
+	}{{5, 4, 8}, {4, 4, 4}, {0, 4, 4}, {5, 1, 5}} {
+		padded, err := PadDocIDs(docIDs[:test.numReal], test.bucketSize, []int{1})
+		if err != nil {
+			t.Fatalf("error when padding the document IDs: %s", err)
+		}
+		if len(padded) != test.expected {
+			t.Fatalf("incorrect number of padded results: expected %d actual %d", test.expected, len(padded))
+		}
+		numReal := 0
+		for _, docID := range padded {
+			if keyGen, err := GetKeyGenFromDocID(docID); err != nil || keyGen != 1 {
+				t.Fatalf("incorrect key generation of a padded result: %d, %v", keyGen, err)
+			}
+			if _, err := DocIDToPathname(docID, []PathnameKeyType{key}); err == nil {
+				numReal++
+			} else if err != ErrInvalidDocID {
+				t.Fatalf("unexpected error for a dummy document ID: %s", err)
+			}
+		}
+		if numReal != test.numReal {
+			t.Fatalf("incorrect number of real results: expected %d actual %d", test.numReal, numReal)
+		}
+	}
+
+	if _, err := PadDocIDs(docIDs, 0, []int{1}); err == nil {
+		t.Fatalf("no error returned for an invalid bucket size")
+	}
+}
@@ -9,55 +9,207 @@ import (
 	"crypto/sha512"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
+	"math"
 
 	"github.com/jxguan/go-datastructures/bitarray"
 )
 
 // SecureIndex defines the elements in a secure index.
 type SecureIndex struct {
-	BloomFilter bitarray.BitArray // The blinded bloom filter, which is the main part of the index.
-	Nonce       uint64
-	Size        uint64           // The number of buckets in the bloom filter.
-	Hash        func() hash.Hash // The hash function to be used for HMAC.
+	BloomFilter  bitarray.BitArray // The blinded bloom filter, which is the main part of the index.  Nil with `CodewordMappingCuckoo`.
+	CuckooFilter *CuckooFilter     // The blinded cuckoo filter replacing the bloom filter with `CodewordMappingCuckoo`.
+	Nonce        uint64
+	Size         uint64                // The number of buckets in the bloom or cuckoo filter.
+	Hash         func() hash.Hash      // The hash function to be used for HMAC.
+	Mapping      CodewordMapping       // The mapping from the trapdoors of the words to the buckets.
+	Blinding     BlindingPolicyVersion // The policy the filter has been blinded with.
+	SizeBucket   bool                  // Whether the marshaled index is padded up to its size bucket, hiding its exact size.
+}
+
+// MinIndexSizeBucket is the smallest size bucket of the marshaled indexes.
+const MinIndexSizeBucket = 1024
+
+// IndexSizeBucket returns the size the marshaled indexes of `length` bytes
+// are padded up to when size-bucketed, i.e. the next power of two, so that the
+// padding at most doubles the size of an index.
+func IndexSizeBucket(length int) int {
+	bucket := MinIndexSizeBucket
+	for bucket < length {
+		bucket *= 2
+	}
+	return bucket
+}
+
+const (
+	// legacyHeaderLen is the length of the header of the indexes built with
+	// the default codeword mapping and blinding policy and not size-bucketed:
+	// the length of the hash, the nonce and the size, which every client and
+	// server can read.
+	legacyHeaderLen = 3 * binary.MaxVarintLen64
+	// explicitHeaderVersion is the version of the header listing all the
+	// parameters of the index.  It is stored negated in place of the length
+	// of the hash of the legacy header, so that the clients and servers
+	// predating it reject the index instead of misreading it.
+	explicitHeaderVersion = 1
+	// explicitHeaderLen is the length of the explicit header: its version,
+	// the length of the hash, the nonce, the size, the codeword mapping, the
+	// blinding policy, whether the index is size-bucketed and the length of
+	// the filter.
+	explicitHeaderLen = 8 * binary.MaxVarintLen64
+)
+
+// IndexHeaderLength returns the length of the header of the marshaled indexes
+// with `mapping`, blinded with the policy of `blinding` and size-bucketed if
+// `sizeBucket` is set.  Only the indexes with the default mapping and blinding
+// policy, not size-bucketed, keep the legacy header.
+func IndexHeaderLength(mapping CodewordMapping, blinding BlindingPolicyVersion, sizeBucket bool) int {
+	if mapping == CodewordMappingUvarint && blinding == BlindingPolicyLength && !sizeBucket {
+		return legacyHeaderLen
+	}
+	return explicitHeaderLen
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
 func (si *SecureIndex) MarshalBinary() ([]byte, error) {
-	bfBytes, err := bitarray.Marshal(si.BloomFilter)
+	var bfBytes []byte
+	var err error
+	if si.Mapping == CodewordMappingCuckoo {
+		bfBytes, err = si.CuckooFilter.MarshalBinary()
+	} else {
+		bfBytes, err = bitarray.Marshal(si.BloomFilter)
+	}
 	if err != nil {
 		return nil, err
 	}
-	length := 3*binary.MaxVarintLen64 + len(bfBytes)
+	headerLen := IndexHeaderLength(si.Mapping, si.Blinding, si.SizeBucket)
+	length := headerLen + len(bfBytes)
+	if si.SizeBucket {
+		length = IndexSizeBucket(length)
+	}
 	result := make([]byte, length)
-	binary.PutVarint(result[0:], int64(si.Hash().Size()))
-	binary.PutUvarint(result[binary.MaxVarintLen64:], si.Nonce)
-	binary.PutUvarint(result[2*binary.MaxVarintLen64:], si.Size)
-	copy(result[3*binary.MaxVarintLen64:], bfBytes)
+	if headerLen == legacyHeaderLen {
+		binary.PutVarint(result[0:], int64(si.Hash().Size()))
+		binary.PutUvarint(result[binary.MaxVarintLen64:], si.Nonce)
+		binary.PutUvarint(result[2*binary.MaxVarintLen64:], si.Size)
+	} else {
+		binary.PutVarint(result[0:], -explicitHeaderVersion)
+		var sizeBucket uint64
+		if si.SizeBucket {
+			sizeBucket = 1
+		}
+		// The length of the filter tells it apart from the padding of
+		// the size-bucketed indexes.
+		fields := []uint64{uint64(si.Hash().Size()), si.Nonce, si.Size, uint64(si.Mapping), uint64(si.Blinding), sizeBucket, uint64(len(bfBytes))}
+		for i, field := range fields {
+			binary.PutUvarint(result[(i+1)*binary.MaxVarintLen64:], field)
+		}
+	}
+	copy(result[headerLen:], bfBytes)
 	return result, nil
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
 func (si *SecureIndex) UnmarshalBinary(input []byte) error {
-	if len(input) < 3*binary.MaxVarintLen64 {
+	if len(input) < legacyHeaderLen {
 		return errors.New("insufficient binary length")
 	}
 	var err error
 	hashLen, err := readInt(input[0:binary.MaxVarintLen64])
 	if err != nil {
 		return err
-	} else if hashLen == 256/8 {
+	}
+	var filter []byte
+	if hashLen != -explicitHeaderVersion {
+		si.Mapping, si.Blinding, si.SizeBucket = CodewordMappingUvarint, BlindingPolicyLength, false
+		si.Nonce, _ = binary.Uvarint(input[binary.MaxVarintLen64 : 2*binary.MaxVarintLen64])
+		si.Size, _ = binary.Uvarint(input[2*binary.MaxVarintLen64 : 3*binary.MaxVarintLen64])
+		filter = input[legacyHeaderLen:]
+	} else {
+		if len(input) < explicitHeaderLen {
+			return errors.New("insufficient binary length")
+		}
+		fields := make([]uint64, explicitHeaderLen/binary.MaxVarintLen64-1)
+		for i := range fields {
+			start := (i + 1) * binary.MaxVarintLen64
+			fields[i], _ = binary.Uvarint(input[start : start+binary.MaxVarintLen64])
+		}
+		if fields[0] > math.MaxInt32 {
+			return errors.New("invalid hash function length")
+		}
+		hashLen = int(fields[0])
+		si.Nonce, si.Size = fields[1], fields[2]
+		si.Mapping = CodewordMapping(fields[3])
+		if fields[3] > math.MaxUint8 {
+			return fmt.Errorf("unknown codeword mapping %d", fields[3])
+		}
+		if err := si.Mapping.Validate(); err != nil {
+			return err
+		}
+		si.Blinding = BlindingPolicyVersion(fields[4])
+		if fields[4] > math.MaxUint8 {
+			return fmt.Errorf("unknown blinding policy %d", fields[4])
+		}
+		if err := si.Blinding.validate(); err != nil {
+			return err
+		}
+		switch fields[5] {
+		case 0:
+			si.SizeBucket = false
+		case 1:
+			si.SizeBucket = true
+		default:
+			return errors.New("invalid size bucket flag")
+		}
+		filter = input[explicitHeaderLen:]
+		if fields[6] > uint64(len(filter)) {
+			return errors.New("invalid filter length")
+		}
+		filter = filter[:fields[6]]
+	}
+	if hashLen == 256/8 {
 		si.Hash = sha256.New
 	} else if hashLen == 512/8 {
 		si.Hash = sha512.New
 	} else {
 		return errors.New("invalid hash function length")
 	}
-	si.Nonce, _ = binary.Uvarint(input[binary.MaxVarintLen64 : 2*binary.MaxVarintLen64])
-	si.Size, _ = binary.Uvarint(input[2*binary.MaxVarintLen64 : 3*binary.MaxVarintLen64])
-	si.BloomFilter, err = bitarray.Unmarshal(input[3*binary.MaxVarintLen64:])
+
+	if si.Mapping == CodewordMappingCuckoo {
+		si.BloomFilter = nil
+		si.CuckooFilter = new(CuckooFilter)
+		if err := si.CuckooFilter.UnmarshalBinary(filter); err != nil {
+			return err
+		}
+		if si.CuckooFilter.NumBuckets() != si.Size {
+			return errors.New("inconsistent number of buckets")
+		}
+		return nil
+	}
+	si.CuckooFilter = nil
+	si.BloomFilter, err = bitarray.Unmarshal(filter)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// ContainsTrapdoors returns true if the word with `trapdoors` is possibly in
+// the document of the index, and false otherwise.  This is the check the
+// search server performs for every index.
+// NOTE: False positives are possible.
+func (si *SecureIndex) ContainsTrapdoors(trapdoors [][]byte) bool {
+	if si.Mapping == CodewordMappingCuckoo {
+		if len(trapdoors) == 0 {
+			return true
+		}
+		return si.CuckooFilter.Contains(cuckooEntry(si.Hash, trapdoors, si.Nonce))
+	}
+	for _, bucket := range si.Mapping.Buckets(si.Hash, trapdoors, si.Nonce, si.Size) {
+		if found, _ := si.BloomFilter.GetBit(bucket); !found {
+			return false
+		}
+	}
+	return true
+}
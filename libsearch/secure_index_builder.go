@@ -10,9 +10,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"hash"
-	"math/big"
-	"os"
+	"io"
 
 	"github.com/jxguan/go-datastructures/bitarray"
 	"golang.org/x/crypto/pbkdf2"
@@ -23,6 +23,13 @@ import (
 // numbers to account for those that are out of range.
 const RandomNumberGenerationFactor = 1.3
 
+// KDFIterations is the number of PBKDF2 iterations used to derive the PRF keys
+// from the master secret and the salts.
+const KDFIterations = 4096
+
+// KDFKeyLength is the length in bytes of each PRF key derived by PBKDF2.
+const KDFKeyLength = 32
+
 // SecureIndexBuilder stores the essential information needed to build the
 // indexes for the documents.
 type SecureIndexBuilder struct {
@@ -30,6 +37,9 @@ type SecureIndexBuilder struct {
 	hash         func() hash.Hash      // The hash function to be used for HMAC.
 	trapdoorFunc func(string) [][]byte // The trapdoor function for the words
 	size         uint64                // The size of each index, i.e. the number of buckets in the bloom filter.  Smaller size will lead to higher false positive rates.
+	mapping      CodewordMapping       // The mapping from the trapdoors of the words to the buckets.
+	blinding     BlindingPolicy        // The policy deciding how many random entries blind each index.
+	nearWindow   int                   // The number of following words each word is indexed as co-occurring with.  No co-occurrence keyword if 0.
 }
 
 // CreateSecureIndexBuilder instantiates a `SecureIndexBuilder`.  Sets up the
@@ -39,10 +49,12 @@ func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][
 	sib := new(SecureIndexBuilder)
 	sib.keys = make([][]byte, len(salts))
 	for index, salt := range salts {
-		sib.keys[index] = pbkdf2.Key(masterSecret, salt, 4096, 32, sha256.New)
+		sib.keys[index] = pbkdf2.Key(masterSecret, salt, KDFIterations, KDFKeyLength, sha256.New)
 	}
 	sib.hash = h
 	sib.size = size
+	sib.mapping = DefaultCodewordMapping
+	sib.blinding = LengthBlinding{}
 	sib.trapdoorFunc = func(word string) [][]byte {
 		trapdoors := make([][]byte, len(salts))
 		for i := 0; i < len(salts); i++ {
@@ -56,31 +68,46 @@ func CreateSecureIndexBuilder(h func() hash.Hash, masterSecret []byte, salts [][
 }
 
 // Builds the bloom filter for the document and returns the result in a sparse
-// bit array and the number of unique words in the document.  The result should
-// not be directly used as the index, as obfuscation need to be added to the
-// bloom filter.
-func (sib *SecureIndexBuilder) buildBloomFilter(nonce uint64, document *os.File) (bitarray.BitArray, int64) {
-	scanner := bufio.NewScanner(document)
-	scanner.Split(bufio.ScanWords)
+// bit array and the set of unique words in the document.  The `keywords` are
+// added to the bloom filter as is, in addition to the normalized words of the
+// document.  The result should not be directly used as the index, as
+// obfuscation need to be added to the bloom filter.
+func (sib *SecureIndexBuilder) buildBloomFilter(nonce uint64, document io.Reader, keywords ...string) (bitarray.BitArray, map[string]bool) {
 	bf := bitarray.NewSparseBitArray()
-	words := make(map[string]bool)
-	for scanner.Scan() {
-		word := scanner.Text()
-		word = NormalizeKeyword(word)
+	words := scanWords(document, keywords, sib.nearWindow, func(word string) {
+		for _, bucket := range sib.mapping.Buckets(sib.hash, sib.trapdoorFunc(word), nonce, sib.size) {
+			bf.SetBit(bucket)
+		}
+	})
+	return bf, words
+}
 
+// scanWords calls `addWord` once for each of the unique normalized words of
+// `document` and of the `keywords`, taken as is, and returns the set of these
+// words.  With a positive `window`, the co-occurrence keywords of the distinct
+// words of `document` within `window` words of each other are added too.
+func scanWords(document io.Reader, keywords []string, window int, addWord func(word string)) map[string]bool {
+	words := make(map[string]bool)
+	add := func(word string) {
 		if words[word] {
-			continue
+			return
 		}
 		words[word] = true
-		trapdoors := sib.trapdoorFunc(word)
-		for _, trapdoor := range trapdoors {
-			mac := hmac.New(sib.hash, trapdoor)
-			mac.Write(big.NewInt(int64(nonce)).Bytes())
-			codeword, _ := binary.Uvarint(mac.Sum(nil))
-			bf.SetBit(codeword % sib.size)
-		}
+		addWord(word)
+	}
+
+	near := nearWindow{size: window}
+	scanner := bufio.NewScanner(document)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		word := NormalizeKeyword(scanner.Text())
+		add(word)
+		near.add(word, add)
+	}
+	for _, keyword := range keywords {
+		add(keyword)
 	}
-	return bf, int64(len(words))
+	return words
 }
 
 // Blinds the bloom filter by setting random bits to be on for `numIterations`
@@ -110,20 +137,168 @@ func (sib *SecureIndexBuilder) blindBloomFilter(bf bitarray.BitArray, numIterati
 	return nil
 }
 
-// BuildSecureIndex builds the index for `document` and an *encrypted* length of
-// `fileLen`.
-func (sib *SecureIndexBuilder) BuildSecureIndex(document *os.File, fileLen int64) (SecureIndex, error) {
+// countingReader is a reader counting the bytes read from the underlying
+// reader.
+type countingReader struct {
+	r io.Reader // The underlying reader.
+	n int64     // The number of bytes read so far.
+}
+
+// Read implements the io.Reader interface.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// length returns `fileLen`, or the number of bytes read so far if `fileLen` is
+// negative.
+func (cr *countingReader) length(fileLen int64) int64 {
+	if fileLen < 0 {
+		return cr.n
+	}
+	return fileLen
+}
+
+// BuildSecureIndex builds the index for the content read from `document` and
+// an *encrypted* length of `fileLen`.  The `document` can be any reader, e.g.
+// an open file, an archive member or some text extracted in memory.  A
+// negative `fileLen` is replaced with the number of bytes read from
+// `document`.
+func (sib *SecureIndexBuilder) BuildSecureIndex(document io.Reader, fileLen int64) (SecureIndex, error) {
+	secIndex, _, err := sib.BuildSecureIndexWithWords(document, fileLen)
+	return secIndex, err
+}
+
+// BuildSecureIndexWithWords is similar to `BuildSecureIndex`, but also returns
+// the normalized unique words in `document`, so that the caller can keep a
+// digest of the word set for later comparisons.
+func (sib *SecureIndexBuilder) BuildSecureIndexWithWords(document io.Reader, fileLen int64) (SecureIndex, []string, error) {
+	return sib.BuildSecureIndexWithKeywords(document, fileLen, nil)
+}
+
+// BuildSecureIndexWithKeywords is similar to `BuildSecureIndexWithWords`, but
+// also indexes the synthetic `keywords`, e.g. the metadata keywords of the
+// document, under the same keys.  The returned words include the keywords.
+func (sib *SecureIndexBuilder) BuildSecureIndexWithKeywords(document io.Reader, fileLen int64, keywords []string) (SecureIndex, []string, error) {
+	nonce, err := RandUint64()
+	if err != nil {
+		return SecureIndex{}, nil, err
+	}
+	counter := &countingReader{r: document}
+	if sib.mapping == CodewordMappingCuckoo {
+		return sib.buildCuckooSecureIndex(nonce, counter, fileLen, keywords)
+	}
+	bf, words := sib.buildBloomFilter(nonce, counter, keywords...)
+	err = sib.blindBloomFilter(bf, sib.blinding.NumEntries(counter.length(fileLen), len(words))*int64(len(sib.keys)))
+	wordList := make([]string, 0, len(words))
+	for word := range words {
+		wordList = append(wordList, word)
+	}
+	return SecureIndex{BloomFilter: bf, Nonce: nonce, Size: sib.size, Hash: sib.hash, Mapping: sib.mapping, Blinding: sib.blinding.Version()}, wordList, err
+}
+
+// BuildDummySecureIndex builds an index that contains no word, but is blinded
+// the same way as the index of a document with an *encrypted* length of
+// `fileLen`, so that the server cannot tell it apart from a real index.  With
+// `PercentageBlinding`, the dummy index only holds the random entries, as if
+// the document had no word.
+func (sib *SecureIndexBuilder) BuildDummySecureIndex(fileLen int64) (SecureIndex, error) {
 	nonce, err := RandUint64()
 	if err != nil {
 		return SecureIndex{}, err
 	}
-	bf, numUniqWords := sib.buildBloomFilter(nonce, document)
-	err = sib.blindBloomFilter(bf, (fileLen-numUniqWords)*int64(len(sib.keys)))
-	return SecureIndex{BloomFilter: bf, Nonce: nonce, Size: sib.size, Hash: sib.hash}, err
+	if sib.mapping == CodewordMappingCuckoo {
+		cf := sib.newCuckooFilter()
+		err = blindCuckooFilter(cf, sib.blinding.NumEntries(fileLen, 0))
+		return SecureIndex{CuckooFilter: cf, Nonce: nonce, Size: cf.NumBuckets(), Hash: sib.hash, Mapping: sib.mapping, Blinding: sib.blinding.Version()}, err
+	}
+	bf := bitarray.NewSparseBitArray()
+	err = sib.blindBloomFilter(bf, sib.blinding.NumEntries(fileLen, 0)*int64(len(sib.keys)))
+	return SecureIndex{BloomFilter: bf, Nonce: nonce, Size: sib.size, Hash: sib.hash, Mapping: sib.mapping, Blinding: sib.blinding.Version()}, err
 }
 
 // ComputeTrapdoors computes the trapdoor values for `word`.  This acts as the
-// public getter for the trapdoorFunc field of SecureIndexBuilder.
+// public getter for the trapdoorFunc field of SecureIndexBuilder.  Metadata
+// keywords such as "ext:pdf" and the co-occurrence keywords are kept in the
+// form they are indexed in.
 func (sib *SecureIndexBuilder) ComputeTrapdoors(word string) [][]byte {
+	if keyword, ok := ParseMetadataKeyword(word); ok {
+		return sib.trapdoorFunc(keyword)
+	}
+	if _, _, ok := ParseNearKeyword(word); ok {
+		return sib.trapdoorFunc(word)
+	}
 	return sib.trapdoorFunc(NormalizeKeyword(word))
 }
+
+// SetCodewordMapping sets the mapping from the MACs of the trapdoors to the
+// buckets the indexes are built with, `DefaultCodewordMapping` by default.
+// Returns an error if `mapping` is unknown.
+func (sib *SecureIndexBuilder) SetCodewordMapping(mapping CodewordMapping) error {
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+	sib.mapping = mapping
+	return nil
+}
+
+// SetBlindingPolicy sets the policy deciding how many random entries blind the
+// indexes built, `LengthBlinding` by default.  Returns an error if the version
+// of `policy` is unknown.
+func (sib *SecureIndexBuilder) SetBlindingPolicy(policy BlindingPolicy) error {
+	if err := policy.Version().validate(); err != nil {
+		return err
+	}
+	sib.blinding = policy
+	return nil
+}
+
+// SetNearWindow sets the number of following words each word of the documents
+// is indexed as co-occurring with, so that the queries built with
+// `ParseNearQuery` match the documents where two words are close together.
+// Each pair of words adds a keyword to the index, which raises its false
+// positive rate.  0, the default, indexes no co-occurrence keyword.
+func (sib *SecureIndexBuilder) SetNearWindow(window int) {
+	sib.nearWindow = window
+}
+
+// BlindingPolicy returns the policy the indexes built are blinded with.
+func (sib *SecureIndexBuilder) BlindingPolicy() BlindingPolicy {
+	return sib.blinding
+}
+
+// NumKeys returns the number of PRFs, i.e. the number of keys derived from the
+// salts, used by the builder.
+func (sib *SecureIndexBuilder) NumKeys() int {
+	return len(sib.keys)
+}
+
+// Size returns the number of buckets in the bloom filters built.
+func (sib *SecureIndexBuilder) Size() uint64 {
+	return sib.size
+}
+
+// HashSize returns the output size in bytes of the hash function used for
+// HMAC.
+func (sib *SecureIndexBuilder) HashSize() int {
+	return sib.hash().Size()
+}
+
+// KDFIterations returns the number of PBKDF2 iterations used to derive the
+// keys.
+func (sib *SecureIndexBuilder) KDFIterations() int {
+	return KDFIterations
+}
+
+// KDFKeyLength returns the length in bytes of the keys derived by PBKDF2.
+func (sib *SecureIndexBuilder) KDFKeyLength() int {
+	return KDFKeyLength
+}
+
+// String implements the fmt.Stringer interface.  Summarizes the non-secret
+// parameters of the builder, so that they can be logged and compared across
+// devices.
+func (sib *SecureIndexBuilder) String() string {
+	return fmt.Sprintf("SecureIndexBuilder{numKeys: %d, size: %d, hash: SHA-%d, kdf: PBKDF2-SHA256 (%d iterations, %d-byte keys)}", sib.NumKeys(), sib.Size(), sib.HashSize()*8, sib.KDFIterations(), sib.KDFKeyLength())
+}
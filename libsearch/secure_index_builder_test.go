@@ -6,12 +6,12 @@ package libsearch
 
 import (
 	"bytes"
-	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/binary"
 	"io/ioutil"
-	"math/big"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/jxguan/go-datastructures/bitarray"
@@ -61,15 +61,36 @@ func TestCreateSecureIndexBuilder(t *testing.T) {
 	}
 }
 
+// Tests the getters and the `String` method of `SecureIndexBuilder`.  Checks
+// that the parameters of the builder are properly reported.
+func TestSecureIndexBuilderParameters(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	if sib.NumKeys() != 13 {
+		t.Fatalf("incorrect number of keys: expected 13 actual %d", sib.NumKeys())
+	}
+	if sib.Size() != 1900000 {
+		t.Fatalf("incorrect size: expected 1900000 actual %d", sib.Size())
+	}
+	if sib.HashSize() != sha256.Size {
+		t.Fatalf("incorrect hash size: expected %d actual %d", sha256.Size, sib.HashSize())
+	}
+	if sib.KDFIterations() != KDFIterations || sib.KDFKeyLength() != KDFKeyLength {
+		t.Fatalf("incorrect KDF parameters")
+	}
+	expected := "SecureIndexBuilder{numKeys: 13, size: 1900000, hash: SHA-256, kdf: PBKDF2-SHA256 (4096 iterations, 32-byte keys)}"
+	if sib.String() != expected {
+		t.Fatalf("incorrect summary: expected \"%s\" actual \"%s\"", expected, sib.String())
+	}
+}
+
 // Helper function that checks if a word is contained in the bloom filter.
 func bfContainsWord(bf bitarray.BitArray, sib *SecureIndexBuilder, nonce uint64, word string) bool {
-	trapdoors := sib.trapdoorFunc(word)
-	for _, trapdoor := range trapdoors {
-		mac := hmac.New(sib.hash, trapdoor)
-		mac.Write(big.NewInt(int64(nonce)).Bytes())
-		// Ignore the error as we need to truncate the 256-bit hash into 64 bits
-		codeword, _ := binary.Uvarint(mac.Sum(nil))
-		if bit, _ := bf.GetBit(codeword % sib.size); !bit {
+	for _, bucket := range sib.mapping.Buckets(sib.hash, sib.trapdoorFunc(word), nonce, sib.size) {
+		if bit, _ := bf.GetBit(bucket); !bit {
 			return false
 		}
 	}
@@ -103,7 +124,7 @@ func TestBuildBloomFilter(t *testing.T) {
 	if _, err := doc.Seek(0, 0); err != nil {
 		t.Errorf("cannot rewind the temporary test file for `TestBuildBloomFilter")
 	}
-	bf1, count := sib.buildBloomFilter(nonce, doc)
+	bf1, words := sib.buildBloomFilter(nonce, doc)
 	// Rewinds the file again
 	if _, err := doc.Seek(0, 0); err != nil {
 		t.Errorf("cannot rewind the temporary test file for `TestBuildBloomFilter")
@@ -120,7 +141,7 @@ func TestBuildBloomFilter(t *testing.T) {
 	if bf1.Equals(bf3) {
 		t.Fatalf("the same document with different ids produces the same bloom filter")
 	}
-	if count != int64(len(docWords)) {
+	if len(words) != len(docWords) {
 		t.Fatalf("the number of unique words is not correct")
 	}
 	for _, word := range docWords {
@@ -207,3 +228,155 @@ func TestBuildSecureIndex(t *testing.T) {
 		}
 	}
 }
+
+// Tests the `BuildSecureIndexWithWords` function.  Checks that the unique
+// normalized words in the document are returned along with the index.
+func TestBuildSecureIndexWithWords(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	doc, err := ioutil.TempFile("", "indexWordsTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file for `TestBuildSecureIndexWithWords`")
+	}
+	defer os.Remove(doc.Name()) // clean up
+	docContent := "This is a TOP-NOTCH test file, this is."
+	if _, err := doc.Write([]byte(docContent)); err != nil {
+		t.Fatalf("cannot write to the temporary test file for `TestBuildSecureIndexWithWords`")
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file for `TestBuildSecureIndexWithWords`")
+	}
+	_, words, err := sib.BuildSecureIndexWithWords(doc, int64(len(docContent)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	sort.Strings(words)
+	expected := []string{"a", "file", "is", "test", "this", "topnotch"}
+	if !reflect.DeepEqual(expected, words) {
+		t.Fatalf("incorrect words returned: expected %s actual %s", expected, words)
+	}
+}
+
+// Tests the `BuildSecureIndexWithWords` function with a document read from
+// memory.  Checks that the words are indexed, and that a negative length is
+// replaced with the number of bytes read, which the cuckoo filters are blinded
+// up to.
+func TestBuildSecureIndexFromReader(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	if err := sib.SetCodewordMapping(CodewordMappingCuckoo); err != nil {
+		t.Fatalf("error when setting the codeword mapping: %s", err)
+	}
+	docContent := "This is a TOP-NOTCH test file, this is."
+	index, words, err := sib.BuildSecureIndexWithWords(strings.NewReader(docContent), -1)
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	sort.Strings(words)
+	expected := []string{"a", "file", "is", "test", "this", "topnotch"}
+	if !reflect.DeepEqual(expected, words) {
+		t.Fatalf("incorrect words returned: expected %s actual %s", expected, words)
+	}
+	if count := index.CuckooFilter.Count(); count != len(docContent) {
+		t.Fatalf("incorrect number of entries: expected %d actual %d", len(docContent), count)
+	}
+	for _, word := range words {
+		if !index.ContainsTrapdoors(sib.ComputeTrapdoors(word)) {
+			t.Fatalf("word %s not found in the index", word)
+		}
+	}
+}
+
+// Tests the `BuildDummySecureIndex` function.  Makes sure that the dummy index
+// has as many bits set as the index of a real document of the same length,
+// and that it is randomized by the nonce.
+func TestBuildDummySecureIndex(t *testing.T) {
+	numKeys := 13
+	lenSalt := 8
+	size := uint64(1900000)
+	salts, err := GenerateSalts(numKeys, lenSalt)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, size)
+	fileLen := int64(1000)
+	index1, err := sib.BuildDummySecureIndex(fileLen)
+	if err != nil {
+		t.Fatalf("error when building the dummy index: %s", err)
+	}
+	index2, err := sib.BuildDummySecureIndex(fileLen)
+	if err != nil {
+		t.Fatalf("error when building the dummy index: %s", err)
+	}
+	if index1.BloomFilter.Equals(index2.BloomFilter) || index1.Nonce == index2.Nonce {
+		t.Fatalf("the two dummy indexes are the same")
+	}
+	if index1.Size != size {
+		t.Fatalf("the size in the index is not set up correctly")
+	}
+	// Allows for the collisions between the random bits.
+	if numBits := len(index1.BloomFilter.ToNums()); numBits < int(fileLen)*numKeys*99/100 || numBits > int(fileLen)*numKeys {
+		t.Fatalf("incorrect number of bits set in the dummy index: %d", numBits)
+	}
+}
+
+// Tests the `UpdateSecureIndex` function.  Checks that the words removed from a
+// cuckoo index are no longer found while the other words and the added ones
+// are, and that bloom indexes cannot be updated.
+func TestUpdateSecureIndex(t *testing.T) {
+	salts, err := GenerateSalts(13, 8)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	doc, err := ioutil.TempFile("", "updateIndexTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file for `TestUpdateSecureIndex`")
+	}
+	defer os.Remove(doc.Name()) // clean up
+	docContent := "This is a TOP-NOTCH test file."
+	if _, err := doc.Write([]byte(docContent)); err != nil {
+		t.Fatalf("cannot write to the temporary test file for `TestUpdateSecureIndex`")
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file for `TestUpdateSecureIndex`")
+	}
+	bloomIndex, err := sib.BuildSecureIndex(doc, int64(len(docContent)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	if err := sib.UpdateSecureIndex(&bloomIndex, []string{"new"}, nil); err == nil {
+		t.Fatalf("no error when updating a bloom index")
+	}
+
+	if err := sib.SetCodewordMapping(CodewordMappingCuckoo); err != nil {
+		t.Fatalf("error when setting the codeword mapping: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file for `TestUpdateSecureIndex`")
+	}
+	index, err := sib.BuildSecureIndex(doc, int64(len(docContent)))
+	if err != nil {
+		t.Fatalf("error when building the secure index: %s", err)
+	}
+	if index.CuckooFilter == nil || index.Size != index.CuckooFilter.NumBuckets() {
+		t.Fatalf("the cuckoo filter of the index is not set up correctly")
+	}
+	if index.CuckooFilter.Count() != len(docContent) {
+		t.Fatalf("the index is not blinded: %d entries", index.CuckooFilter.Count())
+	}
+	if err := sib.UpdateSecureIndex(&index, []string{"updated", "revised"}, []string{"topnotch", "test"}); err != nil {
+		t.Fatalf("error when updating the secure index: %s", err)
+	}
+	for word, expected := range map[string]bool{"this": true, "file": true, "updated": true, "revised": true, "topnotch": false, "test": false} {
+		if found := index.ContainsTrapdoors(sib.ComputeTrapdoors(word)); found != expected {
+			t.Fatalf("incorrect presence of \"%s\" in the updated index: expected %t actual %t", word, expected, found)
+		}
+	}
+}
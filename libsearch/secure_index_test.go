@@ -6,6 +6,10 @@ package libsearch
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/jxguan/go-datastructures/bitarray"
@@ -49,3 +53,117 @@ func TestMarshalAndUnmarshal(t *testing.T) {
 		t.Fatalf("BloomFilter does not mtach")
 	}
 }
+
+// TestContainsTrapdoors tests the `ContainsTrapdoors` function.  Checks that
+// all the words in the document are found in its index, and that words not in
+// the document are not found in an unblinded index.
+func TestContainsTrapdoors(t *testing.T) {
+	salts, err := GenerateSalts(13, 16)
+	if err != nil {
+		t.Fatalf("error in generating the salts")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), salts, uint64(1900000))
+	doc, err := ioutil.TempFile("", "containsTest")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file: %s", err)
+	}
+	defer os.Remove(doc.Name())
+	docContent := "This is a TOP-NOTCH test file."
+	if _, err := doc.Write([]byte(docContent)); err != nil {
+		t.Fatalf("cannot write to the temporary test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file: %s", err)
+	}
+
+	bf, _ := sib.buildBloomFilter(42, doc)
+	si := SecureIndex{BloomFilter: bf, Nonce: 42, Size: sib.size, Hash: sha256.New, Mapping: sib.mapping}
+	for _, word := range []string{"this", "is", "a", "top-notch", "TEST", "file"} {
+		if !si.ContainsTrapdoors(sib.ComputeTrapdoors(word)) {
+			t.Fatalf("word \"%s\" not found in the index", word)
+		}
+	}
+	for _, word := range []string{"missing", "words", "here"} {
+		if si.ContainsTrapdoors(sib.ComputeTrapdoors(word)) {
+			t.Fatalf("word \"%s\" found in the index", word)
+		}
+	}
+}
+
+// TestSizeBucketedIndex tests the `MarshalBinary` and `UnmarshalBinary`
+// functions with size-bucketed indexes.  Checks that the indexes of documents
+// of different lengths are marshaled to the same size bucket, and that the
+// words are still found after a round trip.
+func TestSizeBucketedIndex(t *testing.T) {
+	if IndexSizeBucket(10) != MinIndexSizeBucket || IndexSizeBucket(1024) != 1024 || IndexSizeBucket(1025) != 2048 {
+		t.Fatalf("incorrect size buckets")
+	}
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), [][]byte{[]byte("salt1"), []byte("salt2")}, 1900000)
+	for _, mapping := range []CodewordMapping{DefaultCodewordMapping, CodewordMappingCuckoo} {
+		if err := sib.SetCodewordMapping(mapping); err != nil {
+			t.Fatalf("error when setting the mapping: %s", err)
+		}
+		var lengths []int
+		for _, document := range []string{"short", "a few more words"} {
+			si, err := sib.BuildSecureIndex(strings.NewReader(document), -1)
+			if err != nil {
+				t.Fatalf("error when building the index: %s", err)
+			}
+			si.SizeBucket = true
+			input, err := si.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error when marshaling the index: %s", err)
+			}
+			lengths = append(lengths, len(input))
+			var parsed SecureIndex
+			if err := parsed.UnmarshalBinary(input); err != nil || !parsed.SizeBucket {
+				t.Fatalf("incorrect size-bucketed index unmarshaled: %v", err)
+			}
+			if document == "short" && !parsed.ContainsTrapdoors(sib.ComputeTrapdoors("short")) {
+				t.Fatalf("word not found in the unmarshaled index")
+			}
+			binary.PutUvarint(input[7*binary.MaxVarintLen64:], uint64(len(input)))
+			if err := parsed.UnmarshalBinary(input); err == nil {
+				t.Fatalf("no error when unmarshaling an invalid filter length")
+			}
+		}
+		if lengths[0] != lengths[1] || lengths[0] != MinIndexSizeBucket {
+			t.Fatalf("indexes not padded to the same size bucket with mapping %d: %v", mapping, lengths)
+		}
+	}
+}
+
+// TestIndexHeader tests the headers of the marshaled indexes.  Checks that the
+// indexes with the default mapping and blinding policy keep the legacy header,
+// and that the other ones list their mapping in the explicit header, which
+// the parsers predating it reject as an invalid hash length.
+func TestIndexHeader(t *testing.T) {
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), [][]byte{[]byte("salt1"), []byte("salt2")}, 1900000)
+	for _, mapping := range []CodewordMapping{DefaultCodewordMapping, CodewordMappingDoubleHashing} {
+		if err := sib.SetCodewordMapping(mapping); err != nil {
+			t.Fatalf("error when setting the mapping: %s", err)
+		}
+		si, err := sib.BuildSecureIndex(strings.NewReader("header"), -1)
+		if err != nil {
+			t.Fatalf("error when building the index: %s", err)
+		}
+		input, err := si.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error when marshaling the index: %s", err)
+		}
+		first, _ := binary.Varint(input)
+		if mapping == DefaultCodewordMapping {
+			if first != int64(sha256.Size) {
+				t.Fatalf("legacy header not kept for the default mapping: %d", first)
+			}
+		} else if first != -explicitHeaderVersion {
+			t.Fatalf("explicit header not used for the mapping %d: %d", mapping, first)
+		} else if field, _ := binary.Uvarint(input[4*binary.MaxVarintLen64:]); CodewordMapping(field) != mapping {
+			t.Fatalf("incorrect mapping in the explicit header: %d", field)
+		}
+		var parsed SecureIndex
+		if err := parsed.UnmarshalBinary(input); err != nil || parsed.Mapping != mapping || !parsed.ContainsTrapdoors(sib.ComputeTrapdoors("header")) {
+			t.Fatalf("incorrect index unmarshaled with the mapping %d: %v", mapping, err)
+		}
+	}
+}
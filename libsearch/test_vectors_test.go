@@ -0,0 +1,173 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sserver1 "github.com/keybase/search/protocol/sserver"
+)
+
+// updateTestVectors regenerates the golden files under `testdata` instead of
+// checking against them.  Should only be used when the index format changes
+// on purpose, along with a new format version.
+var updateTestVectors = flag.Bool("update", false, "regenerate the golden test vectors under testdata")
+
+// The fixed parameters the test vectors are generated from.
+const (
+	testVectorMasterSecret = "kbfs search test vector master secret, 64 bytes long............"
+	testVectorNonce        = uint64(42)
+	testVectorSize         = uint64(1900000)
+	testVectorDocument     = "The quick brown fox jumps over the lazy dog."
+	testVectorPathname     = "path/to/the/test/vector"
+	testVectorWord         = "fox"
+)
+
+// testVectorSalts returns the fixed salts the test vectors are generated from.
+func testVectorSalts() [][]byte {
+	salts := make([][]byte, 13)
+	for i := range salts {
+		salts[i] = bytes.Repeat([]byte{byte(i)}, MinLenSalt)
+	}
+	return salts
+}
+
+// testVectorPathnameKey returns the fixed pathname key the test vectors are
+// generated from.
+func testVectorPathnameKey() PathnameKeyType {
+	var key PathnameKeyType
+	copy(key[:], testVectorMasterSecret[0:32])
+	return key
+}
+
+// checkTestVector compares `actual` with the golden file `name` under
+// `testdata`, or overwrites the golden file if `-update` is set.
+func checkTestVector(t *testing.T, name string, actual []byte) []byte {
+	golden := filepath.Join("testdata", name)
+	if *updateTestVectors {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("error when writing the golden file %s: %s", golden, err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error when reading the golden file %s: %s", golden, err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("output does not match the golden file %s; the format has changed in an incompatible way", golden)
+	}
+	return expected
+}
+
+// TestVectorSecureIndex checks that a SecureIndex built from fixed parameters
+// serializes to the golden bytes for each codeword mapping, and that the golden
+// bytes can still be parsed and searched.
+func TestVectorSecureIndex(t *testing.T) {
+	goldens := map[CodewordMapping]string{
+		CodewordMappingUvarint:       "secure_index.golden",
+		CodewordMappingFixed64:       "secure_index_fixed64.golden",
+		CodewordMappingDoubleHashing: "secure_index_double_hashing.golden",
+		CodewordMappingCuckoo:        "secure_index_cuckoo.golden",
+	}
+	for mapping, name := range goldens {
+		checkSecureIndexVector(t, mapping, name)
+	}
+}
+
+// checkSecureIndexVector checks the SecureIndex built from fixed parameters with
+// `mapping` against the golden file `name`.
+func checkSecureIndexVector(t *testing.T, mapping CodewordMapping, name string) {
+	sib := CreateSecureIndexBuilder(sha256.New, []byte(testVectorMasterSecret), testVectorSalts(), testVectorSize)
+	if err := sib.SetCodewordMapping(mapping); err != nil {
+		t.Fatalf("error when setting the codeword mapping: %s", err)
+	}
+
+	doc, err := ioutil.TempFile("", "testVector")
+	if err != nil {
+		t.Fatalf("cannot create the temporary test file: %s", err)
+	}
+	defer os.Remove(doc.Name())
+	if _, err := doc.Write([]byte(testVectorDocument)); err != nil {
+		t.Fatalf("cannot write to the temporary test file: %s", err)
+	}
+	if _, err := doc.Seek(0, 0); err != nil {
+		t.Fatalf("cannot rewind the temporary test file: %s", err)
+	}
+
+	// The index is not blinded, as blinding is randomized.
+	secIndex := SecureIndex{Nonce: testVectorNonce, Size: testVectorSize, Hash: sha256.New, Mapping: mapping}
+	if mapping == CodewordMappingCuckoo {
+		if secIndex.CuckooFilter, _, err = sib.buildCuckooFilter(testVectorNonce, doc); err != nil {
+			t.Fatalf("error when building the cuckoo filter: %s", err)
+		}
+		secIndex.Size = secIndex.CuckooFilter.NumBuckets()
+	} else {
+		secIndex.BloomFilter, _ = sib.buildBloomFilter(testVectorNonce, doc)
+	}
+	secIndexBytes, err := secIndex.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the index: %s", err)
+	}
+
+	golden := checkTestVector(t, name, secIndexBytes)
+
+	var parsed SecureIndex
+	if err := parsed.UnmarshalBinary(golden); err != nil {
+		t.Fatalf("error when unmarshaling the golden index: %s", err)
+	}
+	if parsed.Nonce != testVectorNonce || parsed.Size != secIndex.Size || parsed.Hash().Size() != sha256.Size || parsed.Mapping != mapping {
+		t.Fatalf("incorrect parameters parsed from the golden index")
+	}
+	for _, word := range strings.Fields(testVectorDocument) {
+		if !parsed.ContainsTrapdoors(sib.ComputeTrapdoors(NormalizeKeyword(word))) {
+			t.Fatalf("word \"%s\" not found in the golden index", word)
+		}
+	}
+}
+
+// TestVectorDocID checks that the document ID of a fixed pathname matches the
+// golden one, and that the golden document ID can still be decrypted.
+func TestVectorDocID(t *testing.T) {
+	docID, err := PathnameToDocID(1, testVectorPathname, testVectorPathnameKey())
+	if err != nil {
+		t.Fatalf("error when encrypting the pathname: %s", err)
+	}
+
+	golden := checkTestVector(t, "doc_id.golden", []byte(docID))
+
+	pathname, err := DocIDToPathname(sserver1.DocumentID(golden), []PathnameKeyType{testVectorPathnameKey()})
+	if err != nil {
+		t.Fatalf("error when decrypting the golden document ID: %s", err)
+	}
+	if pathname != testVectorPathname {
+		t.Fatalf("incorrect pathname decrypted from the golden document ID: %s", pathname)
+	}
+	keyGen, err := GetKeyGenFromDocID(sserver1.DocumentID(golden))
+	if err != nil || keyGen != 1 {
+		t.Fatalf("incorrect key generation extracted from the golden document ID: %d, %v", keyGen, err)
+	}
+}
+
+// TestVectorTrapdoors checks that the trapdoors of a fixed word match the
+// golden ones.
+func TestVectorTrapdoors(t *testing.T) {
+	sib := CreateSecureIndexBuilder(sha256.New, []byte(testVectorMasterSecret), testVectorSalts(), testVectorSize)
+
+	var buf bytes.Buffer
+	for _, trapdoor := range sib.ComputeTrapdoors(testVectorWord) {
+		buf.WriteString(hex.EncodeToString(trapdoor))
+		buf.WriteString("\n")
+	}
+
+	checkTestVector(t, "trapdoors.golden", buf.Bytes())
+}
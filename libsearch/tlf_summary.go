@@ -0,0 +1,101 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"errors"
+
+	"github.com/jxguan/go-datastructures/bitarray"
+)
+
+// summaryNonce is the nonce the buckets of the words in a TLF summary are
+// computed with.  Unlike the indexes, the summary is shared by all the
+// documents of the TLF, so the nonce is fixed.
+const summaryNonce = 0
+
+// TlfSummary is a bloom filter merging the words of all the documents of a
+// TLF, which the clients can evaluate locally to skip the search requests for
+// the words in none of the documents.  The summary is not blinded: the search
+// server learns which documents share the buckets of a word, although not the
+// word itself.
+type TlfSummary struct {
+	filter bitarray.BitArray
+}
+
+// NewTlfSummary returns an empty `TlfSummary`.
+func NewTlfSummary() *TlfSummary {
+	return &TlfSummary{filter: bitarray.NewSparseBitArray()}
+}
+
+// AddBuckets sets the `buckets` in the summary.
+func (s *TlfSummary) AddBuckets(buckets []uint64) {
+	for _, bucket := range buckets {
+		s.filter.SetBit(bucket)
+	}
+}
+
+// Merge adds the buckets set in `other` to the summary.
+func (s *TlfSummary) Merge(other *TlfSummary) {
+	s.filter = s.filter.Or(other.filter)
+}
+
+// ContainsBuckets returns true if all the `buckets` are set in the summary,
+// i.e. if the word with these buckets is possibly in one of the documents.
+func (s *TlfSummary) ContainsBuckets(buckets []uint64) bool {
+	for _, bucket := range buckets {
+		if found, _ := s.filter.GetBit(bucket); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (s *TlfSummary) MarshalBinary() ([]byte, error) {
+	return bitarray.Marshal(s.filter)
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (s *TlfSummary) UnmarshalBinary(input []byte) error {
+	if len(input) == 0 {
+		return errors.New("empty summary")
+	}
+	filter, err := bitarray.Unmarshal(input)
+	if err != nil {
+		return err
+	}
+	s.filter = filter
+	return nil
+}
+
+// SummaryBuckets returns the buckets `word` sets in the summaries of the TLF.
+func (sib *SecureIndexBuilder) SummaryBuckets(word string) []uint64 {
+	return CodewordMappingDoubleHashing.Buckets(sib.hash, sib.ComputeTrapdoors(word), summaryNonce, sib.size)
+}
+
+// BuildSummary builds the contribution of the document with `words` to the
+// summary of the TLF.
+func (sib *SecureIndexBuilder) BuildSummary(words []string) *TlfSummary {
+	summary := NewTlfSummary()
+	for _, word := range words {
+		summary.AddBuckets(sib.SummaryBuckets(word))
+	}
+	return summary
+}
+
+// BuildDummySummary builds a contribution of `numWords` random words to the
+// summary of the TLF, so that the dummy indexes padding the number of
+// documents contribute to the summary like real ones.
+func (sib *SecureIndexBuilder) BuildDummySummary(numWords int) (*TlfSummary, error) {
+	summary := NewTlfSummary()
+	for i := 0; i < numWords*len(sib.keys); i++ {
+		bucket, err := RandUint64n(sib.size)
+		if err != nil {
+			return nil, err
+		}
+		summary.filter.SetBit(bucket)
+	}
+	return summary, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestTlfSummary tests the `BuildSummary` and `Merge` functions.  Checks that
+// the merged summary contains the words of all the documents and none of the
+// other words, including through `MarshalBinary` and `UnmarshalBinary`.
+func TestTlfSummary(t *testing.T) {
+	sib := CreateSecureIndexBuilder(sha256.New, []byte("test"), [][]byte{[]byte("salt1"), []byte("salt2")}, 100000)
+	summary := NewTlfSummary()
+	summary.Merge(sib.BuildSummary([]string{"apple", "banana"}))
+	summary.Merge(sib.BuildSummary([]string{"cherry", "ext:pdf"}))
+
+	input, err := summary.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error when marshaling the summary: %s", err)
+	}
+	var parsed TlfSummary
+	if err := parsed.UnmarshalBinary(input); err != nil {
+		t.Fatalf("error when unmarshaling the summary: %s", err)
+	}
+	for _, word := range []string{"apple", "Banana", "cherry", "ext:pdf"} {
+		if !parsed.ContainsBuckets(sib.SummaryBuckets(word)) {
+			t.Fatalf("word %q not found in the summary", word)
+		}
+	}
+	for _, word := range []string{"durian", "ext:txt"} {
+		if parsed.ContainsBuckets(sib.SummaryBuckets(word)) {
+			t.Fatalf("word %q found in the summary", word)
+		}
+	}
+
+	dummy, err := sib.BuildDummySummary(10)
+	if err != nil {
+		t.Fatalf("error when building the dummy summary: %s", err)
+	}
+	if count := len(dummy.filter.ToNums()); count == 0 || count > 20 {
+		t.Fatalf("incorrect number of buckets in the dummy summary: %d", count)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"bufio"
+	"io"
+)
+
+// TokenStats are the statistics of the tokens of a document, as the indexes see
+// them: the words of the document normalized with `NormalizeKeyword`.  They
+// are computed locally, and never leave the client.
+type TokenStats struct {
+	NumTokens int            // The number of tokens of the document, repeated ones included.
+	Counts    map[string]int // The number of occurrences of each normalized word.
+}
+
+// ComputeTokenStats computes the statistics of the tokens read from `document`,
+// split the same way as for building its index.  The tokens without any
+// letter or digit are not counted.
+func ComputeTokenStats(document io.Reader) (TokenStats, error) {
+	stats := TokenStats{Counts: make(map[string]int)}
+	scanner := bufio.NewScanner(document)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		word := NormalizeKeyword(scanner.Text())
+		if word == "" {
+			continue
+		}
+		stats.NumTokens++
+		stats.Counts[word]++
+	}
+	return stats, scanner.Err()
+}
@@ -0,0 +1,25 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestComputeTokenStats tests the `ComputeTokenStats` function.  Checks that
+// the words are normalized as for the indexes and counted, and that the tokens
+// without any letter or digit are ignored.
+func TestComputeTokenStats(t *testing.T) {
+	stats, err := ComputeTokenStats(strings.NewReader("Card: 4111-1111-1111-1111, card -- CARD again"))
+	if err != nil {
+		t.Fatalf("error when computing the token statistics: %s", err)
+	}
+	expected := map[string]int{"card": 3, "4111111111111111": 1, "again": 1}
+	if stats.NumTokens != 5 || !reflect.DeepEqual(expected, stats.Counts) {
+		t.Fatalf("incorrect token statistics: %+v", stats)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
 	"io/ioutil"
 	"math"
 	"math/big"
@@ -23,6 +25,42 @@ import (
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
+// MinLenSalt is the minimum length in bytes of the salts used to derive the
+// PRF keys.  Shorter salts are rejected at TLF registration time.
+const MinLenSalt = 16
+
+// ShortSaltError is returned when a salt is shorter than `MinLenSalt`.
+type ShortSaltError struct {
+	LenSalt int // The length in bytes of the salt.
+}
+
+// Error implements the error interface.
+func (e ShortSaltError) Error() string {
+	return fmt.Sprintf("salt length of %d bytes is shorter than the minimum of %d bytes", e.LenSalt, MinLenSalt)
+}
+
+// ValidateLenSalt returns a `ShortSaltError` if `lenSalt` is shorter than
+// `MinLenSalt`.  Should be checked both by the clients before registering a
+// TLF and on the salts of the TLF, and by the server before generating the
+// salts.
+func ValidateLenSalt(lenSalt int) error {
+	if lenSalt < MinLenSalt {
+		return ShortSaltError{LenSalt: lenSalt}
+	}
+	return nil
+}
+
+// MinLenMS is the minimum length in bytes of a master secret.  The first 32
+// bytes of the master secret are used as the pathname key, and the whole of it
+// is used to derive the PRF keys.
+const MinLenMS = 32
+
+// ComputeNumKeys returns the number of PRF keys, and therefore of salts,
+// needed for the indexes to have a false positive rate of `fpRate`.
+func ComputeNumKeys(fpRate float64) int {
+	return int(math.Ceil(-math.Log2(fpRate)))
+}
+
 // GenerateSalts generates `numKeys` salts with length `lenSalt`.  Returns an
 // error if the salts cannot be properly generated.
 func GenerateSalts(numKeys, lenSalt int) (salts [][]byte, err error) {
@@ -159,6 +197,44 @@ func NormalizeKeyword(keyword string) string {
 	return string(normalizedKeyword)
 }
 
+// ComputeTlfFingerprint computes a fingerprint of the public parameters of a
+// TLF: the `salts`, the `size` of the bloom filters and the KDF parameters.
+// The fingerprint does not depend on any secret, so it can be computed and
+// stored by the server and verified by every client, catching devices that
+// would otherwise build incompatible indexes for the same TLF.
+func ComputeTlfFingerprint(salts [][]byte, size uint64) []byte {
+	h := newTlfFingerprintHash("kbfs_search_tlf_fingerprint", size)
+	binary.Write(h, binary.LittleEndian, uint64(len(salts)))
+	for _, salt := range salts {
+		binary.Write(h, binary.LittleEndian, uint64(len(salt)))
+		h.Write(salt)
+	}
+	return h.Sum(nil)
+}
+
+// ComputeSealedTlfFingerprint computes the fingerprint of a TLF whose salts are
+// sealed by `SealSalts`, over the `sealed` salts instead of the salts, along
+// with the `size` of the bloom filters and the KDF parameters.  The server only
+// relaying the sealed salts can thus compute it as well.
+func ComputeSealedTlfFingerprint(sealed []byte, size uint64) []byte {
+	h := newTlfFingerprintHash("kbfs_search_sealed_tlf_fingerprint", size)
+	binary.Write(h, binary.LittleEndian, uint64(len(sealed)))
+	h.Write(sealed)
+	return h.Sum(nil)
+}
+
+// newTlfFingerprintHash returns the hash of a TLF fingerprint of the kind
+// `domain`, with the KDF parameters and the `size` of the bloom filters
+// written.
+func newTlfFingerprintHash(domain string, size uint64) hash.Hash {
+	h := sha256.New()
+	h.Write([]byte(domain))
+	binary.Write(h, binary.LittleEndian, uint64(KDFIterations))
+	binary.Write(h, binary.LittleEndian, uint64(KDFKeyLength))
+	binary.Write(h, binary.LittleEndian, size)
+	return h
+}
+
 // PathnameKeyType is the type of key used to encrypt the pathnames into
 // document IDs, and vice versa.
 type PathnameKeyType [32]byte
@@ -169,6 +245,12 @@ const docIDVersionLength = 8
 const docIDNonceLength = 24
 const docIDPrefixLength = docIDVersionLength + docIDNonceLength
 
+// DocIDScheme is the version of the way `PathnameToDocID` turns the pathnames
+// into document IDs.  It must change along with it, as the indexes written
+// under the document IDs of another scheme can no longer be found by their
+// pathnames, be it to overwrite or to delete them.
+const DocIDScheme = 1
+
 // PathnameToDocID encrypts a `pathname` to a document ID using `key`.
 // NOTE: Instead of using random nonce and padding, we need to use deterministic
 // ones, because we want the encryptions of the same pathname to always yield the
@@ -198,28 +280,56 @@ func PathnameToDocID(keyGen libkbfs.KeyGen, pathname string, key PathnameKeyType
 	return sserver1.DocumentID(base64.RawURLEncoding.EncodeToString(docIDRaw)), nil
 }
 
+// UnknownKeyGenError is returned when a document ID has been encrypted with a
+// key generation that the caller has no key for.  This usually means that a
+// rekey has happened and the caller should refresh its keys.
+type UnknownKeyGenError struct {
+	KeyGen int // The key generation found in the document ID.
+}
+
+// Error implements the error interface.
+func (e UnknownKeyGenError) Error() string {
+	return fmt.Sprintf("unknown key generation %d", e.KeyGen)
+}
+
+// ErrInvalidDocID is returned when a document ID cannot be decrypted with the
+// key of its key generation, e.g. because it is a dummy one padding a list of
+// search results.
+var ErrInvalidDocID = errors.New("invalid document ID")
+
 // DocIDToPathname decrypts a `docID` to get the actual pathname by using the
-// `keys`.
+// `keys`, where `keys[i]` is the key for the key generation
+// `i + FirstValidKeyGen`.  Returns an `UnknownKeyGenError` if the key
+// generation of `docID` is out of the range of `keys`.
 func DocIDToPathname(docID sserver1.DocumentID, keys []PathnameKeyType) (string, error) {
 	docIDRaw, err := base64.RawURLEncoding.DecodeString(docID.String())
 	if err != nil {
 		return "", err
 	}
+	if len(docIDRaw) < docIDPrefixLength+secretbox.Overhead {
+		return "", errors.New("insufficient document ID length")
+	}
 
 	var keyGen int64
 	versionBuf := bytes.NewBuffer(docIDRaw[0:docIDVersionLength])
 	if err := binary.Read(versionBuf, binary.LittleEndian, &keyGen); err != nil {
 		return "", err
 	}
-	key := keys[keyGen-libkbfs.FirstValidKeyGen]
-	keyBytes := [32]byte(key)
+	keyIndex := keyGen - libkbfs.FirstValidKeyGen
+	if libkbfs.KeyGen(keyGen) == libkbfs.PublicKeyGen {
+		keyIndex = 0
+	}
+	if keyIndex < 0 || keyIndex >= int64(len(keys)) {
+		return "", UnknownKeyGenError{KeyGen: int(keyGen)}
+	}
+	keyBytes := [32]byte(keys[keyIndex])
 
 	var nonce [docIDNonceLength]byte
 	copy(nonce[:], docIDRaw[docIDVersionLength:docIDPrefixLength])
 
 	pathnameRaw, ok := secretbox.Open(nil, docIDRaw[docIDPrefixLength:], &nonce, &keyBytes)
 	if !ok {
-		return "", errors.New("invalid document ID")
+		return "", ErrInvalidDocID
 	}
 
 	return depadPathname(pathnameRaw)
@@ -234,6 +344,9 @@ func GetKeyGenFromDocID(docID sserver1.DocumentID) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if len(docIDRaw) < docIDVersionLength {
+		return 0, errors.New("insufficient document ID length")
+	}
 
 	var keyGen int64
 	versionBuf := bytes.NewBuffer(docIDRaw[0:docIDVersionLength])
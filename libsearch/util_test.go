@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/keybase/kbfs/libkbfs"
+	sserver1 "github.com/keybase/search/protocol/sserver"
 )
 
 // Tests `GenerateSalts`.  Makes sure that salts are properly generated.
@@ -30,6 +31,21 @@ func TestGenerateSalts(t *testing.T) {
 	}
 }
 
+// TestValidateLenSalt tests the `ValidateLenSalt` function.  Checks that salts
+// shorter than `MinLenSalt` are rejected.
+func TestValidateLenSalt(t *testing.T) {
+	for _, lenSalt := range []int{-1, 0, 8, MinLenSalt - 1} {
+		if err := ValidateLenSalt(lenSalt); err == nil {
+			t.Fatalf("salt length %d accepted", lenSalt)
+		}
+	}
+	for _, lenSalt := range []int{MinLenSalt, 32, 64} {
+		if err := ValidateLenSalt(lenSalt); err != nil {
+			t.Fatalf("salt length %d rejected: %s", lenSalt, err)
+		}
+	}
+}
+
 // Checks that random numbers generated are within the range of [0, n).
 func checkRandUint64nForNum(n uint64, t *testing.T) {
 	for i := 0; i < 10000; i++ {
@@ -242,6 +258,80 @@ func TestGetKeyGenFromDocID(t *testing.T) {
 	}
 }
 
+// TestMalformedDocID tests the `DocIDToPathname` and the `GetKeyGenFromDocID`
+// functions with truncated and malformed document IDs.  Checks that errors are
+// returned instead of panics.
+func TestMalformedDocID(t *testing.T) {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	if err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+
+	docID, err := PathnameToDocID(1, "path/to/a/test/file", key)
+	if err != nil {
+		t.Fatalf("error when encrypting the pathname: %s", err)
+	}
+
+	malformedDocIDs := []sserver1.DocumentID{
+		"",
+		"AQ",
+		docID[:8],
+		docID[:len(docID)/2],
+		docID[:len(docID)-1],
+		"!!!not-base64!!!",
+	}
+	for _, malformed := range malformedDocIDs {
+		if _, err := DocIDToPathname(malformed, []PathnameKeyType{key}); err == nil {
+			t.Fatalf("no error returned when decrypting malformed document ID \"%s\"", malformed)
+		}
+	}
+
+	for _, malformed := range []sserver1.DocumentID{"", "AQ", "!!!not-base64!!!"} {
+		if _, err := GetKeyGenFromDocID(malformed); err == nil {
+			t.Fatalf("no error returned when extracting the key generation from malformed document ID \"%s\"", malformed)
+		}
+	}
+	if _, err := GetKeyGenFromDocID(docID[:11]); err != nil {
+		t.Fatalf("error returned when extracting the key generation from a document ID prefix: %s", err)
+	}
+}
+
+// TestDocIDUnknownKeyGen tests the `DocIDToPathname` function with document IDs
+// of key generations out of the range of the keys provided.  Checks that an
+// `UnknownKeyGenError` is returned, and that public key generations use the
+// only key.
+func TestDocIDUnknownKeyGen(t *testing.T) {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	if err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+
+	for _, keyGen := range []libkbfs.KeyGen{0, 2, 42, -2} {
+		docID, err := PathnameToDocID(keyGen, "path/to/a/test/file", key)
+		if err != nil {
+			t.Fatalf("error when encrypting the pathname: %s", err)
+		}
+		_, err = DocIDToPathname(docID, []PathnameKeyType{key})
+		if keyGenErr, ok := err.(UnknownKeyGenError); !ok || keyGenErr.KeyGen != int(keyGen) {
+			t.Fatalf("incorrect error for key generation %d: %v", keyGen, err)
+		}
+	}
+
+	docID, err := PathnameToDocID(libkbfs.PublicKeyGen, "path/to/a/public/file", key)
+	if err != nil {
+		t.Fatalf("error when encrypting the pathname: %s", err)
+	}
+	pathname, err := DocIDToPathname(docID, []PathnameKeyType{key})
+	if err != nil {
+		t.Fatalf("error when decrypting the pathname of a public TLF: %s", err)
+	}
+	if pathname != "path/to/a/public/file" {
+		t.Fatalf("incorrect pathname for a public TLF: %s", pathname)
+	}
+}
+
 // testNextPowerOfTwoHelper checks that `nextPowerOfTwo(n) == expected`.
 func testNextPowerOfTwoHelper(t *testing.T, n uint32, expected uint32) {
 	actual := nextPowerOfTwo(n)
@@ -279,3 +369,72 @@ func TestPadding(t *testing.T) {
 		t.Fatalf("incorrect pathname after padding and depadding")
 	}
 }
+
+// TestComputeTlfFingerprint tests the `ComputeTlfFingerprint` function.  Checks
+// that the fingerprint is deterministic and changes with the salts and size.
+func TestComputeTlfFingerprint(t *testing.T) {
+	salts, err := GenerateSalts(10, 16)
+	if err != nil {
+		t.Fatalf("error when generating salts: %s", err)
+	}
+	otherSalts, err := GenerateSalts(10, 16)
+	if err != nil {
+		t.Fatalf("error when generating salts: %s", err)
+	}
+
+	fingerprint := ComputeTlfFingerprint(salts, 100000)
+	if !bytes.Equal(fingerprint, ComputeTlfFingerprint(salts, 100000)) {
+		t.Fatalf("fingerprint not deterministic")
+	}
+	if bytes.Equal(fingerprint, ComputeTlfFingerprint(salts, 100001)) {
+		t.Fatalf("same fingerprint for different sizes")
+	}
+	if bytes.Equal(fingerprint, ComputeTlfFingerprint(otherSalts, 100000)) {
+		t.Fatalf("same fingerprint for different salts")
+	}
+	if bytes.Equal(fingerprint, ComputeTlfFingerprint(salts[:9], 100000)) {
+		t.Fatalf("same fingerprint for different numbers of salts")
+	}
+}
+
+// TestComputeSealedTlfFingerprint tests the `ComputeSealedTlfFingerprint`
+// function.  Checks that the fingerprint is deterministic, changes with the
+// sealed salts and size, and differs from the one over the salts.
+func TestComputeSealedTlfFingerprint(t *testing.T) {
+	salts, err := GenerateSalts(10, 16)
+	if err != nil {
+		t.Fatalf("error when generating salts: %s", err)
+	}
+	sealed, err := SealSalts(salts, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("error when sealing the salts: %s", err)
+	}
+	resealed, err := SealSalts(salts, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("error when sealing the salts: %s", err)
+	}
+
+	fingerprint := ComputeSealedTlfFingerprint(sealed, 100000)
+	if !bytes.Equal(fingerprint, ComputeSealedTlfFingerprint(sealed, 100000)) {
+		t.Fatalf("fingerprint not deterministic")
+	}
+	if bytes.Equal(fingerprint, ComputeSealedTlfFingerprint(sealed, 100001)) {
+		t.Fatalf("same fingerprint for different sizes")
+	}
+	if bytes.Equal(fingerprint, ComputeSealedTlfFingerprint(resealed, 100000)) {
+		t.Fatalf("same fingerprint for different sealed salts")
+	}
+	if bytes.Equal(fingerprint, ComputeTlfFingerprint(salts, 100000)) {
+		t.Fatalf("same fingerprint over the sealed salts and the salts")
+	}
+}
+
+// TestComputeNumKeys tests the `ComputeNumKeys` function.  Checks that the
+// number of keys is the smallest one reaching the false positive rate.
+func TestComputeNumKeys(t *testing.T) {
+	for fpRate, expected := range map[float64]int{0.5: 1, 0.1: 4, 0.000001: 20} {
+		if actual := ComputeNumKeys(fpRate); actual != expected {
+			t.Fatalf("incorrect number of keys for %f: expected %d actual %d", fpRate, expected, actual)
+		}
+	}
+}
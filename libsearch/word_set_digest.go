@@ -0,0 +1,138 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// wordSetDigestNonceLength is the length of the nonce used to seal a
+// WordSetDigest.
+const wordSetDigestNonceLength = 24
+
+// wordHashKeyDomain separates the key of the word hashes of the digests from
+// the other keys derived from the pathname key.
+const wordHashKeyDomain = "kbfs_search_word_hash"
+
+// wordSetDigestKeyDomain separates the key used to seal the digests from the
+// other keys derived from the pathname key.
+const wordSetDigestKeyDomain = "kbfs_search_word_set_digest"
+
+// deriveDigestKey derives the key of the purpose `domain` from the pathname
+// key `key`, so that the pathnames, the word hashes and the sealed digests are
+// each under a key of their own.
+func deriveDigestKey(key PathnameKeyType, domain string) [32]byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(domain))
+	var derived [32]byte
+	copy(derived[:], mac.Sum(nil))
+	return derived
+}
+
+// WordSetDigest is a compact digest of the set of unique words in a document.
+// Each word is represented by a keyed 64-bit hash, so that the digest reveals
+// nothing about the words without the key, while still allowing two versions
+// of a document to be compared without retokenizing the previous version.
+type WordSetDigest map[uint64]bool
+
+// hashWord computes the keyed 64-bit hash of `word` that is stored in a
+// WordSetDigest, under the key `hashKey` derived from the pathname key.
+func hashWord(hashKey [32]byte, word string) uint64 {
+	mac := hmac.New(sha256.New, hashKey[:])
+	mac.Write([]byte(NormalizeKeyword(word)))
+	return binary.LittleEndian.Uint64(mac.Sum(nil))
+}
+
+// ComputeWordSetDigest computes the WordSetDigest of `words` under `key`.
+func ComputeWordSetDigest(key PathnameKeyType, words []string) WordSetDigest {
+	hashKey := deriveDigestKey(key, wordHashKeyDomain)
+	digest := make(WordSetDigest, len(words))
+	for _, word := range words {
+		digest[hashWord(hashKey, word)] = true
+	}
+	return digest
+}
+
+// Contains returns true if `word` is possibly in the word set represented by
+// the digest under `key`.  False positives happen with a negligible
+// probability due to hash collisions.
+func (d WordSetDigest) Contains(key PathnameKeyType, word string) bool {
+	return d[hashWord(deriveDigestKey(key, wordHashKeyDomain), word)]
+}
+
+// Diff compares the digest with the digest `prev` of a previous version of the
+// same document, and returns the word hashes that have been added and removed
+// since the previous version.
+func (d WordSetDigest) Diff(prev WordSetDigest) (added, removed WordSetDigest) {
+	added = make(WordSetDigest)
+	removed = make(WordSetDigest)
+	for wordHash := range d {
+		if !prev[wordHash] {
+			added[wordHash] = true
+		}
+	}
+	for wordHash := range prev {
+		if !d[wordHash] {
+			removed[wordHash] = true
+		}
+	}
+	return added, removed
+}
+
+// Seal serializes the digest and encrypts it under a key derived from `key`
+// with a random nonce, so that it can be safely persisted on the local disk.
+func (d WordSetDigest) Seal(key PathnameKeyType) ([]byte, error) {
+	wordHashes := make([]uint64, 0, len(d))
+	for wordHash := range d {
+		wordHashes = append(wordHashes, wordHash)
+	}
+	sort.Slice(wordHashes, func(i, j int) bool { return wordHashes[i] < wordHashes[j] })
+
+	plaintext := make([]byte, 8*len(wordHashes))
+	for i, wordHash := range wordHashes {
+		binary.LittleEndian.PutUint64(plaintext[8*i:], wordHash)
+	}
+
+	var nonce [wordSetDigestNonceLength]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	keyBytes := deriveDigestKey(key, wordSetDigestKeyDomain)
+
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &keyBytes), nil
+}
+
+// OpenWordSetDigest decrypts a digest sealed by `WordSetDigest.Seal` with
+// `key`.  Returns an error if the sealed digest is malformed or the key is
+// incorrect.
+func OpenWordSetDigest(sealed []byte, key PathnameKeyType) (WordSetDigest, error) {
+	if len(sealed) < wordSetDigestNonceLength {
+		return nil, errors.New("insufficient sealed digest length")
+	}
+	var nonce [wordSetDigestNonceLength]byte
+	copy(nonce[:], sealed[:wordSetDigestNonceLength])
+	keyBytes := deriveDigestKey(key, wordSetDigestKeyDomain)
+
+	plaintext, ok := secretbox.Open(nil, sealed[wordSetDigestNonceLength:], &nonce, &keyBytes)
+	if !ok {
+		return nil, errors.New("invalid sealed digest")
+	}
+	if len(plaintext)%8 != 0 {
+		return nil, errors.New("invalid digest length")
+	}
+
+	digest := make(WordSetDigest, len(plaintext)/8)
+	for i := 0; i < len(plaintext); i += 8 {
+		digest[binary.LittleEndian.Uint64(plaintext[i:])] = true
+	}
+	return digest, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsearch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// TestWordSetDigest tests the `ComputeWordSetDigest` function and the `Diff`
+// method.  Checks that the digest contains the words and that the added and
+// removed words are correctly computed.
+func TestWordSetDigest(t *testing.T) {
+	var key PathnameKeyType
+	if _, err := rand.Read(key[:]); err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+
+	prev := ComputeWordSetDigest(key, []string{"this", "is", "the", "old", "file"})
+	curr := ComputeWordSetDigest(key, []string{"this", "is", "the", "NEW", "file"})
+
+	for _, word := range []string{"this", "is", "the", "new", "file"} {
+		if !curr.Contains(key, word) {
+			t.Fatalf("word \"%s\" not found in the digest", word)
+		}
+	}
+	if curr.Contains(key, "old") {
+		t.Fatalf("removed word found in the digest")
+	}
+
+	added, removed := curr.Diff(prev)
+	if !reflect.DeepEqual(added, ComputeWordSetDigest(key, []string{"new"})) {
+		t.Fatalf("incorrect added words in the diff")
+	}
+	if !reflect.DeepEqual(removed, ComputeWordSetDigest(key, []string{"old"})) {
+		t.Fatalf("incorrect removed words in the diff")
+	}
+}
+
+// TestSealAndOpenWordSetDigest tests the `Seal` method and the
+// `OpenWordSetDigest` function.  Checks that the original digest is retrieved
+// after sealing and opening, and that opening with a different key fails.
+func TestSealAndOpenWordSetDigest(t *testing.T) {
+	var key1, key2 PathnameKeyType
+	if _, err := rand.Read(key1[:]); err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+	if _, err := rand.Read(key2[:]); err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+
+	digest := ComputeWordSetDigest(key1, []string{"a", "random", "set", "of", "words"})
+	sealed, err := digest.Seal(key1)
+	if err != nil {
+		t.Fatalf("error when sealing the digest: %s", err)
+	}
+
+	opened, err := OpenWordSetDigest(sealed, key1)
+	if err != nil {
+		t.Fatalf("error when opening the digest: %s", err)
+	}
+	if !reflect.DeepEqual(digest, opened) {
+		t.Fatalf("opened digest does not match the original one")
+	}
+
+	if _, err := OpenWordSetDigest(sealed, key2); err == nil {
+		t.Fatalf("sealed digest opened with a different key")
+	}
+	if _, err := OpenWordSetDigest(sealed[:10], key1); err == nil {
+		t.Fatalf("no error returned for a truncated digest")
+	}
+}
+
+// TestWordSetDigestKeys tests that the word hashes and the sealed digests are
+// under keys derived from the pathname key rather than the pathname key
+// itself, which also encrypts the pathnames.
+func TestWordSetDigestKeys(t *testing.T) {
+	var key PathnameKeyType
+	if _, err := rand.Read(key[:]); err != nil {
+		t.Fatalf("error when generating key: %s", err)
+	}
+
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("word"))
+	if ComputeWordSetDigest(key, []string{"word"})[binary.LittleEndian.Uint64(mac.Sum(nil))] {
+		t.Fatalf("word hashed under the pathname key")
+	}
+
+	sealed, err := ComputeWordSetDigest(key, []string{"word"}).Seal(key)
+	if err != nil {
+		t.Fatalf("error when sealing the digest: %s", err)
+	}
+	var nonce [wordSetDigestNonceLength]byte
+	copy(nonce[:], sealed)
+	keyBytes := [32]byte(key)
+	if _, ok := secretbox.Open(nil, sealed[wordSetDigestNonceLength:], &nonce, &keyBytes); ok {
+		t.Fatalf("digest sealed under the pathname key")
+	}
+	if deriveDigestKey(key, wordHashKeyDomain) == deriveDigestKey(key, wordSetDigestKeyDomain) {
+		t.Fatalf("same key derived for the word hashes and the sealing")
+	}
+}
@@ -0,0 +1,291 @@
+// Auto-generated by avdl-compiler v1.3.1 (https://github.com/keybase/node-avdl-compiler)
+//   Input file: searchctl-avdl/searchctl.avdl
+
+package searchctl1
+
+import (
+	rpc "github.com/keybase/go-framed-msgpack-rpc"
+	context "golang.org/x/net/context"
+)
+
+type ScanProgress struct {
+	StartTime  int64 `codec:"startTime" json:"startTime"`
+	Discovered int   `codec:"discovered" json:"discovered"`
+	Processed  int   `codec:"processed" json:"processed"`
+	Bytes      int64 `codec:"bytes" json:"bytes"`
+}
+
+type DirectoryStatus struct {
+	Directory        string        `codec:"directory" json:"directory"`
+	KeyGen           int           `codec:"keyGen" json:"keyGen"`
+	LastScanTime     int64         `codec:"lastScanTime" json:"lastScanTime"`
+	NumIssues        int           `codec:"numIssues" json:"numIssues"`
+	Conflicts        int           `codec:"conflicts" json:"conflicts"`
+	Progress         *ScanProgress `codec:"progress,omitempty" json:"progress,omitempty"`
+	ScanError        string        `codec:"scanError" json:"scanError"`
+	AnalysisMismatch bool          `codec:"analysisMismatch" json:"analysisMismatch"`
+}
+
+type FileIssue struct {
+	Path   string `codec:"path" json:"path"`
+	Reason string `codec:"reason" json:"reason"`
+	Error  string `codec:"error" json:"error"`
+	Time   int64  `codec:"time" json:"time"`
+}
+
+type DaemonStatus struct {
+	StartTime   int64             `codec:"startTime" json:"startTime"`
+	Watching    bool              `codec:"watching" json:"watching"`
+	Directories []DirectoryStatus `codec:"directories" json:"directories"`
+}
+
+type DirectoryStats struct {
+	Directory        string `codec:"directory" json:"directory"`
+	IndexedFiles     int    `codec:"indexedFiles" json:"indexedFiles"`
+	UploadedBytes    int64  `codec:"uploadedBytes" json:"uploadedBytes"`
+	LastScanTime     int64  `codec:"lastScanTime" json:"lastScanTime"`
+	LastScanDuration int64  `codec:"lastScanDuration" json:"lastScanDuration"`
+	PendingFiles     int    `codec:"pendingFiles" json:"pendingFiles"`
+	PendingOps       int    `codec:"pendingOps" json:"pendingOps"`
+}
+
+type ReindexResult struct {
+	Added []string `codec:"added" json:"added"`
+}
+
+type StatusArg struct {
+}
+
+type ListDirsArg struct {
+}
+
+type ReindexArg struct {
+	Directory string `codec:"directory" json:"directory"`
+}
+
+type SearchArg struct {
+	Query string `codec:"query" json:"query"`
+}
+
+type ListIssuesArg struct {
+	Directory string `codec:"directory" json:"directory"`
+}
+
+type AddDirArg struct {
+	Directory string `codec:"directory" json:"directory"`
+}
+
+type RemoveDirArg struct {
+	Directory string `codec:"directory" json:"directory"`
+}
+
+type StatsArg struct {
+}
+
+type ResetDirArg struct {
+	Directory   string `codec:"directory" json:"directory"`
+	DropIndexes bool   `codec:"dropIndexes" json:"dropIndexes"`
+}
+
+type ControlInterface interface {
+	Status(context.Context) (DaemonStatus, error)
+	ListDirs(context.Context) ([]string, error)
+	Reindex(context.Context, string) (ReindexResult, error)
+	Search(context.Context, string) ([]string, error)
+	ListIssues(context.Context, string) ([]FileIssue, error)
+	AddDir(context.Context, string) error
+	RemoveDir(context.Context, string) error
+	Stats(context.Context) ([]DirectoryStats, error)
+	ResetDir(context.Context, ResetDirArg) error
+}
+
+func ControlProtocol(i ControlInterface) rpc.Protocol {
+	return rpc.Protocol{
+		Name: "searchctl.1.control",
+		Methods: map[string]rpc.ServeHandlerDescription{
+			"status": {
+				MakeArg: func() interface{} {
+					ret := make([]StatusArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					ret, err = i.Status(ctx)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"listDirs": {
+				MakeArg: func() interface{} {
+					ret := make([]ListDirsArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					ret, err = i.ListDirs(ctx)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"reindex": {
+				MakeArg: func() interface{} {
+					ret := make([]ReindexArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]ReindexArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]ReindexArg)(nil), args)
+						return
+					}
+					ret, err = i.Reindex(ctx, (*typedArgs)[0].Directory)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"search": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchArg)(nil), args)
+						return
+					}
+					ret, err = i.Search(ctx, (*typedArgs)[0].Query)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"listIssues": {
+				MakeArg: func() interface{} {
+					ret := make([]ListIssuesArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]ListIssuesArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]ListIssuesArg)(nil), args)
+						return
+					}
+					ret, err = i.ListIssues(ctx, (*typedArgs)[0].Directory)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"addDir": {
+				MakeArg: func() interface{} {
+					ret := make([]AddDirArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]AddDirArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]AddDirArg)(nil), args)
+						return
+					}
+					err = i.AddDir(ctx, (*typedArgs)[0].Directory)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"removeDir": {
+				MakeArg: func() interface{} {
+					ret := make([]RemoveDirArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]RemoveDirArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]RemoveDirArg)(nil), args)
+						return
+					}
+					err = i.RemoveDir(ctx, (*typedArgs)[0].Directory)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"stats": {
+				MakeArg: func() interface{} {
+					ret := make([]StatsArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					ret, err = i.Stats(ctx)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"resetDir": {
+				MakeArg: func() interface{} {
+					ret := make([]ResetDirArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]ResetDirArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]ResetDirArg)(nil), args)
+						return
+					}
+					err = i.ResetDir(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+		},
+	}
+}
+
+type ControlClient struct {
+	Cli rpc.GenericClient
+}
+
+func (c ControlClient) Status(ctx context.Context) (res DaemonStatus, err error) {
+	err = c.Cli.Call(ctx, "searchctl.1.control.status", []interface{}{StatusArg{}}, &res)
+	return
+}
+
+func (c ControlClient) ListDirs(ctx context.Context) (res []string, err error) {
+	err = c.Cli.Call(ctx, "searchctl.1.control.listDirs", []interface{}{ListDirsArg{}}, &res)
+	return
+}
+
+func (c ControlClient) Reindex(ctx context.Context, directory string) (res ReindexResult, err error) {
+	__arg := ReindexArg{Directory: directory}
+	err = c.Cli.Call(ctx, "searchctl.1.control.reindex", []interface{}{__arg}, &res)
+	return
+}
+
+func (c ControlClient) Search(ctx context.Context, query string) (res []string, err error) {
+	__arg := SearchArg{Query: query}
+	err = c.Cli.Call(ctx, "searchctl.1.control.search", []interface{}{__arg}, &res)
+	return
+}
+
+func (c ControlClient) ListIssues(ctx context.Context, directory string) (res []FileIssue, err error) {
+	__arg := ListIssuesArg{Directory: directory}
+	err = c.Cli.Call(ctx, "searchctl.1.control.listIssues", []interface{}{__arg}, &res)
+	return
+}
+
+func (c ControlClient) AddDir(ctx context.Context, directory string) (err error) {
+	__arg := AddDirArg{Directory: directory}
+	err = c.Cli.Call(ctx, "searchctl.1.control.addDir", []interface{}{__arg}, nil)
+	return
+}
+
+func (c ControlClient) RemoveDir(ctx context.Context, directory string) (err error) {
+	__arg := RemoveDirArg{Directory: directory}
+	err = c.Cli.Call(ctx, "searchctl.1.control.removeDir", []interface{}{__arg}, nil)
+	return
+}
+
+func (c ControlClient) Stats(ctx context.Context) (res []DirectoryStats, err error) {
+	err = c.Cli.Call(ctx, "searchctl.1.control.stats", []interface{}{StatsArg{}}, &res)
+	return
+}
+
+func (c ControlClient) ResetDir(ctx context.Context, __arg ResetDirArg) (err error) {
+	err = c.Cli.Call(ctx, "searchctl.1.control.resetDir", []interface{}{__arg}, nil)
+	return
+}
@@ -10,19 +10,122 @@ import (
 
 type DocumentID string
 type FolderID string
+type IndexType int
+
+const (
+	IndexType_BLOOM  IndexType = 0
+	IndexType_CUCKOO IndexType = 1
+)
+
+var IndexTypeMap = map[string]IndexType{
+	"BLOOM":  0,
+	"CUCKOO": 1,
+}
+
+var IndexTypeRevMap = map[IndexType]string{
+	0: "BLOOM",
+	1: "CUCKOO",
+}
+
 type TlfInfo struct {
-	Salts [][]byte `codec:"salts" json:"salts"`
-	Size  int64    `codec:"size" json:"size"`
+	Salts               [][]byte  `codec:"salts" json:"salts"`
+	Size                int64     `codec:"size" json:"size"`
+	Fingerprint         []byte    `codec:"fingerprint" json:"fingerprint"`
+	EncryptedSalts      []byte    `codec:"encryptedSalts" json:"encryptedSalts"`
+	IndexType           IndexType `codec:"indexType" json:"indexType"`
+	AnalysisFingerprint []byte    `codec:"analysisFingerprint" json:"analysisFingerprint"`
+	CodewordMapping     int       `codec:"codewordMapping" json:"codewordMapping"`
+}
+
+type DocumentInfo struct {
+	DocID         DocumentID `codec:"docID" json:"docID"`
+	IndexSize     int64      `codec:"indexSize" json:"indexSize"`
+	LastWriteTime int64      `codec:"lastWriteTime" json:"lastWriteTime"`
+	KeyGen        int        `codec:"keyGen" json:"keyGen"`
+	Revision      int64      `codec:"revision" json:"revision"`
+}
+
+type WriteResult struct {
+	Revision int64 `codec:"revision" json:"revision"`
+	Conflict bool  `codec:"conflict" json:"conflict"`
 }
 
 type Trapdoor struct {
 	Codeword [][]byte `codec:"codeword" json:"codeword"`
 }
 
+type ChangeType int
+
+const (
+	ChangeType_WRITE  ChangeType = 0
+	ChangeType_RENAME ChangeType = 1
+	ChangeType_DELETE ChangeType = 2
+)
+
+var ChangeTypeMap = map[string]ChangeType{
+	"WRITE":  0,
+	"RENAME": 1,
+	"DELETE": 2,
+}
+
+var ChangeTypeRevMap = map[ChangeType]string{
+	0: "WRITE",
+	1: "RENAME",
+	2: "DELETE",
+}
+
+type Change struct {
+	Seq       int64      `codec:"seq" json:"seq"`
+	Type      ChangeType `codec:"type" json:"type"`
+	DocID     DocumentID `codec:"docID" json:"docID"`
+	OrigDocID DocumentID `codec:"origDocID" json:"origDocID"`
+}
+
+type ChangeSet struct {
+	Changes   []Change `codec:"changes" json:"changes"`
+	LatestSeq int64    `codec:"latestSeq" json:"latestSeq"`
+	Truncated bool     `codec:"truncated" json:"truncated"`
+}
+
+type TlfIndexStats struct {
+	NumIndexes         int     `codec:"numIndexes" json:"numIndexes"`
+	NumOverfilled      int     `codec:"numOverfilled" json:"numOverfilled"`
+	MeanFillRatio      float64 `codec:"meanFillRatio" json:"meanFillRatio"`
+	MaxFillRatio       float64 `codec:"maxFillRatio" json:"maxFillRatio"`
+	FillRatioHistogram []int   `codec:"fillRatioHistogram" json:"fillRatioHistogram"`
+	TotalBytes         int64   `codec:"totalBytes" json:"totalBytes"`
+	MinBytes           int64   `codec:"minBytes" json:"minBytes"`
+	MedianBytes        int64   `codec:"medianBytes" json:"medianBytes"`
+	P90Bytes           int64   `codec:"p90Bytes" json:"p90Bytes"`
+	MaxBytes           int64   `codec:"maxBytes" json:"maxBytes"`
+}
+
+type SearchPage struct {
+	DocIDs []DocumentID `codec:"docIDs" json:"docIDs"`
+	Next   DocumentID   `codec:"next" json:"next"`
+}
+
+type TlfSummary struct {
+	Filter   []byte `codec:"filter" json:"filter"`
+	Complete bool   `codec:"complete" json:"complete"`
+}
+
+type BackfillGrant struct {
+	WritesPerSec int   `codec:"writesPerSec" json:"writesPerSec"`
+	Ttl          int64 `codec:"ttl" json:"ttl"`
+}
+
+type IndexWrite struct {
+	SecureIndex  []byte     `codec:"secureIndex" json:"secureIndex"`
+	DocID        DocumentID `codec:"docID" json:"docID"`
+	BaseRevision int64      `codec:"baseRevision" json:"baseRevision"`
+}
+
 type WriteIndexArg struct {
-	TlfID       FolderID   `codec:"tlfID" json:"tlfID"`
-	SecureIndex []byte     `codec:"secureIndex" json:"secureIndex"`
-	DocID       DocumentID `codec:"docID" json:"docID"`
+	TlfID        FolderID   `codec:"tlfID" json:"tlfID"`
+	SecureIndex  []byte     `codec:"secureIndex" json:"secureIndex"`
+	DocID        DocumentID `codec:"docID" json:"docID"`
+	BaseRevision int64      `codec:"baseRevision" json:"baseRevision"`
 }
 
 type RenameIndexArg struct {
@@ -41,24 +144,113 @@ type GetKeyGensArg struct {
 }
 
 type SearchWordArg struct {
-	TlfID     FolderID            `codec:"tlfID" json:"tlfID"`
-	Trapdoors map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	TlfID            FolderID            `codec:"tlfID" json:"tlfID"`
+	Trapdoors        map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	ResultBucketSize int                 `codec:"resultBucketSize" json:"resultBucketSize"`
+}
+
+type SearchWordsArg struct {
+	TlfID            FolderID              `codec:"tlfID" json:"tlfID"`
+	Trapdoors        []map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	ResultBucketSize int                   `codec:"resultBucketSize" json:"resultBucketSize"`
+}
+
+type SearchConjunctionArg struct {
+	TlfID            FolderID              `codec:"tlfID" json:"tlfID"`
+	Trapdoors        []map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	ResultBucketSize int                   `codec:"resultBucketSize" json:"resultBucketSize"`
+}
+
+type GetDocumentInfoArg struct {
+	TlfID  FolderID     `codec:"tlfID" json:"tlfID"`
+	DocIDs []DocumentID `codec:"docIDs" json:"docIDs"`
+}
+
+type GetChangesArg struct {
+	TlfID    FolderID `codec:"tlfID" json:"tlfID"`
+	SinceSeq int64    `codec:"sinceSeq" json:"sinceSeq"`
+}
+
+type ClaimIndexArg struct {
+	TlfID    FolderID   `codec:"tlfID" json:"tlfID"`
+	DocID    DocumentID `codec:"docID" json:"docID"`
+	Claimant string     `codec:"claimant" json:"claimant"`
+	Ttl      int64      `codec:"ttl" json:"ttl"`
 }
 
 type RegisterTlfIfNotExistsArg struct {
-	TlfID        FolderID `codec:"tlfID" json:"tlfID"`
-	LenSalt      int      `codec:"lenSalt" json:"lenSalt"`
-	FpRate       float64  `codec:"fpRate" json:"fpRate"`
-	NumUniqWords int64    `codec:"numUniqWords" json:"numUniqWords"`
+	TlfID               FolderID  `codec:"tlfID" json:"tlfID"`
+	LenSalt             int       `codec:"lenSalt" json:"lenSalt"`
+	FpRate              float64   `codec:"fpRate" json:"fpRate"`
+	NumUniqWords        int64     `codec:"numUniqWords" json:"numUniqWords"`
+	EncryptedSalts      []byte    `codec:"encryptedSalts" json:"encryptedSalts"`
+	IndexType           IndexType `codec:"indexType" json:"indexType"`
+	AnalysisFingerprint []byte    `codec:"analysisFingerprint" json:"analysisFingerprint"`
+	CodewordMapping     int       `codec:"codewordMapping" json:"codewordMapping"`
+}
+
+type GetIndexStatsArg struct {
+	TlfID FolderID `codec:"tlfID" json:"tlfID"`
+}
+
+type SearchWordPageArg struct {
+	TlfID            FolderID            `codec:"tlfID" json:"tlfID"`
+	Trapdoors        map[string]Trapdoor `codec:"trapdoors" json:"trapdoors"`
+	ResultBucketSize int                 `codec:"resultBucketSize" json:"resultBucketSize"`
+	After            DocumentID          `codec:"after" json:"after"`
+	PageSize         int                 `codec:"pageSize" json:"pageSize"`
+}
+
+type MergeTlfSummaryArg struct {
+	TlfID  FolderID   `codec:"tlfID" json:"tlfID"`
+	DocID  DocumentID `codec:"docID" json:"docID"`
+	Filter []byte     `codec:"filter" json:"filter"`
+}
+
+type GetTlfSummaryArg struct {
+	TlfID FolderID `codec:"tlfID" json:"tlfID"`
+}
+
+type DropTlfArg struct {
+	TlfID FolderID `codec:"tlfID" json:"tlfID"`
+}
+
+type BeginBackfillArg struct {
+	TlfID    FolderID `codec:"tlfID" json:"tlfID"`
+	Claimant string   `codec:"claimant" json:"claimant"`
+	NumFiles int      `codec:"numFiles" json:"numFiles"`
+}
+
+type EndBackfillArg struct {
+	TlfID    FolderID `codec:"tlfID" json:"tlfID"`
+	Claimant string   `codec:"claimant" json:"claimant"`
+}
+
+type WriteIndexesArg struct {
+	TlfID  FolderID     `codec:"tlfID" json:"tlfID"`
+	Writes []IndexWrite `codec:"writes" json:"writes"`
 }
 
 type SearchServerInterface interface {
-	WriteIndex(context.Context, WriteIndexArg) error
+	WriteIndex(context.Context, WriteIndexArg) (WriteResult, error)
 	RenameIndex(context.Context, RenameIndexArg) error
 	DeleteIndex(context.Context, DeleteIndexArg) error
 	GetKeyGens(context.Context, FolderID) ([]int, error)
 	SearchWord(context.Context, SearchWordArg) ([]DocumentID, error)
+	SearchWords(context.Context, SearchWordsArg) ([][]DocumentID, error)
+	SearchConjunction(context.Context, SearchConjunctionArg) ([]DocumentID, error)
+	GetDocumentInfo(context.Context, GetDocumentInfoArg) ([]DocumentInfo, error)
+	GetChanges(context.Context, GetChangesArg) (ChangeSet, error)
+	ClaimIndex(context.Context, ClaimIndexArg) (bool, error)
 	RegisterTlfIfNotExists(context.Context, RegisterTlfIfNotExistsArg) (TlfInfo, error)
+	GetIndexStats(context.Context, FolderID) (TlfIndexStats, error)
+	SearchWordPage(context.Context, SearchWordPageArg) (SearchPage, error)
+	MergeTlfSummary(context.Context, MergeTlfSummaryArg) error
+	GetTlfSummary(context.Context, FolderID) (TlfSummary, error)
+	DropTlf(context.Context, FolderID) error
+	BeginBackfill(context.Context, BeginBackfillArg) (BackfillGrant, error)
+	EndBackfill(context.Context, EndBackfillArg) error
+	WriteIndexes(context.Context, WriteIndexesArg) ([]WriteResult, error)
 }
 
 func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
@@ -76,7 +268,7 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 						err = rpc.NewTypeError((*[]WriteIndexArg)(nil), args)
 						return
 					}
-					err = i.WriteIndex(ctx, (*typedArgs)[0])
+					ret, err = i.WriteIndex(ctx, (*typedArgs)[0])
 					return
 				},
 				MethodType: rpc.MethodCall,
@@ -145,6 +337,86 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 				},
 				MethodType: rpc.MethodCall,
 			},
+			"searchWords": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchWordsArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchWordsArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchWordsArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchWords(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"searchConjunction": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchConjunctionArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchConjunctionArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchConjunctionArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchConjunction(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"getDocumentInfo": {
+				MakeArg: func() interface{} {
+					ret := make([]GetDocumentInfoArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]GetDocumentInfoArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]GetDocumentInfoArg)(nil), args)
+						return
+					}
+					ret, err = i.GetDocumentInfo(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"getChanges": {
+				MakeArg: func() interface{} {
+					ret := make([]GetChangesArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]GetChangesArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]GetChangesArg)(nil), args)
+						return
+					}
+					ret, err = i.GetChanges(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"claimIndex": {
+				MakeArg: func() interface{} {
+					ret := make([]ClaimIndexArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]ClaimIndexArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]ClaimIndexArg)(nil), args)
+						return
+					}
+					ret, err = i.ClaimIndex(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
 			"registerTlfIfNotExists": {
 				MakeArg: func() interface{} {
 					ret := make([]RegisterTlfIfNotExistsArg, 1)
@@ -161,6 +433,134 @@ func SearchServerProtocol(i SearchServerInterface) rpc.Protocol {
 				},
 				MethodType: rpc.MethodCall,
 			},
+			"getIndexStats": {
+				MakeArg: func() interface{} {
+					ret := make([]GetIndexStatsArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]GetIndexStatsArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]GetIndexStatsArg)(nil), args)
+						return
+					}
+					ret, err = i.GetIndexStats(ctx, (*typedArgs)[0].TlfID)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"searchWordPage": {
+				MakeArg: func() interface{} {
+					ret := make([]SearchWordPageArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]SearchWordPageArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]SearchWordPageArg)(nil), args)
+						return
+					}
+					ret, err = i.SearchWordPage(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"mergeTlfSummary": {
+				MakeArg: func() interface{} {
+					ret := make([]MergeTlfSummaryArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]MergeTlfSummaryArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]MergeTlfSummaryArg)(nil), args)
+						return
+					}
+					err = i.MergeTlfSummary(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"getTlfSummary": {
+				MakeArg: func() interface{} {
+					ret := make([]GetTlfSummaryArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]GetTlfSummaryArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]GetTlfSummaryArg)(nil), args)
+						return
+					}
+					ret, err = i.GetTlfSummary(ctx, (*typedArgs)[0].TlfID)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"dropTlf": {
+				MakeArg: func() interface{} {
+					ret := make([]DropTlfArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]DropTlfArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]DropTlfArg)(nil), args)
+						return
+					}
+					err = i.DropTlf(ctx, (*typedArgs)[0].TlfID)
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"beginBackfill": {
+				MakeArg: func() interface{} {
+					ret := make([]BeginBackfillArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]BeginBackfillArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]BeginBackfillArg)(nil), args)
+						return
+					}
+					ret, err = i.BeginBackfill(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"endBackfill": {
+				MakeArg: func() interface{} {
+					ret := make([]EndBackfillArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]EndBackfillArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]EndBackfillArg)(nil), args)
+						return
+					}
+					err = i.EndBackfill(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+			"writeIndexes": {
+				MakeArg: func() interface{} {
+					ret := make([]WriteIndexesArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]WriteIndexesArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]WriteIndexesArg)(nil), args)
+						return
+					}
+					ret, err = i.WriteIndexes(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
 		},
 	}
 }
@@ -169,8 +569,8 @@ type SearchServerClient struct {
 	Cli rpc.GenericClient
 }
 
-func (c SearchServerClient) WriteIndex(ctx context.Context, __arg WriteIndexArg) (err error) {
-	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.writeIndex", []interface{}{__arg}, nil)
+func (c SearchServerClient) WriteIndex(ctx context.Context, __arg WriteIndexArg) (res WriteResult, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.writeIndex", []interface{}{__arg}, &res)
 	return
 }
 
@@ -195,7 +595,75 @@ func (c SearchServerClient) SearchWord(ctx context.Context, __arg SearchWordArg)
 	return
 }
 
+func (c SearchServerClient) SearchWords(ctx context.Context, __arg SearchWordsArg) (res [][]DocumentID, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchWords", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) SearchConjunction(ctx context.Context, __arg SearchConjunctionArg) (res []DocumentID, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchConjunction", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) GetDocumentInfo(ctx context.Context, __arg GetDocumentInfoArg) (res []DocumentInfo, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.getDocumentInfo", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) GetChanges(ctx context.Context, __arg GetChangesArg) (res ChangeSet, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.getChanges", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) ClaimIndex(ctx context.Context, __arg ClaimIndexArg) (res bool, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.claimIndex", []interface{}{__arg}, &res)
+	return
+}
+
 func (c SearchServerClient) RegisterTlfIfNotExists(ctx context.Context, __arg RegisterTlfIfNotExistsArg) (res TlfInfo, err error) {
 	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.registerTlfIfNotExists", []interface{}{__arg}, &res)
 	return
 }
+
+func (c SearchServerClient) GetIndexStats(ctx context.Context, tlfID FolderID) (res TlfIndexStats, err error) {
+	__arg := GetIndexStatsArg{TlfID: tlfID}
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.getIndexStats", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) SearchWordPage(ctx context.Context, __arg SearchWordPageArg) (res SearchPage, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.searchWordPage", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) MergeTlfSummary(ctx context.Context, __arg MergeTlfSummaryArg) (err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.mergeTlfSummary", []interface{}{__arg}, nil)
+	return
+}
+
+func (c SearchServerClient) GetTlfSummary(ctx context.Context, tlfID FolderID) (res TlfSummary, err error) {
+	__arg := GetTlfSummaryArg{TlfID: tlfID}
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.getTlfSummary", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) DropTlf(ctx context.Context, tlfID FolderID) (err error) {
+	__arg := DropTlfArg{TlfID: tlfID}
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.dropTlf", []interface{}{__arg}, nil)
+	return
+}
+
+func (c SearchServerClient) BeginBackfill(ctx context.Context, __arg BeginBackfillArg) (res BackfillGrant, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.beginBackfill", []interface{}{__arg}, &res)
+	return
+}
+
+func (c SearchServerClient) EndBackfill(ctx context.Context, __arg EndBackfillArg) (err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.endBackfill", []interface{}{__arg}, nil)
+	return
+}
+
+func (c SearchServerClient) WriteIndexes(ctx context.Context, __arg WriteIndexesArg) (res []WriteResult, err error) {
+	err = c.Cli.Call(ctx, "searchsrv.1.searchServer.writeIndexes", []interface{}{__arg}, &res)
+	return
+}
@@ -5,7 +5,11 @@
 package index
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
 	"search/prototype/util"
 	"testing"
 
@@ -46,3 +50,49 @@ func TestMarshalAndUnmarshal(t *testing.T) {
 		t.Fatalf("BloomFilter does not mtach")
 	}
 }
+
+// updateTestVectors regenerates the golden files under `testdata` instead of
+// checking against them.
+var updateTestVectors = flag.Bool("update", false, "regenerate the golden test vectors under testdata")
+
+// TestVectorSecureIndex checks that a SecureIndex with fixed content serializes
+// to the golden bytes, and that the golden bytes can still be parsed.
+func TestVectorSecureIndex(t *testing.T) {
+	si := new(SecureIndex)
+	si.BloomFilter = bitarray.NewSparseBitArray()
+	for i := uint64(0); i < 1000; i++ {
+		si.BloomFilter.SetBit(i * i % 1900000)
+	}
+	si.DocID = 42
+	si.Size = uint64(1900000)
+	si.Hash = sha256.New
+	actual, err := si.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error when marshaling the index")
+	}
+
+	golden := filepath.Join("testdata", "secure_index.golden")
+	if *updateTestVectors {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("error when writing the golden file: %s", err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error when reading the golden file: %s", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("output does not match the golden file; the format has changed in an incompatible way")
+	}
+
+	parsed := new(SecureIndex)
+	if err := parsed.UnmarshalBinary(expected); err != nil {
+		t.Fatalf("error when unmarshaling the golden index: %s", err)
+	}
+	if parsed.DocID != si.DocID || parsed.Size != si.Size || parsed.Hash().Size() != si.Hash().Size() {
+		t.Fatalf("incorrect parameters parsed from the golden index")
+	}
+	if !parsed.BloomFilter.Equals(si.BloomFilter) {
+		t.Fatalf("BloomFilter does not match the golden one")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/sha256"
+	"flag"
+	"os"
+	"search/prototype/indexer"
+	"strconv"
+	"testing"
+)
+
+var benchNumIndexes = flag.Int("bench_indexes", 1000, "the number of indexes the server is populated with in the benchmarks")
+
+// benchDocument returns the content of the `i`-th document of the benchmarks.
+func benchDocument(i int) string {
+	return "common benchmark document number" + strconv.Itoa(i)
+}
+
+// BenchmarkWriteIndex measures the write throughput of the indexes to the
+// disk of the server.
+func BenchmarkWriteIndex(b *testing.B) {
+	s, dir := createTestServer(1, 8, 8, 0.000001, uint64(1000))
+	defer os.RemoveAll(dir)
+	sib := indexer.CreateSecureIndexBuilder(sha256.New, calculateMasterSecret(0, s.keyHalves[0]), s.salts, s.size)
+	si := buildIndexForFile(sib, benchDocument(0), 0)
+	output, err := si.MarshalBinary()
+	if err != nil {
+		b.Fatalf("Error when marshaling the index: %s", err)
+	}
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		si.DocID = i
+		if err := s.WriteIndex(si); err != nil {
+			b.Fatalf("Error when writing the index: %s", err)
+		}
+	}
+}
+
+// BenchmarkSearchWord measures the latency of a search scanning all the
+// indexes on the disk of the server, populated with `-bench_indexes` indexes.
+func BenchmarkSearchWord(b *testing.B) {
+	s, dir := createTestServer(1, 8, 8, 0.000001, uint64(1000))
+	defer os.RemoveAll(dir)
+	sib := indexer.CreateSecureIndexBuilder(sha256.New, calculateMasterSecret(0, s.keyHalves[0]), s.salts, s.size)
+	for i := 0; i < *benchNumIndexes; i++ {
+		docID, err := s.AddFile([]byte(benchDocument(i)))
+		if err != nil {
+			b.Fatalf("Error when adding the file: %s", err)
+		}
+		if err := s.WriteIndex(buildIndexForFile(sib, benchDocument(i), docID)); err != nil {
+			b.Fatalf("Error when writing the index: %s", err)
+		}
+	}
+	trapdoors := sib.ComputeTrapdoors("common")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if result := s.SearchWord(trapdoors); len(result) != *benchNumIndexes {
+			b.Fatalf("incorrect number of results: expected %d actual %d", *benchNumIndexes, len(result))
+		}
+	}
+}